@@ -0,0 +1,631 @@
+package main
+
+import (
+	"cmp"
+	"container/heap"
+	"context"
+	"database/sql"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"math"
+	"math/rand/v2"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Shape of the nearest neighbor graph (HNSW: hierarchical navigable small
+// world). Each node links to its closest vectors on every level it is on;
+// few nodes reach the upper levels, which a search crosses in long hops
+// before walking the bottom level, where every node is.
+const (
+	annM              = 16   // Links per node above the bottom level
+	annM0             = 32   // Links per node on the bottom level
+	annEfConstruction = 100  // Candidates weighed when linking a new node
+	annEfSearch       = 64   // Fewest candidates kept while searching
+	annMinVectors     = 5000 // Below this many vectors a full scan is quick enough
+	annMaxStale       = 10   // Rebuild once vectors stored since pass 1/annMaxStale of the graph
+)
+
+// ANNIndex describes the stored graph of a model's vectors. Vectors stored
+// since it was built aren't in it and are compared one by one.
+type ANNIndex struct {
+	Model  string  `json:"model"`
+	Entry  int64   `json:"entry"` // Node on the top level, where searches start
+	Levels int     `json:"levels"`
+	Nodes  int     `json:"nodes"`
+	Built  float64 `json:"built"` // Vectors created from then on aren't in the graph
+}
+
+// annKey is the meta key describing the graph of model
+func annKey(model string) string {
+	return "ann_index:" + model
+}
+
+// GetANNIndex returns the graph stored for model, or nil if there is none
+func GetANNIndex(ctx context.Context, db *sql.DB, model string) (*ANNIndex, error) {
+	var value string
+	err := db.QueryRowContext(ctx, `SELECT value FROM meta WHERE key = ?`, annKey(model)).Scan(&value)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read vector index: %w", err)
+	}
+	var idx ANNIndex
+	if err := json.Unmarshal([]byte(value), &idx); err != nil {
+		return nil, fmt.Errorf("failed to parse vector index: %w", err)
+	}
+	return &idx, nil
+}
+
+// CountUnindexed returns how many vectors from model were stored since idx
+// was built, or all of them when there is no index
+func CountUnindexed(ctx context.Context, db *sql.DB, model string, idx *ANNIndex) (int, error) {
+	built := 0.0
+	if idx != nil {
+		built = idx.Built
+	}
+	var n int
+	if err := db.QueryRowContext(ctx, `SELECT COUNT(*) FROM embeddings WHERE model = ? AND created_at >= ?`, model, built).Scan(&n); err != nil {
+		return 0, fmt.Errorf("failed to count vectors: %w", err)
+	}
+	return n, nil
+}
+
+// NeedsANNIndex reports whether the vectors from model are many enough for
+// a graph to pay off and the stored one is missing or lags too far behind
+func NeedsANNIndex(ctx context.Context, db *sql.DB, model string) (bool, error) {
+	total, err := CountEmbeddings(ctx, db, model)
+	if err != nil || total < annMinVectors {
+		return false, err
+	}
+	idx, err := GetANNIndex(ctx, db, model)
+	if err != nil || idx == nil {
+		return idx == nil, err
+	}
+	unindexed, err := CountUnindexed(ctx, db, model, idx)
+	if err != nil {
+		return false, err
+	}
+	return unindexed*annMaxStale > idx.Nodes, nil
+}
+
+// IndexEmbeddings links every vector from model into a new graph and stores
+// it in place of the last one. Linking takes a while for large histories,
+// so lock is only held while the graph is written.
+func IndexEmbeddings(ctx context.Context, db *sql.DB, model string, lock func(ctx context.Context) (func(), error)) (ANNIndex, error) {
+	idx := ANNIndex{Model: model, Built: float64(time.Now().Unix())}
+
+	rows, err := db.QueryContext(ctx, `SELECT rowid, vector FROM embeddings WHERE model = ? ORDER BY rowid`, model)
+	if err != nil {
+		return idx, fmt.Errorf("failed to read embeddings: %w", err)
+	}
+	b := newANNBuilder()
+	var ids []int64
+	var vectors [][]float32
+	for rows.Next() {
+		var id int64
+		var vector []byte
+		if err := rows.Scan(&id, &vector); err != nil {
+			rows.Close()
+			return idx, fmt.Errorf("failed to scan embedding: %w", err)
+		}
+		ids = append(ids, id)
+		vectors = append(vectors, decodeVector(vector))
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return idx, fmt.Errorf("error iterating embeddings: %w", err)
+	}
+	if len(ids) == 0 {
+		return idx, fmt.Errorf("no commands embedded with %s yet", model)
+	}
+
+	for i, id := range ids {
+		if i%1000 == 0 {
+			if err := ctx.Err(); err != nil {
+				return idx, err
+			}
+		}
+		b.add(id, vectors[i])
+	}
+	idx.Entry = b.ids[b.entry]
+	idx.Levels = b.top + 1
+	idx.Nodes = len(b.ids)
+
+	if lock != nil {
+		release, err := lock(ctx)
+		if err != nil {
+			return idx, err
+		}
+		defer release()
+	}
+	return idx, storeANNIndex(ctx, db, b, idx)
+}
+
+// storeANNIndex replaces the graph of idx.Model with the one b linked
+func storeANNIndex(ctx context.Context, db *sql.DB, b *annBuilder, idx ANNIndex) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM ann_graph WHERE model = ?`, idx.Model); err != nil {
+		return fmt.Errorf("failed to clear vector index: %w", err)
+	}
+	stmt, err := tx.PrepareContext(ctx, `INSERT INTO ann_graph (model, node, level, neighbors) VALUES (?, ?, ?, ?)`)
+	if err != nil {
+		return fmt.Errorf("failed to prepare statement: %w", err)
+	}
+	defer stmt.Close()
+	for node, levels := range b.links {
+		for level, links := range levels {
+			neighbors := make([]byte, 8*len(links))
+			for i, n := range links {
+				binary.LittleEndian.PutUint64(neighbors[8*i:], uint64(b.ids[n]))
+			}
+			if _, err := stmt.ExecContext(ctx, idx.Model, b.ids[node], level, neighbors); err != nil {
+				return fmt.Errorf("failed to store vector index: %w", err)
+			}
+		}
+	}
+
+	value, err := json.Marshal(idx)
+	if err != nil {
+		return fmt.Errorf("failed to encode vector index: %w", err)
+	}
+	if _, err := tx.ExecContext(ctx, `INSERT INTO meta (key, value) VALUES (?, ?)
+		ON CONFLICT(key) DO UPDATE SET value = excluded.value`, annKey(idx.Model), string(value)); err != nil {
+		return fmt.Errorf("failed to save vector index: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit vector index: %w", err)
+	}
+	return nil
+}
+
+// annCandidate is a node and its similarity to the vector searched for
+type annCandidate struct {
+	id  int64
+	sim float32
+}
+
+// compareCandidates orders candidates closest first
+func compareCandidates(a, b annCandidate) int {
+	return cmp.Or(cmp.Compare(b.sim, a.sim), cmp.Compare(a.id, b.id))
+}
+
+// annQueue is a heap of candidates, the closest on top when near is set and
+// the farthest otherwise
+type annQueue struct {
+	items []annCandidate
+	near  bool
+}
+
+func (q annQueue) Len() int { return len(q.items) }
+func (q annQueue) Less(i, j int) bool {
+	if q.near {
+		return q.items[i].sim > q.items[j].sim
+	}
+	return q.items[i].sim < q.items[j].sim
+}
+func (q annQueue) Swap(i, j int) { q.items[i], q.items[j] = q.items[j], q.items[i] }
+func (q *annQueue) Push(x any)   { q.items = append(q.items, x.(annCandidate)) }
+func (q *annQueue) Pop() any {
+	last := q.items[len(q.items)-1]
+	q.items = q.items[:len(q.items)-1]
+	return last
+}
+
+// annGraph is a graph being linked in memory or one stored in the database
+type annGraph interface {
+	load(ids []int64) ([][]float32, error) // The vectors of ids, nil for any gone
+	neighbors(id int64, level int) ([]int64, error)
+}
+
+// searchLayer walks level of g from entries towards query and returns the
+// ef closest nodes it met, closest first. visited marks a node seen and
+// reports whether it already was.
+func searchLayer(g annGraph, query []float32, entries []annCandidate, ef, level int, visited func(id int64) bool) ([]annCandidate, error) {
+	candidates := &annQueue{near: true}
+	found := &annQueue{}
+	for _, e := range entries {
+		visited(e.id)
+		heap.Push(candidates, e)
+		heap.Push(found, e)
+	}
+	for found.Len() > ef {
+		heap.Pop(found)
+	}
+
+	for candidates.Len() > 0 {
+		c := heap.Pop(candidates).(annCandidate)
+		if found.Len() >= ef && c.sim < found.items[0].sim {
+			break
+		}
+		neighbors, err := g.neighbors(c.id, level)
+		if err != nil {
+			return nil, err
+		}
+		var unseen []int64
+		for _, n := range neighbors {
+			if !visited(n) {
+				unseen = append(unseen, n)
+			}
+		}
+		vectors, err := g.load(unseen)
+		if err != nil {
+			return nil, err
+		}
+		for i, v := range vectors {
+			if v == nil {
+				continue
+			}
+			sim := dotProduct(query, v)
+			if found.Len() < ef || sim > found.items[0].sim {
+				heap.Push(candidates, annCandidate{unseen[i], sim})
+				heap.Push(found, annCandidate{unseen[i], sim})
+				if found.Len() > ef {
+					heap.Pop(found)
+				}
+			}
+		}
+	}
+
+	closest := found.items
+	slices.SortFunc(closest, compareCandidates)
+	return closest, nil
+}
+
+// annBuilder links a graph in memory. Nodes are numbered in the order they
+// are added and map to embeddings rowids through ids.
+type annBuilder struct {
+	ids     []int64
+	vectors [][]float32
+	links   [][][]int64 // By node, then level
+	entry   int64
+	top     int
+	rng     *rand.Rand
+	seen    []uint32 // Search that last visited each node
+	search  uint32
+}
+
+func newANNBuilder() *annBuilder {
+	// A fixed seed gives the same graph for the same vectors
+	return &annBuilder{rng: rand.New(rand.NewPCG(1, 2))}
+}
+
+func (b *annBuilder) load(ids []int64) ([][]float32, error) {
+	vectors := make([][]float32, len(ids))
+	for i, id := range ids {
+		vectors[i] = b.vectors[id]
+	}
+	return vectors, nil
+}
+
+func (b *annBuilder) neighbors(id int64, level int) ([]int64, error) {
+	if level >= len(b.links[id]) {
+		return nil, nil
+	}
+	return b.links[id][level], nil
+}
+
+// selectNeighbors picks up to m of candidates, closest first, to link a node
+// to. A candidate closer to one already picked than to the node is passed
+// over at first, so links reach out in different directions; passed over
+// ones fill any places left.
+func (b *annBuilder) selectNeighbors(candidates []annCandidate, m int) []annCandidate {
+	if len(candidates) <= m {
+		return candidates
+	}
+	var picked, skipped []annCandidate
+	var pickedVectors [][]float32
+	for _, c := range candidates {
+		if len(picked) >= m {
+			break
+		}
+		v := b.vectors[c.id]
+		diverse := true
+		for _, p := range pickedVectors {
+			if dotProduct(v, p) > c.sim {
+				diverse = false
+				break
+			}
+		}
+		if !diverse {
+			skipped = append(skipped, c)
+			continue
+		}
+		picked = append(picked, c)
+		pickedVectors = append(pickedVectors, v)
+	}
+	for _, c := range skipped {
+		if len(picked) >= m {
+			break
+		}
+		picked = append(picked, c)
+	}
+	return picked
+}
+
+// visitor starts a new set of visited nodes
+func (b *annBuilder) visitor() func(id int64) bool {
+	b.search++
+	search := b.search
+	return func(id int64) bool {
+		if b.seen[id] == search {
+			return true
+		}
+		b.seen[id] = search
+		return false
+	}
+}
+
+// add links the vector of embeddings row id into the graph
+func (b *annBuilder) add(id int64, v []float32) {
+	node := int64(len(b.ids))
+	level := int(-math.Log(1-b.rng.Float64()) / math.Log(annM))
+	b.ids = append(b.ids, id)
+	b.vectors = append(b.vectors, v)
+	b.links = append(b.links, make([][]int64, level+1))
+	b.seen = append(b.seen, 0)
+	if node == 0 {
+		b.entry, b.top = node, level
+		return
+	}
+
+	entries := []annCandidate{{b.entry, dotProduct(v, b.vectors[b.entry])}}
+	for l := b.top; l > level; l-- {
+		entries, _ = searchLayer(b, v, entries, 1, l, b.visitor())
+	}
+	for l := min(level, b.top); l >= 0; l-- {
+		found, _ := searchLayer(b, v, entries, annEfConstruction, l, b.visitor())
+		m := annM
+		if l == 0 {
+			m = annM0
+		}
+		picked := b.selectNeighbors(found, m)
+		for _, p := range picked {
+			b.links[node][l] = append(b.links[node][l], p.id)
+			b.links[p.id][l] = append(b.links[p.id][l], node)
+			if len(b.links[p.id][l]) > m {
+				b.prune(p.id, l, m)
+			}
+		}
+		entries = found
+	}
+	if level > b.top {
+		b.entry, b.top = node, level
+	}
+}
+
+// prune cuts the links of node on level back to the m closest. Weighing
+// them for spread as selectNeighbors does would cost most of the build.
+func (b *annBuilder) prune(node int64, level, m int) {
+	links := b.links[node][level]
+	candidates := make([]annCandidate, len(links))
+	for i, n := range links {
+		candidates[i] = annCandidate{n, dotProduct(b.vectors[node], b.vectors[n])}
+	}
+	slices.SortFunc(candidates, compareCandidates)
+	kept := links[:0]
+	for _, c := range candidates[:m] {
+		kept = append(kept, c.id)
+	}
+	b.links[node][level] = kept
+}
+
+// annStore reads a stored graph a node at a time, so a search only loads
+// the vectors it compares
+type annStore struct {
+	ctx      context.Context
+	db       *sql.DB
+	model    string
+	links    *sql.Stmt
+	commands map[int64]string
+	cache    map[int64][]float32
+}
+
+func openANNStore(ctx context.Context, db *sql.DB, model string) (*annStore, error) {
+	links, err := db.PrepareContext(ctx, `SELECT neighbors FROM ann_graph WHERE model = ? AND node = ? AND level = ?`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to prepare statement: %w", err)
+	}
+	return &annStore{ctx: ctx, db: db, model: model, links: links, commands: map[int64]string{}, cache: map[int64][]float32{}}, nil
+}
+
+func (s *annStore) Close() {
+	s.links.Close()
+}
+
+// load reads the vectors of ids it hasn't yet in one query
+func (s *annStore) load(ids []int64) ([][]float32, error) {
+	var args []interface{}
+	for _, id := range ids {
+		if _, ok := s.cache[id]; !ok {
+			args = append(args, id)
+			s.cache[id] = nil
+		}
+	}
+	if len(args) > 0 {
+		// Filtering on model in SQL would have it scan the model's rows
+		// through an index instead of looking up the rowids
+		rows, err := s.db.QueryContext(s.ctx, `SELECT rowid, model, command, vector FROM embeddings
+			WHERE rowid IN (`+strings.Repeat("?, ", len(args)-1)+`?)`, args...)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read embeddings: %w", err)
+		}
+		for rows.Next() {
+			var id int64
+			var model, command string
+			var vector []byte
+			if err := rows.Scan(&id, &model, &command, &vector); err != nil {
+				rows.Close()
+				return nil, fmt.Errorf("failed to scan embedding: %w", err)
+			}
+			if model != s.model {
+				continue
+			}
+			s.cache[id] = decodeVector(vector)
+			s.commands[id] = command
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return nil, fmt.Errorf("error iterating embeddings: %w", err)
+		}
+	}
+
+	vectors := make([][]float32, len(ids))
+	for i, id := range ids {
+		vectors[i] = s.cache[id]
+	}
+	return vectors, nil
+}
+
+func (s *annStore) neighbors(id int64, level int) ([]int64, error) {
+	var blob []byte
+	err := s.links.QueryRowContext(s.ctx, s.model, id, level).Scan(&blob)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read vector index: %w", err)
+	}
+	neighbors := make([]int64, len(blob)/8)
+	for i := range neighbors {
+		neighbors[i] = int64(binary.LittleEndian.Uint64(blob[8*i:]))
+	}
+	return neighbors, nil
+}
+
+// searchANN returns about the k commands in the graph of idx closest to
+// query, closest first, with only their command and score set. Nothing is
+// found when the entry node is gone, so the caller falls back to a scan.
+func searchANN(ctx context.Context, db *sql.DB, idx ANNIndex, query []float32, k int) ([]semanticMatch, error) {
+	store, err := openANNStore(ctx, db, idx.Model)
+	if err != nil {
+		return nil, err
+	}
+	defer store.Close()
+
+	entry, err := store.load([]int64{idx.Entry})
+	if err != nil || entry[0] == nil {
+		return nil, err
+	}
+	visitor := func() func(int64) bool {
+		seen := map[int64]bool{}
+		return func(id int64) bool {
+			if seen[id] {
+				return true
+			}
+			seen[id] = true
+			return false
+		}
+	}
+	entries := []annCandidate{{idx.Entry, dotProduct(query, entry[0])}}
+	for l := idx.Levels - 1; l > 0; l-- {
+		if entries, err = searchLayer(store, query, entries, 1, l, visitor()); err != nil {
+			return nil, err
+		}
+	}
+	found, err := searchLayer(store, query, entries, max(k, annEfSearch), 0, visitor())
+	if err != nil {
+		return nil, err
+	}
+
+	matches := make([]semanticMatch, 0, min(k, len(found)))
+	for _, c := range found[:min(k, len(found))] {
+		matches = append(matches, semanticMatch{command: store.commands[c.id], score: c.sim})
+	}
+	return matches, nil
+}
+
+// rankByIndex ranks commands like rankBySimilarity, but finds candidates in
+// the graph of idx and the vectors stored since it was built rather than
+// comparing every vector. It reports false when the graph can't answer, and
+// a scan should: it lost its entry node, or filters drop too many of the
+// commands it finds.
+func rankByIndex(ctx context.Context, db *sql.DB, idx ANNIndex, query []float32, opts SearchOptions) ([]semanticMatch, bool, error) {
+	// Vectors stored since the graph was built
+	rows, err := db.QueryContext(ctx, `SELECT command, vector FROM embeddings WHERE model = ? AND created_at >= ?`, idx.Model, idx.Built)
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to read embeddings: %w", err)
+	}
+	recent := map[string]float32{}
+	for rows.Next() {
+		var command string
+		var vector []byte
+		if err := rows.Scan(&command, &vector); err != nil {
+			rows.Close()
+			return nil, false, fmt.Errorf("failed to scan embedding: %w", err)
+		}
+		recent[command] = dotProduct(query, decodeVector(vector))
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, false, fmt.Errorf("error iterating embeddings: %w", err)
+	}
+
+	filtered := opts.Source != "" || opts.Since > 0 || opts.Until > 0
+	for k := opts.Limit; ; k *= 4 {
+		// With filters most candidates may fall away, and past some point
+		// searching the graph costs as much as a scan
+		if 2*k > idx.Nodes {
+			return nil, false, nil
+		}
+		found, err := searchANN(ctx, db, idx, query, k)
+		if err != nil || len(found) == 0 {
+			return nil, false, err
+		}
+		scores := maps.Clone(recent)
+		for _, m := range found {
+			scores[m.command] = max(scores[m.command], m.score)
+		}
+		matches, err := latestRuns(ctx, db, scores, opts)
+		if err != nil {
+			return nil, false, err
+		}
+		if !filtered || len(matches) >= opts.Limit || len(found) < k {
+			return matches, true, nil
+		}
+	}
+}
+
+// latestRuns returns the latest run within the filters of opts of each
+// command in scores, with its score
+func latestRuns(ctx context.Context, db *sql.DB, scores map[string]float32, opts SearchOptions) ([]semanticMatch, error) {
+	if len(scores) == 0 {
+		return nil, nil
+	}
+	args := make([]interface{}, 0, len(scores))
+	for command := range scores {
+		args = append(args, command)
+	}
+	filter, filterArgs := commandFilter(opts)
+	args = append(args, filterArgs...)
+	rows, err := db.QueryContext(ctx, `SELECT rowid, command, max(timestamp) FROM commands c
+		WHERE c.command IN (`+strings.Repeat("?, ", len(scores)-1)+`?)`+filter+` GROUP BY command`, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up commands: %w", err)
+	}
+	defer rows.Close()
+
+	var matches []semanticMatch
+	for rows.Next() {
+		var m semanticMatch
+		var latest float64
+		if err := rows.Scan(&m.id, &m.command, &latest); err != nil {
+			return nil, fmt.Errorf("failed to scan command: %w", err)
+		}
+		m.score = scores[m.command]
+		matches = append(matches, m)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating commands: %w", err)
+	}
+	return matches, nil
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"math/rand/v2"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/tchaudhry91/zist/llm"
+)
+
+// randomEmbedding gives each text its own random vector
+func randomEmbedding(text string) []float32 {
+	h := fnv.New64a()
+	h.Write([]byte(text))
+	rng := rand.New(rand.NewPCG(h.Sum64(), 0))
+	v := make([]float32, 16)
+	for i := range v {
+		v[i] = float32(rng.NormFloat64())
+	}
+	return v
+}
+
+// exactNearest returns the ids of the k vectors closest to query
+func exactNearest(vectors [][]float32, query []float32, k int) map[int64]bool {
+	all := make([]annCandidate, len(vectors))
+	for i, v := range vectors {
+		all[i] = annCandidate{int64(i), dotProduct(query, v)}
+	}
+	nearest := map[int64]bool{}
+	slices.SortFunc(all, compareCandidates)
+	for _, c := range all[:k] {
+		nearest[c.id] = true
+	}
+	return nearest
+}
+
+func TestANNRecall(t *testing.T) {
+	b := newANNBuilder()
+	var vectors [][]float32
+	for i := range 2000 {
+		v := decodeVector(encodeVector(randomEmbedding(fmt.Sprint(i))))
+		vectors = append(vectors, v)
+		b.add(int64(i), v)
+	}
+
+	const k = 10
+	hits := 0
+	for q := range 50 {
+		query := decodeVector(encodeVector(randomEmbedding(fmt.Sprint("query", q))))
+		entries := []annCandidate{{b.entry, dotProduct(query, b.vectors[b.entry])}}
+		for l := b.top; l > 0; l-- {
+			entries, _ = searchLayer(b, query, entries, 1, l, b.visitor())
+		}
+		found, _ := searchLayer(b, query, entries, annEfSearch, 0, b.visitor())
+		nearest := exactNearest(vectors, query, k)
+		for _, c := range found[:k] {
+			if nearest[c.id] {
+				hits++
+			}
+		}
+	}
+	if recall := float64(hits) / (50 * k); recall < 0.95 {
+		t.Errorf("recall@%d = %.2f, want at least 0.95", k, recall)
+	}
+}
+
+func TestIndexEmbeddings(t *testing.T) {
+	ctx := context.Background()
+	db, err := InitDB(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("InitDB() error = %v", err)
+	}
+	defer db.Close()
+
+	var commands []Command
+	for i := range 1500 {
+		source := "/laptop"
+		if i%100 == 0 {
+			source = "/server"
+		}
+		commands = append(commands, Command{Source: source, Timestamp: float64(1000 + i), Command: fmt.Sprintf("make target-%d", i)})
+	}
+	if _, _, err := InsertCommands(ctx, db, commands); err != nil {
+		t.Fatal(err)
+	}
+	mock := &llm.Mock{Embedding: randomEmbedding}
+	if _, err := EmbedMissingCommands(ctx, db, mock, "mock-embed", 0); err != nil {
+		t.Fatal(err)
+	}
+
+	opts := SearchOptions{Query: "make target-42", Limit: 10}
+	scanned, err := SemanticSearch(ctx, db, mock, "mock-embed", opts)
+	if err != nil {
+		t.Fatalf("SemanticSearch() without an index error = %v", err)
+	}
+
+	// As if embedded a while ago, so the graph has to find them
+	if _, err := db.ExecContext(ctx, `UPDATE embeddings SET created_at = created_at - 60`); err != nil {
+		t.Fatal(err)
+	}
+	idx, err := IndexEmbeddings(ctx, db, "mock-embed", nil)
+	if err != nil || idx.Nodes != len(commands) {
+		t.Fatalf("IndexEmbeddings() = %+v, %v, want every vector linked", idx, err)
+	}
+	if stored, _ := GetANNIndex(ctx, db, "mock-embed"); stored == nil || *stored != idx {
+		t.Errorf("GetANNIndex() = %+v, want %+v", stored, idx)
+	}
+	indexed, err := SemanticSearch(ctx, db, mock, "mock-embed", opts)
+	if err != nil {
+		t.Fatalf("SemanticSearch() with an index error = %v", err)
+	}
+	if len(indexed) != len(scanned) || indexed[0].ID != scanned[0].ID {
+		t.Fatalf("SemanticSearch() with an index = %+v, want %+v", indexed, scanned)
+	}
+	query := decodeVector(encodeVector(randomEmbedding(opts.Query)))
+	if _, ok, err := rankByIndex(ctx, db, idx, query, opts); !ok || err != nil {
+		t.Errorf("rankByIndex() = %v, %v, want the graph to answer", ok, err)
+	}
+	same := 0
+	for i := range indexed {
+		if indexed[i].Command == scanned[i].Command {
+			same++
+		}
+	}
+	if same < 9 {
+		t.Errorf("SemanticSearch() with an index agrees with a scan on %d of 10", same)
+	}
+
+	// Commands embedded after the graph was built are still found
+	if _, _, err := InsertCommands(ctx, db, []Command{{Source: "/laptop", Timestamp: 5000, Command: "make brand-new"}}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := EmbedNewCommands(ctx, db, mock, "mock-embed", 1); err != nil {
+		t.Fatal(err)
+	}
+	results, err := SemanticSearch(ctx, db, mock, "mock-embed", SearchOptions{Query: "make brand-new", Limit: 5})
+	if err != nil || len(results) == 0 || results[0].Command != "make brand-new" {
+		t.Errorf("SemanticSearch() for a command newer than the index = %+v, %v", results, err)
+	}
+
+	// Filters leaving few of the graph's candidates fall back to a scan
+	opts.Source = "server"
+	results, err = SemanticSearch(ctx, db, mock, "mock-embed", opts)
+	if err != nil || len(results) != 10 {
+		t.Fatalf("SemanticSearch(source) = %d result(s), %v, want 10", len(results), err)
+	}
+	for _, r := range results {
+		if r.Source != "/server" {
+			t.Errorf("SemanticSearch(source) returned %+v", r)
+		}
+	}
+
+	if needs, err := NeedsANNIndex(ctx, db, "mock-embed"); needs || err != nil {
+		t.Errorf("NeedsANNIndex() = %v, %v, want false below %d vectors", needs, err, annMinVectors)
+	}
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ArchiveSchema is the name the archive database is attached under
+const ArchiveSchema = "archive"
+
+// DefaultArchiveYears is how old commands `zist archive` moves by default
+const DefaultArchiveYears = 2
+
+// AttachArchive attaches the archive database to db so queries can span both.
+// ATTACH is per-connection, so the pool is pinned to a single connection.
+func AttachArchive(ctx context.Context, db *sql.DB, archivePath string) error {
+	if err := ensureArchiveSchema(archivePath); err != nil {
+		return err
+	}
+
+	db.SetMaxOpenConns(1)
+	if _, err := db.ExecContext(ctx, "ATTACH DATABASE ? AS "+ArchiveSchema, expandTilde(archivePath)); err != nil {
+		return fmt.Errorf("failed to attach archive: %w", err)
+	}
+	return nil
+}
+
+// ensureArchiveSchema creates the archive database with the same tables,
+// FTS index and triggers as the main database
+func ensureArchiveSchema(archivePath string) error {
+	archive, err := InitDB(archivePath)
+	if err != nil {
+		return fmt.Errorf("failed to initialize archive: %w", err)
+	}
+	return archive.Close()
+}
+
+// commandKeyedTables hold rows keyed by (source, timestamp) like commands,
+// which move into the archive with their command
+var commandKeyedTables = []struct{ name, columns string }{
+	{"command_overflow", "source, timestamp, command"},
+	{"notes", "source, timestamp, note, created_at, updated_at"},
+	{"links", "source, timestamp, link, created_at"},
+}
+
+// ArchiveCommands moves commands older than cutoff from the main database
+// into the archive database, with their full text, notes and links,
+// returning the number of commands moved
+func ArchiveCommands(ctx context.Context, db *sql.DB, archivePath string, cutoff float64) (int64, error) {
+	if err := ensureArchiveSchema(archivePath); err != nil {
+		return 0, err
+	}
+
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("failed to get connection: %w", err)
+	}
+	defer conn.Close()
+
+	if _, err := conn.ExecContext(ctx, "ATTACH DATABASE ? AS "+ArchiveSchema, expandTilde(archivePath)); err != nil {
+		return 0, fmt.Errorf("failed to attach archive: %w", err)
+	}
+	defer conn.ExecContext(context.Background(), "DETACH DATABASE "+ArchiveSchema)
+
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, `INSERT OR IGNORE INTO archive.commands
+		(source, timestamp, command, duration, cwd, exit_code, env_prefix, picked_count, seq)
+		SELECT source, timestamp, command, duration, cwd, exit_code, env_prefix, picked_count, seq
+		FROM main.commands WHERE timestamp < ?`, cutoff); err != nil {
+		return 0, fmt.Errorf("failed to copy commands to archive: %w", err)
+	}
+
+	result, err := tx.ExecContext(ctx, `DELETE FROM main.commands WHERE timestamp < ?`, cutoff)
+	if err != nil {
+		return 0, fmt.Errorf("failed to delete archived commands: %w", err)
+	}
+
+	moved, err := result.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("failed to get rows affected: %w", err)
+	}
+
+	// What is keyed to the commands goes with them. Going by where the
+	// commands are, rather than the cutoff, also moves what an archive
+	// before these went along left behind.
+	for _, table := range commandKeyedTables {
+		moving := `NOT EXISTS (SELECT 1 FROM main.commands c WHERE c.source = t.source AND c.timestamp = t.timestamp)
+			AND EXISTS (SELECT 1 FROM archive.commands c WHERE c.source = t.source AND c.timestamp = t.timestamp)`
+		if _, err := tx.ExecContext(ctx, fmt.Sprintf(`INSERT OR IGNORE INTO archive.%[1]s (%[2]s) SELECT %[2]s FROM main.%[1]s t WHERE %[3]s`,
+			table.name, table.columns, moving)); err != nil {
+			return 0, fmt.Errorf("failed to copy %s to archive: %w", table.name, err)
+		}
+		if _, err := tx.ExecContext(ctx, fmt.Sprintf(`DELETE FROM main.%s AS t WHERE %s`, table.name, moving)); err != nil {
+			return 0, fmt.Errorf("failed to delete archived %s: %w", table.name, err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to commit archive: %w", err)
+	}
+
+	return moved, nil
+}
+
+// SearchWithArchive searches db and the archive database at archivePath at
+// the same time, each on a connection of its own, and merges the results as
+// SearchCommands ranks them. db mustn't have the archive attached with
+// AttachArchive, which leaves it only one connection.
+func SearchWithArchive(ctx context.Context, db *sql.DB, archivePath string, opts SearchOptions) ([]SearchResult, error) {
+	opts, err := searchDefaults(opts)
+	if err != nil {
+		return nil, err
+	}
+	if err := ensureArchiveSchema(archivePath); err != nil {
+		return nil, err
+	}
+	now := float64(time.Now().Unix())
+
+	var hot []rankedResult
+	var hotErr error
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		hot, hotErr = searchSchema(ctx, db, "main", opts, now)
+	}()
+	cold, coldErr := searchArchive(ctx, db, archivePath, opts, now)
+	<-done
+
+	if err := errors.Join(hotErr, coldErr); err != nil {
+		return nil, err
+	}
+	return mergeSearchResults(opts.Limit, hot, cold), nil
+}
+
+// searchArchive runs the archive's half of SearchWithArchive on a
+// connection of db with the archive attached
+func searchArchive(ctx context.Context, db *sql.DB, archivePath string, opts SearchOptions, now float64) ([]rankedResult, error) {
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get connection: %w", err)
+	}
+	defer conn.Close()
+
+	if _, err := conn.ExecContext(ctx, "ATTACH DATABASE ? AS "+ArchiveSchema, expandTilde(archivePath)); err != nil {
+		return nil, fmt.Errorf("failed to attach archive: %w", err)
+	}
+	defer conn.ExecContext(context.Background(), "DETACH DATABASE "+ArchiveSchema)
+
+	return searchSchema(ctx, conn, ArchiveSchema, opts, now)
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestArchiveCommands(t *testing.T) {
+	tmpDir := t.TempDir()
+	archivePath := filepath.Join(tmpDir, "archive.db")
+
+	db, err := InitDB(filepath.Join(tmpDir, "test.db"))
+	if err != nil {
+		t.Fatalf("InitDB() error = %v", err)
+	}
+	defer db.Close()
+
+	commands := []Command{
+		{Source: "/file1", Timestamp: 1000.0, Command: "git clone old"},
+		{Source: "/file1", Timestamp: 1001.0, Command: "ls old"},
+		{Source: "/file1", Timestamp: 5000.0, Command: "git status"},
+	}
+	if _, _, err := InsertCommands(context.Background(), db, commands); err != nil {
+		t.Fatalf("InsertCommands() error = %v", err)
+	}
+
+	moved, err := ArchiveCommands(context.Background(), db, archivePath, 2000.0)
+	if err != nil {
+		t.Fatalf("ArchiveCommands() error = %v", err)
+	}
+	if moved != 2 {
+		t.Errorf("ArchiveCommands() moved = %d, want 2", moved)
+	}
+
+	results, err := SearchCommands(context.Background(), db, SearchOptions{Query: "git"})
+	if err != nil {
+		t.Fatalf("SearchCommands() error = %v", err)
+	}
+	if len(results) != 1 {
+		t.Errorf("SearchCommands('git') on hot DB returned %d results, want 1", len(results))
+	}
+
+	if err := AttachArchive(context.Background(), db, archivePath); err != nil {
+		t.Fatalf("AttachArchive() error = %v", err)
+	}
+
+	results, err = SearchCommands(context.Background(), db, SearchOptions{Query: "git", IncludeArchive: true})
+	if err != nil {
+		t.Fatalf("SearchCommands() with archive error = %v", err)
+	}
+	if len(results) != 2 {
+		t.Fatalf("SearchCommands('git') with archive returned %d results, want 2", len(results))
+	}
+	if results[1].Command != "git clone old" {
+		t.Errorf("SearchCommands()[1].Command = %q, want 'git clone old'", results[1].Command)
+	}
+}
+
+func TestSearchWithArchive(t *testing.T) {
+	ctx := context.Background()
+	tmpDir := t.TempDir()
+	dbPath, archivePath := filepath.Join(tmpDir, "test.db"), filepath.Join(tmpDir, "archive.db")
+
+	db, err := InitDB(dbPath)
+	if err != nil {
+		t.Fatalf("InitDB() error = %v", err)
+	}
+	defer db.Close()
+
+	commands := []Command{
+		{Source: "/file1", Timestamp: 1000.0, Command: "git clone old"},
+		{Source: "/file1", Timestamp: 1001.0, Command: "git git git"},
+		{Source: "/file1", Timestamp: 1002.0, Command: "ls old"},
+		{Source: "/file1", Timestamp: 5000.0, Command: "git status"},
+		{Source: "/file1", Timestamp: 5001.0, Command: "git log --oneline"},
+		{Source: "/file1", Timestamp: 5002.0, Command: "make"},
+	}
+	if _, _, err := InsertCommands(ctx, db, commands); err != nil {
+		t.Fatalf("InsertCommands() error = %v", err)
+	}
+	if _, err := RecordPick(ctx, db, "git clone old"); err != nil {
+		t.Fatalf("RecordPick() error = %v", err)
+	}
+	if _, err := ArchiveCommands(ctx, db, archivePath, 2000.0); err != nil {
+		t.Fatalf("ArchiveCommands() error = %v", err)
+	}
+
+	// The same searches over the archive attached to a single connection
+	attached, err := InitDB(dbPath)
+	if err != nil {
+		t.Fatalf("InitDB() error = %v", err)
+	}
+	defer attached.Close()
+	if err := AttachArchive(ctx, attached, archivePath); err != nil {
+		t.Fatalf("AttachArchive() error = %v", err)
+	}
+
+	for _, opts := range []SearchOptions{
+		{Query: "git"},
+		{Query: "git", Sort: SortTime},
+		{Query: "git", Limit: 2},
+		{},
+		{Limit: 3},
+	} {
+		opts.IncludeArchive = true
+		got, err := SearchWithArchive(ctx, db, archivePath, opts)
+		if err != nil {
+			t.Fatalf("SearchWithArchive(%+v) error = %v", opts, err)
+		}
+		want, err := SearchCommands(ctx, attached, opts)
+		if err != nil {
+			t.Fatalf("SearchCommands(%+v) error = %v", opts, err)
+		}
+		if len(got) != len(want) {
+			t.Fatalf("SearchWithArchive(%+v) returned %d results, want %d", opts, len(got), len(want))
+		}
+		for i := range got {
+			if got[i].Command != want[i].Command || got[i].ID != want[i].ID {
+				t.Errorf("SearchWithArchive(%+v)[%d] = %q, want %q", opts, i, got[i].Command, want[i].Command)
+			}
+		}
+	}
+
+	// The pick carried into the archive still puts its command first
+	results, err := SearchWithArchive(ctx, db, archivePath, SearchOptions{Query: "git", IncludeArchive: true})
+	if err != nil || len(results) != 4 || results[0].Command != "git clone old" {
+		t.Errorf("SearchWithArchive('git') = %+v, %v, want the picked archived command first of 4", results, err)
+	}
+}
+
+func TestArchiveCommandsMovesKeyedRows(t *testing.T) {
+	ctx := context.Background()
+	tmpDir := t.TempDir()
+	dbPath, archivePath := filepath.Join(tmpDir, "test.db"), filepath.Join(tmpDir, "archive.db")
+
+	db, err := InitDB(dbPath)
+	if err != nil {
+		t.Fatalf("InitDB() error = %v", err)
+	}
+	defer db.Close()
+
+	long := "echo " + strings.Repeat("x", maxCommandLength)
+	commands := []Command{
+		{Source: "/file1", Timestamp: 1000.0, Command: long},
+		{Source: "/file1", Timestamp: 1001.0, Command: "deploy old"},
+		{Source: "/file1", Timestamp: 5000.0, Command: "git status"},
+	}
+	if _, _, err := InsertCommands(ctx, db, commands); err != nil {
+		t.Fatalf("InsertCommands() error = %v", err)
+	}
+	if err := SetNote(ctx, db, "/file1", 1001.0, "rollback steps"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := AddLinks(ctx, db, "/file1", 1001.0, []string{"JIRA-42"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if moved, err := ArchiveCommands(ctx, db, archivePath, 2000.0); err != nil || moved != 2 {
+		t.Fatalf("ArchiveCommands() = %d, %v, want 2", moved, err)
+	}
+	for _, table := range []string{"command_overflow", "notes", "links"} {
+		var n int
+		if err := db.QueryRow("SELECT COUNT(*) FROM " + table).Scan(&n); err != nil {
+			t.Fatal(err)
+		}
+		if n != 0 {
+			t.Errorf("%d row(s) left in the main database's %s", n, table)
+		}
+	}
+
+	// An archived pick has no ID, so its full text is found by its text
+	truncated, _ := truncateCommand(long, maxCommandLength)
+	full, err := pickedOverflow(ctx, dbPath, archivePath, &PickedRecord{Source: "/file1", Command: truncated})
+	if err != nil {
+		t.Fatalf("pickedOverflow() error = %v", err)
+	}
+	if full != long {
+		t.Errorf("pickedOverflow() = %d bytes, want the full %d", len(full), len(long))
+	}
+
+	results, err := SearchWithArchive(ctx, db, archivePath, SearchOptions{Query: "rollback"})
+	if err != nil {
+		t.Fatalf("SearchWithArchive() error = %v", err)
+	}
+	if len(results) != 1 || results[0].Note != "rollback steps" || len(results[0].Links) != 1 || results[0].Links[0] != "JIRA-42" {
+		t.Errorf("SearchWithArchive(note) = %+v, want deploy old with its note and link", results)
+	}
+}
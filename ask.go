@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tchaudhry91/zist/llm"
+)
+
+// Limits on the history `zist ask` shows the LLM
+const (
+	DefaultAskRows = 30  // Distinct commands retrieved per question
+	maxAskCommand  = 300 // bytes of a command; the rest is cut
+	maxAskNote     = 200 // bytes of a note
+)
+
+// AskRequest is a question about past activity
+type AskRequest struct {
+	Question string
+	Since    float64 // Unix timestamp, 0 means no filter
+	Until    float64 // Unix timestamp, 0 means no filter
+	Rows     int     // Distinct commands to retrieve, DefaultAskRows when 0
+	Now      time.Time
+}
+
+// AskRow is a command retrieved for a question: its latest run, and how
+// often it ran in the period asked about
+type AskRow struct {
+	SearchResult
+	Runs int
+}
+
+// AskAnswer is the LLM's answer with the rows it cited
+type AskAnswer struct {
+	Answer    string
+	Rows      []AskRow // Everything the LLM was shown, numbered from 1
+	Citations []int    // Row numbers cited in the answer, in order of first mention
+}
+
+// askSystemPrompt sets the LLM up to answer from history rows only
+const askSystemPrompt = `You answer questions about a user's past shell activity using rows from their shell history.
+
+RULES:
+- Use only the numbered history rows given; never invent commands, dates or hosts
+- Cite every row you rely on by its number in square brackets, e.g. [3]
+- Give dates and times as they appear in the rows
+- Be brief: one to three sentences, no markdown headings or code blocks
+- If the rows don't answer the question, say so plainly`
+
+// askCitation matches row numbers cited as [3] or [3, 5]
+var askCitation = regexp.MustCompile(`\[(\d+(?:\s*,\s*\d+)*)\]`)
+
+// RetrieveForQuestion finds the commands most relevant to question: the
+// distinct commands matching any of its keywords, best matches first, each
+// with its latest run and note. Matching more keywords ranks higher.
+func RetrieveForQuestion(ctx context.Context, db *sql.DB, req AskRequest) ([]AskRow, error) {
+	limit := req.Rows
+	if limit <= 0 {
+		limit = DefaultAskRows
+	}
+	return rankByKeywords(ctx, db, SearchOptions{Query: req.Question, Since: req.Since, Until: req.Until, Limit: limit})
+}
+
+// buildAskPrompt lists rows, numbered from 1, under the question
+func buildAskPrompt(req AskRequest, rows []AskRow) string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "Current time: %s\n", req.Now.Format("2006-01-02 15:04:05 (Monday)"))
+	fmt.Fprintf(&sb, "Question: %s\n\nHistory rows, best matches first:\n", req.Question)
+	for i, r := range rows {
+		command, _ := truncateCommand(r.FullCommand(), maxAskCommand)
+		fmt.Fprintf(&sb, "[%d] %s on %s, ran %d time(s): %s\n", i+1, FormatTimestamp(r.Timestamp), r.Source, r.Runs, command)
+		if r.Note != "" {
+			note, _ := truncateCommand(strings.ReplaceAll(r.Note, "\n", " "), maxAskNote)
+			fmt.Fprintf(&sb, "    note: %s\n", note)
+		}
+		for _, link := range r.Links {
+			fmt.Fprintf(&sb, "    link: %s\n", link)
+		}
+	}
+	return sb.String()
+}
+
+// parseCitations returns the row numbers answer cites that exist among n
+// rows, in order of first mention
+func parseCitations(answer string, n int) []int {
+	var cited []int
+	for _, m := range askCitation.FindAllStringSubmatch(answer, -1) {
+		for _, num := range strings.Split(m[1], ",") {
+			i, err := strconv.Atoi(strings.TrimSpace(num))
+			if err == nil && i >= 1 && i <= n && !slices.Contains(cited, i) {
+				cited = append(cited, i)
+			}
+		}
+	}
+	return cited
+}
+
+// Ask answers a question about past activity from the history rows most
+// relevant to it. Nothing is sent to the LLM when no rows match.
+func Ask(ctx context.Context, db *sql.DB, client llm.Client, req AskRequest) (*AskAnswer, error) {
+	req.Question = strings.TrimSpace(req.Question)
+	if req.Question == "" {
+		return nil, fmt.Errorf("question cannot be empty")
+	}
+	if req.Now.IsZero() {
+		req.Now = time.Now()
+	}
+
+	rows, err := RetrieveForQuestion(ctx, db, req)
+	if err != nil {
+		return nil, err
+	}
+	if len(rows) == 0 {
+		return &AskAnswer{Answer: "Nothing in your history matches the question."}, nil
+	}
+
+	response, err := client.Complete(ctx, buildAskPrompt(req, rows), askSystemPrompt)
+	if err != nil {
+		return nil, fmt.Errorf("LLM request failed: %w", err)
+	}
+	answer := strings.TrimSpace(response)
+	if answer == "" {
+		return nil, fmt.Errorf("LLM returned an empty answer")
+	}
+	return &AskAnswer{Answer: answer, Rows: rows, Citations: parseCitations(answer, len(rows))}, nil
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/tchaudhry91/zist/llm"
+)
+
+func TestAsk(t *testing.T) {
+	ctx := context.Background()
+	db, err := InitDB(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("InitDB() error = %v", err)
+	}
+	defer db.Close()
+
+	resize := "kubectl patch pvc postgres-data -p '{\"spec\":{\"resources\":{\"requests\":{\"storage\":\"50Gi\"}}}}'"
+	commands := []Command{
+		{Source: "/h", Timestamp: 1000, Command: resize},
+		{Source: "/h", Timestamp: 2000, Command: resize},
+		{Source: "/h", Timestamp: 2100, Command: "psql -h db-1 -U postgres"},
+		{Source: "/h", Timestamp: 2200, Command: "ls -la"},
+	}
+	if _, _, err := InsertCommands(ctx, db, commands); err != nil {
+		t.Fatal(err)
+	}
+	latest, err := SearchCommands(ctx, db, SearchOptions{Query: "pvc", Sort: SortTime, Limit: 1})
+	if err != nil || len(latest) != 1 {
+		t.Fatal(latest, err)
+	}
+	if err := SetNote(ctx, db, "/h", 2000, "grew the volume for the migration"); err != nil {
+		t.Fatal(err)
+	}
+
+	rows, err := RetrieveForQuestion(ctx, db, AskRequest{Question: "when did I last resize that postgres pvc?"})
+	if err != nil {
+		t.Fatalf("RetrieveForQuestion() error = %v", err)
+	}
+	if len(rows) != 2 || rows[0].Command != resize || rows[0].Runs != 2 || rows[0].ID != latest[0].ID || rows[0].Note == "" {
+		t.Fatalf("RetrieveForQuestion() = %+v, want the resize first, as its latest run with its note", rows)
+	}
+
+	mock := &llm.Mock{Reply: "You last resized it at 2000 [1], after first trying at 1000 [1, 2]. [9]"}
+	answer, err := Ask(ctx, db, mock, AskRequest{Question: "when did I last resize that postgres pvc?", Now: time.Unix(3000, 0)})
+	if err != nil {
+		t.Fatalf("Ask() error = %v", err)
+	}
+	if !slices.Equal(answer.Citations, []int{1, 2}) {
+		t.Errorf("Ask() citations = %v, want [1 2]", answer.Citations)
+	}
+	prompts, systems := mock.Prompts(), mock.Systems()
+	if len(prompts) != 1 || systems[0] != askSystemPrompt {
+		t.Fatalf("Ask() sent %d request(s), want 1 with the ask system prompt", len(prompts))
+	}
+	for _, want := range []string{"Question: when did I last resize", "[1] ", "ran 2 time(s): kubectl patch pvc", "note: grew the volume", "[2] "} {
+		if !strings.Contains(prompts[0], want) {
+			t.Errorf("Ask() prompt missing %q:\n%s", want, prompts[0])
+		}
+	}
+	if strings.Contains(prompts[0], "ls -la") {
+		t.Errorf("Ask() prompt has an unrelated command:\n%s", prompts[0])
+	}
+
+	t.Run("nothing matches", func(t *testing.T) {
+		mock := &llm.Mock{Reply: "made up"}
+		answer, err := Ask(ctx, db, mock, AskRequest{Question: "terraform apply"})
+		if err != nil || len(answer.Rows) != 0 || len(mock.Calls()) != 0 {
+			t.Errorf("Ask() = %+v, %v after %d LLM call(s), want no rows and no call", answer, err, len(mock.Calls()))
+		}
+	})
+
+	t.Run("since", func(t *testing.T) {
+		rows, err := RetrieveForQuestion(ctx, db, AskRequest{Question: "resize pvc", Since: 1500})
+		if err != nil || len(rows) != 1 || rows[0].Runs != 1 {
+			t.Errorf("RetrieveForQuestion(since) = %+v, %v, want one run", rows, err)
+		}
+	})
+}
+
+func TestParseCitations(t *testing.T) {
+	tests := []struct {
+		answer string
+		want   []int
+	}{
+		{"No citations here.", nil},
+		{"On Monday [2], and before that [1].", []int{2, 1}},
+		{"Twice [1,3] and again [3].", []int{1, 3}},
+		{"Unknown rows [0] [7] are dropped.", nil},
+		{"Arrays like [a] aren't citations.", nil},
+	}
+	for _, tt := range tests {
+		if got := parseCitations(tt.answer, 5); !slices.Equal(got, tt.want) {
+			t.Errorf("parseCitations(%q) = %v, want %v", tt.answer, got, tt.want)
+		}
+	}
+}
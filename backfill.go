@@ -0,0 +1,214 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/tchaudhry91/zist/llm"
+)
+
+// Pacing of the embedding backfill
+const (
+	DefaultBackfillPause = 500 * time.Millisecond // Rest between pages, leaving the machine and the embedding server to others
+	backfillPage         = 256                    // History rows scanned per page
+)
+
+// BackfillProgress is how far an embedding backfill got, kept in the meta
+// table after every page so an interrupted backfill carries on where it
+// stopped
+type BackfillProgress struct {
+	Model    string  `json:"model"`
+	Cursor   int64   `json:"cursor"`  // Rows below this rowid are still to scan
+	Total    int64   `json:"total"`   // Rows to scan when the backfill started
+	Scanned  int64   `json:"scanned"` // Rows scanned so far
+	Embedded int64   `json:"embedded"`
+	Started  float64 `json:"started"`
+	Updated  float64 `json:"updated"`
+	Done     bool    `json:"done"`
+	Error    string  `json:"error,omitempty"` // Why the last run stopped early
+}
+
+// Percent is the share of rows scanned
+func (p BackfillProgress) Percent() float64 {
+	if p.Done || p.Total == 0 {
+		return 100
+	}
+	return 100 * float64(p.Scanned) / float64(p.Total)
+}
+
+// BackfillOptions controls an embedding backfill
+type BackfillOptions struct {
+	Model    string
+	Pause    time.Duration // Rest between pages
+	Restart  bool          // Scan from the newest row again even if a backfill is under way
+	Lock     func(ctx context.Context) (release func(), err error)
+	Progress func(BackfillProgress) // Called after every page
+}
+
+// backfillKey is the meta key holding the progress for model
+func backfillKey(model string) string {
+	return "embed_backfill:" + model
+}
+
+// GetBackfillProgress returns the progress of the backfill for model, or
+// nil if none was started
+func GetBackfillProgress(ctx context.Context, db *sql.DB, model string) (*BackfillProgress, error) {
+	var value string
+	err := db.QueryRowContext(ctx, `SELECT value FROM meta WHERE key = ?`, backfillKey(model)).Scan(&value)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read backfill progress: %w", err)
+	}
+	var p BackfillProgress
+	if err := json.Unmarshal([]byte(value), &p); err != nil {
+		return nil, fmt.Errorf("failed to parse backfill progress: %w", err)
+	}
+	return &p, nil
+}
+
+// saveBackfillProgress records p under its model
+func saveBackfillProgress(ctx context.Context, db *sql.DB, p BackfillProgress) error {
+	value, err := json.Marshal(p)
+	if err != nil {
+		return fmt.Errorf("failed to encode backfill progress: %w", err)
+	}
+	if _, err := db.ExecContext(ctx, `INSERT INTO meta (key, value) VALUES (?, ?)
+		ON CONFLICT(key) DO UPDATE SET value = excluded.value`, backfillKey(p.Model), string(value)); err != nil {
+		return fmt.Errorf("failed to save backfill progress: %w", err)
+	}
+	return nil
+}
+
+// BackfillEmbeddings embeds every distinct command with no vector from
+// opts.Model yet, scanning history newest first a page at a time, so recent
+// commands become searchable first. It resumes an unfinished backfill and
+// starts over once one is done, skipping commands embedded since. Vectors
+// are stored under opts.Lock, so collect can go first.
+func BackfillEmbeddings(ctx context.Context, db *sql.DB, e llm.Embedder, opts BackfillOptions) (BackfillProgress, error) {
+	prev, err := GetBackfillProgress(ctx, db, opts.Model)
+	if err != nil {
+		return BackfillProgress{}, err
+	}
+	var p BackfillProgress
+	if prev != nil && !prev.Done && !opts.Restart {
+		p = *prev
+		p.Error = ""
+	} else {
+		p = BackfillProgress{Model: opts.Model, Started: float64(time.Now().Unix())}
+		if err := db.QueryRowContext(ctx, `SELECT COALESCE(max(rowid), 0) + 1, COUNT(*) FROM commands`).Scan(&p.Cursor, &p.Total); err != nil {
+			return p, fmt.Errorf("failed to count commands: %w", err)
+		}
+	}
+
+	// Anything that stops the backfill is kept for --status
+	fail := func(err error) (BackfillProgress, error) {
+		p.Error = err.Error()
+		p.Updated = float64(time.Now().Unix())
+		saveBackfillProgress(context.WithoutCancel(ctx), db, p)
+		return p, err
+	}
+
+	for {
+		cursor, missing, scanned, err := backfillPageAt(ctx, db, opts.Model, p.Cursor)
+		if err != nil {
+			return fail(err)
+		}
+		if scanned == 0 {
+			p.Done = true
+			break
+		}
+
+		for batch := range slices.Chunk(missing, embedBatch) {
+			texts := make([]string, len(batch))
+			for j, command := range batch {
+				texts[j] = embedText(command)
+			}
+			vectors, err := e.Embed(ctx, texts)
+			if err != nil {
+				return fail(err)
+			}
+			if err := storeLocked(ctx, db, opts, batch, vectors); err != nil {
+				return fail(err)
+			}
+			p.Embedded += int64(len(batch))
+		}
+
+		p.Cursor = cursor
+		p.Scanned += int64(scanned)
+		p.Updated = float64(time.Now().Unix())
+		if err := saveBackfillProgress(ctx, db, p); err != nil {
+			return p, err
+		}
+		if opts.Progress != nil {
+			opts.Progress(p)
+		}
+
+		select {
+		case <-ctx.Done():
+			return fail(ctx.Err())
+		case <-time.After(opts.Pause):
+		}
+	}
+
+	p.Updated = float64(time.Now().Unix())
+	if err := saveBackfillProgress(ctx, db, p); err != nil {
+		return p, err
+	}
+	if opts.Progress != nil {
+		opts.Progress(p)
+	}
+	return p, nil
+}
+
+// backfillPageAt scans the page of rows below cursor, returning the rowid
+// the next page starts below, the distinct commands in it with no vector
+// from model, and how many rows it scanned
+func backfillPageAt(ctx context.Context, db *sql.DB, model string, cursor int64) (int64, []string, int, error) {
+	rows, err := db.QueryContext(ctx, `SELECT c.rowid, c.command, EXISTS (SELECT 1 FROM embeddings e WHERE e.command = c.command AND e.model = ?)
+		FROM commands c WHERE c.rowid < ? ORDER BY c.rowid DESC LIMIT ?`, model, cursor, backfillPage)
+	if err != nil {
+		return cursor, nil, 0, fmt.Errorf("failed to scan history: %w", err)
+	}
+	defer rows.Close()
+
+	var missing []string
+	seen := map[string]bool{}
+	scanned := 0
+	for rows.Next() {
+		var command string
+		var embedded bool
+		if err := rows.Scan(&cursor, &command, &embedded); err != nil {
+			return cursor, nil, 0, fmt.Errorf("failed to scan command: %w", err)
+		}
+		scanned++
+		if !embedded && !seen[command] {
+			seen[command] = true
+			missing = append(missing, command)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return cursor, nil, 0, fmt.Errorf("error scanning history: %w", err)
+	}
+	return cursor, missing, scanned, nil
+}
+
+// storeLocked stores vectors while holding opts.Lock, if there is one
+func storeLocked(ctx context.Context, db *sql.DB, opts BackfillOptions, commands []string, vectors [][]float32) error {
+	if len(vectors) != len(commands) {
+		return fmt.Errorf("embedding returned %d vector(s) for %d command(s)", len(vectors), len(commands))
+	}
+	if opts.Lock != nil {
+		release, err := opts.Lock(ctx)
+		if err != nil {
+			return err
+		}
+		defer release()
+	}
+	return storeEmbeddings(ctx, db, opts.Model, commands, vectors)
+}
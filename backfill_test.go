@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	"github.com/tchaudhry91/zist/llm"
+)
+
+func TestBackfillEmbeddings(t *testing.T) {
+	ctx := context.Background()
+	db, err := InitDB(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("InitDB() error = %v", err)
+	}
+	defer db.Close()
+
+	// More rows than a page, each command run twice
+	var commands []Command
+	for i := range backfillPage + 44 {
+		commands = append(commands, Command{Source: "/laptop", Timestamp: float64(1000 + i), Command: fmt.Sprintf("git commit -m %d", i%150)})
+	}
+	if _, _, err := InsertCommands(ctx, db, commands); err != nil {
+		t.Fatal(err)
+	}
+	if p, err := GetBackfillProgress(ctx, db, "mock-embed"); p != nil || err != nil {
+		t.Fatalf("GetBackfillProgress() before any backfill = %+v, %v, want nil", p, err)
+	}
+
+	// A failing embedder leaves the progress for --status
+	if _, err := BackfillEmbeddings(ctx, db, &llm.Mock{Err: errors.New("server down")}, BackfillOptions{Model: "mock-embed"}); err == nil {
+		t.Fatal("BackfillEmbeddings() with a failing embedder succeeded")
+	}
+	p, err := GetBackfillProgress(ctx, db, "mock-embed")
+	if err != nil || p == nil || p.Error != "server down" || p.Scanned != 0 || p.Total != int64(len(commands)) {
+		t.Fatalf("GetBackfillProgress() after a failure = %+v, %v", p, err)
+	}
+
+	// Stop after the first page, then resume
+	mock := &llm.Mock{Embedding: conceptEmbedding}
+	stopCtx, stop := context.WithCancel(ctx)
+	var locked int
+	_, err = BackfillEmbeddings(stopCtx, db, mock, BackfillOptions{
+		Model:    "mock-embed",
+		Progress: func(BackfillProgress) { stop() },
+		Lock: func(context.Context) (func(), error) {
+			locked++
+			return func() {}, nil
+		},
+	})
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("BackfillEmbeddings() stopped = %v, want context.Canceled", err)
+	}
+	if locked == 0 {
+		t.Error("BackfillEmbeddings() stored vectors without taking the lock")
+	}
+	p, _ = GetBackfillProgress(ctx, db, "mock-embed")
+	if p.Scanned != backfillPage || p.Done || p.Percent() >= 100 {
+		t.Fatalf("progress after one page = %+v, want %d rows scanned", p, backfillPage)
+	}
+
+	p2, err := BackfillEmbeddings(ctx, db, mock, BackfillOptions{Model: "mock-embed"})
+	if err != nil || !p2.Done || p2.Scanned != int64(len(commands)) || p2.Embedded != 150 || p2.Error != "" {
+		t.Fatalf("BackfillEmbeddings() resumed = %+v, %v, want every row scanned and 150 commands embedded", p2, err)
+	}
+	if n, _ := CountEmbeddings(ctx, db, "mock-embed"); n != 150 {
+		t.Errorf("CountEmbeddings() = %d, want 150", n)
+	}
+
+	// A finished backfill starts over, with nothing left to embed
+	calls := len(mock.Embeds())
+	p3, err := BackfillEmbeddings(ctx, db, mock, BackfillOptions{Model: "mock-embed"})
+	if err != nil || !p3.Done || p3.Embedded != 0 || p3.Scanned != int64(len(commands)) || len(mock.Embeds()) != calls {
+		t.Errorf("BackfillEmbeddings() again = %+v, %v, want a full scan embedding nothing", p3, err)
+	}
+}
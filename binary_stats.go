@@ -0,0 +1,161 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"slices"
+	"text/tabwriter"
+	"time"
+)
+
+// BinaryStatsVersion is the version of the `zist stats --binary --json`
+// schema. Fields may be added within a version; renaming, removing or
+// changing the meaning of one bumps it.
+const BinaryStatsVersion = 1
+
+// BinaryStats counts commands for prompt segments: overall, in the current
+// project and per program run
+type BinaryStats struct {
+	Version int `json:"version"`
+	CommandCounts
+	Project  *ProjectCount `json:"project"` // Commands run in the project, null outside one
+	Binaries []BinaryCount `json:"binaries"`
+}
+
+// CommandCounts are how many commands were run lately and in all
+type CommandCounts struct {
+	Today int64 `json:"today"` // Since midnight
+	Week  int64 `json:"week"`  // Since Monday
+	Total int64 `json:"total"`
+}
+
+// ProjectCount counts the commands run in a project, by their recorded
+// working directory
+type ProjectCount struct {
+	Root string `json:"root"`
+	CommandCounts
+}
+
+// BinaryCount counts the commands that run one program
+type BinaryCount struct {
+	Binary   string  `json:"binary"`
+	Today    int64   `json:"today"`
+	Week     int64   `json:"week"`
+	Total    int64   `json:"total"`
+	LastUsed float64 `json:"last_used"` // Unix timestamp
+}
+
+// CountCommands counts the commands run as of now, only those whose
+// working directory is under project unless it is ""
+func CountCommands(ctx context.Context, db *sql.DB, now time.Time, project string) (CommandCounts, error) {
+	today, _, _ := ResolveTimeRange("today", now)
+	week, _, _ := ResolveTimeRange("week", now)
+	query := `SELECT COUNT(*), COUNT(CASE WHEN timestamp >= ? THEN 1 END), COUNT(CASE WHEN timestamp >= ? THEN 1 END)
+		FROM commands`
+	args := []any{today, week}
+	if project != "" {
+		query += ` WHERE cwd = ? OR substr(cwd, 1, ?) = ?`
+		args = append(args, project, len(project)+1, project+"/")
+	}
+
+	var c CommandCounts
+	if err := db.QueryRowContext(ctx, query, args...).Scan(&c.Total, &c.Today, &c.Week); err != nil {
+		return CommandCounts{}, fmt.Errorf("failed to count commands: %w", err)
+	}
+	return c, nil
+}
+
+// GetBinaryStats counts commands as of now, and those run under project
+// unless it is "". Binaries are the top programs by total runs, all of them
+// if top is 0.
+func GetBinaryStats(ctx context.Context, db *sql.DB, now time.Time, project string, top int) (BinaryStats, error) {
+	today, _, _ := ResolveTimeRange("today", now)
+	week, _, _ := ResolveTimeRange("week", now)
+	stats := BinaryStats{Version: BinaryStatsVersion, Binaries: []BinaryCount{}}
+
+	// Distinct commands are far fewer than runs, so programs are told apart
+	// in Go, where CommandBinary can skip env assignments
+	rows, err := db.QueryContext(ctx, `SELECT command, COUNT(*), COUNT(CASE WHEN timestamp >= ? THEN 1 END),
+			COUNT(CASE WHEN timestamp >= ? THEN 1 END), MAX(timestamp)
+		FROM commands GROUP BY command`, today, week)
+	if err != nil {
+		return BinaryStats{}, fmt.Errorf("failed to count commands: %w", err)
+	}
+	defer rows.Close()
+
+	byBinary := map[string]*BinaryCount{}
+	for rows.Next() {
+		var command string
+		var c BinaryCount
+		if err := rows.Scan(&command, &c.Total, &c.Today, &c.Week, &c.LastUsed); err != nil {
+			return BinaryStats{}, fmt.Errorf("failed to scan command counts: %w", err)
+		}
+		stats.Total += c.Total
+		stats.Today += c.Today
+		stats.Week += c.Week
+
+		c.Binary = CommandBinary(command)
+		if c.Binary == "" {
+			continue
+		}
+		b, ok := byBinary[c.Binary]
+		if !ok {
+			byBinary[c.Binary] = &c
+			continue
+		}
+		b.Total += c.Total
+		b.Today += c.Today
+		b.Week += c.Week
+		b.LastUsed = max(b.LastUsed, c.LastUsed)
+	}
+	if err := rows.Err(); err != nil {
+		return BinaryStats{}, fmt.Errorf("failed to count commands: %w", err)
+	}
+
+	for _, b := range byBinary {
+		stats.Binaries = append(stats.Binaries, *b)
+	}
+	slices.SortFunc(stats.Binaries, func(a, b BinaryCount) int {
+		return cmp.Or(cmp.Compare(b.Total, a.Total), cmp.Compare(a.Binary, b.Binary))
+	})
+	if top > 0 && len(stats.Binaries) > top {
+		stats.Binaries = stats.Binaries[:top]
+	}
+
+	if project != "" {
+		counts, err := CountCommands(ctx, db, now, project)
+		if err != nil {
+			return BinaryStats{}, err
+		}
+		stats.Project = &ProjectCount{Root: project, CommandCounts: counts}
+	}
+	return stats, nil
+}
+
+// writeBinaryStats prints the counts as a table, or as JSON in the
+// BinaryStatsVersion schema
+func writeBinaryStats(w io.Writer, stats BinaryStats, asJSON bool) error {
+	if asJSON {
+		return json.NewEncoder(w).Encode(stats)
+	}
+
+	fmt.Fprintf(w, "Commands: %d today, %d this week, %d in total\n", stats.Today, stats.Week, stats.Total)
+	if stats.Project != nil {
+		fmt.Fprintf(w, "In %s: %d today, %d this week, %d in total\n", stats.Project.Root, stats.Project.Today, stats.Project.Week, stats.Project.Total)
+	}
+	if len(stats.Binaries) == 0 {
+		return nil
+	}
+
+	fmt.Fprintln(w)
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "BINARY\tTODAY\tWEEK\tTOTAL\tLAST USED\t")
+	for _, b := range stats.Binaries {
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%d\t%s\t\n", b.Binary, b.Today, b.Week, b.Total, FormatTimestamp(b.LastUsed))
+	}
+	return tw.Flush()
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestGetBinaryStats(t *testing.T) {
+	ctx := context.Background()
+	db, err := InitDB(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("InitDB() error = %v", err)
+	}
+	defer db.Close()
+
+	// A Wednesday, so yesterday is still this week
+	now := time.Date(2026, 10, 14, 12, 0, 0, 0, time.Local)
+	today := float64(now.Add(-time.Hour).Unix())
+	yesterday := float64(now.AddDate(0, 0, -1).Unix())
+	lastMonth := float64(now.AddDate(0, -1, 0).Unix())
+	commands := []Command{
+		{Source: "/h", Timestamp: today, Command: "git status", CWD: "/src/zist"},
+		{Source: "/h", Timestamp: today + 1, Command: "GIT_PAGER= git log", CWD: "/src/zist/cmd"},
+		{Source: "/h", Timestamp: yesterday, Command: "git push", CWD: "/src/zistle"},
+		{Source: "/h", Timestamp: lastMonth, Command: "git status", CWD: "/src/zist"},
+		{Source: "/h", Timestamp: yesterday + 1, Command: "make test"},
+		{Source: "/h", Timestamp: lastMonth + 1, Command: "ls"},
+	}
+	if _, _, err := InsertCommands(ctx, db, commands); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := GetBinaryStats(ctx, db, now, "/src/zist", 2)
+	if err != nil {
+		t.Fatalf("GetBinaryStats() error = %v", err)
+	}
+	want := BinaryStats{
+		Version:       BinaryStatsVersion,
+		CommandCounts: CommandCounts{Today: 2, Week: 4, Total: 6},
+		Project:       &ProjectCount{Root: "/src/zist", CommandCounts: CommandCounts{Today: 2, Week: 2, Total: 3}},
+		Binaries: []BinaryCount{
+			{Binary: "git", Today: 2, Week: 3, Total: 4, LastUsed: today + 1},
+			{Binary: "ls", Total: 1, LastUsed: lastMonth + 1},
+		},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("GetBinaryStats() = %+v, want %+v", got, want)
+	}
+
+	got, err = GetBinaryStats(ctx, db, now, "", 0)
+	if err != nil {
+		t.Fatalf("GetBinaryStats() error = %v", err)
+	}
+	if got.Project != nil || len(got.Binaries) != 3 {
+		t.Errorf("GetBinaryStats() without project or top = %+v", got)
+	}
+
+	var buf bytes.Buffer
+	if err := writeBinaryStats(&buf, BinaryStats{Version: BinaryStatsVersion, CommandCounts: CommandCounts{Today: 1}, Binaries: []BinaryCount{}}, true); err != nil {
+		t.Fatal(err)
+	}
+	wantJSON := `{"version":1,"today":1,"week":0,"total":0,"project":null,"binaries":[]}`
+	if strings.TrimSpace(buf.String()) != wantJSON {
+		t.Errorf("writeBinaryStats() JSON = %s, want %s", buf.String(), wantJSON)
+	}
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"text/tabwriter"
+	"time"
+)
+
+// ErrCheckFailed is returned by `zist check` when a check finds a problem,
+// after printing what it found. zist exits 1 without usage help.
+var ErrCheckFailed = errors.New("check failed")
+
+// parseAge parses how long ago something may have happened: N followed by
+// h, d or w, or a Go duration such as 90m
+func parseAge(s string) (time.Duration, error) {
+	if d, ok := parseRelativeDuration("-" + s); ok {
+		return d, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("invalid age %q (use N[hdw], e.g. 7d)", s)
+	}
+	return d, nil
+}
+
+// StaleSources returns the sources whose newest command is older than
+// cutoff, stalest first
+func StaleSources(ctx context.Context, db *sql.DB, cutoff time.Time) ([]SourceInfo, error) {
+	sources, err := ListSources(ctx, db)
+	if err != nil {
+		return nil, err
+	}
+	var stale []SourceInfo
+	for _, s := range sources {
+		if s.LastSeen < float64(cutoff.Unix()) {
+			stale = append(stale, s)
+		}
+	}
+	// ListSources puts the most recently active first
+	slices.Reverse(stale)
+	return stale, nil
+}
+
+// writeStaleSources prints stale sources with how long they have been quiet
+func writeStaleSources(w io.Writer, stale []SourceInfo, now time.Time) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "SOURCE\tLAST COMMAND\tQUIET FOR\t")
+	for _, s := range stale {
+		days := int(now.Sub(unixTime(s.LastSeen)).Hours() / 24)
+		fmt.Fprintf(tw, "%s\t%s\t%dd\t\n", s.Source, FormatTimestamp(s.LastSeen), days)
+	}
+	return tw.Flush()
+}
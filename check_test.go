@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseAge(t *testing.T) {
+	tests := []struct {
+		input   string
+		want    time.Duration
+		wantErr bool
+	}{
+		{"7d", 7 * 24 * time.Hour, false},
+		{"2w", 14 * 24 * time.Hour, false},
+		{"12h", 12 * time.Hour, false},
+		{"90m", 90 * time.Minute, false},
+		{"-7d", 0, true},
+		{"soon", 0, true},
+	}
+
+	for _, tt := range tests {
+		got, err := parseAge(tt.input)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("parseAge(%q) = %v, %v, want %v (error %t)", tt.input, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestCheckStale(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "test.db")
+	db, err := InitDB(dbPath)
+	if err != nil {
+		t.Fatalf("InitDB() error = %v", err)
+	}
+	now := time.Now()
+	days := func(n int) float64 { return float64(now.AddDate(0, 0, -n).Unix()) }
+	if _, _, err := InsertCommands(context.Background(), db, []Command{
+		{Source: "laptop:/h", Timestamp: days(1), Command: "ls"},
+		{Source: "nas:/h", Timestamp: days(9), Command: "df -h"},
+		{Source: "old:/h", Timestamp: days(30), Command: "reboot"},
+	}); err != nil {
+		t.Fatalf("InsertCommands() error = %v", err)
+	}
+	db.Close()
+
+	var out strings.Builder
+	err = runCheckStale(context.Background(), dbPath, "7d", false, &out)
+	if !errors.Is(err, ErrCheckFailed) {
+		t.Fatalf("runCheckStale(context.Background(), 7d) error = %v, want ErrCheckFailed", err)
+	}
+	// Stalest first, and the active laptop left out
+	got := out.String()
+	if strings.Contains(got, "laptop") || !strings.Contains(got, "2 source(s)") ||
+		strings.Index(got, "old:/h") > strings.Index(got, "nas:/h") || !strings.Contains(got, "30d") {
+		t.Errorf("runCheckStale(context.Background(), 7d) printed %q", got)
+	}
+
+	out.Reset()
+	if err := runCheckStale(context.Background(), dbPath, "60d", true, &out); err != nil || out.Len() != 0 {
+		t.Errorf("runCheckStale(context.Background(), 60d, quiet) = %v, printed %q, want silent success", err, out.String())
+	}
+}
@@ -0,0 +1,386 @@
+// Package client is a Go client for the zist serve API described in
+// api/openapi.json. It depends only on the standard library so frontends
+// can import it without pulling in the rest of zist.
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// Command is a history entry as pushed to and returned by the server
+type Command struct {
+	ID        int64   `json:"id,omitempty"`
+	User      string  `json:"user,omitempty"`
+	Source    string  `json:"source"`
+	Timestamp float64 `json:"timestamp"`
+	Command   string  `json:"command"`
+	EnvPrefix string  `json:"env_prefix,omitempty"`
+	Duration  int     `json:"duration,omitempty"`
+	Seq       int64   `json:"seq,omitempty"` // Position in the source, orders commands run in the same second
+	Note      string  `json:"note,omitempty"`
+}
+
+// PushResult reports how many pushed commands were new
+type PushResult struct {
+	Inserted  int         `json:"inserted"`
+	Ignored   int         `json:"ignored"`
+	ClockSkew []ClockSkew `json:"clock_skew,omitempty"`
+}
+
+// ClockSkew is a pushed source with commands in the future by the server's
+// clock, usually because the machine's clock is wrong
+type ClockSkew struct {
+	Source       string  `json:"source"`
+	AheadSeconds float64 `json:"ahead_seconds"`
+}
+
+// DiffBucket counts how pushed commands from one source and day compare with
+// the stored ones
+type DiffBucket struct {
+	Source      string `json:"source"`
+	Day         string `json:"day"`
+	New         int    `json:"new"`
+	Duplicate   int    `json:"duplicate"`
+	Conflicting int    `json:"conflicting"`
+}
+
+// SearchParams filters a search. Zero values are left out of the request.
+type SearchParams struct {
+	Query         string
+	Limit         int
+	Since         string // YYYY-MM-DD, YYYY-MM-DD HH:MM:SS or -N[hdw]
+	Until         string
+	Source        string
+	Exact         bool // match query words whole instead of as prefixes
+	Shared        bool // also search histories shared with the caller
+	CaseSensitive bool // only match commands containing the query words in the same case
+}
+
+// SourceCount is the number of commands collected from one source
+type SourceCount struct {
+	Source string `json:"source"`
+	Count  int64  `json:"count"`
+}
+
+// CommandCount is how often a command was run
+type CommandCount struct {
+	Command string `json:"command"`
+	Count   int    `json:"count"`
+}
+
+// DayCount is the number of commands run on one day, YYYY-MM-DD in the
+// server's time zone
+type DayCount struct {
+	Day   string `json:"day"`
+	Count int64  `json:"count"`
+}
+
+// Stats summarises a user's history
+type Stats struct {
+	TotalCommands int64          `json:"total_commands"`
+	Sources       []SourceCount  `json:"sources"`
+	TopCommands   []CommandCount `json:"top_commands"`
+	Daily         []DayCount     `json:"daily"`
+}
+
+// WizardCacheEntry is a cached natural language query to command mapping
+type WizardCacheEntry struct {
+	Project         string  `json:"project,omitempty"` // empty for global entries
+	QueryNormalized string  `json:"query_normalized"`
+	Query           string  `json:"query"`
+	Command         string  `json:"command"`
+	RunCount        int     `json:"run_count"`
+	LastUsed        float64 `json:"last_used"`
+	CreatedAt       float64 `json:"created_at"`
+}
+
+// WizardRequest asks the server to generate a command
+type WizardRequest struct {
+	Query   string `json:"query"`
+	PWD     string `json:"pwd,omitempty"`     // the caller's working directory, as LLM context
+	Project string `json:"project,omitempty"` // prefer cache entries for this project root
+}
+
+// WizardSuggestion is a generated command
+type WizardSuggestion struct {
+	Command   string `json:"command"`
+	Source    string `json:"source"` // "cache" or "llm"
+	LatencyMs int64  `json:"latency_ms"`
+}
+
+// Shares lists who the caller shares with and who shares with the caller
+type Shares struct {
+	SharedWith   []string `json:"shared_with"`
+	SharedWithMe []string `json:"shared_with_me"`
+}
+
+// User is a server account
+type User struct {
+	Name      string  `json:"name"`
+	Role      string  `json:"role"`
+	CreatedAt float64 `json:"created_at"`
+}
+
+// CreatedUser is a new account and its token, which is only shown once
+type CreatedUser struct {
+	Name  string `json:"name"`
+	Role  string `json:"role"`
+	Token string `json:"token"`
+}
+
+// ReadinessCheck is the outcome of one readiness check
+type ReadinessCheck struct {
+	OK     bool   `json:"ok"`
+	Detail string `json:"detail,omitempty"`
+}
+
+// Readiness is the /readyz response
+type Readiness struct {
+	Status string                    `json:"status"`
+	Checks map[string]ReadinessCheck `json:"checks"`
+}
+
+// Error is a non-2xx response from the server
+type Error struct {
+	StatusCode int
+	Message    string
+	RetryAfter int // seconds, set on 429
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("zist server: %d %s", e.StatusCode, e.Message)
+}
+
+// Client talks to a zist server
+type Client struct {
+	BaseURL    string // e.g. https://zist.example.com:7474
+	Token      string
+	HTTPClient *http.Client // http.DefaultClient if nil
+}
+
+// New returns a client for the server at baseURL
+func New(baseURL, token string) *Client {
+	return &Client{BaseURL: baseURL, Token: token}
+}
+
+// Push adds commands to the caller's history
+func (c *Client) Push(ctx context.Context, commands []Command) (PushResult, error) {
+	var result PushResult
+	err := c.do(ctx, http.MethodPost, "/api/v1/commands", nil, commands, &result)
+	return result, err
+}
+
+// Diff reports what pushing commands would do, per source and day, without
+// storing them
+func (c *Client) Diff(ctx context.Context, commands []Command) ([]DiffBucket, error) {
+	var diff []DiffBucket
+	err := c.do(ctx, http.MethodPost, "/api/v1/commands/diff", nil, commands, &diff)
+	return diff, err
+}
+
+// Search searches the caller's history, newest first
+func (c *Client) Search(ctx context.Context, p SearchParams) ([]Command, error) {
+	q := url.Values{}
+	setIf(q, "q", p.Query)
+	if p.Limit > 0 {
+		q.Set("limit", strconv.Itoa(p.Limit))
+	}
+	setIf(q, "since", p.Since)
+	setIf(q, "until", p.Until)
+	setIf(q, "source", p.Source)
+	if p.Exact {
+		q.Set("exact", "true")
+	}
+	if p.CaseSensitive {
+		q.Set("case_sensitive", "true")
+	}
+	if p.Shared {
+		q.Set("shared", "true")
+	}
+
+	var results []Command
+	err := c.do(ctx, http.MethodGet, "/api/v1/search", q, nil, &results)
+	return results, err
+}
+
+// Stats returns totals, per-source counts, the top most used commands and
+// commands per day over the last days. Zero uses the server defaults.
+func (c *Client) Stats(ctx context.Context, days, top int) (Stats, error) {
+	q := url.Values{}
+	if days > 0 {
+		q.Set("days", strconv.Itoa(days))
+	}
+	if top > 0 {
+		q.Set("top", strconv.Itoa(top))
+	}
+	var stats Stats
+	err := c.do(ctx, http.MethodGet, "/api/v1/stats", q, nil, &stats)
+	return stats, err
+}
+
+// WizardCache lists the caller's most used wizard cache entries
+func (c *Client) WizardCache(ctx context.Context, limit int) ([]WizardCacheEntry, error) {
+	q := url.Values{}
+	if limit > 0 {
+		q.Set("limit", strconv.Itoa(limit))
+	}
+	var entries []WizardCacheEntry
+	err := c.do(ctx, http.MethodGet, "/api/v1/wizard-cache", q, nil, &entries)
+	return entries, err
+}
+
+// LookupWizardCache returns the cached command for query, or nil if there is
+// none. An entry for project wins over a global one; pass "" for global only.
+func (c *Client) LookupWizardCache(ctx context.Context, project, query string) (*WizardCacheEntry, error) {
+	q := url.Values{"q": {query}}
+	setIf(q, "project", project)
+	var entry WizardCacheEntry
+	err := c.do(ctx, http.MethodGet, "/api/v1/wizard-cache", q, nil, &entry)
+	if e, ok := err.(*Error); ok && e.StatusCode == http.StatusNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &entry, nil
+}
+
+// SetWizardCache caches a query to command mapping for project, or globally
+// when project is ""
+func (c *Client) SetWizardCache(ctx context.Context, project, query, command string) error {
+	body := map[string]string{"project": project, "query": query, "command": command}
+	return c.do(ctx, http.MethodPut, "/api/v1/wizard-cache", nil, body, nil)
+}
+
+// Wizard generates a command from natural language. The server must run
+// with --wizard.
+func (c *Client) Wizard(ctx context.Context, req WizardRequest) (WizardSuggestion, error) {
+	var suggestion WizardSuggestion
+	err := c.do(ctx, http.MethodPost, "/api/v1/wizard", nil, req, &suggestion)
+	return suggestion, err
+}
+
+// Shares lists the caller's shares in both directions
+func (c *Client) Shares(ctx context.Context) (Shares, error) {
+	var shares Shares
+	err := c.do(ctx, http.MethodGet, "/api/v1/shares", nil, nil, &shares)
+	return shares, err
+}
+
+// Share lets user search the caller's history
+func (c *Client) Share(ctx context.Context, user string) error {
+	return c.do(ctx, http.MethodPost, "/api/v1/shares", nil, map[string]string{"user": user}, nil)
+}
+
+// Unshare stops sharing the caller's history with user
+func (c *Client) Unshare(ctx context.Context, user string) error {
+	return c.do(ctx, http.MethodDelete, "/api/v1/shares/"+url.PathEscape(user), nil, nil, nil)
+}
+
+// Users lists all accounts (admin only)
+func (c *Client) Users(ctx context.Context) ([]User, error) {
+	var users []User
+	err := c.do(ctx, http.MethodGet, "/api/v1/users", nil, nil, &users)
+	return users, err
+}
+
+// CreateUser creates an account (admin only). Role defaults to member.
+func (c *Client) CreateUser(ctx context.Context, name, role string) (CreatedUser, error) {
+	var created CreatedUser
+	body := map[string]string{"name": name, "role": role}
+	err := c.do(ctx, http.MethodPost, "/api/v1/users", nil, body, &created)
+	return created, err
+}
+
+// DeleteUser deletes an account and its shares (admin only)
+func (c *Client) DeleteUser(ctx context.Context, name string) error {
+	return c.do(ctx, http.MethodDelete, "/api/v1/users/"+url.PathEscape(name), nil, nil, nil)
+}
+
+// Ready reports the server's readiness checks. A server that is up but not
+// ready returns its checks along with an *Error.
+func (c *Client) Ready(ctx context.Context) (Readiness, error) {
+	var r Readiness
+	err := c.do(ctx, http.MethodGet, "/readyz", nil, nil, &r)
+	return r, err
+}
+
+func (c *Client) do(ctx context.Context, method, path string, query url.Values, in, out interface{}) error {
+	u := strings.TrimSuffix(c.BaseURL, "/") + path
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+
+	var body io.Reader
+	if in != nil {
+		data, err := json.Marshal(in)
+		if err != nil {
+			return fmt.Errorf("failed to encode request: %w", err)
+		}
+		body = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, u, body)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.Token)
+	}
+
+	httpClient := c.HTTPClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to reach server: %w", err)
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("failed to read response: %w", err)
+	}
+
+	if resp.StatusCode/100 != 2 {
+		apiErr := &Error{StatusCode: resp.StatusCode, Message: resp.Status}
+		var e struct {
+			Error string `json:"error"`
+		}
+		if json.Unmarshal(data, &e) == nil && e.Error != "" {
+			apiErr.Message = e.Error
+		}
+		apiErr.RetryAfter, _ = strconv.Atoi(resp.Header.Get("Retry-After"))
+		// /readyz explains a 503 in the body, so still decode it
+		if out != nil && resp.StatusCode == http.StatusServiceUnavailable {
+			json.Unmarshal(data, out)
+		}
+		return apiErr
+	}
+
+	if out == nil || resp.StatusCode == http.StatusNoContent {
+		return nil
+	}
+	if err := json.Unmarshal(data, out); err != nil {
+		return fmt.Errorf("failed to decode response: %w", err)
+	}
+	return nil
+}
+
+func setIf(q url.Values, key, value string) {
+	if value != "" {
+		q.Set(key, value)
+	}
+}
@@ -0,0 +1,80 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestClient(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /api/v1/commands", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer tok" {
+			w.WriteHeader(http.StatusUnauthorized)
+			json.NewEncoder(w).Encode(map[string]string{"error": "invalid token"})
+			return
+		}
+		var cmds []Command
+		json.NewDecoder(r.Body).Decode(&cmds)
+		json.NewEncoder(w).Encode(PushResult{Inserted: len(cmds)})
+	})
+	mux.HandleFunc("GET /api/v1/search", func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		if q.Get("q") != "git" || q.Get("limit") != "5" || q.Get("shared") != "true" || q.Has("source") {
+			t.Errorf("unexpected query %q", r.URL.RawQuery)
+		}
+		json.NewEncoder(w).Encode([]Command{{ID: 1, User: "alice", Source: "h:zsh", Command: "git status"}})
+	})
+	mux.HandleFunc("GET /api/v1/wizard-cache", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]string{"error": "not cached"})
+	})
+	mux.HandleFunc("DELETE /api/v1/shares/{user}", func(w http.ResponseWriter, r *http.Request) {
+		if r.PathValue("user") != "bob" {
+			t.Errorf("unshare user = %q", r.PathValue("user"))
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+	mux.HandleFunc("GET /api/v1/users", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Retry-After", "3")
+		w.WriteHeader(http.StatusTooManyRequests)
+		json.NewEncoder(w).Encode(map[string]string{"error": "rate limit exceeded"})
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	ctx := context.Background()
+	c := New(srv.URL+"/", "tok")
+
+	res, err := c.Push(ctx, []Command{{Source: "h:zsh", Command: "ls"}, {Source: "h:zsh", Command: "pwd"}})
+	if err != nil || res.Inserted != 2 {
+		t.Errorf("Push = %+v, %v", res, err)
+	}
+
+	found, err := c.Search(ctx, SearchParams{Query: "git", Limit: 5, Shared: true})
+	if err != nil || len(found) != 1 || found[0].User != "alice" {
+		t.Errorf("Search = %+v, %v", found, err)
+	}
+
+	entry, err := c.LookupWizardCache(ctx, "", "list files")
+	if err != nil || entry != nil {
+		t.Errorf("LookupWizardCache = %+v, %v; want nil, nil", entry, err)
+	}
+
+	if err := c.Unshare(ctx, "bob"); err != nil {
+		t.Errorf("Unshare: %v", err)
+	}
+
+	_, err = c.Users(ctx)
+	apiErr, ok := err.(*Error)
+	if !ok || apiErr.StatusCode != http.StatusTooManyRequests || apiErr.RetryAfter != 3 || apiErr.Message != "rate limit exceeded" {
+		t.Errorf("Users error = %#v", err)
+	}
+
+	_, err = New(srv.URL, "wrong").Push(ctx, nil)
+	if apiErr, ok := err.(*Error); !ok || apiErr.StatusCode != http.StatusUnauthorized {
+		t.Errorf("Push with bad token = %v, want 401", err)
+	}
+}
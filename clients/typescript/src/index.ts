@@ -0,0 +1,244 @@
+// Client for the zist serve API. Types mirror api/openapi.json; keep the two
+// in step. Uses fetch, so it runs in browsers, Node 18+ and React Native.
+
+export interface Command {
+  id?: number;
+  user?: string;
+  source: string;
+  timestamp: number;
+  command: string;
+  env_prefix?: string;
+  duration?: number;
+  /** Position in the source, orders commands run in the same second */
+  seq?: number;
+  note?: string;
+}
+
+export interface PushResult {
+  inserted: number;
+  ignored: number;
+  /** Pushed sources with commands in the future by the server's clock */
+  clock_skew?: { source: string; ahead_seconds: number }[];
+}
+
+/** How pushed commands from one source and day compare with the stored ones */
+export interface DiffBucket {
+  source: string;
+  /** YYYY-MM-DD in the server's time zone */
+  day: string;
+  new: number;
+  duplicate: number;
+  /** A different command is stored at the same source and timestamp */
+  conflicting: number;
+}
+
+export interface SearchParams {
+  q?: string;
+  limit?: number;
+  /** YYYY-MM-DD, YYYY-MM-DD HH:MM:SS or -N[hdw] */
+  since?: string;
+  until?: string;
+  source?: string;
+  /** Match words whole rather than as prefixes */
+  exact?: boolean;
+  /** Words must appear in the command in the same case */
+  case_sensitive?: boolean;
+  /** Also search histories shared with the caller */
+  shared?: boolean;
+}
+
+export interface Stats {
+  total_commands: number;
+  sources: { source: string; count: number }[];
+  top_commands: { command: string; count: number }[];
+  /** Days with at least one command, oldest first, YYYY-MM-DD in the server's time zone */
+  daily: { day: string; count: number }[];
+}
+
+export interface WizardCacheEntry {
+  /** Project root the entry is scoped to; absent for global entries */
+  project?: string;
+  query_normalized: string;
+  query: string;
+  command: string;
+  run_count: number;
+  last_used: number;
+  created_at: number;
+}
+
+export interface WizardSuggestion {
+  command: string;
+  source: "cache" | "llm";
+  latency_ms: number;
+}
+
+export interface Shares {
+  shared_with: string[];
+  shared_with_me: string[];
+}
+
+export type Role = "admin" | "member";
+
+export interface User {
+  name: string;
+  role: Role;
+  created_at: number;
+}
+
+export interface CreatedUser {
+  name: string;
+  role: Role;
+  token: string;
+}
+
+export interface ReadinessCheck {
+  ok: boolean;
+  detail?: string;
+}
+
+export interface Readiness {
+  status: "ok" | "unavailable";
+  checks: Record<string, ReadinessCheck>;
+}
+
+/** A non-2xx response from the server */
+export class ZistError extends Error {
+  constructor(
+    public status: number,
+    message: string,
+    /** Seconds, set on 429 */
+    public retryAfter?: number,
+    public body?: unknown,
+  ) {
+    super(`zist server: ${status} ${message}`);
+  }
+}
+
+export class ZistClient {
+  constructor(
+    private baseURL: string,
+    private token?: string,
+  ) {
+    this.baseURL = baseURL.replace(/\/$/, "");
+  }
+
+  /** Add commands to the caller's history */
+  push(commands: Command[]): Promise<PushResult> {
+    return this.request("POST", "/api/v1/commands", undefined, commands);
+  }
+
+  /** Report what pushing commands would do, per source and day, without storing them */
+  diff(commands: Command[]): Promise<DiffBucket[]> {
+    return this.request("POST", "/api/v1/commands/diff", undefined, commands);
+  }
+
+  /** Search the caller's history, newest first */
+  search(params: SearchParams = {}): Promise<Command[]> {
+    return this.request("GET", "/api/v1/search", params);
+  }
+
+  /** Totals, per-source counts, most used commands and commands per day */
+  stats(params: { days?: number; top?: number } = {}): Promise<Stats> {
+    return this.request("GET", "/api/v1/stats", params);
+  }
+
+  /** The caller's most used wizard cache entries */
+  wizardCache(limit?: number): Promise<WizardCacheEntry[]> {
+    return this.request("GET", "/api/v1/wizard-cache", { limit });
+  }
+
+  /** The cached command for query, or null. An entry for project wins over a global one. */
+  async lookupWizardCache(query: string, project?: string): Promise<WizardCacheEntry | null> {
+    try {
+      return await this.request("GET", "/api/v1/wizard-cache", { q: query, project });
+    } catch (e) {
+      if (e instanceof ZistError && e.status === 404) return null;
+      throw e;
+    }
+  }
+
+  /** Cache a mapping for project, or globally when project is omitted */
+  setWizardCache(query: string, command: string, project?: string): Promise<void> {
+    return this.request("PUT", "/api/v1/wizard-cache", undefined, { project, query, command });
+  }
+
+  /** Generate a command from natural language; the server must run with --wizard */
+  wizard(query: string, opts: { pwd?: string; project?: string } = {}): Promise<WizardSuggestion> {
+    return this.request("POST", "/api/v1/wizard", undefined, { query, ...opts });
+  }
+
+  shares(): Promise<Shares> {
+    return this.request("GET", "/api/v1/shares");
+  }
+
+  /** Let user search the caller's history */
+  share(user: string): Promise<void> {
+    return this.request("POST", "/api/v1/shares", undefined, { user });
+  }
+
+  unshare(user: string): Promise<void> {
+    return this.request("DELETE", `/api/v1/shares/${encodeURIComponent(user)}`);
+  }
+
+  /** List accounts (admin only) */
+  users(): Promise<User[]> {
+    return this.request("GET", "/api/v1/users");
+  }
+
+  /** Create an account (admin only). The token is only returned once. */
+  createUser(name: string, role: Role = "member"): Promise<CreatedUser> {
+    return this.request("POST", "/api/v1/users", undefined, { name, role });
+  }
+
+  /** Delete an account and its shares (admin only) */
+  deleteUser(name: string): Promise<void> {
+    return this.request("DELETE", `/api/v1/users/${encodeURIComponent(name)}`);
+  }
+
+  /** Readiness checks. A 503 throws a ZistError whose body is the Readiness. */
+  ready(): Promise<Readiness> {
+    return this.request("GET", "/readyz");
+  }
+
+  private async request<T>(
+    method: string,
+    path: string,
+    query?: object,
+    body?: unknown,
+  ): Promise<T> {
+    let url = this.baseURL + path;
+    if (query) {
+      const params = new URLSearchParams();
+      for (const [key, value] of Object.entries(query)) {
+        if (value !== undefined && value !== "" && value !== false) {
+          params.set(key, String(value));
+        }
+      }
+      const qs = params.toString();
+      if (qs) url += "?" + qs;
+    }
+
+    const headers: Record<string, string> = {};
+    if (this.token) headers["Authorization"] = `Bearer ${this.token}`;
+    if (body !== undefined) headers["Content-Type"] = "application/json";
+
+    const resp = await fetch(url, {
+      method,
+      headers,
+      body: body === undefined ? undefined : JSON.stringify(body),
+    });
+
+    if (resp.status === 204) return undefined as T;
+    const data = await resp.json().catch(() => undefined);
+    if (!resp.ok) {
+      const retryAfter = resp.headers.get("Retry-After");
+      throw new ZistError(
+        resp.status,
+        data?.error ?? resp.statusText,
+        retryAfter ? Number(retryAfter) : undefined,
+        data,
+      );
+    }
+    return data as T;
+  }
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// clockSkewTolerance is how far in the future a command may be before its
+// source is flagged, allowing for ordinary drift between machines
+const clockSkewTolerance = 5 * time.Minute
+
+// ClockSkew is a source whose newest command is in the future
+type ClockSkew struct {
+	Source       string  `json:"source"`
+	AheadSeconds float64 `json:"ahead_seconds"`
+}
+
+// String says how far ahead the source is and how to correct it
+func (s ClockSkew) String() string {
+	ahead := time.Duration(s.AheadSeconds * float64(time.Second)).Round(time.Second)
+	return fmt.Sprintf("%s is %s in the future; if its clock is wrong, run: zist sources offset -- %s -%s", s.Source, ahead, s.Source, ahead)
+}
+
+// FutureSources returns the sources whose newest command is more than
+// clockSkewTolerance after now
+func FutureSources(ctx context.Context, db *sql.DB, now time.Time) ([]ClockSkew, error) {
+	limit := float64(now.Add(clockSkewTolerance).Unix())
+	rows, err := db.QueryContext(ctx, `SELECT source, MAX(timestamp) FROM commands
+		GROUP BY source HAVING MAX(timestamp) > ? ORDER BY source`, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to check for future commands: %w", err)
+	}
+	defer rows.Close()
+
+	var skews []ClockSkew
+	for rows.Next() {
+		var source string
+		var newest float64
+		if err := rows.Scan(&source, &newest); err != nil {
+			return nil, fmt.Errorf("failed to scan source: %w", err)
+		}
+		skews = append(skews, ClockSkew{Source: source, AheadSeconds: unixTime(newest).Sub(now).Seconds()})
+	}
+	return skews, rows.Err()
+}
+
+// futureCommands is FutureSources for commands not yet stored
+func futureCommands(commands []Command, now time.Time) []ClockSkew {
+	limit := now.Add(clockSkewTolerance)
+	newest := make(map[string]float64)
+	var order []string
+	for _, cmd := range commands {
+		if !unixTime(cmd.Timestamp).After(limit) {
+			continue
+		}
+		if _, ok := newest[cmd.Source]; !ok {
+			order = append(order, cmd.Source)
+		}
+		newest[cmd.Source] = max(newest[cmd.Source], cmd.Timestamp)
+	}
+
+	var skews []ClockSkew
+	for _, source := range order {
+		skews = append(skews, ClockSkew{Source: source, AheadSeconds: unixTime(newest[source]).Sub(now).Seconds()})
+	}
+	return skews
+}
+
+func unixTime(ts float64) time.Time {
+	return time.Unix(0, int64(ts*float64(time.Second)))
+}
+
+// GetClockOffsets returns the correction of every source that has one
+func GetClockOffsets(ctx context.Context, db *sql.DB) (map[string]time.Duration, error) {
+	rows, err := db.QueryContext(ctx, `SELECT source, offset FROM clock_offsets`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read clock offsets: %w", err)
+	}
+	defer rows.Close()
+
+	offsets := make(map[string]time.Duration)
+	for rows.Next() {
+		var source string
+		var offset float64
+		if err := rows.Scan(&source, &offset); err != nil {
+			return nil, fmt.Errorf("failed to scan clock offset: %w", err)
+		}
+		offsets[source] = time.Duration(offset * float64(time.Second))
+	}
+	return offsets, rows.Err()
+}
+
+// ApplyClockOffsets shifts commands from sources with a correction
+func ApplyClockOffsets(commands []Command, offsets map[string]time.Duration) {
+	if len(offsets) == 0 {
+		return
+	}
+	for i := range commands {
+		if offset, ok := offsets[commands[i].Source]; ok {
+			commands[i].Timestamp += offset.Seconds()
+		}
+	}
+}
+
+// SetClockOffset records the correction for a source, 0 to remove it, and
+// shifts its stored commands and notes by the change. Later collects and
+// pushes from the source are shifted as they come in.
+func SetClockOffset(ctx context.Context, db *sql.DB, source string, offset time.Duration) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var old float64
+	err = tx.QueryRowContext(ctx, `SELECT offset FROM clock_offsets WHERE source = ?`, source).Scan(&old)
+	if err != nil && err != sql.ErrNoRows {
+		return fmt.Errorf("failed to read clock offset: %w", err)
+	}
+
+	if delta := offset.Seconds() - old; delta != 0 {
+		// Moving rows through a temporary source keeps shifted timestamps from
+		// colliding with rows not shifted yet
+		tmp := "\x00shifting:" + source
+		for _, table := range []string{"commands", "notes", "command_overflow"} {
+			if _, err := tx.ExecContext(ctx, `UPDATE `+table+` SET source = ?, timestamp = timestamp + ? WHERE source = ?`, tmp, delta, source); err != nil {
+				return fmt.Errorf("failed to shift %s: %w", table, err)
+			}
+			if _, err := tx.ExecContext(ctx, `UPDATE `+table+` SET source = ? WHERE source = ?`, source, tmp); err != nil {
+				return fmt.Errorf("failed to shift %s: %w", table, err)
+			}
+		}
+	}
+
+	if offset == 0 {
+		_, err = tx.ExecContext(ctx, `DELETE FROM clock_offsets WHERE source = ?`, source)
+	} else {
+		_, err = tx.ExecContext(ctx, `INSERT INTO clock_offsets (source, offset) VALUES (?, ?)
+			ON CONFLICT(source) DO UPDATE SET offset = excluded.offset`, source, offset.Seconds())
+	}
+	if err != nil {
+		return fmt.Errorf("failed to record clock offset: %w", err)
+	}
+	return tx.Commit()
+}
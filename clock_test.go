@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestSetClockOffset(t *testing.T) {
+	db, err := InitDB(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("InitDB() error = %v", err)
+	}
+	defer db.Close()
+
+	// One second apart, so shifting by a second walks each row onto the next
+	if _, _, err := InsertCommands(context.Background(), db, []Command{
+		{Source: "/skewed", Timestamp: 1000, Command: "ls"},
+		{Source: "/skewed", Timestamp: 1001, Command: "pwd"},
+		{Source: "/other", Timestamp: 1000, Command: "whoami"},
+	}); err != nil {
+		t.Fatalf("InsertCommands() error = %v", err)
+	}
+	if err := SetNote(context.Background(), db, "/skewed", 1001, "where am I"); err != nil {
+		t.Fatalf("SetNote() error = %v", err)
+	}
+
+	timestamps := func(source string) []float64 {
+		t.Helper()
+		rows, err := db.Query(`SELECT timestamp FROM commands WHERE source = ? ORDER BY timestamp`, source)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer rows.Close()
+		var ts []float64
+		for rows.Next() {
+			var f float64
+			rows.Scan(&f)
+			ts = append(ts, f)
+		}
+		return ts
+	}
+
+	tests := []struct {
+		offset time.Duration
+		want   []float64
+	}{
+		{time.Second, []float64{1001, 1002}},
+		{-time.Second, []float64{999, 1000}},
+		{0, []float64{1000, 1001}},
+	}
+	for _, tt := range tests {
+		if err := SetClockOffset(context.Background(), db, "/skewed", tt.offset); err != nil {
+			t.Fatalf("SetClockOffset(%s) error = %v", tt.offset, err)
+		}
+		if got := timestamps("/skewed"); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("after SetClockOffset(%s) timestamps = %v, want %v", tt.offset, got, tt.want)
+		}
+		var note float64
+		if err := db.QueryRow(`SELECT timestamp FROM notes WHERE source = '/skewed'`).Scan(&note); err != nil || note != tt.want[1] {
+			t.Errorf("after SetClockOffset(%s) note at %v, %v, want %v", tt.offset, note, err, tt.want[1])
+		}
+		offsets, err := GetClockOffsets(context.Background(), db)
+		if err != nil || offsets["/skewed"] != tt.offset || len(offsets) > 1 {
+			t.Errorf("GetClockOffsets() = %v, %v, want /skewed at %s", offsets, err, tt.offset)
+		}
+	}
+	if got := timestamps("/other"); !reflect.DeepEqual(got, []float64{1000}) {
+		t.Errorf("other source moved to %v", got)
+	}
+
+	commands := []Command{{Source: "/skewed", Timestamp: 1000}, {Source: "/other", Timestamp: 1000}}
+	ApplyClockOffsets(commands, map[string]time.Duration{"/skewed": -time.Hour})
+	if commands[0].Timestamp != 1000-3600 || commands[1].Timestamp != 1000 {
+		t.Errorf("ApplyClockOffsets() = %v", commands)
+	}
+}
+
+func TestFutureSources(t *testing.T) {
+	db, err := InitDB(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("InitDB() error = %v", err)
+	}
+	defer db.Close()
+
+	now := time.Unix(100000, 0)
+	commands := []Command{
+		{Source: "/ok", Timestamp: 100000 + 60, Command: "ls"},
+		{Source: "/ahead", Timestamp: 100000, Command: "ls"},
+		{Source: "/ahead", Timestamp: 100000 + 3*3600, Command: "pwd"},
+	}
+	if _, _, err := InsertCommands(context.Background(), db, commands); err != nil {
+		t.Fatalf("InsertCommands() error = %v", err)
+	}
+
+	want := []ClockSkew{{Source: "/ahead", AheadSeconds: 3 * 3600}}
+	if got, err := FutureSources(context.Background(), db, now); err != nil || !reflect.DeepEqual(got, want) {
+		t.Errorf("FutureSources() = %v, %v, want %v", got, err, want)
+	}
+	if got := futureCommands(commands, now); !reflect.DeepEqual(got, want) {
+		t.Errorf("futureCommands() = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io"
+	"text/tabwriter"
+	"time"
+)
+
+// collectRunRetention is how long collect runs are kept. The shell hook
+// collects at nearly every prompt, so the table would otherwise grow
+// without bound.
+const collectRunRetention = 90 * 24 * time.Hour
+
+// CollectRun records collecting one history file
+type CollectRun struct {
+	Timestamp  float64 `json:"timestamp"` // Unix timestamp the run started
+	Source     string  `json:"source"`
+	Parsed     int     `json:"parsed"`
+	Inserted   int     `json:"inserted"`
+	Ignored    int     `json:"ignored"`
+	DurationMs int64   `json:"duration_ms"`
+	Error      string  `json:"error,omitempty"` // Why the run failed, "" if it didn't
+}
+
+// LogCollectRuns appends runs to collect_runs and drops runs older than
+// collectRunRetention
+func LogCollectRuns(ctx context.Context, db *sql.DB, runs []CollectRun) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	for _, r := range runs {
+		if _, err := tx.ExecContext(ctx, `INSERT INTO collect_runs (timestamp, source, parsed, inserted, ignored, duration_ms, error)
+			VALUES (?, ?, ?, ?, ?, ?, ?)`,
+			r.Timestamp, r.Source, r.Parsed, r.Inserted, r.Ignored, r.DurationMs, r.Error); err != nil {
+			return fmt.Errorf("failed to log collect run: %w", err)
+		}
+	}
+	cutoff := float64(time.Now().Add(-collectRunRetention).Unix())
+	if _, err := tx.ExecContext(ctx, `DELETE FROM collect_runs WHERE timestamp < ?`, cutoff); err != nil {
+		return fmt.Errorf("failed to prune collect runs: %w", err)
+	}
+	return tx.Commit()
+}
+
+// CollectStats totals the collect runs of one source
+type CollectStats struct {
+	Source     string
+	Runs       int64
+	Inserted   int64
+	Errors     int64
+	LastRun    float64 // Unix timestamp
+	LastNew    float64 // Last run that inserted anything, 0 if none did
+	AvgMs      int64
+	LastError  string // Error of the most recent failed run
+	LastFailed bool   // Whether the most recent run failed
+}
+
+// GetCollectStats totals collect runs since a Unix timestamp per source,
+// those that went longest without new commands first
+func GetCollectStats(ctx context.Context, db *sql.DB, since float64) ([]CollectStats, error) {
+	rows, err := db.QueryContext(ctx, `SELECT r.source, COUNT(*), SUM(r.inserted), SUM(r.error != ''), MAX(r.timestamp),
+			COALESCE(MAX(CASE WHEN r.inserted > 0 THEN r.timestamp END), 0), CAST(AVG(r.duration_ms) AS INTEGER),
+			COALESCE((SELECT e.error FROM collect_runs e WHERE e.source = r.source AND e.error != '' ORDER BY e.timestamp DESC LIMIT 1), ''),
+			(SELECT l.error != '' FROM collect_runs l WHERE l.source = r.source ORDER BY l.timestamp DESC LIMIT 1)
+		FROM collect_runs r WHERE r.timestamp >= ?
+		GROUP BY r.source ORDER BY 6, r.source`, since)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query collect runs: %w", err)
+	}
+	defer rows.Close()
+
+	var stats []CollectStats
+	for rows.Next() {
+		var s CollectStats
+		if err := rows.Scan(&s.Source, &s.Runs, &s.Inserted, &s.Errors, &s.LastRun, &s.LastNew, &s.AvgMs, &s.LastError, &s.LastFailed); err != nil {
+			return nil, fmt.Errorf("failed to scan collect runs: %w", err)
+		}
+		stats = append(stats, s)
+	}
+	return stats, rows.Err()
+}
+
+// writeCollectStats prints collect runs per source as a table, sources
+// that stopped getting new commands first
+func writeCollectStats(w io.Writer, stats []CollectStats, period string) error {
+	if len(stats) == 0 {
+		fmt.Fprintf(w, "No collect runs (%s)\n", period)
+		return nil
+	}
+
+	fmt.Fprintf(w, "Collect runs (%s):\n\n", period)
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "SOURCE\tRUNS\tNEW\tLAST RUN\tLAST NEW\tAVG TIME\tERRORS\t")
+	for _, s := range stats {
+		lastNew := "never"
+		if s.LastNew > 0 {
+			lastNew = FormatTimestamp(s.LastNew)
+		}
+		failed := fmt.Sprint(s.Errors)
+		if s.LastFailed {
+			failed += " (last run failed)"
+		}
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%s\t%s\t%s\t%s\t\n", s.Source, s.Runs, s.Inserted,
+			FormatTimestamp(s.LastRun), lastNew, time.Duration(s.AvgMs)*time.Millisecond, failed)
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+
+	for _, s := range stats {
+		if s.LastError != "" {
+			fmt.Fprintf(w, "\nLast error for %s:\n  %s\n", s.Source, s.LastError)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCollectRuns(t *testing.T) {
+	dir := t.TempDir()
+	dbPath := filepath.Join(dir, "test.db")
+	laptop, server := filepath.Join(dir, "laptop_zsh_history"), filepath.Join(dir, "server_zsh_history")
+	for path, content := range map[string]string{
+		laptop: ": 1704384000:0;ls\n",
+		server: ": 1704384000:0;uptime\n",
+	} {
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	collect := func() {
+		t.Helper()
+		if err := runCollect(context.Background(), dbPath, []string{dir}, CollectOptions{Quiet: true}); err != nil {
+			t.Fatalf("runCollect() error = %v", err)
+		}
+	}
+
+	collect()
+	// Only the laptop gets a new command before the next collect
+	if err := os.WriteFile(laptop, []byte(": 1704384000:0;ls\n: 1704384010:0;pwd\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	collect()
+
+	db, err := InitDB(dbPath)
+	if err != nil {
+		t.Fatalf("InitDB() error = %v", err)
+	}
+	defer db.Close()
+
+	// An old run is dropped when the next ones are logged
+	old := CollectRun{Timestamp: float64(time.Now().Add(-collectRunRetention - time.Hour).Unix()), Source: laptop}
+	failed := CollectRun{Timestamp: float64(time.Now().UnixNano()) / 1e9, Source: server, Error: "permission denied"}
+	if err := LogCollectRuns(context.Background(), db, []CollectRun{old}); err != nil {
+		t.Fatalf("LogCollectRuns() error = %v", err)
+	}
+	if err := LogCollectRuns(context.Background(), db, []CollectRun{failed}); err != nil {
+		t.Fatalf("LogCollectRuns() error = %v", err)
+	}
+
+	stats, err := GetCollectStats(context.Background(), db, 0)
+	if err != nil {
+		t.Fatalf("GetCollectStats() error = %v", err)
+	}
+	if len(stats) != 2 {
+		t.Fatalf("GetCollectStats() = %+v, want 2 sources", stats)
+	}
+	// The server went longer without new commands, so comes first
+	s, l := stats[0], stats[1]
+	if s.Source != server || s.Runs != 3 || s.Inserted != 1 || s.Errors != 1 || !s.LastFailed || s.LastError != "permission denied" {
+		t.Errorf("server stats = %+v", s)
+	}
+	if l.Source != laptop || l.Runs != 2 || l.Inserted != 2 || l.Errors != 0 || l.LastFailed || l.LastNew < s.LastNew {
+		t.Errorf("laptop stats = %+v", l)
+	}
+
+	var out strings.Builder
+	if err := writeCollectStats(&out, stats, "all kept"); err != nil {
+		t.Fatalf("writeCollectStats() error = %v", err)
+	}
+	if !strings.Contains(out.String(), "1 (last run failed)") || !strings.Contains(out.String(), "Last error for "+server) {
+		t.Errorf("writeCollectStats() = %q, want the failed run called out", out.String())
+	}
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"fmt"
+	"hash/fnv"
+	"os"
+	"strings"
+)
+
+// Values of --color
+const (
+	ColorAuto   = "auto"
+	ColorAlways = "always"
+	ColorNever  = "never"
+)
+
+// plainOutput is --plain, also on for TERM=dumb: no colors, highlighting
+// or other escape sequences, and ASCII in place of symbols, for screen
+// readers and dumb terminals
+var plainOutput bool
+
+// symbol returns fancy, or its ASCII stand-in plain with --plain
+func symbol(fancy, plain string) string {
+	if plainOutput {
+		return plain
+	}
+	return fancy
+}
+
+// hostColors are the ANSI foreground colors given to hosts. The web UI uses
+// the same order, so a host keeps its color everywhere.
+var hostColors = []int{31, 32, 33, 34, 35, 36}
+
+// hostOf returns the host a synced source was pushed from ("laptop" for
+// "laptop:/home/me/.zsh_history"), or "" for local sources
+func hostOf(source string) string {
+	if i := strings.Index(source, ":"); i > 0 {
+		return source[:i]
+	}
+	return ""
+}
+
+// sourceColor returns the ANSI color of a source. Sources synced from the
+// same host share a color; local sources get one per history file.
+func sourceColor(source string) int {
+	key := hostOf(source)
+	if key == "" {
+		key = source
+	}
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return hostColors[h.Sum32()%uint32(len(hostColors))]
+}
+
+// colorSource wraps s in the color of source
+func colorSource(s, source string) string {
+	return fmt.Sprintf("\x1b[%dm%s\x1b[0m", sourceColor(source), s)
+}
+
+// useColor decides --color for output to f, which may be nil when output
+// isn't a file. auto colors terminals unless NO_COLOR is set, and --plain
+// turns color off whatever the mode.
+func useColor(mode string, f *os.File) (bool, error) {
+	switch mode {
+	case ColorAlways:
+		return !plainOutput, nil
+	case ColorNever:
+		return false, nil
+	case ColorAuto, "":
+		if os.Getenv("NO_COLOR") != "" || plainOutput {
+			return false, nil
+		}
+		return isTerminal(f), nil
+	}
+	return false, fmt.Errorf("invalid --color %q: want auto, always or never", mode)
+}
+
+// isTerminal reports whether f, which may be nil, is a terminal
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
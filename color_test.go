@@ -0,0 +1,57 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSourceColor(t *testing.T) {
+	if sourceColor("laptop:/home/me/.zsh_history") != sourceColor("laptop:/home/me/.bash_history") {
+		t.Error("sources from the same host got different colors")
+	}
+	if sourceColor("/home/me/.zsh_history") != sourceColor("/home/me/.zsh_history") {
+		t.Error("a source's color changed between calls")
+	}
+	// Pinned so the web UI, which computes the same hash, stays in step
+	for source, want := range map[string]int{"laptop:~/.zsh_history": 36, "server:~/.zsh_history": 31, "/home/me/.zsh_history": 34} {
+		if got := sourceColor(source); got != want {
+			t.Errorf("sourceColor(%q) = %d, want %d", source, got, want)
+		}
+	}
+
+	var records strings.Builder
+	writeSearchRecords(&records, []SearchResult{{Command: "ls", Source: "laptop:~/.zsh_history"}}, true)
+	if fields := strings.Split(records.String(), "\t"); !strings.HasPrefix(fields[6], "\x1b[") || fields[7] != "ls\x00" {
+		t.Errorf("record = %q, want a colored badge and a plain command", records.String())
+	}
+}
+
+func TestPlainOutput(t *testing.T) {
+	plainOutput = true
+	t.Cleanup(func() { plainOutput = false })
+
+	for _, mode := range []string{ColorAlways, ColorAuto, ColorNever} {
+		if color, err := useColor(mode, nil); err != nil || color {
+			t.Errorf("useColor(%q) with --plain = %v, %v, want false", mode, color, err)
+		}
+	}
+	if _, err := useColor("rainbow", nil); err == nil {
+		t.Error("useColor() with --plain accepted an invalid mode")
+	}
+	if got := nextRecords([]NextSuggestion{{Command: "make", Count: 3}})[0].Badge; got != "3x" {
+		t.Errorf("nextRecords() badge = %q, want 3x", got)
+	}
+	if got := pickerLine(SearchResult{Command: "cat <<EOF\nhi\nEOF"}); got != `cat <<EOF\n hi\n EOF` {
+		t.Errorf("pickerLine() = %q", got)
+	}
+
+	var out strings.Builder
+	records := []SearchResult{{Command: "git push origin main", Source: "/laptop"}, {Command: "ls", Source: "/laptop"}}
+	runInternalPicker(strings.NewReader("\x1b"), &out, 10, 20, records, PickOptions{Header: "history", Preview: true})
+	if strings.Contains(out.String(), "\x1b[7m") || strings.Contains(out.String(), "\x1b[2m") {
+		t.Errorf("runInternalPicker() with --plain drew %q, want no reverse or dim video", out.String())
+	}
+	if !strings.Contains(out.String(), "> git push origin...") || !strings.Contains(out.String(), "  ls") {
+		t.Errorf("runInternalPicker() with --plain drew %q", out.String())
+	}
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"io/fs"
+	"os"
+
+	"github.com/peterbourgon/ff/v4"
+	"github.com/peterbourgon/ff/v4/fftoml"
+)
+
+// EnvVarPrefix sets any long flag from the environment: ZIST_DB for --db,
+// ZIST_CONFIG for --config, ZIST_LLM_API_URL for --llm-api-url
+const EnvVarPrefix = "ZIST"
+
+// parseOptions sets flags from the command line first, then ZIST_*
+// environment variables, then the config file, DefaultConfigPath unless
+// --config says otherwise. The config file is TOML, e.g.
+//
+//	model = "qwen2.5-coder:7b"
+//	env-hints = ["AWS_PROFILE", "KUBECONFIG:set"]
+//
+// Keys are long flag names and a missing file is not an error.
+func parseOptions() []ff.Option {
+	return []ff.Option{
+		ff.WithEnvVarPrefix(EnvVarPrefix),
+		ff.WithConfigFileFlag("config"),
+		ff.WithConfigFileParser(fftoml.Parse),
+		ff.WithConfigAllowMissingFile(),
+		ff.WithConfigIgnoreUndefinedFlags(),
+		ff.WithFilesystem(tildeFS{}),
+	}
+}
+
+// tildeFS opens config files from the OS, expanding a leading ~ the shell
+// leaves alone in ZIST_CONFIG=~/...
+type tildeFS struct{}
+
+func (tildeFS) Open(name string) (fs.File, error) {
+	return os.Open(expandTilde(name))
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/peterbourgon/ff/v4"
+)
+
+func TestParseOptions(t *testing.T) {
+	dir := t.TempDir()
+	config := filepath.Join(dir, "work.toml")
+	if err := os.WriteFile(config, []byte("db = \"~/work.db\"\nlimit = 10\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name      string
+		args      []string
+		env       map[string]string
+		wantDB    string
+		wantLimit int
+	}{
+		{"defaults", nil, nil, "~/.zist/zist.db", 500},
+		{"env", nil, map[string]string{"ZIST_DB": "/tmp/env.db"}, "/tmp/env.db", 500},
+		{"config from env", nil, map[string]string{"ZIST_CONFIG": config}, "~/work.db", 10},
+		{"env beats config", nil, map[string]string{"ZIST_CONFIG": config, "ZIST_DB": "/tmp/env.db"}, "/tmp/env.db", 10},
+		{"flag beats env", []string{"--db", "/tmp/flag.db"}, map[string]string{"ZIST_DB": "/tmp/env.db"}, "/tmp/flag.db", 500},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, key := range []string{"ZIST_DB", "ZIST_CONFIG"} {
+				t.Setenv(key, tt.env[key])
+				if _, ok := tt.env[key]; !ok {
+					os.Unsetenv(key)
+				}
+			}
+
+			root := ff.NewFlagSet("zist")
+			root.StringLong("config", filepath.Join(dir, "missing.toml"), "")
+			flags := ff.NewFlagSet("search").SetParent(root)
+			db := flags.StringLong("db", "~/.zist/zist.db", "")
+			limit := flags.IntLong("limit", 500, "")
+			cmd := &ff.Command{Name: "zist", Flags: root, Subcommands: []*ff.Command{{
+				Name:  "search",
+				Flags: flags,
+				Exec:  func(ctx context.Context, args []string) error { return nil },
+			}}}
+
+			if err := cmd.ParseAndRun(context.Background(), append([]string{"search"}, tt.args...), parseOptions()...); err != nil {
+				t.Fatalf("ParseAndRun() error = %v", err)
+			}
+			if *db != tt.wantDB || *limit != tt.wantLimit {
+				t.Errorf("db, limit = %q, %d, want %q, %d", *db, *limit, tt.wantDB, tt.wantLimit)
+			}
+		})
+	}
+}
@@ -0,0 +1,342 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"slices"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// Defaults for `zist daemon`
+const (
+	DefaultDaemonDelay          = 500 * time.Millisecond
+	DefaultDaemonPoll           = 2 * time.Second
+	DefaultDaemonFlushInterval  = 10 * time.Second
+	DefaultDaemonFlushThreshold = 20
+	DefaultDaemonCheckpointIdle = time.Minute
+	DefaultDaemonRetryDelay     = 5 * time.Second
+)
+
+// maintenanceCheckInterval is how often the daemon checks whether
+// maintenance is due. A timer set for the time would sleep through a
+// suspended laptop's missed run.
+const maintenanceCheckInterval = time.Minute
+
+// DaemonOptions controls `zist daemon`
+type DaemonOptions struct {
+	Collect CollectOptions // How changed files are collected; Quiet and FailOnError are always set
+	// Delay is how long to wait after a change before collecting, so a
+	// burst of writes is collected once
+	Delay time.Duration
+	// FlushInterval is the longest a change waits to be collected, so the
+	// commands of several prompts are written in one transaction; 0
+	// collects every change after Delay
+	FlushInterval time.Duration
+	// FlushThreshold collects sooner, once this many changes, about one
+	// per command, are waiting
+	FlushThreshold int
+	// CheckpointIdle copies the WAL into the database once nothing has
+	// been written for this long, 0 for never
+	CheckpointIdle time.Duration
+	// RetryDelay is how long after a failed collect, such as on a database
+	// busy for longer than its timeout, it's tried again; 0 for
+	// DefaultDaemonRetryDelay
+	RetryDelay time.Duration
+	// Poll is how often files are checked where the platform can't report
+	// changes (anywhere but Linux)
+	Poll time.Duration
+	// Maintenance runs on its schedule, if it has one
+	Maintenance MaintenanceOptions
+	// Ready is called once the database is open and the first collect is
+	// done, nil for nothing
+	Ready func()
+}
+
+// daemonLockPath is the file a running daemon keeps locked, next to the
+// database
+func daemonLockPath(dbPath string) string {
+	return expandTilde(dbPath) + ".daemon"
+}
+
+// DaemonRunning reports whether a zist daemon is collecting into dbPath
+func DaemonRunning(dbPath string) bool {
+	f, err := os.Open(daemonLockPath(dbPath))
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	return lockHeld(f)
+}
+
+// runDaemon collects historyFiles into dbPath, then again whenever one of
+// them, or a history file in one of the directories, changes, until it is
+// interrupted. Directories are watched where they are when the daemon
+// starts, so history files in new subdirectories need a restart.
+//
+// Changes are batched as opts says and the database is kept in WAL mode,
+// so a prompt costs no fsync of its own. Nothing waiting is lost if the
+// daemon dies: the commands are still in the history files, and the next
+// collect picks them up where the last one stopped.
+func runDaemon(ctx context.Context, dbPath string, historyFiles []string, opts DaemonOptions) error {
+	if len(historyFiles) == 0 {
+		historyFiles = []string{expandTilde("~/.histories")}
+	}
+	files, err := expandHistoryPaths(historyFiles)
+	if err != nil {
+		return err
+	}
+	if len(files) == 0 {
+		return fmt.Errorf("no history files found")
+	}
+	if err := checkSourceLabel(files, ""); err != nil {
+		return err
+	}
+
+	lockPath := daemonLockPath(dbPath)
+	if err := os.MkdirAll(filepath.Dir(lockPath), 0755); err != nil {
+		return fmt.Errorf("failed to create %s: %w", filepath.Dir(lockPath), err)
+	}
+	lock, err := os.OpenFile(lockPath, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open daemon lock: %w", err)
+	}
+	defer lock.Close()
+	if !tryLock(lock) {
+		return fmt.Errorf("a zist daemon is already collecting into %s", dbPath)
+	}
+
+	ctx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	watchedFiles := map[string]bool{}
+	watchedDirs := map[string]bool{}
+	var dirs []string
+	addDir := func(dir string) {
+		if !watchedDirs[dir] {
+			watchedDirs[dir] = true
+			dirs = append(dirs, dir)
+		}
+	}
+	var parents []string
+	for _, path := range historyFiles {
+		abs, err := filepath.Abs(path)
+		if err != nil {
+			return fmt.Errorf("failed to get absolute path: %w", err)
+		}
+		if info, err := os.Stat(abs); err == nil && info.IsDir() {
+			parents = append(parents, abs+string(filepath.Separator))
+			addDir(abs)
+		}
+	}
+	for _, file := range files {
+		abs, err := filepath.Abs(file)
+		if err != nil {
+			return fmt.Errorf("failed to get absolute path: %w", err)
+		}
+		watchedFiles[abs] = true
+		addDir(filepath.Dir(abs))
+	}
+
+	// The database and its lock files may sit in a watched directory, so
+	// only history files start a collect
+	kick := make(chan struct{}, 1)
+	changed := func(path string) {
+		name := filepath.Base(path)
+		isHistory := strings.HasSuffix(name, "zsh_history") || strings.HasSuffix(name, "fish_history")
+		underDir := false
+		for _, parent := range parents {
+			underDir = underDir || strings.HasPrefix(path, parent)
+		}
+		if watchedFiles[path] || isHistory && underDir {
+			select {
+			case kick <- struct{}{}:
+			default:
+			}
+		}
+	}
+	watchErrs, err := watchDirs(ctx, dirs, opts.Poll, changed)
+	if err != nil {
+		return err
+	}
+
+	db, err := InitDB(dbPath)
+	if err != nil {
+		return fmt.Errorf("failed to open database: %w", err)
+	}
+	defer db.Close()
+	// One connection, kept open with the WAL, so closing a collect's
+	// connections doesn't checkpoint and remove it each time, and the
+	// idle checkpoint runs where the WAL is known
+	db.SetMaxOpenConns(1)
+	// Persistent, and readers open WAL databases as they do others
+	var journalMode string
+	if err := db.QueryRowContext(ctx, `PRAGMA journal_mode = WAL`).Scan(&journalMode); err != nil {
+		return fmt.Errorf("failed to switch to WAL: %w", err)
+	}
+	if journalMode != "wal" {
+		log.Printf("Database is in %s mode, WAL isn't available", journalMode)
+	}
+	// Switching mode leaves the WAL unopened until the connection reads
+	if _, err := SchemaVersion(db); err != nil {
+		return err
+	}
+
+	if opts.RetryDelay <= 0 {
+		opts.RetryDelay = DefaultDaemonRetryDelay
+	}
+	collectOpts := opts.Collect
+	collectOpts.Quiet, collectOpts.FailOnError = true, true
+	collect := func(ctx context.Context) error {
+		err := runCollect(ctx, dbPath, historyFiles, collectOpts)
+		if err != nil && ctx.Err() == nil {
+			log.Printf("collect failed: %v", err)
+		}
+		return err
+	}
+
+	var maintenanceDue time.Time
+	var maintenanceCheck <-chan time.Time
+	if sched := opts.Maintenance.Schedule; sched != nil {
+		maintenanceDue = sched.Next(time.Now())
+		ticker := time.NewTicker(maintenanceCheckInterval)
+		defer ticker.Stop()
+		maintenanceCheck = ticker.C
+		log.Printf("Maintenance runs %s, next at %s", sched, maintenanceDue.Format("2006-01-02 15:04"))
+	}
+
+	// A change settles Delay after it's seen and then waits in pending
+	// until FlushInterval after the first of them, or until there are
+	// FlushThreshold. A collect that fails is tried again RetryDelay
+	// later, rather than waiting for the next change.
+	var settle, flushDue, idle, retry <-chan time.Time
+	pending, due := 0, false
+	flush := func() {
+		pending, due, flushDue, retry = 0, false, nil, nil
+		if err := collect(ctx); err != nil {
+			pending, retry = 1, time.After(opts.RetryDelay)
+			return
+		}
+		if opts.CheckpointIdle > 0 {
+			idle = time.After(opts.CheckpointIdle)
+		}
+	}
+
+	log.Printf("Watching %d history file(s), collecting into %s", len(files), dbPath)
+	flush()
+	if opts.Ready != nil {
+		opts.Ready()
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			if pending > 0 || settle != nil {
+				// The signal cancelled ctx, not the writes waiting
+				collect(context.WithoutCancel(ctx))
+			}
+			return nil
+		case err := <-watchErrs:
+			return err
+		case now := <-maintenanceCheck:
+			if now.Before(maintenanceDue) {
+				continue
+			}
+			if err := runDaemonMaintenance(ctx, dbPath, opts.Maintenance); err != nil && ctx.Err() == nil {
+				log.Printf("maintenance failed: %v", err)
+			}
+			maintenanceDue = opts.Maintenance.Schedule.Next(time.Now())
+		case <-kick:
+			if settle == nil {
+				settle = time.After(opts.Delay)
+			}
+			if flushDue == nil && pending == 0 && opts.FlushInterval > 0 {
+				flushDue = time.After(opts.FlushInterval)
+			}
+		case <-settle:
+			settle = nil
+			// Changes during the delay are in this one too
+			select {
+			case <-kick:
+			default:
+			}
+			pending++
+			if due || opts.FlushInterval <= 0 || opts.FlushThreshold > 0 && pending >= opts.FlushThreshold {
+				flush()
+			}
+		case <-flushDue:
+			flushDue, due = nil, true
+			// A change still settling may be half written
+			if settle == nil {
+				flush()
+			}
+		case <-retry:
+			retry, due = nil, true
+			if settle == nil {
+				flush()
+			}
+		case <-idle:
+			idle = nil
+			if err := checkpointWAL(ctx, db); err != nil && ctx.Err() == nil {
+				// Try again once it's been idle as long again
+				log.Printf("checkpoint failed: %v", err)
+				idle = time.After(opts.CheckpointIdle)
+			}
+		}
+	}
+}
+
+// checkpointWAL copies the WAL into db and truncates it. It fails if a
+// reader kept it from copying all of it.
+func checkpointWAL(ctx context.Context, db *sql.DB) error {
+	// The pragma returns a row, and run with Exec, leaving it unread,
+	// SQLite reports the table as locked
+	var busy, frames, copied int
+	if err := db.QueryRowContext(ctx, `PRAGMA wal_checkpoint(TRUNCATE)`).Scan(&busy, &frames, &copied); err != nil {
+		return fmt.Errorf("failed to checkpoint: %w", err)
+	}
+	if busy != 0 {
+		return fmt.Errorf("failed to checkpoint: %d of %d WAL frame(s) copied, a reader holds the rest", copied, frames)
+	}
+	return nil
+}
+
+// runDaemonMaintenance runs maintenance on dbPath, waiting for any other
+// zist writing to it or the archive, and logs what it did
+func runDaemonMaintenance(ctx context.Context, dbPath string, opts MaintenanceOptions) error {
+	paths := []string{expandTilde(dbPath)}
+	if opts.RetainYears > 0 {
+		paths = append(paths, expandTilde(opts.ArchivePath))
+	}
+	for _, path := range slices.Compact(paths) {
+		lock, err := lockForWrite(ctx, path, false, true)
+		if err != nil {
+			return err
+		}
+		defer lock.Release()
+	}
+
+	db, err := InitDB(dbPath)
+	if err != nil {
+		return fmt.Errorf("failed to open database: %w", err)
+	}
+	defer db.Close()
+	// The busy timeout is per connection
+	db.SetMaxOpenConns(1)
+	if _, err := db.ExecContext(ctx, fmt.Sprintf("PRAGMA busy_timeout = %d", maintenanceBusyTimeout.Milliseconds())); err != nil {
+		return fmt.Errorf("failed to set busy timeout: %w", err)
+	}
+
+	start := time.Now()
+	report, err := RunMaintenance(ctx, db, dbPath, opts, start)
+	if err != nil {
+		return err
+	}
+	log.Printf("Maintenance done in %s: %s", time.Since(start).Round(time.Millisecond), report)
+	return nil
+}
@@ -0,0 +1,277 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// waitReady waits for a daemon started with Ready closing ready, failing
+// if it stops first
+func waitReady(t *testing.T, ready <-chan struct{}, done <-chan error) {
+	t.Helper()
+	select {
+	case <-ready:
+	case err := <-done:
+		t.Fatalf("runDaemon() stopped before it was ready: %v", err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("runDaemon() wasn't ready after 5s")
+	}
+}
+
+func TestRunDaemon(t *testing.T) {
+	dir := t.TempDir()
+	dbPath := filepath.Join(dir, "db", "test.db")
+	historyDir := filepath.Join(dir, "histories")
+	if err := os.MkdirAll(historyDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	history := filepath.Join(historyDir, "laptop_zsh_history")
+	if err := os.WriteFile(history, []byte(": 1704384000:0;ls\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done, ready := make(chan error, 1), make(chan struct{})
+	go func() {
+		done <- runDaemon(ctx, dbPath, []string{historyDir}, DaemonOptions{
+			Delay: 10 * time.Millisecond,
+			Poll:  20 * time.Millisecond,
+			Ready: func() { close(ready) },
+		})
+	}()
+	defer func() {
+		cancel()
+		if err := <-done; err != nil {
+			t.Errorf("runDaemon() error = %v", err)
+		}
+	}()
+	waitReady(t, ready, done)
+
+	waitFor := func(want int64) {
+		t.Helper()
+		var got int64
+		for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(20 * time.Millisecond) {
+			db, err := OpenReadOnlyDB(dbPath)
+			if err != nil {
+				continue
+			}
+			err = db.QueryRow("SELECT COUNT(*) FROM commands").Scan(&got)
+			db.Close()
+			if err == nil && got == want {
+				return
+			}
+		}
+		t.Fatalf("daemon collected %d command(s), want %d", got, want)
+	}
+	waitFor(1)
+
+	if !DaemonRunning(dbPath) {
+		t.Error("DaemonRunning() = false while the daemon runs")
+	}
+	if err := runDaemon(context.Background(), dbPath, []string{historyDir}, DaemonOptions{}); err == nil {
+		t.Error("a second runDaemon() on the same database started")
+	}
+
+	// Appended commands and new history files in the directory are collected
+	f, err := os.OpenFile(history, os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString(": 1704384010:0;git status\n")
+	f.Close()
+	waitFor(2)
+
+	if err := os.WriteFile(filepath.Join(historyDir, "server_zsh_history"), []byte(": 1704384020:0;uptime\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(3)
+}
+
+func TestRunDaemonBatches(t *testing.T) {
+	dir := t.TempDir()
+	dbPath := filepath.Join(dir, "test.db")
+	history := filepath.Join(dir, "zsh_history")
+	if err := os.WriteFile(history, []byte(": 1704384000:0;ls\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done, ready := make(chan error, 1), make(chan struct{})
+	go func() {
+		done <- runDaemon(ctx, dbPath, []string{history}, DaemonOptions{
+			Delay:          10 * time.Millisecond,
+			Poll:           20 * time.Millisecond,
+			FlushInterval:  time.Hour,
+			FlushThreshold: 2,
+			CheckpointIdle: 500 * time.Millisecond,
+			Ready:          func() { close(ready) },
+		})
+	}()
+	waitReady(t, ready, done)
+
+	count := func() int64 {
+		t.Helper()
+		db, err := OpenReadOnlyDB(dbPath)
+		if err != nil {
+			return -1
+		}
+		defer db.Close()
+		var n int64
+		if err := db.QueryRow("SELECT COUNT(*) FROM commands").Scan(&n); err != nil {
+			return -1
+		}
+		return n
+	}
+	waitFor := func(want int64) {
+		t.Helper()
+		for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(20 * time.Millisecond) {
+			if count() == want {
+				return
+			}
+		}
+		t.Fatalf("daemon collected %d command(s), want %d", count(), want)
+	}
+	appendCommand := func(line string) {
+		t.Helper()
+		f, err := os.OpenFile(history, os.O_APPEND|os.O_WRONLY, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		f.WriteString(line)
+		f.Close()
+	}
+	waitFor(1)
+
+	db, err := OpenReadOnlyDB(dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var journalMode string
+	db.QueryRow("PRAGMA journal_mode").Scan(&journalMode)
+	db.Close()
+	if journalMode != "wal" {
+		t.Errorf("journal_mode = %q, want wal", journalMode)
+	}
+
+	// The first collect's writes wait in the WAL until the daemon is idle,
+	// then are copied into the database and the WAL truncated
+	walSize := func() int64 {
+		info, err := os.Stat(dbPath + "-wal")
+		if err != nil {
+			return -1
+		}
+		return info.Size()
+	}
+	if size := walSize(); size <= 0 {
+		t.Errorf("WAL is %d bytes after the first collect, want its writes", size)
+	}
+	size := walSize()
+	for deadline := time.Now().Add(5 * time.Second); size != 0 && time.Now().Before(deadline); time.Sleep(20 * time.Millisecond) {
+		size = walSize()
+	}
+	if size != 0 {
+		t.Errorf("WAL is %d bytes after the daemon was idle, want it truncated", size)
+	}
+
+	// One change waits for a second, or the interval
+	appendCommand(": 1704384010:0;git status\n")
+	time.Sleep(200 * time.Millisecond)
+	if got := count(); got != 1 {
+		t.Errorf("after one change: %d command(s), want 1 until the threshold", got)
+	}
+	appendCommand(": 1704384020:0;git push\n")
+	waitFor(3)
+
+	// Waiting changes are written when the daemon stops
+	appendCommand(": 1704384030:0;uptime\n")
+	time.Sleep(100 * time.Millisecond)
+	cancel()
+	if err := <-done; err != nil {
+		t.Fatalf("runDaemon() error = %v", err)
+	}
+	if got := count(); got != 4 {
+		t.Errorf("after stopping: %d command(s), want 4", got)
+	}
+}
+
+func TestRunDaemonRetries(t *testing.T) {
+	dir := t.TempDir()
+	dbPath := filepath.Join(dir, "test.db")
+	history := filepath.Join(dir, "zsh_history")
+	if err := os.WriteFile(history, []byte(": 1704384000:0;ls\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// Collects fail soon while the test holds the database
+	defer func(timeout time.Duration) { dbBusyTimeout = timeout }(dbBusyTimeout)
+	dbBusyTimeout = 20 * time.Millisecond
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done, ready := make(chan error, 1), make(chan struct{})
+	go func() {
+		done <- runDaemon(ctx, dbPath, []string{history}, DaemonOptions{
+			Delay:      10 * time.Millisecond,
+			Poll:       20 * time.Millisecond,
+			RetryDelay: 50 * time.Millisecond,
+			Ready:      func() { close(ready) },
+		})
+	}()
+	defer func() {
+		cancel()
+		if err := <-done; err != nil {
+			t.Errorf("runDaemon() error = %v", err)
+		}
+	}()
+	waitReady(t, ready, done)
+
+	count := func() int64 {
+		t.Helper()
+		db, err := OpenReadOnlyDB(dbPath)
+		if err != nil {
+			return -1
+		}
+		defer db.Close()
+		var n int64
+		if err := db.QueryRow("SELECT COUNT(*) FROM commands").Scan(&n); err != nil {
+			return -1
+		}
+		return n
+	}
+
+	// A write left open, as by a zist not using the write lock
+	db, err := InitDB(dbPath)
+	if err != nil {
+		t.Fatalf("InitDB() error = %v", err)
+	}
+	defer db.Close()
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tx.Exec(`INSERT INTO clock_offsets (source, offset) VALUES ('/busy', 0)`); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.OpenFile(history, os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString(": 1704384010:0;git status\n")
+	f.Close()
+	time.Sleep(200 * time.Millisecond)
+	if got := count(); got != 1 {
+		t.Errorf("while busy: %d command(s), want 1", got)
+	}
+
+	// Collected once the database is free, with no further change
+	tx.Rollback()
+	var got int64
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(20 * time.Millisecond) {
+		if got = count(); got == 2 {
+			return
+		}
+	}
+	t.Errorf("once the database was free: %d command(s), want 2", got)
+}
@@ -1,13 +1,17 @@
 package main
 
 import (
+	"cmp"
+	"context"
 	"database/sql"
 	"fmt"
 	"os"
 	"os/user"
 	"path/filepath"
+	"slices"
 	"strings"
 	"time"
+	"unicode/utf8"
 
 	_ "modernc.org/sqlite"
 )
@@ -23,6 +27,11 @@ func expandTilde(path string) string {
 	return path
 }
 
+// dbBusyTimeout is how long InitDB's connections wait for another to
+// finish with the database, such as a search reading it while a collect
+// migrates or commits, before failing with SQLITE_BUSY
+var dbBusyTimeout = 5 * time.Second
+
 func InitDB(dbPath string) (*sql.DB, error) {
 	expandedPath := expandTilde(dbPath)
 
@@ -30,7 +39,8 @@ func InitDB(dbPath string) (*sql.DB, error) {
 		return nil, fmt.Errorf("failed to create database directory: %w", err)
 	}
 
-	db, err := sql.Open("sqlite", expandedPath+"?_foreign_keys=on")
+	db, err := sql.Open("sqlite", fmt.Sprintf("%s?_foreign_keys=on&_pragma=busy_timeout(%d)&%s",
+		expandedPath, dbBusyTimeout.Milliseconds(), sqlitePragmas.dsn()))
 	if err != nil {
 		return nil, fmt.Errorf("failed to open database: %w", err)
 	}
@@ -43,6 +53,10 @@ func InitDB(dbPath string) (*sql.DB, error) {
 		return nil, fmt.Errorf("failed to create schema: %w", err)
 	}
 
+	if err := rekeyWizardCache(db, cacheNormalizer); err != nil {
+		return nil, err
+	}
+
 	return db, nil
 }
 
@@ -86,6 +100,168 @@ func CreateSchema(db *sql.DB) error {
 		);`,
 		`CREATE INDEX IF NOT EXISTS idx_wizard_last_used ON wizard_cache(last_used DESC);`,
 		`CREATE INDEX IF NOT EXISTS idx_wizard_run_count ON wizard_cache(run_count DESC);`,
+		// Last observed state of each collected history file
+		`CREATE TABLE IF NOT EXISTS sources (
+			path TEXT PRIMARY KEY,
+			size INTEGER NOT NULL,
+			inode INTEGER,
+			mtime REAL,
+			head_hash TEXT NOT NULL,
+			head_len INTEGER NOT NULL,
+			last_collected REAL NOT NULL,
+			rewritten_at REAL DEFAULT 0
+		);`,
+		// Named searches (query + filters) for `zist search --saved`
+		`CREATE TABLE IF NOT EXISTS saved_searches (
+			name TEXT PRIMARY KEY,
+			query TEXT NOT NULL DEFAULT '',
+			source TEXT NOT NULL DEFAULT '',
+			since TEXT NOT NULL DEFAULT '',
+			until TEXT NOT NULL DEFAULT '',
+			result_limit INTEGER NOT NULL DEFAULT 0,
+			include_archive INTEGER NOT NULL DEFAULT 0,
+			created_at REAL NOT NULL,
+			last_used REAL NOT NULL
+		);`,
+		// Free-text notes, keyed like commands so they survive re-ingest and archiving
+		`CREATE TABLE IF NOT EXISTS notes (
+			source TEXT NOT NULL,
+			timestamp REAL NOT NULL,
+			note TEXT NOT NULL,
+			created_at REAL NOT NULL,
+			updated_at REAL NOT NULL,
+			PRIMARY KEY (source, timestamp)
+		);`,
+		`CREATE VIRTUAL TABLE IF NOT EXISTS notes_fts USING fts5(
+			note,
+			content='notes',
+			content_rowid='rowid'
+		);`,
+		`CREATE TRIGGER IF NOT EXISTS notes_ai AFTER INSERT ON notes BEGIN
+			INSERT INTO notes_fts(rowid, note) VALUES (new.rowid, new.note);
+		END;`,
+		`CREATE TRIGGER IF NOT EXISTS notes_ad AFTER DELETE ON notes BEGIN
+			INSERT INTO notes_fts(notes_fts, rowid, note) VALUES ('delete', old.rowid, old.note);
+		END;`,
+		`CREATE TRIGGER IF NOT EXISTS notes_au AFTER UPDATE ON notes BEGIN
+			INSERT INTO notes_fts(notes_fts, rowid, note) VALUES ('delete', old.rowid, old.note);
+			INSERT INTO notes_fts(rowid, note) VALUES (new.rowid, new.note);
+		END;`,
+		// Last local rowid pushed to each zist server by `zist sync`
+		`CREATE TABLE IF NOT EXISTS sync_state (
+			server TEXT PRIMARY KEY,
+			last_rowid INTEGER NOT NULL,
+			last_synced REAL NOT NULL
+		);`,
+		// Blessed commands, either added locally or synced from the team library
+		`CREATE TABLE IF NOT EXISTS snippets (
+			origin TEXT NOT NULL,
+			name TEXT NOT NULL,
+			command TEXT NOT NULL,
+			description TEXT NOT NULL DEFAULT '',
+			updated_at REAL NOT NULL,
+			PRIMARY KEY (origin, name)
+		);`,
+		// Raw LLM responses keyed by model and a hash of the prompt
+		`CREATE TABLE IF NOT EXISTS llm_cache (
+			model TEXT NOT NULL,
+			prompt_hash TEXT NOT NULL,
+			response TEXT NOT NULL,
+			created_at REAL NOT NULL,
+			PRIMARY KEY (model, prompt_hash)
+		);`,
+		// One row per wizard request, for usage and cost accounting
+		`CREATE TABLE IF NOT EXISTS wizard_log (
+			timestamp REAL NOT NULL,
+			model TEXT NOT NULL,
+			mode TEXT NOT NULL,
+			source TEXT NOT NULL,
+			query TEXT NOT NULL,
+			command TEXT NOT NULL DEFAULT '',
+			prompt_tokens INTEGER NOT NULL DEFAULT 0,
+			completion_tokens INTEGER NOT NULL DEFAULT 0,
+			latency_ms INTEGER NOT NULL DEFAULT 0
+		);`,
+		`CREATE INDEX IF NOT EXISTS idx_wizard_log_timestamp ON wizard_log(timestamp);`,
+		// Circuit breaker state of LLM endpoints that have been failing
+		`CREATE TABLE IF NOT EXISTS llm_endpoints (
+			endpoint TEXT PRIMARY KEY,
+			failures INTEGER NOT NULL,
+			open_until REAL NOT NULL,
+			last_error TEXT NOT NULL
+		);`,
+		// Settings the data was written under, such as how wizard cache keys are normalized
+		`CREATE TABLE IF NOT EXISTS meta (
+			key TEXT PRIMARY KEY,
+			value TEXT NOT NULL
+		);`,
+		// One row per history file per collect, for `zist stats --collections`
+		`CREATE TABLE IF NOT EXISTS collect_runs (
+			timestamp REAL NOT NULL,
+			source TEXT NOT NULL,
+			parsed INTEGER NOT NULL,
+			inserted INTEGER NOT NULL,
+			ignored INTEGER NOT NULL,
+			duration_ms INTEGER NOT NULL,
+			error TEXT NOT NULL DEFAULT ''
+		);`,
+		`CREATE INDEX IF NOT EXISTS idx_collect_runs_source ON collect_runs(source, timestamp);`,
+		`CREATE INDEX IF NOT EXISTS idx_collect_runs_timestamp ON collect_runs(timestamp);`,
+		// Full text of commands stored cut to --max-command-length, keyed like commands
+		`CREATE TABLE IF NOT EXISTS command_overflow (
+			source TEXT NOT NULL,
+			timestamp REAL NOT NULL,
+			command TEXT NOT NULL,
+			PRIMARY KEY (source, timestamp)
+		);`,
+		// References attached with `zist link`, keyed like commands
+		`CREATE TABLE IF NOT EXISTS links (
+			source TEXT NOT NULL,
+			timestamp REAL NOT NULL,
+			link TEXT NOT NULL,
+			created_at REAL NOT NULL,
+			PRIMARY KEY (source, timestamp, link)
+		);`,
+		// Vectors of distinct commands for `zist search --semantic`, one set per embedding model
+		`CREATE TABLE IF NOT EXISTS embeddings (
+			command TEXT NOT NULL,
+			model TEXT NOT NULL,
+			vector BLOB NOT NULL,
+			created_at REAL NOT NULL,
+			PRIMARY KEY (command, model)
+		);`,
+		`CREATE INDEX IF NOT EXISTS idx_embeddings_created ON embeddings(model, created_at);`,
+		// Nearest neighbor graph over the embeddings of a model, by embeddings rowid
+		`CREATE TABLE IF NOT EXISTS ann_graph (
+			model TEXT NOT NULL,
+			node INTEGER NOT NULL,
+			level INTEGER NOT NULL,
+			neighbors BLOB NOT NULL,
+			PRIMARY KEY (model, node, level)
+		);`,
+		// How often each command followed another, by directory, for `zist predict`.
+		// An empty prev counts commands run in dir, an empty dir those after prev anywhere.
+		`CREATE TABLE IF NOT EXISTS transitions (
+			prev TEXT NOT NULL,
+			dir TEXT NOT NULL,
+			next TEXT NOT NULL,
+			count INTEGER NOT NULL,
+			last_seen REAL NOT NULL,
+			PRIMARY KEY (prev, dir, next)
+		);`,
+		// Where each history was after the last command counted into transitions
+		`CREATE TABLE IF NOT EXISTS predict_state (
+			source TEXT PRIMARY KEY,
+			command TEXT NOT NULL,
+			dir TEXT NOT NULL,
+			prev_dir TEXT NOT NULL,
+			timestamp REAL NOT NULL
+		);`,
+		// Seconds added to the timestamps of a source whose machine has a wrong clock
+		`CREATE TABLE IF NOT EXISTS clock_offsets (
+			source TEXT PRIMARY KEY,
+			offset REAL NOT NULL
+		);`,
 	}
 
 	for _, query := range queries {
@@ -94,34 +270,126 @@ func CreateSchema(db *sql.DB) error {
 		}
 	}
 
+	return migrateSchema(db)
+}
+
+// migrations alter the base schema in order. PRAGMA user_version records how
+// many have been applied, so append new entries and never reorder them.
+var migrations = []string{
+	`ALTER TABLE commands ADD COLUMN env_prefix TEXT NOT NULL DEFAULT ''`,
+	// Key wizard cache entries by project root; '' is the global namespace
+	`CREATE TABLE wizard_cache_v2 (
+		project TEXT NOT NULL DEFAULT '',
+		query_normalized TEXT NOT NULL,
+		query_original TEXT NOT NULL,
+		command TEXT NOT NULL,
+		run_count INTEGER DEFAULT 1,
+		last_used REAL NOT NULL,
+		created_at REAL NOT NULL,
+		PRIMARY KEY (project, query_normalized)
+	);
+	INSERT INTO wizard_cache_v2 (query_normalized, query_original, command, run_count, last_used, created_at)
+		SELECT query_normalized, query_original, command, run_count, last_used, created_at FROM wizard_cache;
+	DROP TABLE wizard_cache;
+	ALTER TABLE wizard_cache_v2 RENAME TO wizard_cache;
+	CREATE INDEX idx_wizard_last_used ON wizard_cache(last_used DESC);
+	CREATE INDEX idx_wizard_run_count ON wizard_cache(run_count DESC);`,
+	// Count how often a search pick was run; only edits to the command text
+	// need reindexing
+	`ALTER TABLE commands ADD COLUMN picked_count INTEGER NOT NULL DEFAULT 0;
+	DROP TRIGGER commands_au;
+	CREATE TRIGGER commands_au AFTER UPDATE OF command ON commands BEGIN
+		INSERT INTO commands_fts(commands_fts, rowid, command) VALUES ('delete', old.rowid, old.command);
+		INSERT INTO commands_fts(rowid, command) VALUES (new.rowid, new.command);
+	END;`,
+	// Order of commands within their source, so ones run in the same second
+	// sort in the order they ran. Rows so far were inserted in file order.
+	`ALTER TABLE commands ADD COLUMN seq INTEGER NOT NULL DEFAULT 0;
+	UPDATE commands SET seq = rowid;
+	CREATE INDEX idx_second_seq ON commands(CAST(timestamp AS INTEGER) DESC, seq DESC);`,
+	// Multi-line commands were stored with the backslash zsh writes before
+	// each newline; drop it as the parser now does. commands_au reindexes.
+	`UPDATE commands SET command = replace(command, '\' || char(10), char(10))
+		WHERE instr(command, '\' || char(10)) > 0;`,
+	// Where collect resumes parsing each history file; 0 parses it whole
+	`ALTER TABLE sources ADD COLUMN resume_offset INTEGER NOT NULL DEFAULT 0;`,
+	// How many commands of each recent second come before resume_offset, so
+	// a resumed collect numbers the commands sharing a second as a full one
+	// does. Resume points without them are dropped, parsing each file whole
+	// once.
+	`ALTER TABLE sources ADD COLUMN resume_subseconds TEXT NOT NULL DEFAULT '';
+	UPDATE sources SET resume_offset = 0;`,
+}
+
+func migrateSchema(db *sql.DB) error {
+	var version int
+	if err := db.QueryRow("PRAGMA user_version").Scan(&version); err != nil {
+		return fmt.Errorf("failed to read schema version: %w", err)
+	}
+
+	for i := version; i < len(migrations); i++ {
+		tx, err := db.Begin()
+		if err != nil {
+			return fmt.Errorf("failed to begin migration %d: %w", i+1, err)
+		}
+		if _, err := tx.Exec(migrations[i]); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("failed to apply migration %d: %w", i+1, err)
+		}
+		if _, err := tx.Exec(fmt.Sprintf("PRAGMA user_version = %d", i+1)); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("failed to record migration %d: %w", i+1, err)
+		}
+		if err := tx.Commit(); err != nil {
+			return fmt.Errorf("failed to commit migration %d: %w", i+1, err)
+		}
+	}
+
 	return nil
 }
 
-func InsertCommands(db *sql.DB, commands []Command) (int, int, error) {
+// SchemaVersion returns the number of migrations applied to the database
+func SchemaVersion(db *sql.DB) (int, error) {
+	var version int
+	if err := db.QueryRow("PRAGMA user_version").Scan(&version); err != nil {
+		return 0, fmt.Errorf("failed to read schema version: %w", err)
+	}
+	return version, nil
+}
+
+func InsertCommands(ctx context.Context, db *sql.DB, commands []Command) (int, int, error) {
 	if len(commands) == 0 {
 		return 0, 0, nil
 	}
 
-	tx, err := db.Begin()
+	tx, err := db.BeginTx(ctx, nil)
 	if err != nil {
 		return 0, 0, fmt.Errorf("failed to begin transaction: %w", err)
 	}
 	defer tx.Rollback()
 
 	// FTS index is updated automatically via triggers
-	insertSQL := `INSERT OR IGNORE INTO commands (source, timestamp, command, duration, cwd, exit_code)
-	              VALUES (?, ?, ?, ?, ?, ?)`
+	insertSQL := `INSERT OR IGNORE INTO commands (source, timestamp, command, duration, cwd, exit_code, env_prefix, seq)
+	              VALUES (?, ?, ?, ?, ?, ?, ?, ?)`
 
-	stmt, err := tx.Prepare(insertSQL)
+	stmt, err := tx.PrepareContext(ctx, insertSQL)
 	if err != nil {
 		return 0, 0, fmt.Errorf("failed to prepare insert statement: %w", err)
 	}
 	defer stmt.Close()
 
+	overflowStmt, err := tx.PrepareContext(ctx, `INSERT OR REPLACE INTO command_overflow (source, timestamp, command) VALUES (?, ?, ?)`)
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to prepare overflow statement: %w", err)
+	}
+	defer overflowStmt.Close()
+
 	inserted := 0
 
 	for _, cmd := range commands {
-		result, err := stmt.Exec(cmd.Source, cmd.Timestamp, cmd.Command, cmd.Duration, cmd.CWD, cmd.ExitCode)
+		// Huge commands, usually pasted by accident, would bloat the FTS index
+		command, truncated := truncateCommand(cmd.Command, maxCommandLength)
+		result, err := stmt.ExecContext(ctx, cmd.Source, cmd.Timestamp, command, cmd.Duration, cmd.CWD, cmd.ExitCode, cmd.EnvPrefix, cmd.Seq)
 		if err != nil {
 			return 0, 0, fmt.Errorf("failed to insert command: %w", err)
 		}
@@ -133,6 +401,11 @@ func InsertCommands(db *sql.DB, commands []Command) (int, int, error) {
 
 		if rowsAffected > 0 {
 			inserted++
+			if truncated {
+				if _, err := overflowStmt.ExecContext(ctx, cmd.Source, cmd.Timestamp, cmd.Command); err != nil {
+					return 0, 0, fmt.Errorf("failed to store full command: %w", err)
+				}
+			}
 		}
 	}
 
@@ -143,7 +416,7 @@ func InsertCommands(db *sql.DB, commands []Command) (int, int, error) {
 	return inserted, len(commands) - inserted, nil
 }
 
-func InsertCommandsBatch(db *sql.DB, commands []Command, batchSize int) (int, int, error) {
+func InsertCommandsBatch(ctx context.Context, db *sql.DB, commands []Command, batchSize int) (int, int, error) {
 	if len(commands) == 0 {
 		return 0, 0, nil
 	}
@@ -162,7 +435,7 @@ func InsertCommandsBatch(db *sql.DB, commands []Command, batchSize int) (int, in
 		}
 
 		batch := commands[i:end]
-		inserted, ignored, err := InsertCommands(db, batch)
+		inserted, ignored, err := InsertCommands(ctx, db, batch)
 		if err != nil {
 			return totalInserted, totalIgnored, fmt.Errorf("failed to insert batch %d-%d: %w", i, end-1, err)
 		}
@@ -174,21 +447,30 @@ func InsertCommandsBatch(db *sql.DB, commands []Command, batchSize int) (int, in
 	return totalInserted, totalIgnored, nil
 }
 
-func GetDBStats(db *sql.DB) (map[string]int64, error) {
+// MaxSeq returns the highest seq stored for a source, 0 if it has none
+func MaxSeq(ctx context.Context, db *sql.DB, source string) (int64, error) {
+	var seq int64
+	if err := db.QueryRowContext(ctx, `SELECT COALESCE(MAX(seq), 0) FROM commands WHERE source = ?`, source).Scan(&seq); err != nil {
+		return 0, fmt.Errorf("failed to read sequence of %s: %w", source, err)
+	}
+	return seq, nil
+}
+
+func GetDBStats(ctx context.Context, db *sql.DB) (map[string]int64, error) {
 	stats := make(map[string]int64)
 
 	var count int64
-	if err := db.QueryRow("SELECT COUNT(*) FROM commands").Scan(&count); err != nil {
+	if err := db.QueryRowContext(ctx, "SELECT COUNT(*) FROM commands").Scan(&count); err != nil {
 		return nil, fmt.Errorf("failed to count commands: %w", err)
 	}
 	stats["total_commands"] = count
 
-	if err := db.QueryRow("SELECT COUNT(DISTINCT source) FROM commands").Scan(&count); err != nil {
+	if err := db.QueryRowContext(ctx, "SELECT COUNT(DISTINCT source) FROM commands").Scan(&count); err != nil {
 		return nil, fmt.Errorf("failed to count sources: %w", err)
 	}
 	stats["total_sources"] = count
 
-	rows, err := db.Query("SELECT source, COUNT(*) as count FROM commands GROUP BY source ORDER BY count DESC")
+	rows, err := db.QueryContext(ctx, "SELECT source, COUNT(*) as count FROM commands GROUP BY source ORDER BY count DESC")
 	if err != nil {
 		return nil, fmt.Errorf("failed to query source stats: %w", err)
 	}
@@ -206,62 +488,192 @@ func GetDBStats(db *sql.DB) (map[string]int64, error) {
 	return stats, nil
 }
 
+// SourceCount is the number of commands collected from one source
+type SourceCount struct {
+	Source string `json:"source"`
+	Count  int64  `json:"count"`
+}
+
+// GetSourceCounts returns the number of commands per source, largest first
+func GetSourceCounts(ctx context.Context, db *sql.DB) ([]SourceCount, error) {
+	rows, err := db.QueryContext(ctx, "SELECT source, COUNT(*) as count FROM commands GROUP BY source ORDER BY count DESC")
+	if err != nil {
+		return nil, fmt.Errorf("failed to query source counts: %w", err)
+	}
+	defer rows.Close()
+
+	var counts []SourceCount
+	for rows.Next() {
+		var c SourceCount
+		if err := rows.Scan(&c.Source, &c.Count); err != nil {
+			return nil, fmt.Errorf("failed to scan source count: %w", err)
+		}
+		counts = append(counts, c)
+	}
+	return counts, rows.Err()
+}
+
+// DayCount is the number of commands run on one day (YYYY-MM-DD, local time)
+type DayCount struct {
+	Day   string `json:"day"`
+	Count int64  `json:"count"`
+}
+
+// GetDailyCounts returns the number of commands per day since a unix time
+func GetDailyCounts(ctx context.Context, db *sql.DB, since float64) ([]DayCount, error) {
+	rows, err := db.QueryContext(ctx, `SELECT date(timestamp, 'unixepoch', 'localtime') AS day, COUNT(*)
+		FROM commands WHERE timestamp >= ?
+		GROUP BY day ORDER BY day`, since)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query daily counts: %w", err)
+	}
+	defer rows.Close()
+
+	var counts []DayCount
+	for rows.Next() {
+		var c DayCount
+		if err := rows.Scan(&c.Day, &c.Count); err != nil {
+			return nil, fmt.Errorf("failed to scan daily count: %w", err)
+		}
+		counts = append(counts, c)
+	}
+	return counts, rows.Err()
+}
+
 type SearchResult struct {
+	ID        int64 // Rowid in the main database, 0 for archived commands
 	Command   string
 	Source    string
 	Timestamp float64
+	EnvPrefix string // Leading VAR=value assignments split off at collect time
+	Note      string
+	Links     []string // Attached with `zist link`, oldest first
+	Badge     string   // Shown before the command in the picker, e.g. [team] for snippets
+	Picked    int      // Times this row was picked in search and run
+}
+
+// FullCommand returns the command as it was typed, including any env prefix
+func (r SearchResult) FullCommand() string {
+	if r.EnvPrefix == "" {
+		return r.Command
+	}
+	return r.EnvPrefix + " " + r.Command
 }
 
 type SearchOptions struct {
-	Query string
-	Limit int
-	Since float64 // Unix timestamp, 0 means no filter
-	Until float64 // Unix timestamp, 0 means no filter
+	Query          string
+	Limit          int
+	Since          float64 // Unix timestamp, 0 means no filter
+	Until          float64 // Unix timestamp, 0 means no filter
+	Source         string  // Only commands whose source contains this, empty means no filter
+	IncludeArchive bool    // Also search the archive database
+	Sort           string  // SortRelevance (the default) or SortTime
+	Exact          bool    // Match query words whole rather than as prefixes
+	CaseSensitive  bool    // Query words must appear in the command in the same case
+	Semantic       bool    // Rank by similarity of meaning to Query instead of shared words
 }
 
-func SearchCommands(db *sql.DB, opts SearchOptions) ([]SearchResult, error) {
-	var results []SearchResult
+// Orders for search results with a text query. Without one, both are newest first.
+const (
+	// SortRelevance ranks by bm25 score, fading over relevanceHalfLife
+	SortRelevance = "relevance"
+	// SortTime is newest first
+	SortTime = "time"
+)
 
+// relevanceHalfLife is the age, in seconds, at which a match counts half as
+// much as the same match today
+const relevanceHalfLife = 365 * 24 * 60 * 60
+
+// SearchCommands returns the commands matching opts, best first. With
+// opts.IncludeArchive the archive must be attached with AttachArchive;
+// SearchWithArchive searches it without.
+func SearchCommands(ctx context.Context, db *sql.DB, opts SearchOptions) ([]SearchResult, error) {
+	opts, err := searchDefaults(opts)
+	if err != nil {
+		return nil, err
+	}
+	now := float64(time.Now().Unix())
+
+	hot, err := searchSchema(ctx, db, "main", opts, now)
+	if err != nil || !opts.IncludeArchive {
+		return resultsOf(hot), err
+	}
+	// The archive is attached to the pool's only connection, so its half
+	// waits for the main one
+	cold, err := searchSchema(ctx, db, ArchiveSchema, opts, now)
+	if err != nil {
+		return nil, err
+	}
+	return mergeSearchResults(opts.Limit, hot, cold), nil
+}
+
+// searchDefaults fills in the limit and sort of a search and checks the sort
+func searchDefaults(opts SearchOptions) (SearchOptions, error) {
 	if opts.Limit <= 0 {
 		opts.Limit = 500
 	}
+	if opts.Sort == "" {
+		opts.Sort = SortRelevance
+	}
+	if opts.Sort != SortRelevance && opts.Sort != SortTime {
+		return opts, fmt.Errorf("unknown sort %q (use %s or %s)", opts.Sort, SortRelevance, SortTime)
+	}
+	return opts, nil
+}
 
-	var queryBuilder strings.Builder
-	var args []interface{}
+// rankedResult is a search result with what places it among the results
+// from another database
+type rankedResult struct {
+	SearchResult
+	rank float64 // bm25 score faded by age as of the search, 0 unless ranking by relevance
+	seq  int64
+}
 
-	queryBuilder.WriteString("SELECT command, source, timestamp FROM commands WHERE 1=1")
+// queryer is what searchSchema runs on: a pool, or one connection the
+// archive is attached to
+type queryer interface {
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+}
 
-	// FTS filter
-	if opts.Query != "" {
-		ftsQuery := buildFTSQuery(opts.Query)
-		queryBuilder.WriteString(" AND rowid IN (SELECT rowid FROM commands_fts WHERE commands_fts MATCH ?)")
-		args = append(args, ftsQuery)
-	}
+// searchSchema returns up to opts.Limit matches from one schema's commands,
+// best first, ranking relevance as of now
+func searchSchema(ctx context.Context, q queryer, schema string, opts SearchOptions, now float64) ([]rankedResult, error) {
+	var filter strings.Builder
+	filterArgs := writeSearchFilter(&filter, nil, schema, opts)
 
-	// Time range filters
-	if opts.Since > 0 {
-		queryBuilder.WriteString(" AND timestamp >= ?")
-		args = append(args, opts.Since)
-	}
-	if opts.Until > 0 {
-		queryBuilder.WriteString(" AND timestamp <= ?")
-		args = append(args, opts.Until)
+	var queryBuilder strings.Builder
+	var args []interface{}
+	queryBuilder.WriteString("SELECT r.id, r.command, r.source, r.timestamp, r.env_prefix, r.picked_count, COALESCE(n.note, ''), " + linksColumn(schema, "r"))
+	if opts.Query != "" && opts.Sort == SortRelevance {
+		// Scores are negative, better matches more so; age brings them
+		// towards zero
+		queryBuilder.WriteString(", r.score / (1.0 + max(? - r.timestamp, 0) / ?) AS rank")
+		args = append(args, now, relevanceHalfLife)
+	} else {
+		queryBuilder.WriteString(", 0 AS rank")
 	}
+	queryBuilder.WriteString(", r.seq FROM (" + filter.String() + ") r LEFT JOIN " + schema + ".notes n ON n.source = r.source AND n.timestamp = r.timestamp")
+	args = append(args, filterArgs...)
 
-	queryBuilder.WriteString(" ORDER BY timestamp DESC LIMIT ?")
+	// Commands picked and run again before rank above ones merely run
+	queryBuilder.WriteString(" ORDER BY r.picked_count DESC, rank, CAST(r.timestamp AS INTEGER) DESC, r.seq DESC LIMIT ?")
 	args = append(args, opts.Limit)
 
-	rows, err := db.Query(queryBuilder.String(), args...)
+	rows, err := q.QueryContext(ctx, queryBuilder.String(), args...)
 	if err != nil {
 		return nil, fmt.Errorf("failed to search commands: %w", err)
 	}
 	defer rows.Close()
 
+	var results []rankedResult
 	for rows.Next() {
-		var result SearchResult
-		if err := rows.Scan(&result.Command, &result.Source, &result.Timestamp); err != nil {
+		var result rankedResult
+		var links string
+		if err := rows.Scan(&result.ID, &result.Command, &result.Source, &result.Timestamp, &result.EnvPrefix, &result.Picked, &result.Note, &links, &result.rank, &result.seq); err != nil {
 			return nil, fmt.Errorf("failed to scan command: %w", err)
 		}
+		result.Links = splitLinks(links)
 		results = append(results, result)
 	}
 
@@ -272,47 +684,173 @@ func SearchCommands(db *sql.DB, opts SearchOptions) ([]SearchResult, error) {
 	return results, nil
 }
 
-func buildFTSQuery(query string) string {
-	query = strings.TrimSpace(query)
-	if query == "" {
-		return ""
+// mergeSearchResults interleaves the results of the main and archive
+// databases the way searchSchema orders each, keeping the best limit
+func mergeSearchResults(limit int, hot, cold []rankedResult) []SearchResult {
+	merged := append(slices.Clip(hot), cold...)
+	slices.SortStableFunc(merged, func(a, b rankedResult) int {
+		return cmp.Or(
+			cmp.Compare(b.Picked, a.Picked),
+			cmp.Compare(a.rank, b.rank),
+			cmp.Compare(int64(b.Timestamp), int64(a.Timestamp)),
+			cmp.Compare(b.seq, a.seq),
+		)
+	})
+	return resultsOf(merged[:min(len(merged), limit)])
+}
+
+// resultsOf drops the ranking of results
+func resultsOf(ranked []rankedResult) []SearchResult {
+	var results []SearchResult
+	for _, r := range ranked {
+		results = append(results, r.SearchResult)
 	}
+	return results
+}
 
-	parts := strings.Fields(query)
-	for i, part := range parts {
-		parts[i] = escapeFTS(part) + "*"
+// writeSearchFilter writes the filtered SELECT over one schema's commands
+// table and returns args extended with its parameters
+func writeSearchFilter(sb *strings.Builder, args []interface{}, schema string, opts SearchOptions) []interface{} {
+	// Archived rowids are a separate namespace, so only main rows get an ID
+	id := "rowid"
+	if schema != "main" {
+		id = "0"
+	}
+	fmt.Fprintf(sb, "SELECT %s AS id, command, source, timestamp, seq, env_prefix, picked_count", id)
+	if opts.Query != "" && opts.Sort == SortRelevance {
+		// FTS5's rank column is the bm25 score; matches only in the note
+		// score 0, below any match in the command
+		fmt.Fprintf(sb, ", COALESCE((SELECT rank FROM %[1]s.commands_fts WHERE commands_fts MATCH ? AND rowid = %[1]s.commands.rowid), 0) AS score", schema)
+		args = append(args, buildFTSQuery(opts.Query, opts.Exact))
+	} else {
+		sb.WriteString(", 0 AS score")
+	}
+	fmt.Fprintf(sb, " FROM %s.commands WHERE 1=1", schema)
+
+	// FTS filter, matching either the command or its note
+	if opts.Query != "" {
+		ftsQuery := buildFTSQuery(opts.Query, opts.Exact)
+		fmt.Fprintf(sb, " AND (rowid IN (SELECT rowid FROM %s.commands_fts WHERE commands_fts MATCH ?)", schema)
+		fmt.Fprintf(sb, " OR (source, timestamp) IN (SELECT source, timestamp FROM %[1]s.notes WHERE rowid IN (SELECT rowid FROM %[1]s.notes_fts WHERE notes_fts MATCH ?)))", schema)
+		args = append(args, ftsQuery, ftsQuery)
+
+		// The index ignores case and punctuation, so check its candidates
+		// for the text as typed: -R, not -r
+		if opts.CaseSensitive {
+			for _, term := range splitQuery(opts.Query) {
+				sb.WriteString(" AND instr(command, ?) > 0")
+				args = append(args, term.text)
+			}
+		}
+	}
+
+	if opts.Source != "" {
+		sb.WriteString(" AND instr(source, ?) > 0")
+		args = append(args, opts.Source)
+	}
+
+	// Time range filters
+	if opts.Since > 0 {
+		sb.WriteString(" AND timestamp >= ?")
+		args = append(args, opts.Since)
+	}
+	if opts.Until > 0 {
+		sb.WriteString(" AND timestamp <= ?")
+		args = append(args, opts.Until)
+	}
+
+	return args
+}
+
+// RecordPick counts a run of a command picked in search against its most
+// recent row, matching the command as typed, with any env prefix. It reports
+// whether a row matched.
+func RecordPick(ctx context.Context, db *sql.DB, command string) (bool, error) {
+	result, err := db.ExecContext(ctx, `UPDATE commands SET picked_count = picked_count + 1 WHERE rowid = (
+		SELECT rowid FROM commands
+		WHERE command = ? OR (env_prefix != '' AND env_prefix || ' ' || command = ?)
+		ORDER BY timestamp DESC LIMIT 1)`, command, command)
+	if err != nil {
+		return false, fmt.Errorf("failed to record pick: %w", err)
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to get rows affected: %w", err)
+	}
+	return n > 0, nil
+}
+
+// buildFTSQuery turns a search query into an FTS5 query matching all of its
+// words as prefixes, or with exact as whole words. "Quoted phrases" match
+// those words in that order.
+func buildFTSQuery(query string, exact bool) string {
+	var parts []string
+	for _, term := range splitQuery(query) {
+		part := quoteFTS(term.text)
+		if !term.phrase && !exact {
+			part += "*"
+		}
+		parts = append(parts, part)
 	}
 	return strings.Join(parts, " ")
 }
 
-func escapeFTS(s string) string {
-	s = strings.ReplaceAll(s, "\"", "\"\"")
-	s = strings.ReplaceAll(s, "'", "''")
-	s = strings.ReplaceAll(s, "\\", "\\\\")
-	s = strings.ReplaceAll(s, "(", "")
-	s = strings.ReplaceAll(s, ")", "")
-	s = strings.ReplaceAll(s, ":", "")
-	return s
+// queryTerm is a word or quoted phrase of a search query
+type queryTerm struct {
+	text   string
+	phrase bool
+}
+
+// splitQuery splits query into words and "quoted phrases". An unterminated
+// quote runs to the end of the query.
+func splitQuery(query string) []queryTerm {
+	var terms []queryTerm
+	for {
+		before, after, quoted := strings.Cut(query, `"`)
+		for _, word := range strings.Fields(before) {
+			terms = append(terms, queryTerm{text: word})
+		}
+		if !quoted {
+			return terms
+		}
+		phrase, rest, _ := strings.Cut(after, `"`)
+		if phrase = strings.TrimSpace(phrase); phrase != "" {
+			terms = append(terms, queryTerm{text: phrase, phrase: true})
+		}
+		query = rest
+	}
+}
+
+// quoteFTS makes s an FTS5 string, so operators and punctuation in it, like
+// the dash in docker-compose, are just text. The tokenizer then splits it
+// into a phrase.
+func quoteFTS(s string) string {
+	return `"` + strings.ReplaceAll(s, `"`, `""`) + `"`
 }
 
 // FrequentCommand represents a command and its usage count
 type FrequentCommand struct {
-	Command string
-	Count   int
+	Command string `json:"command"`
+	Count   int    `json:"count"`
 }
 
-// SearchByPrefix returns commands starting with the given prefix (for history fallback)
-func SearchByPrefix(db *sql.DB, prefix string, limit int) ([]SearchResult, error) {
+// SearchByPrefix returns distinct commands starting with prefix, as typed
+// with any env prefix and matching case, most recently run first. The
+// prefix itself is left out, as cycling to it would change nothing.
+func SearchByPrefix(ctx context.Context, db *sql.DB, prefix string, limit int) ([]SearchResult, error) {
 	if limit <= 0 {
 		limit = 10
 	}
 
-	query := `SELECT command, source, timestamp FROM commands
-		WHERE command LIKE ? || '%'
-		ORDER BY timestamp DESC
-		LIMIT ?`
+	query := `SELECT full, source, max(timestamp) FROM (
+			SELECT CASE env_prefix WHEN '' THEN command ELSE env_prefix || ' ' || command END AS full, source, timestamp
+			FROM commands)
+		WHERE substr(full, 1, length(?1)) = ?1 AND full != ?1
+		GROUP BY full
+		ORDER BY max(timestamp) DESC
+		LIMIT ?2`
 
-	rows, err := db.Query(query, prefix, limit)
+	rows, err := db.QueryContext(ctx, query, prefix, limit)
 	if err != nil {
 		return nil, fmt.Errorf("failed to search by prefix: %w", err)
 	}
@@ -331,7 +869,7 @@ func SearchByPrefix(db *sql.DB, prefix string, limit int) ([]SearchResult, error
 }
 
 // GetFrequentCommands returns the most frequently used commands matching a pattern
-func GetFrequentCommands(db *sql.DB, pattern string, limit int) ([]FrequentCommand, error) {
+func GetFrequentCommands(ctx context.Context, db *sql.DB, pattern string, limit int) ([]FrequentCommand, error) {
 	if limit <= 0 {
 		limit = 10
 	}
@@ -354,7 +892,7 @@ func GetFrequentCommands(db *sql.DB, pattern string, limit int) ([]FrequentComma
 		args = []interface{}{limit}
 	}
 
-	rows, err := db.Query(query, args...)
+	rows, err := db.QueryContext(ctx, query, args...)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get frequent commands: %w", err)
 	}
@@ -373,16 +911,16 @@ func GetFrequentCommands(db *sql.DB, pattern string, limit int) ([]FrequentComma
 }
 
 // GetRecentCommands returns the last N commands globally
-func GetRecentCommands(db *sql.DB, limit int) ([]SearchResult, error) {
+func GetRecentCommands(ctx context.Context, db *sql.DB, limit int) ([]SearchResult, error) {
 	if limit <= 0 {
 		limit = 50
 	}
 
-	query := `SELECT command, source, timestamp FROM commands
-		ORDER BY timestamp DESC
+	query := `SELECT command, source, timestamp, env_prefix FROM commands
+		ORDER BY CAST(timestamp AS INTEGER) DESC, seq DESC
 		LIMIT ?`
 
-	rows, err := db.Query(query, limit)
+	rows, err := db.QueryContext(ctx, query, limit)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get recent commands: %w", err)
 	}
@@ -391,7 +929,7 @@ func GetRecentCommands(db *sql.DB, limit int) ([]SearchResult, error) {
 	var results []SearchResult
 	for rows.Next() {
 		var result SearchResult
-		if err := rows.Scan(&result.Command, &result.Source, &result.Timestamp); err != nil {
+		if err := rows.Scan(&result.Command, &result.Source, &result.Timestamp, &result.EnvPrefix); err != nil {
 			return nil, fmt.Errorf("failed to scan result: %w", err)
 		}
 		results = append(results, result)
@@ -402,28 +940,26 @@ func GetRecentCommands(db *sql.DB, limit int) ([]SearchResult, error) {
 
 // WizardCacheEntry represents a cached query→command mapping
 type WizardCacheEntry struct {
-	QueryNormalized string
-	QueryOriginal   string
-	Command         string
-	RunCount        int
-	LastUsed        float64
-	CreatedAt       float64
-}
-
-// NormalizeQuery normalizes a query for cache lookup (lowercase, trim whitespace)
-func NormalizeQuery(query string) string {
-	return strings.ToLower(strings.TrimSpace(query))
+	Project         string  `json:"project,omitempty"` // Project root, empty for global entries
+	QueryNormalized string  `json:"query_normalized"`
+	QueryOriginal   string  `json:"query"`
+	Command         string  `json:"command"`
+	RunCount        int     `json:"run_count"`
+	LastUsed        float64 `json:"last_used"`
+	CreatedAt       float64 `json:"created_at"`
 }
 
-// GetWizardCache looks up a cached command for the given query
-func GetWizardCache(db *sql.DB, query string) (*WizardCacheEntry, error) {
+// GetWizardCache looks up a cached command for the given query. With a
+// project, an entry cached for that project wins over the global one.
+func GetWizardCache(ctx context.Context, db *sql.DB, project, query string) (*WizardCacheEntry, error) {
 	normalized := NormalizeQuery(query)
 
-	row := db.QueryRow(`SELECT query_normalized, query_original, command, run_count, last_used, created_at
-		FROM wizard_cache WHERE query_normalized = ?`, normalized)
+	row := db.QueryRowContext(ctx, `SELECT project, query_normalized, query_original, command, run_count, last_used, created_at
+		FROM wizard_cache WHERE query_normalized = ? AND project IN (?, '')
+		ORDER BY project = '' LIMIT 1`, normalized, project)
 
 	var entry WizardCacheEntry
-	err := row.Scan(&entry.QueryNormalized, &entry.QueryOriginal, &entry.Command,
+	err := row.Scan(&entry.Project, &entry.QueryNormalized, &entry.QueryOriginal, &entry.Command,
 		&entry.RunCount, &entry.LastUsed, &entry.CreatedAt)
 	if err == sql.ErrNoRows {
 		return nil, nil
@@ -435,18 +971,19 @@ func GetWizardCache(db *sql.DB, query string) (*WizardCacheEntry, error) {
 	return &entry, nil
 }
 
-// SetWizardCache stores or updates a query→command mapping
-func SetWizardCache(db *sql.DB, query, command string) error {
+// SetWizardCache stores or updates a query→command mapping for a project,
+// or globally when project is empty
+func SetWizardCache(ctx context.Context, db *sql.DB, project, query, command string) error {
 	normalized := NormalizeQuery(query)
 	now := float64(time.Now().Unix())
 
-	_, err := db.Exec(`INSERT INTO wizard_cache (query_normalized, query_original, command, run_count, last_used, created_at)
-		VALUES (?, ?, ?, 1, ?, ?)
-		ON CONFLICT(query_normalized) DO UPDATE SET
+	_, err := db.ExecContext(ctx, `INSERT INTO wizard_cache (project, query_normalized, query_original, command, run_count, last_used, created_at)
+		VALUES (?, ?, ?, ?, 1, ?, ?)
+		ON CONFLICT(project, query_normalized) DO UPDATE SET
 			command = excluded.command,
 			run_count = run_count + 1,
 			last_used = excluded.last_used`,
-		normalized, query, command, now, now)
+		project, normalized, query, command, now, now)
 
 	if err != nil {
 		return fmt.Errorf("failed to set wizard cache: %w", err)
@@ -456,12 +993,12 @@ func SetWizardCache(db *sql.DB, query, command string) error {
 }
 
 // ListWizardCache returns all cached mappings, ordered by most recently used
-func ListWizardCache(db *sql.DB, limit int) ([]WizardCacheEntry, error) {
+func ListWizardCache(ctx context.Context, db *sql.DB, limit int) ([]WizardCacheEntry, error) {
 	if limit <= 0 {
 		limit = 50
 	}
 
-	rows, err := db.Query(`SELECT query_normalized, query_original, command, run_count, last_used, created_at
+	rows, err := db.QueryContext(ctx, `SELECT project, query_normalized, query_original, command, run_count, last_used, created_at
 		FROM wizard_cache ORDER BY last_used DESC LIMIT ?`, limit)
 	if err != nil {
 		return nil, fmt.Errorf("failed to list wizard cache: %w", err)
@@ -471,7 +1008,7 @@ func ListWizardCache(db *sql.DB, limit int) ([]WizardCacheEntry, error) {
 	var entries []WizardCacheEntry
 	for rows.Next() {
 		var entry WizardCacheEntry
-		if err := rows.Scan(&entry.QueryNormalized, &entry.QueryOriginal, &entry.Command,
+		if err := rows.Scan(&entry.Project, &entry.QueryNormalized, &entry.QueryOriginal, &entry.Command,
 			&entry.RunCount, &entry.LastUsed, &entry.CreatedAt); err != nil {
 			return nil, fmt.Errorf("failed to scan wizard cache entry: %w", err)
 		}
@@ -481,9 +1018,85 @@ func ListWizardCache(db *sql.DB, limit int) ([]WizardCacheEntry, error) {
 	return entries, rows.Err()
 }
 
+// GetWizardExamples returns the most run cached mappings for project and
+// global ones, to show the LLM the user's style. Only single-line commands of
+// at most maxExampleCommand bytes, run at least minRuns times, qualify.
+func GetWizardExamples(ctx context.Context, db *sql.DB, project string, minRuns, limit int) ([]WizardCacheEntry, error) {
+	rows, err := db.QueryContext(ctx, `SELECT project, query_normalized, query_original, command, run_count, last_used, created_at
+		FROM wizard_cache
+		WHERE project IN (?, '') AND run_count >= ? AND instr(command, char(10)) = 0 AND length(command) <= ?
+		ORDER BY run_count DESC, last_used DESC LIMIT ?`, project, minRuns, maxExampleCommand, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get wizard examples: %w", err)
+	}
+	defer rows.Close()
+
+	var entries []WizardCacheEntry
+	for rows.Next() {
+		var entry WizardCacheEntry
+		if err := rows.Scan(&entry.Project, &entry.QueryNormalized, &entry.QueryOriginal, &entry.Command,
+			&entry.RunCount, &entry.LastUsed, &entry.CreatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan wizard cache entry: %w", err)
+		}
+		entries = append(entries, entry)
+	}
+
+	return entries, rows.Err()
+}
+
+// SearchWizardCache finds cached mappings run at least minRuns times whose
+// query or command contains every word of query, most run first
+func SearchWizardCache(ctx context.Context, db *sql.DB, query string, minRuns, limit int) ([]WizardCacheEntry, error) {
+	var sb strings.Builder
+	args := []interface{}{minRuns}
+
+	sb.WriteString(`SELECT project, query_normalized, query_original, command, run_count, last_used, created_at
+		FROM wizard_cache WHERE run_count >= ?`)
+	for _, word := range strings.Fields(query) {
+		sb.WriteString(" AND instr(lower(query_original || ' ' || command), ?) > 0")
+		args = append(args, strings.ToLower(word))
+	}
+	sb.WriteString(" ORDER BY run_count DESC, last_used DESC LIMIT ?")
+	args = append(args, limit)
+
+	rows, err := db.QueryContext(ctx, sb.String(), args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to search wizard cache: %w", err)
+	}
+	defer rows.Close()
+
+	var entries []WizardCacheEntry
+	for rows.Next() {
+		var entry WizardCacheEntry
+		if err := rows.Scan(&entry.Project, &entry.QueryNormalized, &entry.QueryOriginal, &entry.Command,
+			&entry.RunCount, &entry.LastUsed, &entry.CreatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan wizard cache entry: %w", err)
+		}
+		entries = append(entries, entry)
+	}
+
+	return entries, rows.Err()
+}
+
+// SearchResult converts the mapping into a picker row. The badge carries the
+// natural language query, so it can be fuzzy-found alongside commands.
+func (e WizardCacheEntry) SearchResult() SearchResult {
+	source := "wizard"
+	if e.Project != "" {
+		source += ":" + e.Project
+	}
+	return SearchResult{
+		Command:   e.Command,
+		Source:    source,
+		Timestamp: e.LastUsed,
+		Note:      fmt.Sprintf("Wizard query: %s (run %d times)", e.QueryOriginal, e.RunCount),
+		Badge:     "[wizard: " + strings.ReplaceAll(e.QueryOriginal, "\t", " ") + "]",
+	}
+}
+
 // ClearWizardCache removes all cached mappings
-func ClearWizardCache(db *sql.DB) error {
-	_, err := db.Exec(`DELETE FROM wizard_cache`)
+func ClearWizardCache(ctx context.Context, db *sql.DB) error {
+	_, err := db.ExecContext(ctx, `DELETE FROM wizard_cache`)
 	if err != nil {
 		return fmt.Errorf("failed to clear wizard cache: %w", err)
 	}
@@ -491,18 +1104,112 @@ func ClearWizardCache(db *sql.DB) error {
 }
 
 // DeleteWizardCacheEntry removes a specific cached mapping
-func DeleteWizardCacheEntry(db *sql.DB, query string) error {
+func DeleteWizardCacheEntry(ctx context.Context, db *sql.DB, project, query string) error {
 	normalized := NormalizeQuery(query)
-	_, err := db.Exec(`DELETE FROM wizard_cache WHERE query_normalized = ?`, normalized)
+	_, err := db.ExecContext(ctx, `DELETE FROM wizard_cache WHERE project = ? AND query_normalized = ?`, project, normalized)
 	if err != nil {
 		return fmt.Errorf("failed to delete wizard cache entry: %w", err)
 	}
 	return nil
 }
 
-// SearchHistoryByKeywords searches history for commands containing the given keywords
-// Uses AND for multiple keywords to get more relevant results
-func SearchHistoryByKeywords(db *sql.DB, keywords []string, limit int) ([]SearchResult, error) {
+// ExportWizardCache returns every cached mapping, most run first
+func ExportWizardCache(ctx context.Context, db *sql.DB) ([]WizardCacheEntry, error) {
+	rows, err := db.QueryContext(ctx, `SELECT project, query_normalized, query_original, command, run_count, last_used, created_at
+		FROM wizard_cache ORDER BY run_count DESC, last_used DESC`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to export wizard cache: %w", err)
+	}
+	defer rows.Close()
+
+	entries := []WizardCacheEntry{}
+	for rows.Next() {
+		var entry WizardCacheEntry
+		if err := rows.Scan(&entry.Project, &entry.QueryNormalized, &entry.QueryOriginal, &entry.Command,
+			&entry.RunCount, &entry.LastUsed, &entry.CreatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan wizard cache entry: %w", err)
+		}
+		entries = append(entries, entry)
+	}
+
+	return entries, rows.Err()
+}
+
+// ImportWizardCache merges exported mappings into the cache and returns how
+// many were added and how many changed existing ones. Where both have a
+// mapping for a query, the more recently used command wins and the higher
+// run count is kept. Hand-written entries only need a query and command, and
+// win over existing mappings.
+func ImportWizardCache(ctx context.Context, db *sql.DB, entries []WizardCacheEntry) (added, updated int, err error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	now := float64(time.Now().Unix())
+	for i, e := range entries {
+		e.QueryNormalized = NormalizeQuery(e.QueryOriginal)
+		e.Command = strings.TrimSpace(e.Command)
+		if e.QueryNormalized == "" || e.Command == "" {
+			return 0, 0, fmt.Errorf("wizard cache entry %d: query and command are required", i+1)
+		}
+
+		cur := WizardCacheEntry{Project: e.Project, QueryNormalized: e.QueryNormalized}
+		err := tx.QueryRowContext(ctx, `SELECT query_original, command, run_count, last_used, created_at
+			FROM wizard_cache WHERE project = ? AND query_normalized = ?`, e.Project, e.QueryNormalized).
+			Scan(&cur.QueryOriginal, &cur.Command, &cur.RunCount, &cur.LastUsed, &cur.CreatedAt)
+		if err == sql.ErrNoRows {
+			e.RunCount = max(e.RunCount, 1)
+			if e.LastUsed <= 0 {
+				e.LastUsed = now
+			}
+			if e.CreatedAt <= 0 {
+				e.CreatedAt = e.LastUsed
+			}
+			if _, err := tx.ExecContext(ctx, `INSERT INTO wizard_cache (project, query_normalized, query_original, command, run_count, last_used, created_at)
+				VALUES (?, ?, ?, ?, ?, ?, ?)`,
+				e.Project, e.QueryNormalized, e.QueryOriginal, e.Command, e.RunCount, e.LastUsed, e.CreatedAt); err != nil {
+				return 0, 0, fmt.Errorf("failed to import wizard cache entry: %w", err)
+			}
+			added++
+			continue
+		}
+		if err != nil {
+			return 0, 0, fmt.Errorf("failed to look up wizard cache entry: %w", err)
+		}
+
+		next := cur
+		if e.LastUsed <= 0 || e.LastUsed > cur.LastUsed {
+			next.QueryOriginal, next.Command = e.QueryOriginal, e.Command
+		}
+		next.RunCount = max(cur.RunCount, e.RunCount)
+		next.LastUsed = max(cur.LastUsed, e.LastUsed)
+		if e.CreatedAt > 0 {
+			next.CreatedAt = min(cur.CreatedAt, e.CreatedAt)
+		}
+		if next == cur {
+			continue
+		}
+		if _, err := tx.ExecContext(ctx, `UPDATE wizard_cache SET query_original = ?, command = ?, run_count = ?, last_used = ?, created_at = ?
+			WHERE project = ? AND query_normalized = ?`,
+			next.QueryOriginal, next.Command, next.RunCount, next.LastUsed, next.CreatedAt, next.Project, next.QueryNormalized); err != nil {
+			return 0, 0, fmt.Errorf("failed to import wizard cache entry: %w", err)
+		}
+		updated++
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, 0, fmt.Errorf("failed to commit wizard cache import: %w", err)
+	}
+	return added, updated, nil
+}
+
+// SearchHistoryByKeywords searches history for commands containing the given
+// keywords. The full-text index ranks commands matching any keyword by bm25,
+// so those matching more and rarer keywords come first; substring matches,
+// such as "config" in kubeconfig, fill up the rest.
+func SearchHistoryByKeywords(ctx context.Context, db *sql.DB, keywords []string, limit int) ([]SearchResult, error) {
 	if len(keywords) == 0 || limit <= 0 {
 		return nil, nil
 	}
@@ -510,7 +1217,7 @@ func SearchHistoryByKeywords(db *sql.DB, keywords []string, limit int) ([]Search
 	// Filter to meaningful keywords (longer than 2 chars)
 	var filtered []string
 	for _, kw := range keywords {
-		if kw = strings.TrimSpace(kw); len(kw) > 2 {
+		if kw = strings.TrimSpace(kw); utf8.RuneCountInString(kw) > 2 {
 			filtered = append(filtered, kw)
 		}
 	}
@@ -519,23 +1226,60 @@ func SearchHistoryByKeywords(db *sql.DB, keywords []string, limit int) ([]Search
 		return nil, nil
 	}
 
-	// Build LIKE conditions - use AND for better relevance
+	terms := make([]string, len(filtered))
+	for i, kw := range filtered {
+		terms[i] = quoteFTS(kw) + "*"
+	}
+	// FTS5's rank column is the bm25 score, lower is better
+	results, err := queryKeywordMatches(ctx, db, `SELECT c.command, c.source, max(c.timestamp)
+		FROM commands_fts f JOIN commands c ON c.rowid = f.rowid
+		WHERE commands_fts MATCH ?
+		GROUP BY c.command
+		ORDER BY min(f.rank), COUNT(*) DESC, max(c.timestamp) DESC
+		LIMIT ?`, strings.Join(terms, " OR "), limit)
+	if err != nil || len(results) >= limit {
+		return results, err
+	}
+
+	// Substrings inside words are only found by scanning. Try AND first
+	// (more specific), then OR.
 	var conditions []string
 	var args []interface{}
 	for _, kw := range filtered {
 		conditions = append(conditions, "command LIKE ?")
 		args = append(args, "%"+kw+"%")
 	}
-
-	// Try AND first (more specific), fall back to OR if no results
-	query := fmt.Sprintf(`SELECT command, source, timestamp FROM commands
-		WHERE %s
-		GROUP BY command
-		ORDER BY COUNT(*) DESC, timestamp DESC
-		LIMIT ?`, strings.Join(conditions, " AND "))
 	args = append(args, limit)
+	found := make(map[string]bool)
+	for _, r := range results {
+		found[r.Command] = true
+	}
+	for _, op := range []string{" AND ", " OR "} {
+		more, err := queryKeywordMatches(ctx, db, fmt.Sprintf(`SELECT command, source, max(timestamp) FROM commands
+			WHERE %s
+			GROUP BY command
+			ORDER BY COUNT(*) DESC, max(timestamp) DESC
+			LIMIT ?`, strings.Join(conditions, op)), args...)
+		if err != nil {
+			return nil, err
+		}
+		for _, r := range more {
+			if !found[r.Command] && len(results) < limit {
+				found[r.Command] = true
+				results = append(results, r)
+			}
+		}
+		if len(results) >= limit || len(filtered) == 1 {
+			break
+		}
+	}
+
+	return results, nil
+}
 
-	rows, err := db.Query(query, args...)
+// queryKeywordMatches runs one of SearchHistoryByKeywords' queries
+func queryKeywordMatches(ctx context.Context, db *sql.DB, query string, args ...interface{}) ([]SearchResult, error) {
+	rows, err := db.QueryContext(ctx, query, args...)
 	if err != nil {
 		return nil, fmt.Errorf("failed to search history by keywords: %w", err)
 	}
@@ -549,35 +1293,5 @@ func SearchHistoryByKeywords(db *sql.DB, keywords []string, limit int) ([]Search
 		}
 		results = append(results, result)
 	}
-
-	// If AND returned no results and we have multiple keywords, try OR
-	if len(results) == 0 && len(filtered) > 1 {
-		args = args[:0]
-		for _, kw := range filtered {
-			args = append(args, "%"+kw+"%")
-		}
-		args = append(args, limit)
-
-		query = fmt.Sprintf(`SELECT command, source, timestamp FROM commands
-			WHERE %s
-			GROUP BY command
-			ORDER BY COUNT(*) DESC, timestamp DESC
-			LIMIT ?`, strings.Join(conditions, " OR "))
-
-		rows2, err := db.Query(query, args...)
-		if err != nil {
-			return nil, fmt.Errorf("failed to search history by keywords: %w", err)
-		}
-		defer rows2.Close()
-
-		for rows2.Next() {
-			var result SearchResult
-			if err := rows2.Scan(&result.Command, &result.Source, &result.Timestamp); err != nil {
-				return nil, fmt.Errorf("failed to scan result: %w", err)
-			}
-			results = append(results, result)
-		}
-	}
-
-	return results, nil
+	return results, rows.Err()
 }
@@ -1,9 +1,15 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"os"
 	"path/filepath"
+	"reflect"
+	"strings"
 	"testing"
+	"time"
 
 	_ "modernc.org/sqlite"
 )
@@ -40,7 +46,7 @@ func TestInsertCommands(t *testing.T) {
 		{Source: "/file2", Timestamp: 2000.0, Command: "git status", Duration: 1},
 	}
 
-	inserted, ignored, err := InsertCommands(db, commands)
+	inserted, ignored, err := InsertCommands(context.Background(), db, commands)
 	if err != nil {
 		t.Fatalf("InsertCommands() error = %v", err)
 	}
@@ -53,7 +59,7 @@ func TestInsertCommands(t *testing.T) {
 		t.Errorf("InsertCommands() ignored = %d, want 0", ignored)
 	}
 
-	inserted2, ignored2, err := InsertCommands(db, commands)
+	inserted2, ignored2, err := InsertCommands(context.Background(), db, commands)
 	if err != nil {
 		t.Fatalf("InsertCommands() second call error = %v", err)
 	}
@@ -88,7 +94,7 @@ func TestInsertCommandsBatch(t *testing.T) {
 		})
 	}
 
-	inserted, ignored, err := InsertCommandsBatch(db, commands, 10)
+	inserted, ignored, err := InsertCommandsBatch(context.Background(), db, commands, 10)
 	if err != nil {
 		t.Fatalf("InsertCommandsBatch() error = %v", err)
 	}
@@ -118,12 +124,12 @@ func TestGetDBStats(t *testing.T) {
 		{Source: "/file2", Timestamp: 2000.0, Command: "cmd3"},
 	}
 
-	_, _, err = InsertCommands(db, commands)
+	_, _, err = InsertCommands(context.Background(), db, commands)
 	if err != nil {
 		t.Fatalf("InsertCommands() error = %v", err)
 	}
 
-	stats, err := GetDBStats(db)
+	stats, err := GetDBStats(context.Background(), db)
 	if err != nil {
 		t.Fatalf("GetDBStats() error = %v", err)
 	}
@@ -137,6 +143,43 @@ func TestGetDBStats(t *testing.T) {
 	}
 }
 
+func TestSourceAndDailyCounts(t *testing.T) {
+	db, err := InitDB(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("InitDB() error = %v", err)
+	}
+	defer db.Close()
+
+	day1 := float64(time.Date(2026, 3, 1, 12, 0, 0, 0, time.Local).Unix())
+	day2 := float64(time.Date(2026, 3, 3, 9, 0, 0, 0, time.Local).Unix())
+	commands := []Command{
+		{Source: "a:/zsh", Timestamp: day1, Command: "ls"},
+		{Source: "a:/zsh", Timestamp: day1 + 1, Command: "pwd"},
+		{Source: "b:/zsh", Timestamp: day2, Command: "ls"},
+	}
+	if _, _, err := InsertCommands(context.Background(), db, commands); err != nil {
+		t.Fatalf("InsertCommands() error = %v", err)
+	}
+
+	sources, err := GetSourceCounts(context.Background(), db)
+	if err != nil {
+		t.Fatalf("GetSourceCounts() error = %v", err)
+	}
+	want := []SourceCount{{"a:/zsh", 2}, {"b:/zsh", 1}}
+	if !reflect.DeepEqual(sources, want) {
+		t.Errorf("GetSourceCounts() = %v, want %v", sources, want)
+	}
+
+	days, err := GetDailyCounts(context.Background(), db, day1+1)
+	if err != nil {
+		t.Fatalf("GetDailyCounts() error = %v", err)
+	}
+	wantDays := []DayCount{{"2026-03-01", 1}, {"2026-03-03", 1}}
+	if !reflect.DeepEqual(days, wantDays) {
+		t.Errorf("GetDailyCounts() = %v, want %v", days, wantDays)
+	}
+}
+
 func TestSearchCommands(t *testing.T) {
 	tmpDir := t.TempDir()
 	dbPath := filepath.Join(tmpDir, "test.db")
@@ -154,13 +197,13 @@ func TestSearchCommands(t *testing.T) {
 		{Source: "/file2", Timestamp: 2000.0, Command: "echo hello"},
 	}
 
-	_, _, err = InsertCommands(db, commands)
+	_, _, err = InsertCommands(context.Background(), db, commands)
 	if err != nil {
 		t.Fatalf("InsertCommands() error = %v", err)
 	}
 
 	t.Run("all commands", func(t *testing.T) {
-		results, err := SearchCommands(db, SearchOptions{})
+		results, err := SearchCommands(context.Background(), db, SearchOptions{})
 		if err != nil {
 			t.Fatalf("SearchCommands() error = %v", err)
 		}
@@ -179,7 +222,7 @@ func TestSearchCommands(t *testing.T) {
 	})
 
 	t.Run("fts search", func(t *testing.T) {
-		results, err := SearchCommands(db, SearchOptions{Query: "git"})
+		results, err := SearchCommands(context.Background(), db, SearchOptions{Query: "git"})
 		if err != nil {
 			t.Fatalf("SearchCommands() error = %v", err)
 		}
@@ -208,7 +251,7 @@ func TestSearchCommands(t *testing.T) {
 	})
 
 	t.Run("no results", func(t *testing.T) {
-		results, err := SearchCommands(db, SearchOptions{Query: "nonexistent"})
+		results, err := SearchCommands(context.Background(), db, SearchOptions{Query: "nonexistent"})
 		if err != nil {
 			t.Fatalf("SearchCommands() error = %v", err)
 		}
@@ -219,7 +262,7 @@ func TestSearchCommands(t *testing.T) {
 	})
 
 	t.Run("with limit", func(t *testing.T) {
-		results, err := SearchCommands(db, SearchOptions{Limit: 2})
+		results, err := SearchCommands(context.Background(), db, SearchOptions{Limit: 2})
 		if err != nil {
 			t.Fatalf("SearchCommands() error = %v", err)
 		}
@@ -230,7 +273,7 @@ func TestSearchCommands(t *testing.T) {
 	})
 
 	t.Run("with since filter", func(t *testing.T) {
-		results, err := SearchCommands(db, SearchOptions{Since: 1500.0})
+		results, err := SearchCommands(context.Background(), db, SearchOptions{Since: 1500.0})
 		if err != nil {
 			t.Fatalf("SearchCommands() error = %v", err)
 		}
@@ -245,7 +288,7 @@ func TestSearchCommands(t *testing.T) {
 	})
 
 	t.Run("with until filter", func(t *testing.T) {
-		results, err := SearchCommands(db, SearchOptions{Until: 1001.5})
+		results, err := SearchCommands(context.Background(), db, SearchOptions{Until: 1001.5})
 		if err != nil {
 			t.Fatalf("SearchCommands() error = %v", err)
 		}
@@ -255,8 +298,19 @@ func TestSearchCommands(t *testing.T) {
 		}
 	})
 
+	t.Run("with source filter", func(t *testing.T) {
+		results, err := SearchCommands(context.Background(), db, SearchOptions{Source: "file2"})
+		if err != nil {
+			t.Fatalf("SearchCommands() error = %v", err)
+		}
+
+		if len(results) != 1 || results[0].Source != "/file2" {
+			t.Errorf("SearchCommands() with source=file2 returned %+v, want only /file2", results)
+		}
+	})
+
 	t.Run("with since and until", func(t *testing.T) {
-		results, err := SearchCommands(db, SearchOptions{Since: 1000.5, Until: 1002.5})
+		results, err := SearchCommands(context.Background(), db, SearchOptions{Since: 1000.5, Until: 1002.5})
 		if err != nil {
 			t.Fatalf("SearchCommands() error = %v", err)
 		}
@@ -265,6 +319,259 @@ func TestSearchCommands(t *testing.T) {
 			t.Errorf("SearchCommands() with time range returned %d results, want 2", len(results))
 		}
 	})
+
+	t.Run("cancelled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		if _, err := SearchCommands(ctx, db, SearchOptions{Query: "git"}); !errors.Is(err, context.Canceled) {
+			t.Errorf("SearchCommands() with a cancelled context error = %v, want context.Canceled", err)
+		}
+	})
+}
+
+func TestBuildFTSQuery(t *testing.T) {
+	tests := []struct {
+		query string
+		exact bool
+		want  string
+	}{
+		{"", false, ""},
+		{"git push", false, `"git"* "push"*`},
+		{"git push", true, `"git" "push"`},
+		{"docker-compose up", false, `"docker-compose"* "up"*`},
+		{`"docker compose up" prod`, false, `"docker compose up" "prod"*`},
+		{`say "hi`, true, `"say" "hi"`},
+		{`a"b""c"`, false, `"a"* "b" "c"`},
+		{`OR NOT (x:y)`, false, `"OR"* "NOT"* "(x:y)"*`},
+	}
+	for _, tt := range tests {
+		if got := buildFTSQuery(tt.query, tt.exact); got != tt.want {
+			t.Errorf("buildFTSQuery(%q, %v) = %s, want %s", tt.query, tt.exact, got, tt.want)
+		}
+	}
+}
+
+func TestSearchCommandsPhrase(t *testing.T) {
+	db, err := InitDB(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("InitDB() error = %v", err)
+	}
+	defer db.Close()
+
+	commands := []Command{
+		{Source: "/h", Timestamp: 1, Command: "docker compose up -d"},
+		{Source: "/h", Timestamp: 2, Command: "docker compose logs && up"},
+		{Source: "/h", Timestamp: 3, Command: "docker-compose up"},
+		{Source: "/h", Timestamp: 4, Command: "tail logs/app.log"},
+	}
+	if _, _, err := InsertCommands(context.Background(), db, commands); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		query string
+		exact bool
+		want  int
+	}{
+		{"docker compose up", false, 3},
+		{`"docker compose up"`, false, 2},
+		{`"compose up" -d`, true, 1},
+		{"log", false, 2},
+		{"log", true, 1},
+		{"docker-compose", false, 3},
+	}
+	for _, tt := range tests {
+		results, err := SearchCommands(context.Background(), db, SearchOptions{Query: tt.query, Exact: tt.exact, Limit: 10})
+		if err != nil {
+			t.Fatalf("SearchCommands(%q) error = %v", tt.query, err)
+		}
+		if len(results) != tt.want {
+			t.Errorf("SearchCommands(%q, exact %v) = %d results, want %d", tt.query, tt.exact, len(results), tt.want)
+		}
+	}
+}
+
+func TestSearchCommandsCaseSensitive(t *testing.T) {
+	db, err := InitDB(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("InitDB() error = %v", err)
+	}
+	defer db.Close()
+
+	commands := []Command{
+		{Source: "/h", Timestamp: 1, Command: "grep -R TODO src"},
+		{Source: "/h", Timestamp: 2, Command: "grep -r todo src"},
+		{Source: "/h", Timestamp: 3, Command: "grep -rn Todo ."},
+	}
+	if _, _, err := InsertCommands(context.Background(), db, commands); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		query         string
+		caseSensitive bool
+		want          []string
+	}{
+		{"grep -R", false, []string{"grep -rn Todo .", "grep -r todo src", "grep -R TODO src"}},
+		{"grep -R", true, []string{"grep -R TODO src"}},
+		{"grep -r", true, []string{"grep -rn Todo .", "grep -r todo src"}},
+		{`"-r todo"`, true, []string{"grep -r todo src"}},
+		{"Todo", true, []string{"grep -rn Todo ."}},
+	}
+	for _, tt := range tests {
+		results, err := SearchCommands(context.Background(), db, SearchOptions{Query: tt.query, CaseSensitive: tt.caseSensitive, Limit: 10, Sort: SortTime})
+		if err != nil {
+			t.Fatalf("SearchCommands(%q) error = %v", tt.query, err)
+		}
+		var got []string
+		for _, r := range results {
+			got = append(got, r.Command)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("SearchCommands(%q, case sensitive %v) = %q, want %q", tt.query, tt.caseSensitive, got, tt.want)
+		}
+	}
+}
+
+func TestSearchCommandsRelevance(t *testing.T) {
+	db, err := InitDB(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("InitDB() error = %v", err)
+	}
+	defer db.Close()
+
+	now := float64(time.Now().Unix())
+	commands := []Command{
+		{Source: "/h", Timestamp: now - 30*86400, Command: "terraform plan"},
+		{Source: "/h", Timestamp: now - 60, Command: "cd infra && terraform init -upgrade && make lint docs && echo plan-review"},
+		{Source: "/h", Timestamp: now - 10*365*86400, Command: "terraform plan -out tf.plan"},
+	}
+	if _, _, err := InsertCommands(context.Background(), db, commands); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		sort string
+		want []string
+	}{
+		{"", []string{"terraform plan", "cd infra && terraform init -upgrade && make lint docs && echo plan-review", "terraform plan -out tf.plan"}},
+		{SortTime, []string{"cd infra && terraform init -upgrade && make lint docs && echo plan-review", "terraform plan", "terraform plan -out tf.plan"}},
+	}
+	for _, tt := range tests {
+		results, err := SearchCommands(context.Background(), db, SearchOptions{Query: "terraform plan", Limit: 10, Sort: tt.sort})
+		if err != nil {
+			t.Fatalf("SearchCommands(sort %q) error = %v", tt.sort, err)
+		}
+		var got []string
+		for _, r := range results {
+			got = append(got, r.Command)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("SearchCommands(sort %q) = %q, want %q", tt.sort, got, tt.want)
+		}
+	}
+
+	if _, err := SearchCommands(context.Background(), db, SearchOptions{Query: "terraform", Sort: "alphabetical"}); err == nil {
+		t.Error("SearchCommands() error = nil for an unknown sort")
+	}
+}
+
+func TestSearchHistoryByKeywords(t *testing.T) {
+	db, err := InitDB(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("InitDB() error = %v", err)
+	}
+	defer db.Close()
+
+	commands := []Command{
+		{Source: "/h", Timestamp: 1, Command: "docker logs -f web"},
+		{Source: "/h", Timestamp: 2, Command: "docker compose logs nginx"},
+		{Source: "/h", Timestamp: 3, Command: "docker ps"},
+		{Source: "/h", Timestamp: 4, Command: "docker ps"},
+		{Source: "/h", Timestamp: 5, Command: "export KUBECONFIG=~/.kube/dev"},
+		{Source: "/h", Timestamp: 6, Command: "tail -f /var/log/syslog"},
+	}
+	if _, _, err := InsertCommands(context.Background(), db, commands); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		keywords []string
+		want     []string
+	}{
+		// Matching more keywords ranks higher; any one is enough
+		{[]string{"nginx", "logs"}, []string{"docker compose logs nginx", "docker logs -f web"}},
+		// Prefixes match through the index
+		{[]string{"sysl"}, []string{"tail -f /var/log/syslog"}},
+		// Substrings inside a word only through the scan
+		{[]string{"config"}, []string{"export KUBECONFIG=~/.kube/dev"}},
+		{[]string{"docker-compose"}, []string{"docker compose logs nginx"}},
+		{[]string{"ab"}, nil},
+	}
+	for _, tt := range tests {
+		results, err := SearchHistoryByKeywords(context.Background(), db, tt.keywords, 10)
+		if err != nil {
+			t.Fatalf("SearchHistoryByKeywords(%q) error = %v", tt.keywords, err)
+		}
+		var got []string
+		for _, r := range results {
+			got = append(got, r.Command)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("SearchHistoryByKeywords(%q) = %q, want %q", tt.keywords, got, tt.want)
+		}
+	}
+
+	results, err := SearchHistoryByKeywords(context.Background(), db, []string{"docker"}, 2)
+	if err != nil || len(results) != 2 {
+		t.Errorf("SearchHistoryByKeywords(limit 2) = %v, %v, want 2 results", results, err)
+	}
+}
+
+func TestRecordPick(t *testing.T) {
+	db, err := InitDB(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("InitDB() error = %v", err)
+	}
+	defer db.Close()
+
+	commands := []Command{
+		{Source: "/file1", Timestamp: 1000.0, Command: "make deploy", EnvPrefix: "ENV=prod"},
+		{Source: "/file1", Timestamp: 1001.0, Command: "git status"},
+		{Source: "/file1", Timestamp: 1002.0, Command: "git status"},
+		{Source: "/file2", Timestamp: 2000.0, Command: "git log"},
+	}
+	if _, _, err := InsertCommands(context.Background(), db, commands); err != nil {
+		t.Fatalf("InsertCommands() error = %v", err)
+	}
+
+	for _, command := range []string{"ENV=prod make deploy", "git status", "ENV=prod make deploy"} {
+		if ok, err := RecordPick(context.Background(), db, command); err != nil || !ok {
+			t.Fatalf("RecordPick(%q) = %v, %v", command, ok, err)
+		}
+	}
+	if ok, _ := RecordPick(context.Background(), db, "rm -rf build"); ok {
+		t.Error("RecordPick() of a command not in history = true")
+	}
+
+	results, err := SearchCommands(context.Background(), db, SearchOptions{})
+	if err != nil {
+		t.Fatalf("SearchCommands() error = %v", err)
+	}
+	var got []string
+	for _, r := range results {
+		got = append(got, fmt.Sprintf("%s@%.0f/%d", r.Command, r.Timestamp, r.Picked))
+	}
+	// Most picked first; only the latest row of a repeated command counts the pick
+	want := []string{"make deploy@1000/2", "git status@1002/1", "git log@2000/0", "git status@1001/0"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("SearchCommands() = %v, want %v", got, want)
+	}
+
+	// Counting picks leaves the search index alone
+	if results, _ := SearchCommands(context.Background(), db, SearchOptions{Query: "deploy"}); len(results) != 1 {
+		t.Errorf("SearchCommands(deploy) after picks = %d results, want 1", len(results))
+	}
 }
 
 func TestExpandTilde(t *testing.T) {
@@ -290,3 +597,324 @@ func TestExpandTilde(t *testing.T) {
 		})
 	}
 }
+
+func TestEnvPrefixRoundTrip(t *testing.T) {
+	db, err := InitDB(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("InitDB() error = %v", err)
+	}
+	defer db.Close()
+
+	version, err := SchemaVersion(db)
+	if err != nil {
+		t.Fatalf("SchemaVersion() error = %v", err)
+	}
+	if version != len(migrations) {
+		t.Errorf("SchemaVersion() = %d, want %d", version, len(migrations))
+	}
+
+	commands := []Command{
+		{Source: "/file1", Timestamp: 1000.0, Command: "make test", EnvPrefix: "CGO_ENABLED=0"},
+	}
+	if _, _, err := InsertCommands(context.Background(), db, commands); err != nil {
+		t.Fatalf("InsertCommands() error = %v", err)
+	}
+
+	results, err := SearchCommands(context.Background(), db, SearchOptions{Query: "CGO_ENABLED"})
+	if err != nil {
+		t.Fatalf("SearchCommands() error = %v", err)
+	}
+	if len(results) != 0 {
+		t.Errorf("SearchCommands('CGO_ENABLED') returned %d results, want 0 (env prefix not indexed)", len(results))
+	}
+
+	results, err = SearchCommands(context.Background(), db, SearchOptions{Query: "make"})
+	if err != nil {
+		t.Fatalf("SearchCommands() error = %v", err)
+	}
+	if len(results) != 1 || results[0].FullCommand() != "CGO_ENABLED=0 make test" {
+		t.Errorf("SearchCommands('make') = %+v, want full command 'CGO_ENABLED=0 make test'", results)
+	}
+}
+
+func TestWizardCacheProjects(t *testing.T) {
+	db, err := InitDB(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("InitDB() error = %v", err)
+	}
+	defer db.Close()
+
+	if err := SetWizardCache(context.Background(), db, "", "run tests", "make test"); err != nil {
+		t.Fatal(err)
+	}
+	if err := SetWizardCache(context.Background(), db, "/src/api", "Run tests", "go test ./..."); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		project string
+		want    string
+	}{
+		{"/src/api", "go test ./..."},
+		{"/src/web", "make test"},
+		{"", "make test"},
+	}
+	for _, tt := range tests {
+		entry, err := GetWizardCache(context.Background(), db, tt.project, "run tests")
+		if err != nil {
+			t.Fatalf("GetWizardCache(%q) error = %v", tt.project, err)
+		}
+		if entry == nil || entry.Command != tt.want {
+			t.Errorf("GetWizardCache(%q) = %+v, want %q", tt.project, entry, tt.want)
+		}
+	}
+
+	if err := DeleteWizardCacheEntry(context.Background(), db, "/src/api", "run tests"); err != nil {
+		t.Fatal(err)
+	}
+	if entry, _ := GetWizardCache(context.Background(), db, "/src/api", "run tests"); entry == nil || entry.Command != "make test" {
+		t.Errorf("after deleting the project entry, lookup = %+v, want the global entry", entry)
+	}
+}
+
+func TestSearchWizardCache(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "test.db")
+	db, err := InitDB(dbPath)
+	if err != nil {
+		t.Fatalf("InitDB() error = %v", err)
+	}
+	defer db.Close()
+
+	// Each SetWizardCache is one accepted run
+	for i := 0; i < 3; i++ {
+		SetWizardCache(context.Background(), db, "", "list running containers", "docker ps")
+	}
+	for i := 0; i < 2; i++ {
+		SetWizardCache(context.Background(), db, "/src/api", "run tests", "go test ./...")
+	}
+	SetWizardCache(context.Background(), db, "", "list open ports", "ss -tlnp")
+	if _, _, err := InsertCommands(context.Background(), db, []Command{{Command: "docker ps -a", Source: "laptop", Timestamp: 1700000000}}); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		query string
+		want  []string
+	}{
+		{"", []string{"docker ps", "go test ./..."}},
+		{"containers", []string{"docker ps"}},
+		{"LIST docker", []string{"docker ps"}},
+		{"ports", nil},
+	}
+	for _, tt := range tests {
+		entries, err := SearchWizardCache(context.Background(), db, tt.query, minSearchWizardRuns, maxSearchWizardEntries)
+		if err != nil {
+			t.Fatalf("SearchWizardCache(%q) error = %v", tt.query, err)
+		}
+		var got []string
+		for _, e := range entries {
+			got = append(got, e.Command)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("SearchWizardCache(%q) = %q, want %q", tt.query, got, tt.want)
+		}
+	}
+
+	// The picker lists the mapping, labelled with its query, before history
+	results, err := searchWithState(context.Background(), &PickerState{DBPath: dbPath, Base: SearchOptions{Query: "containers", Limit: 10}})
+	if err != nil {
+		t.Fatalf("searchWithState() error = %v", err)
+	}
+	if len(results) == 0 || results[0].Badge != "[wizard: list running containers]" || results[0].Command != "docker ps" {
+		t.Errorf("searchWithState() = %+v, want the wizard mapping first", results)
+	}
+}
+
+func TestImportWizardCache(t *testing.T) {
+	dir := t.TempDir()
+	src, err := InitDB(filepath.Join(dir, "src.db"))
+	if err != nil {
+		t.Fatalf("InitDB() error = %v", err)
+	}
+	defer src.Close()
+	for _, m := range [][3]string{{"", "list pods", "kubectl get pods -A"}, {"", "list pods", "kubectl get pods -A"}, {"/src/api", "run tests", "go test ./..."}} {
+		if err := SetWizardCache(context.Background(), src, m[0], m[1], m[2]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	exported, err := ExportWizardCache(context.Background(), src)
+	if err != nil || len(exported) != 2 || exported[0].RunCount != 2 {
+		t.Fatalf("ExportWizardCache() = %+v, %v, want both mappings, most run first", exported, err)
+	}
+
+	db, err := InitDB(filepath.Join(dir, "dst.db"))
+	if err != nil {
+		t.Fatalf("InitDB() error = %v", err)
+	}
+	defer db.Close()
+	if err := SetWizardCache(context.Background(), db, "", "List pods", "kubectl get pods"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec(`UPDATE wizard_cache SET last_used = 1`); err != nil {
+		t.Fatal(err)
+	}
+
+	// Hand-written entries only need a query and command
+	entries := append(exported, WizardCacheEntry{QueryOriginal: "disk usage", Command: "du -sh *"})
+	added, updated, err := ImportWizardCache(context.Background(), db, entries)
+	if err != nil || added != 2 || updated != 1 {
+		t.Fatalf("ImportWizardCache() = %d, %d, %v, want 2 added, 1 updated", added, updated, err)
+	}
+	if entry, _ := GetWizardCache(context.Background(), db, "", "list pods"); entry == nil || entry.Command != "kubectl get pods -A" || entry.RunCount != 2 {
+		t.Errorf("merged entry = %+v, want the newer command and higher run count", entry)
+	}
+	if entry, _ := GetWizardCache(context.Background(), db, "/src/api", "run tests"); entry == nil || entry.Command != "go test ./..." {
+		t.Errorf("project entry = %+v, want it imported into its project", entry)
+	}
+	if entry, _ := GetWizardCache(context.Background(), db, "", "disk usage"); entry == nil || entry.RunCount != 1 || entry.LastUsed == 0 {
+		t.Errorf("hand-written entry = %+v, want defaults filled in", entry)
+	}
+
+	// Importing the same file again changes nothing
+	if added, updated, err := ImportWizardCache(context.Background(), db, entries); err != nil || added != 0 || updated != 0 {
+		t.Errorf("ImportWizardCache() again = %d, %d, %v, want nothing to do", added, updated, err)
+	}
+
+	if _, _, err := ImportWizardCache(context.Background(), db, []WizardCacheEntry{{QueryOriginal: "no command"}}); err == nil {
+		t.Error("ImportWizardCache() error = nil for an entry without a command")
+	}
+}
+
+func TestWizardCacheMigration(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "test.db")
+	db, err := InitDB(dbPath)
+	if err != nil {
+		t.Fatalf("InitDB() error = %v", err)
+	}
+
+	// Recreate the wizard cache as it was before project keys
+	for _, q := range []string{
+		`DROP TABLE wizard_cache`,
+		`CREATE TABLE wizard_cache (query_normalized TEXT PRIMARY KEY, query_original TEXT NOT NULL,
+			command TEXT NOT NULL, run_count INTEGER DEFAULT 1, last_used REAL NOT NULL, created_at REAL NOT NULL)`,
+		`INSERT INTO wizard_cache VALUES ('list pods', 'List pods', 'kubectl get pods', 3, 20, 10)`,
+		// Undo later migrations too
+		`ALTER TABLE commands DROP COLUMN picked_count`,
+		`DROP INDEX idx_second_seq`,
+		`ALTER TABLE commands DROP COLUMN seq`,
+		`ALTER TABLE sources DROP COLUMN resume_offset`,
+		`ALTER TABLE sources DROP COLUMN resume_subseconds`,
+		`INSERT INTO commands (source, timestamp, command) VALUES ('h', 1, 'cat <<EOF\' || char(10) || 'hi\' || char(10) || 'EOF')`,
+		`PRAGMA user_version = 1`,
+	} {
+		if _, err := db.Exec(q); err != nil {
+			t.Fatalf("%s: %v", q, err)
+		}
+	}
+	db.Close()
+
+	db, err = InitDB(dbPath)
+	if err != nil {
+		t.Fatalf("InitDB() after downgrade error = %v", err)
+	}
+	defer db.Close()
+
+	entry, err := GetWizardCache(context.Background(), db, "/any/project", "list pods")
+	if err != nil {
+		t.Fatalf("GetWizardCache() error = %v", err)
+	}
+	if entry == nil || entry.Command != "kubectl get pods" || entry.RunCount != 3 || entry.Project != "" {
+		t.Errorf("migrated entry = %+v, want the old entry in the global namespace", entry)
+	}
+
+	results, err := SearchCommands(context.Background(), db, SearchOptions{Query: "hi", Limit: 10})
+	if err != nil || len(results) != 1 || results[0].Command != "cat <<EOF\nhi\nEOF" {
+		t.Errorf("SearchCommands() = %+v, %v, want the here-document without backslashes", results, err)
+	}
+}
+
+func TestSeqOrdering(t *testing.T) {
+	dir := t.TempDir()
+	dbPath := filepath.Join(dir, "test.db")
+	historyFile := filepath.Join(dir, "zsh_history")
+	collect := func(content string) {
+		t.Helper()
+		if err := os.WriteFile(historyFile, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := runCollect(context.Background(), dbPath, []string{historyFile}, CollectOptions{Quiet: true}); err != nil {
+			t.Fatalf("runCollect() error = %v", err)
+		}
+	}
+
+	collect(": 1704384000:0;first\n: 1704384000:0;second\n")
+	collect(": 1704384000:0;first\n: 1704384000:0;second\n: 1704384001:0;third\n")
+
+	db, err := InitDB(dbPath)
+	if err != nil {
+		t.Fatalf("InitDB() error = %v", err)
+	}
+	defer db.Close()
+
+	// Numbering carries on from the first collect
+	if seq, err := MaxSeq(context.Background(), db, historyFile); err != nil || seq != 5 {
+		t.Errorf("MaxSeq() = %d, %v, want 5", seq, err)
+	}
+
+	// Bumps that disagree with the order commands ran, as from another tool
+	if _, _, err := InsertCommands(context.Background(), db, []Command{
+		{Source: "/other", Timestamp: 1704384002.002, Command: "earlier", Seq: 1},
+		{Source: "/other", Timestamp: 1704384002.001, Command: "later", Seq: 2},
+	}); err != nil {
+		t.Fatalf("InsertCommands() error = %v", err)
+	}
+
+	results, err := SearchCommands(context.Background(), db, SearchOptions{Sort: SortTime})
+	if err != nil {
+		t.Fatalf("SearchCommands() error = %v", err)
+	}
+	var got []string
+	for _, r := range results {
+		got = append(got, r.Command)
+	}
+	if want := []string{"later", "earlier", "third", "second", "first"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("SearchCommands() = %v, want %v", got, want)
+	}
+
+	recent, err := GetRecentCommands(context.Background(), db, 1)
+	if err != nil || len(recent) != 1 || recent[0].Command != "later" {
+		t.Errorf("GetRecentCommands() = %v, %v, want later", recent, err)
+	}
+}
+
+func TestSearchTimeout(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "test.db")
+	db, err := InitDB(dbPath)
+	if err != nil {
+		t.Fatalf("InitDB() error = %v", err)
+	}
+	defer db.Close()
+	if _, _, err := InsertCommands(context.Background(), db, []Command{{Command: "docker ps -a", Source: "laptop", Timestamp: 1700000000}}); err != nil {
+		t.Fatal(err)
+	}
+
+	state := &PickerState{DBPath: dbPath, Base: SearchOptions{Query: "docker", Limit: 10}, Timeout: time.Minute}
+	if results, err := searchWithState(context.Background(), state); err != nil || len(results) != 1 {
+		t.Fatalf("searchWithState() = %+v, %v, want the command within the timeout", results, err)
+	}
+
+	state.Timeout = time.Nanosecond
+	if _, err := searchWithState(context.Background(), state); err == nil || !strings.Contains(err.Error(), "--timeout") {
+		t.Errorf("searchWithState() past the timeout error = %v, want it to name --timeout", err)
+	}
+
+	// A query that would never finish is interrupted once the context ends
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	var n int64
+	err = db.QueryRowContext(ctx, `WITH RECURSIVE c(x) AS (SELECT 1 UNION ALL SELECT x + 1 FROM c) SELECT count(*) FROM c`).Scan(&n)
+	if err == nil || time.Since(start) > 5*time.Second {
+		t.Errorf("endless query returned %v after %s, want it interrupted", err, time.Since(start))
+	}
+}
@@ -0,0 +1,243 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io"
+	"math"
+	"math/rand/v2"
+	"strings"
+	"time"
+)
+
+// FixtureOptions controls the synthetic history GenerateFixture writes
+type FixtureOptions struct {
+	Rows    int       // Commands to generate
+	Seed    uint64    // Same seed, same history
+	Sources int       // Machines the history is spread over
+	Days    int       // Roughly how far back from End the history goes
+	End     time.Time // DefaultFixtureEnd when zero
+}
+
+// DefaultFixtureEnd is the fixed end of generated histories, so fixtures
+// don't depend on when they were made
+var DefaultFixtureEnd = time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// fixtureBatch is how many commands GenerateFixture inserts per transaction
+const fixtureBatch = 10000
+
+// Word pools the fixture templates draw from
+var (
+	fixtureHosts    = []string{"laptop", "workstation", "build-01", "web-1", "web-2", "db-1", "bastion", "pi"}
+	fixtureProjects = []string{"api", "web", "infra", "dotfiles", "zist", "billing", "ml-pipeline", "docs"}
+	fixtureFiles    = []string{"main.go", "README.md", "Makefile", "docker-compose.yml", "config.yaml", "app.py", "package.json", ".env", "schema.sql", "deploy.sh"}
+	fixtureServices = []string{"api", "worker", "postgres", "redis", "nginx", "scheduler"}
+	fixtureBranches = []string{"main", "develop", "fix/login-timeout", "feature/search", "release/1.4", "chore/deps"}
+	fixtureWords    = []string{"TODO", "timeout", "panic", "password", "deprecated", "retry", "ERROR", "listen"}
+	fixtureMessages = []string{"fix flaky test", "bump deps", "wip", "address review comments", "add metrics", "refactor config loading", "typo"}
+	fixtureNS       = []string{"default", "staging", "prod", "monitoring", "kube-system"}
+	fixturePackages = []string{"jq", "ripgrep", "htop", "fzf", "postgresql-client", "tmux", "neovim"}
+)
+
+// fixtureTemplates are commands by how often they're typed. {project},
+// {file} and the like are replaced from the pools above.
+var fixtureTemplates = []struct {
+	weight   int
+	template string
+}{
+	{120, "ls"},
+	{80, "ls -la"},
+	{90, "cd ~/src/{project}"},
+	{60, "cd .."},
+	{100, "git status"},
+	{50, "git diff"},
+	{40, "git add -A"},
+	{40, `git commit -m "{message}"`},
+	{30, "git push origin {branch}"},
+	{30, "git pull --rebase"},
+	{25, "git checkout {branch}"},
+	{20, "git log --oneline -20"},
+	{10, "git stash"},
+	{8, "git stash pop"},
+	{45, "vim {file}"},
+	{25, "cat {file}"},
+	{20, "less {file}"},
+	{25, "rg -n {word}"},
+	{15, "grep -rn {word} ."},
+	{30, "go test ./..."},
+	{20, "go build ./..."},
+	{20, "make"},
+	{10, "make test"},
+	{15, "npm run dev"},
+	{10, "npm install"},
+	{10, "python3 {file}"},
+	{20, "docker ps"},
+	{15, "docker compose up -d"},
+	{15, "docker compose logs -f {service}"},
+	{8, "docker exec -it {project}-{service}-1 sh"},
+	{20, "kubectl -n {ns} get pods"},
+	{10, "kubectl -n {ns} logs -f deploy/{service}"},
+	{8, "kubectl -n {ns} describe pod {service}-{n}"},
+	{5, "kubectl config use-context {ns}"},
+	{15, "ssh {host}"},
+	{8, "scp {file} {host}:/tmp/"},
+	{10, "curl -s https://api.example.com/v1/{service}/status | jq ."},
+	{10, "htop"},
+	{8, "df -h"},
+	{8, "du -sh *"},
+	{6, "tail -f /var/log/{service}.log"},
+	{5, "psql -h localhost -U postgres {project}"},
+	{5, "sudo apt install {package}"},
+	{5, "brew install {package}"},
+	{4, "export AWS_PROFILE={ns}"},
+	{4, "terraform plan -out plan.tfplan"},
+	{3, "history | grep {word}"},
+	{3, "man {package}"},
+	{2, "for f in *.log; do\n  gzip \"$f\"\ndone"},
+	{2, "find . -name '*.{ext}' -mtime -{n} -exec ls -lh {} +"},
+	{1, "openssl s_client -connect {host}.example.com:443 -servername {host}.example.com </dev/null | openssl x509 -noout -dates"},
+	{1, "tar czf backup-{n}.tar.gz --exclude=node_modules --exclude=.git ~/src/{project}"},
+}
+
+// fixtureTotalWeight is the sum of the template weights
+var fixtureTotalWeight = func() int {
+	total := 0
+	for _, t := range fixtureTemplates {
+		total += t.weight
+	}
+	return total
+}()
+
+// fixtureGenerator produces a deterministic stream of commands
+type fixtureGenerator struct {
+	rng     *rand.Rand
+	opts    FixtureOptions
+	now     float64 // Timestamp of the last command
+	step    float64 // Mean seconds between commands, spreading Rows over Days
+	source  string
+	project string
+	left    int // Commands left in the current session
+	seq     map[string]int64
+}
+
+func newFixtureGenerator(opts FixtureOptions) *fixtureGenerator {
+	span := float64(opts.Days) * 24 * 3600
+	return &fixtureGenerator{
+		rng:  rand.New(rand.NewPCG(opts.Seed, opts.Seed^0x9e3779b97f4a7c15)),
+		opts: opts,
+		now:  float64(opts.End.Unix()) - span,
+		step: span / float64(opts.Rows),
+		seq:  make(map[string]int64),
+	}
+}
+
+// next returns the next command, starting a new session when the current
+// one is done. Sessions are bursts of commands seconds apart on one source,
+// separated by long idle gaps, which keeps the average rate at step.
+func (g *fixtureGenerator) next() Command {
+	burst := math.Min(20, g.step)
+	gap := g.rng.ExpFloat64() * burst
+	if g.left == 0 {
+		g.left = 1 + g.rng.IntN(40)
+		g.source = fmt.Sprintf("%s:/home/dev/.zsh_history", fixtureHosts[g.rng.IntN(g.opts.Sources)])
+		g.project = fixtureProjects[g.rng.IntN(len(fixtureProjects))]
+		// An idle gap worth the session's share of the span
+		gap = g.rng.ExpFloat64() * (g.step - burst) * float64(g.left)
+	}
+	g.left--
+	g.now += max(gap, 0.002)
+	g.seq[g.source]++
+
+	cmd := Command{
+		Source:    g.source,
+		Timestamp: math.Round(g.now*1000) / 1000,
+		Command:   g.command(),
+		CWD:       "/home/dev/src/" + g.project,
+		Seq:       g.seq[g.source],
+	}
+	if g.rng.IntN(20) == 0 {
+		cmd.ExitCode = 1 + g.rng.IntN(2)
+	}
+	if g.rng.IntN(10) == 0 {
+		cmd.Duration = 1 + int(g.rng.ExpFloat64()*30)
+	}
+	return cmd
+}
+
+// command picks a template by weight and fills it in
+func (g *fixtureGenerator) command() string {
+	pick := g.rng.IntN(fixtureTotalWeight)
+	template := fixtureTemplates[len(fixtureTemplates)-1].template
+	for _, t := range fixtureTemplates {
+		if pick < t.weight {
+			template = t.template
+			break
+		}
+		pick -= t.weight
+	}
+
+	choose := func(pool []string) string { return pool[g.rng.IntN(len(pool))] }
+	return strings.NewReplacer(
+		"{project}", g.project,
+		"{file}", choose(fixtureFiles),
+		"{service}", choose(fixtureServices),
+		"{branch}", choose(fixtureBranches),
+		"{word}", choose(fixtureWords),
+		"{message}", choose(fixtureMessages),
+		"{ns}", choose(fixtureNS),
+		"{host}", choose(fixtureHosts),
+		"{package}", choose(fixturePackages),
+		"{ext}", choose([]string{"log", "go", "py", "tmp"}),
+		"{n}", fmt.Sprint(1+g.rng.IntN(30)),
+	).Replace(template)
+}
+
+// GenerateFixture fills db with opts.Rows synthetic commands: realistic
+// sessions on several machines, with a long tail of rare commands. The same
+// options always produce the same rows. It refuses a database that already
+// has commands, so real history never gets mixed with fixtures.
+func GenerateFixture(ctx context.Context, db *sql.DB, opts FixtureOptions, progress io.Writer) (int, error) {
+	if opts.Rows <= 0 {
+		return 0, fmt.Errorf("--rows must be positive")
+	}
+	if opts.Sources <= 0 || opts.Sources > len(fixtureHosts) {
+		return 0, fmt.Errorf("--sources must be between 1 and %d", len(fixtureHosts))
+	}
+	if opts.Days <= 0 {
+		return 0, fmt.Errorf("--days must be positive")
+	}
+	if opts.End.IsZero() {
+		opts.End = DefaultFixtureEnd
+	}
+
+	var existing int
+	if err := db.QueryRowContext(ctx, "SELECT COUNT(*) FROM commands").Scan(&existing); err != nil {
+		return 0, fmt.Errorf("failed to count commands: %w", err)
+	}
+	if existing > 0 {
+		return 0, fmt.Errorf("database already has %d commands, generate fixtures into a new one", existing)
+	}
+
+	g := newFixtureGenerator(opts)
+	total := 0
+	batch := make([]Command, 0, fixtureBatch)
+	for total < opts.Rows {
+		batch = batch[:0]
+		for len(batch) < fixtureBatch && total+len(batch) < opts.Rows {
+			batch = append(batch, g.next())
+		}
+		inserted, _, err := InsertCommands(ctx, db, batch)
+		if err != nil {
+			return total, err
+		}
+		total += inserted
+		if progress != nil {
+			fmt.Fprintf(progress, "\r%d/%d commands", total, opts.Rows)
+		}
+	}
+	if progress != nil {
+		fmt.Fprintln(progress)
+	}
+	return total, nil
+}
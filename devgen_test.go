@@ -0,0 +1,61 @@
+package main
+
+import (
+	"context"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestGenerateFixture(t *testing.T) {
+	dir := t.TempDir()
+	opts := FixtureOptions{Rows: 2500, Seed: 7, Sources: 3, Days: 30}
+
+	generate := func(name string, opts FixtureOptions) []Command {
+		t.Helper()
+		db, err := InitDB(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatalf("InitDB() error = %v", err)
+		}
+		defer db.Close()
+		inserted, err := GenerateFixture(context.Background(), db, opts, nil)
+		if err != nil || inserted != opts.Rows {
+			t.Fatalf("GenerateFixture() = %d, %v, want %d", inserted, err, opts.Rows)
+		}
+		if _, err := GenerateFixture(context.Background(), db, opts, nil); err == nil {
+			t.Error("GenerateFixture() into a database with commands error = nil")
+		}
+
+		rows, err := db.Query(`SELECT source, timestamp, command, duration, cwd, exit_code, seq FROM commands ORDER BY source, timestamp`)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer rows.Close()
+		var commands []Command
+		for rows.Next() {
+			var c Command
+			if err := rows.Scan(&c.Source, &c.Timestamp, &c.Command, &c.Duration, &c.CWD, &c.ExitCode, &c.Seq); err != nil {
+				t.Fatal(err)
+			}
+			commands = append(commands, c)
+		}
+		return commands
+	}
+
+	first := generate("first.db", opts)
+	if again := generate("again.db", opts); !reflect.DeepEqual(first, again) {
+		t.Error("GenerateFixture() with the same seed made different histories")
+	}
+	opts.Seed++
+	if other := generate("other.db", opts); reflect.DeepEqual(first, other) {
+		t.Error("GenerateFixture() with another seed made the same history")
+	}
+
+	sources := map[string]bool{}
+	for _, c := range first {
+		sources[c.Source] = true
+	}
+	if len(sources) != 3 {
+		t.Errorf("GenerateFixture() used %d sources, want 3", len(sources))
+	}
+}
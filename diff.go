@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io"
+	"sort"
+	"text/tabwriter"
+	"time"
+)
+
+// DiffBucket counts how incoming commands from one source and day compare
+// with what is already stored
+type DiffBucket struct {
+	Source      string `json:"source"`
+	Day         string `json:"day"`         // YYYY-MM-DD, local time
+	New         int    `json:"new"`         // Nothing stored at this source and timestamp
+	Duplicate   int    `json:"duplicate"`   // The same command is already stored
+	Conflicting int    `json:"conflicting"` // A different command is stored at this source and timestamp
+}
+
+// DiffCommands reports, without writing anything, what inserting commands
+// would do. Commands repeated within the batch count against the first.
+func DiffCommands(ctx context.Context, db *sql.DB, commands []Command) ([]DiffBucket, error) {
+	stmt, err := db.PrepareContext(ctx, `SELECT COALESCE(o.command, c.command), c.env_prefix FROM commands c
+		LEFT JOIN command_overflow o ON o.source = c.source AND o.timestamp = c.timestamp
+		WHERE c.source = ? AND c.timestamp = ?`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to prepare diff: %w", err)
+	}
+	defer stmt.Close()
+
+	type key struct {
+		source    string
+		timestamp float64
+	}
+	seen := make(map[key]string)
+	buckets := make(map[[2]string]*DiffBucket)
+	for _, cmd := range commands {
+		incoming := SearchResult{Command: cmd.Command, EnvPrefix: cmd.EnvPrefix}.FullCommand()
+		k := key{cmd.Source, cmd.Timestamp}
+
+		stored, ok := seen[k]
+		if !ok {
+			var r SearchResult
+			err := stmt.QueryRowContext(ctx, cmd.Source, cmd.Timestamp).Scan(&r.Command, &r.EnvPrefix)
+			switch {
+			case err == nil:
+				stored, ok = r.FullCommand(), true
+				seen[k] = stored
+			case err == sql.ErrNoRows:
+				seen[k] = incoming
+			default:
+				return nil, fmt.Errorf("failed to look up command: %w", err)
+			}
+		}
+
+		day := time.Unix(int64(cmd.Timestamp), 0).Format("2006-01-02")
+		b := buckets[[2]string{cmd.Source, day}]
+		if b == nil {
+			b = &DiffBucket{Source: cmd.Source, Day: day}
+			buckets[[2]string{cmd.Source, day}] = b
+		}
+		switch {
+		case !ok:
+			b.New++
+		case stored == incoming:
+			b.Duplicate++
+		default:
+			b.Conflicting++
+		}
+	}
+
+	return sortDiff(buckets), nil
+}
+
+// MergeDiff adds up buckets from several diffs, e.g. one per pushed batch
+func MergeDiff(diffs ...[]DiffBucket) []DiffBucket {
+	buckets := make(map[[2]string]*DiffBucket)
+	for _, diff := range diffs {
+		for _, d := range diff {
+			k := [2]string{d.Source, d.Day}
+			if b := buckets[k]; b != nil {
+				b.New += d.New
+				b.Duplicate += d.Duplicate
+				b.Conflicting += d.Conflicting
+				continue
+			}
+			d := d
+			buckets[k] = &d
+		}
+	}
+	return sortDiff(buckets)
+}
+
+func sortDiff(buckets map[[2]string]*DiffBucket) []DiffBucket {
+	diff := make([]DiffBucket, 0, len(buckets))
+	for _, b := range buckets {
+		diff = append(diff, *b)
+	}
+	sort.Slice(diff, func(i, j int) bool {
+		if diff[i].Source != diff[j].Source {
+			return diff[i].Source < diff[j].Source
+		}
+		return diff[i].Day < diff[j].Day
+	})
+	return diff
+}
+
+// writeDiff prints a diff as a table with totals. Days with conflicts are
+// usually a clock or format problem worth a look before writing anything.
+func writeDiff(w io.Writer, diff []DiffBucket) error {
+	if len(diff) == 0 {
+		fmt.Fprintln(w, "Nothing to import")
+		return nil
+	}
+
+	var total DiffBucket
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "SOURCE\tDAY\tNEW\tDUPLICATE\tCONFLICTING\t")
+	for _, d := range diff {
+		fmt.Fprintf(tw, "%s\t%s\t%d\t%d\t%d\t\n", d.Source, d.Day, d.New, d.Duplicate, d.Conflicting)
+		total.New += d.New
+		total.Duplicate += d.Duplicate
+		total.Conflicting += d.Conflicting
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(w, "\nTotal: %d new, %d duplicate, %d conflicting\n", total.New, total.Duplicate, total.Conflicting)
+	if total.Conflicting > 0 {
+		fmt.Fprintln(w, "Conflicting rows keep the stored command; check the clocks and history formats of those sources.")
+	}
+	return nil
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"context"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDiffCommands(t *testing.T) {
+	db, err := InitDB(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("InitDB() error = %v", err)
+	}
+	defer db.Close()
+
+	if _, _, err := InsertCommands(context.Background(), db, []Command{
+		{Source: "/h", Timestamp: 1000, Command: "ls"},
+		{Source: "/h", Timestamp: 1001, Command: "pwd", EnvPrefix: "FOO=1"},
+	}); err != nil {
+		t.Fatalf("InsertCommands() error = %v", err)
+	}
+
+	diff, err := DiffCommands(context.Background(), db, []Command{
+		{Source: "/h", Timestamp: 1000, Command: "ls"},
+		// Stored with its env prefix split off, so it's the same command
+		{Source: "/h", Timestamp: 1001, Command: "FOO=1 pwd"},
+		{Source: "/h", Timestamp: 1002, Command: "date"},
+		// Repeated within the batch, with a different command
+		{Source: "/h", Timestamp: 1002, Command: "uptime"},
+		{Source: "/h", Timestamp: 1000 + 86400, Command: "ls"},
+		{Source: "/other", Timestamp: 1000, Command: "whoami"},
+	})
+	if err != nil {
+		t.Fatalf("DiffCommands() error = %v", err)
+	}
+	day := func(ts float64) string { return time.Unix(int64(ts), 0).Format("2006-01-02") }
+	want := []DiffBucket{
+		{Source: "/h", Day: day(1000), New: 1, Duplicate: 2, Conflicting: 1},
+		{Source: "/h", Day: day(1000 + 86400), New: 1},
+		{Source: "/other", Day: day(1000), New: 1},
+	}
+	if !reflect.DeepEqual(diff, want) {
+		t.Errorf("DiffCommands() = %v, want %v", diff, want)
+	}
+
+	// Nothing was written
+	if stats, err := GetDBStats(context.Background(), db); err != nil || stats["total_commands"] != 2 {
+		t.Errorf("total commands = %v, %v, want 2", stats["total_commands"], err)
+	}
+
+	var out strings.Builder
+	if err := writeDiff(&out, MergeDiff(diff, diff)); err != nil {
+		t.Fatalf("writeDiff() error = %v", err)
+	}
+	if !strings.Contains(out.String(), "Total: 6 new, 4 duplicate, 2 conflicting") {
+		t.Errorf("writeDiff() = %q, want merged totals", out.String())
+	}
+}
@@ -0,0 +1,313 @@
+//go:build e2e && linux
+
+// End-to-end tests of the zsh integration: a real zsh, set up with the
+// block `zist install --print` writes, runs in a pseudo-terminal and is
+// typed at like a user would. Run them with
+//
+//	go test -tags e2e -run E2E .
+//
+// They need zsh on PATH, and are skipped without. Ctrl+X uses whichever
+// picker --picker auto finds.
+package main
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+	"unsafe"
+)
+
+// e2eTimeout is how long the shell gets to show what a test waits for
+const e2eTimeout = 15 * time.Second
+
+// e2eBuild builds zist into a directory to put first on the shell's PATH
+func e2eBuild(t *testing.T) string {
+	t.Helper()
+	bin := t.TempDir()
+	if out, err := exec.Command("go", "build", "-o", filepath.Join(bin, "zist"), ".").CombinedOutput(); err != nil {
+		t.Fatalf("failed to build zist: %v\n%s", err, out)
+	}
+	return bin
+}
+
+// openPTY opens a pseudo-terminal pair of rows by cols
+func openPTY(rows, cols uint16) (master, tty *os.File, err error) {
+	master, err = os.OpenFile("/dev/ptmx", os.O_RDWR|syscall.O_NOCTTY, 0)
+	if err != nil {
+		return nil, nil, err
+	}
+	var unlock int32
+	var n uint32
+	size := struct{ rows, cols, x, y uint16 }{rows, cols, 0, 0}
+	if err := ioctl(master, syscall.TIOCSPTLCK, unsafe.Pointer(&unlock)); err != nil {
+		master.Close()
+		return nil, nil, err
+	}
+	if err := ioctl(master, syscall.TIOCGPTN, unsafe.Pointer(&n)); err != nil {
+		master.Close()
+		return nil, nil, err
+	}
+	if err := ioctl(master, syscall.TIOCSWINSZ, unsafe.Pointer(&size)); err != nil {
+		master.Close()
+		return nil, nil, err
+	}
+	tty, err = os.OpenFile("/dev/pts/"+strconv.FormatUint(uint64(n), 10), os.O_RDWR|syscall.O_NOCTTY, 0)
+	if err != nil {
+		master.Close()
+		return nil, nil, err
+	}
+	return master, tty, nil
+}
+
+// e2eShell is an interactive zsh with the zist integration, in a
+// pseudo-terminal
+type e2eShell struct {
+	t      *testing.T
+	home   string
+	dbPath string
+	pty    *os.File
+	cmd    *exec.Cmd
+
+	mu   sync.Mutex
+	out  bytes.Buffer // Everything the shell wrote
+	seen int          // Offset in out up to which expect has matched
+	done chan struct{}
+}
+
+// e2eHome sets up a home directory whose .zshrc loads the zist
+// integration, with history going where zist collect looks by default
+func e2eHome(t *testing.T, bin string, env []string) (home, dbPath string) {
+	t.Helper()
+	home = t.TempDir()
+	dbPath = filepath.Join(home, "zist.db")
+	if err := os.MkdirAll(filepath.Join(home, ".histories"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	block, err := exec.Command(filepath.Join(bin, "zist"), "install", "--print").Output()
+	if err != nil {
+		t.Fatalf("zist install --print failed: %v", err)
+	}
+	rc := `HISTFILE=$HOME/.histories/zsh_history
+HISTSIZE=1000
+SAVEHIST=1000
+setopt EXTENDED_HISTORY INC_APPEND_HISTORY
+PROMPT='e2e> '
+`
+	for _, kv := range env {
+		rc += "export " + kv + "\n"
+	}
+	rc += string(block)
+	if err := os.WriteFile(filepath.Join(home, ".zshrc"), []byte(rc), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return home, dbPath
+}
+
+// startShell starts zsh in a new home, with env exported in .zshrc before
+// the zist block. Commands already in the database are seeded by seed.
+func startShell(t *testing.T, env []string, seed []Command) *e2eShell {
+	t.Helper()
+	zsh, err := exec.LookPath("zsh")
+	if err != nil {
+		t.Skip("zsh not found in PATH")
+	}
+	bin := e2eBuild(t)
+	home, dbPath := e2eHome(t, bin, env)
+
+	if len(seed) > 0 {
+		db, err := InitDB(dbPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, _, err = InsertCommands(context.Background(), db, seed)
+		db.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	master, tty, err := openPTY(24, 100)
+	if err != nil {
+		t.Fatalf("failed to open a pseudo-terminal: %v", err)
+	}
+	cmd := exec.Command(zsh, "-i")
+	cmd.Dir = home
+	cmd.Env = []string{
+		"HOME=" + home,
+		"ZDOTDIR=" + home,
+		"PATH=" + bin + string(os.PathListSeparator) + os.Getenv("PATH"),
+		"TERM=xterm-256color",
+		"ZIST_DB=" + dbPath,
+		"ZIST_CONFIG=" + filepath.Join(home, "config.toml"),
+		"XDG_DATA_HOME=" + filepath.Join(home, ".local", "share"),
+		"XDG_CONFIG_HOME=" + filepath.Join(home, ".config"),
+	}
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = tty, tty, tty
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true, Setctty: true}
+	if err := cmd.Start(); err != nil {
+		master.Close()
+		tty.Close()
+		t.Fatalf("failed to start zsh: %v", err)
+	}
+	tty.Close()
+
+	s := &e2eShell{t: t, home: home, dbPath: dbPath, pty: master, cmd: cmd, done: make(chan struct{})}
+	go func() {
+		defer close(s.done)
+		buf := make([]byte, 4096)
+		for {
+			n, err := master.Read(buf)
+			s.mu.Lock()
+			s.out.Write(buf[:n])
+			s.mu.Unlock()
+			if err != nil {
+				return
+			}
+		}
+	}()
+	t.Cleanup(s.close)
+	s.expect("e2e> ")
+	return s
+}
+
+// close exits the shell, killing it if it doesn't go
+func (s *e2eShell) close() {
+	s.pty.Write([]byte("exit\r"))
+	exited := make(chan struct{})
+	go func() {
+		s.cmd.Wait()
+		close(exited)
+	}()
+	select {
+	case <-exited:
+	case <-time.After(5 * time.Second):
+		s.cmd.Process.Kill()
+		<-exited
+	}
+	s.pty.Close()
+	<-s.done
+	if s.t.Failed() {
+		s.t.Logf("terminal output:\n%q", s.output())
+	}
+}
+
+// output is everything the shell wrote so far
+func (s *e2eShell) output() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.out.String()
+}
+
+// typeKeys sends keys to the shell as if typed
+func (s *e2eShell) typeKeys(keys string) {
+	s.t.Helper()
+	if _, err := s.pty.Write([]byte(keys)); err != nil {
+		s.t.Fatalf("failed to type %q: %v", keys, err)
+	}
+}
+
+// expect waits until the shell writes want after what earlier calls
+// matched, failing the test after e2eTimeout
+func (s *e2eShell) expect(want string) {
+	s.t.Helper()
+	deadline := time.Now().Add(e2eTimeout)
+	for time.Now().Before(deadline) {
+		s.mu.Lock()
+		if i := strings.Index(s.out.String()[s.seen:], want); i >= 0 {
+			s.seen += i + len(want)
+			s.mu.Unlock()
+			return
+		}
+		s.mu.Unlock()
+		time.Sleep(20 * time.Millisecond)
+	}
+	s.t.Fatalf("shell didn't show %q within %s", want, e2eTimeout)
+}
+
+// eventually polls check, pressing Enter on an empty line between tries so
+// the prompt hook runs again, until it holds or e2eTimeout passes
+func (s *e2eShell) eventually(what string, check func() bool) {
+	s.t.Helper()
+	deadline := time.Now().Add(e2eTimeout)
+	for time.Now().Before(deadline) {
+		if check() {
+			return
+		}
+		time.Sleep(500 * time.Millisecond)
+		s.typeKeys("\r")
+	}
+	s.t.Fatalf("%s didn't happen within %s", what, e2eTimeout)
+}
+
+// count runs a query returning one number against the shell's database
+func (s *e2eShell) count(query string, args ...interface{}) int {
+	s.t.Helper()
+	if _, err := os.Stat(s.dbPath); err != nil {
+		return 0
+	}
+	db, err := OpenReadOnlyDB(s.dbPath)
+	if err != nil {
+		s.t.Fatal(err)
+	}
+	defer db.Close()
+	var n int
+	if err := db.QueryRow(query, args...).Scan(&n); err != nil {
+		// The first collect may still be creating the schema
+		return 0
+	}
+	return n
+}
+
+func TestE2ECollect(t *testing.T) {
+	s := startShell(t, nil, nil)
+
+	// The arithmetic keeps the typed line from matching what it prints
+	s.typeKeys("echo collected-$((40+2))\r")
+	s.expect("collected-42")
+	s.eventually("collecting the command", func() bool {
+		return s.count(`SELECT COUNT(*) FROM commands WHERE command = ?`, "echo collected-$((40+2))") == 1
+	})
+	if n := s.count(`SELECT COUNT(DISTINCT source) FROM commands`); n != 1 {
+		t.Errorf("collected from %d sources, want the shell's history file", n)
+	}
+}
+
+func TestE2EPrefixSearch(t *testing.T) {
+	s := startShell(t, []string{"ZIST_PREFIX_SEARCH=1"}, []Command{
+		{Source: "/other/zsh_history", Timestamp: 1000, Command: "echo older-$((1+1))"},
+		{Source: "/other/zsh_history", Timestamp: 2000, Command: "echo newer-$((2+2))"},
+	})
+
+	// Up cycles through the database, newest first, from the typed prefix
+	s.typeKeys("echo \x1b[A\x1b[A\r")
+	s.expect("older-2")
+	s.expect("e2e> ")
+	// The line just run is collected as newer still, so narrow the prefix
+	s.typeKeys("echo n\x1b[A\r")
+	s.expect("newer-4")
+}
+
+func TestE2ESearchPick(t *testing.T) {
+	command := "echo picked-$((6*7))"
+	s := startShell(t, nil, []Command{{Source: "/other/zsh_history", Timestamp: 1000, Command: command}})
+
+	// Ctrl+X opens the picker on what was typed; Enter puts the match on the
+	// line and Enter again runs it
+	s.typeKeys("picked\x18")
+	s.expect(command)
+	time.Sleep(500 * time.Millisecond)
+	s.typeKeys("\r")
+	s.typeKeys("\r")
+	s.expect("picked-42")
+	s.eventually("counting the pick", func() bool {
+		return s.count(`SELECT COALESCE(SUM(picked_count), 0) FROM commands WHERE command = ?`, command) > 0
+	})
+}
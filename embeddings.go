@@ -0,0 +1,368 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"database/sql"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/tchaudhry91/zist/llm"
+)
+
+// Limits on embedding history
+const (
+	DefaultCollectEmbeddings = 200  // Most new distinct commands embedded per collect
+	embedBatch               = 32   // Commands per embedding request
+	maxEmbedCommand          = 2000 // bytes of a command that are embedded; the rest is cut
+)
+
+// embedConfig chooses the embedding model and its server, from the global
+// --embed-* flags. Embedding is off while Model is empty.
+var embedConfig llm.Config
+
+// newEmbedder returns a client for config, filling in the server from the
+// ZIST_LLM_* settings when it isn't given
+func newEmbedder(config llm.Config) (llm.Embedder, llm.Config, error) {
+	if config.Model == "" {
+		return nil, config, fmt.Errorf("no embedding model set (use --embed-model, e.g. nomic-embed-text)")
+	}
+	config, err := llmSettings(config)
+	if err != nil {
+		return nil, config, err
+	}
+	client, err := llm.New(config)
+	if err != nil {
+		return nil, config, fmt.Errorf("failed to create LLM client: %w", err)
+	}
+	e, ok := client.(llm.Embedder)
+	if !ok {
+		return nil, config, fmt.Errorf("the %s backend can't embed text (use openai or ollama)", config.Backend)
+	}
+	return e, config, nil
+}
+
+// encodeVector stores v scaled to unit length as little-endian float32s, so
+// cosine similarity is a dot product
+func encodeVector(v []float32) []byte {
+	var norm float64
+	for _, x := range v {
+		norm += float64(x) * float64(x)
+	}
+	norm = math.Sqrt(norm)
+	if norm == 0 {
+		norm = 1
+	}
+	b := make([]byte, 4*len(v))
+	for i, x := range v {
+		binary.LittleEndian.PutUint32(b[4*i:], math.Float32bits(float32(float64(x)/norm)))
+	}
+	return b
+}
+
+// decodeVector reads a vector written by encodeVector
+func decodeVector(b []byte) []float32 {
+	v := make([]float32, len(b)/4)
+	for i := range v {
+		v[i] = math.Float32frombits(binary.LittleEndian.Uint32(b[4*i:]))
+	}
+	return v
+}
+
+// dotProduct is the cosine similarity of two unit vectors, 0 when their
+// dimensions differ
+func dotProduct(a, b []float32) float32 {
+	if len(a) != len(b) {
+		return 0
+	}
+	// Four running sums let the loop run faster
+	var s0, s1, s2, s3 float32
+	i := 0
+	for ; i+4 <= len(a); i += 4 {
+		s0 += a[i] * b[i]
+		s1 += a[i+1] * b[i+1]
+		s2 += a[i+2] * b[i+2]
+		s3 += a[i+3] * b[i+3]
+	}
+	for ; i < len(a); i++ {
+		s0 += a[i] * b[i]
+	}
+	return s0 + s1 + s2 + s3
+}
+
+// embedText is what gets embedded for a command
+func embedText(command string) string {
+	text, _ := truncateCommand(command, maxEmbedCommand)
+	return text
+}
+
+// EmbedCommands stores a vector from e for each of commands, in batches of
+// embedBatch, and returns how many it stored. Commands stored before a
+// failed batch are kept.
+func EmbedCommands(ctx context.Context, db *sql.DB, e llm.Embedder, model string, commands []string) (int, error) {
+	stored := 0
+	for batch := range slices.Chunk(commands, embedBatch) {
+		texts := make([]string, len(batch))
+		for i, command := range batch {
+			texts[i] = embedText(command)
+		}
+		vectors, err := e.Embed(ctx, texts)
+		if err != nil {
+			return stored, err
+		}
+		if err := storeEmbeddings(ctx, db, model, batch, vectors); err != nil {
+			return stored, err
+		}
+		stored += len(batch)
+	}
+	return stored, nil
+}
+
+// storeEmbeddings saves the vector of each command under model
+func storeEmbeddings(ctx context.Context, db *sql.DB, model string, commands []string, vectors [][]float32) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	now := float64(time.Now().Unix())
+	for i, command := range commands {
+		if _, err := tx.ExecContext(ctx, `INSERT OR REPLACE INTO embeddings (command, model, vector, created_at) VALUES (?, ?, ?, ?)`,
+			command, model, encodeVector(vectors[i]), now); err != nil {
+			return fmt.Errorf("failed to store embedding: %w", err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit embeddings: %w", err)
+	}
+	return nil
+}
+
+// EmbedNewCommands embeds the distinct commands among the recent newest
+// rows that have no vector from model yet, which after a collect are the
+// commands it added. A command that misses out, say while the embedding
+// server is down, is embedded the next time it runs.
+func EmbedNewCommands(ctx context.Context, db *sql.DB, e llm.Embedder, model string, recent int) (int, error) {
+	if recent <= 0 {
+		return 0, nil
+	}
+	rows, err := db.QueryContext(ctx, `SELECT DISTINCT c.command FROM (SELECT command FROM commands ORDER BY rowid DESC LIMIT ?) c
+		WHERE NOT EXISTS (SELECT 1 FROM embeddings e WHERE e.command = c.command AND e.model = ?)`, recent, model)
+	if err != nil {
+		return 0, fmt.Errorf("failed to find new commands: %w", err)
+	}
+	commands, err := scanCommands(rows)
+	if err != nil {
+		return 0, err
+	}
+	return EmbedCommands(ctx, db, e, model, commands)
+}
+
+// EmbedMissingCommands embeds up to limit distinct commands with no vector
+// from model yet, most recently run first, or all of them when limit is 0
+func EmbedMissingCommands(ctx context.Context, db *sql.DB, e llm.Embedder, model string, limit int) (int, error) {
+	if limit <= 0 {
+		limit = -1
+	}
+	rows, err := db.QueryContext(ctx, `SELECT command FROM commands c
+		WHERE NOT EXISTS (SELECT 1 FROM embeddings e WHERE e.command = c.command AND e.model = ?)
+		GROUP BY command ORDER BY max(timestamp) DESC LIMIT ?`, model, limit)
+	if err != nil {
+		return 0, fmt.Errorf("failed to find commands to embed: %w", err)
+	}
+	commands, err := scanCommands(rows)
+	if err != nil {
+		return 0, err
+	}
+	return EmbedCommands(ctx, db, e, model, commands)
+}
+
+// scanCommands reads a column of commands and closes rows
+func scanCommands(rows *sql.Rows) ([]string, error) {
+	defer rows.Close()
+	var commands []string
+	for rows.Next() {
+		var command string
+		if err := rows.Scan(&command); err != nil {
+			return nil, fmt.Errorf("failed to scan command: %w", err)
+		}
+		commands = append(commands, command)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating commands: %w", err)
+	}
+	return commands, nil
+}
+
+// CountEmbeddings returns how many commands have a vector from model
+func CountEmbeddings(ctx context.Context, db *sql.DB, model string) (int, error) {
+	var n int
+	if err := db.QueryRowContext(ctx, `SELECT COUNT(*) FROM embeddings WHERE model = ?`, model).Scan(&n); err != nil {
+		return 0, fmt.Errorf("failed to count embeddings: %w", err)
+	}
+	return n, nil
+}
+
+// semanticMatch is a distinct command's latest run and how close it is to
+// the query
+type semanticMatch struct {
+	id      int64
+	command string
+	score   float32
+}
+
+// SemanticSearch returns the commands whose meaning is closest to
+// opts.Query, by cosine similarity of their vectors from model, each as its
+// latest run matching the filters. Only commands embedded with model are
+// found, and the archive isn't searched.
+func SemanticSearch(ctx context.Context, db *sql.DB, e llm.Embedder, model string, opts SearchOptions) ([]SearchResult, error) {
+	matches, err := rankBySimilarity(ctx, db, e, model, opts)
+	if err != nil {
+		return nil, err
+	}
+	return commandsByID(ctx, db, matches)
+}
+
+// rankBySimilarity returns up to opts.Limit distinct commands with vectors
+// from model, closest to opts.Query first. With a graph of the vectors
+// built by IndexEmbeddings, it is searched instead of comparing them all.
+func rankBySimilarity(ctx context.Context, db *sql.DB, e llm.Embedder, model string, opts SearchOptions) ([]semanticMatch, error) {
+	if strings.TrimSpace(opts.Query) == "" {
+		return nil, fmt.Errorf("semantic search needs a query")
+	}
+	if opts.IncludeArchive {
+		return nil, fmt.Errorf("semantic search doesn't cover the archive")
+	}
+	if opts.Limit <= 0 {
+		opts.Limit = 500
+	}
+
+	vectors, err := e.Embed(ctx, []string{opts.Query})
+	if err != nil {
+		return nil, err
+	}
+	if len(vectors) != 1 {
+		return nil, fmt.Errorf("embedding returned %d vectors for the query", len(vectors))
+	}
+	query := decodeVector(encodeVector(vectors[0]))
+
+	idx, err := GetANNIndex(ctx, db, model)
+	if err != nil {
+		return nil, err
+	}
+	if idx != nil {
+		matches, ok, err := rankByIndex(ctx, db, *idx, query, opts)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			return sortMatches(matches, opts.Limit), nil
+		}
+	}
+
+	// The latest run of each distinct command within the filters; bare
+	// columns come from the row holding max(timestamp)
+	filter, args := commandFilter(opts)
+	args = append(args, model)
+	rows, err := db.QueryContext(ctx, `SELECT m.id, m.command, e.vector
+		FROM (SELECT rowid AS id, command, max(timestamp) FROM commands c WHERE 1=1`+filter+` GROUP BY command) m
+		JOIN embeddings e ON e.command = m.command AND e.model = ?`, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read embeddings: %w", err)
+	}
+	var matches []semanticMatch
+	for rows.Next() {
+		var m semanticMatch
+		var vector []byte
+		if err := rows.Scan(&m.id, &m.command, &vector); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("failed to scan embedding: %w", err)
+		}
+		m.score = dotProduct(query, decodeVector(vector))
+		matches = append(matches, m)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating embeddings: %w", err)
+	}
+
+	return sortMatches(matches, opts.Limit), nil
+}
+
+// sortMatches orders matches closest first, latest run first among equals,
+// and keeps the first limit
+func sortMatches(matches []semanticMatch, limit int) []semanticMatch {
+	slices.SortFunc(matches, func(a, b semanticMatch) int {
+		return cmp.Or(cmp.Compare(b.score, a.score), cmp.Compare(b.id, a.id))
+	})
+	if len(matches) > limit {
+		matches = matches[:limit]
+	}
+	return matches
+}
+
+// commandFilter returns the conditions on commands aliased c for the
+// source and time filters of opts, and their parameters
+func commandFilter(opts SearchOptions) (string, []interface{}) {
+	filter := ""
+	var args []interface{}
+	if opts.Source != "" {
+		filter += " AND instr(c.source, ?) > 0"
+		args = append(args, opts.Source)
+	}
+	if opts.Since > 0 {
+		filter += " AND c.timestamp >= ?"
+		args = append(args, opts.Since)
+	}
+	if opts.Until > 0 {
+		filter += " AND c.timestamp <= ?"
+		args = append(args, opts.Until)
+	}
+	return filter, args
+}
+
+// commandsByID returns the commands of matches, in the same order
+func commandsByID(ctx context.Context, db *sql.DB, matches []semanticMatch) ([]SearchResult, error) {
+	if len(matches) == 0 {
+		return nil, nil
+	}
+	placeholders := strings.Repeat("?, ", len(matches)-1) + "?"
+	args := make([]interface{}, len(matches))
+	for i, m := range matches {
+		args[i] = m.id
+	}
+	rows, err := db.QueryContext(ctx, `SELECT c.rowid, c.command, c.source, c.timestamp, c.env_prefix, c.picked_count, COALESCE(n.note, ''), `+linksColumn("main", "c")+`
+		FROM commands c LEFT JOIN notes n ON n.source = c.source AND n.timestamp = c.timestamp
+		WHERE c.rowid IN (`+placeholders+`)`, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up commands: %w", err)
+	}
+	defer rows.Close()
+
+	byID := make(map[int64]SearchResult, len(matches))
+	for rows.Next() {
+		var r SearchResult
+		var links string
+		if err := rows.Scan(&r.ID, &r.Command, &r.Source, &r.Timestamp, &r.EnvPrefix, &r.Picked, &r.Note, &links); err != nil {
+			return nil, fmt.Errorf("failed to scan command: %w", err)
+		}
+		r.Links = splitLinks(links)
+		byID[r.ID] = r
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating commands: %w", err)
+	}
+
+	results := make([]SearchResult, 0, len(matches))
+	for _, m := range matches {
+		if r, ok := byID[m.id]; ok {
+			results = append(results, r)
+		}
+	}
+	return results, nil
+}
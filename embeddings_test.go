@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/tchaudhry91/zist/llm"
+)
+
+// conceptEmbedding puts text on an axis per topic it mentions, so related
+// commands are close without sharing words
+func conceptEmbedding(text string) []float32 {
+	topics := [][]string{
+		{"tar", "zip", "gzip", "compress", "archive"},
+		{"kubectl", "pod", "cluster", "kubernetes"},
+		{"git", "commit", "branch", "repository"},
+	}
+	v := make([]float32, len(topics)+1)
+	v[len(topics)] = 0.1
+	for i, words := range topics {
+		for _, w := range words {
+			if strings.Contains(text, w) {
+				v[i]++
+			}
+		}
+	}
+	return v
+}
+
+func TestSemanticSearch(t *testing.T) {
+	ctx := context.Background()
+	db, err := InitDB(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("InitDB() error = %v", err)
+	}
+	defer db.Close()
+
+	commands := []Command{
+		{Source: "/laptop", Timestamp: 1000, Command: "tar czf site.tgz public/"},
+		{Source: "/laptop", Timestamp: 1100, Command: "kubectl get pods -A"},
+		{Source: "/laptop", Timestamp: 1200, Command: "git commit -m wip"},
+		{Source: "/server", Timestamp: 1300, Command: "tar czf site.tgz public/"},
+		{Source: "/server", Timestamp: 1400, Command: "ls -la"},
+	}
+	if _, _, err := InsertCommands(ctx, db, commands); err != nil {
+		t.Fatal(err)
+	}
+
+	mock := &llm.Mock{Embedding: conceptEmbedding}
+	embedded, err := EmbedNewCommands(ctx, db, mock, "mock-embed", 3)
+	if err != nil || embedded != 3 {
+		t.Fatalf("EmbedNewCommands(3) = %d, %v, want the 3 distinct commands of the newest 3 rows", embedded, err)
+	}
+	embedded, err = EmbedNewCommands(ctx, db, mock, "mock-embed", len(commands))
+	if err != nil || embedded != 1 {
+		t.Fatalf("EmbedNewCommands(all) = %d, %v, want only the one left", embedded, err)
+	}
+	if n, _ := CountEmbeddings(ctx, db, "mock-embed"); n != 4 {
+		t.Errorf("CountEmbeddings() = %d, want 4", n)
+	}
+
+	results, err := SemanticSearch(ctx, db, mock, "mock-embed", SearchOptions{Query: "compress a directory", Limit: 2})
+	if err != nil {
+		t.Fatalf("SemanticSearch() error = %v", err)
+	}
+	if len(results) != 2 || results[0].Command != "tar czf site.tgz public/" || results[0].Source != "/server" {
+		t.Fatalf("SemanticSearch() = %+v, want the latest tar run first", results)
+	}
+
+	results, err = SemanticSearch(ctx, db, mock, "mock-embed", SearchOptions{Query: "compress a directory", Source: "laptop", Limit: 1})
+	if err != nil || len(results) != 1 || results[0].Source != "/laptop" || results[0].Timestamp != 1000 {
+		t.Errorf("SemanticSearch(source) = %+v, %v, want the laptop's tar run", results, err)
+	}
+	if results, _ := SemanticSearch(ctx, db, mock, "other-model", SearchOptions{Query: "compress"}); len(results) != 0 {
+		t.Errorf("SemanticSearch() with another model = %+v, want nothing", results)
+	}
+
+	// Forgetting a source keeps vectors of commands other sources ran
+	if _, err := ForgetSource(ctx, db, "/laptop"); err != nil {
+		t.Fatal(err)
+	}
+	if n, _ := CountEmbeddings(ctx, db, "mock-embed"); n != 2 {
+		t.Errorf("CountEmbeddings() after ForgetSource() = %d, want 2", n)
+	}
+}
+
+func TestEncodeVector(t *testing.T) {
+	v := decodeVector(encodeVector([]float32{3, 4}))
+	if len(v) != 2 || v[0] != 0.6 || v[1] != 0.8 {
+		t.Errorf("decodeVector(encodeVector([3 4])) = %v, want it at unit length", v)
+	}
+	if got := dotProduct(v, v); got < 0.999 || got > 1.001 {
+		t.Errorf("dotProduct() of a unit vector with itself = %v, want 1", got)
+	}
+	if got := dotProduct(v, []float32{1}); got != 0 {
+		t.Errorf("dotProduct() of different dimensions = %v, want 0", got)
+	}
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+)
+
+// DefaultEnvHints are the environment variables the wizard may mention when
+// no allowlist is configured. NAME shares the value, NAME:set only whether
+// the variable is set, and NAME:base the last element of a path value.
+var DefaultEnvHints = []string{
+	"AWS_PROFILE",
+	"AWS_REGION",
+	"AWS_DEFAULT_REGION",
+	"CLOUDSDK_ACTIVE_CONFIG_NAME",
+	"KUBECONFIG:set",
+	"DOCKER_HOST:set",
+	"VIRTUAL_ENV:base",
+	"CONDA_DEFAULT_ENV",
+	"NODE_ENV",
+}
+
+// maxEnvHintValue caps a shared value so a stray blob can't fill the prompt
+const maxEnvHintValue = 100
+
+// secretMarkers flag names whose values are never shared, whatever the
+// allowlist says
+var secretMarkers = []string{"KEY", "TOKEN", "SECRET", "PASSWORD", "PASSWD", "CREDENTIAL", "AUTH"}
+
+// EnvHints describes the allowlisted variables that are set, one per line,
+// e.g. "AWS_PROFILE=prod" or "KUBECONFIG is set"
+func EnvHints(allowlist []string, lookup func(string) (string, bool)) []string {
+	var hints []string
+	for _, entry := range allowlist {
+		name, mode, _ := strings.Cut(strings.TrimSpace(entry), ":")
+		if name == "" {
+			continue
+		}
+		value, ok := lookup(name)
+		if !ok || value == "" {
+			continue
+		}
+
+		if looksSecret(name) {
+			mode = "set"
+		}
+		switch mode {
+		case "":
+		case "base":
+			value = filepath.Base(value)
+		default:
+			hints = append(hints, name+" is set")
+			continue
+		}
+
+		if len(value) > maxEnvHintValue {
+			value = value[:maxEnvHintValue] + "..."
+		}
+		hints = append(hints, name+"="+value)
+	}
+	return hints
+}
+
+func looksSecret(name string) bool {
+	upper := strings.ToUpper(name)
+	for _, marker := range secretMarkers {
+		if strings.Contains(upper, marker) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestEnvHints(t *testing.T) {
+	env := map[string]string{
+		"AWS_PROFILE":       "prod",
+		"KUBECONFIG":        "/home/me/.kube/staging",
+		"VIRTUAL_ENV":       "/home/me/src/app/.venv-app",
+		"GITHUB_TOKEN":      "ghp_secret",
+		"MY_API_KEY":        "sk-123",
+		"EMPTY":             "",
+		"LONG":              strings.Repeat("x", 150),
+		"CONDA_DEFAULT_ENV": "ml",
+	}
+	lookup := func(name string) (string, bool) {
+		v, ok := env[name]
+		return v, ok
+	}
+
+	tests := []struct {
+		name      string
+		allowlist []string
+		want      []string
+	}{
+		{
+			"defaults",
+			DefaultEnvHints,
+			[]string{"AWS_PROFILE=prod", "KUBECONFIG is set", "VIRTUAL_ENV=.venv-app", "CONDA_DEFAULT_ENV=ml"},
+		},
+		{"value", []string{"KUBECONFIG"}, []string{"KUBECONFIG=/home/me/.kube/staging"}},
+		{"secrets never leak", []string{"GITHUB_TOKEN", "MY_API_KEY:base"}, []string{"GITHUB_TOKEN is set", "MY_API_KEY is set"}},
+		{"unset and empty skipped", []string{"NOPE", "EMPTY", " "}, nil},
+		{"long values truncated", []string{"LONG"}, []string{"LONG=" + strings.Repeat("x", maxEnvHintValue) + "..."}},
+		{"empty allowlist", nil, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := EnvHints(tt.allowlist, lookup); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("EnvHints() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
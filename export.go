@@ -0,0 +1,164 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+)
+
+// ChainGenesis is the prev of the first record of a hash-chained export
+var ChainGenesis = strings.Repeat("0", sha256.Size*2)
+
+// chainHashField ends every record of a hash-chained export, after the
+// fields it hashes
+var chainHashField = regexp.MustCompile(`,"hash":"([0-9a-f]{64})"}$`)
+
+// ExportRecord is one command, a line of `zist export`
+type ExportRecord struct {
+	Source    string  `json:"source"`
+	Timestamp float64 `json:"timestamp"`
+	Time      string  `json:"time"` // Timestamp in RFC 3339
+	Command   string  `json:"command"`
+	EnvPrefix string  `json:"env_prefix,omitempty"`
+	Duration  int     `json:"duration,omitempty"`
+	CWD       string  `json:"cwd,omitempty"`
+	ExitCode  *int    `json:"exit_code,omitempty"`
+	// Prev is the hash of the record before, or ChainGenesis, in a
+	// hash-chained export
+	Prev string `json:"prev,omitempty"`
+}
+
+// ExportOptions selects the commands `zist export` writes
+type ExportOptions struct {
+	Since  float64 // Unix timestamp, 0 for no lower bound
+	Until  float64 // Unix timestamp, 0 for no upper bound
+	Source string  // Only this source, "" for all
+	// Chain ends each record with the SHA-256 of the record up to there,
+	// which includes the hash of the record before, so no record can be
+	// changed, dropped or reordered without breaking every hash after it
+	Chain bool
+}
+
+// ExportCommands writes the commands opts selects to w as JSON lines,
+// oldest first, returning how many it wrote and, for a chain, the hash of
+// the last record.
+func ExportCommands(ctx context.Context, db *sql.DB, w io.Writer, opts ExportOptions) (int, string, error) {
+	// History files have no exit codes, which collect stores as 0, so only
+	// commands with a directory, from zist record, have a real one
+	query := `SELECT c.source, c.timestamp, COALESCE(o.command, c.command), c.env_prefix, COALESCE(c.duration, 0), COALESCE(c.cwd, ''),
+			CASE WHEN COALESCE(c.cwd, '') != '' THEN c.exit_code END
+		FROM commands c LEFT JOIN command_overflow o ON o.source = c.source AND o.timestamp = c.timestamp
+		WHERE 1=1`
+	var args []any
+	if opts.Since > 0 {
+		query += ` AND c.timestamp >= ?`
+		args = append(args, opts.Since)
+	}
+	if opts.Until > 0 {
+		query += ` AND c.timestamp <= ?`
+		args = append(args, opts.Until)
+	}
+	if opts.Source != "" {
+		query += ` AND c.source = ?`
+		args = append(args, opts.Source)
+	}
+	query += ` ORDER BY c.timestamp, c.source`
+
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return 0, "", fmt.Errorf("failed to read commands: %w", err)
+	}
+	defer rows.Close()
+
+	bw := bufio.NewWriter(w)
+	count, head := 0, ChainGenesis
+	for rows.Next() {
+		var r ExportRecord
+		var exitCode sql.NullInt64
+		if err := rows.Scan(&r.Source, &r.Timestamp, &r.Command, &r.EnvPrefix, &r.Duration, &r.CWD, &exitCode); err != nil {
+			return count, "", fmt.Errorf("failed to scan command: %w", err)
+		}
+		r.Time = JSONTimestamp(r.Timestamp)
+		if exitCode.Valid {
+			code := int(exitCode.Int64)
+			r.ExitCode = &code
+		}
+		if opts.Chain {
+			r.Prev = head
+		}
+		line, err := json.Marshal(r)
+		if err != nil {
+			return count, "", fmt.Errorf("failed to encode command: %w", err)
+		}
+		if opts.Chain {
+			line, head = chainRecord(line)
+		}
+		bw.Write(line)
+		bw.WriteByte('\n')
+		count++
+	}
+	if err := rows.Err(); err != nil {
+		return count, "", fmt.Errorf("failed to read commands: %w", err)
+	}
+	if err := bw.Flush(); err != nil {
+		return count, "", fmt.Errorf("failed to write export: %w", err)
+	}
+	if !opts.Chain {
+		head = ""
+	}
+	return count, head, nil
+}
+
+// chainRecord adds the hash field to record, a JSON object, and returns it
+// with the hash: the SHA-256 of record as it was, so checking a line only
+// needs its last field cut off again
+func chainRecord(record []byte) ([]byte, string) {
+	sum := sha256.Sum256(record)
+	hash := hex.EncodeToString(sum[:])
+	line := append(record[:len(record)-1:len(record)-1], `,"hash":"`+hash+`"}`...)
+	return line, hash
+}
+
+// VerifyChain checks a hash-chained export read from r: every record's
+// hash, and that each names the one before as prev. It returns how many
+// records there are and the hash of the last, which matches the head
+// printed at export only if nothing was cut off the end.
+func VerifyChain(r io.Reader) (int, string, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxHistoryLine)
+	count, head := 0, ChainGenesis
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		n := count + 1
+		m := chainHashField.FindSubmatchIndex(line)
+		if m == nil {
+			return count, head, fmt.Errorf("line %d: no hash at the end of the record", n)
+		}
+		record := append(bytes.Clone(line[:m[0]]), '}')
+		hash := string(line[m[2]:m[3]])
+		sum := sha256.Sum256(record)
+		if hex.EncodeToString(sum[:]) != hash {
+			return count, head, fmt.Errorf("line %d: record doesn't match its hash, it was changed", n)
+		}
+		var rec ExportRecord
+		if err := json.Unmarshal(record, &rec); err != nil {
+			return count, head, fmt.Errorf("line %d: invalid record: %w", n, err)
+		}
+		if rec.Prev != head {
+			return count, head, fmt.Errorf("line %d: prev doesn't match the record before, records were removed, added or reordered", n)
+		}
+		count, head = n, hash
+	}
+	if err := scanner.Err(); err != nil {
+		return count, head, fmt.Errorf("failed to read export: %w", err)
+	}
+	return count, head, nil
+}
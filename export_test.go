@@ -0,0 +1,111 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestExportCommands(t *testing.T) {
+	ctx := context.Background()
+	db, err := InitDB(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("InitDB() error = %v", err)
+	}
+	defer db.Close()
+
+	commands := []Command{
+		{Source: "/b", Timestamp: 1700000002, Command: "git status", Duration: 1},
+		{Source: "/a", Timestamp: 1700000001, Command: "make build", CWD: "/src", ExitCode: 2},
+		{Source: "/a", Timestamp: 1700000000, Command: "ls"},
+	}
+	if _, _, err := InsertCommands(ctx, db, commands); err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	count, head, err := ExportCommands(ctx, db, &buf, ExportOptions{})
+	if err != nil {
+		t.Fatalf("ExportCommands() error = %v", err)
+	}
+	if count != 3 || head != "" {
+		t.Errorf("ExportCommands() = %d, %q, want 3 and no head", count, head)
+	}
+	var got []string
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		var r ExportRecord
+		if err := json.Unmarshal([]byte(line), &r); err != nil {
+			t.Fatalf("line %q: %v", line, err)
+		}
+		if r.Prev != "" {
+			t.Errorf("unchained record has prev %q", r.Prev)
+		}
+		got = append(got, r.Command)
+	}
+	if want := []string{"ls", "make build", "git status"}; strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("exported %q, want oldest first %q", got, want)
+	}
+
+	buf.Reset()
+	if count, _, err := ExportCommands(ctx, db, &buf, ExportOptions{Source: "/a", Since: 1700000001}); err != nil || count != 1 {
+		t.Errorf("ExportCommands(source, since) = %d, %v, want 1", count, err)
+	}
+}
+
+func TestVerifyChain(t *testing.T) {
+	ctx := context.Background()
+	db, err := InitDB(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("InitDB() error = %v", err)
+	}
+	defer db.Close()
+
+	var commands []Command
+	for i, c := range []string{"ls", "echo \"<a&b>\"", "git status", "rm -rf build"} {
+		commands = append(commands, Command{Source: "/h", Timestamp: float64(1700000000 + i), Command: c})
+	}
+	if _, _, err := InsertCommands(ctx, db, commands); err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	_, head, err := ExportCommands(ctx, db, &buf, ExportOptions{Chain: true})
+	if err != nil {
+		t.Fatalf("ExportCommands() error = %v", err)
+	}
+	export := buf.String()
+	lines := strings.SplitAfter(strings.TrimSuffix(export, "\n"), "\n")
+
+	count, last, err := VerifyChain(strings.NewReader(export))
+	if err != nil || count != 4 || last != head {
+		t.Fatalf("VerifyChain() = %d, %q, %v, want 4, %q", count, last, err, head)
+	}
+
+	tests := []struct {
+		name   string
+		export string
+	}{
+		{"changed command", strings.Replace(export, "rm -rf build", "make build", 1)},
+		{"removed record", lines[0] + lines[2] + lines[3]},
+		{"reordered records", lines[1] + lines[0] + lines[2] + lines[3]},
+		{"unchained export", strings.ReplaceAll(export, `,"hash":`, `,"sum":`)},
+		{"rehashed without prev", lines[0] + lines[1] + `{"source":"/h","timestamp":1700000003,"command":"ls","prev":"` + ChainGenesis + `","hash":"` + strings.Repeat("a", 64) + `"}` + "\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, _, err := VerifyChain(strings.NewReader(tt.export)); err == nil {
+				t.Error("VerifyChain() = nil, want an error")
+			}
+		})
+	}
+
+	// Cutting records off the end keeps the chain valid, only the head
+	// tells
+	_, last, err = VerifyChain(strings.NewReader(lines[0] + lines[1]))
+	if err != nil || last == head {
+		t.Errorf("VerifyChain(truncated) = %q, %v, want a valid chain ending elsewhere", last, err)
+	}
+}
@@ -0,0 +1,9 @@
+//go:build !unix
+
+package main
+
+import "os"
+
+func fileInode(info os.FileInfo) uint64 {
+	return 0
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+)
+
+// CheckFTS verifies that the FTS index matches the commands table. An error
+// means search results may be silently missing and the index needs a rebuild.
+func CheckFTS(ctx context.Context, db *sql.DB) error {
+	if _, err := db.ExecContext(ctx, `INSERT INTO commands_fts(commands_fts, rank) VALUES('integrity-check', 1)`); err != nil {
+		return fmt.Errorf("FTS index out of sync: %w", err)
+	}
+	return nil
+}
+
+// RebuildFTS regenerates the FTS index from the commands table
+func RebuildFTS(ctx context.Context, db *sql.DB) error {
+	if _, err := db.ExecContext(ctx, `INSERT INTO commands_fts(commands_fts) VALUES('rebuild')`); err != nil {
+		return fmt.Errorf("failed to rebuild FTS index: %w", err)
+	}
+	return nil
+}
+
+// EnsureFTS checks the FTS index and rebuilds it if it is out of sync,
+// reporting whether a rebuild was needed
+func EnsureFTS(ctx context.Context, db *sql.DB) (bool, error) {
+	if err := CheckFTS(ctx, db); err == nil {
+		return false, nil
+	}
+	if err := RebuildFTS(ctx, db); err != nil {
+		return true, err
+	}
+	return true, CheckFTS(ctx, db)
+}
+
+// OptimizeFTS merges the FTS index's segments into one, which collects
+// leave many of
+func OptimizeFTS(ctx context.Context, db *sql.DB) error {
+	if _, err := db.ExecContext(ctx, `INSERT INTO commands_fts(commands_fts) VALUES('optimize')`); err != nil {
+		return fmt.Errorf("failed to optimize FTS index: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+)
+
+func TestEnsureFTS(t *testing.T) {
+	db, err := InitDB(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("InitDB() error = %v", err)
+	}
+	defer db.Close()
+
+	commands := []Command{
+		{Source: "/file1", Timestamp: 1000.0, Command: "docker ps"},
+		{Source: "/file1", Timestamp: 1001.0, Command: "kubectl get pods"},
+	}
+	if _, _, err := InsertCommands(context.Background(), db, commands); err != nil {
+		t.Fatalf("InsertCommands() error = %v", err)
+	}
+
+	if err := CheckFTS(context.Background(), db); err != nil {
+		t.Fatalf("CheckFTS() on fresh index error = %v", err)
+	}
+
+	// Simulate an out-of-sync index by bypassing the triggers
+	if _, err := db.Exec(`INSERT INTO commands_fts(commands_fts) VALUES('delete-all')`); err != nil {
+		t.Fatalf("failed to clear FTS index: %v", err)
+	}
+
+	results, err := SearchCommands(context.Background(), db, SearchOptions{Query: "docker"})
+	if err != nil {
+		t.Fatalf("SearchCommands() error = %v", err)
+	}
+	if len(results) != 0 {
+		t.Fatalf("SearchCommands('docker') with empty index returned %d results, want 0", len(results))
+	}
+
+	rebuilt, err := EnsureFTS(context.Background(), db)
+	if err != nil {
+		t.Fatalf("EnsureFTS() error = %v", err)
+	}
+	if !rebuilt {
+		t.Errorf("EnsureFTS() rebuilt = false, want true")
+	}
+
+	results, err = SearchCommands(context.Background(), db, SearchOptions{Query: "docker"})
+	if err != nil {
+		t.Fatalf("SearchCommands() error = %v", err)
+	}
+	if len(results) != 1 {
+		t.Errorf("SearchCommands('docker') after rebuild returned %d results, want 1", len(results))
+	}
+}
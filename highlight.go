@@ -0,0 +1,79 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/alecthomas/chroma/v2"
+	"github.com/alecthomas/chroma/v2/formatters"
+	"github.com/alecthomas/chroma/v2/lexers"
+)
+
+// shellStyle colors shell syntax from the terminal's own palette, leaving
+// plain words in its default color, so it reads on light and dark themes
+var shellStyle = chroma.MustNewStyle("zist", chroma.StyleEntries{
+	chroma.Keyword:       "#ansidarkblue",
+	chroma.NameBuiltin:   "#ansiteal",
+	chroma.NameVariable:  "#ansipurple",
+	chroma.LiteralString: "#ansidarkgreen",
+	chroma.LiteralNumber: "#ansidarkred",
+	chroma.Operator:      "#ansibrown",
+	chroma.Punctuation:   "#ansibrown",
+	chroma.Comment:       "italic #ansidarkgray",
+})
+
+// shellLexer is chroma's lexer for zsh, looked up once
+var shellLexer = sync.OnceValue(func() chroma.Lexer {
+	return lexers.Get("zsh")
+})
+
+// highlightCommand returns command with ANSI colors for its shell syntax,
+// or unchanged if it can't be lexed
+func highlightCommand(command string) string {
+	lexer := shellLexer()
+	if lexer == nil {
+		return command
+	}
+	tokens, err := lexer.Tokenise(nil, command)
+	if err != nil {
+		return command
+	}
+	var sb strings.Builder
+	if err := formatters.TTY16.Format(&sb, shellStyle, tokens); err != nil {
+		return command
+	}
+	return sb.String()
+}
+
+// writeRecordPreview prints the preview pane for a picker record: where
+// and when the command ran, the command itself, highlighted with color,
+// and its note and links
+func writeRecordPreview(w io.Writer, record string, color bool) error {
+	fields := strings.SplitN(strings.TrimSuffix(record, "\x00"), "\t", 8)
+	if len(fields) < 8 {
+		return fmt.Errorf("invalid picker record %q", record)
+	}
+	command := decodePickerField(fields[5])
+	if color {
+		command = highlightCommand(command)
+	}
+	when := fields[1]
+	if t, err := time.ParseInLocation(recordTimeLayout, when, time.Local); err == nil {
+		when = FormatTimestamp(float64(t.Unix()))
+	}
+	fmt.Fprintf(w, "Source: %s\nTime:   %s\n", decodePickerField(fields[0]), when)
+	if fields[2] != "" {
+		fmt.Fprintf(w, "ID:     %s\n", fields[2])
+	}
+	fmt.Fprintf(w, "\nCommand:\n%s\n", command)
+	if fields[3] != "" {
+		fmt.Fprintf(w, "\nNote:\n%s\n", fields[3])
+	}
+	if fields[4] != "" {
+		fmt.Fprintf(w, "\nLinks:\n%s\n", fields[4])
+	}
+	return nil
+}
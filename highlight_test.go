@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+var ansiEscape = regexp.MustCompile("\x1b\\[[0-9;]*m")
+
+func TestHighlightCommand(t *testing.T) {
+	tests := []struct {
+		command string
+		colored []string // Pieces that get a color of their own
+	}{
+		{`FOO=1 git log | grep "fix $X" # why`, []string{"\x1b[35mFOO", "\x1b[33m|", "\x1b[32mfix ", "\x1b[35m$X", "\x1b[90m# why"}},
+		{"for f in *; do\n  echo $f\ndone", []string{"\x1b[34mfor", "\x1b[36mecho", "\x1b[34mdone"}},
+		{"ls", nil},
+		{"", nil},
+	}
+	for _, tt := range tests {
+		got := highlightCommand(tt.command)
+		if plain := ansiEscape.ReplaceAllString(got, ""); plain != tt.command {
+			t.Errorf("highlightCommand(%q) changed the text to %q", tt.command, plain)
+		}
+		for _, want := range tt.colored {
+			if !strings.Contains(got, want) {
+				t.Errorf("highlightCommand(%q) = %q, missing %q", tt.command, got, want)
+			}
+		}
+		if tt.colored == nil && got != tt.command {
+			t.Errorf("highlightCommand(%q) = %q, want it unchanged", tt.command, got)
+		}
+	}
+}
+
+func TestRunShow(t *testing.T) {
+	ctx := context.Background()
+	dbPath := filepath.Join(t.TempDir(), "test.db")
+	db, err := InitDB(dbPath)
+	if err != nil {
+		t.Fatalf("InitDB() error = %v", err)
+	}
+	if _, _, err := InsertCommands(ctx, db, []Command{{Source: "/laptop", Timestamp: 1000, Command: "echo \"$HOME\"\tdone"}}); err != nil {
+		t.Fatal(err)
+	}
+	results, err := SearchCommands(ctx, db, SearchOptions{Limit: 1})
+	db.Close()
+	if err != nil || len(results) != 1 {
+		t.Fatalf("SearchCommands() = %v, %v", results, err)
+	}
+	results[0].Note = "prints home"
+
+	var out strings.Builder
+	if err := runShow(ctx, ShowRequest{DBPath: dbPath, ID: results[0].ID, Color: ColorNever}, &out); err != nil {
+		t.Fatalf("runShow() error = %v", err)
+	}
+	if want := "echo \"$HOME\"\tdone\n"; out.String() != want {
+		t.Errorf("runShow() = %q, want %q", out.String(), want)
+	}
+	if err := runShow(ctx, ShowRequest{DBPath: dbPath, ID: 99, Color: ColorNever}, &out); err == nil {
+		t.Error("runShow() of a missing ID succeeded")
+	}
+
+	var record strings.Builder
+	writeSearchRecords(&record, results, true)
+	out.Reset()
+	if err := runShow(ctx, ShowRequest{Record: record.String(), Color: ColorAlways}, &out); err != nil {
+		t.Fatalf("runShow(--record) error = %v", err)
+	}
+	want := "Source: /laptop\nTime:   " + FormatTimestamp(1000) + "\nID:     1\n\nCommand:\n" + highlightCommand("echo \"$HOME\"\tdone") + "\n\nNote:\nprints home\n"
+	if out.String() != want {
+		t.Errorf("runShow(--record) = %q, want %q", out.String(), want)
+	}
+	if err := runShow(ctx, ShowRequest{Record: "not a record", Color: ColorNever}, &out); err == nil {
+		t.Error("runShow() of a bad record succeeded")
+	}
+}
@@ -2,12 +2,13 @@ package main
 
 import (
 	"bufio"
+	"bytes"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
 	"strconv"
 	"strings"
-	"time"
 )
 
 type Command struct {
@@ -17,44 +18,123 @@ type Command struct {
 	Duration  int     // Execution duration in seconds
 	CWD       string  // Working directory (optional, not in ZSH history)
 	ExitCode  int     // Exit code (optional, not in ZSH history)
+	EnvPrefix string  // Leading VAR=value assignments (only when normalized)
+	Seq       int64   // Position in the source, increasing in the order commands ran
+	Offset    int64   // Byte offset of the command's entry in the history file
 }
 
+// maxHistoryLine is the longest history line ParseHistoryStream accepts
+const maxHistoryLine = 16 * 1024 * 1024
+
 type History struct {
 	Commands []Command
 }
 
 func ParseHistoryFile(file string) (*History, error) {
+	var history History
+	err := ParseHistoryStream(file, func(cmd Command) error {
+		history.Commands = append(history.Commands, cmd)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &history, nil
+}
+
+// ParseHistoryStream parses a ZSH or fish history file and calls fn for
+// every command in file order, so callers can process large files in
+// bounded memory.
+// Commands are numbered from 1 in Seq. zsh writes each newline inside a
+// command as a backslash ending the line; like zsh, that backslash is
+// dropped, so here-documents and functions come back as they were typed.
+// Returning an error from fn stops parsing and is returned as-is.
+func ParseHistoryStream(file string, fn func(Command) error) error {
+	return ParseHistoryStreamFrom(file, 0, fn)
+}
+
+// ParseHistoryStreamFrom is ParseHistoryStream starting offset bytes into
+// file, which must be the start of an entry. Offsets of the commands still
+// count from the start of the file, while Seq counts from 1 again.
+func ParseHistoryStreamFrom(file string, offset int64, fn func(Command) error) error {
 	absPath, err := filepath.Abs(file)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get absolute path: %w", err)
+		return fmt.Errorf("failed to get absolute path: %w", err)
 	}
 
 	f, err := os.Open(absPath)
 	if err != nil {
-		return nil, fmt.Errorf("failed to open history file: %w", err)
+		return fmt.Errorf("failed to open history file: %w", err)
 	}
 	defer f.Close()
+	if offset == 0 {
+		return ParseHistoryReader(f, absPath, fn)
+	}
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		return fmt.Errorf("failed to seek history file: %w", err)
+	}
+	return ParseHistoryReader(f, absPath, func(cmd Command) error {
+		cmd.Offset += offset
+		return fn(cmd)
+	})
+}
 
-	scanner := bufio.NewScanner(f)
-	var history History
+// ParseHistoryReader is ParseHistoryStream for history read from r, such as
+// stdin, with source as the Source of every command
+func ParseHistoryReader(r io.Reader, source string, fn func(Command) error) error {
+	br := bufio.NewReader(r)
+	if head, _ := br.Peek(len(fishEntryPrefix)); string(head) == fishEntryPrefix {
+		return ParseFishHistory(br, source, fn)
+	}
+
+	scanner, lineStart := newLineScanner(br)
+	subsecond := newSubsecondCounter()
 	var currentCommand strings.Builder
 	var currentTimestamp int64
 	var currentDuration int
+	var currentOffset int64
 	var hasCommand bool
+	var seq int64
+
+	// A trailing backslash is held back until the next line shows whether
+	// it marked a newline
+	var pendingBackslash bool
+	writeLine := func(line string) {
+		line, pendingBackslash = strings.CutSuffix(line, "\\")
+		currentCommand.WriteString(line)
+	}
+
+	emit := func() error {
+		if pendingBackslash {
+			currentCommand.WriteString("\\")
+			pendingBackslash = false
+		}
+		if !hasCommand || currentCommand.Len() == 0 {
+			return nil
+		}
+		seq++
+		cmd := Command{
+			Source:    source,
+			Timestamp: subsecond.next(currentTimestamp),
+			Command:   strings.TrimSpace(currentCommand.String()),
+			Duration:  currentDuration,
+			Seq:       seq,
+			Offset:    currentOffset,
+		}
+		currentCommand.Reset()
+		return fn(cmd)
+	}
 
 	for scanner.Scan() {
 		line := scanner.Text()
 
-		if strings.HasPrefix(line, ": ") {
-			if hasCommand && currentCommand.Len() > 0 {
-				history.Commands = append(history.Commands, Command{
-					Source:    absPath,
-					Timestamp: float64(currentTimestamp),
-					Command:   strings.TrimSpace(currentCommand.String()),
-					Duration:  currentDuration,
-				})
-				currentCommand.Reset()
+		// After a backslash the line belongs to the command even if it looks
+		// like an entry, e.g. ": " lines in a here-document
+		if strings.HasPrefix(line, ": ") && !pendingBackslash {
+			if err := emit(); err != nil {
+				return err
 			}
+			currentOffset = *lineStart
 
 			metaAndCmd := strings.SplitN(line[2:], ";", 2)
 			if len(metaAndCmd) != 2 {
@@ -74,55 +154,265 @@ func ParseHistoryFile(file string) (*History, error) {
 				currentDuration = duration
 			}
 
-			currentCommand.WriteString(metaAndCmd[1])
+			writeLine(metaAndCmd[1])
 			hasCommand = true
 		} else if hasCommand {
 			currentCommand.WriteString("\n")
-			currentCommand.WriteString(line)
+			writeLine(line)
 		}
 	}
 
-	if hasCommand && currentCommand.Len() > 0 {
-		history.Commands = append(history.Commands, Command{
-			Source:    absPath,
-			Timestamp: float64(currentTimestamp),
-			Command:   strings.TrimSpace(currentCommand.String()),
-			Duration:  currentDuration,
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("scanner error: %w", err)
+	}
+
+	return emit()
+}
+
+// fishEntryPrefix starts every entry of a fish history file
+const fishEntryPrefix = "- cmd: "
+
+// ParseFishHistory parses fish's fish_history, a YAML-like list of entries
+// each starting with a "- cmd: " line, followed by an indented "when: "
+// timestamp and sometimes the "paths:" the command touched, and calls fn for
+// every command as ParseHistoryStream does. fish writes newlines inside a
+// command as \n and backslashes as \\; both are undone. Paths aren't kept.
+func ParseFishHistory(r io.Reader, source string, fn func(Command) error) error {
+	scanner, lineStart := newLineScanner(r)
+	subsecond := newSubsecondCounter()
+	var command string
+	var timestamp, offset int64
+	var hasCommand bool
+	var seq int64
+
+	emit := func() error {
+		if !hasCommand || strings.TrimSpace(command) == "" {
+			return nil
+		}
+		hasCommand = false
+		seq++
+		return fn(Command{
+			Source:    source,
+			Timestamp: subsecond.next(timestamp),
+			Command:   strings.TrimSpace(command),
+			Seq:       seq,
+			Offset:    offset,
 		})
 	}
 
+	for scanner.Scan() {
+		line := scanner.Text()
+		if cmd, ok := strings.CutPrefix(line, fishEntryPrefix); ok {
+			if err := emit(); err != nil {
+				return err
+			}
+			command, timestamp, offset, hasCommand = unescapeFish(cmd), 0, *lineStart, true
+		} else if when, ok := strings.CutPrefix(line, "  when: "); ok && hasCommand {
+			if ts, err := strconv.ParseInt(strings.TrimSpace(when), 10, 64); err == nil {
+				timestamp = ts
+			}
+		}
+	}
+
 	if err := scanner.Err(); err != nil {
-		return nil, fmt.Errorf("scanner error: %w", err)
+		return fmt.Errorf("scanner error: %w", err)
 	}
 
-	history = addSubsecondTimestamps(history)
+	return emit()
+}
 
-	return &history, nil
+// newLineScanner scans r line by line, setting *start to the byte offset in
+// r where the line just scanned began
+func newLineScanner(r io.Reader) (*bufio.Scanner, *int64) {
+	scanner := bufio.NewScanner(r)
+	// Pasted scripts can make single lines far longer than the default 64KB
+	scanner.Buffer(make([]byte, 64*1024), maxHistoryLine)
+	var pos, start int64
+	scanner.Split(func(data []byte, atEOF bool) (int, []byte, error) {
+		advance, token, err := bufio.ScanLines(data, atEOF)
+		if token != nil {
+			start = pos
+		}
+		pos += int64(advance)
+		return advance, token, err
+	})
+	return scanner, &start
+}
+
+// unescapeFish undoes the escaping of commands in fish_history
+func unescapeFish(s string) string {
+	if !strings.Contains(s, "\\") {
+		return s
+	}
+	var sb strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+1 < len(s) {
+			switch s[i+1] {
+			case 'n':
+				sb.WriteByte('\n')
+				i++
+				continue
+			case '\\':
+				sb.WriteByte('\\')
+				i++
+				continue
+			}
+		}
+		sb.WriteByte(s[i])
+	}
+	return sb.String()
+}
+
+// subsecondCounter spreads commands sharing the same second across
+// millisecond offsets so they stay unique under the (source, timestamp) key.
+type subsecondCounter map[int64]int
+
+func newSubsecondCounter() subsecondCounter {
+	return make(subsecondCounter)
+}
+
+func (c subsecondCounter) next(ts int64) float64 {
+	index := c[ts]
+	c[ts] = index + 1
+	return float64(ts) + float64(index)*0.001
 }
 
 func addSubsecondTimestamps(history History) History {
-	timestampMap := make(map[int64]int)
+	subsecond := newSubsecondCounter()
 	result := make([]Command, 0, len(history.Commands))
 
 	for _, cmd := range history.Commands {
-		ts := int64(cmd.Timestamp)
-		index := timestampMap[ts]
-		timestampMap[ts] = index + 1
-
 		result = append(result, Command{
 			Source:    cmd.Source,
-			Timestamp: float64(ts) + float64(index)*0.001,
+			Timestamp: subsecond.next(int64(cmd.Timestamp)),
 			Command:   cmd.Command,
 			Duration:  cmd.Duration,
 			CWD:       cmd.CWD,
 			ExitCode:  cmd.ExitCode,
+			EnvPrefix: cmd.EnvPrefix,
+			Seq:       cmd.Seq,
+			Offset:    cmd.Offset,
 		})
 	}
 
 	return History{Commands: result}
 }
 
-func FormatTimestamp(ts float64) string {
-	t := time.Unix(int64(ts), int64((ts-float64(int64(ts)))*1e9))
-	return t.Format("2006-01-02 15:04:05")
+// SplitEnvPrefix separates leading environment assignments such as
+// `FOO=bar BAZ="a b" cmd --flag` into the assignments and the command that
+// actually runs. Commands that are only assignments are returned unchanged.
+func SplitEnvPrefix(command string) (string, string) {
+	rest := command
+	end := 0
+	for {
+		trimmed := strings.TrimLeft(rest, " \t")
+		n := envAssignmentLen(trimmed)
+		if n == 0 {
+			break
+		}
+		end += len(rest) - len(trimmed) + n
+		rest = trimmed[n:]
+	}
+
+	rest = strings.TrimLeft(rest, " \t")
+	if end == 0 || rest == "" {
+		return "", command
+	}
+	return strings.TrimSpace(command[:end]), rest
+}
+
+// JoinContinuations puts commands split over lines with trailing
+// backslashes back on one line, as the shell reads them: `ls \` followed by
+// an indented `-la` becomes `ls -la`. Commands with here-documents are left
+// alone, since a backslash in one may be data.
+func JoinContinuations(command string) string {
+	if !strings.Contains(command, "\\\n") || strings.Contains(command, "<<") {
+		return command
+	}
+
+	var out []byte
+	var single, double bool
+	for i := 0; i < len(command); i++ {
+		c := command[i]
+		switch {
+		case c == '\'' && !double:
+			single = !single
+		case c == '"' && !single:
+			double = !double
+		case c == '\\' && !single && i+1 < len(command):
+			i++
+			if command[i] != '\n' {
+				out = append(out, c, command[i])
+				continue
+			}
+			if double {
+				// Quoted, so only the backslash and newline go
+				continue
+			}
+			// Indentation around the break collapses to one space
+			trimmed := bytes.TrimRight(out, " \t")
+			spaced := len(trimmed) < len(out)
+			out = trimmed
+			for i+1 < len(command) && (command[i+1] == ' ' || command[i+1] == '\t') {
+				i++
+				spaced = true
+			}
+			if spaced {
+				out = append(out, ' ')
+			}
+			continue
+		}
+		out = append(out, c)
+	}
+	return string(out)
+}
+
+// envAssignmentLen returns the length of a NAME=value word at the start of
+// s, honouring quotes and backslash escapes in the value, or 0 if s does not
+// start with an assignment.
+func envAssignmentLen(s string) int {
+	i := 0
+	for i < len(s) && (s[i] == '_' || s[i] >= 'a' && s[i] <= 'z' || s[i] >= 'A' && s[i] <= 'Z' || i > 0 && s[i] >= '0' && s[i] <= '9') {
+		i++
+	}
+	if i == 0 || i >= len(s) || s[i] != '=' {
+		return 0
+	}
+	i++
+
+	var quote byte
+	for ; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			} else if c == '\\' && quote == '"' && i+1 < len(s) {
+				i++
+			}
+		case c == '\'' || c == '"':
+			quote = c
+		case c == '\\' && i+1 < len(s):
+			i++
+		case c == ' ' || c == '\t':
+			return i
+		case c == '\n' || c == ';' || c == '&' || c == '|':
+			return 0
+		}
+	}
+	if quote != 0 {
+		return 0
+	}
+	return i
+}
+
+// CommandBinary returns the program a command line runs, skipping any
+// leading environment assignments
+func CommandBinary(command string) string {
+	_, rest := SplitEnvPrefix(command)
+	fields := strings.Fields(rest)
+	if len(fields) == 0 {
+		return ""
+	}
+	return fields[0]
 }
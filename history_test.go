@@ -1,8 +1,10 @@
 package main
 
 import (
+	"errors"
 	"os"
 	"path/filepath"
+	"slices"
 	"strings"
 	"testing"
 )
@@ -206,3 +208,244 @@ func TestParseHistoryFile_Duration(t *testing.T) {
 		}
 	}
 }
+
+func TestParseHistoryStream(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	content := `: 1704384000:0;cmd1
+: 1704384000:0;cmd2
+: 1704384001:0;cmd3`
+
+	historyFile := filepath.Join(tmpDir, "stream.hist")
+	if err := os.WriteFile(historyFile, []byte(content), 0644); err != nil {
+		t.Fatalf("failed to write history file: %v", err)
+	}
+
+	t.Run("all commands in order", func(t *testing.T) {
+		var got []Command
+		err := ParseHistoryStream(historyFile, func(cmd Command) error {
+			got = append(got, cmd)
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("ParseHistoryStream() error = %v", err)
+		}
+
+		want := []struct {
+			ts  float64
+			cmd string
+		}{
+			{1704384000.000, "cmd1"},
+			{1704384000.001, "cmd2"},
+			{1704384001.000, "cmd3"},
+		}
+		if len(got) != len(want) {
+			t.Fatalf("got %d commands, want %d", len(got), len(want))
+		}
+		for i, w := range want {
+			if got[i].Timestamp != w.ts || got[i].Command != w.cmd {
+				t.Errorf("command[%d] = (%v, %q), want (%v, %q)", i, got[i].Timestamp, got[i].Command, w.ts, w.cmd)
+			}
+			if got[i].Seq != int64(i+1) {
+				t.Errorf("command[%d].Seq = %d, want %d", i, got[i].Seq, i+1)
+			}
+		}
+	})
+
+	t.Run("from an offset", func(t *testing.T) {
+		// cmd1 and cmd2 are 20 bytes each
+		var got []Command
+		err := ParseHistoryStreamFrom(historyFile, 20, func(cmd Command) error {
+			got = append(got, cmd)
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("ParseHistoryStreamFrom() error = %v", err)
+		}
+		if len(got) != 2 || got[0].Command != "cmd2" || got[0].Offset != 20 || got[0].Seq != 1 || got[1].Command != "cmd3" || got[1].Offset != 40 {
+			t.Errorf("ParseHistoryStreamFrom() = %+v, want cmd2 at 20 and cmd3 at 40", got)
+		}
+	})
+
+	t.Run("callback error stops parsing", func(t *testing.T) {
+		stop := errors.New("stop")
+		calls := 0
+		err := ParseHistoryStream(historyFile, func(cmd Command) error {
+			calls++
+			return stop
+		})
+		if !errors.Is(err, stop) {
+			t.Errorf("ParseHistoryStream() error = %v, want %v", err, stop)
+		}
+		if calls != 1 {
+			t.Errorf("callback called %d times, want 1", calls)
+		}
+	})
+}
+
+// zsh writes a newline inside a command as a backslash ending the line
+func TestParseMultilineCommands(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    []string
+	}{
+		{
+			name:    "here-document",
+			content: ": 1704384000:0;cat > notes.txt <<'EOF'\\\nfirst\\\n: not an entry\\\nEOF\n: 1704384010:0;ls\n",
+			want:    []string{"cat > notes.txt <<'EOF'\nfirst\n: not an entry\nEOF", "ls"},
+		},
+		{
+			name:    "function",
+			content: ": 1704384000:0;greet() {\\\n\techo \"hi $1\"\\\n}\n",
+			want:    []string{"greet() {\n\techo \"hi $1\"\n}"},
+		},
+		{
+			name:    "typed continuation",
+			content: ": 1704384000:0;docker run \\\\\n  --rm app\n",
+			want:    []string{"docker run \\\n  --rm app"},
+		},
+		{
+			name:    "trailing backslash",
+			content: ": 1704384000:0;echo a\\\n",
+			want:    []string{"echo a\\"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			historyFile := filepath.Join(t.TempDir(), "history")
+			if err := os.WriteFile(historyFile, []byte(tt.content), 0644); err != nil {
+				t.Fatal(err)
+			}
+			history, err := ParseHistoryFile(historyFile)
+			if err != nil {
+				t.Fatalf("ParseHistoryFile() error = %v", err)
+			}
+			var got []string
+			for _, cmd := range history.Commands {
+				got = append(got, cmd.Command)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("commands = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestJoinContinuations(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  string
+	}{
+		{"single line", "ls -la", "ls -la"},
+		{"indented", "docker run \\\n  --rm \\\n  app", "docker run --rm app"},
+		{"no space", "ec\\\nho hi", "echo hi"},
+		{"double quoted", "echo \"a \\\n  b\"", "echo \"a   b\""},
+		{"single quoted", "echo 'a \\\nb'", "echo 'a \\\nb'"},
+		{"escaped backslash", "echo a\\\\\nls", "echo a\\\\\nls"},
+		{"plain newline", "cd /tmp\nls", "cd /tmp\nls"},
+		{"here-document", "cat <<EOF\na \\\nb\nEOF", "cat <<EOF\na \\\nb\nEOF"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := JoinContinuations(tt.input); got != tt.want {
+				t.Errorf("JoinContinuations(%q) = %q, want %q", tt.input, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSplitEnvPrefix(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		wantEnv string
+		wantCmd string
+	}{
+		{"no prefix", "ls -la", "", "ls -la"},
+		{"single", "FOO=bar make test", "FOO=bar", "make test"},
+		{"multiple", "A=1 B=2 go test ./...", "A=1 B=2", "go test ./..."},
+		{"quoted value", `MSG="hello world" echo $MSG`, `MSG="hello world"`, "echo $MSG"},
+		{"single quoted", `X='a b' cmd`, `X='a b'`, "cmd"},
+		{"empty value", "FOO= cmd", "FOO=", "cmd"},
+		{"assignment only", "FOO=bar", "", "FOO=bar"},
+		{"separate statement", "FOO=bar; cmd", "", "FOO=bar; cmd"},
+		{"not a name", "1X=2 cmd", "", "1X=2 cmd"},
+		{"unterminated quote", `A="x cmd`, "", `A="x cmd`},
+		{"flag with equals", "cmd --opt=1", "", "cmd --opt=1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env, cmd := SplitEnvPrefix(tt.input)
+			if env != tt.wantEnv || cmd != tt.wantCmd {
+				t.Errorf("SplitEnvPrefix(%q) = (%q, %q), want (%q, %q)", tt.input, env, cmd, tt.wantEnv, tt.wantCmd)
+			}
+		})
+	}
+}
+
+func TestCommandBinary(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{"git status", "git"},
+		{"GOOS=linux go build", "go"},
+		{"", ""},
+	}
+
+	for _, tt := range tests {
+		if got := CommandBinary(tt.input); got != tt.want {
+			t.Errorf("CommandBinary(%q) = %q, want %q", tt.input, got, tt.want)
+		}
+	}
+}
+
+func TestParseFishHistory(t *testing.T) {
+	content := `- cmd: git status
+  when: 1704384000
+- cmd: vim README.md
+  when: 1704384000
+  paths:
+    - README.md
+- cmd: for f in *.go\n    echo $f\nend
+  when: 1704384005
+- cmd: echo a\\nb \d
+  when: 1704384010
+`
+	historyFile := filepath.Join(t.TempDir(), "fish_history")
+	if err := os.WriteFile(historyFile, []byte(content), 0644); err != nil {
+		t.Fatalf("failed to write history file: %v", err)
+	}
+
+	history, err := ParseHistoryFile(historyFile)
+	if err != nil {
+		t.Fatalf("ParseHistoryFile() error = %v", err)
+	}
+
+	want := []struct {
+		ts  float64
+		cmd string
+	}{
+		{1704384000.000, "git status"},
+		{1704384000.001, "vim README.md"},
+		{1704384005.000, "for f in *.go\n    echo $f\nend"},
+		{1704384010.000, `echo a\nb \d`},
+	}
+	if len(history.Commands) != len(want) {
+		t.Fatalf("got %d commands, want %d: %+v", len(history.Commands), len(want), history.Commands)
+	}
+	for i, w := range want {
+		got := history.Commands[i]
+		if got.Timestamp != w.ts || got.Command != w.cmd {
+			t.Errorf("command[%d] = (%v, %q), want (%v, %q)", i, got.Timestamp, got.Command, w.ts, w.cmd)
+		}
+		if got.Seq != int64(i+1) {
+			t.Errorf("command[%d].Seq = %d, want %d", i, got.Seq, i+1)
+		}
+	}
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"time"
+)
+
+// Events hooks can be set for, with --on-collect, --on-wizard-generate and
+// --on-search-select
+const (
+	HookCollect        = "collect"
+	HookWizardGenerate = "wizard_generate"
+	HookSearchSelect   = "search_select"
+)
+
+// hookTimeout is how long a hook may run before it is killed. Hooks run
+// before zist exits, so ones that take long should background themselves.
+const hookTimeout = 10 * time.Second
+
+// hooks maps events to the shell commands run on them
+var hooks = map[string]string{}
+
+// CollectEvent is sent to the on-collect hook after every collect that ran
+type CollectEvent struct {
+	Event    string       `json:"event"`
+	Inserted int          `json:"inserted"`
+	Ignored  int          `json:"ignored"`
+	Runs     []CollectRun `json:"runs"` // One per history file
+}
+
+// WizardGenerateEvent is sent to the on-wizard-generate hook for every
+// command or plan the wizard came up with
+type WizardGenerateEvent struct {
+	Event     string `json:"event"`
+	Mode      string `json:"mode"`   // "query", "plan" or "ghost"
+	Source    string `json:"source"` // "cache" or "llm"
+	Model     string `json:"model"`
+	Query     string `json:"query"`
+	Command   string `json:"command"` // Plan steps are joined with " && "
+	LatencyMs int64  `json:"latency_ms"`
+}
+
+// SearchSelectEvent is sent to the on-search-select hook when a command is
+// picked in `zist search`
+type SearchSelectEvent struct {
+	Event   string `json:"event"`
+	Query   string `json:"query"` // What the search started with
+	Command string `json:"command"`
+	Source  string `json:"source"`
+	ID      int64  `json:"id,omitempty"` // For `zist note`; 0 for archived commands and snippets
+}
+
+// runHook runs the command set for event, if any, with sh and payload as
+// JSON on stdin. The hook's output goes to stderr, so it never mixes with
+// what zist prints for the shell.
+func runHook(event string, payload any) error {
+	command := hooks[event]
+	if command == "" {
+		return nil
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("failed to encode %s event: %w", event, err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), hookTimeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, "sh", "-c", command)
+	cmd.Stdin = bytes.NewReader(body)
+	cmd.Stdout = os.Stderr
+	cmd.Stderr = os.Stderr
+	cmd.Env = append(os.Environ(), "ZIST_HOOK_EVENT="+event)
+	// Don't wait on anything the hook left running in the background
+	cmd.WaitDelay = time.Second
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("%s hook failed: %w", event, err)
+	}
+	return nil
+}
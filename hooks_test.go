@@ -0,0 +1,46 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCollectHook(t *testing.T) {
+	dir := t.TempDir()
+	history, out := filepath.Join(dir, "zsh_history"), filepath.Join(dir, "event.json")
+	if err := os.WriteFile(history, []byte(": 1704384000:0;ls\n: 1704384010:0;pwd\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	defer delete(hooks, HookCollect)
+	hooks[HookCollect] = `[ "$ZIST_HOOK_EVENT" = collect ] && cat > ` + shellQuote(out)
+	if err := runCollect(context.Background(), filepath.Join(dir, "test.db"), []string{history}, CollectOptions{Quiet: true}); err != nil {
+		t.Fatalf("runCollect() error = %v", err)
+	}
+
+	b, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatalf("hook didn't run: %v", err)
+	}
+	var event CollectEvent
+	if err := json.Unmarshal(b, &event); err != nil {
+		t.Fatalf("hook got %q: %v", b, err)
+	}
+	if event.Event != HookCollect || event.Inserted != 2 || len(event.Runs) != 1 || event.Runs[0].Source != history || event.Runs[0].Parsed != 2 {
+		t.Errorf("hook got %+v", event)
+	}
+}
+
+func TestRunHook(t *testing.T) {
+	defer delete(hooks, HookSearchSelect)
+	if err := runHook(HookSearchSelect, SearchSelectEvent{}); err != nil {
+		t.Errorf("runHook() without a hook error = %v", err)
+	}
+	hooks[HookSearchSelect] = "exit 3"
+	if err := runHook(HookSearchSelect, SearchSelectEvent{}); err == nil {
+		t.Error("runHook() error = nil for a failing hook")
+	}
+}
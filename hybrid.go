@@ -0,0 +1,110 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"database/sql"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/tchaudhry91/zist/llm"
+)
+
+// rrfK damps how much the top few ranks of either list dominate reciprocal
+// rank fusion; 60 is the value from the paper that introduced it
+const rrfK = 60
+
+// rankByKeywords returns up to opts.Limit distinct commands matching any
+// keyword of opts.Query in the full-text index, best bm25 score first, each
+// as its latest run within the filters with its note, links and run count
+func rankByKeywords(ctx context.Context, db *sql.DB, opts SearchOptions) ([]AskRow, error) {
+	var terms []string
+	for _, kw := range extractKeywords(opts.Query) {
+		terms = append(terms, quoteFTS(kw)+"*")
+	}
+	if len(terms) == 0 {
+		return nil, nil
+	}
+	if opts.Limit <= 0 {
+		opts.Limit = 500
+	}
+
+	// Rank distinct commands, then look up the latest run of each
+	filter, filterArgs := commandFilter(opts)
+	args := append([]interface{}{strings.Join(terms, " OR ")}, filterArgs...)
+	args = append(args, opts.Limit)
+	query := `SELECT c.rowid, c.command, c.source, c.timestamp, c.env_prefix, COALESCE(n.note, ''), ` + linksColumn("main", "c") + `, m.runs
+		FROM (SELECT c.command, max(c.timestamp) AS latest, COUNT(*) AS runs, min(f.rank) AS score
+			FROM commands_fts f JOIN commands c ON c.rowid = f.rowid
+			WHERE commands_fts MATCH ?` + filter + `
+			GROUP BY c.command ORDER BY score, latest DESC LIMIT ?) m
+		JOIN commands c ON c.command = m.command AND c.timestamp = m.latest
+		LEFT JOIN notes n ON n.source = c.source AND n.timestamp = c.timestamp
+		GROUP BY m.command ORDER BY m.score, m.latest DESC`
+
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to search history: %w", err)
+	}
+	defer rows.Close()
+
+	var found []AskRow
+	for rows.Next() {
+		var r AskRow
+		var links string
+		if err := rows.Scan(&r.ID, &r.Command, &r.Source, &r.Timestamp, &r.EnvPrefix, &r.Note, &links, &r.Runs); err != nil {
+			return nil, fmt.Errorf("failed to scan command: %w", err)
+		}
+		r.Links = splitLinks(links)
+		found = append(found, r)
+	}
+	return found, rows.Err()
+}
+
+// HybridSearch ranks distinct commands by reciprocal rank fusion of their
+// bm25 rank for the keywords of opts.Query and their cosine rank against
+// its vector from model, so a command either search ranks well comes out
+// near the top, and one both do comes first. Each is its latest run within
+// the filters; the archive isn't searched.
+func HybridSearch(ctx context.Context, db *sql.DB, e llm.Embedder, model string, opts SearchOptions) ([]SearchResult, error) {
+	if opts.Limit <= 0 {
+		opts.Limit = 500
+	}
+	semantic, err := rankBySimilarity(ctx, db, e, model, opts)
+	if err != nil {
+		return nil, err
+	}
+	keyword, err := rankByKeywords(ctx, db, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	fused := make(map[string]*semanticMatch)
+	add := func(rank int, id int64, command string) {
+		m, ok := fused[command]
+		if !ok {
+			m = &semanticMatch{id: id, command: command}
+			fused[command] = m
+		}
+		m.score += 1 / float32(rrfK+rank+1)
+	}
+	for i, m := range semantic {
+		add(i, m.id, m.command)
+	}
+	for i, r := range keyword {
+		add(i, r.ID, r.Command)
+	}
+
+	matches := make([]semanticMatch, 0, len(fused))
+	for _, m := range fused {
+		matches = append(matches, *m)
+	}
+	slices.SortFunc(matches, func(a, b semanticMatch) int {
+		return cmp.Or(cmp.Compare(b.score, a.score), cmp.Compare(b.id, a.id))
+	})
+	if len(matches) > opts.Limit {
+		matches = matches[:opts.Limit]
+	}
+	return commandsByID(ctx, db, matches)
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/tchaudhry91/zist/llm"
+)
+
+func TestHybridSearch(t *testing.T) {
+	ctx := context.Background()
+	db, err := InitDB(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("InitDB() error = %v", err)
+	}
+	defer db.Close()
+
+	commands := []Command{
+		{Source: "/h", Timestamp: 1000, Command: "tar czf public.tgz public/"},
+		{Source: "/h", Timestamp: 1100, Command: "zip -r site.zip site/"},
+		{Source: "/h", Timestamp: 1200, Command: "ls public/"},
+		{Source: "/h", Timestamp: 1300, Command: "kubectl get pods"},
+	}
+	if _, _, err := InsertCommands(ctx, db, commands); err != nil {
+		t.Fatal(err)
+	}
+	mock := &llm.Mock{Embedding: conceptEmbedding}
+	if _, err := EmbedMissingCommands(ctx, db, mock, "mock-embed", 0); err != nil {
+		t.Fatal(err)
+	}
+
+	results, err := HybridSearch(ctx, db, mock, "mock-embed", SearchOptions{Query: "compress the public folder", Limit: 3})
+	if err != nil {
+		t.Fatalf("HybridSearch() error = %v", err)
+	}
+	var got []string
+	for _, r := range results {
+		got = append(got, r.Command)
+	}
+	// tar is in both rankings; zip only by meaning, ls only by keyword
+	if len(got) != 3 || got[0] != "tar czf public.tgz public/" || !strings.Contains(strings.Join(got, "\n"), "zip -r") || !strings.Contains(strings.Join(got, "\n"), "ls public/") {
+		t.Errorf("HybridSearch() = %q, want tar first, then zip and ls", got)
+	}
+
+	rows, err := rankByKeywords(ctx, db, SearchOptions{Query: "public", Since: 1100})
+	if err != nil || len(rows) != 1 || rows[0].Command != "ls public/" {
+		t.Errorf("rankByKeywords(since) = %+v, %v, want only ls", rows, err)
+	}
+}
+
+func TestWizardUseEmbeddings(t *testing.T) {
+	ctx := context.Background()
+	db, err := InitDB(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("InitDB() error = %v", err)
+	}
+	defer db.Close()
+
+	if _, _, err := InsertCommands(ctx, db, []Command{{Source: "/h", Timestamp: 1000, Command: "tar czf site.tgz public/"}}); err != nil {
+		t.Fatal(err)
+	}
+	embedder := &llm.Mock{Embedding: conceptEmbedding}
+	if _, err := EmbedMissingCommands(ctx, db, embedder, "mock-embed", 0); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, withEmbeddings := range []bool{false, true} {
+		mock := &llm.Mock{Reply: "tar czf dir.tgz dir/"}
+		w := NewWizard(db, mock)
+		if withEmbeddings {
+			w.UseEmbeddings(embedder, "mock-embed")
+		}
+		if _, err := w.Generate(ctx, WizardRequest{Query: "compress a directory"}); err != nil {
+			t.Fatalf("Generate() error = %v", err)
+		}
+		if got := strings.Contains(mock.Prompts()[0], "tar czf site.tgz"); got != withEmbeddings {
+			t.Errorf("with embeddings %v, prompt has the tar command from history = %v", withEmbeddings, got)
+		}
+	}
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// DefaultIgnore are the commands collect leaves out unless --ignore says
+// otherwise: zist's own searches and collects, which pile up after heavy use
+// and are never worth finding again
+var DefaultIgnore = []string{
+	"zist collect",
+	"zist search",
+	"zist prefix-search",
+	"zist quick",
+	"zist next",
+	"zist predict",
+	"zist refine",
+	"zist show",
+	"zist wizard",
+}
+
+// IgnoreRules match commands that collect leaves out. Each rule is the
+// words a command starts with, after any VAR=value assignments; the
+// program matches by name wherever it was run from.
+type IgnoreRules [][]string
+
+// NewIgnoreRules parses patterns like "zist search", skipping empty ones
+func NewIgnoreRules(patterns []string) IgnoreRules {
+	var rules IgnoreRules
+	for _, pattern := range patterns {
+		if words := strings.Fields(pattern); len(words) > 0 {
+			rules = append(rules, words)
+		}
+	}
+	return rules
+}
+
+// Match reports whether any rule matches command
+func (rules IgnoreRules) Match(command string) bool {
+	if len(rules) == 0 {
+		return false
+	}
+	_, rest := SplitEnvPrefix(command)
+	words := strings.Fields(rest)
+	if len(words) == 0 {
+		return false
+	}
+	words[0] = filepath.Base(words[0])
+	for _, rule := range rules {
+		if len(rule) <= len(words) && slices.Equal(rule, words[:len(rule)]) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestIgnoreRules(t *testing.T) {
+	rules := NewIgnoreRules(DefaultIgnore)
+	tests := []struct {
+		command string
+		want    bool
+	}{
+		{"zist search docker", true},
+		{"zist collect --quiet", true},
+		{"zist search", true},
+		{"~/go/bin/zist search kubectl", true},
+		{"ZIST_DB=/tmp/t.db zist search", true},
+		{"  zist   wizard --query 'list files'", true},
+		{"zist note 42 'why'", false},
+		{"zist", false},
+		{"zistsearch", false},
+		{"echo zist search", false},
+		{"zist searching", false},
+		{"", false},
+	}
+	for _, tt := range tests {
+		if got := rules.Match(tt.command); got != tt.want {
+			t.Errorf("Match(%q) = %v, want %v", tt.command, got, tt.want)
+		}
+	}
+
+	if NewIgnoreRules([]string{""}).Match("zist search docker") {
+		t.Error("--ignore \"\" still ignored a command")
+	}
+}
+
+func TestCollectIgnore(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	history, dbPath := filepath.Join(dir, "zsh_history"), filepath.Join(dir, "test.db")
+	data := ": 1704384000:0;ls\n: 1704384010:0;zist search ls\n: 1704384020:0;zist collect --quiet\n: 1704384030:0;git status\n"
+	if err := os.WriteFile(history, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	opts := CollectOptions{Quiet: true, Ignore: NewIgnoreRules(DefaultIgnore)}
+	if err := runCollect(ctx, dbPath, []string{history}, opts); err != nil {
+		t.Fatalf("runCollect() error = %v", err)
+	}
+	db, err := InitDB(dbPath)
+	if err != nil {
+		t.Fatalf("InitDB() error = %v", err)
+	}
+	defer db.Close()
+	results, err := SearchCommands(ctx, db, SearchOptions{Limit: 10})
+	if err != nil {
+		t.Fatalf("SearchCommands() error = %v", err)
+	}
+	if len(results) != 2 || results[0].Command != "git status" || results[1].Command != "ls" {
+		t.Errorf("collected %+v, want ls and git status only", results)
+	}
+
+	// With no rules, parsing the same file again from the start brings in
+	// the rest
+	if err := runCollect(ctx, dbPath, []string{history}, CollectOptions{Quiet: true, Full: true}); err != nil {
+		t.Fatalf("runCollect() error = %v", err)
+	}
+	if results, err = SearchCommands(ctx, db, SearchOptions{Limit: 10}); err != nil || len(results) != 4 {
+		t.Errorf("collect without rules stored %d command(s), %v, want 4", len(results), err)
+	}
+}
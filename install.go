@@ -0,0 +1,260 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"os/user"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// Markers around the block `zist install` adds to the rc file
+const (
+	integrationBegin = "# BEGIN zist integration"
+	integrationEnd   = "# END zist integration"
+)
+
+// keptBindingsHeader introduces key bindings carried over from an edited block
+const keptBindingsHeader = "# Key bindings kept from your edits"
+
+// ErrMalformedIntegration is returned when the rc file's zist markers don't
+// form exactly one block, so install and uninstall can't tell what is theirs
+var ErrMalformedIntegration = errors.New("malformed zist integration block")
+
+// integrationBlock is where the zist block sits in an rc file
+type integrationBlock struct {
+	Start int    // Offset of the BEGIN line
+	End   int    // Offset just past the END line and its newline
+	Text  string // The block, markers included
+}
+
+// rcFilePath returns the zsh rc file install and uninstall edit: override
+// when set, else .zshrc in $ZDOTDIR, where zsh reads it from, or home
+func rcFilePath(override string) (string, error) {
+	if override != "" {
+		return expandTilde(override), nil
+	}
+	if dir := os.Getenv("ZDOTDIR"); dir != "" {
+		return filepath.Join(expandTilde(dir), ".zshrc"), nil
+	}
+	usr, err := user.Current()
+	if err != nil {
+		return "", fmt.Errorf("failed to get current user: %w", err)
+	}
+	return filepath.Join(usr.HomeDir, ".zshrc"), nil
+}
+
+// findIntegration locates the zist block in content. Markers only count
+// alone on a line. It fails instead of guessing when a marker is missing,
+// out of order or repeated.
+func findIntegration(content string) (integrationBlock, bool, error) {
+	var block integrationBlock
+	begin, end := 0, 0 // Line numbers of the markers found so far
+	offset := 0
+	for i, line := range strings.SplitAfter(content, "\n") {
+		lineNo := i + 1
+		switch strings.TrimSpace(line) {
+		case integrationBegin:
+			switch {
+			case end > 0:
+				return block, false, fmt.Errorf("%w: another BEGIN marker on line %d after the block ending on line %d", ErrMalformedIntegration, lineNo, end)
+			case begin > 0:
+				return block, false, fmt.Errorf("%w: BEGIN marker on line %d has no END marker before the next BEGIN on line %d", ErrMalformedIntegration, begin, lineNo)
+			}
+			begin, block.Start = lineNo, offset
+		case integrationEnd:
+			switch {
+			case begin == 0:
+				return block, false, fmt.Errorf("%w: END marker on line %d has no BEGIN marker before it", ErrMalformedIntegration, lineNo)
+			case end > 0:
+				return block, false, fmt.Errorf("%w: another END marker on line %d after the one on line %d", ErrMalformedIntegration, lineNo, end)
+			}
+			end, block.End = lineNo, offset+len(line)
+		}
+		offset += len(line)
+	}
+
+	switch {
+	case begin == 0:
+		return block, false, nil
+	case end == 0:
+		return block, false, fmt.Errorf("%w: BEGIN marker on line %d has no END marker", ErrMalformedIntegration, begin)
+	}
+	block.Text = content[block.Start:block.End]
+	return block, true, nil
+}
+
+// edited reports whether the block differs from what install writes
+func (b integrationBlock) edited() bool {
+	return strings.TrimRight(b.Text, "\n") != strings.TrimRight(zshIntegration, "\n")
+}
+
+// customBindings returns the bindkey lines in the block that zist didn't
+// write, in order
+func (b integrationBlock) customBindings() []string {
+	ours := make(map[string]bool)
+	for _, line := range strings.Split(zshIntegration, "\n") {
+		ours[strings.TrimSpace(line)] = true
+	}
+	var custom []string
+	for _, line := range strings.Split(b.Text, "\n") {
+		trimmed := strings.TrimSpace(line)
+		if strings.HasPrefix(trimmed, "bindkey ") && !ours[trimmed] {
+			custom = append(custom, trimmed)
+		}
+	}
+	return custom
+}
+
+// integrationWithBindings returns the current block with bindings added at
+// its end, where they override zist's own
+func integrationWithBindings(bindings []string) string {
+	if len(bindings) == 0 {
+		return zshIntegration
+	}
+	body := strings.TrimSuffix(zshIntegration, integrationEnd+"\n")
+	return body + "\n" + keptBindingsHeader + "\n" + strings.Join(bindings, "\n") + "\n" + integrationEnd + "\n"
+}
+
+// appendIntegration adds the block at the end of content, after a blank line
+func appendIntegration(content string) string {
+	if content == "" {
+		return zshIntegration
+	}
+	if !strings.HasSuffix(content, "\n") {
+		content += "\n"
+	}
+	return content + "\n" + zshIntegration
+}
+
+// replaceIntegration swaps the block in content for text, in place
+func replaceIntegration(content string, block integrationBlock, text string) string {
+	return content[:block.Start] + text + content[block.End:]
+}
+
+// removeIntegration cuts the block and the blank line before it from content
+func removeIntegration(content string, block integrationBlock) string {
+	start := block.Start
+	if strings.HasSuffix(content[:start], "\n\n") {
+		start--
+	}
+	return content[:start] + content[block.End:]
+}
+
+// writeRCFile replaces path with content through a temporary file, so a
+// failed write never leaves a half-written rc file. It keeps the file's mode.
+func writeRCFile(path, content string) error {
+	// Dotfile managers often make the rc file a link; write where it points
+	if target, err := filepath.EvalSymlinks(path); err == nil {
+		path = target
+	}
+	mode := os.FileMode(0644)
+	if info, err := os.Stat(path); err == nil {
+		mode = info.Mode().Perm()
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".zist-*")
+	if err != nil {
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.WriteString(content); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	if err := tmp.Chmod(mode); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	return nil
+}
+
+// askYesNo prints prompt and reads an answer from answers. An empty answer,
+// or none at all when input isn't interactive, means def.
+func askYesNo(answers *bufio.Scanner, out io.Writer, prompt string, def bool) bool {
+	fmt.Fprint(out, prompt)
+	if !answers.Scan() {
+		fmt.Fprintln(out)
+		return def
+	}
+	switch strings.ToLower(strings.TrimSpace(answers.Text())) {
+	case "y", "yes":
+		return true
+	case "n", "no":
+		return false
+	default:
+		return def
+	}
+}
+
+// purgeCandidates lists what `zist uninstall --purge` deletes: the data and
+// config directories, the pre-XDG ~/.zist, and the given databases with
+// their journals, for ones kept elsewhere
+func purgeCandidates(dbPaths ...string) []string {
+	candidates := []string{DataDir(), ConfigDir(), LegacyDir}
+	for _, db := range dbPaths {
+		for _, suffix := range []string{"", "-wal", "-shm", "-journal"} {
+			candidates = append(candidates, db+suffix)
+		}
+	}
+	return candidates
+}
+
+// purgeTargets returns the candidates that exist, leaving out ones inside
+// another
+func purgeTargets(candidates []string) []string {
+	var targets []string
+	for _, path := range candidates {
+		path = expandTilde(path)
+		if _, err := os.Lstat(path); err != nil || slices.ContainsFunc(targets, func(t string) bool { return within(path, t) }) {
+			continue
+		}
+		targets = append(targets, path)
+	}
+	return targets
+}
+
+// within reports whether path is dir or inside it
+func within(path, dir string) bool {
+	rel, err := filepath.Rel(dir, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// pathSize returns the bytes taken by the files at or under path
+func pathSize(path string) int64 {
+	var size int64
+	filepath.WalkDir(path, func(_ string, d fs.DirEntry, err error) error {
+		if err == nil && d.Type().IsRegular() {
+			if info, err := d.Info(); err == nil {
+				size += info.Size()
+			}
+		}
+		return nil
+	})
+	return size
+}
+
+// formatSize renders bytes with a binary unit, e.g. 1.5 MB
+func formatSize(bytes int64) string {
+	const unit = 1024
+	if bytes < unit {
+		return fmt.Sprintf("%d B", bytes)
+	}
+	div, exp := int64(unit), 0
+	for n := bytes / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %cB", float64(bytes)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,254 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/peterbourgon/ff/v4"
+)
+
+func TestFindIntegration(t *testing.T) {
+	tests := []struct {
+		name      string
+		content   string
+		wantFound bool
+		wantErr   bool
+	}{
+		{"none", "export EDITOR=vim\n", false, false},
+		{"one", "alias ll='ls -l'\n\n" + zshIntegration, true, false},
+		{"indented markers", "  " + integrationBegin + "\nbindkey '^X' x\n  " + integrationEnd + "\n", true, false},
+		{"mentioned in a comment", "# see " + integrationBegin + " below\n", false, false},
+		{"no end", integrationBegin + "\nbindkey '^X' x\n", false, true},
+		{"no begin", "bindkey '^X' x\n" + integrationEnd + "\n", false, true},
+		{"end first", integrationEnd + "\n" + integrationBegin + "\n", false, true},
+		{"nested", integrationBegin + "\n" + integrationBegin + "\n" + integrationEnd + "\n", false, true},
+		{"two blocks", zshIntegration + "\n" + zshIntegration, false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			block, found, err := findIntegration(tt.content)
+			if found != tt.wantFound || (err != nil) != tt.wantErr {
+				t.Fatalf("findIntegration() = %v, %v, want %v, error %v", found, err, tt.wantFound, tt.wantErr)
+			}
+			if err != nil && !errors.Is(err, ErrMalformedIntegration) {
+				t.Errorf("findIntegration() error = %v, want ErrMalformedIntegration", err)
+			}
+			if found && (!strings.HasPrefix(strings.TrimSpace(block.Text), integrationBegin) || !strings.HasSuffix(block.Text, integrationEnd+"\n")) {
+				t.Errorf("findIntegration() block = %q", block.Text)
+			}
+		})
+	}
+}
+
+func TestInstallUninstall(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	rc := filepath.Join(dir, ".zshrc")
+	read := func() string {
+		t.Helper()
+		b, err := os.ReadFile(rc)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(b)
+	}
+	const before, after = "export EDITOR=vim\n", "alias ll='ls -l'\n"
+	if err := os.WriteFile(rc, []byte(before), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	var out bytes.Buffer
+	if err := runInstall(ctx, rc, strings.NewReader(""), &out); err != nil {
+		t.Fatalf("runInstall() error = %v", err)
+	}
+	if got := read(); got != before+"\n"+zshIntegration {
+		t.Fatalf("after install .zshrc = %q", got)
+	}
+	if info, _ := os.Stat(rc); info.Mode().Perm() != 0600 {
+		t.Errorf("install changed the mode to %v", info.Mode().Perm())
+	}
+
+	// Installing again leaves it alone
+	if err := runInstall(ctx, rc, strings.NewReader(""), &out); err != nil || !strings.Contains(out.String(), "already installed") {
+		t.Errorf("second runInstall() = %v:\n%s", err, out.String())
+	}
+
+	// An edited block is only replaced when asked to, keeping custom bindings
+	custom := "bindkey '^R' _zist_search_widget"
+	edited := strings.Replace(read(), integrationEnd, custom+"\n"+integrationEnd, 1) + after
+	if err := os.WriteFile(rc, []byte(edited), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := runInstall(ctx, rc, strings.NewReader(""), &out); err != nil || read() != edited {
+		t.Errorf("runInstall() without an answer = %v, changed .zshrc", err)
+	}
+	out.Reset()
+	if err := runInstall(ctx, rc, strings.NewReader("y\n\n"), &out); err != nil {
+		t.Fatalf("runInstall() replacing = %v", err)
+	}
+	want := before + "\n" + integrationWithBindings([]string{custom}) + after
+	if got := read(); got != want {
+		t.Errorf("after replacing .zshrc = %q, want %q", got, want)
+	}
+	if !strings.Contains(out.String(), custom) {
+		t.Errorf("runInstall() didn't show the custom binding:\n%s", out.String())
+	}
+
+	out.Reset()
+	if err := runUninstall(ctx, rc, UninstallOptions{}, strings.NewReader(""), &out); err != nil {
+		t.Fatalf("runUninstall() error = %v", err)
+	}
+	if got := read(); got != before+after {
+		t.Errorf("after uninstall .zshrc = %q, want %q", got, before+after)
+	}
+	if !strings.Contains(out.String(), custom) {
+		t.Errorf("runUninstall() didn't list the removed binding:\n%s", out.String())
+	}
+
+	// Broken markers are refused without touching the file
+	broken := before + integrationBegin + "\n" + after
+	if err := os.WriteFile(rc, []byte(broken), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := runInstall(ctx, rc, strings.NewReader("y\n"), &out); !errors.Is(err, ErrMalformedIntegration) || read() != broken {
+		t.Errorf("runInstall() on a broken block = %v", err)
+	}
+	if err := runUninstall(ctx, rc, UninstallOptions{}, strings.NewReader(""), &out); !errors.Is(err, ErrMalformedIntegration) || read() != broken {
+		t.Errorf("runUninstall() on a broken block = %v", err)
+	}
+}
+
+func TestWriteRCFileFollowsLinks(t *testing.T) {
+	dir := t.TempDir()
+	target, link := filepath.Join(dir, "dotfiles-zshrc"), filepath.Join(dir, ".zshrc")
+	if err := os.WriteFile(target, []byte("old\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(target, link); err != nil {
+		t.Skip("symlinks not supported:", err)
+	}
+
+	if err := writeRCFile(link, "new\n"); err != nil {
+		t.Fatalf("writeRCFile() error = %v", err)
+	}
+	if info, err := os.Lstat(link); err != nil || info.Mode()&os.ModeSymlink == 0 {
+		t.Errorf("writeRCFile() replaced the link: %v", err)
+	}
+	if b, _ := os.ReadFile(target); string(b) != "new\n" {
+		t.Errorf("link target = %q, want the new content", b)
+	}
+}
+
+func TestRCFilePath(t *testing.T) {
+	t.Setenv("ZDOTDIR", "/home/dev/.config/zsh")
+	if got, _ := rcFilePath(""); got != "/home/dev/.config/zsh/.zshrc" {
+		t.Errorf("rcFilePath() with ZDOTDIR = %q", got)
+	}
+	if got, _ := rcFilePath("/etc/zsh/zshrc.local"); got != "/etc/zsh/zshrc.local" {
+		t.Errorf("rcFilePath(override) = %q", got)
+	}
+	t.Setenv("ZDOTDIR", "")
+	if got, err := rcFilePath(""); err != nil || filepath.Base(got) != ".zshrc" {
+		t.Errorf("rcFilePath() = %q, %v", got, err)
+	}
+}
+
+func TestInstallCreatesRCFile(t *testing.T) {
+	rc := filepath.Join(t.TempDir(), ".zshrc")
+	var out bytes.Buffer
+	if err := runUninstall(context.Background(), rc, UninstallOptions{}, strings.NewReader(""), &out); err != nil {
+		t.Errorf("runUninstall() without the file error = %v", err)
+	}
+	if err := runInstall(context.Background(), rc, strings.NewReader(""), &out); err != nil {
+		t.Fatalf("runInstall() error = %v", err)
+	}
+	if b, err := os.ReadFile(rc); err != nil || string(b) != zshIntegration {
+		t.Errorf("new .zshrc = %q, %v", b, err)
+	}
+}
+
+func TestUninstallPurge(t *testing.T) {
+	dir := t.TempDir()
+	rc := filepath.Join(dir, ".zshrc")
+	data, config := filepath.Join(dir, "data"), filepath.Join(dir, "config")
+	elsewhere := filepath.Join(dir, "elsewhere.db")
+	for _, path := range []string{filepath.Join(data, "zist.db"), filepath.Join(data, "team-snippets", "snippets.json"), filepath.Join(config, "config.toml"), elsewhere, rc} {
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte("x"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	candidates := []string{data, config, filepath.Join(dir, "legacy"), filepath.Join(data, "zist.db"), elsewhere, elsewhere + "-wal"}
+	if got, want := purgeTargets(candidates), []string{data, config, elsewhere}; !reflect.DeepEqual(got, want) {
+		t.Errorf("purgeTargets() = %q, want %q", got, want)
+	}
+
+	ctx := context.Background()
+	var out bytes.Buffer
+	for _, tt := range []struct {
+		name   string
+		opts   UninstallOptions
+		answer string
+	}{
+		{"dry run", UninstallOptions{DryRun: true, Purge: candidates}, "y\n"},
+		{"declined", UninstallOptions{Purge: candidates}, "\n"},
+	} {
+		if err := runUninstall(ctx, rc, tt.opts, strings.NewReader(tt.answer), &out); err != nil {
+			t.Fatalf("%s: runUninstall() error = %v", tt.name, err)
+		}
+		if _, err := os.Stat(data); err != nil {
+			t.Fatalf("%s: data deleted", tt.name)
+		}
+	}
+
+	if err := runUninstall(ctx, rc, UninstallOptions{Purge: candidates}, strings.NewReader("y\n"), &out); err != nil {
+		t.Fatalf("runUninstall() error = %v", err)
+	}
+	for _, path := range []string{data, config, elsewhere} {
+		if _, err := os.Stat(path); !os.IsNotExist(err) {
+			t.Errorf("%s still exists after purge", path)
+		}
+	}
+	if _, err := os.Stat(rc); err != nil {
+		t.Errorf("purge deleted the rc file: %v", err)
+	}
+}
+
+func TestZshOptInsArentFlags(t *testing.T) {
+	// ZIST_* variables set flags, so a variable the integration reads to
+	// opt in mustn't name one, or exporting it changes what commands do
+	flags := map[string]string{}
+	var walk func(cmd *ff.Command)
+	walk = func(cmd *ff.Command) {
+		cmd.Flags.WalkFlags(func(f ff.Flag) error {
+			if name, ok := f.GetLongName(); ok {
+				flags[EnvVarPrefix+"_"+strings.ToUpper(strings.ReplaceAll(name, "-", "_"))] = cmd.Name
+			}
+			return nil
+		})
+		for _, sub := range cmd.Subcommands {
+			walk(sub)
+		}
+	}
+	root, _ := newRootCommand()
+	walk(root)
+
+	optIns := regexp.MustCompile(`"\$(ZIST_[A-Z_]+)" [!=]= 1`).FindAllStringSubmatch(zshIntegration, -1)
+	if len(optIns) == 0 {
+		t.Fatal("found no opt-in variables in the zsh integration")
+	}
+	for _, m := range optIns {
+		if cmd, ok := flags[m[1]]; ok {
+			t.Errorf("opt-in %s sets a flag of zist %s", m[1], cmd)
+		}
+	}
+}
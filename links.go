@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// linksColumn selects the links of the command aliased alias, one per line
+// in the order they were attached, from the links of schema
+func linksColumn(schema, alias string) string {
+	return fmt.Sprintf(`COALESCE((SELECT group_concat(l.link, char(10) ORDER BY l.created_at, l.rowid) FROM %[1]s.links l
+		WHERE l.source = %[2]s.source AND l.timestamp = %[2]s.timestamp), '')`, schema, alias)
+}
+
+// splitLinks undoes linksColumn
+func splitLinks(column string) []string {
+	if column == "" {
+		return nil
+	}
+	return strings.Split(column, "\n")
+}
+
+// NormalizeLink checks a reference before it is attached. URLs and ticket
+// IDs are kept as given; paths to files that exist are made absolute, so
+// they still resolve from another directory.
+func NormalizeLink(link string) (string, error) {
+	link = strings.TrimSpace(link)
+	if link == "" {
+		return "", fmt.Errorf("link is empty")
+	}
+	if strings.ContainsAny(link, "\t\n\r") {
+		return "", fmt.Errorf("link %q contains a tab or newline", link)
+	}
+	if strings.Contains(link, "://") {
+		return link, nil
+	}
+	path := expandTilde(link)
+	if _, err := os.Stat(path); err == nil {
+		if abs, err := filepath.Abs(path); err == nil {
+			return abs, nil
+		}
+	}
+	return link, nil
+}
+
+// AddLinks attaches links to the command, returning how many weren't
+// attached already
+func AddLinks(ctx context.Context, db *sql.DB, source string, timestamp float64, links []string) (int64, error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	now := float64(time.Now().Unix())
+	var added int64
+	for _, link := range links {
+		result, err := tx.ExecContext(ctx, `INSERT OR IGNORE INTO links (source, timestamp, link, created_at) VALUES (?, ?, ?, ?)`,
+			source, timestamp, link, now)
+		if err != nil {
+			return 0, fmt.Errorf("failed to add link: %w", err)
+		}
+		n, err := result.RowsAffected()
+		if err != nil {
+			return 0, fmt.Errorf("failed to get rows affected: %w", err)
+		}
+		added += n
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to commit links: %w", err)
+	}
+	return added, nil
+}
+
+// DeleteLinks removes the given links from the command, or all of them when
+// none are given, returning how many went
+func DeleteLinks(ctx context.Context, db *sql.DB, source string, timestamp float64, links []string) (int64, error) {
+	query := `DELETE FROM links WHERE source = ? AND timestamp = ?`
+	args := []interface{}{source, timestamp}
+	if len(links) > 0 {
+		query += ` AND link IN (?` + strings.Repeat(", ?", len(links)-1) + `)`
+		for _, link := range links {
+			args = append(args, link)
+		}
+	}
+
+	result, err := db.ExecContext(ctx, query, args...)
+	if err != nil {
+		return 0, fmt.Errorf("failed to delete links: %w", err)
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("failed to get rows affected: %w", err)
+	}
+	return n, nil
+}
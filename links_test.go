@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestLinks(t *testing.T) {
+	ctx := context.Background()
+	db, err := InitDB(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("InitDB() error = %v", err)
+	}
+	defer db.Close()
+
+	commands := []Command{
+		{Source: "/file1", Timestamp: 1000.0, Command: "kubectl rollout undo deploy/api"},
+		{Source: "/file1", Timestamp: 1001.0, Command: "ls -la"},
+	}
+	if _, _, err := InsertCommands(ctx, db, commands); err != nil {
+		t.Fatalf("InsertCommands() error = %v", err)
+	}
+	results, err := SearchCommands(ctx, db, SearchOptions{Query: "rollout"})
+	if err != nil || len(results) != 1 {
+		t.Fatalf("SearchCommands('rollout') = %+v, %v, want one result", results, err)
+	}
+	cmd := results[0]
+
+	links := []string{"https://tracker.example.com/INC-42", "https://github.com/example/api/pull/7"}
+	added, err := AddLinks(ctx, db, cmd.Source, cmd.Timestamp, append(links, links[0]))
+	if err != nil || added != 2 {
+		t.Fatalf("AddLinks() = %d, %v, want 2 added", added, err)
+	}
+
+	got, err := GetCommandByID(ctx, db, cmd.ID)
+	if err != nil || got == nil || !slices.Equal(got.Links, links) {
+		t.Fatalf("GetCommandByID() = %+v, %v, want links %q in order", got, err, links)
+	}
+	results, err = SearchCommands(ctx, db, SearchOptions{Query: "rollout"})
+	if err != nil || len(results) != 1 || !slices.Equal(results[0].Links, links) {
+		t.Errorf("SearchCommands() = %+v, %v, want the links", results, err)
+	}
+	if other, _ := GetCommandByID(ctx, db, cmd.ID+1); other == nil || other.Links != nil {
+		t.Errorf("GetCommandByID() of an unlinked command = %+v, want no links", other)
+	}
+
+	deleted, err := DeleteLinks(ctx, db, cmd.Source, cmd.Timestamp, links[:1])
+	if err != nil || deleted != 1 {
+		t.Fatalf("DeleteLinks(one) = %d, %v, want 1", deleted, err)
+	}
+	if got, _ := GetCommandByID(ctx, db, cmd.ID); !slices.Equal(got.Links, links[1:]) {
+		t.Errorf("links after deleting one = %q, want %q", got.Links, links[1:])
+	}
+
+	if _, err := RenameSource(ctx, db, "/file1", "/file2"); err != nil {
+		t.Fatal(err)
+	}
+	results, _ = SearchCommands(ctx, db, SearchOptions{Query: "rollout"})
+	if len(results) != 1 || !slices.Equal(results[0].Links, links[1:]) {
+		t.Errorf("links after RenameSource() = %+v, want them moved along", results)
+	}
+
+	deleted, err = DeleteLinks(ctx, db, "/file2", cmd.Timestamp, nil)
+	if err != nil || deleted != 1 {
+		t.Errorf("DeleteLinks(all) = %d, %v, want 1", deleted, err)
+	}
+}
+
+func TestNormalizeLink(t *testing.T) {
+	dir, err := filepath.EvalSymlinks(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	file := filepath.Join(dir, "postmortem.md")
+	if err := os.WriteFile(file, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	t.Chdir(dir)
+
+	tests := []struct {
+		link    string
+		want    string
+		wantErr bool
+	}{
+		{"https://tracker.example.com/INC-42", "https://tracker.example.com/INC-42", false},
+		{"  JIRA-123 ", "JIRA-123", false},
+		{"postmortem.md", file, false},
+		{"missing.md", "missing.md", false},
+		{"", "", true},
+		{"a\tb", "", true},
+	}
+	for _, tt := range tests {
+		got, err := NormalizeLink(tt.link)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("NormalizeLink(%q) = %q, %v, want %q", tt.link, got, err, tt.want)
+		}
+	}
+}
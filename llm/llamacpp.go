@@ -0,0 +1,81 @@
+package llm
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+)
+
+func init() {
+	b := Backend{DefaultURL: "http://localhost:8080", New: newLlamaCppClient}
+	Register("llamacpp", b)
+	Register("llamafile", b)
+}
+
+// LlamaCppClient implements Client using the /completion endpoint of the
+// llama.cpp server, which llamafile also serves. That endpoint takes a raw
+// prompt, so messages are rendered as a plain transcript.
+type LlamaCppClient struct {
+	usageCounter
+	root   string
+	config Config
+}
+
+func newLlamaCppClient(config Config) (Client, error) {
+	return &LlamaCppClient{root: serverRoot(config.BaseURL), config: config}, nil
+}
+
+// renderTranscript turns messages into a prompt ending where the assistant
+// should answer
+func renderTranscript(messages []Message) string {
+	var sb strings.Builder
+	for _, m := range messages {
+		if m.Content == "" {
+			continue
+		}
+		role := m.Role
+		if role == "" {
+			role = "user"
+		}
+		fmt.Fprintf(&sb, "### %s%s:\n%s\n\n", strings.ToUpper(role[:1]), role[1:], m.Content)
+	}
+	sb.WriteString("### Assistant:\n")
+	return sb.String()
+}
+
+// Complete performs a single-turn completion with optional system prompt
+func (c *LlamaCppClient) Complete(ctx context.Context, prompt, system string) (string, error) {
+	return c.Chat(ctx, []Message{{Role: "system", Content: system}, {Role: "user", Content: prompt}})
+}
+
+// Chat performs a multi-turn conversation
+func (c *LlamaCppClient) Chat(ctx context.Context, messages []Message) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, c.config.Timeout)
+	defer cancel()
+
+	req := map[string]interface{}{
+		"prompt":       renderTranscript(messages),
+		"n_predict":    c.config.MaxTokens,
+		"temperature":  c.config.Temperature,
+		"stop":         []string{"### User:", "### System:"},
+		"cache_prompt": true,
+	}
+	var resp struct {
+		Content         string `json:"content"`
+		TokensEvaluated int    `json:"tokens_evaluated"`
+		TokensPredicted int    `json:"tokens_predicted"`
+	}
+	if err := postJSON(ctx, c.root+"/completion", req, &resp); err != nil {
+		return "", fmt.Errorf("LLM completion failed: %w", err)
+	}
+	c.add(resp.TokensEvaluated, resp.TokensPredicted)
+	return strings.TrimSpace(resp.Content), nil
+}
+
+// IsAvailable checks the server's health endpoint
+func (c *LlamaCppClient) IsAvailable(ctx context.Context) bool {
+	ctx, cancel := context.WithTimeout(ctx, 2*time.Second)
+	defer cancel()
+	return reachable(ctx, c.root+"/health")
+}
@@ -0,0 +1,170 @@
+// Package llm defines the interface zist uses to talk to language models and
+// a registry of backends that implement it. Backends register themselves by
+// name; New picks one from Config.Backend.
+package llm
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultBackend is used when Config.Backend is empty
+const DefaultBackend = "openai"
+
+// Config holds configuration for an LLM client
+type Config struct {
+	Backend     string        // Registered backend name, e.g. "openai", "ollama" or "llamacpp"
+	BaseURL     string        // Server URL; the backend's default when empty
+	APIKey      string        // Required for OpenAI, "ollama" for local
+	Model       string        // "qwen2.5-coder:3b" or "gpt-4o-mini"
+	Timeout     time.Duration // Default: 5s
+	MaxTokens   int           // Default: 100
+	Temperature float32       // Default: 0.3
+}
+
+// Message represents a chat message
+type Message struct {
+	Role    string // "system", "user", or "assistant"
+	Content string
+}
+
+// Client is implemented by every backend
+type Client interface {
+	Complete(ctx context.Context, prompt, system string) (string, error)
+	Chat(ctx context.Context, messages []Message) (string, error)
+	IsAvailable(ctx context.Context) bool
+}
+
+// Embedder is implemented by clients whose backend can turn text into
+// vectors, with Config.Model naming an embedding model
+type Embedder interface {
+	Embed(ctx context.Context, texts []string) ([][]float32, error)
+}
+
+// Backend creates clients for one kind of LLM server
+type Backend struct {
+	DefaultURL string
+	New        func(Config) (Client, error)
+}
+
+var (
+	mu       sync.RWMutex
+	backends = map[string]Backend{}
+)
+
+// Register makes a backend available by name. It panics if the name is
+// taken, like database/sql.Register.
+func Register(name string, b Backend) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if _, dup := backends[name]; dup {
+		panic("llm: Register called twice for backend " + name)
+	}
+	backends[name] = b
+}
+
+// Backends returns the registered backend names, sorted
+func Backends() []string {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	names := make([]string, 0, len(backends))
+	for name := range backends {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// WithDefaults fills in empty fields, including the backend's default URL
+func (c Config) WithDefaults() (Config, error) {
+	if c.Backend == "" {
+		c.Backend = DefaultBackend
+	}
+	mu.RLock()
+	b, ok := backends[c.Backend]
+	mu.RUnlock()
+	if !ok {
+		return c, fmt.Errorf("unknown LLM backend %q (available: %s)", c.Backend, strings.Join(Backends(), ", "))
+	}
+
+	if c.BaseURL == "" {
+		c.BaseURL = b.DefaultURL
+	}
+	if c.APIKey == "" {
+		c.APIKey = "ollama"
+	}
+	if c.Model == "" {
+		c.Model = "qwen2.5-coder:3b"
+	}
+	if c.Timeout == 0 {
+		c.Timeout = 5 * time.Second
+	}
+	if c.MaxTokens == 0 {
+		c.MaxTokens = 100
+	}
+	if c.Temperature == 0 {
+		c.Temperature = 0.3
+	}
+	return c, nil
+}
+
+// New creates a client for config.Backend
+func New(config Config) (Client, error) {
+	config, err := config.WithDefaults()
+	if err != nil {
+		return nil, err
+	}
+	mu.RLock()
+	b := backends[config.Backend]
+	mu.RUnlock()
+	return b.New(config)
+}
+
+// Usage counts tokens spent on LLM requests
+type Usage struct {
+	PromptTokens     int
+	CompletionTokens int
+}
+
+// Sub returns the tokens spent since before
+func (u Usage) Sub(before Usage) Usage {
+	return Usage{
+		PromptTokens:     u.PromptTokens - before.PromptTokens,
+		CompletionTokens: u.CompletionTokens - before.CompletionTokens,
+	}
+}
+
+// UsageOf returns the tokens c has spent so far, or zero if it doesn't keep
+// count
+func UsageOf(c Client) Usage {
+	if u, ok := c.(interface{ Usage() Usage }); ok {
+		return u.Usage()
+	}
+	return Usage{}
+}
+
+// usageCounter is embedded by backends to keep a running token count
+type usageCounter struct {
+	mu    sync.Mutex
+	usage Usage
+}
+
+// Usage returns the tokens spent so far, as reported by the server
+func (u *usageCounter) Usage() Usage {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	return u.usage
+}
+
+func (u *usageCounter) add(prompt, completion int) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.usage.PromptTokens += prompt
+	u.usage.CompletionTokens += completion
+}
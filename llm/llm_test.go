@@ -0,0 +1,205 @@
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestWithDefaults(t *testing.T) {
+	tests := []struct {
+		config  Config
+		wantURL string
+		wantErr bool
+	}{
+		{Config{}, "http://localhost:11434/v1", false},
+		{Config{Backend: "ollama"}, "http://localhost:11434", false},
+		{Config{Backend: "llamafile"}, "http://localhost:8080", false},
+		{Config{Backend: "ollama", BaseURL: "http://gpu:11434"}, "http://gpu:11434", false},
+		{Config{Backend: "bard"}, "", true},
+	}
+	for _, tt := range tests {
+		got, err := tt.config.WithDefaults()
+		if (err != nil) != tt.wantErr {
+			t.Errorf("WithDefaults(%q) error = %v, wantErr %v", tt.config.Backend, err, tt.wantErr)
+			continue
+		}
+		if err == nil && got.BaseURL != tt.wantURL {
+			t.Errorf("WithDefaults(%q).BaseURL = %q, want %q", tt.config.Backend, got.BaseURL, tt.wantURL)
+		}
+	}
+
+	want := []string{"llamacpp", "llamafile", "ollama", "openai"}
+	if got := Backends(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Backends() = %v, want %v", got, want)
+	}
+}
+
+func TestServerRoot(t *testing.T) {
+	tests := map[string]string{
+		"http://localhost:11434":     "http://localhost:11434",
+		"http://localhost:11434/":    "http://localhost:11434",
+		"http://localhost:11434/v1":  "http://localhost:11434",
+		"http://localhost:11434/v1/": "http://localhost:11434",
+	}
+	for in, want := range tests {
+		if got := serverRoot(in); got != want {
+			t.Errorf("serverRoot(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestRenderTranscript(t *testing.T) {
+	got := renderTranscript([]Message{
+		{Role: "system", Content: "Reply with a command."},
+		{Role: "user", Content: "list files"},
+		{Role: "assistant", Content: ""},
+	})
+	want := "### System:\nReply with a command.\n\n### User:\nlist files\n\n### Assistant:\n"
+	if got != want {
+		t.Errorf("renderTranscript() = %q, want %q", got, want)
+	}
+}
+
+// fakeServer answers each path with a fixed JSON body and records request
+// bodies
+func fakeServer(t *testing.T, routes map[string]string) (*httptest.Server, map[string]map[string]interface{}) {
+	t.Helper()
+	requests := map[string]map[string]interface{}{}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, ok := routes[r.URL.Path]
+		if !ok {
+			http.Error(w, `{"error":"model not found"}`, http.StatusNotFound)
+			return
+		}
+		if r.Method == http.MethodPost {
+			var req map[string]interface{}
+			json.NewDecoder(r.Body).Decode(&req)
+			requests[r.URL.Path] = req
+		}
+		w.Write([]byte(body))
+	}))
+	t.Cleanup(ts.Close)
+	return ts, requests
+}
+
+func TestOllamaClient(t *testing.T) {
+	ts, requests := fakeServer(t, map[string]string{
+		"/api/generate": `{"response": "ls -la", "prompt_eval_count": 20, "eval_count": 4}`,
+		"/api/chat":     `{"message": {"role": "assistant", "content": "du -sh ."}, "prompt_eval_count": 30, "eval_count": 5}`,
+		"/api/tags":     `{"models": []}`,
+		"/api/embed":    `{"embeddings": [[0.1, 0.2], [0.3, 0.4]], "prompt_eval_count": 6}`,
+	})
+	c, err := New(Config{Backend: "ollama", BaseURL: ts.URL + "/v1", Model: "llama3"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+
+	if got, err := c.Complete(ctx, "list files", "Reply with a command."); err != nil || got != "ls -la" {
+		t.Errorf("Complete() = %q, %v", got, err)
+	}
+	if req := requests["/api/generate"]; req["system"] != "Reply with a command." || req["model"] != "llama3" || req["stream"] != false {
+		t.Errorf("generate request = %v", req)
+	}
+	if got, err := c.Chat(ctx, []Message{{Role: "user", Content: "disk usage"}}); err != nil || got != "du -sh ." {
+		t.Errorf("Chat() = %q, %v", got, err)
+	}
+	vectors, err := c.(Embedder).Embed(ctx, []string{"tar czf a.tgz a", "ls"})
+	if err != nil || len(vectors) != 2 || vectors[1][1] != 0.4 {
+		t.Errorf("Embed() = %v, %v", vectors, err)
+	}
+	if got, want := UsageOf(c), (Usage{PromptTokens: 56, CompletionTokens: 9}); got != want {
+		t.Errorf("UsageOf() = %+v, want %+v", got, want)
+	}
+	if !c.IsAvailable(ctx) {
+		t.Error("IsAvailable() = false, want true")
+	}
+}
+
+func TestLlamaCppClient(t *testing.T) {
+	ts, requests := fakeServer(t, map[string]string{
+		"/completion": `{"content": " git status\n", "tokens_evaluated": 12, "tokens_predicted": 3}`,
+		"/health":     `{"status": "ok"}`,
+	})
+	c, err := New(Config{Backend: "llamacpp", BaseURL: ts.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+
+	if got, err := c.Complete(ctx, "what changed", "Reply with a command."); err != nil || got != "git status" {
+		t.Errorf("Complete() = %q, %v", got, err)
+	}
+	prompt, _ := requests["/completion"]["prompt"].(string)
+	if !strings.HasPrefix(prompt, "### System:\nReply with a command.") || !strings.HasSuffix(prompt, "### Assistant:\n") {
+		t.Errorf("prompt = %q", prompt)
+	}
+	if got, want := UsageOf(c), (Usage{PromptTokens: 12, CompletionTokens: 3}); got != want {
+		t.Errorf("UsageOf() = %+v, want %+v", got, want)
+	}
+	if !c.IsAvailable(ctx) {
+		t.Error("IsAvailable() = false, want true")
+	}
+}
+
+func TestStatusError(t *testing.T) {
+	ts, _ := fakeServer(t, map[string]string{})
+	c, err := New(Config{Backend: "ollama", BaseURL: ts.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = c.Complete(context.Background(), "list files", "")
+	var statusErr *StatusError
+	if !errors.As(err, &statusErr) || statusErr.StatusCode != http.StatusNotFound || statusErr.Message != "model not found" {
+		t.Errorf("Complete() error = %v, want a 404 StatusError", err)
+	}
+	if c.IsAvailable(context.Background()) {
+		t.Error("IsAvailable() = true, want false")
+	}
+}
+
+func TestMock(t *testing.T) {
+	ctx := context.Background()
+	m := &Mock{Reply: "ls -la"}
+	if got, err := m.Complete(ctx, "list files", "be brief"); err != nil || got != "ls -la" {
+		t.Errorf("Complete() = %q, %v", got, err)
+	}
+	if _, err := m.Chat(ctx, []Message{{Role: "user", Content: "hi"}}); err != nil {
+		t.Errorf("Chat() error = %v", err)
+	}
+	if got, want := m.Prompts(), []string{"list files", "hi"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Prompts() = %q, want %q", got, want)
+	}
+	if got, want := m.Systems(), []string{"be brief", ""}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Systems() = %q, want %q", got, want)
+	}
+
+	m.Respond = func(messages []Message) (string, error) { return "", errors.New("down") }
+	if _, err := m.Complete(ctx, "q", ""); err == nil {
+		t.Error("Complete() error = nil with a failing Respond")
+	}
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	if _, err := (&Mock{}).Complete(cancelled, "q", ""); !errors.Is(err, context.Canceled) {
+		t.Errorf("Complete() with a cancelled context error = %v", err)
+	}
+	if _, err := (&Mock{}).Embed(ctx, []string{"ls"}); err == nil {
+		t.Error("Embed() error = nil without Embedding")
+	}
+	m = &Mock{Embedding: func(text string) []float32 { return []float32{float32(len(text))} }}
+	if got, err := m.Embed(ctx, []string{"ls", "make"}); err != nil || !reflect.DeepEqual(got, [][]float32{{2}, {4}}) {
+		t.Errorf("Embed() = %v, %v", got, err)
+	}
+	if got := m.Embeds(); !reflect.DeepEqual(got, [][]string{{"ls", "make"}}) {
+		t.Errorf("Embeds() = %q", got)
+	}
+	if (&Mock{Unavailable: true}).IsAvailable(ctx) {
+		t.Error("IsAvailable() = true for an unavailable mock")
+	}
+}
@@ -0,0 +1,114 @@
+package llm
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"sync"
+)
+
+// Mock is a Client for tests that answers without a server and records what
+// it was asked. It replies with Reply and Err, or with Respond when set. It
+// is safe for concurrent use.
+type Mock struct {
+	Reply       string
+	Err         error
+	Respond     func(messages []Message) (string, error) // Overrides Reply and Err
+	Unavailable bool                                     // Makes IsAvailable report false
+	Embedding   func(text string) []float32              // Vectors for Embed, which fails with Err when nil
+
+	mu     sync.Mutex
+	calls  [][]Message
+	embeds [][]string
+}
+
+// Complete records the prompt as a chat, with the system message first when
+// there is one, like the real backends send it
+func (m *Mock) Complete(ctx context.Context, prompt, system string) (string, error) {
+	var messages []Message
+	if system != "" {
+		messages = append(messages, Message{Role: "system", Content: system})
+	}
+	messages = append(messages, Message{Role: "user", Content: prompt})
+	return m.Chat(ctx, messages)
+}
+
+// Chat records messages and replies. It fails with the context's error once
+// ctx is done.
+func (m *Mock) Chat(ctx context.Context, messages []Message) (string, error) {
+	m.mu.Lock()
+	m.calls = append(m.calls, append([]Message(nil), messages...))
+	m.mu.Unlock()
+
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+	if m.Respond != nil {
+		return m.Respond(messages)
+	}
+	return m.Reply, m.Err
+}
+
+// Embed records texts and returns Embedding of each. It fails with the
+// context's error once ctx is done.
+func (m *Mock) Embed(ctx context.Context, texts []string) ([][]float32, error) {
+	m.mu.Lock()
+	m.embeds = append(m.embeds, append([]string(nil), texts...))
+	m.mu.Unlock()
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if m.Embedding == nil {
+		return nil, cmp.Or(m.Err, errors.New("mock has no Embedding"))
+	}
+	vectors := make([][]float32, len(texts))
+	for i, text := range texts {
+		vectors[i] = m.Embedding(text)
+	}
+	return vectors, nil
+}
+
+// Embeds returns the texts of every Embed call so far, oldest first
+func (m *Mock) Embeds() [][]string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([][]string(nil), m.embeds...)
+}
+
+// IsAvailable reports whether the mock was left available
+func (m *Mock) IsAvailable(ctx context.Context) bool {
+	return !m.Unavailable
+}
+
+// Calls returns the messages of every request so far, oldest first
+func (m *Mock) Calls() [][]Message {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([][]Message(nil), m.calls...)
+}
+
+// Prompts returns the last user message of every request
+func (m *Mock) Prompts() []string {
+	return m.contents("user")
+}
+
+// Systems returns the system message of every request, "" for requests
+// without one
+func (m *Mock) Systems() []string {
+	return m.contents("system")
+}
+
+// contents returns the last message with role from each request
+func (m *Mock) contents(role string) []string {
+	calls := m.Calls()
+	contents := make([]string, len(calls))
+	for i, messages := range calls {
+		for _, msg := range messages {
+			if msg.Role == role {
+				contents[i] = msg.Content
+			}
+		}
+	}
+	return contents
+}
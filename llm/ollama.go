@@ -0,0 +1,119 @@
+package llm
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+func init() {
+	Register("ollama", Backend{DefaultURL: "http://localhost:11434", New: newOllamaClient})
+}
+
+// OllamaClient implements Client using Ollama's native /api/generate and
+// /api/chat endpoints
+type OllamaClient struct {
+	usageCounter
+	root   string
+	config Config
+}
+
+func newOllamaClient(config Config) (Client, error) {
+	return &OllamaClient{root: serverRoot(config.BaseURL), config: config}, nil
+}
+
+type ollamaOptions struct {
+	Temperature float32 `json:"temperature"`
+	NumPredict  int     `json:"num_predict"`
+}
+
+type ollamaMessage struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+}
+
+// ollamaResponse holds the fields of /api/generate and /api/chat responses
+// zist uses
+type ollamaResponse struct {
+	Response        string        `json:"response"`
+	Message         ollamaMessage `json:"message"`
+	PromptEvalCount int           `json:"prompt_eval_count"`
+	EvalCount       int           `json:"eval_count"`
+}
+
+func (c *OllamaClient) options() ollamaOptions {
+	return ollamaOptions{Temperature: c.config.Temperature, NumPredict: c.config.MaxTokens}
+}
+
+// Complete performs a single-turn completion with optional system prompt
+func (c *OllamaClient) Complete(ctx context.Context, prompt, system string) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, c.config.Timeout)
+	defer cancel()
+
+	req := map[string]interface{}{
+		"model":   c.config.Model,
+		"prompt":  prompt,
+		"system":  system,
+		"stream":  false,
+		"options": c.options(),
+	}
+	var resp ollamaResponse
+	if err := postJSON(ctx, c.root+"/api/generate", req, &resp); err != nil {
+		return "", fmt.Errorf("LLM completion failed: %w", err)
+	}
+	c.add(resp.PromptEvalCount, resp.EvalCount)
+	return resp.Response, nil
+}
+
+// Chat performs a multi-turn conversation
+func (c *OllamaClient) Chat(ctx context.Context, messages []Message) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, c.config.Timeout)
+	defer cancel()
+
+	msgs := make([]ollamaMessage, len(messages))
+	for i, m := range messages {
+		msgs[i] = ollamaMessage{Role: m.Role, Content: m.Content}
+	}
+	req := map[string]interface{}{
+		"model":    c.config.Model,
+		"messages": msgs,
+		"stream":   false,
+		"options":  c.options(),
+	}
+	var resp ollamaResponse
+	if err := postJSON(ctx, c.root+"/api/chat", req, &resp); err != nil {
+		return "", fmt.Errorf("LLM chat failed: %w", err)
+	}
+	c.add(resp.PromptEvalCount, resp.EvalCount)
+	return resp.Message.Content, nil
+}
+
+// Embed returns a vector per text from /api/embed
+func (c *OllamaClient) Embed(ctx context.Context, texts []string) ([][]float32, error) {
+	ctx, cancel := context.WithTimeout(ctx, c.config.Timeout)
+	defer cancel()
+
+	req := map[string]interface{}{
+		"model": c.config.Model,
+		"input": texts,
+	}
+	var resp struct {
+		Embeddings      [][]float32 `json:"embeddings"`
+		PromptEvalCount int         `json:"prompt_eval_count"`
+	}
+	if err := postJSON(ctx, c.root+"/api/embed", req, &resp); err != nil {
+		return nil, fmt.Errorf("embedding failed: %w", err)
+	}
+	if len(resp.Embeddings) != len(texts) {
+		return nil, fmt.Errorf("embedding returned %d vector(s) for %d text(s)", len(resp.Embeddings), len(texts))
+	}
+	c.add(resp.PromptEvalCount, 0)
+	return resp.Embeddings, nil
+}
+
+// IsAvailable checks if the Ollama server is reachable
+func (c *OllamaClient) IsAvailable(ctx context.Context) bool {
+	ctx, cancel := context.WithTimeout(ctx, 2*time.Second)
+	defer cancel()
+	return reachable(ctx, c.root+"/api/tags")
+}
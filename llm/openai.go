@@ -1,4 +1,4 @@
-package main
+package llm
 
 import (
 	"context"
@@ -8,75 +8,24 @@ import (
 	"github.com/sashabaranov/go-openai"
 )
 
-// LLMConfig holds configuration for the LLM client
-type LLMConfig struct {
-	BaseURL     string        // "http://localhost:11434/v1" (Ollama) or "https://api.openai.com/v1"
-	APIKey      string        // Required for OpenAI, "ollama" for local
-	Model       string        // "qwen2.5-coder:3b" or "gpt-4o-mini"
-	Timeout     time.Duration // Default: 5s
-	MaxTokens   int           // Default: 100
-	Temperature float32       // Default: 0.3
+func init() {
+	Register("openai", Backend{DefaultURL: "http://localhost:11434/v1", New: newOpenAIClient})
 }
 
-// Message represents a chat message
-type Message struct {
-	Role    string // "system", "user", or "assistant"
-	Content string
-}
-
-// LLMClient interface for LLM operations
-type LLMClient interface {
-	Complete(ctx context.Context, prompt, system string) (string, error)
-	Chat(ctx context.Context, messages []Message) (string, error)
-	IsAvailable(ctx context.Context) bool
-}
-
-// OpenAIClient implements LLMClient using the OpenAI-compatible API
+// OpenAIClient implements Client using the OpenAI-compatible chat API, which
+// Ollama, OpenAI, OpenRouter, Groq and most proxies speak
 type OpenAIClient struct {
+	usageCounter
 	client *openai.Client
-	config LLMConfig
-}
-
-// DefaultLLMConfig returns a config suitable for local Ollama
-func DefaultLLMConfig() LLMConfig {
-	return LLMConfig{
-		BaseURL:     "http://localhost:11434/v1",
-		APIKey:      "ollama",
-		Model:       "qwen2.5-coder:3b",
-		Timeout:     5 * time.Second,
-		MaxTokens:   100,
-		Temperature: 0.3,
-	}
+	config Config
 }
 
-// NewLLMClient creates a new LLM client with the given configuration
-func NewLLMClient(config LLMConfig) (LLMClient, error) {
-	if config.BaseURL == "" {
-		config.BaseURL = "http://localhost:11434/v1"
-	}
-	if config.APIKey == "" {
-		config.APIKey = "ollama"
-	}
-	if config.Model == "" {
-		config.Model = "qwen2.5-coder:3b"
-	}
-	if config.Timeout == 0 {
-		config.Timeout = 5 * time.Second
-	}
-	if config.MaxTokens == 0 {
-		config.MaxTokens = 100
-	}
-	if config.Temperature == 0 {
-		config.Temperature = 0.3
-	}
-
+func newOpenAIClient(config Config) (Client, error) {
 	openaiConfig := openai.DefaultConfig(config.APIKey)
 	openaiConfig.BaseURL = config.BaseURL
 
-	client := openai.NewClientWithConfig(openaiConfig)
-
 	return &OpenAIClient{
-		client: client,
+		client: openai.NewClientWithConfig(openaiConfig),
 		config: config,
 	}, nil
 }
@@ -109,6 +58,7 @@ func (c *OpenAIClient) Complete(ctx context.Context, prompt, system string) (str
 	if err != nil {
 		return "", fmt.Errorf("LLM completion failed: %w", err)
 	}
+	c.add(resp.Usage.PromptTokens, resp.Usage.CompletionTokens)
 
 	if len(resp.Choices) == 0 {
 		return "", fmt.Errorf("LLM returned no choices")
@@ -148,6 +98,7 @@ func (c *OpenAIClient) Chat(ctx context.Context, messages []Message) (string, er
 	if err != nil {
 		return "", fmt.Errorf("LLM chat failed: %w", err)
 	}
+	c.add(resp.Usage.PromptTokens, resp.Usage.CompletionTokens)
 
 	if len(resp.Choices) == 0 {
 		return "", fmt.Errorf("LLM returned no choices")
@@ -156,6 +107,33 @@ func (c *OpenAIClient) Chat(ctx context.Context, messages []Message) (string, er
 	return resp.Choices[0].Message.Content, nil
 }
 
+// Embed returns a vector per text from the embeddings API
+func (c *OpenAIClient) Embed(ctx context.Context, texts []string) ([][]float32, error) {
+	ctx, cancel := context.WithTimeout(ctx, c.config.Timeout)
+	defer cancel()
+
+	resp, err := c.client.CreateEmbeddings(ctx, openai.EmbeddingRequestStrings{
+		Input: texts,
+		Model: openai.EmbeddingModel(c.config.Model),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("embedding failed: %w", err)
+	}
+	if len(resp.Data) != len(texts) {
+		return nil, fmt.Errorf("embedding returned %d vector(s) for %d text(s)", len(resp.Data), len(texts))
+	}
+	c.add(resp.Usage.PromptTokens, 0)
+
+	vectors := make([][]float32, len(texts))
+	for _, d := range resp.Data {
+		if d.Index < 0 || d.Index >= len(texts) {
+			return nil, fmt.Errorf("embedding returned a vector for text %d of %d", d.Index, len(texts))
+		}
+		vectors[d.Index] = d.Embedding
+	}
+	return vectors, nil
+}
+
 // IsAvailable checks if the LLM endpoint is reachable
 func (c *OpenAIClient) IsAvailable(ctx context.Context) bool {
 	ctx, cancel := context.WithTimeout(ctx, 2*time.Second)
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"time"
+
+	"github.com/tchaudhry91/zist/llm"
+)
+
+// DefaultLLMCacheTTL is how long a raw LLM response is reused
+const DefaultLLMCacheTTL = 24 * time.Hour
+
+// CachedLLM wraps an llm.Client and reuses its responses to identical prompts
+// for the same model, so retries and reruns don't wait on a slow model again.
+// It sits below the query→command cache, which keys on the user's wording.
+type CachedLLM struct {
+	next  llm.Client
+	db    *sql.DB
+	model string
+	ttl   time.Duration
+}
+
+// NewCachedLLM caches responses from next in db for ttl
+func NewCachedLLM(next llm.Client, db *sql.DB, model string, ttl time.Duration) *CachedLLM {
+	return &CachedLLM{next: next, db: db, model: model, ttl: ttl}
+}
+
+// Complete returns the cached response for prompt and system, or asks the LLM
+func (c *CachedLLM) Complete(ctx context.Context, prompt, system string) (string, error) {
+	messages := []llm.Message{{Role: "system", Content: system}, {Role: "user", Content: prompt}}
+	return c.cached(ctx, messages, func() (string, error) {
+		return c.next.Complete(ctx, prompt, system)
+	})
+}
+
+// Chat returns the cached response for messages, or asks the LLM
+func (c *CachedLLM) Chat(ctx context.Context, messages []llm.Message) (string, error) {
+	return c.cached(ctx, messages, func() (string, error) {
+		return c.next.Chat(ctx, messages)
+	})
+}
+
+// Usage returns the tokens spent by the wrapped client; cache hits are free
+func (c *CachedLLM) Usage() llm.Usage {
+	return llm.UsageOf(c.next)
+}
+
+// IsAvailable checks the wrapped client
+func (c *CachedLLM) IsAvailable(ctx context.Context) bool {
+	return c.next.IsAvailable(ctx)
+}
+
+func (c *CachedLLM) cached(ctx context.Context, messages []llm.Message, generate func() (string, error)) (string, error) {
+	hash := PromptHash(messages)
+	if response, ok, err := GetLLMCache(ctx, c.db, c.model, hash, c.ttl); err == nil && ok {
+		return response, nil
+	}
+
+	response, err := generate()
+	if err != nil {
+		return "", err
+	}
+	// A failed write only costs a future cache miss
+	SetLLMCache(ctx, c.db, c.model, hash, response, c.ttl)
+	return response, nil
+}
+
+// PromptHash identifies a prompt by the roles and contents of its messages
+func PromptHash(messages []llm.Message) string {
+	h := sha256.New()
+	for _, m := range messages {
+		h.Write([]byte(m.Role))
+		h.Write([]byte{0})
+		h.Write([]byte(m.Content))
+		h.Write([]byte{0})
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// GetLLMCache returns the response cached for model and hash if it is
+// younger than ttl
+func GetLLMCache(ctx context.Context, db *sql.DB, model, hash string, ttl time.Duration) (string, bool, error) {
+	cutoff := float64(time.Now().Add(-ttl).Unix())
+
+	var response string
+	err := db.QueryRowContext(ctx, `SELECT response FROM llm_cache WHERE model = ? AND prompt_hash = ? AND created_at >= ?`,
+		model, hash, cutoff).Scan(&response)
+	if err == sql.ErrNoRows {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, fmt.Errorf("failed to get LLM cache: %w", err)
+	}
+	return response, true, nil
+}
+
+// SetLLMCache stores a response and drops entries older than ttl
+func SetLLMCache(ctx context.Context, db *sql.DB, model, hash, response string, ttl time.Duration) error {
+	now := time.Now()
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM llm_cache WHERE created_at < ?`, float64(now.Add(-ttl).Unix())); err != nil {
+		return fmt.Errorf("failed to prune LLM cache: %w", err)
+	}
+	if _, err := tx.ExecContext(ctx, `INSERT INTO llm_cache (model, prompt_hash, response, created_at) VALUES (?, ?, ?, ?)
+		ON CONFLICT(model, prompt_hash) DO UPDATE SET response = excluded.response, created_at = excluded.created_at`,
+		model, hash, response, float64(now.Unix())); err != nil {
+		return fmt.Errorf("failed to set LLM cache: %w", err)
+	}
+	return tx.Commit()
+}
+
+// ClearLLMCache removes all cached LLM responses
+func ClearLLMCache(ctx context.Context, db *sql.DB) error {
+	if _, err := db.ExecContext(ctx, `DELETE FROM llm_cache`); err != nil {
+		return fmt.Errorf("failed to clear LLM cache: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/tchaudhry91/zist/llm"
+)
+
+// countingLLM counts calls and fails while err is set
+type countingLLM struct {
+	calls int
+	err   error
+}
+
+func (c *countingLLM) Complete(ctx context.Context, prompt, system string) (string, error) {
+	c.calls++
+	if c.err != nil {
+		return "", c.err
+	}
+	return "reply to " + prompt, nil
+}
+
+func (c *countingLLM) Chat(ctx context.Context, messages []llm.Message) (string, error) {
+	c.calls++
+	return "chat reply", nil
+}
+
+func (c *countingLLM) IsAvailable(ctx context.Context) bool { return true }
+
+func TestCachedLLM(t *testing.T) {
+	db, err := InitDB(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	ctx := context.Background()
+
+	next := &countingLLM{}
+	client := NewCachedLLM(next, db, "qwen2.5-coder:3b", time.Hour)
+
+	steps := []struct {
+		name      string
+		call      func() (string, error)
+		want      string
+		wantCalls int
+	}{
+		{"first call", func() (string, error) { return client.Complete(ctx, "list files", "sys") }, "reply to list files", 1},
+		{"same prompt", func() (string, error) { return client.Complete(ctx, "list files", "sys") }, "reply to list files", 1},
+		{"other system prompt", func() (string, error) { return client.Complete(ctx, "list files", "other") }, "reply to list files", 2},
+		{"other model", func() (string, error) {
+			return NewCachedLLM(next, db, "gpt-4o", time.Hour).Complete(ctx, "list files", "sys")
+		}, "reply to list files", 3},
+		{"chat", func() (string, error) { return client.Chat(ctx, []llm.Message{{Role: "user", Content: "hi"}}) }, "chat reply", 4},
+		{"chat again", func() (string, error) { return client.Chat(ctx, []llm.Message{{Role: "user", Content: "hi"}}) }, "chat reply", 4},
+	}
+	for _, s := range steps {
+		got, err := s.call()
+		if err != nil {
+			t.Fatalf("%s: error = %v", s.name, err)
+		}
+		if got != s.want || next.calls != s.wantCalls {
+			t.Errorf("%s: got %q after %d calls, want %q after %d", s.name, got, next.calls, s.want, s.wantCalls)
+		}
+	}
+
+	// Expired entries are not reused
+	if _, err := db.Exec(`UPDATE llm_cache SET created_at = created_at - 7200`); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.Complete(ctx, "list files", "sys"); err != nil || next.calls != 5 {
+		t.Errorf("expired entry: calls = %d, err = %v", next.calls, err)
+	}
+
+	// Failures are not cached
+	next.err = errors.New("timeout")
+	if _, err := client.Complete(ctx, "slow prompt", "sys"); err == nil {
+		t.Error("Complete() should return the LLM error")
+	}
+	next.err = nil
+	if got, err := client.Complete(ctx, "slow prompt", "sys"); err != nil || got != "reply to slow prompt" {
+		t.Errorf("Complete() after failure = %q, %v", got, err)
+	}
+
+	if err := ClearLLMCache(context.Background(), db); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok, _ := GetLLMCache(context.Background(), db, "qwen2.5-coder:3b", PromptHash([]llm.Message{{Role: "user", Content: "hi"}}), time.Hour); ok {
+		t.Error("ClearLLMCache() left entries behind")
+	}
+}
@@ -0,0 +1,265 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"net"
+	"time"
+
+	"github.com/sashabaranov/go-openai"
+	"github.com/tchaudhry91/zist/llm"
+)
+
+// ErrCircuitOpen is returned while an endpoint that keeps failing is paused
+var ErrCircuitOpen = errors.New("LLM endpoint keeps failing, wizard paused")
+
+// RetryPolicy controls retries and circuit breaking for an LLM endpoint
+type RetryPolicy struct {
+	MaxAttempts      int           // Tries per request, including the first
+	BaseDelay        time.Duration // Wait before the first retry, doubled after each
+	MaxDelay         time.Duration // Longest wait between retries
+	FailureThreshold int           // Failed requests in a row that open the circuit
+	Cooldown         time.Duration // How long an open circuit stays open, 0 disables breaking
+}
+
+// DefaultRetryPolicy retries twice and pauses an endpoint for five minutes
+// after three failed requests in a row
+func DefaultRetryPolicy() RetryPolicy {
+	return RetryPolicy{
+		MaxAttempts:      3,
+		BaseDelay:        500 * time.Millisecond,
+		MaxDelay:         5 * time.Second,
+		FailureThreshold: 3,
+		Cooldown:         5 * time.Minute,
+	}
+}
+
+// ResilientLLM retries transient failures of an LLM endpoint with exponential
+// backoff. Each run of zist is a new process, so failures are counted in the
+// database; once an endpoint fails often enough in a row, requests fail fast
+// with ErrCircuitOpen until the cooldown passes.
+type ResilientLLM struct {
+	next     llm.Client
+	db       *sql.DB
+	endpoint string
+	policy   RetryPolicy
+	sleep    func(context.Context, time.Duration) error
+}
+
+// NewResilientLLM wraps next, the client for endpoint
+func NewResilientLLM(next llm.Client, db *sql.DB, endpoint string, policy RetryPolicy) *ResilientLLM {
+	return &ResilientLLM{next: next, db: db, endpoint: endpoint, policy: policy, sleep: sleepContext}
+}
+
+// Complete calls the wrapped client with retries
+func (r *ResilientLLM) Complete(ctx context.Context, prompt, system string) (string, error) {
+	return r.call(ctx, func() (string, error) {
+		return r.next.Complete(ctx, prompt, system)
+	})
+}
+
+// Chat calls the wrapped client with retries
+func (r *ResilientLLM) Chat(ctx context.Context, messages []llm.Message) (string, error) {
+	return r.call(ctx, func() (string, error) {
+		return r.next.Chat(ctx, messages)
+	})
+}
+
+// Usage returns the tokens spent by the wrapped client
+func (r *ResilientLLM) Usage() llm.Usage {
+	return llm.UsageOf(r.next)
+}
+
+// IsAvailable checks the wrapped client
+func (r *ResilientLLM) IsAvailable(ctx context.Context) bool {
+	return r.next.IsAvailable(ctx)
+}
+
+func (r *ResilientLLM) call(ctx context.Context, generate func() (string, error)) (string, error) {
+	breaking := r.policy.Cooldown > 0 && r.db != nil
+	if breaking {
+		health, err := GetEndpointHealth(ctx, r.db, r.endpoint)
+		if err == nil && health.Open(time.Now()) {
+			return "", fmt.Errorf("%w until %s after %d failures in a row at %s (last: %s)",
+				ErrCircuitOpen, time.Unix(int64(health.OpenUntil), 0).Format("15:04:05"),
+				health.Failures, r.endpoint, health.LastError)
+		}
+	}
+
+	var response string
+	var err error
+	for attempt := 1; ; attempt++ {
+		response, err = generate()
+		if err == nil || attempt >= r.policy.MaxAttempts || !isTransient(err) || ctx.Err() != nil {
+			break
+		}
+		if r.sleep(ctx, backoff(r.policy, attempt)) != nil {
+			break
+		}
+	}
+
+	if breaking {
+		// The caller giving up says nothing about the endpoint
+		if err == nil {
+			RecordEndpointSuccess(ctx, r.db, r.endpoint)
+		} else if ctx.Err() == nil {
+			RecordEndpointFailure(ctx, r.db, r.endpoint, err, r.policy.FailureThreshold, r.policy.Cooldown)
+		}
+	}
+	return response, err
+}
+
+// backoff returns a jittered wait before retry number attempt
+func backoff(p RetryPolicy, attempt int) time.Duration {
+	d := p.BaseDelay << (attempt - 1)
+	if d > p.MaxDelay || d <= 0 {
+		d = p.MaxDelay
+	}
+	if d <= 0 {
+		return 0
+	}
+	return d/2 + rand.N(d/2+1)
+}
+
+// isTransient reports whether a failed request is worth retrying: rate
+// limits, server errors and timeouts
+func isTransient(err error) bool {
+	var apiErr *openai.APIError
+	if errors.As(err, &apiErr) {
+		return retryableStatus(apiErr.HTTPStatusCode)
+	}
+	var reqErr *openai.RequestError
+	if errors.As(err, &reqErr) {
+		return retryableStatus(reqErr.HTTPStatusCode)
+	}
+	var statusErr *llm.StatusError
+	if errors.As(err, &statusErr) {
+		return retryableStatus(statusErr.StatusCode)
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+func retryableStatus(code int) bool {
+	return code == 429 || code >= 500
+}
+
+func sleepContext(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
+
+// FallbackLLM sends requests to a second endpoint, such as an
+// OpenAI-compatible proxy, when the primary one fails
+type FallbackLLM struct {
+	primary  llm.Client
+	fallback llm.Client
+}
+
+// NewFallbackLLM tries primary first, then fallback
+func NewFallbackLLM(primary, fallback llm.Client) *FallbackLLM {
+	return &FallbackLLM{primary: primary, fallback: fallback}
+}
+
+// Complete asks the primary endpoint, then the fallback
+func (f *FallbackLLM) Complete(ctx context.Context, prompt, system string) (string, error) {
+	response, err := f.primary.Complete(ctx, prompt, system)
+	if err == nil || ctx.Err() != nil {
+		return response, err
+	}
+	response, fallbackErr := f.fallback.Complete(ctx, prompt, system)
+	if fallbackErr != nil {
+		return "", fmt.Errorf("%w (fallback: %v)", err, fallbackErr)
+	}
+	return response, nil
+}
+
+// Chat asks the primary endpoint, then the fallback
+func (f *FallbackLLM) Chat(ctx context.Context, messages []llm.Message) (string, error) {
+	response, err := f.primary.Chat(ctx, messages)
+	if err == nil || ctx.Err() != nil {
+		return response, err
+	}
+	response, fallbackErr := f.fallback.Chat(ctx, messages)
+	if fallbackErr != nil {
+		return "", fmt.Errorf("%w (fallback: %v)", err, fallbackErr)
+	}
+	return response, nil
+}
+
+// Usage returns the tokens spent by both endpoints
+func (f *FallbackLLM) Usage() llm.Usage {
+	p, fb := llm.UsageOf(f.primary), llm.UsageOf(f.fallback)
+	return llm.Usage{
+		PromptTokens:     p.PromptTokens + fb.PromptTokens,
+		CompletionTokens: p.CompletionTokens + fb.CompletionTokens,
+	}
+}
+
+// IsAvailable reports whether either endpoint is reachable
+func (f *FallbackLLM) IsAvailable(ctx context.Context) bool {
+	return f.primary.IsAvailable(ctx) || f.fallback.IsAvailable(ctx)
+}
+
+// EndpointHealth is the circuit breaker state of an LLM endpoint
+type EndpointHealth struct {
+	Endpoint  string
+	Failures  int     // Failed requests in a row
+	OpenUntil float64 // Unix time until which requests fail fast
+	LastError string
+}
+
+// Open reports whether requests should fail fast at now
+func (h EndpointHealth) Open(now time.Time) bool {
+	return h.OpenUntil > float64(now.Unix())
+}
+
+// GetEndpointHealth returns the breaker state for endpoint; unknown
+// endpoints are healthy
+func GetEndpointHealth(ctx context.Context, db *sql.DB, endpoint string) (EndpointHealth, error) {
+	h := EndpointHealth{Endpoint: endpoint}
+	err := db.QueryRowContext(ctx, `SELECT failures, open_until, last_error FROM llm_endpoints WHERE endpoint = ?`, endpoint).
+		Scan(&h.Failures, &h.OpenUntil, &h.LastError)
+	if err != nil && err != sql.ErrNoRows {
+		return h, fmt.Errorf("failed to get endpoint health: %w", err)
+	}
+	return h, nil
+}
+
+// RecordEndpointSuccess closes the circuit for endpoint
+func RecordEndpointSuccess(ctx context.Context, db *sql.DB, endpoint string) error {
+	if _, err := db.ExecContext(ctx, `DELETE FROM llm_endpoints WHERE endpoint = ?`, endpoint); err != nil {
+		return fmt.Errorf("failed to record endpoint success: %w", err)
+	}
+	return nil
+}
+
+// RecordEndpointFailure counts a failed request and opens the circuit for
+// cooldown once threshold failures in a row are reached. A failed request
+// after the cooldown reopens it straight away.
+func RecordEndpointFailure(ctx context.Context, db *sql.DB, endpoint string, failure error, threshold int, cooldown time.Duration) error {
+	openUntil := float64(time.Now().Add(cooldown).Unix())
+	_, err := db.ExecContext(ctx, `INSERT INTO llm_endpoints (endpoint, failures, open_until, last_error)
+		VALUES (?, 1, CASE WHEN 1 >= ? THEN ? ELSE 0 END, ?)
+		ON CONFLICT(endpoint) DO UPDATE SET
+			failures = failures + 1,
+			open_until = CASE WHEN failures + 1 >= ? THEN ? ELSE 0 END,
+			last_error = excluded.last_error`,
+		endpoint, threshold, openUntil, failure.Error(), threshold, openUntil)
+	if err != nil {
+		return fmt.Errorf("failed to record endpoint failure: %w", err)
+	}
+	return nil
+}
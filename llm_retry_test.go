@@ -0,0 +1,182 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/sashabaranov/go-openai"
+	"github.com/tchaudhry91/zist/llm"
+)
+
+// scriptedLLM returns the scripted errors in order, then succeeds
+type scriptedLLM struct {
+	errs  []error
+	calls int
+}
+
+func (s *scriptedLLM) Complete(ctx context.Context, prompt, system string) (string, error) {
+	s.calls++
+	if len(s.errs) > 0 {
+		err := s.errs[0]
+		s.errs = s.errs[1:]
+		return "", err
+	}
+	return "ls -la", nil
+}
+
+func (s *scriptedLLM) Chat(ctx context.Context, messages []llm.Message) (string, error) {
+	return s.Complete(ctx, "", "")
+}
+
+func (s *scriptedLLM) IsAvailable(ctx context.Context) bool { return true }
+
+func statusErr(code int) error {
+	return fmt.Errorf("LLM completion failed: %w", &openai.APIError{HTTPStatusCode: code, Message: "status"})
+}
+
+func TestIsTransient(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"rate limited", statusErr(429), true},
+		{"server error", statusErr(503), true},
+		{"request error", &openai.RequestError{HTTPStatusCode: 502, Err: errors.New("bad gateway")}, true},
+		{"native server error", fmt.Errorf("LLM completion failed: %w", &llm.StatusError{StatusCode: 500}), true},
+		{"native not found", &llm.StatusError{StatusCode: 404, Message: "model not found"}, false},
+		{"timeout", fmt.Errorf("LLM completion failed: %w", context.DeadlineExceeded), true},
+		{"unauthorized", statusErr(401), false},
+		{"bad request", statusErr(400), false},
+		{"other", errors.New("connection refused"), false},
+	}
+	for _, tt := range tests {
+		if got := isTransient(tt.err); got != tt.want {
+			t.Errorf("isTransient(%s) = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	p := RetryPolicy{BaseDelay: 100 * time.Millisecond, MaxDelay: time.Second}
+	for attempt, max := range map[int]time.Duration{1: 100 * time.Millisecond, 2: 200 * time.Millisecond, 5: time.Second, 40: time.Second} {
+		for i := 0; i < 20; i++ {
+			if d := backoff(p, attempt); d < max/2 || d > max {
+				t.Errorf("backoff(%d) = %v, want between %v and %v", attempt, d, max/2, max)
+			}
+		}
+	}
+}
+
+func TestResilientLLMRetries(t *testing.T) {
+	tests := []struct {
+		name      string
+		errs      []error
+		wantCalls int
+		wantErr   bool
+	}{
+		{"success", nil, 1, false},
+		{"recovers", []error{statusErr(503), statusErr(429)}, 3, false},
+		{"gives up", []error{statusErr(503), statusErr(503), statusErr(503), statusErr(503)}, 3, true},
+		{"permanent", []error{statusErr(401)}, 1, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			next := &scriptedLLM{errs: tt.errs}
+			client := NewResilientLLM(next, nil, "http://llm", DefaultRetryPolicy())
+			var waits []time.Duration
+			client.sleep = func(ctx context.Context, d time.Duration) error {
+				waits = append(waits, d)
+				return nil
+			}
+
+			_, err := client.Complete(context.Background(), "list files", "")
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Complete() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if next.calls != tt.wantCalls || len(waits) != tt.wantCalls-1 {
+				t.Errorf("calls = %d, waits = %v, want %d calls", next.calls, waits, tt.wantCalls)
+			}
+		})
+	}
+}
+
+func TestResilientLLMCircuitBreaker(t *testing.T) {
+	db, err := InitDB(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	ctx := context.Background()
+
+	policy := RetryPolicy{MaxAttempts: 1, FailureThreshold: 2, Cooldown: time.Minute}
+	next := &scriptedLLM{errs: []error{statusErr(500), statusErr(500), statusErr(500)}}
+	client := NewResilientLLM(next, db, "http://llm", policy)
+
+	for i := 0; i < 2; i++ {
+		if _, err := client.Complete(ctx, "q", ""); err == nil || errors.Is(err, ErrCircuitOpen) {
+			t.Fatalf("request %d error = %v, want the endpoint's error", i+1, err)
+		}
+	}
+	if _, err := client.Complete(ctx, "q", ""); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("error after %d failures = %v, want ErrCircuitOpen", policy.FailureThreshold, err)
+	}
+	if next.calls != 2 {
+		t.Errorf("open circuit still called the endpoint: %d calls", next.calls)
+	}
+
+	// Another endpoint is unaffected
+	other := NewResilientLLM(&scriptedLLM{}, db, "http://other", policy)
+	if _, err := other.Complete(ctx, "q", ""); err != nil {
+		t.Errorf("other endpoint error = %v", err)
+	}
+
+	// After the cooldown one trial request goes through; failing reopens
+	if _, err := db.Exec(`UPDATE llm_endpoints SET open_until = 0`); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.Complete(ctx, "q", ""); err == nil || errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("trial request error = %v, want the endpoint's error", err)
+	}
+	if _, err := client.Complete(ctx, "q", ""); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("error after failed trial = %v, want ErrCircuitOpen", err)
+	}
+
+	// A successful trial closes it
+	if _, err := db.Exec(`UPDATE llm_endpoints SET open_until = 0`); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.Complete(ctx, "q", ""); err != nil {
+		t.Fatalf("successful trial error = %v", err)
+	}
+	if h, _ := GetEndpointHealth(context.Background(), db, "http://llm"); h.Failures != 0 || h.Open(time.Now()) {
+		t.Errorf("health after success = %+v", h)
+	}
+}
+
+func TestFallbackLLM(t *testing.T) {
+	ctx := context.Background()
+
+	primary := &scriptedLLM{errs: []error{statusErr(503)}}
+	fallback := &scriptedLLM{}
+	got, err := NewFallbackLLM(primary, fallback).Complete(ctx, "q", "")
+	if err != nil || got != "ls -la" || fallback.calls != 1 {
+		t.Errorf("Complete() = %q, %v with %d fallback calls", got, err, fallback.calls)
+	}
+
+	primary = &scriptedLLM{}
+	fallback = &scriptedLLM{}
+	if _, err := NewFallbackLLM(primary, fallback).Complete(ctx, "q", ""); err != nil || fallback.calls != 0 {
+		t.Errorf("healthy primary: err = %v, fallback calls = %d", err, fallback.calls)
+	}
+
+	primary = &scriptedLLM{errs: []error{statusErr(503)}}
+	fallback = &scriptedLLM{errs: []error{statusErr(401)}}
+	if _, err := NewFallbackLLM(primary, fallback).Complete(ctx, "q", ""); err == nil {
+		t.Error("Complete() should fail when both endpoints fail")
+	}
+}
@@ -0,0 +1,14 @@
+//go:build !unix
+
+package main
+
+import "os"
+
+// tryLock always succeeds where flock isn't available, so writers aren't
+// coordinated there
+func tryLock(f *os.File) bool {
+	return true
+}
+
+// unlock has no lock to release where flock isn't available
+func unlock(f *os.File) {}
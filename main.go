@@ -1,17 +1,30 @@
 package main
 
 import (
+	"bufio"
 	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"maps"
+	"net"
+	"net/http"
 	"os"
 	"os/exec"
-	"os/user"
+	"os/signal"
 	"path/filepath"
+	"slices"
+	"strconv"
 	"strings"
+	"syscall"
+	"text/tabwriter"
 	"time"
 
 	"github.com/peterbourgon/ff/v4"
 	"github.com/peterbourgon/ff/v4/ffhelp"
+	"github.com/tchaudhry91/zist/llm"
 	_ "modernc.org/sqlite"
 )
 
@@ -19,370 +32,4435 @@ import (
 var version = "dev"
 
 func main() {
+	// `zist migrate-paths` reports the move itself
+	if !slices.Contains(os.Args[1:], "migrate-paths") {
+		migrateLegacyPaths()
+	}
+
+	rootCmd, globals := newRootCommand()
+
+	// Anything that isn't a built-in command may be a plugin. Its flags are
+	// its own, so it runs before zist parses any.
+	if len(os.Args) > 1 && !strings.HasPrefix(os.Args[1], "-") && !slices.ContainsFunc(rootCmd.Subcommands, func(c *ff.Command) bool {
+		return strings.EqualFold(c.Name, os.Args[1])
+	}) {
+		if path, ok := findPlugin(os.Args[1]); ok {
+			code, err := runPlugin(path, os.Args[2:])
+			if err != nil {
+				fmt.Printf("error: %v\n", err)
+			}
+			os.Exit(code)
+		}
+	}
+
+	// Config file keys and ZIST_* environment variables are long flag names
+	// and apply to every command that has the flag; flags given on the
+	// command line win
+	if err := parseAndRun(rootCmd, globals); err != nil {
+		if errors.Is(err, ErrCheckFailed) {
+			os.Exit(1)
+		}
+		if *globals.version {
+			fmt.Printf("zist version %s\n", version)
+			return
+		}
+		if *globals.help {
+			fmt.Println(ffhelp.Command(rootCmd))
+			return
+		}
+		fmt.Println(ffhelp.Command(rootCmd))
+		if err.Error() == "no subcommand provided" {
+			os.Exit(0)
+		}
+		fmt.Printf("error: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+// newRootCommand returns the zist command, with every subcommand and flag,
+// and the root flags parseAndRun applies
+func newRootCommand() (*ff.Command, globalFlags) {
 	rootFlags := ff.NewFlagSet("zist")
-	helpFlag := rootFlags.BoolLong("help", "h")
-	versionFlag := rootFlags.BoolLong("version", "v")
+	rootFlags.StringLong("config", expandTilde(DefaultConfigPath), "TOML config file setting defaults for any long flag")
+	globals := globalFlags{
+		help:              rootFlags.BoolLong("help", "h"),
+		version:           rootFlags.BoolLong("version", "v"),
+		profileCPU:        rootFlags.StringLong("profile-cpu", "", "Write a pprof CPU profile of the command to FILE"),
+		profileMem:        rootFlags.StringLong("profile-mem", "", "Write a pprof heap profile to FILE when the command ends"),
+		timings:           rootFlags.BoolLong("timings", "Print how long each stage of collect and search took to stderr"),
+		cacheStopwords:    rootFlags.StringListLong("cache-stopwords", "Word to ignore in wizard queries when looking up cached mappings (repeatable, replaces the defaults; \"\" for none)"),
+		cacheSingular:     rootFlags.BoolLong("cache-singular", "Treat simple plurals in wizard queries as singular when looking up cached mappings"),
+		keywordStopwords:  rootFlags.StringListLong("keyword-stopwords", "Extra word the wizard ignores when searching history for context, e.g. for queries in other languages (repeatable)"),
+		maxCommandLength:  rootFlags.IntLong("max-command-length", DefaultMaxCommandLength, "Longest command in bytes stored whole; longer ones are indexed cut short (0 for no limit)"),
+		onCollect:         rootFlags.StringLong("on-collect", "", "Shell command run after each collect, with the results as JSON on stdin"),
+		onWizardGenerate:  rootFlags.StringLong("on-wizard-generate", "", "Shell command run when the wizard generates a command, with it as JSON on stdin"),
+		onSearchSelect:    rootFlags.StringLong("on-search-select", "", "Shell command run when a command is picked in search, with it as JSON on stdin"),
+		plain:             rootFlags.BoolLong("plain", "No colors, highlighting or symbols, and the simplest picker layout, for screen readers and dumb terminals (on for TERM=dumb)"),
+		timeFormat:        rootFlags.StringLong("time-format", TimeFormatDefault, "How times are shown: default (2006-01-02 15:04:05), iso (ISO-8601 with the UTC offset), locale (as LC_TIME or LANG), epoch (Unix seconds) or a Go layout; JSON output always uses RFC 3339"),
+		sudo:              rootFlags.StringLong("sudo", SudoHint, "Commands from the wizard and search that likely need root: hint (point them out), auto (also prepend sudo where history shows you usually do) or off"),
+		sudoPrograms:      rootFlags.StringListLong("sudo-program", "NAME=always, NAME=never or NAME=history: whether to prepend sudo to commands running NAME, whatever --sudo says (repeatable)"),
+		picker:            rootFlags.StringLong("picker", PickerAuto, "Interactive picker for search, timeline and next: auto (first of fzf, sk and peco on PATH, else internal), fzf, sk, peco or internal"),
+		embedModel:        rootFlags.StringLong("embed-model", "", "Embedding model for semantic search, e.g. nomic-embed-text; collect embeds new commands when set"),
+		embedBackend:      rootFlags.StringLong("embed-backend", "", "LLM API flavour serving --embed-model: openai or ollama (default: as for the wizard)"),
+		embedURL:          rootFlags.StringLong("embed-api-url", "", "API endpoint serving --embed-model (default: as for the wizard)"),
+		embedKey:          rootFlags.StringLong("embed-key", "", "API key for --embed-api-url (default: as for the wizard)"),
+		sqlitePragmas:     rootFlags.StringListLong("sqlite-pragma", "NAME=VALUE: SQLite setting databases are opened with, one of page_size, cache_size, mmap_size, temp_store or synchronous (repeatable)"),
+		sqliteBulkPragmas: rootFlags.StringListLong("sqlite-bulk-pragma", "NAME=VALUE: SQLite setting for collect --bulk, as for --sqlite-pragma (repeatable)"),
+	}
 
 	collectFlags := ff.NewFlagSet("collect").SetParent(rootFlags)
-	dbPath := collectFlags.StringLong("db", "~/.zist/zist.db", "SQLite database path")
+	dbPath := collectFlags.StringLong("db", DefaultDBPath, "SQLite database path")
 	quietFlag := collectFlags.BoolLong("quiet", "q")
+	normalizeEnvFlag := collectFlags.BoolLong("normalize-env", "Store leading VAR=value assignments apart from the command")
+	joinContinuationsFlag := collectFlags.BoolLong("join-continuations", "Store commands continued with trailing backslashes on one line")
+	debounceFlag := collectFlags.DurationLong("debounce", 0, "Skip if a collect into the same DB started less than this long ago")
+	noWaitFlag := collectFlags.BoolLong("no-wait", "Skip instead of waiting while another zist writes to the DB")
+	collectDiffFlag := collectFlags.BoolLong("diff", "Only report how many rows per source and day are new, duplicate or conflicting")
+	collectFullFlag := collectFlags.BoolLong("full", "Parse every history file from the start, not just what was appended since the last collect")
+	collectBulkFlag := collectFlags.BoolLong("bulk", "Write with the --sqlite-bulk-pragma settings, faster for importing a lot of history but unsafe if power is lost meanwhile")
+	collectMaxSize := collectFlags.StringLong("max-size", "", "Most the database may hold, e.g. 2GB (default: no limit)")
+	collectMaxSizeAction := collectFlags.StringLong("max-size-action", MaxSizeArchive, "Past --max-size: archive (move the oldest commands into --archive-db) or warn")
+	collectArchivePath := collectFlags.StringLong("archive-db", DefaultArchivePath, "Archive database the oldest commands move to past --max-size")
+	collectSourceLabel := collectFlags.StringLong("source-label", "", "Name to store the commands of a single input under, e.g. server:~/.zsh_history (required for - and pipes)")
+	collectIgnoreFlag := collectFlags.StringListLong("ignore", "Leave out commands starting with these words, e.g. \"zist search\" (repeatable, replaces the defaults of zist's own searches and collects; \"\" to keep everything)")
 	collectCmd := &ff.Command{
 		Name:      "collect",
-		Usage:     "zist collect [--db PATH] [--quiet] [PATH...]",
+		Usage:     "zist collect [--db PATH] [--quiet] [--normalize-env] [--join-continuations] [--debounce DURATION] [--no-wait] [--diff] [--full] [--bulk] [--source-label NAME] [--ignore WORDS]... [--max-size SIZE [--max-size-action archive|warn] [--archive-db PATH]] [PATH...]",
 		ShortHelp: "Collect commands from ZSH history files (default: ~/.histories)",
 		Flags:     collectFlags,
 		Exec: func(ctx context.Context, args []string) error {
-			return runCollect(ctx, *dbPath, args, *quietFlag)
+			ignore := DefaultIgnore
+			if len(*collectIgnoreFlag) > 0 {
+				ignore = *collectIgnoreFlag
+			}
+			maxSize, err := parseSize(*collectMaxSize)
+			if err != nil {
+				return err
+			}
+			if *collectMaxSizeAction != MaxSizeArchive && *collectMaxSizeAction != MaxSizeWarn {
+				return fmt.Errorf("invalid --max-size-action %q (use archive or warn)", *collectMaxSizeAction)
+			}
+			return runCollect(ctx, *dbPath, args, CollectOptions{
+				Quiet:             *quietFlag,
+				NormalizeEnv:      *normalizeEnvFlag,
+				JoinContinuations: *joinContinuationsFlag,
+				NoWait:            *noWaitFlag,
+				Debounce:          *debounceFlag,
+				Diff:              *collectDiffFlag,
+				Full:              *collectFullFlag,
+				Bulk:              *collectBulkFlag,
+				Ignore:            NewIgnoreRules(ignore),
+				SourceLabel:       *collectSourceLabel,
+				MaxSize:           maxSize,
+				MaxSizeAction:     *collectMaxSizeAction,
+				ArchivePath:       *collectArchivePath,
+			})
+		},
+	}
+
+	daemonFlags := ff.NewFlagSet("daemon").SetParent(rootFlags)
+	dbPathDaemon := daemonFlags.StringLong("db", DefaultDBPath, "SQLite database path")
+	daemonNormalizeEnv := daemonFlags.BoolLong("normalize-env", "Store leading VAR=value assignments apart from the command")
+	daemonJoinContinuations := daemonFlags.BoolLong("join-continuations", "Store commands continued with trailing backslashes on one line")
+	daemonIgnore := daemonFlags.StringListLong("ignore", "Leave out commands starting with these words, as for collect (repeatable)")
+	daemonMaxSize := daemonFlags.StringLong("max-size", "", "Most the database may hold, e.g. 2GB (default: no limit)")
+	daemonMaxSizeAction := daemonFlags.StringLong("max-size-action", MaxSizeArchive, "Past --max-size: archive (move the oldest commands into --archive-db) or warn")
+	daemonArchivePath := daemonFlags.StringLong("archive-db", DefaultArchivePath, "Archive database the oldest commands move to past --max-size or --retention")
+	daemonMaintenance := daemonFlags.StringLong("maintenance", "", "When to vacuum, optimize the FTS index, back up and apply --retention, e.g. \"03:00 daily\" or \"03:00 sunday\" (default: never)")
+	daemonRetention := daemonFlags.IntLong("retention", 0, "At maintenance, archive commands older than this many years into --archive-db (default: keep all)")
+	daemonBackupDir := daemonFlags.StringLong("backup-dir", DefaultBackupDir, "Directory maintenance backs the database up into")
+	daemonBackupKeep := daemonFlags.IntLong("backup-keep", DefaultBackupKeep, "Backups to keep, the oldest deleted first; 0 makes none")
+	daemonDelay := daemonFlags.DurationLong("delay", DefaultDaemonDelay, "Wait this long after a history file changes before collecting, so a burst of writes is collected once")
+	daemonPoll := daemonFlags.DurationLong("poll", DefaultDaemonPoll, "How often to check history files where changes can't be watched (other systems than Linux)")
+	daemonFlushInterval := daemonFlags.DurationLong("flush-interval", DefaultDaemonFlushInterval, "Longest a change waits to be collected, so several prompts' commands are written at once; 0 collects every change after --delay")
+	daemonFlushThreshold := daemonFlags.IntLong("flush-threshold", DefaultDaemonFlushThreshold, "Collect sooner once this many changes, about one per command, are waiting")
+	daemonCheckpointIdle := daemonFlags.DurationLong("checkpoint-idle", DefaultDaemonCheckpointIdle, "Checkpoint the WAL once nothing has been written for this long; 0 leaves it to SQLite")
+	daemonRetryDelay := daemonFlags.DurationLong("retry-delay", DefaultDaemonRetryDelay, "Collect again this long after a collect fails, such as on a database busy for too long")
+	daemonCmd := &ff.Command{
+		Name:      "daemon",
+		Usage:     "zist daemon [--db PATH] [--normalize-env] [--join-continuations] [--ignore WORDS]... [--max-size SIZE [--max-size-action archive|warn] [--archive-db PATH]] [--maintenance SCHEDULE [--retention YEARS] [--backup-dir DIR] [--backup-keep N]] [--delay DURATION] [--flush-interval DURATION] [--flush-threshold N] [--checkpoint-idle DURATION] [--retry-delay DURATION] [--poll DURATION] [PATH...]",
+		ShortHelp: "Collect history files whenever they change, instead of after every prompt",
+		Flags:     daemonFlags,
+		Exec: func(ctx context.Context, args []string) error {
+			ignore := DefaultIgnore
+			if len(*daemonIgnore) > 0 {
+				ignore = *daemonIgnore
+			}
+			maxSize, err := parseSize(*daemonMaxSize)
+			if err != nil {
+				return err
+			}
+			if *daemonMaxSizeAction != MaxSizeArchive && *daemonMaxSizeAction != MaxSizeWarn {
+				return fmt.Errorf("invalid --max-size-action %q (use archive or warn)", *daemonMaxSizeAction)
+			}
+			schedule, err := ParseMaintenanceSchedule(*daemonMaintenance)
+			if err != nil {
+				return err
+			}
+			if *daemonRetention < 0 || *daemonBackupKeep < 0 {
+				return fmt.Errorf("--retention and --backup-keep can't be negative")
+			}
+			if *daemonFlushInterval < 0 || *daemonFlushThreshold < 0 || *daemonCheckpointIdle < 0 {
+				return fmt.Errorf("--flush-interval, --flush-threshold and --checkpoint-idle can't be negative")
+			}
+			if *daemonRetryDelay <= 0 {
+				return fmt.Errorf("--retry-delay must be positive")
+			}
+			return runDaemon(ctx, *dbPathDaemon, args, DaemonOptions{
+				Collect: CollectOptions{
+					NormalizeEnv:      *daemonNormalizeEnv,
+					JoinContinuations: *daemonJoinContinuations,
+					Ignore:            NewIgnoreRules(ignore),
+					MaxSize:           maxSize,
+					MaxSizeAction:     *daemonMaxSizeAction,
+					ArchivePath:       *daemonArchivePath,
+				},
+				Delay:          *daemonDelay,
+				FlushInterval:  *daemonFlushInterval,
+				FlushThreshold: *daemonFlushThreshold,
+				CheckpointIdle: *daemonCheckpointIdle,
+				RetryDelay:     *daemonRetryDelay,
+				Poll:           *daemonPoll,
+				Maintenance: MaintenanceOptions{
+					Schedule:    schedule,
+					RetainYears: *daemonRetention,
+					ArchivePath: *daemonArchivePath,
+					BackupDir:   *daemonBackupDir,
+					BackupKeep:  *daemonBackupKeep,
+				},
+			})
+		},
+	}
+
+	recordFlags := ff.NewFlagSet("record").SetParent(rootFlags)
+	dbPathRecord := recordFlags.StringLong("db", DefaultDBPath, "SQLite database path")
+	recordSource := recordFlags.StringLong("source", "", "History file the shell writes the command to")
+	recordTimestamp := recordFlags.Float64Long("timestamp", 0, "When the command started, in Unix seconds, as the history file has it")
+	recordDuration := recordFlags.IntLong("duration", 0, "How long the command ran, in seconds")
+	recordCWD := recordFlags.StringLong("cwd", "", "Directory the command ran in")
+	recordExitCode := recordFlags.IntLong("exit-code", 0, "Exit status of the command")
+	recordNormalizeEnv := recordFlags.BoolLong("normalize-env", "Store leading VAR=value assignments apart from the command, as for collect")
+	recordIgnore := recordFlags.StringListLong("ignore", "Leave out commands starting with these words, as for collect (repeatable)")
+	recordCmd := &ff.Command{
+		Name:      "record",
+		Usage:     "zist record [--db PATH] --source FILE --timestamp SECONDS [--duration SECONDS] [--cwd DIR] [--exit-code N] -- COMMAND",
+		ShortHelp: "Store a command the shell just ran with its directory, exit status and duration",
+		Flags:     recordFlags,
+		Exec: func(ctx context.Context, args []string) error {
+			if len(args) == 0 {
+				return fmt.Errorf("command is required")
+			}
+			if *recordSource == "" || *recordTimestamp <= 0 {
+				return fmt.Errorf("--source and --timestamp are required")
+			}
+			ignore := DefaultIgnore
+			if len(*recordIgnore) > 0 {
+				ignore = *recordIgnore
+			}
+			return runRecord(ctx, *dbPathRecord, Command{
+				Source:    *recordSource,
+				Timestamp: *recordTimestamp,
+				Command:   strings.Join(args, " "),
+				Duration:  *recordDuration,
+				CWD:       *recordCWD,
+				ExitCode:  *recordExitCode,
+			}, *recordNormalizeEnv, NewIgnoreRules(ignore))
 		},
 	}
 
 	searchFlags := ff.NewFlagSet("search").SetParent(rootFlags)
-	dbPathSearch := searchFlags.StringLong("db", "~/.zist/zist.db", "SQLite database path")
+	dbPathSearch := searchFlags.StringLong("db", DefaultDBPath, "SQLite database path")
 	limitFlag := searchFlags.IntLong("limit", 500, "Maximum number of results")
-	sinceFlag := searchFlags.StringLong("since", "", "Only show commands after this date (YYYY-MM-DD or YYYY-MM-DD HH:MM:SS)")
-	untilFlag := searchFlags.StringLong("until", "", "Only show commands before this date (YYYY-MM-DD or YYYY-MM-DD HH:MM:SS)")
+	sinceFlag := searchFlags.StringLong("since", "", "Only show commands after this date (YYYY-MM-DD, YYYY-MM-DD HH:MM:SS or -N[hdw])")
+	untilFlag := searchFlags.StringLong("until", "", "Only show commands before this date (YYYY-MM-DD, YYYY-MM-DD HH:MM:SS or -N[hdw])")
+	sourceFlag := searchFlags.StringLong("source", "", "Only show commands whose source path contains this")
+	includeArchiveFlag := searchFlags.BoolLong("include-archive", "Also search archived history")
+	exactFlag := searchFlags.BoolLong("exact", "Match QUERY words whole instead of as prefixes")
+	caseSensitiveFlag := searchFlags.BoolLong("case-sensitive", "Only show commands containing QUERY words in the same case")
+	sortFlag := searchFlags.StringLong("sort", SortRelevance, "Order matches for QUERY by relevance (best match, fading with age) or time (newest first)")
+	archivePathSearch := searchFlags.StringLong("archive-db", DefaultArchivePath, "Archive database path")
+	saveFlag := searchFlags.StringLong("save", "", "Save this query and its filters under NAME, then run it")
+	savedFlag := searchFlags.StringLong("saved", "", "Run the saved search NAME (other flags override its values)")
+	pickSavedFlag := searchFlags.BoolLong("pick-saved", "Choose a saved search in the picker, then run it")
+	listSavedFlag := searchFlags.BoolLong("list-saved", "List saved searches and exit")
+	deleteSavedFlag := searchFlags.StringLong("delete-saved", "", "Delete the saved search NAME and exit")
+	pickedFlag := searchFlags.StringLong("picked", "", "Record that a command picked in search was run, to rank it higher, and exit")
+	colorSearchFlag := searchFlags.StringLong("color", ColorAuto, "Mark rows with a color per host: auto, always or never")
+	semanticFlag := searchFlags.BoolLong("semantic", "Find commands similar in meaning to QUERY with --embed-model, even without shared words")
+	timeoutSearch := searchFlags.DurationLong("timeout", DefaultSearchTimeout, "Give up on a search, or a refinement in the picker, taking longer than this (0 for no limit)")
 	searchCmd := &ff.Command{
 		Name:      "search",
-		Usage:     "zist search [--db PATH] [--limit N] [--since DATE] [--until DATE] [QUERY]",
-		ShortHelp: "Search command history interactively with fzf",
+		Usage:     "zist search [--db PATH] [--limit N] [--since DATE] [--until DATE] [--source NAME] [--include-archive] [--exact] [--case-sensitive] [--sort relevance|time] [--semantic] [--timeout DURATION] [--save NAME | --saved NAME | --pick-saved] [QUERY]",
+		ShortHelp: "Search command history interactively in a fuzzy picker",
 		Flags:     searchFlags,
 		Exec: func(ctx context.Context, args []string) error {
-			return runSearch(ctx, *dbPathSearch, args, *limitFlag, *sinceFlag, *untilFlag)
+			if *listSavedFlag {
+				return runListSavedSearches(ctx, *dbPathSearch, os.Stdout)
+			}
+			if *deleteSavedFlag != "" {
+				return runDeleteSavedSearch(ctx, *dbPathSearch, *deleteSavedFlag)
+			}
+			if *pickedFlag != "" {
+				return runRecordPick(ctx, *dbPathSearch, *pickedFlag)
+			}
+
+			req := SearchRequest{
+				DBPath:        *dbPathSearch,
+				Limit:         *limitFlag,
+				Since:         *sinceFlag,
+				Until:         *untilFlag,
+				Source:        *sourceFlag,
+				Sort:          *sortFlag,
+				Exact:         *exactFlag,
+				SaveAs:        *saveFlag,
+				CaseSensitive: *caseSensitiveFlag,
+				Color:         *colorSearchFlag,
+				Semantic:      *semanticFlag,
+				Timeout:       *timeoutSearch,
+			}
+			if len(args) > 0 {
+				req.Query = args[0]
+			}
+			if *includeArchiveFlag {
+				req.ArchivePath = *archivePathSearch
+			}
+
+			savedName := *savedFlag
+			if *pickSavedFlag {
+				name, err := pickSavedSearch(ctx, *dbPathSearch)
+				if err != nil || name == "" {
+					return err
+				}
+				savedName = name
+			}
+			if savedName != "" {
+				isSet := func(name string) bool {
+					f, ok := searchFlags.GetFlag(name)
+					return ok && f.IsSet()
+				}
+				if err := loadSavedSearch(ctx, &req, savedName, isSet, *archivePathSearch); err != nil {
+					return err
+				}
+			}
+			return runSearch(ctx, req)
+		},
+	}
+
+	prefixFlags := ff.NewFlagSet("prefix-search").SetParent(rootFlags)
+	dbPathPrefix := prefixFlags.StringLong("db", DefaultDBPath, "SQLite database path")
+	prefixLimit := prefixFlags.IntLong("limit", 100, "Maximum number of commands")
+	prefixPrint0 := prefixFlags.BoolLong("print0", "Separate commands with NUL instead of newline, for multi-line commands")
+	prefixCmd := &ff.Command{
+		Name:      "prefix-search",
+		Usage:     "zist prefix-search [--db PATH] [--limit N] [--print0] [--] PREFIX",
+		ShortHelp: "List commands starting with PREFIX, most recent first (used by the Up/Down bindings)",
+		Flags:     prefixFlags,
+		Exec: func(ctx context.Context, args []string) error {
+			return runPrefixSearch(ctx, *dbPathPrefix, strings.Join(args, " "), *prefixLimit, *prefixPrint0, os.Stdout)
+		},
+	}
+
+	quickFlags := ff.NewFlagSet("quick").SetParent(rootFlags)
+	dbPathQuick := quickFlags.StringLong("db", DefaultDBPath, "SQLite database path")
+	quickQuery := quickFlags.StringLong("query", "", "Text to search for (default: the arguments)")
+	quickLimit := quickFlags.IntLong("limit", 10, "Maximum number of results")
+	quickJSON := quickFlags.BoolLong("json", "Print results as JSON for launcher extensions")
+	quickBudget := quickFlags.DurationLong("budget", DefaultQuickBudget, "Return whatever was found after this long")
+	quickColor := quickFlags.StringLong("color", ColorAuto, "Color commands by host: auto, always or never")
+	quickCmd := &ff.Command{
+		Name:      "quick",
+		Usage:     "zist quick [--db PATH] [--limit N] [--json] [--budget DURATION] [--query QUERY | QUERY]",
+		ShortHelp: "Search history within a strict time budget, for launcher extensions",
+		Flags:     quickFlags,
+		Exec: func(ctx context.Context, args []string) error {
+			query := *quickQuery
+			if query == "" {
+				query = strings.Join(args, " ")
+			}
+			return runQuick(ctx, *dbPathQuick, query, *quickLimit, *quickBudget, *quickJSON, *quickColor, os.Stdout)
+		},
+	}
+
+	nextFlags := ff.NewFlagSet("next").SetParent(rootFlags)
+	dbPathNext := nextFlags.StringLong("db", DefaultDBPath, "SQLite database path")
+	nextLimit := nextFlags.IntLong("limit", DefaultNextLimit, "Maximum number of suggestions")
+	nextJSON := nextFlags.BoolLong("json", "Print suggestions as JSON")
+	nextPick := nextFlags.BoolLong("pick", "Pick a suggestion in the picker and print it")
+	nextColor := nextFlags.StringLong("color", ColorAuto, "Color commands by host: auto, always or never")
+	nextCmd := &ff.Command{
+		Name:      "next",
+		Usage:     "zist next [--db PATH] [--limit N] [--json | --pick] [COMMAND]",
+		ShortHelp: "Suggest what usually comes after a command (default: the last one run)",
+		Flags:     nextFlags,
+		Exec: func(ctx context.Context, args []string) error {
+			return runNext(ctx, NextRequest{
+				DBPath:  *dbPathNext,
+				Command: strings.Join(args, " "),
+				Limit:   *nextLimit,
+				JSON:    *nextJSON,
+				Pick:    *nextPick,
+				Color:   *nextColor,
+			}, os.Stdout)
+		},
+	}
+
+	notFoundFlags := ff.NewFlagSet("not-found").SetParent(rootFlags)
+	dbPathNotFound := notFoundFlags.StringLong("db", DefaultDBPath, "SQLite database path")
+	notFoundLimit := notFoundFlags.IntLong("limit", DefaultNotFoundLimit, "Maximum number of suggestions")
+	notFoundCmd := &ff.Command{
+		Name:      "not-found",
+		Usage:     "zist not-found [--db PATH] [--limit N] -- COMMAND [ARG...]",
+		ShortHelp: "Suggest commands from history that a command the shell couldn't find likely meant",
+		Flags:     notFoundFlags,
+		Exec: func(ctx context.Context, args []string) error {
+			return runNotFound(ctx, *dbPathNotFound, args, *notFoundLimit, os.Stdout)
+		},
+	}
+
+	predictFlags := ff.NewFlagSet("predict").SetParent(rootFlags)
+	dbPathPredict := predictFlags.StringLong("db", DefaultDBPath, "SQLite database path")
+	predictAfter := predictFlags.StringLong("after", "", "Command just run (default: the last one in the database)")
+	predictPwd := predictFlags.StringLong("pwd", "", "Directory the next command runs in (default: the current one)")
+	predictLimit := predictFlags.IntLong("limit", DefaultPredictLimit, "Maximum number of predictions")
+	predictJSON := predictFlags.BoolLong("json", "Print predictions as JSON")
+	predictRetrain := predictFlags.BoolLong("retrain", "Count all history again instead of predicting")
+	predictNoWait := predictFlags.BoolLong("no-wait", "With --retrain, fail instead of waiting while another zist writes")
+	predictCmd := &ff.Command{
+		Name:      "predict",
+		Usage:     "zist predict [--db PATH] [--after COMMAND] [--pwd DIR] [--limit N] [--json] [--retrain]",
+		ShortHelp: "Predict the next command from what followed the last one in this directory",
+		Flags:     predictFlags,
+		Exec: func(ctx context.Context, args []string) error {
+			if *predictRetrain {
+				return runPredictRetrain(ctx, *dbPathPredict, *predictNoWait)
+			}
+			return runPredict(ctx, PredictRequest{
+				DBPath: *dbPathPredict,
+				After:  *predictAfter,
+				Dir:    *predictPwd,
+				Limit:  *predictLimit,
+				JSON:   *predictJSON,
+			}, os.Stdout)
+		},
+	}
+
+	refineFlags := ff.NewFlagSet("refine").SetParent(rootFlags)
+	refineState := refineFlags.StringLong("state", "", "Picker state file")
+	refineAdd := refineFlags.StringLong("add", "", "Filter to add (source=PATH, day=YYYY-MM-DD, text=WORDS, range=today|yesterday|week|month|FROM..TO)")
+	refinePop := refineFlags.BoolLong("pop", "Remove the most recent filter")
+	refineClear := refineFlags.BoolLong("clear", "Remove all filters")
+	refineEmit := refineFlags.BoolLong("emit", "Print matching records instead of picker actions")
+	refineCmd := &ff.Command{
+		Name:      "refine",
+		Usage:     "zist refine --state FILE [--add KIND=VALUE | --pop | --clear | --emit]",
+		ShortHelp: "Update drill-down filters of a running search picker (used by key bindings)",
+		Flags:     refineFlags,
+		Exec: func(ctx context.Context, args []string) error {
+			if *refineState == "" {
+				return fmt.Errorf("--state is required")
+			}
+			return runRefine(ctx, RefineRequest{
+				StateFile: *refineState,
+				Add:       *refineAdd,
+				Pop:       *refinePop,
+				Clear:     *refineClear,
+				Emit:      *refineEmit,
+			})
+		},
+	}
+
+	archiveFlags := ff.NewFlagSet("archive").SetParent(rootFlags)
+	dbPathArchive := archiveFlags.StringLong("db", DefaultDBPath, "SQLite database path")
+	archivePath := archiveFlags.StringLong("archive-db", DefaultArchivePath, "Archive database path")
+	archiveYears := archiveFlags.IntLong("older-than", DefaultArchiveYears, "Archive commands older than this many years")
+	archiveNoWait := archiveFlags.BoolLong("no-wait", "Fail instead of waiting while another zist writes to either DB")
+	archiveCmd := &ff.Command{
+		Name:      "archive",
+		Usage:     "zist archive [--db PATH] [--archive-db PATH] [--older-than YEARS] [--no-wait]",
+		ShortHelp: "Move old commands into a separate archive database",
+		Flags:     archiveFlags,
+		Exec: func(ctx context.Context, args []string) error {
+			return runArchive(ctx, *dbPathArchive, *archivePath, *archiveYears, *archiveNoWait)
 		},
 	}
 
 	installFlags := ff.NewFlagSet("install").SetParent(rootFlags)
+	installPrint := installFlags.BoolLong("print", "Print the block to add to .zshrc instead of adding it")
+	installRCFile := installFlags.StringLong("rc-file", "", "zsh rc file to edit (default: .zshrc in $ZDOTDIR or home)")
 	installCmd := &ff.Command{
 		Name:      "install",
-		Usage:     "zist install",
+		Usage:     "zist install [--print] [--rc-file PATH]",
 		ShortHelp: "Install ZSH integration (Ctrl+X binding and precmd hook)",
 		Flags:     installFlags,
 		Exec: func(ctx context.Context, args []string) error {
-			return runInstall(ctx)
+			if *installPrint {
+				_, err := os.Stdout.WriteString(zshIntegration)
+				return err
+			}
+			rcPath, err := rcFilePath(*installRCFile)
+			if err != nil {
+				return err
+			}
+			return runInstall(ctx, rcPath, os.Stdin, os.Stdout)
 		},
 	}
 
 	uninstallFlags := ff.NewFlagSet("uninstall").SetParent(rootFlags)
+	uninstallRCFile := uninstallFlags.StringLong("rc-file", "", "zsh rc file to edit (default: .zshrc in $ZDOTDIR or home)")
+	uninstallPurge := uninstallFlags.BoolLong("purge", "Also delete the history database, archive, config and all other zist data, after asking")
+	uninstallDryRun := uninstallFlags.BoolLong("dry-run", "Only show what would be removed")
+	dbPathUninstall := uninstallFlags.StringLong("db", DefaultDBPath, "SQLite database path, deleted by --purge")
+	archivePathUninstall := uninstallFlags.StringLong("archive-db", DefaultArchivePath, "Archive database path, deleted by --purge")
 	uninstallCmd := &ff.Command{
 		Name:      "uninstall",
-		Usage:     "zist uninstall",
-		ShortHelp: "Remove ZSH integration",
+		Usage:     "zist uninstall [--rc-file PATH] [--purge] [--dry-run]",
+		ShortHelp: "Remove ZSH integration, and with --purge all zist data",
 		Flags:     uninstallFlags,
 		Exec: func(ctx context.Context, args []string) error {
-			return runUninstall(ctx)
+			rcPath, err := rcFilePath(*uninstallRCFile)
+			if err != nil {
+				return err
+			}
+			opts := UninstallOptions{DryRun: *uninstallDryRun}
+			if *uninstallPurge {
+				opts.Purge = purgeCandidates(*dbPathUninstall, *archivePathUninstall)
+			}
+			return runUninstall(ctx, rcPath, opts, os.Stdin, os.Stdout)
 		},
 	}
 
-	wizardFlags := ff.NewFlagSet("wizard").SetParent(rootFlags)
-	wizardQuery := wizardFlags.StringLong("query", "q", "")
-	wizardCache := wizardFlags.StringLong("cache", "", "Cache a query→command mapping (format: query)")
-	wizardCacheCmd := wizardFlags.StringLong("cache-command", "", "Command to cache (use with --cache)")
-	wizardListCache := wizardFlags.BoolLong("list-cache", "List cached query→command mappings")
-	wizardClearCache := wizardFlags.BoolLong("clear-cache", "Clear all cached mappings")
-	wizardPWD := wizardFlags.StringLong("pwd", "", "Current working directory (default: $PWD)")
-	wizardOllamaURL := wizardFlags.StringLong("llm-api-url", "", "LLM API endpoint")
-	wizardModel := wizardFlags.StringLong("model", "", "Model name")
-	wizardKey := wizardFlags.StringLong("key", "", "API key")
-	wizardTimeout := wizardFlags.DurationLong("timeout", 30*time.Second, "LLM timeout")
-	wizardDBPath := wizardFlags.StringLong("db", "~/.zist/zist.db", "SQLite database path")
-	wizardCmd := &ff.Command{
-		Name:      "wizard",
-		Usage:     "zist wizard --query 'natural language' [--json]",
-		ShortHelp: "Generate shell commands from natural language",
-		Flags:     wizardFlags,
+	migrateFlags := ff.NewFlagSet("migrate-paths").SetParent(rootFlags)
+	migrateDryRun := migrateFlags.BoolLong("dry-run", "Only show what would move")
+	migrateCmd := &ff.Command{
+		Name:      "migrate-paths",
+		Usage:     "zist migrate-paths [--dry-run]",
+		ShortHelp: "Move ~/.zist into the XDG data and config directories",
+		Flags:     migrateFlags,
+		Exec: func(ctx context.Context, args []string) error {
+			return runMigratePaths(*migrateDryRun, os.Stdout)
+		},
+	}
+
+	noteFlags := ff.NewFlagSet("note").SetParent(rootFlags)
+	dbPathNote := noteFlags.StringLong("db", DefaultDBPath, "SQLite database path")
+	deleteNote := noteFlags.Int64Long("delete", 0, "Remove the note from command ID")
+	noteCmd := &ff.Command{
+		Name:      "note",
+		Usage:     "zist note [--db PATH] ID [TEXT] | zist note --delete ID",
+		ShortHelp: "Attach a note to a history entry (ID is shown in the search preview)",
+		Flags:     noteFlags,
+		Exec: func(ctx context.Context, args []string) error {
+			if *deleteNote > 0 {
+				return runNote(ctx, *dbPathNote, *deleteNote, "", true)
+			}
+			if len(args) == 0 {
+				return fmt.Errorf("command ID is required")
+			}
+			id, err := strconv.ParseInt(args[0], 10, 64)
+			if err != nil {
+				return fmt.Errorf("invalid command ID: %s", args[0])
+			}
+			return runNote(ctx, *dbPathNote, id, strings.Join(args[1:], " "), false)
+		},
+	}
+
+	linkFlags := ff.NewFlagSet("link").SetParent(rootFlags)
+	dbPathLink := linkFlags.StringLong("db", DefaultDBPath, "SQLite database path")
+	deleteLink := linkFlags.Int64Long("delete", 0, "Remove the given links from command ID, or all of them")
+	linkCmd := &ff.Command{
+		Name:      "link",
+		Usage:     "zist link [--db PATH] ID [URL|PATH]... | zist link --delete ID [URL|PATH]...",
+		ShortHelp: "Attach ticket URLs, PR links or file paths to a history entry (ID is shown in the search preview)",
+		Flags:     linkFlags,
+		Exec: func(ctx context.Context, args []string) error {
+			if *deleteLink > 0 {
+				return runLink(ctx, *dbPathLink, *deleteLink, args, true)
+			}
+			if len(args) == 0 {
+				return fmt.Errorf("command ID is required")
+			}
+			id, err := strconv.ParseInt(args[0], 10, 64)
+			if err != nil {
+				return fmt.Errorf("invalid command ID: %s", args[0])
+			}
+			return runLink(ctx, *dbPathLink, id, args[1:], false)
+		},
+	}
+
+	timelineFlags := ff.NewFlagSet("timeline").SetParent(rootFlags)
+	dbPathTimeline := timelineFlags.StringLong("db", DefaultDBPath, "SQLite database path")
+	timelineCWD := timelineFlags.StringLong("cwd", "", "Project directory to show work in (default: the project around the current directory)")
+	timelineSince := timelineFlags.StringLong("since", "-7d", "Only show commands after this date (YYYY-MM-DD, YYYY-MM-DD HH:MM:SS or -N[hdw])")
+	timelineUntil := timelineFlags.StringLong("until", "", "Only show commands before this date (YYYY-MM-DD, YYYY-MM-DD HH:MM:SS or -N[hdw])")
+	timelineSource := timelineFlags.StringLong("source", "", "Only show commands from sources containing this")
+	timelineFormat := timelineFlags.StringLong("format", "", "Output: tui (browse in the picker), text or markdown (default: tui on a terminal, text otherwise)")
+	timelineColor := timelineFlags.StringLong("color", ColorAuto, "Color text output by host: auto, always or never")
+	timelineCmd := &ff.Command{
+		Name:      "timeline",
+		Usage:     "zist timeline [--db PATH] [--cwd DIR] [--since DATE] [--until DATE] [--source NAME] [--format tui|text|markdown]",
+		ShortHelp: "Show everything done in a project, grouped into sessions, e.g. for status updates and postmortems",
+		Flags:     timelineFlags,
+		Exec: func(ctx context.Context, args []string) error {
+			return runTimeline(ctx, TimelineRequest{
+				DBPath: *dbPathTimeline,
+				Dir:    *timelineCWD,
+				Since:  *timelineSince,
+				Until:  *timelineUntil,
+				Source: *timelineSource,
+				Format: *timelineFormat,
+				Color:  *timelineColor,
+			}, os.Stdout)
+		},
+	}
+
+	sequenceFlags := ff.NewFlagSet("sequence").SetParent(rootFlags)
+	dbPathSequence := sequenceFlags.StringLong("db", DefaultDBPath, "SQLite database path")
+	sequenceWithin := sequenceFlags.DurationLong("within", DefaultSequenceWithin, "Longest time from one step to the next")
+	sequenceRegexp := sequenceFlags.BoolLong("regexp", "Match steps as regular expressions instead of command prefixes")
+	sequenceSince := sequenceFlags.StringLong("since", "", "Only look at commands after this date (YYYY-MM-DD, YYYY-MM-DD HH:MM:SS or -N[hdw])")
+	sequenceUntil := sequenceFlags.StringLong("until", "", "Only look at commands before this date (YYYY-MM-DD, YYYY-MM-DD HH:MM:SS or -N[hdw])")
+	sequenceSource := sequenceFlags.StringLong("source", "", "Only look at sources containing this")
+	sequenceLimit := sequenceFlags.IntLong("limit", DefaultSequenceLimit, "Most recent occurrences to list (0 for all)")
+	sequenceJSON := sequenceFlags.BoolLong("json", "Print every occurrence as JSON")
+	sequenceColor := sequenceFlags.StringLong("color", ColorAuto, "Color occurrences by host: auto, always or never")
+	sequenceCmd := &ff.Command{
+		Name:      "sequence",
+		Usage:     "zist sequence [--db PATH] [--within DURATION] [--regexp] [--since DATE] [--until DATE] [--source NAME] [--limit N] [--json] STEP STEP...",
+		ShortHelp: "Find where commands were run one after another, e.g. to spot workflows worth scripting",
+		Flags:     sequenceFlags,
+		Exec: func(ctx context.Context, args []string) error {
+			return runSequence(ctx, SequenceRequest{
+				DBPath: *dbPathSequence,
+				Steps:  args,
+				Within: *sequenceWithin,
+				Regexp: *sequenceRegexp,
+				Since:  *sequenceSince,
+				Until:  *sequenceUntil,
+				Source: *sequenceSource,
+				Limit:  *sequenceLimit,
+				JSON:   *sequenceJSON,
+				Color:  *sequenceColor,
+			}, os.Stdout)
+		},
+	}
+
+	replayFlags := ff.NewFlagSet("replay").SetParent(rootFlags)
+	dbPathReplay := replayFlags.StringLong("db", DefaultDBPath, "SQLite database path")
+	replayCWD := replayFlags.StringLong("cwd", "", "Only replay commands run in this directory or below it (default: every command)")
+	replaySince := replayFlags.StringLong("since", "-1d", "Only replay commands after this date (YYYY-MM-DD, YYYY-MM-DD HH:MM:SS or -N[hdw])")
+	replayUntil := replayFlags.StringLong("until", "", "Only replay commands before this date (YYYY-MM-DD, YYYY-MM-DD HH:MM:SS or -N[hdw])")
+	replaySource := replayFlags.StringLong("source", "", "Only replay commands from sources containing this")
+	replaySession := replayFlags.IntLong("session", 1, "Replay the Nth most recent session in the range (0 replays every session)")
+	replayFormat := replayFlags.StringLong("format", ReplayScript, "Output: script (a zsh script with the original pauses)")
+	replaySpeed := replayFlags.Float64Long("speed", 1, "Divide every pause by this")
+	replayMaxSleep := replayFlags.DurationLong("max-sleep", 0, "Longest pause between two commands (0 keeps them as they were)")
+	replayCmd := &ff.Command{
+		Name:      "replay",
+		Usage:     "zist replay [--db PATH] [--since DATE] [--until DATE] [--source NAME] [--cwd DIR] [--session N] [--speed X] [--max-sleep DURATION]",
+		ShortHelp: "Write a past session out as a script that runs it again, for demos and repeating a workflow",
+		Flags:     replayFlags,
+		Exec: func(ctx context.Context, args []string) error {
+			return runReplay(ctx, ReplayRequest{
+				DBPath:  *dbPathReplay,
+				Dir:     *replayCWD,
+				Since:   *replaySince,
+				Until:   *replayUntil,
+				Source:  *replaySource,
+				Session: *replaySession,
+				Format:  *replayFormat,
+				Options: ReplayOptions{Speed: *replaySpeed, MaxSleep: *replayMaxSleep},
+			}, os.Stdout)
+		},
+	}
+
+	showFlags := ff.NewFlagSet("show").SetParent(rootFlags)
+	dbPathShow := showFlags.StringLong("db", DefaultDBPath, "SQLite database path")
+	showColor := showFlags.StringLong("color", ColorAuto, "Highlight shell syntax: auto, always or never")
+	showRecord := showFlags.StringLong("record", "", "Print the preview pane for a picker record instead, as fzf passes it with {}")
+	showCmd := &ff.Command{
+		Name:      "show",
+		Usage:     "zist show [--db PATH] [--color auto|always|never] ID | --record RECORD",
+		ShortHelp: "Print a command, with its shell syntax highlighted",
+		Flags:     showFlags,
+		Exec: func(ctx context.Context, args []string) error {
+			req := ShowRequest{DBPath: *dbPathShow, Color: *showColor, Record: *showRecord}
+			if req.Record == "" {
+				if len(args) == 0 {
+					return fmt.Errorf("command ID is required")
+				}
+				id, err := strconv.ParseInt(args[0], 10, 64)
+				if err != nil {
+					return fmt.Errorf("invalid command ID: %s", args[0])
+				}
+				req.ID = id
+			}
+			return runShow(ctx, req, os.Stdout)
+		},
+	}
+
+	shareFlags := ff.NewFlagSet("share").SetParent(rootFlags)
+	dbPathShare := shareFlags.StringLong("db", DefaultDBPath, "SQLite database path")
+	shareTo := shareFlags.StringLong("to", "", "Where to share: markdown, gist or paste (overridden by ZIST_SHARE_TO, default: markdown)")
+	shareNoNote := shareFlags.BoolLong("no-note", "Leave out the command's note")
+	shareNoContext := shareFlags.BoolLong("no-context", "Leave out when and from which history file the command ran")
+	sharePublic := shareFlags.BoolLong("public", "Create a public gist instead of a secret one")
+	shareGitHubToken := shareFlags.StringLong("github-token", "", "GitHub token for gists (overridden by ZIST_GITHUB_TOKEN)")
+	shareGistURL := shareFlags.StringLong("gist-api-url", DefaultGistAPIURL, "GitHub gists API endpoint")
+	sharePasteURL := shareFlags.StringLong("paste-url", "", "Paste service endpoint (overridden by ZIST_PASTE_URL)")
+	shareYes := shareFlags.BoolLong("yes", "Upload to a gist or paste without showing it and asking first")
+	shareCmd := &ff.Command{
+		Name:      "share",
+		Usage:     "zist share [--db PATH] [--to markdown|gist|paste] [--no-note] [--no-context] [--yes] ID",
+		ShortHelp: "Share a command as a markdown snippet, gist or paste URL",
+		Flags:     shareFlags,
 		Exec: func(ctx context.Context, args []string) error {
-			ollamaURL := *wizardOllamaURL
-			if ollamaURL == "" {
-				ollamaURL = os.Getenv("ZIST_LLM_API_URL")
+			if len(args) == 0 {
+				return fmt.Errorf("command ID is required")
+			}
+			id, err := strconv.ParseInt(args[0], 10, 64)
+			if err != nil {
+				return fmt.Errorf("invalid command ID: %s", args[0])
 			}
-			if ollamaURL == "" {
-				ollamaURL = "http://localhost:11434/v1"
+
+			to := *shareTo
+			if to == "" {
+				to = os.Getenv("ZIST_SHARE_TO")
 			}
-			model := *wizardModel
-			if model == "" {
-				model = os.Getenv("ZIST_MODEL")
+			token := *shareGitHubToken
+			if token == "" {
+				token = os.Getenv("ZIST_GITHUB_TOKEN")
 			}
-			if model == "" {
-				model = "qwen2.5-coder:3b"
+			pasteURL := *sharePasteURL
+			if pasteURL == "" {
+				pasteURL = os.Getenv("ZIST_PASTE_URL")
 			}
-			key := *wizardKey
-			if key == "" {
-				key = os.Getenv("ZIST_LLM_API_KEY")
+
+			var target ShareTarget
+			switch to {
+			case "", "markdown":
+			case "gist":
+				target = &GistTarget{APIURL: *shareGistURL, Token: token, Public: *sharePublic}
+			case "paste":
+				target = &PasteTarget{URL: pasteURL}
+			default:
+				return fmt.Errorf("unknown share target %q (use markdown, gist or paste)", to)
 			}
-			return runWizard(ctx, *wizardDBPath, *wizardQuery, *wizardPWD,
-				ollamaURL, model, key, *wizardTimeout,
-				*wizardCache, *wizardCacheCmd, *wizardListCache, *wizardClearCache)
+
+			return runShare(ctx, *dbPathShare, id, target, ShareOptions{
+				Note:    !*shareNoNote,
+				Context: !*shareNoContext,
+				Yes:     *shareYes,
+			}, os.Stdin, os.Stdout)
 		},
 	}
 
-	var rootCmd *ff.Command
+	exportFlags := ff.NewFlagSet("export").SetParent(rootFlags)
+	dbPathExport := exportFlags.StringLong("db", DefaultDBPath, "SQLite database path")
+	exportOutput := exportFlags.StringLong("output", "-", "File to write the export to (- for stdout)")
+	exportChain := exportFlags.BoolLong("chain", "End each record with a hash covering it and the record before, so changes to the export can be detected")
+	exportSince := exportFlags.StringLong("since", "", "Only export commands after this date (YYYY-MM-DD, YYYY-MM-DD HH:MM:SS or -N[hdw])")
+	exportUntil := exportFlags.StringLong("until", "", "Only export commands before this date (YYYY-MM-DD, YYYY-MM-DD HH:MM:SS or -N[hdw])")
+	exportSource := exportFlags.StringLong("source", "", "Only export commands from this source")
+	exportVerify := exportFlags.StringLong("verify", "", "Check the hash chain of an export written with --chain instead (- for stdin)")
+	exportHead := exportFlags.StringLong("head", "", "With --verify, the hash printed when the export was written, to also detect records cut off the end")
+	exportCmd := &ff.Command{
+		Name:      "export",
+		Usage:     "zist export [--db PATH] [--output FILE] [--chain] [--since DATE] [--until DATE] [--source NAME] | --verify FILE [--head HASH]",
+		ShortHelp: "Export history as JSON lines, optionally hash-chained to prove it wasn't changed",
+		Flags:     exportFlags,
+		Exec: func(ctx context.Context, args []string) error {
+			if *exportVerify != "" {
+				return runExportVerify(*exportVerify, *exportHead)
+			}
+			if *exportHead != "" {
+				return fmt.Errorf("--head needs --verify")
+			}
+			since, err := parseDateTime(*exportSince)
+			if err != nil {
+				return fmt.Errorf("invalid --since: %w", err)
+			}
+			until, err := parseDateTime(*exportUntil)
+			if err != nil {
+				return fmt.Errorf("invalid --until: %w", err)
+			}
+			return runExport(ctx, *dbPathExport, *exportOutput, ExportOptions{
+				Since:  since,
+				Until:  until,
+				Source: *exportSource,
+				Chain:  *exportChain,
+			})
+		},
+	}
 
-	rootCmd = &ff.Command{
-		Name:  "zist",
-		Usage: "zist [FLAGS] SUBCOMMAND ...",
-		ShortHelp: "Local ZSH history aggregation tool. " +
-			"Reads commands from multiple ZSH history files, " +
-			"aggregates them into a local SQLite database, and provides fast search.",
-		Flags:       rootFlags,
-		Subcommands: []*ff.Command{collectCmd, searchCmd, wizardCmd, installCmd, uninstallCmd},
+	snippetFlags := ff.NewFlagSet("snippet").SetParent(rootFlags)
+	dbPathSnippet := snippetFlags.StringLong("db", DefaultDBPath, "SQLite database path")
+	snippetDir := snippetFlags.StringLong("dir", DefaultTeamSnippetsDir, "Checkout of the team snippets repo")
+	snippetAddFlags := ff.NewFlagSet("add").SetParent(snippetFlags)
+	snippetDescription := snippetAddFlags.StringLong("description", "", "What the snippet does")
+	snippetAddCmd := &ff.Command{
+		Name:      "add",
+		Usage:     "zist snippet add [--description TEXT] NAME COMMAND",
+		ShortHelp: "Save a command as a local snippet",
+		Flags:     snippetAddFlags,
 		Exec: func(ctx context.Context, args []string) error {
-			return fmt.Errorf("no subcommand provided")
+			if len(args) < 2 {
+				return fmt.Errorf("snippet name and command are required")
+			}
+			return runSnippetAdd(ctx, *dbPathSnippet, Snippet{
+				Name:        args[0],
+				Command:     strings.Join(args[1:], " "),
+				Description: *snippetDescription,
+			})
+		},
+	}
+	snippetListCmd := &ff.Command{
+		Name:      "list",
+		Usage:     "zist snippet list [QUERY]",
+		ShortHelp: "List local and team snippets",
+		Flags:     ff.NewFlagSet("list").SetParent(snippetFlags),
+		Exec: func(ctx context.Context, args []string) error {
+			return runSnippetList(ctx, *dbPathSnippet, strings.Join(args, " "))
+		},
+	}
+	snippetRmCmd := &ff.Command{
+		Name:      "rm",
+		Usage:     "zist snippet rm NAME",
+		ShortHelp: "Delete a local snippet",
+		Flags:     ff.NewFlagSet("rm").SetParent(snippetFlags),
+		Exec: func(ctx context.Context, args []string) error {
+			if len(args) == 0 {
+				return fmt.Errorf("snippet name is required")
+			}
+			return runSnippetRm(ctx, *dbPathSnippet, args[0])
+		},
+	}
+	snippetSyncFlags := ff.NewFlagSet("sync").SetParent(snippetFlags)
+	snippetRepo := snippetSyncFlags.StringLong("repo", "", "Team snippets git repo to clone (overridden by ZIST_TEAM_SNIPPETS_REPO)")
+	snippetSyncCmd := &ff.Command{
+		Name:      "sync",
+		Usage:     "zist snippet sync [--repo URL] [--dir PATH]",
+		ShortHelp: "Pull the team snippet library",
+		Flags:     snippetSyncFlags,
+		Exec: func(ctx context.Context, args []string) error {
+			repo := *snippetRepo
+			if repo == "" {
+				repo = os.Getenv("ZIST_TEAM_SNIPPETS_REPO")
+			}
+			return runSnippetSync(ctx, *dbPathSnippet, repo, *snippetDir)
+		},
+	}
+	snippetPublishCmd := &ff.Command{
+		Name:      "publish",
+		Usage:     "zist snippet publish [--dir PATH] NAME",
+		ShortHelp: "Push a local snippet to the team library",
+		Flags:     ff.NewFlagSet("publish").SetParent(snippetFlags),
+		Exec: func(ctx context.Context, args []string) error {
+			if len(args) == 0 {
+				return fmt.Errorf("snippet name is required")
+			}
+			return runSnippetPublish(ctx, *dbPathSnippet, *snippetDir, args[0])
+		},
+	}
+	snippetCmd := &ff.Command{
+		Name:        "snippet",
+		Usage:       "zist snippet <add|list|rm|sync|publish>",
+		ShortHelp:   "Manage blessed commands shared with your team",
+		Flags:       snippetFlags,
+		Subcommands: []*ff.Command{snippetAddCmd, snippetListCmd, snippetRmCmd, snippetSyncCmd, snippetPublishCmd},
+	}
+
+	serveFlags := ff.NewFlagSet("serve").SetParent(rootFlags)
+	serveAddr := serveFlags.StringLong("addr", "127.0.0.1:7474", "Address to listen on")
+	serveDir := serveFlags.StringLong("data-dir", DefaultServerDir, "Directory for the server and per-user databases")
+	serveTLSCert := serveFlags.StringLong("tls-cert", "", "TLS certificate file")
+	serveTLSKey := serveFlags.StringLong("tls-key", "", "TLS private key file")
+	serveACMEDomain := serveFlags.StringLong("acme-domain", "", "Get certificates from Let's Encrypt for these comma-separated domains")
+	serveACMEEmail := serveFlags.StringLong("acme-email", "", "Contact email for Let's Encrypt")
+	serveACMECache := serveFlags.StringLong("acme-cache", "", "Directory for Let's Encrypt certificates (default: DATA-DIR/acme)")
+	serveClientCA := serveFlags.StringLong("client-ca", "", "Require client certificates signed by this CA (mutual TLS)")
+	defaultLimits := DefaultServerLimits()
+	serveRateLimit := serveFlags.IntLong("rate-limit", defaultLimits.RequestsPerMinute, "Requests per minute allowed per token (0 disables)")
+	serveRateBurst := serveFlags.IntLong("rate-burst", defaultLimits.Burst, "Requests allowed in a burst before the rate limit applies")
+	serveMaxBody := serveFlags.Int64Long("max-body", defaultLimits.MaxBodyBytes, "Largest accepted request body in bytes")
+	serveMaxPush := serveFlags.IntLong("max-push", defaultLimits.MaxPushCommands, "Most commands accepted in one push")
+	serveSlowRequest := serveFlags.DurationLong("slow-request", defaultLimits.SlowRequest, "Log requests slower than this (0 disables)")
+	serveMinFreeDisk := serveFlags.Uint64Long("min-free-disk", defaultLimits.MinFreeDisk, "Report not ready below this many free bytes in the data dir")
+	serveUI := serveFlags.BoolLong("ui", "Serve a web UI for browsing history at /")
+	serveWizard := serveFlags.BoolLong("wizard", "Generate commands for clients at /api/v1/wizard, e.g. for ghost text previews")
+	serveWarm := serveFlags.DurationLong("warm", 0, "With --wizard, load the model at startup and again this often so it stays in memory (0 disables)")
+	serveLLMBackend := serveFlags.StringLong("llm-backend", "", "LLM API flavour for --wizard: "+strings.Join(llm.Backends(), ", ")+" (default: openai)")
+	serveLLMURL := serveFlags.StringLong("llm-api-url", "", "LLM API endpoint for --wizard")
+	serveModel := serveFlags.StringLong("model", "", "Model name for --wizard")
+	serveKey := serveFlags.StringLong("key", "", "API key for --wizard")
+	serveUserFlags := ff.NewFlagSet("user").SetParent(serveFlags)
+	serveUserAddFlags := ff.NewFlagSet("add").SetParent(serveUserFlags)
+	serveUserRole := serveUserAddFlags.StringLong("role", RoleMember, "Role: member or admin")
+	serveUserAddCmd := &ff.Command{
+		Name:      "add",
+		Usage:     "zist serve user add [--role member|admin] NAME",
+		ShortHelp: "Create a user and print their API token",
+		Flags:     serveUserAddFlags,
+		Exec: func(ctx context.Context, args []string) error {
+			if len(args) == 0 {
+				return fmt.Errorf("user name is required")
+			}
+			return runServeUser(ctx, *serveDir, "add", args[0], *serveUserRole)
+		},
+	}
+	serveUserListCmd := &ff.Command{
+		Name:      "list",
+		Usage:     "zist serve user list",
+		ShortHelp: "List users",
+		Flags:     ff.NewFlagSet("list").SetParent(serveUserFlags),
+		Exec: func(ctx context.Context, args []string) error {
+			return runServeUser(ctx, *serveDir, "list", "", "")
+		},
+	}
+	serveUserRmCmd := &ff.Command{
+		Name:      "rm",
+		Usage:     "zist serve user rm NAME",
+		ShortHelp: "Delete a user (their history database is kept)",
+		Flags:     ff.NewFlagSet("rm").SetParent(serveUserFlags),
+		Exec: func(ctx context.Context, args []string) error {
+			if len(args) == 0 {
+				return fmt.Errorf("user name is required")
+			}
+			return runServeUser(ctx, *serveDir, "rm", args[0], "")
+		},
+	}
+	serveUserTokenCmd := &ff.Command{
+		Name:      "token",
+		Usage:     "zist serve user token NAME",
+		ShortHelp: "Issue a new API token for a user, revoking the old one",
+		Flags:     ff.NewFlagSet("token").SetParent(serveUserFlags),
+		Exec: func(ctx context.Context, args []string) error {
+			if len(args) == 0 {
+				return fmt.Errorf("user name is required")
+			}
+			return runServeUser(ctx, *serveDir, "token", args[0], "")
+		},
+	}
+	serveUserCmd := &ff.Command{
+		Name:        "user",
+		Usage:       "zist serve user <add|list|rm|token>",
+		ShortHelp:   "Manage server users and API tokens",
+		Flags:       serveUserFlags,
+		Subcommands: []*ff.Command{serveUserAddCmd, serveUserListCmd, serveUserRmCmd, serveUserTokenCmd},
+	}
+	serveCmd := &ff.Command{
+		Name:        "serve",
+		Usage:       "zist serve [--addr HOST:PORT] [--data-dir PATH] [--tls-cert FILE --tls-key FILE | --acme-domain DOMAINS] [--client-ca FILE] [--ui] [--wizard]",
+		ShortHelp:   "Run a shared zist server with per-user namespaces",
+		Flags:       serveFlags,
+		Subcommands: []*ff.Command{serveUserCmd},
+		Exec: func(ctx context.Context, args []string) error {
+			tlsOpts := ServerTLSOptions{
+				CertFile:     *serveTLSCert,
+				KeyFile:      *serveTLSKey,
+				ACMEEmail:    *serveACMEEmail,
+				ACMECacheDir: *serveACMECache,
+				ClientCAFile: *serveClientCA,
+			}
+			if *serveACMEDomain != "" {
+				tlsOpts.ACMEDomains = strings.Split(*serveACMEDomain, ",")
+			}
+			if tlsOpts.ACMECacheDir == "" {
+				tlsOpts.ACMECacheDir = defaultACMECacheDir(*serveDir)
+			}
+			limits := ServerLimits{
+				RequestsPerMinute: *serveRateLimit,
+				Burst:             *serveRateBurst,
+				MaxBodyBytes:      *serveMaxBody,
+				MaxPushCommands:   *serveMaxPush,
+				SlowRequest:       *serveSlowRequest,
+				MinFreeDisk:       *serveMinFreeDisk,
+			}
+			var wizardLLM llm.Client
+			if *serveWizard {
+				config, err := llmSettings(llm.Config{
+					Backend:   *serveLLMBackend,
+					BaseURL:   *serveLLMURL,
+					Model:     *serveModel,
+					APIKey:    *serveKey,
+					Timeout:   30 * time.Second,
+					MaxTokens: 500,
+				})
+				if err != nil {
+					return err
+				}
+				wizardLLM, err = llm.New(config)
+				if err != nil {
+					return fmt.Errorf("failed to create LLM client: %w", err)
+				}
+			}
+			return runServe(ctx, *serveAddr, *serveDir, tlsOpts, limits, *serveUI, wizardLLM, *serveWarm)
+		},
+	}
+
+	syncFlags := ff.NewFlagSet("sync").SetParent(rootFlags)
+	dbPathSync := syncFlags.StringLong("db", DefaultDBPath, "SQLite database path")
+	syncServer := syncFlags.StringLong("server", "", "zist server URL (overridden by ZIST_SERVER)")
+	syncToken := syncFlags.StringLong("token", "", "API token (overridden by ZIST_TOKEN)")
+	syncCACert := syncFlags.StringLong("ca-cert", "", "Trust this CA for the server certificate (overridden by ZIST_CA_CERT)")
+	syncClientCert := syncFlags.StringLong("client-cert", "", "Client certificate for mutual TLS (overridden by ZIST_CLIENT_CERT)")
+	syncClientKey := syncFlags.StringLong("client-key", "", "Client private key for mutual TLS (overridden by ZIST_CLIENT_KEY)")
+	syncAllowHTTP := syncFlags.BoolLong("allow-http", "Allow plain HTTP to servers other than localhost")
+	syncDiff := syncFlags.BoolLong("diff", "Only report how many unpushed rows per source and day the server would take as new, duplicate or conflicting")
+	syncCmd := &ff.Command{
+		Name:      "sync",
+		Usage:     "zist sync [--db PATH] [--server URL] [--token TOKEN] [--ca-cert FILE] [--client-cert FILE --client-key FILE] [--diff]",
+		ShortHelp: "Push new local history to a zist server",
+		Flags:     syncFlags,
+		Exec: func(ctx context.Context, args []string) error {
+			server := *syncServer
+			if server == "" {
+				server = os.Getenv("ZIST_SERVER")
+			}
+			token := *syncToken
+			if token == "" {
+				token = os.Getenv("ZIST_TOKEN")
+			}
+			if server == "" || token == "" {
+				return fmt.Errorf("--server and --token (or ZIST_SERVER and ZIST_TOKEN) are required")
+			}
+			if err := CheckTransport(server, *syncAllowHTTP); err != nil {
+				return err
+			}
+
+			tlsOpts := ClientTLSOptions{CAFile: *syncCACert, CertFile: *syncClientCert, KeyFile: *syncClientKey}
+			if tlsOpts.CAFile == "" {
+				tlsOpts.CAFile = os.Getenv("ZIST_CA_CERT")
+			}
+			if tlsOpts.CertFile == "" {
+				tlsOpts.CertFile = os.Getenv("ZIST_CLIENT_CERT")
+			}
+			if tlsOpts.KeyFile == "" {
+				tlsOpts.KeyFile = os.Getenv("ZIST_CLIENT_KEY")
+			}
+			tlsConfig, err := tlsOpts.Config()
+			if err != nil {
+				return err
+			}
+
+			client := &SyncClient{Server: server, Token: token}
+			if tlsConfig != nil {
+				client.Client = &http.Client{
+					Timeout:   60 * time.Second,
+					Transport: &http.Transport{TLSClientConfig: tlsConfig},
+				}
+			}
+			return runSync(ctx, *dbPathSync, client, *syncDiff)
+		},
+	}
+
+	statsFlags := ff.NewFlagSet("stats").SetParent(rootFlags)
+	dbPathStats := statsFlags.StringLong("db", DefaultDBPath, "SQLite database path")
+	statsWizard := statsFlags.BoolLong("wizard", "Show wizard requests, token usage and estimated cost per model")
+	statsCollections := statsFlags.BoolLong("collections", "Show collect runs per history file, ones that stopped getting new commands first")
+	statsStorage := statsFlags.BoolLong("storage", "Show disk usage per table, growth forecast and what would free space")
+	statsBinary := statsFlags.BoolLong("binary", "Show commands run today, this week and in all, overall, in the current project and per program")
+	statsJSON := statsFlags.BoolLong("json", "Print --binary counts as JSON, in a schema stable for prompt tools")
+	statsPwd := statsFlags.StringLong("pwd", "", "Directory whose project --binary counts (default: the current directory)")
+	statsDays := statsFlags.IntLong("days", 0, "Only count wizard requests or collect runs from the last N days (0 for all kept)")
+	statsTop := statsFlags.IntLong("top", 10, "Number of most used commands to show")
+	statsPrices := statsFlags.StringListLong("price", "Price of a model in USD per million tokens: MODEL=INPUT/OUTPUT (repeatable)")
+	statsCmd := &ff.Command{
+		Name:      "stats",
+		Usage:     "zist stats [--db PATH] [--top N] | --binary [--json] [--pwd DIR] [--top N] | --wizard [--days N] [--price MODEL=INPUT/OUTPUT]... | --collections [--days N] | --storage",
+		ShortHelp: "Show history statistics, wizard token usage and cost, collect runs, or disk usage",
+		Flags:     statsFlags,
+		Exec: func(ctx context.Context, args []string) error {
+			if *statsJSON && !*statsBinary {
+				return fmt.Errorf("--json only works with --binary")
+			}
+			if *statsBinary {
+				return runStatsBinary(ctx, *dbPathStats, *statsPwd, *statsTop, *statsJSON, os.Stdout)
+			}
+			if *statsWizard {
+				return runStatsWizard(ctx, *dbPathStats, *statsDays, *statsPrices, os.Stdout)
+			}
+			if *statsCollections {
+				return runStatsCollections(ctx, *dbPathStats, *statsDays, os.Stdout)
+			}
+			if *statsStorage {
+				return runStatsStorage(ctx, *dbPathStats, os.Stdout)
+			}
+			return runStats(ctx, *dbPathStats, *statsTop, os.Stdout)
 		},
 	}
 
-	if err := rootCmd.ParseAndRun(context.Background(), os.Args[1:]); err != nil {
-		if *versionFlag {
-			fmt.Printf("zist version %s\n", version)
-			return
-		}
-		if *helpFlag {
-			fmt.Println(ffhelp.Command(rootCmd))
-			return
-		}
-		fmt.Println(ffhelp.Command(rootCmd))
-		if err.Error() == "no subcommand provided" {
-			os.Exit(0)
-		}
-		fmt.Printf("error: %v\n", err)
-		os.Exit(1)
+	ftsFlags := ff.NewFlagSet("fts").SetParent(rootFlags)
+	dbPathFTS := ftsFlags.StringLong("db", DefaultDBPath, "SQLite database path")
+	ftsRebuildFlags := ff.NewFlagSet("rebuild").SetParent(ftsFlags)
+	ftsNoWait := ftsRebuildFlags.BoolLong("no-wait", "Fail instead of waiting while another zist writes to the DB")
+	ftsRebuildCmd := &ff.Command{
+		Name:      "rebuild",
+		Usage:     "zist fts rebuild [--db PATH] [--no-wait]",
+		ShortHelp: "Rebuild the full-text search index from scratch",
+		Flags:     ftsRebuildFlags,
+		Exec: func(ctx context.Context, args []string) error {
+			return runFTS(ctx, *dbPathFTS, true, *ftsNoWait)
+		},
+	}
+	ftsCheckCmd := &ff.Command{
+		Name:      "check",
+		Usage:     "zist fts check [--db PATH]",
+		ShortHelp: "Verify the full-text search index matches stored commands",
+		Flags:     ff.NewFlagSet("check").SetParent(ftsFlags),
+		Exec: func(ctx context.Context, args []string) error {
+			return runFTS(ctx, *dbPathFTS, false, false)
+		},
+	}
+	ftsCmd := &ff.Command{
+		Name:        "fts",
+		Usage:       "zist fts <rebuild|check> [--db PATH]",
+		ShortHelp:   "Check or rebuild the full-text search index",
+		Flags:       ftsFlags,
+		Subcommands: []*ff.Command{ftsRebuildCmd, ftsCheckCmd},
+		Exec: func(ctx context.Context, args []string) error {
+			return fmt.Errorf("fts requires a subcommand: rebuild or check")
+		},
+	}
+
+	checkFlags := ff.NewFlagSet("check").SetParent(rootFlags)
+	dbPathCheck := checkFlags.StringLong("db", DefaultDBPath, "SQLite database path")
+	checkStale := checkFlags.StringLong("stale", "", "Fail if a source has had no new commands in this long, e.g. 7d")
+	checkQuiet := checkFlags.BoolLong("quiet", "Print nothing when every check passes")
+	checkCmd := &ff.Command{
+		Name:      "check",
+		Usage:     "zist check [--db PATH] [--quiet] --stale AGE",
+		ShortHelp: "Exit non-zero if a source has gone quiet, for cron jobs and health checks",
+		Flags:     checkFlags,
+		Exec: func(ctx context.Context, args []string) error {
+			if *checkStale == "" {
+				return fmt.Errorf("nothing to check, use --stale AGE")
+			}
+			return runCheckStale(ctx, *dbPathCheck, *checkStale, *checkQuiet, os.Stdout)
+		},
+	}
+
+	promptFlags := ff.NewFlagSet("prompt-segment").SetParent(rootFlags)
+	dbPathPrompt := promptFlags.StringLong("db", DefaultDBPath, "SQLite database path")
+	promptPwd := promptFlags.StringLong("pwd", "", "Directory whose project is counted (default: the current directory)")
+	promptFormat := promptFlags.StringLong("format", DefaultPromptFormat, "Text to print, with {today}, {week}, {total}, {project}, {project_today}, {project_week} and {project_total} filled in")
+	promptMaxAge := promptFlags.DurationLong("max-age", DefaultPromptMaxAge, "Show cached counts younger than this without counting again (0 to always count)")
+	promptBudget := promptFlags.DurationLong("budget", DefaultPromptBudget, "Show the last cached counts if counting takes longer than this")
+	promptCachePath := promptFlags.StringLong("cache", DefaultPromptCachePath, "File the counts are cached in between prompts")
+	promptCmd := &ff.Command{
+		Name:      "prompt-segment",
+		Usage:     "zist prompt-segment [--db PATH] [--pwd DIR] [--format TEXT] [--max-age DURATION] [--budget DURATION] [--cache FILE]",
+		ShortHelp: "Print a cached count of commands for starship, powerlevel10k and other prompts",
+		Flags:     promptFlags,
+		Exec: func(ctx context.Context, args []string) error {
+			dir := *promptPwd
+			if dir == "" {
+				dir, _ = os.Getwd()
+			}
+			return runPromptSegment(ctx, PromptSegmentRequest{
+				DBPath:    *dbPathPrompt,
+				Dir:       dir,
+				Format:    *promptFormat,
+				MaxAge:    *promptMaxAge,
+				Budget:    *promptBudget,
+				CachePath: *promptCachePath,
+			}, os.Stdout)
+		},
+	}
+
+	statusFlags := ff.NewFlagSet("status").SetParent(rootFlags)
+	dbPathStatus := statusFlags.StringLong("db", DefaultDBPath, "SQLite database path")
+	statusRCFile := statusFlags.StringLong("rc-file", "", "zsh rc file to look for the integration in (default: .zshrc in $ZDOTDIR or home)")
+	statusBackend := statusFlags.StringLong("llm-backend", "", "LLM API flavour: "+strings.Join(llm.Backends(), ", ")+" (default: openai)")
+	statusLLMURL := statusFlags.StringLong("llm-api-url", "", "LLM API endpoint")
+	statusModel := statusFlags.StringLong("model", "", "Model name")
+	statusKey := statusFlags.StringLong("key", "", "API key")
+	statusMaxSize := statusFlags.StringLong("max-size", "", "Warn if the database holds more than this, as collect's --max-size")
+	statusCmd := &ff.Command{
+		Name:      "status",
+		Usage:     "zist status [--db PATH] [--rc-file PATH] [--max-size SIZE]",
+		ShortHelp: "Show whether the integration is installed, the database, collects, sync and the wizard LLM",
+		Flags:     statusFlags,
+		Exec: func(ctx context.Context, args []string) error {
+			rcPath, err := rcFilePath(*statusRCFile)
+			if err != nil {
+				return err
+			}
+			maxSize, err := parseSize(*statusMaxSize)
+			if err != nil {
+				return err
+			}
+			llmConfig := llm.Config{Backend: *statusBackend, BaseURL: *statusLLMURL, Model: *statusModel, APIKey: *statusKey}
+			st := GetStatus(ctx, *dbPathStatus, rcPath, llmConfig)
+			st.MaxSize = maxSize
+			return writeStatus(os.Stdout, st)
+		},
+	}
+
+	pluginsCmd := &ff.Command{
+		Name:      "plugins",
+		Usage:     "zist plugins",
+		ShortHelp: "List plugins: zist-NAME executables on PATH, run as zist NAME",
+		Flags:     ff.NewFlagSet("plugins").SetParent(rootFlags),
+		Exec: func(ctx context.Context, args []string) error {
+			return runPlugins(os.Stdout)
+		},
+	}
+
+	devgenFlags := ff.NewFlagSet("devgen").SetParent(rootFlags)
+	dbPathDevgen := devgenFlags.StringLong("db", "", "New SQLite database to fill (required, never your real one)")
+	devgenRows := devgenFlags.IntLong("rows", 100000, "Commands to generate")
+	devgenSeed := devgenFlags.IntLong("seed", 1, "Random seed; the same seed and flags give the same database")
+	devgenSources := devgenFlags.IntLong("sources", 4, fmt.Sprintf("Machines to spread the history over (1-%d)", len(fixtureHosts)))
+	devgenDays := devgenFlags.IntLong("days", 730, "Days of history, ending on "+DefaultFixtureEnd.Format("2006-01-02"))
+	devgenCmd := &ff.Command{
+		Name:      "devgen",
+		Usage:     "zist devgen --db PATH [--rows N] [--seed N] [--sources N] [--days N]",
+		ShortHelp: "Generate a synthetic history database for benchmarks and bug reports (development)",
+		Flags:     devgenFlags,
+		Exec: func(ctx context.Context, args []string) error {
+			if *dbPathDevgen == "" {
+				return fmt.Errorf("--db is required")
+			}
+			return runDevgen(ctx, *dbPathDevgen, FixtureOptions{
+				Rows:    *devgenRows,
+				Seed:    uint64(*devgenSeed),
+				Sources: *devgenSources,
+				Days:    *devgenDays,
+			})
+		},
+	}
+
+	embedFlags := ff.NewFlagSet("embed").SetParent(rootFlags)
+	dbPathEmbed := embedFlags.StringLong("db", DefaultDBPath, "SQLite database path")
+	embedPause := embedFlags.DurationLong("pause", DefaultBackfillPause, "Rest between pages of history, to stay out of the way")
+	embedRestart := embedFlags.BoolLong("restart", "Scan all history again instead of resuming")
+	embedDetach := embedFlags.BoolLong("detach", "Run in the background, logging to the database path plus .embed.log")
+	embedReindex := embedFlags.BoolLong("reindex", "Rebuild the vector index afterwards even if it is up to date")
+	embedStatus := embedFlags.BoolLong("status", "Show how far the backfill got and exit")
+	embedQuiet := embedFlags.BoolLong("quiet", "Only print errors")
+	embedCmd := &ff.Command{
+		Name:      "embed",
+		Usage:     "zist embed [--db PATH] [--pause DURATION] [--restart] [--reindex] [--detach] [--quiet] | --status",
+		ShortHelp: "Embed commands collected before --embed-model was set, at low priority and resumably",
+		Flags:     embedFlags,
+		Exec: func(ctx context.Context, args []string) error {
+			if *embedStatus {
+				return runEmbedStatus(ctx, *dbPathEmbed, os.Stdout)
+			}
+			return runEmbed(ctx, EmbedRequest{
+				DBPath:  *dbPathEmbed,
+				Pause:   *embedPause,
+				Restart: *embedRestart,
+				Reindex: *embedReindex,
+				Detach:  *embedDetach,
+				Quiet:   *embedQuiet,
+			})
+		},
+	}
+
+	devbenchFlags := ff.NewFlagSet("devbench").SetParent(rootFlags)
+	dbPathDevbench := devbenchFlags.StringLong("db", "", "Database to measure, e.g. one made by devgen (required)")
+	devbenchQueries := devbenchFlags.StringLong("queries", "", "JSON file of {\"query\", \"relevant\": REGEXP} cases (default: built-in cases for devgen fixtures)")
+	devbenchK := devbenchFlags.IntLong("k", DefaultRecallK, "Results per query to measure recall over")
+	devbenchCmd := &ff.Command{
+		Name:      "devbench",
+		Usage:     "zist devbench --db PATH [--queries FILE] [--k N]",
+		ShortHelp: "Compare recall of keyword, semantic and hybrid history search on labelled queries (development)",
+		Flags:     devbenchFlags,
+		Exec: func(ctx context.Context, args []string) error {
+			if *dbPathDevbench == "" {
+				return fmt.Errorf("--db is required")
+			}
+			return runDevbench(ctx, *dbPathDevbench, *devbenchQueries, *devbenchK)
+		},
+	}
+
+	sourcesFlags := ff.NewFlagSet("sources").SetParent(rootFlags)
+	dbPathSources := sourcesFlags.StringLong("db", DefaultDBPath, "SQLite database path")
+	sourcesNoWait := sourcesFlags.BoolLong("no-wait", "Fail instead of waiting while another zist writes to the DB")
+	sourcesListCmd := &ff.Command{
+		Name:      "list",
+		Usage:     "zist sources list [--db PATH]",
+		ShortHelp: "List sources with their command counts and first/last seen",
+		Flags:     ff.NewFlagSet("list").SetParent(sourcesFlags),
+		Exec: func(ctx context.Context, args []string) error {
+			return runSourcesList(ctx, *dbPathSources, os.Stdout)
+		},
+	}
+	sourcesRenameCmd := &ff.Command{
+		Name:      "rename",
+		Usage:     "zist sources rename [--db PATH] [--no-wait] FROM TO",
+		ShortHelp: "Move a source's commands to a new name, e.g. after moving a history file",
+		Flags:     ff.NewFlagSet("rename").SetParent(sourcesFlags),
+		Exec: func(ctx context.Context, args []string) error {
+			if len(args) != 2 {
+				return fmt.Errorf("old and new source names are required")
+			}
+			return runSourcesRename(ctx, *dbPathSources, args[0], args[1], *sourcesNoWait)
+		},
+	}
+	sourcesForgetCmd := &ff.Command{
+		Name:      "forget",
+		Usage:     "zist sources forget [--db PATH] [--no-wait] SOURCE",
+		ShortHelp: "Delete every command collected from a source",
+		Flags:     ff.NewFlagSet("forget").SetParent(sourcesFlags),
+		Exec: func(ctx context.Context, args []string) error {
+			if len(args) != 1 {
+				return fmt.Errorf("source name is required")
+			}
+			return runSourcesForget(ctx, *dbPathSources, args[0], *sourcesNoWait)
+		},
+	}
+	sourcesOffsetCmd := &ff.Command{
+		Name:      "offset",
+		Usage:     "zist sources offset [--db PATH] [--no-wait] -- SOURCE DURATION",
+		ShortHelp: "Correct the timestamps of a source whose clock is wrong, e.g. -3h (0 removes the correction)",
+		Flags:     ff.NewFlagSet("offset").SetParent(sourcesFlags),
+		Exec: func(ctx context.Context, args []string) error {
+			if len(args) != 2 {
+				return fmt.Errorf("source and offset are required")
+			}
+			offset, err := time.ParseDuration(args[1])
+			if err != nil {
+				return fmt.Errorf("invalid offset %q: %w", args[1], err)
+			}
+			return runSourcesOffset(ctx, *dbPathSources, args[0], offset, *sourcesNoWait)
+		},
+	}
+	sourcesCmd := &ff.Command{
+		Name:        "sources",
+		Usage:       "zist sources <list|rename|forget|offset> [--db PATH]",
+		ShortHelp:   "List, rename, forget or fix the clock of the sources history was collected from",
+		Flags:       sourcesFlags,
+		Subcommands: []*ff.Command{sourcesListCmd, sourcesRenameCmd, sourcesForgetCmd, sourcesOffsetCmd},
+		Exec: func(ctx context.Context, args []string) error {
+			return fmt.Errorf("sources requires a subcommand: list, rename, forget or offset")
+		},
+	}
+
+	wizardFlags := ff.NewFlagSet("wizard").SetParent(rootFlags)
+	wizardQuery := wizardFlags.StringLong("query", "q", "")
+	wizardPlan := wizardFlags.StringLong("plan", "", "Generate an ordered list of commands for a task, run them, and save the plan as a snippet")
+	wizardWarm := wizardFlags.BoolLong("warm", "Send a tiny request so the LLM server loads the model into memory, then exit")
+	wizardGhost := wizardFlags.BoolLong("ghost", "Print a one-line suggestion for the ghost text preview, or nothing, also for risky ones (asks ZIST_SERVER on a cache miss when set)")
+	wizardCache := wizardFlags.StringLong("cache", "", "Cache a query→command mapping (format: query)")
+	wizardCacheCmd := wizardFlags.StringLong("cache-command", "", "Command to cache (use with --cache)")
+	wizardListCache := wizardFlags.BoolLong("list-cache", "List cached query→command mappings")
+	wizardClearCache := wizardFlags.BoolLong("clear-cache", "Clear all cached mappings and LLM responses")
+	wizardExportCache := wizardFlags.StringLong("export-cache", "", "Write all cached mappings to FILE as JSON (- for stdout)")
+	wizardImportCache := wizardFlags.StringLong("import-cache", "", "Merge cached mappings from a JSON FILE written by --export-cache (- for stdin)")
+	wizardPWD := wizardFlags.StringLong("pwd", "", "Current working directory (default: $PWD)")
+	wizardNoDirContext := wizardFlags.BoolLong("no-dir-context", "Don't send the names of files in --pwd to the LLM (or ZIST_WIZARD_DIR_CONTEXT=0)")
+	wizardEnvHints := wizardFlags.StringListLong("env-hints", "Environment variable the LLM may see: NAME, NAME:set or NAME:base (repeatable, replaces the defaults)")
+	wizardExamples := wizardFlags.IntLong("examples", DefaultWizardExamples, "Show the LLM this many of your most used cached mappings as examples of your style (0 disables)")
+	wizardNoEnvHints := wizardFlags.BoolLong("no-env-hints", "Don't tell the LLM about any environment variables")
+	wizardProjectCache := wizardFlags.BoolLong("project-cache", "Key cached mappings by the project root of --pwd (or ZIST_WIZARD_PROJECT_CACHE=1)")
+	wizardBackend := wizardFlags.StringLong("llm-backend", "", "LLM API flavour: "+strings.Join(llm.Backends(), ", ")+" (default: openai)")
+	wizardOllamaURL := wizardFlags.StringLong("llm-api-url", "", "LLM API endpoint")
+	wizardModel := wizardFlags.StringLong("model", "", "Model name")
+	wizardKey := wizardFlags.StringLong("key", "", "API key")
+	wizardTimeout := wizardFlags.DurationLong("timeout", 30*time.Second, "LLM timeout")
+	wizardMaxAttempts := wizardFlags.IntLong("max-attempts", DefaultRetryPolicy().MaxAttempts, "Tries per LLM request on rate limits, server errors and timeouts")
+	wizardCooldown := wizardFlags.DurationLong("circuit-cooldown", DefaultRetryPolicy().Cooldown, "Pause an LLM endpoint this long after repeated failures (0 disables)")
+	wizardFallbackURL := wizardFlags.StringLong("fallback-llm-api-url", "", "OpenAI-compatible endpoint to use when the main one fails (overridden by ZIST_LLM_FALLBACK_API_URL)")
+	wizardFallbackKey := wizardFlags.StringLong("fallback-key", "", "API key for --fallback-llm-api-url (overridden by ZIST_LLM_FALLBACK_API_KEY)")
+	wizardLLMCacheTTL := wizardFlags.DurationLong("llm-cache-ttl", DefaultLLMCacheTTL, "Reuse LLM responses to identical prompts for this long (0 disables)")
+	wizardDBPath := wizardFlags.StringLong("db", DefaultDBPath, "SQLite database path")
+	wizardOffline := wizardFlags.BoolLong("offline", "Answer from the cache and history only, never calling the LLM or server")
+	wizardJSON := wizardFlags.BoolLong("json", "Print the command with its source and safety warnings as one line of JSON")
+	wizardCmd := &ff.Command{
+		Name:      "wizard",
+		Usage:     "zist wizard --query 'natural language' [--json] [--offline] | --plan 'task' | --export-cache FILE | --import-cache FILE",
+		ShortHelp: "Generate shell commands from natural language",
+		Flags:     wizardFlags,
+		Exec: func(ctx context.Context, args []string) error {
+			if *wizardExportCache != "" {
+				return runWizardExportCache(ctx, *wizardDBPath, *wizardExportCache)
+			}
+			if *wizardImportCache != "" {
+				return runWizardImportCache(ctx, *wizardDBPath, *wizardImportCache)
+			}
+			llmConfig, err := llmSettings(llm.Config{
+				Backend:     *wizardBackend,
+				BaseURL:     *wizardOllamaURL,
+				Model:       *wizardModel,
+				APIKey:      *wizardKey,
+				Timeout:     *wizardTimeout,
+				MaxTokens:   500,
+				Temperature: 0.3,
+			})
+			if err != nil {
+				return err
+			}
+			if *wizardWarm {
+				if *wizardOffline {
+					return nil
+				}
+				return runWizardWarm(ctx, llmConfig)
+			}
+			fallback := fallbackLLMConfig(*wizardFallbackURL, *wizardFallbackKey)
+			policy := DefaultRetryPolicy()
+			policy.MaxAttempts = *wizardMaxAttempts
+			policy.Cooldown = *wizardCooldown
+			projectCache := *wizardProjectCache
+			if !projectCache {
+				projectCache, _ = strconv.ParseBool(os.Getenv("ZIST_WIZARD_PROJECT_CACHE"))
+			}
+			listDir := !*wizardNoDirContext
+			if v, err := strconv.ParseBool(os.Getenv("ZIST_WIZARD_DIR_CONTEXT")); err == nil && !v {
+				listDir = false
+			}
+			var envHints []string
+			if !*wizardNoEnvHints {
+				allowlist := *wizardEnvHints
+				if len(allowlist) == 0 {
+					allowlist = DefaultEnvHints
+				}
+				envHints = EnvHints(allowlist, os.LookupEnv)
+			}
+			return runWizard(ctx, WizardOptions{
+				DBPath:       *wizardDBPath,
+				Query:        *wizardQuery,
+				Plan:         *wizardPlan,
+				PWD:          *wizardPWD,
+				LLM:          llmConfig,
+				LLMCacheTTL:  *wizardLLMCacheTTL,
+				Retry:        policy,
+				Fallback:     fallback,
+				Ghost:        *wizardGhost,
+				CacheQuery:   *wizardCache,
+				CacheCmd:     *wizardCacheCmd,
+				ListCache:    *wizardListCache,
+				ClearCache:   *wizardClearCache,
+				ProjectCache: projectCache,
+				ListDir:      listDir,
+				EnvHints:     envHints,
+				Examples:     *wizardExamples,
+				Offline:      *wizardOffline,
+				JSON:         *wizardJSON,
+			})
+		},
+	}
+
+	askFlags := ff.NewFlagSet("ask").SetParent(rootFlags)
+	dbPathAsk := askFlags.StringLong("db", DefaultDBPath, "SQLite database path")
+	askSince := askFlags.StringLong("since", "", "Only look at commands after this date (YYYY-MM-DD, YYYY-MM-DD HH:MM:SS or -N[hdw])")
+	askUntil := askFlags.StringLong("until", "", "Only look at commands before this date (YYYY-MM-DD, YYYY-MM-DD HH:MM:SS or -N[hdw])")
+	askRows := askFlags.IntLong("rows", DefaultAskRows, "Distinct commands from history to show the LLM")
+	askBackend := askFlags.StringLong("llm-backend", "", "LLM API flavour: "+strings.Join(llm.Backends(), ", ")+" (default: openai)")
+	askLLMURL := askFlags.StringLong("llm-api-url", "", "LLM API endpoint")
+	askModel := askFlags.StringLong("model", "", "Model name")
+	askKey := askFlags.StringLong("key", "", "API key")
+	askTimeout := askFlags.DurationLong("timeout", 60*time.Second, "LLM timeout")
+	askFallbackURL := askFlags.StringLong("fallback-llm-api-url", "", "OpenAI-compatible endpoint to use when the main one fails (overridden by ZIST_LLM_FALLBACK_API_URL)")
+	askFallbackKey := askFlags.StringLong("fallback-key", "", "API key for --fallback-llm-api-url (overridden by ZIST_LLM_FALLBACK_API_KEY)")
+	askCmd := &ff.Command{
+		Name:      "ask",
+		Usage:     "zist ask [--db PATH] [--since DATE] [--until DATE] [--rows N] 'QUESTION'",
+		ShortHelp: "Answer a question about past activity from your history, citing the commands it is based on",
+		Flags:     askFlags,
+		Exec: func(ctx context.Context, args []string) error {
+			if len(args) == 0 {
+				return fmt.Errorf("question is required")
+			}
+			llmConfig, err := llmSettings(llm.Config{
+				Backend:     *askBackend,
+				BaseURL:     *askLLMURL,
+				Model:       *askModel,
+				APIKey:      *askKey,
+				Timeout:     *askTimeout,
+				MaxTokens:   400,
+				Temperature: 0.2,
+			})
+			if err != nil {
+				return err
+			}
+			return runAsk(ctx, *dbPathAsk, strings.Join(args, " "), *askSince, *askUntil, *askRows,
+				llmConfig, fallbackLLMConfig(*askFallbackURL, *askFallbackKey), os.Stdout)
+		},
+	}
+
+	reportFlags := ff.NewFlagSet("report").SetParent(rootFlags)
+	dbPathReport := reportFlags.StringLong("db", DefaultDBPath, "SQLite database path")
+	workflowsFlags := ff.NewFlagSet("workflows").SetParent(reportFlags)
+	workflowsSince := workflowsFlags.StringLong("since", "", "Only look at commands after this date (YYYY-MM-DD, YYYY-MM-DD HH:MM:SS or -N[hdw])")
+	workflowsUntil := workflowsFlags.StringLong("until", "", "Only look at commands before this date (YYYY-MM-DD, YYYY-MM-DD HH:MM:SS or -N[hdw])")
+	workflowsMinCount := workflowsFlags.IntLong("min-count", DefaultWorkflowMinCount, "Times a command or run of commands must repeat to be suggested")
+	workflowsMinLength := workflowsFlags.IntLong("min-length", DefaultWorkflowMinLength, "Shortest single command, in bytes, to suggest an alias for")
+	workflowsLimit := workflowsFlags.IntLong("limit", DefaultWorkflowLimit, "Maximum number of suggestions")
+	workflowsJSON := workflowsFlags.BoolLong("json", "Print the suggestions as JSON")
+	workflowsLLM := workflowsFlags.BoolLong("llm", "Ask the LLM to name and describe the suggestions")
+	workflowsBackend := workflowsFlags.StringLong("llm-backend", "", "LLM API flavour: "+strings.Join(llm.Backends(), ", ")+" (default: openai)")
+	workflowsLLMURL := workflowsFlags.StringLong("llm-api-url", "", "LLM API endpoint")
+	workflowsModel := workflowsFlags.StringLong("model", "", "Model name")
+	workflowsKey := workflowsFlags.StringLong("key", "", "API key")
+	workflowsTimeout := workflowsFlags.DurationLong("timeout", 60*time.Second, "LLM timeout")
+	reportWorkflowsCmd := &ff.Command{
+		Name:      "workflows",
+		Usage:     "zist report workflows [--db PATH] [--since DATE] [--until DATE] [--min-count N] [--min-length N] [--limit N] [--json] [--llm]",
+		ShortHelp: "Suggest aliases and functions for what you type again and again",
+		Flags:     workflowsFlags,
+		Exec: func(ctx context.Context, args []string) error {
+			req := WorkflowsRequest{
+				DBPath:    *dbPathReport,
+				Since:     *workflowsSince,
+				Until:     *workflowsUntil,
+				MinCount:  *workflowsMinCount,
+				MinLength: *workflowsMinLength,
+				Limit:     *workflowsLimit,
+				JSON:      *workflowsJSON,
+			}
+			if *workflowsLLM {
+				llmConfig, err := llmSettings(llm.Config{
+					Backend:     *workflowsBackend,
+					BaseURL:     *workflowsLLMURL,
+					Model:       *workflowsModel,
+					APIKey:      *workflowsKey,
+					Timeout:     *workflowsTimeout,
+					MaxTokens:   600,
+					Temperature: 0.3,
+				})
+				if err != nil {
+					return err
+				}
+				req.LLM = &llmConfig
+			}
+			return runReportWorkflows(ctx, req, os.Stdout)
+		},
+	}
+	reportCmd := &ff.Command{
+		Name:        "report",
+		Usage:       "zist report <workflows> [--db PATH]",
+		ShortHelp:   "Reports mined from history",
+		Flags:       reportFlags,
+		Subcommands: []*ff.Command{reportWorkflowsCmd},
+		Exec: func(ctx context.Context, args []string) error {
+			return fmt.Errorf("report requires a subcommand: workflows")
+		},
+	}
+
+	var rootCmd *ff.Command
+
+	rootCmd = &ff.Command{
+		Name:  "zist",
+		Usage: "zist [FLAGS] SUBCOMMAND ...",
+		ShortHelp: "Local ZSH history aggregation tool. " +
+			"Reads commands from multiple ZSH history files, " +
+			"aggregates them into a local SQLite database, and provides fast search.",
+		Flags:       rootFlags,
+		Subcommands: []*ff.Command{collectCmd, daemonCmd, recordCmd, searchCmd, prefixCmd, quickCmd, nextCmd, predictCmd, notFoundCmd, refineCmd, noteCmd, linkCmd, timelineCmd, replayCmd, sequenceCmd, showCmd, shareCmd, exportCmd, snippetCmd, serveCmd, syncCmd, archiveCmd, statsCmd, promptCmd, statusCmd, reportCmd, checkCmd, sourcesCmd, ftsCmd, embedCmd, wizardCmd, askCmd, installCmd, uninstallCmd, migrateCmd, pluginsCmd, devgenCmd, devbenchCmd},
+		Exec: func(ctx context.Context, args []string) error {
+			if len(args) > 0 {
+				return fmt.Errorf("unknown command %q, and no %s%s plugin on PATH", args[0], pluginPrefix, args[0])
+			}
+			return fmt.Errorf("no subcommand provided")
+		},
+	}
+
+	return rootCmd, globals
+}
+
+// globalFlags are root flags that set up every command, and --help and
+// --version
+type globalFlags struct {
+	help              *bool
+	version           *bool
+	profileCPU        *string
+	profileMem        *string
+	timings           *bool
+	cacheStopwords    *[]string
+	cacheSingular     *bool
+	keywordStopwords  *[]string
+	maxCommandLength  *int
+	onCollect         *string
+	onWizardGenerate  *string
+	onSearchSelect    *string
+	picker            *string
+	plain             *bool
+	timeFormat        *string
+	sudo              *string
+	sudoPrograms      *[]string
+	embedModel        *string
+	embedBackend      *string
+	embedURL          *string
+	embedKey          *string
+	sqlitePragmas     *[]string
+	sqliteBulkPragmas *[]string
+}
+
+// parseAndRun runs the command line, applying the global flags once they
+// are parsed
+func parseAndRun(rootCmd *ff.Command, globals globalFlags) error {
+	if err := rootCmd.Parse(os.Args[1:], parseOptions()...); err != nil {
+		return err
+	}
+	if len(*globals.cacheStopwords) > 0 {
+		cacheNormalizer.Stopwords = nil
+		for _, word := range *globals.cacheStopwords {
+			if word != "" {
+				cacheNormalizer.Stopwords = append(cacheNormalizer.Stopwords, strings.ToLower(word))
+			}
+		}
+	}
+	cacheNormalizer.Singularize = *globals.cacheSingular
+	keywordStopwords = stopwordSet(DefaultKeywordStopwords, *globals.keywordStopwords)
+	maxCommandLength = *globals.maxCommandLength
+	hooks[HookCollect] = *globals.onCollect
+	hooks[HookWizardGenerate] = *globals.onWizardGenerate
+	hooks[HookSearchSelect] = *globals.onSearchSelect
+	pickerChoice = *globals.picker
+	layout, err := parseTimeFormat(*globals.timeFormat)
+	if err != nil {
+		return err
+	}
+	timeLayout = layout
+	if sudoRules, err = ParseSudoRules(*globals.sudo, *globals.sudoPrograms); err != nil {
+		return err
+	}
+	plainOutput = *globals.plain || os.Getenv("TERM") == "dumb"
+	if sqlitePragmas, err = ParsePragmas("sqlite-pragma", DefaultPragmas, *globals.sqlitePragmas); err != nil {
+		return err
+	}
+	if sqliteBulkPragmas, err = ParsePragmas("sqlite-bulk-pragma", BulkPragmas, *globals.sqliteBulkPragmas); err != nil {
+		return err
+	}
+	embedConfig = llm.Config{Backend: *globals.embedBackend, BaseURL: *globals.embedURL, APIKey: *globals.embedKey, Model: *globals.embedModel}
+	if *globals.timings {
+		timings = NewTimings()
+		defer timings.Write(os.Stderr)
+	}
+	stop, err := StartProfile(*globals.profileCPU, *globals.profileMem)
+	if err != nil {
+		return err
+	}
+	if err := rootCmd.Run(context.Background()); err != nil {
+		stop()
+		return err
+	}
+	return stop()
+}
+
+func expandHistoryPaths(paths []string) ([]string, error) {
+	var files []string
+
+	for _, path := range paths {
+		if path == StdinHistory {
+			files = append(files, path)
+			continue
+		}
+		fileInfo, err := os.Stat(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to stat %s: %w", path, err)
+		}
+
+		if fileInfo.IsDir() {
+			// Recursively walk the directory tree
+			err := filepath.WalkDir(path, func(p string, d os.DirEntry, err error) error {
+				if err != nil {
+					return err
+				}
+				if !d.IsDir() && (strings.HasSuffix(d.Name(), "zsh_history") || strings.HasSuffix(d.Name(), "fish_history")) {
+					files = append(files, p)
+				}
+				return nil
+			})
+			if err != nil {
+				return nil, fmt.Errorf("failed to walk directory %s: %w", path, err)
+			}
+		} else {
+			files = append(files, path)
+		}
+	}
+
+	return files, nil
+}
+
+// CollectOptions controls how history files are ingested
+type CollectOptions struct {
+	Quiet             bool        // Suppress progress output
+	NormalizeEnv      bool        // Split leading VAR=value assignments into env_prefix
+	JoinContinuations bool        // Put lines continued with a trailing backslash back on one line
+	NoWait            bool        // Skip instead of waiting while another zist writes
+	Diff              bool        // Report new, duplicate and conflicting rows instead of writing
+	Full              bool        // Parse files from the start instead of where the last collect stopped
+	Bulk              bool        // Write with sqliteBulkPragmas instead of sqlitePragmas
+	Ignore            IgnoreRules // Commands to leave out
+	SourceLabel       string      // Source of every command, instead of the file's path
+	// MaxSize caps the bytes the database holds, 0 for no cap. Past it
+	// MaxSizeAction archives the oldest commands into ArchivePath or warns.
+	MaxSize       int64
+	MaxSizeAction string
+	ArchivePath   string
+	// Debounce skips the collect if one started less than this long ago
+	Debounce time.Duration
+	// FailOnError returns an error once the other files are collected if
+	// any couldn't be, rather than only logging the run as failed
+	FailOnError bool
+}
+
+// lockForWrite takes the write lock on dbPath, saying so on stderr if it
+// has to wait. With noWait the ErrLocked it returns says how to wait.
+func lockForWrite(ctx context.Context, dbPath string, noWait, quiet bool) (*WriteLock, error) {
+	lock, err := AcquireWriteLock(ctx, dbPath, !noWait, func() {
+		if !quiet {
+			fmt.Fprintf(os.Stderr, "Waiting for another zist writing to %s...\n", dbPath)
+		}
+	})
+	if errors.Is(err, ErrLocked) {
+		return nil, fmt.Errorf("%w %s (drop --no-wait to wait for it)", err, dbPath)
+	}
+	return lock, err
+}
+
+func runCollect(ctx context.Context, dbPath string, historyFiles []string, opts CollectOptions) error {
+
+	// Default to ~/.histories if no paths specified
+	if len(historyFiles) == 0 {
+		historyFiles = []string{expandTilde("~/.histories")}
+	}
+
+	expandedFiles, err := expandHistoryPaths(historyFiles)
+	if err != nil {
+		return err
+	}
+
+	if len(expandedFiles) == 0 {
+		return fmt.Errorf("no history files found")
+	}
+	if err := checkSourceLabel(expandedFiles, opts.SourceLabel); err != nil {
+		return err
+	}
+	timings.Mark("find history files")
+
+	if opts.Diff {
+		return runCollectDiff(ctx, dbPath, expandedFiles, opts, os.Stdout)
+	}
+
+	if opts.Debounce > 0 && RecentlyCollected(dbPath, opts.Debounce) {
+		if !opts.Quiet {
+			fmt.Printf("A collect started less than %s ago, skipping\n", opts.Debounce)
+		}
+		return nil
+	}
+	lock, err := lockForWrite(ctx, dbPath, opts.NoWait, opts.Quiet)
+	if errors.Is(err, ErrLocked) {
+		// Whatever is writing will be followed by the next prompt's collect
+		if !opts.Quiet {
+			fmt.Println("Another zist is writing to the database, skipping")
+		}
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer lock.Release()
+	if err := lock.Touch(); err != nil && !opts.Quiet {
+		fmt.Printf("Warning: %v\n", err)
+	}
+	timings.Mark("lock database")
+
+	if !opts.Quiet {
+		fmt.Printf("Collecting from %d file(s) into DB: %s\n", len(expandedFiles), dbPath)
+	}
+
+	db, err := InitDB(dbPath)
+	if err != nil {
+		return fmt.Errorf("failed to initialize database: %w", err)
+	}
+	defer db.Close()
+	if opts.Bulk {
+		// Pragmas are per connection
+		db.SetMaxOpenConns(1)
+		restore, err := ApplyPragmas(ctx, db, sqliteBulkPragmas)
+		if err != nil {
+			return err
+		}
+		defer restore()
+	}
+	timings.Mark("open database")
+
+	totalInserted := 0
+	totalIgnored := 0
+	var runs []CollectRun
+
+	for _, file := range expandedFiles {
+		started := time.Now()
+		run := CollectRun{Timestamp: float64(started.UnixNano()) / 1e9, Source: file}
+		if abs, err := filepath.Abs(file); err == nil {
+			run.Source = abs
+		}
+
+		// Only a history file on disk, stored under its own path, has a
+		// state to compare with the last collect
+		var state *SourceState
+		if opts.SourceLabel != "" {
+			run.Source = opts.SourceLabel
+		} else if !pipedHistory(file) {
+			state, err = checkSourceIntegrity(ctx, db, file, opts.Full, opts.Quiet)
+			if err != nil {
+				if !opts.Quiet {
+					fmt.Printf("Error checking %s: %v\n", file, err)
+				}
+				run.Error = err.Error()
+				runs = append(runs, run)
+				continue
+			}
+		}
+
+		parsed, inserted, ignored, err := collectFile(ctx, db, file, state, 500, opts)
+		run.Parsed, run.Inserted, run.Ignored = parsed, inserted, ignored
+		run.DurationMs = time.Since(started).Milliseconds()
+		if err != nil {
+			if !opts.Quiet {
+				fmt.Printf("Error collecting %s: %v\n", file, err)
+			}
+			run.Error = err.Error()
+			runs = append(runs, run)
+			continue
+		}
+		runs = append(runs, run)
+
+		if state != nil {
+			if err := SetSourceState(ctx, db, state); err != nil && !opts.Quiet {
+				fmt.Printf("Warning: could not record state of %s: %v\n", file, err)
+			}
+		}
+
+		if !opts.Quiet {
+			fmt.Printf("%s: %d parsed, %d new, %d skipped\n", file, parsed, inserted, ignored)
+		}
+
+		totalInserted += inserted
+		totalIgnored += ignored
+		timings.Mark("collect " + file)
+	}
+
+	if embedConfig.Model != "" && totalInserted > 0 {
+		embedded, err := embedCollected(ctx, db, totalInserted)
+		if err != nil && !opts.Quiet {
+			fmt.Printf("Warning: could not embed new commands: %v\n", err)
+		}
+		if embedded > 0 && !opts.Quiet {
+			fmt.Printf("Embedded %d new command(s) with %s\n", embedded, embedConfig.Model)
+		}
+		timings.Mark("embed new commands")
+	}
+
+	if totalInserted > 0 {
+		if _, err := TrainPredictor(ctx, db); err != nil && !opts.Quiet {
+			fmt.Printf("Warning: could not update predictions: %v\n", err)
+		}
+		timings.Mark("train predictor")
+	}
+
+	if err := LogCollectRuns(ctx, db, runs); err != nil && !opts.Quiet {
+		fmt.Printf("Warning: %v\n", err)
+	}
+	if opts.MaxSize > 0 {
+		if err := enforceMaxSize(ctx, db, dbPath, opts); err != nil && !opts.Quiet {
+			fmt.Printf("Warning: %v\n", err)
+		}
+		timings.Mark("enforce max size")
+	}
+	event := CollectEvent{Event: HookCollect, Inserted: totalInserted, Ignored: totalIgnored, Runs: runs}
+	if err := runHook(HookCollect, event); err != nil && !opts.Quiet {
+		fmt.Printf("Warning: %v\n", err)
+	}
+
+	if !opts.Quiet {
+		stats, err := GetDBStats(ctx, db)
+		if err != nil {
+			fmt.Printf("Warning: could not get DB stats: %v\n", err)
+		} else {
+			fmt.Printf("\nDatabase stats:\n")
+			fmt.Printf("  Total commands: %d\n", stats["total_commands"])
+			fmt.Printf("  Total sources: %d\n", stats["total_sources"])
+		}
+
+		skews, err := FutureSources(ctx, db, time.Now())
+		if err != nil {
+			fmt.Printf("Warning: could not check for clock skew: %v\n", err)
+		}
+		for _, skew := range skews {
+			fmt.Printf("Warning: %s\n", skew)
+		}
+
+		fmt.Printf("\nCollection complete: %d new, %d skipped\n", totalInserted, totalIgnored)
+		timings.Mark("database stats")
+	}
+	if opts.FailOnError {
+		for _, run := range runs {
+			if run.Error != "" {
+				return fmt.Errorf("failed to collect %s: %s", run.Source, run.Error)
+			}
+		}
+	}
+	return nil
+}
+
+// enforceMaxSize archives the oldest commands, or warns, once the database
+// holds more than opts.MaxSize. Archiving waits for no one: if another zist
+// is writing to the archive, the next collect tries again.
+func enforceMaxSize(ctx context.Context, db *sql.DB, dbPath string, opts CollectOptions) error {
+	used, err := DBUsedBytes(ctx, db)
+	if err != nil || used <= opts.MaxSize {
+		return err
+	}
+	if opts.MaxSizeAction == MaxSizeWarn {
+		if !opts.Quiet {
+			fmt.Printf("Warning: the database holds %s, over max-size %s; `zist archive` moves old commands out\n", formatSize(used), formatSize(opts.MaxSize))
+		}
+		return nil
+	}
+	if expandTilde(opts.ArchivePath) == expandTilde(dbPath) {
+		return fmt.Errorf("the archive database can't be the database itself (%s)", dbPath)
+	}
+
+	lock, err := lockForWrite(ctx, opts.ArchivePath, true, opts.Quiet)
+	if errors.Is(err, ErrLocked) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer lock.Release()
+	moved, err := ArchiveOldest(ctx, db, opts.ArchivePath, int64(maxSizeTarget*float64(opts.MaxSize)))
+	if err != nil || opts.Quiet {
+		return err
+	}
+	if moved > 0 {
+		fmt.Printf("Archived the %d oldest command(s) into %s to keep under max-size %s\n", moved, opts.ArchivePath, formatSize(opts.MaxSize))
+	}
+	if used, err := DBUsedBytes(ctx, db); err == nil && used > opts.MaxSize {
+		fmt.Printf("Warning: the database still holds %s, over max-size %s; `zist stats --storage` shows what takes the space\n", formatSize(used), formatSize(opts.MaxSize))
+	}
+	return nil
+}
+
+// embedCollected embeds the distinct commands among the inserted rows a
+// collect just added, up to DefaultCollectEmbeddings of them
+func embedCollected(ctx context.Context, db *sql.DB, inserted int) (int, error) {
+	e, config, err := newEmbedder(embedConfig)
+	if err != nil {
+		return 0, err
+	}
+	return EmbedNewCommands(ctx, db, e, config.Model, min(inserted, DefaultCollectEmbeddings))
+}
+
+// runCollectDiff parses history files and compares them with the database
+// without writing, so odd clocks or formats show up before they're stored
+func runCollectDiff(ctx context.Context, dbPath string, files []string, opts CollectOptions, w io.Writer) error {
+	db, err := InitDB(dbPath)
+	if err != nil {
+		return fmt.Errorf("failed to initialize database: %w", err)
+	}
+	defer db.Close()
+
+	offsets, err := GetClockOffsets(ctx, db)
+	if err != nil {
+		return err
+	}
+
+	var diffs [][]DiffBucket
+	for _, file := range files {
+		var commands []Command
+		err := parseCollectInput(file, 0, opts, func(cmd Command) error {
+			if opts.JoinContinuations {
+				cmd.Command = JoinContinuations(cmd.Command)
+			}
+			if opts.Ignore.Match(cmd.Command) {
+				return nil
+			}
+			if opts.NormalizeEnv {
+				cmd.EnvPrefix, cmd.Command = SplitEnvPrefix(cmd.Command)
+			}
+			commands = append(commands, cmd)
+			return nil
+		})
+		if err != nil {
+			return fmt.Errorf("failed to parse %s: %w", file, err)
+		}
+		ApplyClockOffsets(commands, offsets)
+		diff, err := DiffCommands(ctx, db, commands)
+		if err != nil {
+			return err
+		}
+		diffs = append(diffs, diff)
+	}
+	return writeDiff(w, MergeDiff(diffs...))
+}
+
+// checkSourceIntegrity compares a history file against its last collected
+// state. Files that shrank or were rewritten (HISTSIZE trims, manual edits)
+// are flagged and re-ingested from the start, as are all files with full;
+// others are parsed from where the last collect stopped.
+func checkSourceIntegrity(ctx context.Context, db *sql.DB, file string, full, quiet bool) (*SourceState, error) {
+	absPath, err := filepath.Abs(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get absolute path: %w", err)
+	}
+
+	prev, err := GetSourceState(ctx, db, absPath)
+	if err != nil {
+		return nil, err
+	}
+
+	cur, err := StatSource(absPath)
+	if err != nil {
+		return nil, err
+	}
+
+	rewritten, reason, err := DetectRewrite(prev, cur)
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case rewritten:
+		cur.RewrittenAt = float64(time.Now().Unix())
+		if !quiet {
+			fmt.Printf("%s: history file rewritten (%s), re-ingesting from start\n", file, reason)
+		}
+	case prev != nil:
+		cur.RewrittenAt = prev.RewrittenAt
+		if !full {
+			cur.Offset, cur.Subseconds = prev.Offset, prev.Subseconds
+		}
+	}
+
+	return cur, nil
+}
+
+// StdinHistory is the collect argument that reads history from stdin
+const StdinHistory = "-"
+
+// pipedHistory reports whether file is a stream rather than a history file
+// on disk: stdin, or a pipe such as <(ssh server cat .zsh_history), whose
+// path means nothing by the next collect
+func pipedHistory(file string) bool {
+	if file == StdinHistory {
+		return true
+	}
+	info, err := os.Stat(file)
+	return err == nil && !info.Mode().IsRegular()
+}
+
+// checkSourceLabel makes sure piped history is collected under a label,
+// and that a label names a single input
+func checkSourceLabel(files []string, label string) error {
+	if label != "" {
+		if len(files) != 1 {
+			return fmt.Errorf("--source-label names the commands of one input, not %d", len(files))
+		}
+		if strings.ContainsAny(label, "\t\n\x00") || strings.TrimSpace(label) != label {
+			return fmt.Errorf("invalid source label %q", label)
+		}
+		return nil
+	}
+	for _, file := range files {
+		if pipedHistory(file) {
+			return fmt.Errorf("%s is a pipe, not a history file: name its commands with --source-label, e.g. --source-label server:~/.zsh_history", file)
+		}
+	}
+	return nil
+}
+
+// parseCollectInput parses a history file from offset, or stdin for
+// StdinHistory, storing its commands under opts.SourceLabel when set
+func parseCollectInput(file string, offset int64, opts CollectOptions, fn func(Command) error) error {
+	if file == StdinHistory {
+		return ParseHistoryReader(os.Stdin, opts.SourceLabel, fn)
+	}
+	if opts.SourceLabel == "" {
+		return ParseHistoryStreamFrom(file, offset, fn)
+	}
+	return ParseHistoryStreamFrom(file, offset, func(cmd Command) error {
+		cmd.Source = opts.SourceLabel
+		return fn(cmd)
+	})
+}
+
+// collectFile streams a history file into the database, flushing every
+// batchSize commands so memory stays bounded regardless of file size.
+// With state, parsing starts at state.Offset, which is then moved up to
+// where the next collect should resume.
+func collectFile(ctx context.Context, db *sql.DB, file string, state *SourceState, batchSize int, opts CollectOptions) (int, int, int, error) {
+	offsets, err := GetClockOffsets(ctx, db)
+	if err != nil {
+		return 0, 0, 0, err
+	}
+
+	parsed, inserted, ignored := 0, 0, 0
+	batch := make([]Command, 0, batchSize)
+	// Numbering continues after the source's last stored command, so rows
+	// added by later collects sort after earlier ones
+	var seqBase int64 = -1
+
+	// The next collect resumes at the first command of the last second
+	// parsed. Parsing that whole second again gives its commands the same
+	// subsecond timestamps, so they're recognised as already stored, and
+	// picks up any command cut off by a shell still writing it.
+	var from int64
+	if state != nil {
+		from = state.Offset
+	}
+	resume, lastSecond := from, int64(-1)
+
+	// The parser numbers the commands sharing a second from 0, which from
+	// the resume point would give a command of a second that also has ones
+	// before it the same timestamp as one of those, so they're numbered
+	// again carrying on from the counts stored for the commands before it.
+	subsecond, sinceResume := newSubsecondCounter(), newSubsecondCounter()
+	var stored int64 = -1
+	if state != nil {
+		maps.Copy(subsecond, state.Subseconds)
+		for second := range state.Subseconds {
+			stored = max(stored, second)
+		}
+	}
+
+	flush := func() error {
+		n, skipped, err := InsertCommands(ctx, db, batch)
+		if err != nil {
+			return fmt.Errorf("failed to insert batch: %w", err)
+		}
+		inserted += n
+		ignored += skipped
+		batch = batch[:0]
+		return nil
+	}
+
+	err = parseCollectInput(file, from, opts, func(cmd Command) error {
+		parsed++
+		second := int64(cmd.Timestamp)
+		if second != lastSecond {
+			resume, lastSecond = cmd.Offset, second
+			clear(sinceResume)
+		}
+		if _, ok := subsecond[second]; !ok && second < stored-subsecondWindow {
+			// Older than the counts go back, so count what's stored instead
+			var err error
+			if subsecond[second], err = countSecond(ctx, db, cmd.Source, float64(second)+offsets[cmd.Source].Seconds()); err != nil {
+				return err
+			}
+		}
+		cmd.Timestamp = subsecond.next(second)
+		sinceResume.next(second)
+		if opts.JoinContinuations {
+			cmd.Command = JoinContinuations(cmd.Command)
+		}
+		if opts.Ignore.Match(cmd.Command) {
+			ignored++
+			return nil
+		}
+		if opts.NormalizeEnv {
+			cmd.EnvPrefix, cmd.Command = SplitEnvPrefix(cmd.Command)
+		}
+		cmd.Timestamp += offsets[cmd.Source].Seconds()
+		if seqBase < 0 {
+			var err error
+			if seqBase, err = MaxSeq(ctx, db, cmd.Source); err != nil {
+				return err
+			}
+		}
+		cmd.Seq += seqBase
+		batch = append(batch, cmd)
+		if len(batch) < batchSize {
+			return nil
+		}
+		return flush()
+	})
+	if err != nil {
+		return parsed, inserted, ignored, err
+	}
+
+	if err := flush(); err != nil {
+		return parsed, inserted, ignored, err
+	}
+
+	if state != nil {
+		state.Offset = resume
+		state.Subseconds = resumeSubseconds(subsecond, sinceResume)
+	}
+	return parsed, inserted, ignored, nil
+}
+
+// resumeSubseconds is SourceState.Subseconds for a resume point: counts,
+// the commands of each second parsed, less sinceResume, those parsed after
+// the resume point, back to subsecondWindow before the newest second
+func resumeSubseconds(counts, sinceResume subsecondCounter) map[int64]int {
+	var newest int64 = -1
+	for second, n := range counts {
+		if n > sinceResume[second] {
+			newest = max(newest, second)
+		}
+	}
+	resume := map[int64]int{}
+	for second, n := range counts {
+		if n -= sinceResume[second]; n > 0 && second >= newest-subsecondWindow {
+			resume[second] = n
+		}
+	}
+	return resume
+}
+
+// countSecond counts the commands of source stored in the second starting
+// at second
+func countSecond(ctx context.Context, db *sql.DB, source string, second float64) (int, error) {
+	var n int
+	if err := db.QueryRowContext(ctx, `SELECT COUNT(*) FROM commands WHERE source = ? AND timestamp >= ? AND timestamp < ?`,
+		source, second, second+1).Scan(&n); err != nil {
+		return 0, fmt.Errorf("failed to count commands: %w", err)
+	}
+	return n, nil
+}
+
+// runRecord stores cmd, as the shell integration calls it after every
+// command, waiting for any collect writing meanwhile
+func runRecord(ctx context.Context, dbPath string, cmd Command, normalizeEnv bool, ignore IgnoreRules) error {
+	if ignore.Match(cmd.Command) {
+		return nil
+	}
+	if normalizeEnv {
+		cmd.EnvPrefix, cmd.Command = SplitEnvPrefix(cmd.Command)
+	}
+	source, err := filepath.Abs(expandTilde(cmd.Source))
+	if err != nil {
+		return fmt.Errorf("failed to get absolute path: %w", err)
+	}
+	cmd.Source = source
+
+	lock, err := lockForWrite(ctx, dbPath, false, true)
+	if err != nil {
+		return err
+	}
+	defer lock.Release()
+
+	db, err := InitDB(dbPath)
+	if err != nil {
+		return fmt.Errorf("failed to initialize database: %w", err)
+	}
+	defer db.Close()
+
+	_, err = RecordCommand(ctx, db, cmd)
+	return err
+}
+
+func parseDateTime(s string) (float64, error) {
+	if s == "" {
+		return 0, nil
+	}
+
+	// Relative to now: -30d, -12h, -2w
+	if ago, ok := parseRelativeDuration(s); ok {
+		return float64(time.Now().Add(-ago).Unix()), nil
+	}
+
+	// Try full datetime format first
+	t, err := time.ParseInLocation(recordTimeLayout, s, time.Local)
+	if err == nil {
+		return float64(t.Unix()), nil
+	}
+
+	// Try date-only format (use start of day)
+	t, err = time.ParseInLocation("2006-01-02", s, time.Local)
+	if err == nil {
+		return float64(t.Unix()), nil
+	}
+
+	return 0, fmt.Errorf("invalid date format: %s (use YYYY-MM-DD, YYYY-MM-DD HH:MM:SS or -N[hdw])", s)
+}
+
+// parseRelativeDuration parses "-N" followed by h, d or w
+func parseRelativeDuration(s string) (time.Duration, bool) {
+	if len(s) < 3 || s[0] != '-' {
+		return 0, false
+	}
+
+	n, err := strconv.Atoi(s[1 : len(s)-1])
+	if err != nil || n < 0 {
+		return 0, false
+	}
+
+	switch s[len(s)-1] {
+	case 'h':
+		return time.Duration(n) * time.Hour, true
+	case 'd':
+		return time.Duration(n) * 24 * time.Hour, true
+	case 'w':
+		return time.Duration(n) * 7 * 24 * time.Hour, true
+	}
+	return 0, false
+}
+
+// SearchRequest holds the search subcommand's flags
+type SearchRequest struct {
+	DBPath        string
+	ArchivePath   string // Empty unless --include-archive
+	Query         string
+	Limit         int
+	Since         string
+	Until         string
+	Source        string
+	Sort          string // SortRelevance or SortTime
+	Exact         bool   // Match query words whole rather than as prefixes
+	CaseSensitive bool   // Query words must appear in the command in the same case
+	SaveAs        string // Save the request under this name before running it
+	Color         string // ColorAuto, ColorAlways or ColorNever
+	Semantic      bool   // Rank by similarity of meaning using embedConfig
+	Timeout       time.Duration
+}
+
+// DefaultSearchTimeout is how long a search may take before it gives up,
+// so a runaway query can't hang the Ctrl+X widget
+const DefaultSearchTimeout = 10 * time.Second
+
+func runSearch(ctx context.Context, req SearchRequest) error {
+	sinceTs, err := parseDateTime(req.Since)
+	if err != nil {
+		return err
+	}
+
+	untilTs, err := parseDateTime(req.Until)
+	if err != nil {
+		return err
+	}
+
+	// The picker draws on the terminal while stdout goes back to the shell widget
+	color, err := useColor(req.Color, os.Stderr)
+	if err != nil {
+		return err
+	}
+
+	if req.SaveAs != "" {
+		if err := saveSearchRequest(ctx, req); err != nil {
+			return err
+		}
+	}
+
+	state := &PickerState{
+		DBPath:      req.DBPath,
+		ArchivePath: req.ArchivePath,
+		Base: SearchOptions{
+			Query:          req.Query,
+			Limit:          req.Limit,
+			Since:          sinceTs,
+			Until:          untilTs,
+			Source:         req.Source,
+			IncludeArchive: req.ArchivePath != "",
+			Sort:           req.Sort,
+			Exact:          req.Exact,
+			CaseSensitive:  req.CaseSensitive,
+		},
+		Color:   color,
+		Timeout: req.Timeout,
+	}
+	if req.Semantic {
+		if req.Query == "" {
+			return fmt.Errorf("--semantic needs a QUERY")
+		}
+		_, config, err := newEmbedder(embedConfig)
+		if err != nil {
+			return err
+		}
+		state.Base.Semantic = true
+		state.Embed = &config
+	}
+
+	picker, err := newPicker(pickerChoice)
+	if err != nil {
+		return err
+	}
+
+	commands, err := searchWithState(ctx, state)
+	if err != nil {
+		return err
+	}
+
+	if len(commands) == 0 {
+		return nil
+	}
+
+	// Drill-down key bindings share filter state with `zist refine` through a temp file
+	stateFile, err := os.CreateTemp("", "zist-search-*.json")
+	if err != nil {
+		return fmt.Errorf("failed to create picker state: %w", err)
+	}
+	stateFile.Close()
+	defer os.Remove(stateFile.Name())
+
+	if err := state.Save(stateFile.Name()); err != nil {
+		return err
+	}
+
+	self, err := os.Executable()
+	if err != nil {
+		self = "zist"
+	}
+
+	opts := PickOptions{Header: pickerHeader(state), Color: color, Preview: true, FzfArgs: pickerBindings(self, stateFile.Name())}
+	if !drillDown(picker) {
+		opts.Header = "filters: " + state.Breadcrumb()
+	}
+	picked, err := picker.Pick(ctx, commands, opts)
+	timings.Mark("picker (includes your time picking)")
+	if err != nil || picked == nil {
+		return err
+	}
+
+	id, source := picked.ID, picked.Source
+	// The picker shows what was indexed, but the shell gets what was typed
+	command, err := pickedOverflow(ctx, req.DBPath, req.ArchivePath, picked)
+	if err != nil {
+		return err
+	}
+	if command == "" {
+		return nil
+	}
+	if sudoRules.Changes() {
+		if command, err = pickedWithSudo(ctx, req.DBPath, command); err != nil {
+			return err
+		}
+	}
+	fmt.Println(command)
+
+	event := SearchSelectEvent{Event: HookSearchSelect, Query: req.Query, Command: command, Source: source, ID: id}
+	if err := runHook(HookSearchSelect, event); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: %v\n", err)
+	}
+	return nil
+}
+
+// pickedWithSudo returns a picked command with sudo in front if sudoRules
+// call for it
+func pickedWithSudo(ctx context.Context, dbPath, command string) (string, error) {
+	db, err := InitDB(dbPath)
+	if err != nil {
+		return "", fmt.Errorf("failed to initialize database: %w", err)
+	}
+	defer db.Close()
+	return sudoRules.Apply(ctx, db, command)
+}
+
+// pickedOverflow returns the full text of a picked command that was
+// stored truncated, or its command if it wasn't. Picks without an ID are
+// from the archive at archivePath, if there is one.
+func pickedOverflow(ctx context.Context, dbPath, archivePath string, picked *PickedRecord) (string, error) {
+	if !strings.HasSuffix(picked.Command, truncatedMarker) || picked.ID == 0 && archivePath == "" {
+		return picked.Command, nil
+	}
+	path := dbPath
+	if picked.ID == 0 {
+		path = archivePath
+	}
+	db, err := InitDB(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to initialize database: %w", err)
+	}
+	defer db.Close()
+
+	var full string
+	if picked.ID > 0 {
+		full, err = OverflowCommand(ctx, db, picked.ID)
+	} else {
+		full, err = OverflowCommandByText(ctx, db, picked.Source, picked.Command)
+	}
+	if err != nil || full == "" {
+		return picked.Command, err
+	}
+	return full, nil
+}
+
+// applySaved fills in the request from a saved search, keeping any values
+// given explicitly on the command line
+func (r *SearchRequest) applySaved(s *SavedSearch, isSet func(name string) bool, archivePath string) {
+	if r.Query == "" {
+		r.Query = s.Query
+	}
+	if !isSet("limit") && s.Limit > 0 {
+		r.Limit = s.Limit
+	}
+	if !isSet("since") {
+		r.Since = s.Since
+	}
+	if !isSet("until") {
+		r.Until = s.Until
+	}
+	if !isSet("source") {
+		r.Source = s.Source
+	}
+	if r.ArchivePath == "" && s.IncludeArchive {
+		r.ArchivePath = archivePath
+	}
+}
+
+// loadSavedSearch applies the named saved search to the request
+func loadSavedSearch(ctx context.Context, req *SearchRequest, name string, isSet func(name string) bool, archivePath string) error {
+	db, err := InitDB(req.DBPath)
+	if err != nil {
+		return fmt.Errorf("failed to open database: %w", err)
+	}
+	defer db.Close()
+
+	saved, err := GetSavedSearch(ctx, db, name)
+	if err != nil {
+		return err
+	}
+	if saved == nil {
+		return fmt.Errorf("no saved search named %q (see zist search --list-saved)", name)
+	}
+
+	req.applySaved(saved, isSet, archivePath)
+	return nil
+}
+
+// saveSearchRequest stores the request's query and filters under req.SaveAs
+func saveSearchRequest(ctx context.Context, req SearchRequest) error {
+	db, err := InitDB(req.DBPath)
+	if err != nil {
+		return fmt.Errorf("failed to open database: %w", err)
+	}
+	defer db.Close()
+
+	return SaveSearch(ctx, db, SavedSearch{
+		Name:           req.SaveAs,
+		Query:          req.Query,
+		Source:         req.Source,
+		Since:          req.Since,
+		Until:          req.Until,
+		Limit:          req.Limit,
+		IncludeArchive: req.ArchivePath != "",
+	})
+}
+
+func runListSavedSearches(ctx context.Context, dbPath string, w io.Writer) error {
+	db, err := InitDB(dbPath)
+	if err != nil {
+		return fmt.Errorf("failed to open database: %w", err)
+	}
+	defer db.Close()
+
+	searches, err := ListSavedSearches(ctx, db)
+	if err != nil {
+		return err
+	}
+
+	for _, s := range searches {
+		fmt.Fprintf(w, "%s\t%s\n", s.Name, s.Describe())
+	}
+	return nil
+}
+
+// runRecordPick counts a picked command; commands no longer in history are
+// ignored, since the accept-line hook can't tell
+func runRecordPick(ctx context.Context, dbPath, command string) error {
+	db, err := InitDB(dbPath)
+	if err != nil {
+		return fmt.Errorf("failed to open database: %w", err)
+	}
+	defer db.Close()
+
+	_, err = RecordPick(ctx, db, command)
+	return err
+}
+
+func runDeleteSavedSearch(ctx context.Context, dbPath, name string) error {
+	db, err := InitDB(dbPath)
+	if err != nil {
+		return fmt.Errorf("failed to open database: %w", err)
+	}
+	defer db.Close()
+
+	deleted, err := DeleteSavedSearch(ctx, db, name)
+	if err != nil {
+		return err
+	}
+	if !deleted {
+		return fmt.Errorf("no saved search named %q", name)
+	}
+	return nil
+}
+
+// pickSavedSearch lets the user choose a saved search in the picker,
+// returning its name or "" if the picker was cancelled
+func pickSavedSearch(ctx context.Context, dbPath string) (string, error) {
+	db, err := InitDB(dbPath)
+	if err != nil {
+		return "", fmt.Errorf("failed to open database: %w", err)
+	}
+	searches, err := ListSavedSearches(ctx, db)
+	db.Close()
+	if err != nil {
+		return "", err
+	}
+	if len(searches) == 0 {
+		return "", fmt.Errorf("no saved searches yet (use zist search --save NAME)")
+	}
+
+	picker, err := newPicker(pickerChoice)
+	if err != nil {
+		return "", err
+	}
+	// The name goes in Source so it comes back from the picker
+	records := make([]SearchResult, len(searches))
+	for i, s := range searches {
+		records[i] = SearchResult{Source: s.Name, Badge: s.Name, Command: s.Describe()}
+	}
+	picked, err := picker.Pick(ctx, records, PickOptions{Header: "Saved searches", Ordered: true})
+	if err != nil || picked == nil {
+		return "", err
+	}
+	return picked.Source, nil
+}
+
+// Wizard mappings shown in search: only those accepted more than once, so
+// one-off requests don't crowd out history
+const (
+	minSearchWizardRuns    = 2
+	maxSearchWizardEntries = 20
+)
+
+// searchWithState runs the search described by picker state, giving up
+// after its timeout. The driver interrupts SQLite when the context ends, so
+// this stops a query mid-scan too.
+func searchWithState(ctx context.Context, state *PickerState) ([]SearchResult, error) {
+	if state.Timeout <= 0 {
+		return searchState(ctx, state)
+	}
+	ctx, cancel := context.WithTimeout(ctx, state.Timeout)
+	defer cancel()
+	results, err := searchState(ctx, state)
+	if err != nil && ctx.Err() == context.DeadlineExceeded {
+		return nil, fmt.Errorf("search took longer than --timeout %s; narrow it down or raise --timeout", state.Timeout)
+	}
+	return results, err
+}
+
+func searchState(ctx context.Context, state *PickerState) ([]SearchResult, error) {
+	db, err := InitDB(state.DBPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database: %w", err)
+	}
+	defer db.Close()
+	timings.Mark("open database")
+
+	opts := state.Options()
+	if opts.Semantic {
+		return semanticWithState(ctx, db, state, opts)
+	}
+	var commands []SearchResult
+	if state.ArchivePath != "" {
+		commands, err = SearchWithArchive(ctx, db, state.ArchivePath, opts)
+	} else {
+		commands, err = SearchCommands(ctx, db, opts)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to search: %w", err)
+	}
+	timings.Mark("search commands")
+
+	// Snippets and wizard mappings aren't tied to a source or time, so only
+	// list them unfiltered
+	if opts.Source == "" && opts.Since == 0 && opts.Until == 0 {
+		snippets, err := SearchSnippets(ctx, db, opts.Query)
+		if err != nil {
+			return nil, err
+		}
+		mappings, err := SearchWizardCache(ctx, db, opts.Query, minSearchWizardRuns, maxSearchWizardEntries)
+		if err != nil {
+			return nil, err
+		}
+		results := make([]SearchResult, 0, len(snippets)+len(mappings)+len(commands))
+		for _, s := range snippets {
+			results = append(results, s.SearchResult())
+		}
+		for _, m := range mappings {
+			results = append(results, m.SearchResult())
+		}
+		commands = append(results, commands...)
+		timings.Mark("search snippets and wizard mappings")
+	}
+
+	return commands, nil
+}
+
+// semanticWithState runs a semantic search with the embedding settings in
+// state. Snippets and wizard mappings have no vectors, so aren't listed.
+func semanticWithState(ctx context.Context, db *sql.DB, state *PickerState, opts SearchOptions) ([]SearchResult, error) {
+	if state.Embed == nil {
+		return nil, fmt.Errorf("picker state has no embedding model")
+	}
+	e, config, err := newEmbedder(*state.Embed)
+	if err != nil {
+		return nil, err
+	}
+	n, err := CountEmbeddings(ctx, db, config.Model)
+	if err != nil {
+		return nil, err
+	}
+	if n == 0 {
+		return nil, fmt.Errorf("no commands embedded with %s yet; collect embeds new ones while --embed-model is set", config.Model)
+	}
+	commands, err := HybridSearch(ctx, db, e, config.Model, opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to search: %w", err)
+	}
+	timings.Mark("semantic search")
+	return commands, nil
+}
+
+// RefineRequest holds the refine subcommand's flags
+type RefineRequest struct {
+	StateFile string
+	Add       string // KIND=VALUE filter to push
+	Pop       bool   // Drop the most recent filter
+	Clear     bool   // Drop all filters
+	Emit      bool   // Print records for the current filters instead of fzf actions
+}
+
+func runRefine(ctx context.Context, req RefineRequest) error {
+	state, err := LoadPickerState(req.StateFile)
+	if err != nil {
+		return err
+	}
+
+	if req.Emit {
+		commands, err := searchWithState(ctx, state)
+		if err != nil {
+			return err
+		}
+		writeSearchRecords(os.Stdout, commands, state.Color)
+		return nil
+	}
+
+	clearQuery := false
+	switch {
+	case req.Clear:
+		state.Filters = nil
+	case req.Pop:
+		if len(state.Filters) > 0 {
+			state.Filters = state.Filters[:len(state.Filters)-1]
+		}
+	case req.Add != "":
+		filter, err := ParseSearchFilter(req.Add)
+		if err != nil {
+			// Ignore bad input (e.g. empty query) rather than breaking the picker
+			fmt.Println("change-header:" + pickerHeader(state))
+			return nil
+		}
+		state.withFilter(filter)
+		clearQuery = filter.Kind == "text" || filter.Kind == "range" && strings.Contains(filter.Value, "..")
+	}
+
+	if err := state.Save(req.StateFile); err != nil {
+		return err
+	}
+
+	self, err := os.Executable()
+	if err != nil {
+		self = "zist"
+	}
+	fmt.Println(refineActions(self, req.StateFile, state, clearQuery))
+	return nil
+}
+
+// runNote sets, deletes or prints the note on a command
+func runNote(ctx context.Context, dbPath string, id int64, text string, remove bool) error {
+	db, err := InitDB(dbPath)
+	if err != nil {
+		return fmt.Errorf("failed to open database: %w", err)
+	}
+	defer db.Close()
+
+	cmd, err := GetCommandByID(ctx, db, id)
+	if err != nil {
+		return err
+	}
+	if cmd == nil {
+		return fmt.Errorf("no command with ID %d", id)
+	}
+
+	switch {
+	case remove:
+		deleted, err := DeleteNote(ctx, db, cmd.Source, cmd.Timestamp)
+		if err != nil {
+			return err
+		}
+		if !deleted {
+			return fmt.Errorf("command %d has no note", id)
+		}
+	case text != "":
+		if err := SetNote(ctx, db, cmd.Source, cmd.Timestamp, text); err != nil {
+			return err
+		}
+	default:
+		fmt.Printf("%s\n", cmd.FullCommand())
+		if cmd.Note != "" {
+			fmt.Printf("\n%s\n", cmd.Note)
+		}
+	}
+
+	return nil
+}
+
+func runLink(ctx context.Context, dbPath string, id int64, links []string, remove bool) error {
+	db, err := InitDB(dbPath)
+	if err != nil {
+		return fmt.Errorf("failed to open database: %w", err)
+	}
+	defer db.Close()
+
+	cmd, err := GetCommandByID(ctx, db, id)
+	if err != nil {
+		return err
+	}
+	if cmd == nil {
+		return fmt.Errorf("no command with ID %d", id)
+	}
+
+	switch {
+	case remove:
+		deleted, err := DeleteLinks(ctx, db, cmd.Source, cmd.Timestamp, links)
+		if err != nil {
+			return err
+		}
+		if deleted == 0 {
+			return fmt.Errorf("command %d has no such link", id)
+		}
+	case len(links) > 0:
+		for i, link := range links {
+			if links[i], err = NormalizeLink(link); err != nil {
+				return err
+			}
+		}
+		if _, err := AddLinks(ctx, db, cmd.Source, cmd.Timestamp, links); err != nil {
+			return err
+		}
+	default:
+		fmt.Printf("%s\n", cmd.FullCommand())
+		if len(cmd.Links) > 0 {
+			fmt.Printf("\n%s\n", strings.Join(cmd.Links, "\n"))
+		}
+	}
+
+	return nil
+}
+
+// TimelineRequest holds the timeline subcommand's flags
+type TimelineRequest struct {
+	DBPath string
+	Dir    string
+	Since  string
+	Until  string
+	Source string
+	Format string
+	Color  string
+}
+
+func runTimeline(ctx context.Context, req TimelineRequest, w io.Writer) error {
+	sinceTs, err := parseDateTime(req.Since)
+	if err != nil {
+		return fmt.Errorf("invalid --since: %w", err)
+	}
+	untilTs, err := parseDateTime(req.Until)
+	if err != nil {
+		return fmt.Errorf("invalid --until: %w", err)
+	}
+
+	dir := expandTilde(req.Dir)
+	if dir == "" {
+		wd, err := os.Getwd()
+		if err != nil {
+			return fmt.Errorf("failed to get current directory: %w", err)
+		}
+		dir = wd
+		if root := ProjectRoot(wd); root != "" {
+			dir = root
+		}
+	}
+	if dir, err = filepath.Abs(dir); err != nil {
+		return fmt.Errorf("invalid --cwd: %w", err)
+	}
+
+	f, _ := w.(*os.File)
+	format := req.Format
+	if format == "" {
+		format = TimelineText
+		if isTerminal(f) {
+			format = TimelineTUI
+		}
+	}
+
+	db, err := InitDB(req.DBPath)
+	if err != nil {
+		return fmt.Errorf("failed to open database: %w", err)
+	}
+	defer db.Close()
+
+	sessions, err := GetTimeline(ctx, db, TimelineOptions{Dir: dir, Since: sinceTs, Until: untilTs, Source: req.Source})
+	if err != nil {
+		return err
+	}
+
+	period := "All time"
+	switch {
+	case sinceTs > 0 && untilTs > 0:
+		period = fmt.Sprintf("%s to %s", FormatTimestamp(sinceTs), FormatTimestamp(untilTs))
+	case sinceTs > 0:
+		period = "Since " + FormatTimestamp(sinceTs)
+	case untilTs > 0:
+		period = "Until " + FormatTimestamp(untilTs)
+	}
+
+	switch format {
+	case TimelineMarkdown:
+		writeTimelineMarkdown(w, sessions, dir, period)
+		return nil
+	case TimelineText, TimelineTUI:
+	default:
+		return fmt.Errorf("invalid --format %q: want tui, text or markdown", format)
+	}
+	if len(sessions) == 0 {
+		fmt.Fprintf(w, "No commands in %s (%s)\n", dir, strings.ToLower(period[:1])+period[1:])
+		return nil
+	}
+
+	color, err := useColor(req.Color, f)
+	if err != nil {
+		return err
+	}
+	if format == TimelineText {
+		writeTimelineText(w, sessions, color)
+		return nil
+	}
+
+	picker, err := newPicker(pickerChoice)
+	if err != nil {
+		return err
+	}
+	header := fmt.Sprintf("%s: %d session(s), %d command(s)", dir, len(sessions), timelineCommands(sessions))
+	picked, err := picker.Pick(ctx, timelineRecords(sessions), PickOptions{Header: header, Ordered: true, Color: color, Preview: true})
+	if err != nil || picked == nil {
+		return err
+	}
+	command, err := pickedOverflow(ctx, req.DBPath, "", picked)
+	if err != nil {
+		return err
+	}
+	if command != "" {
+		fmt.Fprintln(w, command)
+	}
+	return nil
+}
+
+// SequenceRequest holds the sequence subcommand's flags
+type SequenceRequest struct {
+	DBPath string
+	Steps  []string
+	Within time.Duration
+	Regexp bool
+	Since  string
+	Until  string
+	Source string
+	Limit  int
+	JSON   bool
+	Color  string
+}
+
+func runSequence(ctx context.Context, req SequenceRequest, w io.Writer) error {
+	sinceTs, err := parseDateTime(req.Since)
+	if err != nil {
+		return fmt.Errorf("invalid --since: %w", err)
+	}
+	untilTs, err := parseDateTime(req.Until)
+	if err != nil {
+		return fmt.Errorf("invalid --until: %w", err)
+	}
+
+	db, err := OpenReadOnlyDB(req.DBPath)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	matches, err := FindSequences(ctx, db, SequenceOptions{
+		Steps:  req.Steps,
+		Regexp: req.Regexp,
+		Within: req.Within,
+		Since:  sinceTs,
+		Until:  untilTs,
+		Source: req.Source,
+	})
+	if err != nil {
+		return err
+	}
+
+	if req.JSON {
+		if matches == nil {
+			matches = []SequenceMatch{}
+		}
+		for _, m := range matches {
+			for i := range m.Steps {
+				m.Steps[i].Time = JSONTimestamp(m.Steps[i].Timestamp)
+			}
+		}
+		return json.NewEncoder(w).Encode(matches)
+	}
+	f, _ := w.(*os.File)
+	color, err := useColor(req.Color, f)
+	if err != nil {
+		return err
+	}
+	writeSequencesText(w, req.Steps, matches, req.Limit, color)
+	return nil
+}
+
+// ReplayRequest holds the replay subcommand's flags
+type ReplayRequest struct {
+	DBPath  string
+	Dir     string
+	Since   string
+	Until   string
+	Source  string
+	Session int // Nth most recent session, 0 for all
+	Format  string
+	Options ReplayOptions
+}
+
+func runReplay(ctx context.Context, req ReplayRequest, w io.Writer) error {
+	if req.Format != ReplayScript {
+		return fmt.Errorf("invalid --format %q: want script", req.Format)
+	}
+	if req.Session < 0 {
+		return fmt.Errorf("invalid --session %d", req.Session)
+	}
+	if req.Options.Speed <= 0 {
+		return fmt.Errorf("invalid --speed %g: must be positive", req.Options.Speed)
+	}
+	sinceTs, err := parseDateTime(req.Since)
+	if err != nil {
+		return fmt.Errorf("invalid --since: %w", err)
+	}
+	untilTs, err := parseDateTime(req.Until)
+	if err != nil {
+		return fmt.Errorf("invalid --until: %w", err)
+	}
+	dir := expandTilde(req.Dir)
+	if dir != "" {
+		if dir, err = filepath.Abs(dir); err != nil {
+			return fmt.Errorf("invalid --cwd: %w", err)
+		}
+	}
+
+	db, err := InitDB(req.DBPath)
+	if err != nil {
+		return fmt.Errorf("failed to open database: %w", err)
+	}
+	defer db.Close()
+
+	sessions, err := GetTimeline(ctx, db, TimelineOptions{Dir: dir, Since: sinceTs, Until: untilTs, Source: req.Source})
+	if err != nil {
+		return err
+	}
+	if len(sessions) == 0 {
+		return fmt.Errorf("no commands to replay, try an earlier --since")
+	}
+	if req.Session > 0 {
+		if req.Session > len(sessions) {
+			return fmt.Errorf("only %d session(s) in the range", len(sessions))
+		}
+		sessions = sessions[len(sessions)-req.Session : len(sessions)-req.Session+1]
+	}
+
+	writeReplayScript(w, sessions, req.Options)
+	return nil
+}
+
+// runShare formats a command and prints it, or publishes it to target and
+// prints the resulting URL
+// ShowRequest holds the show subcommand's flags
+type ShowRequest struct {
+	DBPath string
+	ID     int64
+	Record string // Picker record to preview, instead of a command by ID
+	Color  string
+}
+
+// runShow prints a command from the database, or the preview pane of a
+// picker record, highlighted when color is on
+func runShow(ctx context.Context, req ShowRequest, w io.Writer) error {
+	f, _ := w.(*os.File)
+	color, err := useColor(req.Color, f)
+	if err != nil {
+		return err
+	}
+	if req.Record != "" {
+		return writeRecordPreview(w, req.Record, color)
+	}
+
+	db, err := OpenReadOnlyDB(req.DBPath)
+	if err != nil {
+		return fmt.Errorf("failed to open database: %w", err)
+	}
+	defer db.Close()
+
+	cmd, err := GetCommandByID(ctx, db, req.ID)
+	if err != nil {
+		return err
+	}
+	if cmd == nil {
+		return fmt.Errorf("no command with ID %d", req.ID)
+	}
+	command := cmd.FullCommand()
+	if strings.HasSuffix(command, truncatedMarker) {
+		full, err := OverflowCommand(ctx, db, req.ID)
+		if err != nil {
+			return err
+		}
+		if full != "" {
+			command = full
+		}
+	}
+	if color {
+		command = highlightCommand(command)
+	}
+	fmt.Fprintln(w, command)
+	return nil
+}
+
+// ShareOptions controls what `zist share` includes and whether it asks
+type ShareOptions struct {
+	Note    bool // Include the command's note
+	Context bool // Include when and from which history file it ran
+	Yes     bool // Upload without showing the snippet and asking first
+}
+
+// runShare prints the snippet of command id, or uploads it to target once
+// the user, shown it on out, agrees on in
+func runShare(ctx context.Context, dbPath string, id int64, target ShareTarget, opts ShareOptions, in io.Reader, out io.Writer) error {
+	db, err := InitDB(dbPath)
+	if err != nil {
+		return fmt.Errorf("failed to open database: %w", err)
+	}
+	defer db.Close()
+
+	cmd, err := GetCommandByID(ctx, db, id)
+	if err != nil {
+		return err
+	}
+	if cmd == nil {
+		return fmt.Errorf("no command with ID %d", id)
+	}
+
+	body := FormatShare(*cmd, opts.Note, opts.Context)
+	if target == nil {
+		fmt.Fprint(out, body)
+		return nil
+	}
+	if !opts.Yes {
+		// Redaction only catches what looks like a credential
+		fmt.Fprintf(out, "%s\n", body)
+		if !askYesNo(bufio.NewScanner(in), out, "Upload this? [y/N] ", false) {
+			fmt.Fprintln(out, "Nothing shared")
+			return nil
+		}
+	}
+
+	title := "zist: " + CommandBinary(cmd.Command)
+	url, err := target.Share(ctx, title, body)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintln(out, url)
+	return nil
+}
+
+// runExport writes the commands opts selects to path, or stdout for "-",
+// and reports how many to stderr when the export goes to stdout
+func runExport(ctx context.Context, dbPath, path string, opts ExportOptions) error {
+	db, err := OpenReadOnlyDB(dbPath)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	var w io.Writer = os.Stdout
+	report := os.Stderr
+	var f *os.File
+	if path != "-" {
+		// Written under a temporary name, so a failed export leaves no file
+		path = expandTilde(path)
+		f, err = os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+		if err != nil {
+			return fmt.Errorf("failed to create %s: %w", path, err)
+		}
+		defer os.Remove(f.Name())
+		defer f.Close()
+		w, report = f, os.Stdout
+	}
+
+	count, head, err := ExportCommands(ctx, db, w, opts)
+	if err != nil {
+		return err
+	}
+	if f != nil {
+		if err := f.Close(); err != nil {
+			return fmt.Errorf("failed to write %s: %w", path, err)
+		}
+		if err := os.Rename(f.Name(), path); err != nil {
+			return fmt.Errorf("failed to write %s: %w", path, err)
+		}
+		fmt.Fprintf(report, "Exported %d command(s) to %s\n", count, path)
+	} else {
+		fmt.Fprintf(report, "Exported %d command(s)\n", count)
+	}
+	if opts.Chain {
+		fmt.Fprintf(report, "Chain head: %s\n", head)
+		fmt.Fprintln(report, "  Keep it apart from the export; zist export --verify FILE --head HASH proves nothing was changed")
+	}
+	return nil
+}
+
+// runExportVerify checks the hash chain of the export at path, or stdin
+// for "-", and with head that it ends where it did when written
+func runExportVerify(path, head string) error {
+	var r io.Reader = os.Stdin
+	if path != "-" {
+		f, err := os.Open(expandTilde(path))
+		if err != nil {
+			return fmt.Errorf("failed to open %s: %w", path, err)
+		}
+		defer f.Close()
+		r = f
+	}
+
+	count, last, err := VerifyChain(r)
+	if err != nil {
+		return fmt.Errorf("%s fails verification after %d good record(s): %w", path, count, err)
+	}
+	if head != "" && !strings.EqualFold(head, last) {
+		return fmt.Errorf("%s ends at %s after %d record(s), not at --head %s: records were removed from or added to the end", path, last, count, head)
+	}
+	fmt.Printf("%d record(s) verified, chain head: %s\n", count, last)
+	if head == "" {
+		fmt.Println("  Pass --head with the hash printed at export to also detect records cut off the end")
+	}
+	return nil
+}
+
+func runArchive(ctx context.Context, dbPath, archivePath string, years int, noWait bool) error {
+	if years <= 0 {
+		return fmt.Errorf("--older-than must be at least 1 year")
+	}
+
+	for _, path := range slices.Compact([]string{expandTilde(dbPath), expandTilde(archivePath)}) {
+		lock, err := lockForWrite(ctx, path, noWait, false)
+		if err != nil {
+			return err
+		}
+		defer lock.Release()
+	}
+
+	db, err := InitDB(dbPath)
+	if err != nil {
+		return fmt.Errorf("failed to open database: %w", err)
+	}
+	defer db.Close()
+
+	cutoff := time.Now().AddDate(-years, 0, 0)
+	moved, err := ArchiveCommands(ctx, db, archivePath, float64(cutoff.Unix()))
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Archived %d command(s) older than %s into %s\n", moved, cutoff.Format("2006-01-02"), archivePath)
+
+	if err := ensureFTSAfterBulkChange(ctx, db, dbPath); err != nil {
+		return err
+	}
+
+	archive, err := InitDB(archivePath)
+	if err != nil {
+		return fmt.Errorf("failed to open archive: %w", err)
+	}
+	defer archive.Close()
+
+	if err := ensureFTSAfterBulkChange(ctx, archive, archivePath); err != nil {
+		return err
+	}
+
+	fmt.Println("  Search them with: zist search --include-archive")
+	return nil
+}
+
+// ensureFTSAfterBulkChange verifies the FTS index after operations that move
+// many rows at once, rebuilding it so search never silently misses results
+func ensureFTSAfterBulkChange(ctx context.Context, db *sql.DB, dbPath string) error {
+	rebuilt, err := EnsureFTS(ctx, db)
+	if err != nil {
+		return fmt.Errorf("FTS index of %s is out of sync and could not be rebuilt: %w", dbPath, err)
+	}
+	if rebuilt {
+		fmt.Printf("  Rebuilt out-of-sync FTS index of %s\n", dbPath)
+	}
+	return nil
+}
+
+func runSnippetAdd(ctx context.Context, dbPath string, snippet Snippet) error {
+	db, err := InitDB(dbPath)
+	if err != nil {
+		return fmt.Errorf("failed to open database: %w", err)
+	}
+	defer db.Close()
+
+	return AddSnippet(ctx, db, snippet)
+}
+
+func runSnippetList(ctx context.Context, dbPath, query string) error {
+	db, err := InitDB(dbPath)
+	if err != nil {
+		return fmt.Errorf("failed to open database: %w", err)
+	}
+	defer db.Close()
+
+	snippets, err := SearchSnippets(ctx, db, query)
+	if err != nil {
+		return err
+	}
+
+	for _, s := range snippets {
+		fmt.Printf("%-9s %s\t%s\n", s.Badge(), s.Name, s.Command)
+		if s.Description != "" {
+			fmt.Printf("          %s\n", s.Description)
+		}
+	}
+	return nil
+}
+
+func runSnippetRm(ctx context.Context, dbPath, name string) error {
+	db, err := InitDB(dbPath)
+	if err != nil {
+		return fmt.Errorf("failed to open database: %w", err)
+	}
+	defer db.Close()
+
+	deleted, err := DeleteSnippet(ctx, db, name)
+	if err != nil {
+		return err
+	}
+	if !deleted {
+		return fmt.Errorf("no local snippet named %q", name)
+	}
+	return nil
+}
+
+// runSnippetSync pulls the team repo and replaces the synced team snippets
+func runSnippetSync(ctx context.Context, dbPath, repo, dir string) error {
+	dir = expandTilde(dir)
+	if err := SyncTeamRepo(ctx, repo, dir); err != nil {
+		return err
+	}
+
+	snippets, err := LoadSnippetFile(filepath.Join(dir, TeamSnippetsFile))
+	if err != nil {
+		return err
+	}
+
+	db, err := InitDB(dbPath)
+	if err != nil {
+		return fmt.Errorf("failed to open database: %w", err)
+	}
+	defer db.Close()
+
+	if err := ReplaceTeamSnippets(ctx, db, snippets); err != nil {
+		return err
+	}
+
+	fmt.Printf("Synced %d team snippets\n", len(snippets))
+	return nil
+}
+
+// runSnippetPublish pushes a local snippet to the team repo and re-syncs so
+// it shows up with the team badge
+func runSnippetPublish(ctx context.Context, dbPath, dir, name string) error {
+	dir = expandTilde(dir)
+
+	db, err := InitDB(dbPath)
+	if err != nil {
+		return fmt.Errorf("failed to open database: %w", err)
+	}
+	defer db.Close()
+
+	snippet, err := GetSnippet(ctx, db, SnippetLocal, name)
+	if err != nil {
+		return err
+	}
+	if snippet == nil {
+		return fmt.Errorf("no local snippet named %q", name)
+	}
+
+	if err := SyncTeamRepo(ctx, "", dir); err != nil {
+		return fmt.Errorf("%w (run zist snippet sync first)", err)
+	}
+	if err := PublishTeamSnippet(ctx, dir, *snippet); err != nil {
+		return err
+	}
+
+	snippets, err := LoadSnippetFile(filepath.Join(dir, TeamSnippetsFile))
+	if err != nil {
+		return err
+	}
+	if err := ReplaceTeamSnippets(ctx, db, snippets); err != nil {
+		return err
+	}
+
+	fmt.Printf("Published %s to the team library\n", name)
+	return nil
+}
+
+// runQuick searches the read-only database and prints results, one per line
+// or as a QuickResponse
+// runPrefixSearch prints the commands starting with prefix, one per line or
+// NUL-terminated with print0
+func runPrefixSearch(ctx context.Context, dbPath, prefix string, limit int, print0 bool, w io.Writer) error {
+	db, err := OpenReadOnlyDB(dbPath)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	results, err := SearchByPrefix(ctx, db, prefix, limit)
+	if err != nil {
+		return err
+	}
+	sep := "\n"
+	if print0 {
+		sep = "\x00"
+	}
+	for _, r := range results {
+		fmt.Fprint(w, r.Command, sep)
+	}
+	return nil
+}
+
+func runQuick(ctx context.Context, dbPath, query string, limit int, budget time.Duration, asJSON bool, colorMode string, w io.Writer) error {
+	start := time.Now()
+	f, _ := w.(*os.File)
+	color, err := useColor(colorMode, f)
+	if err != nil {
+		return err
+	}
+	if budget > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, budget)
+		defer cancel()
+	}
+
+	db, err := OpenReadOnlyDB(dbPath)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	results, partial, err := QuickSearch(ctx, db, query, limit)
+	if err != nil {
+		return err
+	}
+
+	if !asJSON {
+		for _, r := range results {
+			if color {
+				fmt.Fprintln(w, colorSource(r.Command, r.Source))
+			} else {
+				fmt.Fprintln(w, r.Command)
+			}
+		}
+		return nil
+	}
+
+	if results == nil {
+		results = []QuickResult{}
+	}
+	resp := QuickResponse{
+		Version: QuickVersion,
+		Query:   query,
+		Results: results,
+		Partial: partial,
+		TookMs:  float64(time.Since(start).Microseconds()) / 1000,
+	}
+	return json.NewEncoder(w).Encode(resp)
+}
+
+// NextRequest holds the next subcommand's flags
+type NextRequest struct {
+	DBPath  string
+	Command string // Empty for the last command run
+	Limit   int
+	JSON    bool
+	Pick    bool
+	Color   string
+}
+
+func runNext(ctx context.Context, req NextRequest, w io.Writer) error {
+	db, err := OpenReadOnlyDB(req.DBPath)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	command := strings.TrimSpace(req.Command)
+	if command == "" {
+		if command, err = LatestCommand(ctx, db); err != nil {
+			return err
+		}
+		if command == "" {
+			return fmt.Errorf("history is empty, run zist collect first")
+		}
+	}
+	suggestions, runs, err := SuggestNext(ctx, db, command, req.Limit)
+	if err != nil {
+		return err
+	}
+
+	if req.JSON {
+		if suggestions == nil {
+			suggestions = []NextSuggestion{}
+		}
+		for i := range suggestions {
+			suggestions[i].Time = JSONTimestamp(suggestions[i].Timestamp)
+		}
+		return json.NewEncoder(w).Encode(struct {
+			Command     string           `json:"command"`
+			Runs        int              `json:"runs"`
+			Suggestions []NextSuggestion `json:"suggestions"`
+		}{command, runs, suggestions})
+	}
+
+	f, _ := w.(*os.File)
+	if !req.Pick {
+		color, err := useColor(req.Color, f)
+		if err != nil {
+			return err
+		}
+		writeNextText(w, command, runs, suggestions, color)
+		return nil
+	}
+
+	if len(suggestions) == 0 {
+		return nil
+	}
+	color, err := useColor(req.Color, os.Stderr)
+	if err != nil {
+		return err
+	}
+	picker, err := newPicker(pickerChoice)
+	if err != nil {
+		return err
+	}
+	shown, _ := truncateCommand(command, 60)
+	header := fmt.Sprintf("After %s (%d run(s))", shown, runs)
+	picked, err := picker.Pick(ctx, nextRecords(suggestions), PickOptions{Header: header, Ordered: true, Color: color, Preview: true})
+	if err != nil || picked == nil {
+		return err
+	}
+	next, err := pickedOverflow(ctx, req.DBPath, "", picked)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(w, next)
+	return nil
+}
+
+// PredictRequest holds the predict subcommand's flags
+type PredictRequest struct {
+	DBPath string
+	After  string // Empty for the last command in the database
+	Dir    string // Empty for the current directory
+	Limit  int
+	JSON   bool
+}
+
+func runPredict(ctx context.Context, req PredictRequest, w io.Writer) error {
+	db, err := OpenReadOnlyDB(req.DBPath)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	opts := PredictOptions{Prev: strings.TrimSpace(req.After), Dir: req.Dir, Limit: req.Limit}
+	if opts.Prev == "" {
+		if opts.Prev, err = LatestCommand(ctx, db); err != nil {
+			return err
+		}
+	}
+	if opts.Dir == "" {
+		opts.Dir, _ = os.Getwd()
+	}
+	predictions, err := Predict(ctx, db, opts)
+	if err != nil {
+		return err
+	}
+
+	if req.JSON {
+		if predictions == nil {
+			predictions = []Prediction{}
+		}
+		return json.NewEncoder(w).Encode(struct {
+			After       string       `json:"after"`
+			Dir         string       `json:"dir"`
+			Predictions []Prediction `json:"predictions"`
+		}{opts.Prev, opts.Dir, predictions})
+	}
+	for _, p := range predictions {
+		fmt.Fprintln(w, p.Command)
+	}
+	return nil
+}
+
+// runNotFound prints the corrected command lines for words, one per line,
+// and nothing when history has no near miss
+func runNotFound(ctx context.Context, dbPath string, words []string, limit int, w io.Writer) error {
+	db, err := OpenReadOnlyDB(dbPath)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	suggestions, err := SuggestForNotFound(ctx, db, words, limit)
+	if err != nil {
+		return err
+	}
+	for _, s := range suggestions {
+		fmt.Fprintln(w, s.Command)
+	}
+	return nil
+}
+
+// runPredictRetrain throws away the transition counts and counts all
+// history again
+func runPredictRetrain(ctx context.Context, dbPath string, noWait bool) error {
+	lock, err := lockForWrite(ctx, dbPath, noWait, false)
+	if err != nil {
+		return err
+	}
+	defer lock.Release()
+
+	db, err := InitDB(dbPath)
+	if err != nil {
+		return fmt.Errorf("failed to open database: %w", err)
+	}
+	defer db.Close()
+
+	if err := ResetPredictor(ctx, db); err != nil {
+		return err
+	}
+	trained, err := TrainPredictor(ctx, db)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Counted what followed what in %d command(s)\n", trained)
+	return nil
+}
+
+// runServe serves the API until interrupted
+func runServe(ctx context.Context, addr, dataDir string, tlsOpts ServerTLSOptions, limits ServerLimits, ui bool, wizardLLM llm.Client, warmEvery time.Duration) error {
+	tlsConfig, err := tlsOpts.Config()
+	if err != nil {
+		return err
+	}
+	if host, _, err := net.SplitHostPort(addr); tlsConfig == nil && (err != nil || !isLoopback(host)) {
+		fmt.Println("Warning: serving without TLS on a non-loopback address, history will cross the network unencrypted")
+	}
+
+	server, err := NewServer(dataDir, limits)
+	if err != nil {
+		return err
+	}
+	defer server.Close()
+	server.ui = ui
+	server.llm = wizardLLM
+
+	users, err := ListUsers(ctx, server.authDB)
+	if err != nil {
+		return err
+	}
+	if len(users) == 0 {
+		fmt.Println("No users yet, create one with: zist serve user add --role admin NAME")
+	}
+
+	ctx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	httpServer := &http.Server{
+		Addr:              addr,
+		Handler:           server.Handler(),
+		ReadHeaderTimeout: 10 * time.Second,
+		TLSConfig:         tlsConfig,
+	}
+
+	scheme := "http"
+	if tlsConfig != nil {
+		scheme = "https"
+	}
+	fmt.Printf("Serving on %s://%s (data: %s)\n", scheme, addr, expandTilde(dataDir))
+	if ui {
+		fmt.Printf("Web UI at %s://%s/\n", scheme, addr)
+	}
+	if wizardLLM != nil {
+		fmt.Println("Wizard enabled at /api/v1/wizard")
+		if warmEvery > 0 {
+			go KeepWarm(ctx, wizardLLM, warmEvery)
+		}
+	}
+
+	errCh := make(chan error, 1)
+	go func() {
+		if tlsConfig != nil {
+			errCh <- httpServer.ListenAndServeTLS("", "")
+			return
+		}
+		errCh <- httpServer.ListenAndServe()
+	}()
+
+	select {
+	case err := <-errCh:
+		return fmt.Errorf("server failed: %w", err)
+	case <-ctx.Done():
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	return httpServer.Shutdown(shutdownCtx)
+}
+
+// runServeUser manages users directly in the server database
+func runServeUser(ctx context.Context, dataDir, action, name, role string) error {
+	db, err := InitServerDB(dataDir)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	switch action {
+	case "add":
+		token, err := CreateUser(ctx, db, name, role)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Created %s %s\nToken: %s\n", role, name, token)
+	case "token":
+		token, err := RotateToken(ctx, db, name)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Token: %s\n", token)
+	case "rm":
+		deleted, err := DeleteUser(ctx, db, name)
+		if err != nil {
+			return err
+		}
+		if !deleted {
+			return fmt.Errorf("no user named %q", name)
+		}
+		retired, err := RetireUserDB(dataDir, name)
+		if err != nil {
+			return err
+		}
+		if retired == "" {
+			fmt.Printf("Deleted %s\n", name)
+		} else {
+			fmt.Printf("Deleted %s (history moved to %s)\n", name, retired)
+		}
+	case "list":
+		users, err := ListUsers(ctx, db)
+		if err != nil {
+			return err
+		}
+		for _, u := range users {
+			fmt.Printf("%-20s %-8s %s\n", u.Name, u.Role, FormatTimestamp(u.CreatedAt))
+		}
+	}
+
+	return nil
+}
+
+func runSync(ctx context.Context, dbPath string, client *SyncClient, diff bool) error {
+	db, err := InitDB(dbPath)
+	if err != nil {
+		return fmt.Errorf("failed to open database: %w", err)
+	}
+	defer db.Close()
+
+	if diff {
+		buckets, err := client.Diff(ctx, db, syncHostname())
+		if err != nil {
+			return fmt.Errorf("sync diff failed: %w", err)
+		}
+		return writeDiff(os.Stdout, buckets)
+	}
+
+	pushed, skews, err := client.Push(ctx, db, syncHostname())
+	for _, skew := range skews {
+		fmt.Printf("Warning: %s (on the server, by its clock)\n", skew)
+	}
+	if err != nil {
+		return fmt.Errorf("sync failed after %d commands: %w", pushed, err)
+	}
+
+	fmt.Printf("Pushed %d commands to %s\n", pushed, client.Server)
+	return nil
+}
+
+func runStats(ctx context.Context, dbPath string, top int, w io.Writer) error {
+	db, err := InitDB(dbPath)
+	if err != nil {
+		return fmt.Errorf("failed to open database: %w", err)
+	}
+	defer db.Close()
+
+	stats, err := GetDBStats(ctx, db)
+	if err != nil {
+		return err
+	}
+	sources, err := GetSourceCounts(ctx, db)
+	if err != nil {
+		return err
+	}
+	commands, err := GetFrequentCommands(ctx, db, "", top)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(w, "Total commands: %d\n", stats["total_commands"])
+	fmt.Fprintf(w, "\nSources (%d):\n", len(sources))
+	for _, s := range sources {
+		fmt.Fprintf(w, "  %8d  %s\n", s.Count, s.Source)
+	}
+	fmt.Fprintf(w, "\nMost used commands:\n")
+	for _, c := range commands {
+		fmt.Fprintf(w, "  %8d  %s\n", c.Count, c.Command)
+	}
+	return nil
+}
+
+func runStatsBinary(ctx context.Context, dbPath, pwd string, top int, asJSON bool, w io.Writer) error {
+	db, err := OpenReadOnlyDB(dbPath)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	if pwd == "" {
+		pwd, _ = os.Getwd()
+	}
+	stats, err := GetBinaryStats(ctx, db, time.Now(), ProjectRoot(pwd), top)
+	if err != nil {
+		return err
+	}
+	return writeBinaryStats(w, stats, asJSON)
+}
+
+func runStatsWizard(ctx context.Context, dbPath string, days int, priceFlags []string, w io.Writer) error {
+	prices := make(map[string]ModelPrice, len(DefaultModelPrices))
+	for model, p := range DefaultModelPrices {
+		prices[model] = p
+	}
+	for _, f := range priceFlags {
+		model, p, err := ParseModelPrice(f)
+		if err != nil {
+			return err
+		}
+		prices[model] = p
+	}
+
+	db, err := InitDB(dbPath)
+	if err != nil {
+		return fmt.Errorf("failed to open database: %w", err)
+	}
+	defer db.Close()
+
+	var since float64
+	period := "all time"
+	if days > 0 {
+		since = float64(time.Now().AddDate(0, 0, -days).Unix())
+		period = fmt.Sprintf("last %d days", days)
+	}
+	usage, err := GetWizardUsage(ctx, db, since)
+	if err != nil {
+		return err
+	}
+	if len(usage) == 0 {
+		fmt.Fprintf(w, "No wizard requests (%s)\n", period)
+		return nil
+	}
+
+	fmt.Fprintf(w, "Wizard usage (%s):\n\n", period)
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "MODEL\tREQUESTS\tCACHED\tPROMPT TOKENS\tCOMPLETION TOKENS\tEST. COST\t")
+	var total float64
+	for _, u := range usage {
+		cost := "-"
+		if c, ok := u.Cost(prices); ok {
+			cost = fmt.Sprintf("$%.4f", c)
+			total += c
+		}
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%d\t%d\t%s\t\n", u.Model, u.Requests, u.CacheHits, u.PromptTokens, u.CompletionTokens, cost)
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(w, "\nEstimated total: $%.4f\n", total)
+	fmt.Fprintln(w, "Costs use list prices; \"-\" means the price is unknown (local models are free). Set one with --price MODEL=INPUT/OUTPUT.")
+	return nil
+}
+
+func runStatsCollections(ctx context.Context, dbPath string, days int, w io.Writer) error {
+	db, err := InitDB(dbPath)
+	if err != nil {
+		return fmt.Errorf("failed to open database: %w", err)
+	}
+	defer db.Close()
+
+	var since float64
+	period := "all kept"
+	if days > 0 {
+		since = float64(time.Now().AddDate(0, 0, -days).Unix())
+		period = fmt.Sprintf("last %d days", days)
 	}
+	stats, err := GetCollectStats(ctx, db, since)
+	if err != nil {
+		return err
+	}
+	return writeCollectStats(w, stats, period)
 }
 
-func expandHistoryPaths(paths []string) ([]string, error) {
-	var files []string
+func runStatsStorage(ctx context.Context, dbPath string, w io.Writer) error {
+	db, err := InitDB(dbPath)
+	if err != nil {
+		return fmt.Errorf("failed to open database: %w", err)
+	}
+	defer db.Close()
 
-	for _, path := range paths {
-		fileInfo, err := os.Stat(path)
+	stats, err := GetStorageStats(ctx, db, dbPath)
+	if err != nil {
+		return err
+	}
+	return writeStorageStats(w, stats, dbPath)
+}
+
+func runFTS(ctx context.Context, dbPath string, rebuild, noWait bool) error {
+	if rebuild {
+		lock, err := lockForWrite(ctx, dbPath, noWait, false)
 		if err != nil {
-			return nil, fmt.Errorf("failed to stat %s: %w", path, err)
+			return err
 		}
+		defer lock.Release()
+	}
 
-		if fileInfo.IsDir() {
-			// Recursively walk the directory tree
-			err := filepath.WalkDir(path, func(p string, d os.DirEntry, err error) error {
-				if err != nil {
-					return err
-				}
-				if !d.IsDir() && strings.HasSuffix(d.Name(), "zsh_history") {
-					files = append(files, p)
-				}
-				return nil
-			})
-			if err != nil {
-				return nil, fmt.Errorf("failed to walk directory %s: %w", path, err)
-			}
-		} else {
-			files = append(files, path)
+	db, err := InitDB(dbPath)
+	if err != nil {
+		return fmt.Errorf("failed to open database: %w", err)
+	}
+	defer db.Close()
+
+	if rebuild {
+		if err := RebuildFTS(ctx, db); err != nil {
+			return err
 		}
+		fmt.Println("FTS index rebuilt")
+		return nil
 	}
 
-	return files, nil
+	if err := CheckFTS(ctx, db); err != nil {
+		return fmt.Errorf("%w (run: zist fts rebuild)", err)
+	}
+	fmt.Println("FTS index OK")
+	return nil
 }
 
-func runCollect(ctx context.Context, dbPath string, historyFiles []string, quiet bool) error {
-	// Default to ~/.histories if no paths specified
-	if len(historyFiles) == 0 {
-		historyFiles = []string{expandTilde("~/.histories")}
+func runPlugins(w io.Writer) error {
+	plugins := ListPlugins()
+	if len(plugins) == 0 {
+		fmt.Fprintf(w, "No plugins found; put an executable named %sNAME on PATH to add zist NAME\n", pluginPrefix)
+		return nil
 	}
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "COMMAND\tPATH\t")
+	for _, p := range plugins {
+		fmt.Fprintf(tw, "%s\t%s\t\n", p.Name, p.Path)
+	}
+	return tw.Flush()
+}
 
-	expandedFiles, err := expandHistoryPaths(historyFiles)
+func runDevgen(ctx context.Context, dbPath string, opts FixtureOptions) error {
+	db, err := InitDB(dbPath)
+	if err != nil {
+		return fmt.Errorf("failed to open database: %w", err)
+	}
+	defer db.Close()
+
+	start := time.Now()
+	inserted, err := GenerateFixture(ctx, db, opts, os.Stderr)
 	if err != nil {
 		return err
 	}
+	fmt.Printf("Generated %d commands in %s into %s (seed %d)\n", inserted, time.Since(start).Round(time.Millisecond), dbPath, opts.Seed)
+	return nil
+}
 
-	if len(expandedFiles) == 0 {
-		return fmt.Errorf("no history files found")
+// EmbedRequest holds the embed subcommand's flags
+type EmbedRequest struct {
+	DBPath  string
+	Pause   time.Duration
+	Restart bool
+	Reindex bool
+	Detach  bool
+	Quiet   bool
+}
+
+// embedLockPath is locked by the running backfill, so only one runs
+func embedLockPath(dbPath string) string {
+	return dbPath + ".embed"
+}
+
+// backfillProgressInterval is how often a running backfill reports
+const backfillProgressInterval = 5 * time.Second
+
+func runEmbed(ctx context.Context, req EmbedRequest) error {
+	e, config, err := newEmbedder(embedConfig)
+	if err != nil {
+		return err
+	}
+	if req.Detach {
+		return detachEmbed(req)
 	}
 
-	if !quiet {
-		fmt.Printf("Collecting from %d file(s) into DB: %s\n", len(expandedFiles), dbPath)
+	lock, err := AcquireWriteLock(ctx, embedLockPath(req.DBPath), false, nil)
+	if errors.Is(err, ErrLocked) {
+		return fmt.Errorf("an embedding backfill is already running on %s (see zist embed --status)", req.DBPath)
 	}
+	if err != nil {
+		return err
+	}
+	defer lock.Release()
+	lowerPriority()
 
-	db, err := InitDB(dbPath)
+	db, err := InitDB(req.DBPath)
 	if err != nil {
-		return fmt.Errorf("failed to initialize database: %w", err)
+		return fmt.Errorf("failed to open database: %w", err)
 	}
 	defer db.Close()
 
-	totalInserted := 0
-	totalIgnored := 0
+	// Stopping keeps the progress, for the next run to resume from
+	ctx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	defer stop()
 
-	for _, file := range expandedFiles {
-		history, err := ParseHistoryFile(file)
+	writeLock := func(ctx context.Context) (func(), error) {
+		l, err := AcquireWriteLock(ctx, req.DBPath, true, nil)
 		if err != nil {
-			if !quiet {
-				fmt.Printf("Error parsing %s: %v\n", file, err)
-			}
-			continue
+			return nil, err
 		}
+		return func() { l.Release() }, nil
+	}
 
-		inserted, ignored, err := InsertCommandsBatch(db, history.Commands, 500)
-		if err != nil {
-			if !quiet {
-				fmt.Printf("Error inserting from %s: %v\n", file, err)
+	var reported time.Time
+	p, err := BackfillEmbeddings(ctx, db, e, BackfillOptions{
+		Model:   config.Model,
+		Pause:   req.Pause,
+		Restart: req.Restart,
+		Lock:    writeLock,
+		Progress: func(p BackfillProgress) {
+			if !req.Quiet && time.Since(reported) >= backfillProgressInterval {
+				reported = time.Now()
+				fmt.Printf("%s: scanned %d of %d rows (%.1f%%), embedded %d command(s)\n", config.Model, p.Scanned, p.Total, p.Percent(), p.Embedded)
 			}
-			continue
-		}
+		},
+	})
+	if errors.Is(err, context.Canceled) {
+		fmt.Printf("Stopped at %.1f%%; run zist embed again to resume\n", p.Percent())
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("backfill stopped at %.1f%%, run zist embed to resume: %w", p.Percent(), err)
+	}
+	if !req.Quiet {
+		fmt.Printf("Backfill with %s done: scanned %d rows, embedded %d command(s)\n", config.Model, p.Scanned, p.Embedded)
+	}
 
-		if !quiet {
-			fmt.Printf("%s: %d parsed, %d new, %d skipped\n", file, len(history.Commands), inserted, ignored)
+	// Link the vectors into a graph once there are enough for scanning
+	// them all to slow semantic search down
+	reindex := req.Reindex
+	if !reindex {
+		if reindex, err = NeedsANNIndex(ctx, db, config.Model); err != nil {
+			return err
 		}
+	}
+	if !reindex {
+		return nil
+	}
+	if !req.Quiet {
+		fmt.Printf("Indexing vectors from %s...\n", config.Model)
+	}
+	start := time.Now()
+	idx, err := IndexEmbeddings(ctx, db, config.Model, writeLock)
+	if errors.Is(err, context.Canceled) {
+		fmt.Println("Stopped indexing; run zist embed again to index")
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to index vectors: %w", err)
+	}
+	if !req.Quiet {
+		fmt.Printf("Indexed %d vector(s) in %s\n", idx.Nodes, time.Since(start).Round(time.Second))
+	}
+	return nil
+}
 
-		totalInserted += inserted
-		totalIgnored += ignored
+// detachEmbed starts the backfill again as a background process with its
+// output in a log next to the database. The embedding settings go through
+// the environment, keeping the key off the process list.
+func detachEmbed(req EmbedRequest) error {
+	self, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("failed to find zist: %w", err)
+	}
+	logPath := expandTilde(req.DBPath) + ".embed.log"
+	logFile, err := os.OpenFile(logPath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to open log: %w", err)
 	}
+	defer logFile.Close()
 
-	if !quiet {
-		stats, err := GetDBStats(db)
+	args := []string{"embed", "--db", req.DBPath, "--pause", req.Pause.String()}
+	if req.Restart {
+		args = append(args, "--restart")
+	}
+	if req.Reindex {
+		args = append(args, "--reindex")
+	}
+	cmd := exec.Command(self, args...)
+	cmd.Env = append(os.Environ(),
+		"ZIST_EMBED_MODEL="+embedConfig.Model,
+		"ZIST_EMBED_BACKEND="+embedConfig.Backend,
+		"ZIST_EMBED_API_URL="+embedConfig.BaseURL,
+		"ZIST_EMBED_KEY="+embedConfig.APIKey,
+	)
+	cmd.Stdout, cmd.Stderr = logFile, logFile
+	cmd.SysProcAttr = detachedAttr()
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("failed to start backfill: %w", err)
+	}
+	fmt.Printf("Backfilling embeddings in the background (pid %d), logging to %s\nSee zist embed --status\n", cmd.Process.Pid, logPath)
+	return cmd.Process.Release()
+}
+
+func runEmbedStatus(ctx context.Context, dbPath string, w io.Writer) error {
+	if embedConfig.Model == "" {
+		return fmt.Errorf("no embedding model set (use --embed-model, e.g. nomic-embed-text)")
+	}
+	db, err := OpenReadOnlyDB(dbPath)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	embedded, err := CountEmbeddings(ctx, db, embedConfig.Model)
+	if err != nil {
+		return err
+	}
+	p, err := GetBackfillProgress(ctx, db, embedConfig.Model)
+	if err != nil {
+		return err
+	}
+	running := false
+	if lock, err := AcquireWriteLock(ctx, embedLockPath(dbPath), false, nil); errors.Is(err, ErrLocked) {
+		running = true
+	} else if err == nil {
+		lock.Release()
+	}
+
+	idx, err := GetANNIndex(ctx, db, embedConfig.Model)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(w, "%s: %d command(s) embedded\n", embedConfig.Model, embedded)
+	if idx != nil {
+		unindexed, err := CountUnindexed(ctx, db, embedConfig.Model, idx)
 		if err != nil {
-			fmt.Printf("Warning: could not get DB stats: %v\n", err)
-		} else {
-			fmt.Printf("\nDatabase stats:\n")
-			fmt.Printf("  Total commands: %d\n", stats["total_commands"])
-			fmt.Printf("  Total sources: %d\n", stats["total_sources"])
+			return err
 		}
-
-		fmt.Printf("\nCollection complete: %d new, %d skipped\n", totalInserted, totalIgnored)
+		fmt.Fprintf(w, "Index of %d vector(s) built %s, %d newer ones scanned\n", idx.Nodes, FormatTimestamp(idx.Built), unindexed)
 	}
+	if p == nil {
+		fmt.Fprintln(w, "No backfill yet; zist embed embeds history collected before the model was set")
+		return nil
+	}
+	state := "stopped; run zist embed to resume"
+	switch {
+	case running:
+		state = "running"
+	case p.Done:
+		state = "done"
+	case p.Error != "":
+		state = "stopped: " + p.Error
+	}
+	fmt.Fprintf(w, "Backfill %s\n", state)
+	fmt.Fprintf(w, "  scanned %d of %d rows (%.1f%%), embedded %d command(s)\n", p.Scanned, p.Total, p.Percent(), p.Embedded)
+	fmt.Fprintf(w, "  started %s, last progress %s\n", FormatTimestamp(p.Started), FormatTimestamp(p.Updated))
 	return nil
 }
 
-func parseDateTime(s string) (float64, error) {
-	if s == "" {
-		return 0, nil
+func runDevbench(ctx context.Context, dbPath, queriesPath string, k int) error {
+	cases := DefaultRecallCases
+	if queriesPath != "" {
+		var err error
+		if cases, err = LoadRecallCases(queriesPath); err != nil {
+			return err
+		}
+	}
+	e, config, err := newEmbedder(embedConfig)
+	if err != nil {
+		return err
 	}
 
-	// Try full datetime format first
-	t, err := time.ParseInLocation("2006-01-02 15:04:05", s, time.Local)
-	if err == nil {
-		return float64(t.Unix()), nil
+	db, err := InitDB(dbPath)
+	if err != nil {
+		return fmt.Errorf("failed to open database: %w", err)
 	}
+	defer db.Close()
 
-	// Try date-only format (use start of day)
-	t, err = time.ParseInLocation("2006-01-02", s, time.Local)
-	if err == nil {
-		return float64(t.Unix()), nil
+	// Every command needs a vector for the comparison to be fair
+	start := time.Now()
+	embedded, err := EmbedMissingCommands(ctx, db, e, config.Model, 0)
+	if err != nil {
+		return err
+	}
+	if embedded > 0 {
+		fmt.Fprintf(os.Stderr, "Embedded %d command(s) with %s in %s\n", embedded, config.Model, time.Since(start).Round(time.Millisecond))
 	}
 
-	return 0, fmt.Errorf("invalid date format: %s (use YYYY-MM-DD or YYYY-MM-DD HH:MM:SS)", s)
+	results, err := MeasureRecall(ctx, db, dbSearcher(db, e, config.Model), cases, k)
+	if err != nil {
+		return err
+	}
+	writeRecallReport(os.Stdout, results, k)
+	return nil
 }
 
-func runSearch(ctx context.Context, dbPath string, args []string, limit int, since, until string) error {
-	query := ""
-	if len(args) > 0 {
-		query = args[0]
+func runCheckStale(ctx context.Context, dbPath, staleAge string, quiet bool, w io.Writer) error {
+	age, err := parseAge(staleAge)
+	if err != nil {
+		return err
 	}
 
-	sinceTs, err := parseDateTime(since)
+	db, err := InitDB(dbPath)
 	if err != nil {
-		return err
+		return fmt.Errorf("failed to open database: %w", err)
 	}
+	defer db.Close()
 
-	untilTs, err := parseDateTime(until)
+	now := time.Now()
+	stale, err := StaleSources(ctx, db, now.Add(-age))
 	if err != nil {
 		return err
 	}
+	if len(stale) == 0 {
+		if !quiet {
+			fmt.Fprintf(w, "OK: every source has new commands in the last %s\n", staleAge)
+		}
+		return nil
+	}
+
+	fmt.Fprintf(w, "%d source(s) with no new commands in the last %s:\n\n", len(stale), staleAge)
+	if err := writeStaleSources(w, stale, now); err != nil {
+		return err
+	}
+	fmt.Fprintln(w, "\nDrop decommissioned ones with: zist sources forget SOURCE")
+	return ErrCheckFailed
+}
 
+func runSourcesList(ctx context.Context, dbPath string, w io.Writer) error {
 	db, err := InitDB(dbPath)
 	if err != nil {
 		return fmt.Errorf("failed to open database: %w", err)
 	}
 	defer db.Close()
 
-	commands, err := SearchCommands(db, SearchOptions{
-		Query: query,
-		Limit: limit,
-		Since: sinceTs,
-		Until: untilTs,
-	})
+	sources, err := ListSources(ctx, db)
 	if err != nil {
-		return fmt.Errorf("failed to search: %w", err)
+		return err
 	}
-
-	if len(commands) == 0 {
+	offsets, err := GetClockOffsets(ctx, db)
+	if err != nil {
+		return err
+	}
+	if len(sources) == 0 {
+		fmt.Fprintln(w, "No sources collected")
 		return nil
 	}
 
-	if _, err := exec.LookPath("fzf"); err != nil {
-		return fmt.Errorf("fzf not found in PATH, please install it first")
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "SOURCE\tCOMMANDS\tFIRST SEEN\tLAST SEEN\tFILE\tCLOCK OFFSET\t")
+	var piped []string
+	for _, s := range sources {
+		file := "missing"
+		switch {
+		case s.Kind() == "pipe":
+			file = "pipe"
+			piped = append(piped, s.Source)
+		case s.Kind() == "label":
+			file = "-"
+		case s.Exists:
+			file = "ok"
+		}
+		offset := "-"
+		if o, ok := offsets[s.Source]; ok {
+			offset = o.String()
+		}
+		fmt.Fprintf(tw, "%s\t%d\t%s\t%s\t%s\t%s\t\n", s.Source, s.Count,
+			FormatTimestamp(s.FirstSeen), FormatTimestamp(s.LastSeen), file, offset)
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	if len(piped) > 0 {
+		fmt.Fprintf(w, "\n%d source(s) are named after the pipe they were collected from; give each a name with\n  zist sources rename %s NAME\n", len(piped), shellQuote(piped[0]))
+	}
+	return nil
+}
+
+func runSourcesRename(ctx context.Context, dbPath, from, to string, noWait bool) error {
+	lock, err := lockForWrite(ctx, dbPath, noWait, false)
+	if err != nil {
+		return err
 	}
+	defer lock.Release()
 
-	// fzf with preview pane showing source and timestamp
-	// Use --read0 to handle multiline commands (null-byte separated records)
-	cmd := exec.CommandContext(ctx, "fzf",
-		"--read0",
-		"--print0",
-		"--delimiter=\t",
-		"--with-nth=1", // Only display the command (field 1)
-		"--preview", `sh -c 'printf "Source: %s\nTime:   %s\n\nCommand:\n%s\n" "$2" "$3" "$1"' _ {1} {2} {3}`,
-		"--preview-window=right:40%:wrap",
-	)
-	cmd.Stderr = os.Stderr
+	db, err := InitDB(dbPath)
+	if err != nil {
+		return fmt.Errorf("failed to open database: %w", err)
+	}
+	defer db.Close()
+
+	moved, err := RenameSource(ctx, db, from, to)
+	if err != nil {
+		return err
+	}
+	if moved == 0 {
+		return fmt.Errorf("no commands from source %q", from)
+	}
+	fmt.Printf("Renamed %d command(s) from %s to %s\n", moved, from, to)
+	return nil
+}
 
-	stdin, err := cmd.StdinPipe()
+func runSourcesForget(ctx context.Context, dbPath, source string, noWait bool) error {
+	lock, err := lockForWrite(ctx, dbPath, noWait, false)
 	if err != nil {
-		return fmt.Errorf("failed to create stdin pipe: %w", err)
+		return err
 	}
+	defer lock.Release()
 
-	go func() {
-		for _, result := range commands {
-			// Tab-separated: command \t source \t timestamp, null-byte terminated
-			formattedTime := FormatTimestamp(result.Timestamp)
-			fmt.Fprintf(stdin, "%s\t%s\t%s\x00", result.Command, result.Source, formattedTime)
-		}
-		stdin.Close()
-	}()
+	db, err := InitDB(dbPath)
+	if err != nil {
+		return fmt.Errorf("failed to open database: %w", err)
+	}
+	defer db.Close()
 
-	stdout, err := cmd.Output()
+	deleted, err := ForgetSource(ctx, db, source)
 	if err != nil {
-		if exitErr, ok := err.(*exec.ExitError); ok {
-			if exitErr.ExitCode() == 130 {
-				return nil
-			}
-			return fmt.Errorf("fzf failed: %w", err)
-		}
-		return fmt.Errorf("fzf failed: %w", err)
+		return err
+	}
+	if deleted == 0 {
+		return fmt.Errorf("no commands from source %q", source)
+	}
+	fmt.Printf("Deleted %d command(s) from %s\n", deleted, source)
+	return nil
+}
+
+func runSourcesOffset(ctx context.Context, dbPath, source string, offset time.Duration, noWait bool) error {
+	lock, err := lockForWrite(ctx, dbPath, noWait, false)
+	if err != nil {
+		return err
 	}
+	defer lock.Release()
 
-	// Trim null byte and whitespace from output (--print0 adds trailing null)
-	selected := strings.TrimRight(string(stdout), "\x00")
-	selected = strings.TrimSpace(selected)
-	if selected == "" {
-		return nil
+	db, err := InitDB(dbPath)
+	if err != nil {
+		return fmt.Errorf("failed to open database: %w", err)
 	}
+	defer db.Close()
 
-	// Extract just the command (first tab-separated field)
-	parts := strings.SplitN(selected, "\t", 2)
-	if len(parts) >= 1 {
-		fmt.Println(parts[0])
+	if err := SetClockOffset(ctx, db, source, offset); err != nil {
+		return err
+	}
+	if offset == 0 {
+		fmt.Printf("Removed the clock offset of %s\n", source)
+	} else {
+		fmt.Printf("Timestamps from %s are now shifted by %s\n", source, offset)
 	}
 	return nil
 }
 
 const zshIntegration = `# BEGIN zist integration
+# Every zist command here honours ZIST_DB and ZIST_CONFIG; export them before
+# this block to use another profile
 # Ctrl+X for fuzzy history search
 _zist_search() {
   local buf=$LBUFFER
   local selected=$(zist search "$buf" 2>/dev/null)
   if [[ -n "$selected" ]]; then
     LBUFFER="$selected"
+    _zist_search_pick="$selected"
   fi
   zle reset-prompt
 }
 zle -N _zist_search
 bindkey '^X' _zist_search
 
+# Last command picked with Ctrl+X, counted when it is run unedited
+typeset -g _zist_search_pick=""
+# Last command run, for Alt+N and predictions
+typeset -g _zist_last_command=""
+
 # Wizard state for caching
 typeset -g _zist_wizard_query=""
 typeset -g _zist_wizard_command=""
+# A risky command held back until Ctrl+G is pressed again, and its query
+typeset -g _zist_wizard_held=""
+typeset -g _zist_wizard_held_query=""
 
-# Ctrl+G for wizard (natural language → command)
+# Ctrl+G for wizard (natural language → command). Commands the wizard
+# thinks need confirming are shown with why first, and go into the buffer
+# on a second Ctrl+G.
 _zist_wizard() {
   local query="$BUFFER"
   [[ -z "$query" ]] && return
 
-  local cmd
-  cmd=$(zist wizard --query "$query" 2>/dev/null)
+  local cmd line
+  if [[ -n "$_zist_wizard_held" && "$query" == "$_zist_wizard_held_query" ]]; then
+    cmd="$_zist_wizard_held"
+  else
+    if ! line=$(zist wizard --json --query "$query" 2>&1); then
+      # The last line is the error, e.g. the wizard being paused
+      zle -M "zist: ${${line##*$'\n'}#error: }"
+      return
+    fi
+    # One line of JSON, where the command has quotes and backslashes
+    # escaped and newlines as \n: undo the quotes, and (g::) the rest
+    [[ "$line" =~ '"command":"(([^"\\]|\\.)*)"' ]] || return
+    cmd=${(g::)${match[1]//\\\"/\"}}
+    if [[ "$line" =~ '"requires_confirmation":true,"danger_reasons":\[([^]]*)\]' ]]; then
+      _zist_wizard_held="$cmd"
+      _zist_wizard_held_query="$query"
+      zle -M "zist: careful, ${cmd%%$'\n'*} ${${match[1]//\",\"/, }//\"/}. Ctrl+G again to insert it"
+      return
+    fi
+  fi
+  _zist_wizard_held=""
+  _zist_wizard_held_query=""
 
   if [[ -n "$cmd" ]]; then
     # Store for caching on execution
@@ -392,6 +4470,9 @@ _zist_wizard() {
     CURSOR=${#BUFFER}
   fi
   zle reset-prompt
+  if [[ "$line" == *'"needs_root":true'* ]]; then
+    zle -M "zist: this likely needs root (sudo)"
+  fi
 }
 zle -N _zist_wizard
 bindkey '^G' _zist_wizard
@@ -403,137 +4484,600 @@ _zist_accept_line() {
     # Cache the actual command being run (user may have edited it)
     (zist wizard --cache "$_zist_wizard_query" --cache-command "$BUFFER" &) 2>/dev/null
   fi
-  # Clear wizard state
+  # Count search picks that are run as picked, so they rank higher
+  if [[ -n "$_zist_search_pick" && "$BUFFER" == "$_zist_search_pick" ]]; then
+    (zist search --picked "$BUFFER" &) 2>/dev/null
+  fi
+  _zist_last_command=$BUFFER
+  # Clear wizard and search state
   _zist_wizard_query=""
   _zist_wizard_command=""
+  _zist_wizard_held=""
+  _zist_wizard_held_query=""
+  _zist_search_pick=""
   zle .accept-line
 }
 zle -N accept-line _zist_accept_line
 
-# Collect history after each command
-autoload -Uz add-zsh-hook
-_zist_precmd() {
-  (zist collect --quiet &)
-}
-add-zsh-hook precmd _zist_precmd
+# Alt+N picks from the commands that usually followed the last one run, or
+# the one just picked with Ctrl+X. Opt in with ZIST_NEXT=1 set before this
+# block.
+if [[ "$ZIST_NEXT" == 1 ]]; then
+  _zist_next() {
+    local last=${_zist_search_pick:-$_zist_last_command}
+    local selected=$(zist next --pick -- "$last" 2>/dev/null)
+    if [[ -n "$selected" ]]; then
+      LBUFFER="$selected"
+    fi
+    zle reset-prompt
+  }
+  zle -N _zist_next
+  bindkey '^[n' _zist_next
+fi
+
+# When a command isn't found, suggest the program from history it likely
+# meant, e.g. git for gti, then fall back to the handler defined before this
+# block, such as the distro's. Opt in with ZIST_NOT_FOUND=1 set before this
+# block.
+if [[ "$ZIST_NOT_FOUND" == 1 ]]; then
+  # Sourcing .zshrc again mustn't make this handler its own fallback
+  if (( $+functions[command_not_found_handler] )) && [[ $functions[command_not_found_handler] != *'zist not-found'* ]]; then
+    functions[_zist_fallback_not_found]=$functions[command_not_found_handler]
+  fi
+  command_not_found_handler() {
+    local suggestion=$(zist not-found --limit 1 -- "$@" 2>/dev/null)
+    if [[ -n "$suggestion" ]]; then
+      print -ru2 -- "zsh: command not found: $1"
+      print -ru2 -- "zist: did you mean: $suggestion"
+      return 127
+    fi
+    if (( $+functions[_zist_fallback_not_found] )); then
+      _zist_fallback_not_found "$@"
+      return
+    fi
+    print -ru2 -- "zsh: command not found: $1"
+    return 127
+  }
+fi
+
+# On an empty prompt, the command most likely to follow the last one in this
+# directory is shown dimmed and Right arrow takes it. Opt in with
+# ZIST_PREDICT=1 set before this block (zsh 5.3+).
+if [[ "$ZIST_PREDICT" == 1 ]]; then
+  autoload -Uz add-zle-hook-widget
+  typeset -g _zist_predict_command="" _zist_predict_hl=""
+
+  _zist_predict_show() {
+    local cmd=$(zist predict --limit 1 --after "$_zist_last_command" --pwd "$PWD" 2>/dev/null)
+    [[ -n "$cmd" && -z "$BUFFER" ]] || return
+    _zist_predict_command=$cmd
+    POSTDISPLAY=$cmd
+    _zist_predict_hl="0 ${#POSTDISPLAY} fg=8"
+    region_highlight+=("$_zist_predict_hl")
+  }
+
+  _zist_predict_clear() {
+    [[ -n "$_zist_predict_command" ]] || return
+    POSTDISPLAY=""
+    region_highlight=("${(@)region_highlight:#$_zist_predict_hl}")
+    _zist_predict_command="" _zist_predict_hl=""
+  }
+
+  # Typing anything hides the prediction
+  _zist_predict_redraw() {
+    [[ -n "$BUFFER" ]] && _zist_predict_clear
+  }
+
+  # Right arrow takes the prediction, or moves the cursor as usual
+  _zist_predict_accept() {
+    if [[ -z "$_zist_predict_command" || -n "$BUFFER" ]]; then
+      zle forward-char
+      return
+    fi
+    local cmd=$_zist_predict_command
+    _zist_predict_clear
+    BUFFER=$cmd
+    CURSOR=${#BUFFER}
+  }
+
+  zle -N _zist_predict_show
+  zle -N _zist_predict_clear
+  zle -N _zist_predict_redraw
+  zle -N _zist_predict_accept
+  add-zle-hook-widget line-init _zist_predict_show
+  add-zle-hook-widget line-pre-redraw _zist_predict_redraw
+  add-zle-hook-widget line-finish _zist_predict_clear
+  bindkey '^[[C' _zist_predict_accept
+  bindkey '^[OC' _zist_predict_accept
+fi
+
+# Load the wizard's model in the background at shell startup, so the first
+# Ctrl+G isn't a cold start. Opt in with ZIST_WIZARD_WARM=1.
+if [[ "$ZIST_WIZARD_WARM" == 1 ]]; then
+  (zist wizard --warm &>/dev/null &)
+fi
+
+# Ghost text wizard preview: after a short pause on a buffer that reads like
+# a request, the suggestion is shown dimmed after the cursor and Tab accepts
+# it. Opt in with ZIST_WIZARD_GHOST=1 set before this block (zsh 5.3+).
+if [[ "$ZIST_WIZARD_GHOST" == 1 ]]; then
+  autoload -Uz add-zle-hook-widget
+  typeset -g _zist_ghost_fd="" _zist_ghost_pid="" _zist_ghost_buffer=""
+  typeset -g _zist_ghost_command="" _zist_ghost_hl=""
+
+  _zist_ghost_cancel() {
+    [[ -n "$_zist_ghost_fd" ]] || return
+    zle -F $_zist_ghost_fd 2>/dev/null
+    exec {_zist_ghost_fd}<&-
+    kill $_zist_ghost_pid 2>/dev/null
+    _zist_ghost_fd="" _zist_ghost_pid=""
+  }
+
+  _zist_ghost_clear() {
+    [[ -n "$_zist_ghost_command" ]] || return
+    POSTDISPLAY=""
+    region_highlight=("${(@)region_highlight:#$_zist_ghost_hl}")
+    _zist_ghost_command="" _zist_ghost_hl=""
+  }
+
+  # Restart the idle timer whenever the buffer changes
+  _zist_ghost_redraw() {
+    [[ "$BUFFER" == "$_zist_ghost_buffer" ]] && return
+    _zist_ghost_buffer=$BUFFER
+    _zist_ghost_cancel
+    _zist_ghost_clear
+    (( ${#${(z)BUFFER}} >= 3 )) || return
+    exec {_zist_ghost_fd}< <(sh -c 'echo $$; sleep "$1"; exec zist wizard --ghost --query "$2" --pwd "$3" 2>/dev/null' \
+      zist-ghost "${ZIST_WIZARD_GHOST_DELAY:-0.6}" "$BUFFER" "$PWD")
+    read -r -u $_zist_ghost_fd _zist_ghost_pid
+    zle -F -w $_zist_ghost_fd _zist_ghost_ready
+  }
+
+  _zist_ghost_ready() {
+    local fd=$1 cmd=""
+    read -r -u $fd cmd
+    zle -F $fd
+    exec {fd}<&-
+    _zist_ghost_fd="" _zist_ghost_pid=""
+    [[ -n "$cmd" && "$BUFFER" == "$_zist_ghost_buffer" ]] || return
+    _zist_ghost_command=$cmd
+    POSTDISPLAY="  → $cmd"
+    _zist_ghost_hl="${#BUFFER} $(( ${#BUFFER} + ${#POSTDISPLAY} )) fg=8"
+    region_highlight+=("$_zist_ghost_hl")
+    zle -R
+  }
+
+  _zist_ghost_finish() {
+    _zist_ghost_cancel
+    _zist_ghost_clear
+    _zist_ghost_buffer=""
+  }
+
+  # Tab takes the suggestion, or completes as usual when there is none
+  _zist_ghost_accept() {
+    if [[ -z "$_zist_ghost_command" ]]; then
+      zle expand-or-complete
+      return
+    fi
+    _zist_wizard_query=$BUFFER
+    _zist_wizard_command=$_zist_ghost_command
+    local cmd=$_zist_ghost_command
+    _zist_ghost_clear
+    BUFFER=$cmd
+    CURSOR=${#BUFFER}
+    _zist_ghost_buffer=$BUFFER
+  }
+
+  zle -N _zist_ghost_redraw
+  zle -N _zist_ghost_ready
+  zle -N _zist_ghost_finish
+  zle -N _zist_ghost_accept
+  add-zle-hook-widget line-pre-redraw _zist_ghost_redraw
+  add-zle-hook-widget line-finish _zist_ghost_finish
+  bindkey '^I' _zist_ghost_accept
+fi
+
+# Up/Down cycle through commands from every history that start with the
+# text before the cursor, like history-beginning-search-backward over the
+# database. Opt in with ZIST_PREFIX_SEARCH=1 set before this block.
+if [[ "$ZIST_PREFIX_SEARCH" == 1 ]]; then
+  typeset -g _zist_prefix_buffer=""
+  typeset -ga _zist_prefix_matches
+  typeset -gi _zist_prefix_index=0 _zist_prefix_cursor=0
+
+  # Step through the matches by $1, fetching them when a cycle starts
+  _zist_prefix_step() {
+    if [[ $LASTWIDGET != _zist_prefix_up && $LASTWIDGET != _zist_prefix_down ]]; then
+      local out
+      out=$(zist prefix-search --print0 -- "$LBUFFER" 2>/dev/null)
+      _zist_prefix_matches=(${(0)out})
+      _zist_prefix_buffer=$BUFFER _zist_prefix_cursor=$CURSOR _zist_prefix_index=0
+    fi
+    local next=$(( _zist_prefix_index + $1 ))
+    if (( next < 0 || next > ${#_zist_prefix_matches} )); then
+      return 1
+    fi
+    _zist_prefix_index=$next
+    if (( next == 0 )); then
+      BUFFER=$_zist_prefix_buffer
+    else
+      BUFFER=${_zist_prefix_matches[next]}
+    fi
+    CURSOR=$_zist_prefix_cursor
+  }
+
+  # Lines of a multi-line buffer, and an empty database, work as usual
+  _zist_prefix_up() {
+    if [[ $LASTWIDGET != _zist_prefix_* && $LBUFFER == *$'\n'* ]]; then
+      zle .up-line-or-history
+      return
+    fi
+    _zist_prefix_step 1 || (( ${#_zist_prefix_matches} )) || zle .up-line-or-history
+  }
+
+  _zist_prefix_down() {
+    if [[ $LASTWIDGET != _zist_prefix_* ]]; then
+      zle .down-line-or-history
+      return
+    fi
+    _zist_prefix_step -1 || (( ${#_zist_prefix_matches} )) || zle .down-line-or-history
+  }
+
+  zle -N _zist_prefix_up
+  zle -N _zist_prefix_down
+  bindkey '^[[A' _zist_prefix_up
+  bindkey '^[OA' _zist_prefix_up
+  bindkey '^[[B' _zist_prefix_down
+  bindkey '^[OB' _zist_prefix_down
+fi
+
+# Record each command as it finishes with its directory, exit status and
+# duration, which history files leave out. Opt in with ZIST_HOOK_RECORD=1 set
+# before this block.
+if [[ "$ZIST_HOOK_RECORD" == 1 ]]; then
+  zmodload zsh/datetime zsh/parameter
+  autoload -Uz add-zsh-hook
+  _zist_record_preexec() {
+    _zist_record_cmd=$1
+    _zist_record_event=$HISTCMD
+    _zist_record_start=$EPOCHREALTIME
+  }
+  _zist_record_precmd() {
+    local exit_code=$?
+    [[ -n "$_zist_record_start" ]] || return
+    local cmd=$_zist_record_cmd event=$_zist_record_event started=${_zist_record_start%.*}
+    local duration=$(( EPOCHREALTIME - _zist_record_start ))
+    _zist_record_start=
+    # Only commands that go into the history file, with the start time
+    # written there, so collect recognises them as already stored
+    [[ "$history[$event]" == "$cmd" ]] || return
+    [[ -o histignorespace && "$cmd" == ' '* ]] && return
+    local -a entry=(${=$(fc -lnt %s $event $event 2>/dev/null)})
+    local start=${entry[1]}
+    [[ "$start" == <-> ]] || start=$started
+    # An older event means the shell dropped the command as a duplicate
+    (( start >= started - 1 )) || return
+    (zist record --source "${HISTFILE:a}" --timestamp "$start" --duration "${duration%.*}" \
+      --cwd "$PWD" --exit-code "$exit_code" -- "$cmd" &>/dev/null &)
+  }
+  add-zsh-hook preexec _zist_record_preexec
+  # First, so $? is still the command's and it's recorded before collect
+  precmd_functions=(_zist_record_precmd $precmd_functions)
+fi
+
+# Collect history after each command. With ZIST_DAEMON=1 set before this
+# block, zist daemon collects instead.
+if [[ "$ZIST_DAEMON" != 1 ]]; then
+  autoload -Uz add-zsh-hook
+  _zist_precmd() {
+    (zist collect --quiet --debounce 2s --no-wait &)
+  }
+  add-zsh-hook precmd _zist_precmd
+fi
 # END zist integration
 `
 
-func runInstall(ctx context.Context) error {
-	usr, err := user.Current()
+// runMigratePaths moves what zist kept in ~/.zist to where it lives now.
+// Every other command does this quietly on startup.
+func runMigratePaths(dryRun bool, w io.Writer) error {
+	moves, err := MigratePaths(legacyMoves(LegacyDir, DataDir(), ConfigDir()), dryRun)
+	verb := "Moved"
+	if dryRun {
+		verb = "Would move"
+	}
+	for _, m := range moves {
+		fmt.Fprintf(w, "%s %s %s %s\n", verb, m.From, symbol("→", "->"), m.To)
+	}
 	if err != nil {
-		return fmt.Errorf("failed to get current user: %w", err)
+		return err
+	}
+	if !dryRun {
+		os.Remove(expandTilde(LegacyDir))
 	}
+	if len(moves) == 0 {
+		fmt.Fprintf(w, "Nothing to move, data is in %s and config in %s\n", DataDir(), ConfigDir())
+	}
+	return nil
+}
 
-	zshrcPath := filepath.Join(usr.HomeDir, ".zshrc")
+func runInstall(ctx context.Context, rcPath string, in io.Reader, out io.Writer) error {
+	// A missing rc file is created, e.g. when zsh is only set up in /etc/zshrc
+	content, err := os.ReadFile(rcPath)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to read %s: %w", rcPath, err)
+	}
 
-	content, err := os.ReadFile(zshrcPath)
+	block, found, err := findIntegration(string(content))
 	if err != nil {
-		return fmt.Errorf("failed to read ~/.zshrc: %w", err)
+		return fmt.Errorf("refusing to edit %s: %w\n  Fix the markers or remove the block by hand; zist install --print shows what it should contain", rcPath, err)
 	}
-
-	if strings.Contains(string(content), "# BEGIN zist integration") {
-		fmt.Println("ZSH integration already installed")
-		fmt.Println("  To reinstall, run: zist uninstall && zist install")
-		fmt.Printf("  Or source %s and press Ctrl+X to search history\n", zshrcPath)
+	if found && !block.edited() {
+		fmt.Fprintln(out, "ZSH integration already installed")
+		fmt.Fprintf(out, "  Source %s and press Ctrl+X to search history\n", rcPath)
 		return nil
 	}
 
-	newContent := string(content)
-	if len(newContent) > 0 && !strings.HasSuffix(newContent, "\n") {
-		newContent += "\n"
+	newContent := appendIntegration(string(content))
+	if found {
+		fmt.Fprintf(out, "The zist block in %s differs from what this version installs,\n", rcPath)
+		fmt.Fprintln(out, "either because it was edited or because zist was upgraded.")
+		answers := bufio.NewScanner(in)
+		if !askYesNo(answers, out, "Replace it with the current block? [y/N] ", false) {
+			fmt.Fprintln(out, "Left unchanged. Compare it with: zist install --print")
+			return nil
+		}
+		var keep []string
+		if bindings := block.customBindings(); len(bindings) > 0 {
+			fmt.Fprintln(out, "Key bindings you added:")
+			for _, b := range bindings {
+				fmt.Fprintf(out, "  %s\n", b)
+			}
+			if askYesNo(answers, out, "Keep them? [Y/n] ", true) {
+				keep = bindings
+			}
+		}
+		newContent = replaceIntegration(string(content), block, integrationWithBindings(keep))
 	}
-	newContent += "\n" + zshIntegration
 
-	if err := os.WriteFile(zshrcPath, []byte(newContent), 0644); err != nil {
-		return fmt.Errorf("failed to write ~/.zshrc: %w", err)
+	if err := writeRCFile(rcPath, newContent); err != nil {
+		return err
 	}
 
-	fmt.Println("ZSH integration installed")
-	fmt.Println("  Collects from: ~/.histories (default)")
-	fmt.Printf("  Run: source %s\n", zshrcPath)
-	fmt.Println("  Keybindings:")
-	fmt.Println("    Ctrl+G - wizard (natural language → command)")
-	fmt.Println("    Ctrl+X - fuzzy history search")
+	if found {
+		fmt.Fprintln(out, "ZSH integration updated")
+	} else {
+		fmt.Fprintf(out, "ZSH integration installed in %s\n", rcPath)
+		fmt.Fprintln(out, "  Collects from: ~/.histories (default)")
+	}
+	fmt.Fprintf(out, "  Run: source %s\n", rcPath)
+	fmt.Fprintln(out, "  Keybindings:")
+	fmt.Fprintln(out, "    Ctrl+G - wizard (natural language → command)")
+	fmt.Fprintln(out, "    Ctrl+X - fuzzy history search")
 	return nil
 }
 
-func runUninstall(ctx context.Context) error {
-	usr, err := user.Current()
-	if err != nil {
-		return fmt.Errorf("failed to get current user: %w", err)
+// UninstallOptions controls what `zist uninstall` removes
+type UninstallOptions struct {
+	DryRun bool     // Only show what would be removed
+	Purge  []string // Paths to delete as well, see purgeCandidates
+}
+
+func runUninstall(ctx context.Context, rcPath string, opts UninstallOptions, in io.Reader, out io.Writer) error {
+	if err := uninstallIntegration(rcPath, opts.DryRun, out); err != nil {
+		return err
+	}
+	if len(opts.Purge) == 0 {
+		return nil
+	}
+
+	targets := purgeTargets(opts.Purge)
+	if len(targets) == 0 {
+		fmt.Fprintln(out, "No zist data found")
+		return nil
+	}
+	fmt.Fprintln(out, "\nHistory, config and other data zist keeps:")
+	for _, path := range targets {
+		fmt.Fprintf(out, "  %s (%s)\n", path, formatSize(pathSize(path)))
 	}
+	if opts.DryRun {
+		fmt.Fprintln(out, "Dry run, nothing deleted")
+		return nil
+	}
+	if !askYesNo(bufio.NewScanner(in), out, "Delete them permanently? [y/N] ", false) {
+		fmt.Fprintln(out, "Kept your data")
+		return nil
+	}
+	for _, path := range targets {
+		if err := os.RemoveAll(path); err != nil {
+			return fmt.Errorf("failed to delete %s: %w", path, err)
+		}
+	}
+	fmt.Fprintf(out, "Deleted %d path(s)\n", len(targets))
+	return nil
+}
 
-	zshrcPath := filepath.Join(usr.HomeDir, ".zshrc")
+// uninstallIntegration removes the zist block from the rc file
+func uninstallIntegration(rcPath string, dryRun bool, out io.Writer) error {
+	content, err := os.ReadFile(rcPath)
+	if os.IsNotExist(err) {
+		fmt.Fprintf(out, "ZSH integration not found, %s doesn't exist\n", rcPath)
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %w", rcPath, err)
+	}
 
-	content, err := os.ReadFile(zshrcPath)
+	block, found, err := findIntegration(string(content))
 	if err != nil {
-		return fmt.Errorf("failed to read ~/.zshrc: %w", err)
+		return fmt.Errorf("refusing to edit %s: %w\n  Remove the zist block by hand", rcPath, err)
+	}
+	if !found {
+		fmt.Fprintf(out, "ZSH integration not found in %s\n", rcPath)
+		return nil
 	}
 
-	contentStr := string(content)
+	if dryRun {
+		fmt.Fprintf(out, "Would remove ZSH integration from %s\n", rcPath)
+	} else {
+		if err := writeRCFile(rcPath, removeIntegration(string(content), block)); err != nil {
+			return err
+		}
+		fmt.Fprintln(out, "ZSH integration removed")
+	}
+	if bindings := block.customBindings(); len(bindings) > 0 {
+		fmt.Fprintln(out, "  Removed with it, key bindings you had added:")
+		for _, b := range bindings {
+			fmt.Fprintf(out, "    %s\n", b)
+		}
+	}
+	if !dryRun {
+		fmt.Fprintf(out, "  Run: source %s\n", rcPath)
+	}
+	return nil
+}
 
-	// Find the BEGIN and END markers
-	beginMarker := "# BEGIN zist integration"
-	endMarker := "# END zist integration"
+// runWizardExportCache writes the wizard cache to path, or stdout for "-"
+func runWizardExportCache(ctx context.Context, dbPath, path string) error {
+	db, err := InitDB(dbPath)
+	if err != nil {
+		return fmt.Errorf("failed to open database: %w", err)
+	}
+	defer db.Close()
 
-	beginIdx := strings.Index(contentStr, beginMarker)
-	if beginIdx == -1 {
-		fmt.Println("ZSH integration not found")
-		return nil
+	entries, err := ExportWizardCache(ctx, db)
+	if err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(entries, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode wizard cache: %w", err)
 	}
+	data = append(data, '\n')
 
-	endIdx := strings.Index(contentStr, endMarker)
-	if endIdx == -1 {
-		return fmt.Errorf("found BEGIN marker but no END marker - please manually remove zist integration from %s", zshrcPath)
+	if path == "-" {
+		_, err := os.Stdout.Write(data)
+		return err
+	}
+	if err := os.WriteFile(expandTilde(path), data, 0644); err != nil {
+		return fmt.Errorf("failed to write %s: %w", path, err)
 	}
+	fmt.Printf("Exported %d cached mapping(s) to %s\n", len(entries), path)
+	return nil
+}
 
-	// Remove the block including markers and the trailing newline
-	endIdx += len(endMarker)
-	if endIdx < len(contentStr) && contentStr[endIdx] == '\n' {
-		endIdx++
+// runWizardImportCache merges a file written by --export-cache, or stdin
+// for "-", into the wizard cache
+func runWizardImportCache(ctx context.Context, dbPath, path string) error {
+	var data []byte
+	var err error
+	if path == "-" {
+		data, err = io.ReadAll(os.Stdin)
+	} else {
+		data, err = os.ReadFile(expandTilde(path))
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	var entries []WizardCacheEntry
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return fmt.Errorf("failed to parse %s: %w", path, err)
 	}
 
-	// Also remove a leading newline if present
-	if beginIdx > 0 && contentStr[beginIdx-1] == '\n' {
-		beginIdx--
+	db, err := InitDB(dbPath)
+	if err != nil {
+		return fmt.Errorf("failed to open database: %w", err)
 	}
+	defer db.Close()
 
-	newContent := contentStr[:beginIdx] + contentStr[endIdx:]
+	added, updated, err := ImportWizardCache(ctx, db, entries)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Imported %d cached mapping(s): %d new, %d updated, %d unchanged\n",
+		len(entries), added, updated, len(entries)-added-updated)
+	return nil
+}
 
-	// Clean up any double newlines left behind
-	for strings.Contains(newContent, "\n\n\n") {
-		newContent = strings.ReplaceAll(newContent, "\n\n\n", "\n\n")
+// runWizardWarm loads the model on the LLM server without touching the
+// database or caches
+func runWizardWarm(ctx context.Context, config llm.Config) error {
+	client, err := llm.New(config)
+	if err != nil {
+		return fmt.Errorf("failed to create LLM client: %w", err)
+	}
+	took, err := WarmLLM(ctx, client)
+	if err != nil {
+		return err
 	}
+	fmt.Printf("Model %s ready at %s (%s)\n", config.Model, config.BaseURL, took.Round(time.Millisecond))
+	return nil
+}
 
-	if err := os.WriteFile(zshrcPath, []byte(newContent), 0644); err != nil {
-		return fmt.Errorf("failed to write ~/.zshrc: %w", err)
+// llmSettings fills in the LLM backend, endpoint, model and key from
+// ZIST_LLM_BACKEND, ZIST_LLM_API_URL, ZIST_MODEL and ZIST_LLM_API_KEY when
+// the flags are empty, then the backend's defaults
+func llmSettings(c llm.Config) (llm.Config, error) {
+	if c.Backend == "" {
+		c.Backend = os.Getenv("ZIST_LLM_BACKEND")
+	}
+	if c.BaseURL == "" {
+		c.BaseURL = os.Getenv("ZIST_LLM_API_URL")
 	}
+	if c.Model == "" {
+		c.Model = os.Getenv("ZIST_MODEL")
+	}
+	if c.APIKey == "" {
+		c.APIKey = os.Getenv("ZIST_LLM_API_KEY")
+	}
+	return c.WithDefaults()
+}
 
-	fmt.Println("ZSH integration removed")
-	fmt.Printf("  Run: source %s\n", zshrcPath)
-	return nil
+// WizardOptions holds the wizard subcommand's flags
+type WizardOptions struct {
+	DBPath      string
+	Query       string
+	Plan        string // Break this task into steps instead of one command
+	PWD         string // Directory the command is for, "" for the current one
+	LLM         llm.Config
+	LLMCacheTTL time.Duration
+	Retry       RetryPolicy
+	Fallback    llm.Config
+	Ghost       bool   // Print a quick suggestion for the prompt, or nothing
+	CacheQuery  string // With CacheCmd, cache this mapping instead of generating
+	CacheCmd    string
+	ListCache   bool
+	ClearCache  bool
+	// ProjectCache keys cached mappings by the project PWD is in
+	ProjectCache bool
+	ListDir      bool     // Show the LLM the files in PWD
+	EnvHints     []string // Environment the LLM is told about
+	Examples     int      // Similar commands from history to show the LLM
+	Offline      bool     // Only answer from the cache and history
+	JSON         bool
 }
 
-func runWizard(ctx context.Context, dbPath, query, pwd, ollamaURL, model, apiKey string, timeout time.Duration, cacheQuery, cacheCmd string, listCache, clearCache bool) error {
+func runWizard(ctx context.Context, opts WizardOptions) error {
 	// Initialize database
-	db, err := InitDB(dbPath)
+	db, err := InitDB(opts.DBPath)
 	if err != nil {
 		return fmt.Errorf("failed to open database: %w", err)
 	}
 	defer db.Close()
 
 	// Handle cache operations
-	if clearCache {
-		if err := ClearWizardCache(db); err != nil {
+	if opts.ClearCache {
+		if err := ClearWizardCache(ctx, db); err != nil {
+			return err
+		}
+		if err := ClearLLMCache(ctx, db); err != nil {
 			return err
 		}
-		fmt.Println("Wizard cache cleared")
+		fmt.Println("Wizard cache and LLM responses cleared")
 		return nil
 	}
 
-	if listCache {
-		entries, err := ListWizardCache(db, 50)
+	if opts.ListCache {
+		entries, err := ListWizardCache(ctx, db, 50)
 		if err != nil {
 			return err
 		}
@@ -543,6 +5087,9 @@ func runWizard(ctx context.Context, dbPath, query, pwd, ollamaURL, model, apiKey
 		}
 		fmt.Printf("Cached mappings (%d):\n\n", len(entries))
 		for _, e := range entries {
+			if e.Project != "" {
+				fmt.Printf("  Project: %s\n", e.Project)
+			}
 			fmt.Printf("  Query: %s\n", e.QueryOriginal)
 			fmt.Printf("  Command: %s\n", e.Command)
 			fmt.Printf("  Used: %d times\n\n", e.RunCount)
@@ -550,50 +5097,332 @@ func runWizard(ctx context.Context, dbPath, query, pwd, ollamaURL, model, apiKey
 		return nil
 	}
 
-	if cacheQuery != "" && cacheCmd != "" {
-		if err := SetWizardCache(db, cacheQuery, cacheCmd); err != nil {
+	// Default PWD to current directory
+	pwd := opts.PWD
+	if pwd == "" {
+		pwd, _ = os.Getwd()
+	}
+	var project string
+	if opts.ProjectCache {
+		project = ProjectRoot(pwd)
+	}
+
+	if opts.CacheQuery != "" && opts.CacheCmd != "" {
+		if err := SetWizardCache(ctx, db, project, opts.CacheQuery, opts.CacheCmd); err != nil {
 			return err
 		}
-		fmt.Printf("Cached: %q → %s\n", cacheQuery, cacheCmd)
+		if project != "" {
+			fmt.Printf("Cached for %s: %q %s %s\n", project, opts.CacheQuery, symbol("→", "->"), opts.CacheCmd)
+		} else {
+			fmt.Printf("Cached: %q %s %s\n", opts.CacheQuery, symbol("→", "->"), opts.CacheCmd)
+		}
 		return nil
 	}
 
 	// Generate command from query
-	if query == "" {
-		return fmt.Errorf("--query is required (or use --list-cache, --clear-cache)")
+	if opts.Query == "" && opts.Plan == "" {
+		return fmt.Errorf("--query or --plan is required (or use --list-cache, --clear-cache, --export-cache, --import-cache)")
 	}
-
-	// Default PWD to current directory
-	if pwd == "" {
-		pwd, _ = os.Getwd()
+	if opts.Plan != "" && opts.Offline {
+		return fmt.Errorf("--plan needs the LLM, which --offline rules out")
 	}
 
-	// Create LLM client
-	llmConfig := LLMConfig{
-		BaseURL:     ollamaURL,
-		APIKey:      apiKey,
-		Model:       model,
-		Timeout:     timeout,
-		MaxTokens:   500,
-		Temperature: 0.3,
+	model := opts.LLM.Model
+	llmClient, err := newLLMClient(db, opts.LLM, opts.LLMCacheTTL, opts.Retry, opts.Fallback)
+	if err != nil {
+		return err
 	}
 
-	llm, err := NewLLMClient(llmConfig)
-	if err != nil {
-		return fmt.Errorf("failed to create LLM client: %w", err)
+	start, before := time.Now(), llm.UsageOf(llmClient)
+	if opts.Ghost {
+		remote, err := remoteFromEnv(opts.LLM.Timeout)
+		if err != nil {
+			return err
+		}
+		command, err := GhostSuggestion(ctx, db, llmClient, remote, WizardRequest{
+			Query:    opts.Query,
+			PWD:      pwd,
+			Project:  project,
+			ListDir:  opts.ListDir,
+			EnvHints: opts.EnvHints,
+			Examples: opts.Examples,
+			Offline:  opts.Offline,
+		})
+		if err != nil {
+			return err
+		}
+		if command != "" {
+			fmt.Println(command)
+			if remote == nil {
+				logWizardUsage(ctx, db, llmClient, before, start, WizardLogEntry{Model: model, Mode: "ghost", Query: opts.Query, Command: command})
+			}
+		}
+		return nil
 	}
 
 	// Create wizard and generate
-	wizard := NewWizard(db, llm)
+	wizard := NewWizard(db, llmClient)
+	if embedConfig.Model != "" && !opts.Offline {
+		e, config, err := newEmbedder(embedConfig)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: %v\n", err)
+		} else {
+			wizard.UseEmbeddings(e, config.Model)
+		}
+	}
+	if opts.Plan != "" {
+		return runWizardPlan(ctx, db, wizard, model, WizardRequest{
+			Query:    opts.Plan,
+			PWD:      pwd,
+			ListDir:  opts.ListDir,
+			EnvHints: opts.EnvHints,
+		})
+	}
 	resp, err := wizard.Generate(ctx, WizardRequest{
-		Query: query,
-		PWD:   pwd,
+		Query:    opts.Query,
+		PWD:      pwd,
+		Project:  project,
+		ListDir:  opts.ListDir,
+		EnvHints: opts.EnvHints,
+		Examples: opts.Examples,
+		Offline:  opts.Offline,
 	})
 	if err != nil {
 		return err
 	}
+	if sudoRules.Changes() {
+		command, err := sudoRules.Apply(ctx, db, resp.Command)
+		if err != nil {
+			return err
+		}
+		if command != resp.Command {
+			resp.Command, resp.SafetyReport = command, AnalyzeCommand(command)
+		}
+	}
+	logWizardUsage(ctx, db, llmClient, before, start, WizardLogEntry{Model: model, Mode: "query", Source: resp.Source, Query: opts.Query, Command: resp.Command})
 
-	// Output just the command (for shell integration)
+	if opts.JSON {
+		return writeWizardJSON(os.Stdout, resp)
+	}
+	// Output just the command
 	fmt.Println(resp.Command)
 	return nil
 }
+
+// writeWizardJSON writes resp as one line of JSON for the shell widget,
+// which reads it with pattern matching, so nothing is escaped that needn't be
+func writeWizardJSON(w io.Writer, resp *WizardResponse) error {
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+	return enc.Encode(struct {
+		*WizardResponse
+		LatencyMs int64 `json:"latency_ms"`
+	}{resp, resp.Latency.Milliseconds()})
+}
+
+// fallbackLLMConfig returns the fallback endpoint from the flags, or from
+// ZIST_LLM_FALLBACK_API_URL and ZIST_LLM_FALLBACK_API_KEY where unset
+func fallbackLLMConfig(url, key string) llm.Config {
+	if url == "" {
+		url = os.Getenv("ZIST_LLM_FALLBACK_API_URL")
+	}
+	if key == "" {
+		key = os.Getenv("ZIST_LLM_FALLBACK_API_KEY")
+	}
+	return llm.Config{BaseURL: url, APIKey: key}
+}
+
+// runAsk answers question from history and lists the rows the answer cites
+func runAsk(ctx context.Context, dbPath, question, since, until string, rows int, llmConfig, fallback llm.Config, w io.Writer) error {
+	sinceTs, err := parseDateTime(since)
+	if err != nil {
+		return fmt.Errorf("invalid --since: %w", err)
+	}
+	untilTs, err := parseDateTime(until)
+	if err != nil {
+		return fmt.Errorf("invalid --until: %w", err)
+	}
+
+	db, err := InitDB(dbPath)
+	if err != nil {
+		return fmt.Errorf("failed to open database: %w", err)
+	}
+	defer db.Close()
+
+	client, err := newLLMClient(db, llmConfig, DefaultLLMCacheTTL, DefaultRetryPolicy(), fallback)
+	if err != nil {
+		return err
+	}
+
+	start, before := time.Now(), llm.UsageOf(client)
+	answer, err := Ask(ctx, db, client, AskRequest{Question: question, Since: sinceTs, Until: untilTs, Rows: rows})
+	if err != nil {
+		return err
+	}
+	if len(answer.Rows) > 0 {
+		// Logged for `zist stats --wizard` like wizard requests, without their hook
+		spent := llm.UsageOf(client).Sub(before)
+		source := "llm"
+		if spent == (llm.Usage{}) {
+			source = "cache"
+		}
+		LogWizardRequest(ctx, db, WizardLogEntry{Model: llmConfig.Model, Mode: "ask", Source: source, Query: question,
+			PromptTokens: spent.PromptTokens, CompletionTokens: spent.CompletionTokens, LatencyMs: time.Since(start).Milliseconds()})
+	}
+
+	fmt.Fprintln(w, answer.Answer)
+	if len(answer.Citations) == 0 {
+		return nil
+	}
+	fmt.Fprintln(w, "\nSources:")
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	for _, n := range answer.Citations {
+		r := answer.Rows[n-1]
+		fmt.Fprintf(tw, "  [%d]\tID %d\t%s\t%s\n", n, r.ID, FormatTimestamp(r.Timestamp), strings.ReplaceAll(r.FullCommand(), "\n", " "))
+	}
+	return tw.Flush()
+}
+
+// WorkflowsRequest holds the report workflows subcommand's flags
+type WorkflowsRequest struct {
+	DBPath    string
+	Since     string
+	Until     string
+	MinCount  int
+	MinLength int
+	Limit     int
+	JSON      bool
+	LLM       *llm.Config // Names the suggestions when set
+}
+
+// runReportWorkflows prints alias and function suggestions, named by the
+// LLM when one is configured
+func runReportWorkflows(ctx context.Context, req WorkflowsRequest, w io.Writer) error {
+	sinceTs, err := parseDateTime(req.Since)
+	if err != nil {
+		return fmt.Errorf("invalid --since: %w", err)
+	}
+	untilTs, err := parseDateTime(req.Until)
+	if err != nil {
+		return fmt.Errorf("invalid --until: %w", err)
+	}
+
+	db, err := InitDB(req.DBPath)
+	if err != nil {
+		return fmt.Errorf("failed to open database: %w", err)
+	}
+	defer db.Close()
+
+	// Suggesting the name of a program would hide it
+	onPath := func(name string) bool {
+		_, err := exec.LookPath(name)
+		return err == nil
+	}
+	report, err := MineWorkflows(ctx, db, WorkflowOptions{
+		Since:     sinceTs,
+		Until:     untilTs,
+		MinCount:  req.MinCount,
+		MinLength: req.MinLength,
+		Limit:     req.Limit,
+		Taken:     onPath,
+	})
+	if err != nil {
+		return err
+	}
+
+	if req.LLM != nil && len(report.Candidates) > 0 {
+		client, err := newLLMClient(db, *req.LLM, DefaultLLMCacheTTL, DefaultRetryPolicy(), llm.Config{})
+		if err != nil {
+			return err
+		}
+		if err := NameWorkflows(ctx, client, report.Candidates, onPath); err != nil {
+			return err
+		}
+	}
+
+	if req.JSON {
+		if report.Candidates == nil {
+			report.Candidates = []WorkflowCandidate{}
+		}
+		return json.NewEncoder(w).Encode(report)
+	}
+	writeWorkflowsText(w, report)
+	return nil
+}
+
+// newLLMClient creates the client for llmConfig, retrying and pausing the
+// endpoint per policy, falling back to fallback when it has a URL, and
+// caching responses for llmCacheTTL when positive
+func newLLMClient(db *sql.DB, llmConfig llm.Config, llmCacheTTL time.Duration, policy RetryPolicy, fallback llm.Config) (llm.Client, error) {
+	client, err := llm.New(llmConfig)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create LLM client: %w", err)
+	}
+	client = NewResilientLLM(client, db, llmConfig.BaseURL, policy)
+	if fallback.BaseURL != "" {
+		fallbackConfig := llmConfig
+		fallbackConfig.Backend = "openai"
+		fallbackConfig.BaseURL, fallbackConfig.APIKey = fallback.BaseURL, fallback.APIKey
+		fallbackLLM, err := llm.New(fallbackConfig)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create fallback LLM client: %w", err)
+		}
+		client = NewFallbackLLM(client, NewResilientLLM(fallbackLLM, db, fallback.BaseURL, policy))
+	}
+	if llmCacheTTL > 0 {
+		client = NewCachedLLM(client, db, llmConfig.Model, llmCacheTTL)
+	}
+	return client, nil
+}
+
+// logWizardUsage records a wizard request with the tokens client spent on it
+// since before. Without a source, a request that spent no tokens counts as a
+// cache hit. Logging is best effort and never fails the request.
+func logWizardUsage(ctx context.Context, db *sql.DB, client llm.Client, before llm.Usage, start time.Time, e WizardLogEntry) {
+	spent := llm.UsageOf(client).Sub(before)
+	e.PromptTokens, e.CompletionTokens = spent.PromptTokens, spent.CompletionTokens
+	e.LatencyMs = time.Since(start).Milliseconds()
+	if e.Source == "" {
+		e.Source = "llm"
+		if spent == (llm.Usage{}) {
+			e.Source = "cache"
+		}
+	}
+	LogWizardRequest(ctx, db, e)
+
+	event := WizardGenerateEvent{Event: HookWizardGenerate, Mode: e.Mode, Source: e.Source, Model: e.Model,
+		Query: e.Query, Command: e.Command, LatencyMs: e.LatencyMs}
+	if err := runHook(HookWizardGenerate, event); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: %v\n", err)
+	}
+}
+
+// runWizardPlan generates a plan, runs it with the user's confirmation and
+// saves it as a snippet if every step succeeded
+func runWizardPlan(ctx context.Context, db *sql.DB, wizard *Wizard, model string, req WizardRequest) error {
+	start, before := time.Now(), llm.UsageOf(wizard.llm)
+	steps, err := wizard.Plan(ctx, req)
+	if err != nil {
+		return err
+	}
+	logWizardUsage(ctx, db, wizard.llm, before, start, WizardLogEntry{Model: model, Mode: "plan", Source: "llm", Query: req.Query, Command: strings.Join(steps, " && ")})
+
+	fmt.Printf("Plan for %q:\n", req.Query)
+	complete, err := RunPlan(ctx, steps, os.Stdin, os.Stdout, func(ctx context.Context, command string) error {
+		return runShellStep(ctx, req.PWD, command)
+	})
+	if err != nil || !complete {
+		return err
+	}
+
+	snippet := Snippet{
+		Name:        planSnippetName(req.Query),
+		Command:     strings.Join(steps, " && "),
+		Description: "Plan: " + req.Query,
+	}
+	if err := AddSnippet(ctx, db, snippet); err != nil {
+		return err
+	}
+	fmt.Printf("\nDone. Saved as snippet %s\n", snippet.Name)
+	return nil
+}
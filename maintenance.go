@@ -0,0 +1,242 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+// DefaultBackupKeep is how many backups `zist daemon --maintenance` keeps
+const DefaultBackupKeep = 7
+
+// backupTimeLayout is the time in backup names, which sorts as it reads
+const backupTimeLayout = "20060102-150405"
+
+// maintenanceBusyTimeout is how long maintenance waits for readers, such as
+// a search in another shell, to let go of the database
+const maintenanceBusyTimeout = 30 * time.Second
+
+// MaintenanceSchedule is when maintenance runs: every day at Hour:Minute,
+// or with Weekly only on Weekday
+type MaintenanceSchedule struct {
+	Hour, Minute int
+	Weekly       bool
+	Weekday      time.Weekday
+}
+
+// ParseMaintenanceSchedule parses a time of day and how often, in either
+// order: "03:00 daily", "03:00 sunday" or "sun 03:00". Empty and "off"
+// return nil, for no maintenance.
+func ParseMaintenanceSchedule(s string) (*MaintenanceSchedule, error) {
+	fields := strings.Fields(strings.ToLower(s))
+	if len(fields) == 0 || len(fields) == 1 && fields[0] == "off" {
+		return nil, nil
+	}
+	invalid := fmt.Errorf("invalid maintenance schedule %q (use e.g. \"03:00 daily\" or \"03:00 sunday\")", s)
+	if len(fields) != 2 {
+		return nil, invalid
+	}
+	clock, every := fields[0], fields[1]
+	if !strings.Contains(clock, ":") {
+		clock, every = every, clock
+	}
+	t, err := time.Parse("15:04", clock)
+	if err != nil {
+		return nil, invalid
+	}
+	sched := &MaintenanceSchedule{Hour: t.Hour(), Minute: t.Minute()}
+	if every == "daily" {
+		return sched, nil
+	}
+	for d := time.Sunday; d <= time.Saturday; d++ {
+		name := strings.ToLower(d.String())
+		if every == name || every == name[:3] {
+			sched.Weekly, sched.Weekday = true, d
+			return sched, nil
+		}
+	}
+	return nil, invalid
+}
+
+// Next returns the first time after after that maintenance is due, in
+// after's location
+func (s *MaintenanceSchedule) Next(after time.Time) time.Time {
+	next := time.Date(after.Year(), after.Month(), after.Day(), s.Hour, s.Minute, 0, 0, after.Location())
+	for !next.After(after) || s.Weekly && next.Weekday() != s.Weekday {
+		next = time.Date(next.Year(), next.Month(), next.Day()+1, s.Hour, s.Minute, 0, 0, after.Location())
+	}
+	return next
+}
+
+func (s *MaintenanceSchedule) String() string {
+	every := "daily"
+	if s.Weekly {
+		every = strings.ToLower(s.Weekday.String())
+	}
+	return fmt.Sprintf("%02d:%02d %s", s.Hour, s.Minute, every)
+}
+
+// MaintenanceOptions controls what scheduled maintenance does
+type MaintenanceOptions struct {
+	Schedule *MaintenanceSchedule // When it runs, nil for never
+	// RetainYears archives commands older than this many years into
+	// ArchivePath, as `zist archive` does; 0 keeps them all
+	RetainYears int
+	ArchivePath string
+	// BackupDir gets a copy of the database each run, of which the newest
+	// BackupKeep are kept; 0 makes no backups
+	BackupDir  string
+	BackupKeep int
+}
+
+// MaintenanceReport is what one maintenance run did
+type MaintenanceReport struct {
+	Archived   int64  // Commands moved into the archive
+	FTSRebuilt bool   // The FTS index was out of sync and rebuilt
+	Reclaimed  int64  // Bytes VACUUM gave back
+	Backup     string // Path of the backup made, "" if none
+	Removed    int    // Old backups deleted
+}
+
+// RunMaintenance archives commands past the retention, optimizes the FTS
+// index, vacuums db, the database at dbPath, and backs it up. The caller
+// holds the write locks of the database and the archive.
+func RunMaintenance(ctx context.Context, db *sql.DB, dbPath string, opts MaintenanceOptions, now time.Time) (MaintenanceReport, error) {
+	var report MaintenanceReport
+
+	if opts.RetainYears > 0 {
+		if expandTilde(opts.ArchivePath) == expandTilde(dbPath) {
+			return report, fmt.Errorf("the archive database can't be the database itself (%s)", dbPath)
+		}
+		cutoff := now.AddDate(-opts.RetainYears, 0, 0)
+		moved, err := ArchiveCommands(ctx, db, opts.ArchivePath, float64(cutoff.Unix()))
+		if err != nil {
+			return report, err
+		}
+		report.Archived = moved
+	}
+
+	rebuilt, err := EnsureFTS(ctx, db)
+	if err != nil {
+		return report, fmt.Errorf("FTS index of %s is out of sync and could not be rebuilt: %w", dbPath, err)
+	}
+	report.FTSRebuilt = rebuilt
+	if err := OptimizeFTS(ctx, db); err != nil {
+		return report, err
+	}
+
+	before, err := dbFileBytes(ctx, db)
+	if err != nil {
+		return report, err
+	}
+	if _, err := db.ExecContext(ctx, `VACUUM`); err != nil {
+		return report, fmt.Errorf("failed to vacuum database: %w", err)
+	}
+	after, err := dbFileBytes(ctx, db)
+	if err != nil {
+		return report, err
+	}
+	report.Reclaimed = max(before-after, 0)
+
+	if opts.BackupKeep > 0 && opts.BackupDir != "" {
+		backup, err := BackupDB(ctx, db, dbPath, opts.BackupDir, now)
+		if err != nil {
+			return report, err
+		}
+		report.Backup = backup
+		removed, err := pruneBackups(dbPath, opts.BackupDir, opts.BackupKeep)
+		report.Removed = removed
+		if err != nil {
+			return report, err
+		}
+	}
+	return report, nil
+}
+
+// dbFileBytes returns the size of db's main database from its pages
+func dbFileBytes(ctx context.Context, db *sql.DB) (int64, error) {
+	var pages, pageSize int64
+	if err := db.QueryRowContext(ctx, `SELECT * FROM pragma_page_count, pragma_page_size`).Scan(&pages, &pageSize); err != nil {
+		return 0, fmt.Errorf("failed to measure database: %w", err)
+	}
+	return pages * pageSize, nil
+}
+
+// backupPrefix is how backups of the database at dbPath are named, with
+// the time they were made after it
+func backupPrefix(dbPath string) string {
+	return strings.TrimSuffix(filepath.Base(expandTilde(dbPath)), ".db") + "-"
+}
+
+// BackupDB copies db, the database at dbPath, into dir as a consistent
+// snapshot named for now, and returns its path. It is written under a
+// temporary name first, so an interrupted backup never looks complete.
+func BackupDB(ctx context.Context, db *sql.DB, dbPath, dir string, now time.Time) (string, error) {
+	dir = expandTilde(dir)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", fmt.Errorf("failed to create %s: %w", dir, err)
+	}
+	path := filepath.Join(dir, backupPrefix(dbPath)+now.Format(backupTimeLayout)+".db")
+	tmp := path + ".tmp"
+	// VACUUM INTO refuses to overwrite, and a leftover is from a failed run
+	os.Remove(tmp)
+	if _, err := db.ExecContext(ctx, `VACUUM INTO ?`, tmp); err != nil {
+		os.Remove(tmp)
+		return "", fmt.Errorf("failed to back up database: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return "", fmt.Errorf("failed to save backup: %w", err)
+	}
+	return path, nil
+}
+
+// pruneBackups deletes all but the newest keep backups of the database at
+// dbPath in dir, returning how many it deleted
+func pruneBackups(dbPath, dir string, keep int) (int, error) {
+	prefix := backupPrefix(dbPath)
+	backups, err := filepath.Glob(filepath.Join(expandTilde(dir), prefix+"*.db"))
+	if err != nil {
+		return 0, fmt.Errorf("failed to list backups: %w", err)
+	}
+	// Another database's backups may share the prefix, as zist-test.db's
+	// do zist.db's
+	backups = slices.DeleteFunc(backups, func(path string) bool {
+		stamp := strings.TrimSuffix(strings.TrimPrefix(filepath.Base(path), prefix), ".db")
+		_, err := time.Parse(backupTimeLayout, stamp)
+		return err != nil
+	})
+	// Names end in the time they were made, so they sort oldest first
+	slices.Sort(backups)
+	removed := 0
+	for len(backups)-removed > keep {
+		if err := os.Remove(backups[removed]); err != nil {
+			return removed, fmt.Errorf("failed to delete old backup: %w", err)
+		}
+		removed++
+	}
+	return removed, nil
+}
+
+// String summarises the report in one line for the daemon's log
+func (r MaintenanceReport) String() string {
+	parts := []string{fmt.Sprintf("reclaimed %s", formatSize(r.Reclaimed))}
+	if r.Archived > 0 {
+		parts = append(parts, fmt.Sprintf("archived %d command(s)", r.Archived))
+	}
+	if r.FTSRebuilt {
+		parts = append(parts, "rebuilt the out-of-sync FTS index")
+	}
+	if r.Backup != "" {
+		parts = append(parts, "backed up to "+r.Backup)
+	}
+	if r.Removed > 0 {
+		parts = append(parts, fmt.Sprintf("deleted %d old backup(s)", r.Removed))
+	}
+	return strings.Join(parts, ", ")
+}
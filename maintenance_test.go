@@ -0,0 +1,152 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestParseMaintenanceSchedule(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    string // String() of the schedule, "" for none
+		wantErr bool
+	}{
+		{in: "", want: ""},
+		{in: "off", want: ""},
+		{in: "03:00 daily", want: "03:00 daily"},
+		{in: "daily 3:30", want: "03:30 daily"},
+		{in: "23:15 Sunday", want: "23:15 sunday"},
+		{in: "wed 04:00", want: "04:00 wednesday"},
+		{in: "03:00", wantErr: true},
+		{in: "25:00 daily", wantErr: true},
+		{in: "03:00 hourly", wantErr: true},
+		{in: "03:00 daily now", wantErr: true},
+	}
+	for _, tt := range tests {
+		sched, err := ParseMaintenanceSchedule(tt.in)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseMaintenanceSchedule(%q) error = %v, wantErr %v", tt.in, err, tt.wantErr)
+			continue
+		}
+		got := ""
+		if sched != nil {
+			got = sched.String()
+		}
+		if got != tt.want {
+			t.Errorf("ParseMaintenanceSchedule(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestMaintenanceScheduleNext(t *testing.T) {
+	// A Friday
+	after := time.Date(2026, 10, 16, 10, 0, 0, 0, time.UTC)
+	tests := []struct {
+		sched string
+		after time.Time
+		want  time.Time
+	}{
+		{"03:00 daily", after, time.Date(2026, 10, 17, 3, 0, 0, 0, time.UTC)},
+		{"12:00 daily", after, time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC)},
+		{"10:00 daily", after, time.Date(2026, 10, 17, 10, 0, 0, 0, time.UTC)},
+		{"03:00 sunday", after, time.Date(2026, 10, 18, 3, 0, 0, 0, time.UTC)},
+		{"12:00 friday", after, time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC)},
+		{"09:00 friday", after, time.Date(2026, 10, 23, 9, 0, 0, 0, time.UTC)},
+		{"03:00 daily", time.Date(2026, 12, 31, 23, 0, 0, 0, time.UTC), time.Date(2027, 1, 1, 3, 0, 0, 0, time.UTC)},
+	}
+	for _, tt := range tests {
+		sched, err := ParseMaintenanceSchedule(tt.sched)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := sched.Next(tt.after); !got.Equal(tt.want) {
+			t.Errorf("%q.Next(%v) = %v, want %v", tt.sched, tt.after, got, tt.want)
+		}
+	}
+}
+
+func TestRunMaintenance(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	dbPath := filepath.Join(dir, "zist.db")
+	backupDir := filepath.Join(dir, "backups")
+
+	db, err := InitDB(dbPath)
+	if err != nil {
+		t.Fatalf("InitDB() error = %v", err)
+	}
+	defer db.Close()
+
+	now := time.Date(2026, 10, 16, 3, 0, 0, 0, time.UTC)
+	old := float64(now.AddDate(-3, 0, 0).Unix())
+	recent := float64(now.AddDate(0, -1, 0).Unix())
+	commands := []Command{
+		{Source: "/h", Timestamp: old, Command: "git clone old"},
+		{Source: "/h", Timestamp: old + 1, Command: "ls old"},
+		{Source: "/h", Timestamp: recent, Command: "git status"},
+	}
+	if _, _, err := InsertCommands(ctx, db, commands); err != nil {
+		t.Fatalf("InsertCommands() error = %v", err)
+	}
+	// Another database's backup, left alone
+	if err := os.MkdirAll(backupDir, 0700); err != nil {
+		t.Fatal(err)
+	}
+	other := filepath.Join(backupDir, "zist-test-20260101-030000.db")
+	if err := os.WriteFile(other, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	opts := MaintenanceOptions{
+		RetainYears: 2,
+		ArchivePath: filepath.Join(dir, "archive.db"),
+		BackupDir:   backupDir,
+		BackupKeep:  2,
+	}
+	report, err := RunMaintenance(ctx, db, dbPath, opts, now)
+	if err != nil {
+		t.Fatalf("RunMaintenance() error = %v", err)
+	}
+	if report.Archived != 2 {
+		t.Errorf("RunMaintenance() archived %d command(s), want 2", report.Archived)
+	}
+	want := filepath.Join(backupDir, "zist-20261016-030000.db")
+	if report.Backup != want {
+		t.Errorf("RunMaintenance() backup = %q, want %q", report.Backup, want)
+	}
+
+	backup, err := OpenReadOnlyDB(report.Backup)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var count int
+	err = backup.QueryRow("SELECT COUNT(*) FROM commands").Scan(&count)
+	backup.Close()
+	if err != nil || count != 1 {
+		t.Errorf("backup holds %d command(s) (err %v), want 1", count, err)
+	}
+	if err := CheckFTS(ctx, db); err != nil {
+		t.Errorf("CheckFTS() after maintenance error = %v", err)
+	}
+
+	// Only the newest BackupKeep backups stay
+	for _, next := range []time.Time{now.AddDate(0, 0, 1), now.AddDate(0, 0, 2)} {
+		if report, err = RunMaintenance(ctx, db, dbPath, opts, next); err != nil {
+			t.Fatalf("RunMaintenance() error = %v", err)
+		}
+	}
+	if report.Removed != 1 {
+		t.Errorf("RunMaintenance() removed %d backup(s), want 1", report.Removed)
+	}
+	for _, name := range []string{"zist-20261017-030000.db", "zist-20261018-030000.db", "zist-test-20260101-030000.db"} {
+		if _, err := os.Stat(filepath.Join(backupDir, name)); err != nil {
+			t.Errorf("backup %s missing: %v", name, err)
+		}
+	}
+	if _, err := os.Stat(want); !os.IsNotExist(err) {
+		t.Errorf("oldest backup %s still there", want)
+	}
+}
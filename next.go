@@ -0,0 +1,175 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"database/sql"
+	"fmt"
+	"io"
+	"slices"
+)
+
+// Limits on what zist next looks at
+const (
+	DefaultNextLimit = 5
+	nextWindow       = 3   // Commands after a run that count as following it
+	nextRuns         = 500 // Most recent runs of a command looked at
+)
+
+// NextSuggestion is a command that often followed another in the same
+// session
+type NextSuggestion struct {
+	ID        int64   `json:"id"` // Its latest run after the command
+	Command   string  `json:"command"`
+	Source    string  `json:"source"`
+	Timestamp float64 `json:"timestamp"`
+	Time      string  `json:"time,omitempty"` // Timestamp in RFC 3339, set for --json
+	Count     int     `json:"count"`          // Runs of the command it followed
+	Score     float64 `json:"score"`          // Count weighted towards following right away
+}
+
+// SuggestNext returns up to limit commands most often run within
+// nextWindow commands after the latest runs of command, in the same history
+// and session, best first. Following right away counts more than two
+// commands later. It also returns how many runs of command it looked at.
+func SuggestNext(ctx context.Context, db *sql.DB, command string, limit int) ([]NextSuggestion, int, error) {
+	if limit <= 0 {
+		limit = DefaultNextLimit
+	}
+	rows, err := db.QueryContext(ctx, `SELECT source, timestamp FROM commands WHERE command = ?
+		ORDER BY timestamp DESC LIMIT ?`, command, nextRuns)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to find runs: %w", err)
+	}
+	type run struct {
+		source    string
+		timestamp float64
+	}
+	var runs []run
+	for rows.Next() {
+		var r run
+		if err := rows.Scan(&r.source, &r.timestamp); err != nil {
+			rows.Close()
+			return nil, 0, fmt.Errorf("failed to scan run: %w", err)
+		}
+		runs = append(runs, r)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, 0, fmt.Errorf("error iterating runs: %w", err)
+	}
+
+	// The timestamp range keeps each lookup on the timestamp index
+	after, err := db.PrepareContext(ctx, `SELECT rowid, command, timestamp FROM commands
+		WHERE source = ? AND timestamp > ? AND timestamp <= ?
+		ORDER BY timestamp LIMIT ?`)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to prepare statement: %w", err)
+	}
+	defer after.Close()
+
+	byCommand := make(map[string]*NextSuggestion)
+	for _, r := range runs {
+		rows, err := after.QueryContext(ctx, r.source, r.timestamp, r.timestamp+timelineSessionGap.Seconds(), nextWindow)
+		if err != nil {
+			return nil, 0, fmt.Errorf("failed to read following commands: %w", err)
+		}
+		seen := make(map[string]bool)
+		prev := r.timestamp
+		for position := 1; rows.Next(); position++ {
+			var s NextSuggestion
+			if err := rows.Scan(&s.ID, &s.Command, &s.Timestamp); err != nil {
+				rows.Close()
+				return nil, 0, fmt.Errorf("failed to scan command: %w", err)
+			}
+			// Running it again, or a long break, ends what followed this run
+			if s.Command == command || s.Timestamp-prev > timelineSessionGap.Seconds() {
+				break
+			}
+			prev = s.Timestamp
+			if seen[s.Command] {
+				continue
+			}
+			seen[s.Command] = true
+
+			found, ok := byCommand[s.Command]
+			if !ok {
+				s.Source = r.source
+				found = &s
+				byCommand[s.Command] = found
+			} else if s.Timestamp > found.Timestamp {
+				found.ID, found.Source, found.Timestamp = s.ID, r.source, s.Timestamp
+			}
+			found.Count++
+			found.Score += 1 / float64(position)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return nil, 0, fmt.Errorf("error iterating following commands: %w", err)
+		}
+	}
+
+	suggestions := make([]NextSuggestion, 0, len(byCommand))
+	for _, s := range byCommand {
+		suggestions = append(suggestions, *s)
+	}
+	slices.SortFunc(suggestions, func(a, b NextSuggestion) int {
+		return cmp.Or(cmp.Compare(b.Score, a.Score), cmp.Compare(b.Count, a.Count), cmp.Compare(b.Timestamp, a.Timestamp))
+	})
+	if len(suggestions) > limit {
+		suggestions = suggestions[:limit]
+	}
+	return suggestions, len(runs), nil
+}
+
+// LatestCommand returns the most recently run command, or "" for an empty
+// history
+func LatestCommand(ctx context.Context, db *sql.DB) (string, error) {
+	var command string
+	err := db.QueryRowContext(ctx, `SELECT command FROM commands
+		ORDER BY CAST(timestamp AS INTEGER) DESC, seq DESC LIMIT 1`).Scan(&command)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to read latest command: %w", err)
+	}
+	return command, nil
+}
+
+// writeNextText prints suggestions with how many of the runs each followed
+func writeNextText(w io.Writer, command string, runs int, suggestions []NextSuggestion, color bool) {
+	shown, _ := truncateCommand(command, 60)
+	if runs == 0 {
+		fmt.Fprintf(w, "%q isn't in the history\n", shown)
+		return
+	}
+	if len(suggestions) == 0 {
+		fmt.Fprintf(w, "Nothing followed %q in its %d run(s) yet\n", shown, runs)
+		return
+	}
+	fmt.Fprintf(w, "After %q (%d run(s) looked at):\n", shown, runs)
+	for _, s := range suggestions {
+		text := s.Command
+		if color {
+			text = colorSource(text, s.Source)
+		}
+		fmt.Fprintf(w, "  %3d%%  %s\n", 100*s.Count/runs, text)
+	}
+}
+
+// nextRecords turns suggestions into picker rows, each badged with how
+// many of the runs it followed
+func nextRecords(suggestions []NextSuggestion) []SearchResult {
+	records := make([]SearchResult, len(suggestions))
+	for i, s := range suggestions {
+		records[i] = SearchResult{
+			ID:        s.ID,
+			Command:   s.Command,
+			Source:    s.Source,
+			Timestamp: s.Timestamp,
+			Badge:     fmt.Sprintf("%d%s", s.Count, symbol("×", "x")),
+		}
+	}
+	return records
+}
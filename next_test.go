@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+)
+
+func TestSuggestNext(t *testing.T) {
+	ctx := context.Background()
+	db, err := InitDB(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("InitDB() error = %v", err)
+	}
+	defer db.Close()
+
+	commands := []Command{
+		{Source: "/laptop", Timestamp: 1000, Command: "make build"},
+		{Source: "/laptop", Timestamp: 1010, Command: "make test"},
+		{Source: "/laptop", Timestamp: 1020, Command: "git push"},
+		{Source: "/laptop", Timestamp: 2000, Command: "make build"},
+		{Source: "/laptop", Timestamp: 2010, Command: "make test"},
+		{Source: "/laptop", Timestamp: 2020, Command: "make test"},
+		// Running it again ends the run before
+		{Source: "/laptop", Timestamp: 3000, Command: "make build"},
+		{Source: "/laptop", Timestamp: 3010, Command: "make build"},
+		{Source: "/laptop", Timestamp: 3020, Command: "ls"},
+		// Too long after to have followed it
+		{Source: "/server", Timestamp: 4000, Command: "make build"},
+		{Source: "/server", Timestamp: 4000 + 2*timelineSessionGap.Seconds(), Command: "reboot"},
+		// Within the same second
+		{Source: "/server", Timestamp: 5000, Command: "make build"},
+		{Source: "/server", Timestamp: 5000.5, Command: "make test"},
+	}
+	if _, _, err := InsertCommands(ctx, db, commands); err != nil {
+		t.Fatal(err)
+	}
+
+	suggestions, runs, err := SuggestNext(ctx, db, "make build", 0)
+	if err != nil {
+		t.Fatalf("SuggestNext() error = %v", err)
+	}
+	if runs != 6 {
+		t.Errorf("SuggestNext() looked at %d runs, want 6", runs)
+	}
+	want := []struct {
+		command string
+		count   int
+	}{{"make test", 3}, {"ls", 1}, {"git push", 1}}
+	if len(suggestions) != len(want) {
+		t.Fatalf("SuggestNext() = %+v, want %v", suggestions, want)
+	}
+	for i, w := range want {
+		if suggestions[i].Command != w.command || suggestions[i].Count != w.count {
+			t.Errorf("suggestion %d = %+v, want %s after %d run(s)", i, suggestions[i], w.command, w.count)
+		}
+	}
+	if s := suggestions[0]; s.Source != "/server" || s.Timestamp != 5000.5 {
+		t.Errorf("SuggestNext() top suggestion = %+v, want its latest run", s)
+	}
+
+	if suggestions, _, _ := SuggestNext(ctx, db, "make build", 1); len(suggestions) != 1 {
+		t.Errorf("SuggestNext(limit 1) = %+v", suggestions)
+	}
+	if suggestions, runs, err := SuggestNext(ctx, db, "never ran", 0); err != nil || runs != 0 || len(suggestions) != 0 {
+		t.Errorf("SuggestNext(never ran) = %+v, %d, %v", suggestions, runs, err)
+	}
+
+	latest, err := LatestCommand(ctx, db)
+	if err != nil || latest != "reboot" {
+		t.Errorf("LatestCommand() = %q, %v, want reboot", latest, err)
+	}
+}
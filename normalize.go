@@ -0,0 +1,166 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"slices"
+	"strings"
+	"unicode"
+)
+
+// normalizeVersion changes whenever NormalizeQuery would key an existing
+// query differently, so caches keyed the old way get rekeyed
+const normalizeVersion = 2
+
+// DefaultCacheStopwords are dropped from wizard queries before cache lookups,
+// so "show me the pods" finds "show pods"
+var DefaultCacheStopwords = []string{"a", "an", "the", "please", "pls", "me", "my"}
+
+// QueryNormalizer turns wizard queries into cache keys
+type QueryNormalizer struct {
+	Stopwords   []string
+	Singularize bool // Key simple plurals by their singular (files → file)
+}
+
+// cacheNormalizer is set from --cache-stopwords and --cache-singular
+var cacheNormalizer = QueryNormalizer{Stopwords: DefaultCacheStopwords}
+
+// NormalizeQuery returns the cache key for query, see QueryNormalizer.Normalize
+func NormalizeQuery(query string) string {
+	return cacheNormalizer.Normalize(query)
+}
+
+// Normalize lowercases query, strips punctuation around words, drops
+// stopwords and collapses whitespace. Punctuation inside a word, and dashes,
+// dots, slashes and the like that start one, are kept since they tend to
+// matter to the command (main.go, -la, ./run.sh). A query made only of
+// stopwords keeps them rather than becoming an empty key.
+func (n QueryNormalizer) Normalize(query string) string {
+	var words, kept []string
+	for _, word := range strings.Fields(strings.ToLower(query)) {
+		word = strings.TrimLeftFunc(word, func(r rune) bool {
+			return unicode.IsPunct(r) && !strings.ContainsRune("-./~*$#@%_&", r)
+		})
+		word = strings.TrimRightFunc(word, func(r rune) bool {
+			return unicode.IsPunct(r) && !strings.ContainsRune("/*%_", r)
+		})
+		if word == "" {
+			continue
+		}
+		if n.Singularize {
+			word = singular(word)
+		}
+		words = append(words, word)
+		if !slices.Contains(n.Stopwords, word) {
+			kept = append(kept, word)
+		}
+	}
+	if len(kept) == 0 {
+		kept = words
+	}
+	return strings.Join(kept, " ")
+}
+
+// singular strips a simple English plural ending from a word made only of
+// letters. It only has to map both forms to the same key, not get the
+// singular right.
+func singular(word string) string {
+	if len(word) <= 3 || strings.IndexFunc(word, func(r rune) bool { return !unicode.IsLetter(r) }) >= 0 {
+		return word
+	}
+	switch {
+	case strings.HasSuffix(word, "ies"):
+		return strings.TrimSuffix(word, "ies") + "y"
+	case strings.HasSuffix(word, "sses"), strings.HasSuffix(word, "xes"), strings.HasSuffix(word, "zes"),
+		strings.HasSuffix(word, "ches"), strings.HasSuffix(word, "shes"):
+		return strings.TrimSuffix(word, "es")
+	case strings.HasSuffix(word, "ss"), strings.HasSuffix(word, "us"), strings.HasSuffix(word, "is"):
+		return word
+	case strings.HasSuffix(word, "s"):
+		return strings.TrimSuffix(word, "s")
+	}
+	return word
+}
+
+// fingerprint identifies the keys n produces
+func (n QueryNormalizer) fingerprint() string {
+	stopwords := slices.Clone(n.Stopwords)
+	slices.Sort(stopwords)
+	return fmt.Sprintf("v%d singular=%t stopwords=%s", normalizeVersion, n.Singularize, strings.Join(stopwords, ","))
+}
+
+// rekeyWizardCache recomputes every cache key when the normalizer changed
+// since the database was last opened. Queries that now share a key are
+// merged: the most recently used command wins and run counts add up.
+func rekeyWizardCache(db *sql.DB, n QueryNormalizer) error {
+	var stored string
+	err := db.QueryRow(`SELECT value FROM meta WHERE key = 'wizard_cache_keys'`).Scan(&stored)
+	if err != nil && err != sql.ErrNoRows {
+		return fmt.Errorf("failed to read wizard cache keys: %w", err)
+	}
+	if stored == n.fingerprint() {
+		return nil
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.Query(`SELECT project, query_normalized, query_original, command, run_count, last_used, created_at
+		FROM wizard_cache ORDER BY last_used DESC`)
+	if err != nil {
+		return fmt.Errorf("failed to read wizard cache: %w", err)
+	}
+	type key struct{ project, query string }
+	var order []key
+	merged := map[key]*WizardCacheEntry{}
+	changed := false
+	for rows.Next() {
+		var e WizardCacheEntry
+		if err := rows.Scan(&e.Project, &e.QueryNormalized, &e.QueryOriginal, &e.Command,
+			&e.RunCount, &e.LastUsed, &e.CreatedAt); err != nil {
+			rows.Close()
+			return fmt.Errorf("failed to scan wizard cache entry: %w", err)
+		}
+		normalized := n.Normalize(e.QueryOriginal)
+		changed = changed || normalized != e.QueryNormalized
+		e.QueryNormalized = normalized
+
+		k := key{e.Project, normalized}
+		if m, ok := merged[k]; ok {
+			// Rows come most recently used first, so m keeps its command
+			m.RunCount += e.RunCount
+			m.CreatedAt = min(m.CreatedAt, e.CreatedAt)
+			changed = true
+			continue
+		}
+		merged[k] = &e
+		order = append(order, k)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to read wizard cache: %w", err)
+	}
+
+	if changed {
+		if _, err := tx.Exec(`DELETE FROM wizard_cache`); err != nil {
+			return fmt.Errorf("failed to rekey wizard cache: %w", err)
+		}
+		for _, k := range order {
+			e := merged[k]
+			if _, err := tx.Exec(`INSERT INTO wizard_cache (project, query_normalized, query_original, command, run_count, last_used, created_at)
+				VALUES (?, ?, ?, ?, ?, ?, ?)`,
+				e.Project, e.QueryNormalized, e.QueryOriginal, e.Command, e.RunCount, e.LastUsed, e.CreatedAt); err != nil {
+				return fmt.Errorf("failed to rekey wizard cache: %w", err)
+			}
+		}
+	}
+
+	if _, err := tx.Exec(`INSERT INTO meta (key, value) VALUES ('wizard_cache_keys', ?)
+		ON CONFLICT(key) DO UPDATE SET value = excluded.value`, n.fingerprint()); err != nil {
+		return fmt.Errorf("failed to record wizard cache keys: %w", err)
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+)
+
+func TestNormalize(t *testing.T) {
+	tests := []struct {
+		normalizer QueryNormalizer
+		query      string
+		want       string
+	}{
+		{QueryNormalizer{}, "  List   Pods ", "list pods"},
+		{QueryNormalizer{}, "list pods?", "list pods"},
+		{QueryNormalizer{}, `"list" pods, please!`, "list pods please"},
+		{QueryNormalizer{}, "count lines in main.go", "count lines in main.go"},
+		{QueryNormalizer{}, "ls -la in ./src/ ?", "ls -la in ./src/"},
+		{QueryNormalizer{Stopwords: DefaultCacheStopwords}, "Show me the pods, please", "show pods"},
+		{QueryNormalizer{Stopwords: DefaultCacheStopwords}, "the", "the"},
+		{QueryNormalizer{Singularize: true}, "delete files", "delete file"},
+		{QueryNormalizer{Singularize: true}, "list directories", "list directory"},
+		{QueryNormalizer{Singularize: true}, "kill processes", "kill process"},
+		{QueryNormalizer{Singularize: true}, "git status", "git status"},
+		{QueryNormalizer{Singularize: true}, "find *.logs", "find *.logs"},
+	}
+	for _, tt := range tests {
+		if got := tt.normalizer.Normalize(tt.query); got != tt.want {
+			t.Errorf("%+v.Normalize(%q) = %q, want %q", tt.normalizer, tt.query, got, tt.want)
+		}
+	}
+}
+
+func TestRekeyWizardCache(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "test.db")
+	db, err := InitDB(dbPath)
+	if err != nil {
+		t.Fatalf("InitDB() error = %v", err)
+	}
+	defer db.Close()
+
+	// Keyed the way older versions did
+	for _, row := range []struct {
+		key, query, command string
+		runs                int
+		lastUsed            float64
+	}{
+		{"list the pods", "list the pods", "kubectl get pods", 2, 100},
+		{"list pods?", "List pods?", "kubectl get pods -A", 3, 200},
+		{"disk usage", "disk usage", "du -sh *", 1, 50},
+	} {
+		if _, err := db.Exec(`INSERT INTO wizard_cache (project, query_normalized, query_original, command, run_count, last_used, created_at)
+			VALUES ('', ?, ?, ?, ?, ?, ?)`, row.key, row.query, row.command, row.runs, row.lastUsed, row.lastUsed); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := db.Exec(`DELETE FROM meta`); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := rekeyWizardCache(db, QueryNormalizer{Stopwords: DefaultCacheStopwords}); err != nil {
+		t.Fatalf("rekeyWizardCache() error = %v", err)
+	}
+	entry, err := GetWizardCache(context.Background(), db, "", "List the pods!")
+	if err != nil || entry == nil || entry.Command != "kubectl get pods -A" || entry.RunCount != 5 {
+		t.Errorf("merged entry = %+v, %v, want the most recent command run 5 times", entry, err)
+	}
+	entries, _ := ListWizardCache(context.Background(), db, 10)
+	if len(entries) != 2 {
+		t.Errorf("ListWizardCache() = %+v, want 2 entries after merging", entries)
+	}
+
+	// Changing the settings rekeys again
+	if err := rekeyWizardCache(db, QueryNormalizer{}); err != nil {
+		t.Fatalf("rekeyWizardCache() error = %v", err)
+	}
+	var key string
+	if err := db.QueryRow(`SELECT query_normalized FROM wizard_cache WHERE command = 'kubectl get pods -A'`).Scan(&key); err != nil || key != "list pods" {
+		t.Errorf("key = %q, %v, want %q", key, err, "list pods")
+	}
+}
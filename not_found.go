@@ -0,0 +1,103 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"database/sql"
+	"slices"
+	"strings"
+	"time"
+)
+
+// DefaultNotFoundLimit is how many suggestions `zist not-found` prints
+const DefaultNotFoundLimit = 3
+
+// NotFoundSuggestion is a command from history that a mistyped one likely
+// meant
+type NotFoundSuggestion struct {
+	Command  string // The typed command line with the program corrected
+	Binary   string
+	Distance int   // Edits from the typed program to Binary
+	Runs     int64 // Commands in history running Binary
+}
+
+// SuggestForNotFound returns up to limit programs run before that are a
+// typo or two away from the program of words, a command line the shell
+// couldn't find, closest and most run first. Short names only get one edit,
+// so `ls` doesn't suggest every two-letter program.
+func SuggestForNotFound(ctx context.Context, db *sql.DB, words []string, limit int) ([]NotFoundSuggestion, error) {
+	if len(words) == 0 || words[0] == "" {
+		return nil, nil
+	}
+	typed := words[0]
+	maxDistance := 2
+	if len(typed) <= 4 {
+		maxDistance = 1
+	}
+
+	stats, err := GetBinaryStats(ctx, db, time.Now(), "", 0)
+	if err != nil {
+		return nil, err
+	}
+
+	rest := make([]string, 0, len(words)-1)
+	for _, w := range words[1:] {
+		rest = append(rest, shellWord(w))
+	}
+	var suggestions []NotFoundSuggestion
+	for _, b := range stats.Binaries {
+		if b.Binary == typed || strings.Contains(b.Binary, "/") {
+			continue
+		}
+		if d := editDistance(typed, b.Binary); d <= maxDistance {
+			suggestions = append(suggestions, NotFoundSuggestion{
+				Command:  strings.Join(append([]string{b.Binary}, rest...), " "),
+				Binary:   b.Binary,
+				Distance: d,
+				Runs:     b.Total,
+			})
+		}
+	}
+	slices.SortStableFunc(suggestions, func(a, b NotFoundSuggestion) int {
+		return cmp.Or(cmp.Compare(a.Distance, b.Distance), cmp.Compare(b.Runs, a.Runs))
+	})
+	if limit > 0 && len(suggestions) > limit {
+		suggestions = suggestions[:limit]
+	}
+	return suggestions, nil
+}
+
+// editDistance counts the insertions, deletions, substitutions and swaps of
+// neighbouring bytes that turn a into b, so `gti` is one edit from `git`
+func editDistance(a, b string) int {
+	// Three rows of the optimal string alignment matrix
+	prev2 := make([]int, len(b)+1)
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+			if i > 1 && j > 1 && a[i-1] == b[j-2] && a[i-2] == b[j-1] {
+				cur[j] = min(cur[j], prev2[j-2]+1)
+			}
+		}
+		prev2, prev, cur = prev, cur, prev2
+	}
+	return prev[len(b)]
+}
+
+// shellWord quotes s only if the shell would otherwise split or expand it
+func shellWord(s string) string {
+	if s == "" || strings.ContainsAny(s, " \t\n'\"\\$`|&;<>()*?[]{}~#!") {
+		return shellQuote(s)
+	}
+	return s
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestEditDistance(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"git", "git", 0},
+		{"gti", "git", 1},
+		{"gt", "git", 1},
+		{"gitt", "git", 1},
+		{"got", "git", 1},
+		{"dokcer", "docker", 1},
+		{"kubeclt", "kubectl", 1},
+		{"sl", "ls", 1},
+		{"", "ls", 2},
+		{"make", "cargo", 4},
+	}
+	for _, tt := range tests {
+		if got := editDistance(tt.a, tt.b); got != tt.want {
+			t.Errorf("editDistance(%q, %q) = %d, want %d", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+func TestSuggestForNotFound(t *testing.T) {
+	ctx := context.Background()
+	db, err := InitDB(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("InitDB() error = %v", err)
+	}
+	defer db.Close()
+
+	var commands []Command
+	for i, c := range []string{"git status", "git log", "git push", "gist create", "docker ps", "dig example.com", "ls -la", "./deploy.sh"} {
+		commands = append(commands, Command{Source: "/h", Timestamp: float64(1700000000 + i), Command: c})
+	}
+	if _, _, err := InsertCommands(ctx, db, commands); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		words []string
+		want  []string
+	}{
+		{[]string{"gti", "commit", "-m", "fix it"}, []string{"git commit -m 'fix it'"}},
+		{[]string{"dokcer", "ps"}, []string{"docker ps"}},
+		{[]string{"sl"}, []string{"ls"}},
+		{[]string{"gits"}, []string{"git", "gist"}},
+		{[]string{"terraform"}, nil},
+		{nil, nil},
+	}
+	for _, tt := range tests {
+		suggestions, err := SuggestForNotFound(ctx, db, tt.words, 2)
+		if err != nil {
+			t.Fatalf("SuggestForNotFound(%q) error = %v", tt.words, err)
+		}
+		var got []string
+		for _, s := range suggestions {
+			got = append(got, s.Command)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("SuggestForNotFound(%q) = %q, want %q", tt.words, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// GetCommandByID looks up a command in the main database by its ID, along
+// with its note and links. Returns nil if there is no such command.
+func GetCommandByID(ctx context.Context, db *sql.DB, id int64) (*SearchResult, error) {
+	row := db.QueryRowContext(ctx, `SELECT c.rowid, c.command, c.source, c.timestamp, c.env_prefix, COALESCE(n.note, ''), `+linksColumn("main", "c")+`
+		FROM commands c LEFT JOIN notes n ON n.source = c.source AND n.timestamp = c.timestamp
+		WHERE c.rowid = ?`, id)
+
+	var result SearchResult
+	var links string
+	err := row.Scan(&result.ID, &result.Command, &result.Source, &result.Timestamp, &result.EnvPrefix, &result.Note, &links)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get command: %w", err)
+	}
+	result.Links = splitLinks(links)
+
+	return &result, nil
+}
+
+// SetNote attaches a note to the command, replacing any existing note
+func SetNote(ctx context.Context, db *sql.DB, source string, timestamp float64, note string) error {
+	now := float64(time.Now().Unix())
+
+	_, err := db.ExecContext(ctx, `INSERT INTO notes (source, timestamp, note, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT(source, timestamp) DO UPDATE SET
+			note = excluded.note,
+			updated_at = excluded.updated_at`,
+		source, timestamp, note, now, now)
+	if err != nil {
+		return fmt.Errorf("failed to set note: %w", err)
+	}
+
+	return nil
+}
+
+// DeleteNote removes the command's note, reporting whether it had one
+func DeleteNote(ctx context.Context, db *sql.DB, source string, timestamp float64) (bool, error) {
+	result, err := db.ExecContext(ctx, `DELETE FROM notes WHERE source = ? AND timestamp = ?`, source, timestamp)
+	if err != nil {
+		return false, fmt.Errorf("failed to delete note: %w", err)
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to get rows affected: %w", err)
+	}
+	return n > 0, nil
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+)
+
+func TestNotes(t *testing.T) {
+	db, err := InitDB(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("InitDB() error = %v", err)
+	}
+	defer db.Close()
+
+	commands := []Command{
+		{Source: "/file1", Timestamp: 1000.0, Command: "openssl s_client -connect host:443"},
+		{Source: "/file1", Timestamp: 1001.0, Command: "ls -la"},
+	}
+	if _, _, err := InsertCommands(context.Background(), db, commands); err != nil {
+		t.Fatalf("InsertCommands() error = %v", err)
+	}
+
+	results, err := SearchCommands(context.Background(), db, SearchOptions{Query: "openssl"})
+	if err != nil || len(results) != 1 {
+		t.Fatalf("SearchCommands('openssl') = %+v, %v, want one result", results, err)
+	}
+	id := results[0].ID
+
+	cmd, err := GetCommandByID(context.Background(), db, id)
+	if err != nil || cmd == nil || cmd.Command != commands[0].Command {
+		t.Fatalf("GetCommandByID(%d) = %+v, %v, want %q", id, cmd, err, commands[0].Command)
+	}
+
+	if err := SetNote(context.Background(), db, cmd.Source, cmd.Timestamp, "this fixed the TLS bug"); err != nil {
+		t.Fatalf("SetNote() error = %v", err)
+	}
+
+	t.Run("search matches note text", func(t *testing.T) {
+		results, err := SearchCommands(context.Background(), db, SearchOptions{Query: "TLS"})
+		if err != nil {
+			t.Fatalf("SearchCommands() error = %v", err)
+		}
+		if len(results) != 1 || results[0].ID != id || results[0].Note != "this fixed the TLS bug" {
+			t.Errorf("SearchCommands('TLS') = %+v, want the noted command with its note", results)
+		}
+	})
+
+	t.Run("note replaced", func(t *testing.T) {
+		if err := SetNote(context.Background(), db, cmd.Source, cmd.Timestamp, "cert chain check"); err != nil {
+			t.Fatalf("SetNote() error = %v", err)
+		}
+		results, err := SearchCommands(context.Background(), db, SearchOptions{Query: "TLS"})
+		if err != nil || len(results) != 0 {
+			t.Errorf("SearchCommands('TLS') after replace = %+v, %v, want none", results, err)
+		}
+		got, _ := GetCommandByID(context.Background(), db, id)
+		if got == nil || got.Note != "cert chain check" {
+			t.Errorf("GetCommandByID() note = %+v, want replaced note", got)
+		}
+	})
+
+	t.Run("note deleted", func(t *testing.T) {
+		deleted, err := DeleteNote(context.Background(), db, cmd.Source, cmd.Timestamp)
+		if err != nil || !deleted {
+			t.Fatalf("DeleteNote() = %v, %v, want true", deleted, err)
+		}
+		results, err := SearchCommands(context.Background(), db, SearchOptions{Query: "chain"})
+		if err != nil || len(results) != 0 {
+			t.Errorf("SearchCommands('chain') after delete = %+v, %v, want none", results, err)
+		}
+	})
+
+	t.Run("unknown id", func(t *testing.T) {
+		got, err := GetCommandByID(context.Background(), db, 9999)
+		if err != nil || got != nil {
+			t.Errorf("GetCommandByID(9999) = %+v, %v, want nil", got, err)
+		}
+	})
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/tchaudhry91/zist/client"
+)
+
+func TestOpenAPISpecCoversRoutes(t *testing.T) {
+	var spec struct {
+		OpenAPI string                                `json:"openapi"`
+		Paths   map[string]map[string]json.RawMessage `json:"paths"`
+	}
+	if err := json.Unmarshal(openAPISpec, &spec); err != nil {
+		t.Fatalf("spec is not valid JSON: %v", err)
+	}
+	if !strings.HasPrefix(spec.OpenAPI, "3.") {
+		t.Errorf("openapi = %q, want 3.x", spec.OpenAPI)
+	}
+
+	documented := map[string]bool{}
+	for path, ops := range spec.Paths {
+		for method := range ops {
+			documented[strings.ToUpper(method)+" "+path] = true
+		}
+	}
+
+	routed := map[string]bool{}
+	for _, r := range (&Server{}).routes() {
+		routed[r.pattern] = true
+		if !documented[r.pattern] {
+			t.Errorf("route %q is missing from api/openapi.json", r.pattern)
+		}
+	}
+	for op := range documented {
+		if !routed[op] {
+			t.Errorf("api/openapi.json documents %q but the server has no such route", op)
+		}
+	}
+}
+
+func TestOpenAPIServed(t *testing.T) {
+	srv, _ := newTestServer(t)
+
+	resp, err := http.Get(srv.URL + "/openapi.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("status = %d, want 200", resp.StatusCode)
+	}
+	if ct := resp.Header.Get("Content-Type"); ct != "application/json" {
+		t.Errorf("Content-Type = %q", ct)
+	}
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(body, openAPISpec) {
+		t.Error("served spec differs from the embedded one")
+	}
+}
+
+// TestClientAgainstServer checks the Go client and the server agree on the
+// wire format
+func TestClientAgainstServer(t *testing.T) {
+	srv, tokens := newTestServer(t)
+	ctx := context.Background()
+	alice := client.New(srv.URL, tokens["alice"])
+	bob := client.New(srv.URL, tokens["bob"])
+
+	res, err := alice.Push(ctx, []client.Command{
+		{Source: "laptop:zsh", Timestamp: 100, Command: "git status"},
+		{Source: "laptop:zsh", Timestamp: 200, Command: "AWS_PROFILE=prod aws s3 ls", EnvPrefix: "AWS_PROFILE=prod"},
+	})
+	if err != nil || res.Inserted != 2 {
+		t.Fatalf("Push = %+v, %v", res, err)
+	}
+
+	if err := alice.Share(ctx, "bob"); err != nil {
+		t.Fatalf("Share: %v", err)
+	}
+	found, err := bob.Search(ctx, client.SearchParams{Query: "git", Shared: true})
+	if err != nil || len(found) != 1 || found[0].User != "alice" || found[0].Command != "git status" {
+		t.Errorf("Search = %+v, %v", found, err)
+	}
+
+	if err := alice.SetWizardCache(ctx, "", "list buckets", "aws s3 ls"); err != nil {
+		t.Fatalf("SetWizardCache: %v", err)
+	}
+	if err := alice.SetWizardCache(ctx, "/src/infra", "list buckets", "aws --profile infra s3 ls"); err != nil {
+		t.Fatalf("SetWizardCache: %v", err)
+	}
+	entry, err := alice.LookupWizardCache(ctx, "", "list buckets")
+	if err != nil || entry == nil || entry.Command != "aws s3 ls" {
+		t.Errorf("LookupWizardCache = %+v, %v", entry, err)
+	}
+	entry, err = alice.LookupWizardCache(ctx, "/src/infra", "list buckets")
+	if err != nil || entry == nil || entry.Project != "/src/infra" {
+		t.Errorf("LookupWizardCache for a project = %+v, %v", entry, err)
+	}
+
+	shares, err := bob.Shares(ctx)
+	if err != nil || len(shares.SharedWithMe) != 1 || shares.SharedWithMe[0] != "alice" {
+		t.Errorf("Shares = %+v, %v", shares, err)
+	}
+
+	created, err := alice.CreateUser(ctx, "carol", "")
+	if err != nil || created.Role != RoleMember || created.Token == "" {
+		t.Errorf("CreateUser = %+v, %v", created, err)
+	}
+	if _, err := bob.Users(ctx); err == nil {
+		t.Error("Users as member should fail")
+	}
+
+	stats, err := alice.Stats(ctx, 0, 0)
+	if err != nil || stats.TotalCommands != 2 || len(stats.Sources) != 1 {
+		t.Errorf("Stats = %+v, %v", stats, err)
+	}
+
+	ready, err := alice.Ready(ctx)
+	if err != nil || ready.Status != "ok" {
+		t.Errorf("Ready = %+v, %v", ready, err)
+	}
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"unicode/utf8"
+)
+
+// DefaultMaxCommandLength is the longest command, in bytes, stored whole
+const DefaultMaxCommandLength = 16 * 1024
+
+// truncatedMarker ends commands cut to maxCommandLength
+const truncatedMarker = " …[truncated]"
+
+// maxCommandLength is set from --max-command-length; 0 stores every
+// command whole
+var maxCommandLength = DefaultMaxCommandLength
+
+// truncateCommand cuts command to at most limit bytes, on a character
+// boundary, and marks the cut. The second result says whether it did.
+func truncateCommand(command string, limit int) (string, bool) {
+	if limit <= 0 || len(command) <= limit {
+		return command, false
+	}
+	cut := limit
+	for cut > 0 && !utf8.RuneStart(command[cut]) {
+		cut--
+	}
+	return command[:cut] + truncatedMarker, true
+}
+
+// OverflowCommand returns the full command, env prefix included, of a
+// truncated row in the main database, or "" if the row wasn't truncated
+func OverflowCommand(ctx context.Context, db *sql.DB, id int64) (string, error) {
+	var r SearchResult
+	err := db.QueryRowContext(ctx, `SELECT o.command, c.env_prefix FROM commands c
+		JOIN command_overflow o ON o.source = c.source AND o.timestamp = c.timestamp
+		WHERE c.rowid = ?`, id).Scan(&r.Command, &r.EnvPrefix)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to read full command: %w", err)
+	}
+	return r.FullCommand(), nil
+}
+
+// OverflowCommandByText returns the full command of the newest truncated
+// row of source whose command, env prefix included, is command, or "" if
+// there is none. Archived rows have no ID to look them up by.
+func OverflowCommandByText(ctx context.Context, db *sql.DB, source, command string) (string, error) {
+	var r SearchResult
+	err := db.QueryRowContext(ctx, `SELECT o.command, c.env_prefix FROM commands c
+		JOIN command_overflow o ON o.source = c.source AND o.timestamp = c.timestamp
+		WHERE c.source = ? AND (CASE c.env_prefix WHEN '' THEN c.command ELSE c.env_prefix || ' ' || c.command END) = ?
+		ORDER BY c.timestamp DESC LIMIT 1`, source, command).Scan(&r.Command, &r.EnvPrefix)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to read full command: %w", err)
+	}
+	return r.FullCommand(), nil
+}
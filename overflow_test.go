@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestTruncateCommand(t *testing.T) {
+	tests := []struct {
+		name          string
+		command       string
+		limit         int
+		want          string
+		wantTruncated bool
+	}{
+		{"short", "ls -la", 10, "ls -la", false},
+		{"exact", "ls -la", 6, "ls -la", false},
+		{"long", "echo hello", 4, "echo" + truncatedMarker, true},
+		{"mid character", "echo héllo", 7, "echo h" + truncatedMarker, true},
+		{"no limit", "echo hello", 0, "echo hello", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, truncated := truncateCommand(tt.command, tt.limit)
+			if got != tt.want || truncated != tt.wantTruncated {
+				t.Errorf("truncateCommand(%q, %d) = %q, %t, want %q, %t", tt.command, tt.limit, got, truncated, tt.want, tt.wantTruncated)
+			}
+		})
+	}
+}
+
+func TestCommandOverflow(t *testing.T) {
+	defer func(limit int) { maxCommandLength = limit }(maxCommandLength)
+	maxCommandLength = 64
+
+	db, err := InitDB(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("InitDB() error = %v", err)
+	}
+	defer db.Close()
+
+	blob := "echo " + strings.Repeat("\x7fELF", 1000)
+	commands := []Command{
+		{Source: "/h", Timestamp: 1, Command: "ls"},
+		{Source: "/h", Timestamp: 2, Command: blob, EnvPrefix: "LANG=C"},
+	}
+	if _, _, err := InsertCommands(context.Background(), db, commands); err != nil {
+		t.Fatalf("InsertCommands() error = %v", err)
+	}
+
+	// Only the cut text is indexed and shown
+	results, err := SearchCommands(context.Background(), db, SearchOptions{Query: "echo"})
+	if err != nil || len(results) != 1 {
+		t.Fatalf("SearchCommands() = %v, %v, want the truncated command", results, err)
+	}
+	if r := results[0]; len(r.Command) > maxCommandLength+len(truncatedMarker) || !strings.HasSuffix(r.Command, truncatedMarker) {
+		t.Errorf("stored command = %q, want at most %d bytes and the marker", r.Command, maxCommandLength)
+	}
+
+	full, err := OverflowCommand(context.Background(), db, results[0].ID)
+	if err != nil || full != "LANG=C "+blob {
+		t.Errorf("OverflowCommand() = %d bytes, %v, want the full command", len(full), err)
+	}
+	var rowid int64
+	if err := db.QueryRow(`SELECT rowid FROM commands WHERE command = 'ls'`).Scan(&rowid); err != nil {
+		t.Fatal(err)
+	}
+	if full, err := OverflowCommand(context.Background(), db, rowid); err != nil || full != "" {
+		t.Errorf("OverflowCommand(short) = %q, %v, want nothing", full, err)
+	}
+
+	// Collecting the file again finds the same commands
+	diff, err := DiffCommands(context.Background(), db, commands)
+	if err != nil || len(diff) != 1 || diff[0].Duplicate != 2 {
+		t.Errorf("DiffCommands() = %+v, %v, want 2 duplicates", diff, err)
+	}
+
+	// Servers get the whole command and apply their own limit
+	pushed, _, err := commandsAfter(context.Background(), db, 0, 10, syncBatchBytes)
+	if err != nil || len(pushed) != 2 || pushed[1].Command != blob {
+		t.Errorf("commandsAfter(context.Background()) = %d commands, %v, want the full command pushed", len(pushed), err)
+	}
+
+	if _, err := ForgetSource(context.Background(), db, "/h"); err != nil {
+		t.Fatalf("ForgetSource() error = %v", err)
+	}
+	var left int
+	if err := db.QueryRow(`SELECT COUNT(*) FROM command_overflow`).Scan(&left); err != nil || left != 0 {
+		t.Errorf("overflow rows after ForgetSource() = %d, %v, want 0", left, err)
+	}
+}
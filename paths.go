@@ -0,0 +1,133 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// LegacyDir held everything before zist followed the XDG base directories
+const LegacyDir = "~/.zist"
+
+// Default locations, under $XDG_DATA_HOME/zist and $XDG_CONFIG_HOME/zist
+var (
+	// DefaultDBPath is the local history database
+	DefaultDBPath = filepath.Join(DataDir(), "zist.db")
+	// DefaultArchivePath is where cold history is moved by `zist archive`
+	DefaultArchivePath = filepath.Join(DataDir(), "archive.db")
+	// DefaultServerDir holds the server database and one history database per user
+	DefaultServerDir = filepath.Join(DataDir(), "server")
+	// DefaultTeamSnippetsDir is where the team snippets repo is checked out
+	DefaultTeamSnippetsDir = filepath.Join(DataDir(), "team-snippets")
+	// DefaultBackupDir is where `zist daemon --maintenance` keeps backups
+	DefaultBackupDir = filepath.Join(DataDir(), "backups")
+	// DefaultConfigPath is the TOML file read for flag defaults, see config.go
+	DefaultConfigPath = filepath.Join(ConfigDir(), "config.toml")
+)
+
+// DataDir is $XDG_DATA_HOME/zist, by default ~/.local/share/zist
+func DataDir() string {
+	return xdgDir("XDG_DATA_HOME", "~/.local/share")
+}
+
+// ConfigDir is $XDG_CONFIG_HOME/zist, by default ~/.config/zist
+func ConfigDir() string {
+	return xdgDir("XDG_CONFIG_HOME", "~/.config")
+}
+
+// CacheDir is $XDG_CACHE_HOME/zist, by default ~/.cache/zist
+func CacheDir() string {
+	return xdgDir("XDG_CACHE_HOME", "~/.cache")
+}
+
+// xdgDir returns the zist directory under the base directory in env. The
+// spec says to ignore relative paths, so those get the default too.
+func xdgDir(env, fallback string) string {
+	base := os.Getenv(env)
+	if !filepath.IsAbs(base) {
+		base = fallback
+	}
+	return filepath.Join(base, "zist")
+}
+
+// PathMove is a file or directory that belongs somewhere else
+type PathMove struct {
+	From string
+	To   string
+}
+
+// legacyMoves lists where each entry zist kept in legacy now lives
+func legacyMoves(legacy, data, config string) []PathMove {
+	var moves []PathMove
+	for _, name := range []string{"zist.db", "archive.db"} {
+		// SQLite's journal files must stay next to their database
+		for _, suffix := range []string{"", "-wal", "-shm", "-journal"} {
+			moves = append(moves, PathMove{filepath.Join(legacy, name+suffix), filepath.Join(data, name+suffix)})
+		}
+	}
+	for _, name := range []string{"server", "team-snippets"} {
+		moves = append(moves, PathMove{filepath.Join(legacy, name), filepath.Join(data, name)})
+	}
+	return append(moves, PathMove{filepath.Join(legacy, "config.toml"), filepath.Join(config, "config.toml")})
+}
+
+// MigratePaths moves what exists of the given entries, leaving any whose
+// destination is already taken, and returns the moves made. With dryRun it
+// only reports them. Entries that vanish meanwhile, moved by another zist
+// starting at the same time, are skipped; failed moves don't stop the rest.
+func MigratePaths(moves []PathMove, dryRun bool) ([]PathMove, error) {
+	var done []PathMove
+	var errs []error
+	for _, m := range moves {
+		from, to := expandTilde(m.From), expandTilde(m.To)
+		if _, err := os.Lstat(from); err != nil {
+			continue
+		}
+		if _, err := os.Lstat(to); err == nil {
+			continue
+		}
+		if !dryRun {
+			if err := os.MkdirAll(filepath.Dir(to), 0755); err != nil {
+				errs = append(errs, fmt.Errorf("failed to create %s: %w", filepath.Dir(to), err))
+				continue
+			}
+			if err := os.Rename(from, to); err != nil {
+				if !errors.Is(err, fs.ErrNotExist) {
+					errs = append(errs, fmt.Errorf("failed to move %s to %s: %w", m.From, m.To, err))
+				}
+				continue
+			}
+		}
+		done = append(done, m)
+	}
+	return done, errors.Join(errs...)
+}
+
+// migrateLegacyPaths moves ~/.zist into the XDG directories the first time
+// a newer zist runs. Whatever can't be moved is still used from ~/.zist, so
+// history doesn't seem to vanish; `zist migrate-paths` reports the error.
+func migrateLegacyPaths() {
+	if _, err := os.Stat(expandTilde(LegacyDir)); err != nil {
+		return
+	}
+	MigratePaths(legacyMoves(LegacyDir, DataDir(), ConfigDir()), false)
+	// Only goes if nothing else was kept there
+	os.Remove(expandTilde(LegacyDir))
+
+	for _, p := range []*string{&DefaultDBPath, &DefaultArchivePath, &DefaultServerDir, &DefaultTeamSnippetsDir, &DefaultConfigPath} {
+		*p = preferExisting(*p, filepath.Join(LegacyDir, filepath.Base(*p)))
+	}
+}
+
+// preferExisting returns legacy if only it exists
+func preferExisting(path, legacy string) string {
+	if _, err := os.Stat(expandTilde(path)); err == nil {
+		return path
+	}
+	if _, err := os.Stat(expandTilde(legacy)); err == nil {
+		return legacy
+	}
+	return path
+}
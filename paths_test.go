@@ -0,0 +1,84 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestXDGDir(t *testing.T) {
+	t.Setenv("XDG_DATA_HOME", "/srv/data")
+	if got := DataDir(); got != "/srv/data/zist" {
+		t.Errorf("DataDir() = %q, want /srv/data/zist", got)
+	}
+	// Relative paths are invalid per the spec
+	t.Setenv("XDG_CONFIG_HOME", "config")
+	if got := ConfigDir(); got != "~/.config/zist" {
+		t.Errorf("ConfigDir() = %q, want ~/.config/zist", got)
+	}
+}
+
+func TestMigratePaths(t *testing.T) {
+	root := t.TempDir()
+	legacy, data, config := filepath.Join(root, ".zist"), filepath.Join(root, "data"), filepath.Join(root, "config")
+	for _, name := range []string{"zist.db", "zist.db-wal", "config.toml", "server/auth.db", "notes.txt"} {
+		path := filepath.Join(legacy, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(name), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Already migrated by hand, so left alone
+	if err := os.MkdirAll(config, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(config, "config.toml"), []byte("new"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	moves := legacyMoves(legacy, data, config)
+	want := []PathMove{
+		{filepath.Join(legacy, "zist.db"), filepath.Join(data, "zist.db")},
+		{filepath.Join(legacy, "zist.db-wal"), filepath.Join(data, "zist.db-wal")},
+		{filepath.Join(legacy, "server"), filepath.Join(data, "server")},
+	}
+
+	got, err := MigratePaths(moves, true)
+	if err != nil || !reflect.DeepEqual(got, want) {
+		t.Fatalf("MigratePaths(dry run) = %v, %v, want %v", got, err, want)
+	}
+	if _, err := os.Stat(filepath.Join(data, "zist.db")); err == nil {
+		t.Fatal("dry run moved zist.db")
+	}
+
+	got, err = MigratePaths(moves, false)
+	if err != nil || !reflect.DeepEqual(got, want) {
+		t.Fatalf("MigratePaths() = %v, %v, want %v", got, err, want)
+	}
+	for path, content := range map[string]string{
+		filepath.Join(data, "zist.db"):        "zist.db",
+		filepath.Join(data, "server/auth.db"): "server/auth.db",
+		filepath.Join(config, "config.toml"):  "new",
+		filepath.Join(legacy, "config.toml"):  "config.toml",
+		filepath.Join(legacy, "notes.txt"):    "notes.txt",
+	} {
+		if b, err := os.ReadFile(path); err != nil || string(b) != content {
+			t.Errorf("%s = %q, %v, want %q", path, b, err, content)
+		}
+	}
+
+	if got, err := MigratePaths(moves, false); err != nil || len(got) != 0 {
+		t.Errorf("MigratePaths() again = %v, %v, want nothing to do", got, err)
+	}
+
+	// The legacy config is still there, but the new one wins
+	if got := preferExisting(filepath.Join(config, "config.toml"), filepath.Join(legacy, "config.toml")); got != filepath.Join(config, "config.toml") {
+		t.Errorf("preferExisting() = %q, want the new config", got)
+	}
+	if got := preferExisting(filepath.Join(data, "archive.db"), filepath.Join(legacy, "notes.txt")); got != filepath.Join(legacy, "notes.txt") {
+		t.Errorf("preferExisting() = %q, want the legacy path that exists", got)
+	}
+}
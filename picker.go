@@ -0,0 +1,588 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Values of --picker
+const (
+	PickerAuto     = "auto"
+	PickerFzf      = "fzf"
+	PickerSkim     = "sk"
+	PickerPeco     = "peco"
+	PickerInternal = "internal"
+)
+
+// pickerChoice is --picker, the picker search, timeline and next use
+var pickerChoice = PickerAuto
+
+// PickOptions describes how a picker shows records
+type PickOptions struct {
+	Header  string
+	Ordered bool     // Keep records in the order given, top first, instead of ranking matches
+	Color   bool     // Mark each record with the color of its source
+	Preview bool     // Show the source, time, note and links of the highlighted record
+	FzfArgs []string // Extra flags only fzf understands, like the search drill-down bindings
+}
+
+// PickedRecord is the record chosen in a picker
+type PickedRecord struct {
+	ID      int64 // 0 for records without one
+	Source  string
+	Command string // As typed, env prefix included
+}
+
+// Picker lets the user choose one of a list of records
+type Picker interface {
+	// Pick shows records and returns the one chosen, or nil when the user
+	// cancelled
+	Pick(ctx context.Context, records []SearchResult, opts PickOptions) (*PickedRecord, error)
+}
+
+// newPicker returns the picker called name. auto takes the first of fzf,
+// sk and peco on PATH, and the internal picker without any.
+func newPicker(name string) (Picker, error) {
+	switch name {
+	case PickerAuto, "":
+		for _, bin := range []string{PickerFzf, PickerSkim, PickerPeco} {
+			if _, err := exec.LookPath(bin); err == nil {
+				return newPicker(bin)
+			}
+		}
+		return internalPicker{}, nil
+	case PickerFzf, PickerSkim:
+		if _, err := exec.LookPath(name); err != nil {
+			return nil, fmt.Errorf("%s not found in PATH, please install it or choose another --picker", name)
+		}
+		return fzfPicker{bin: name}, nil
+	case PickerPeco:
+		if _, err := exec.LookPath(name); err != nil {
+			return nil, fmt.Errorf("peco not found in PATH, please install it or choose another --picker")
+		}
+		return pecoPicker{}, nil
+	case PickerInternal:
+		return internalPicker{}, nil
+	}
+	return nil, fmt.Errorf("invalid --picker %q: want auto, fzf, sk, peco or internal", name)
+}
+
+// drillDown reports whether p handles the search drill-down keys, which
+// rely on fzf's transform action
+func drillDown(p Picker) bool {
+	f, ok := p.(fzfPicker)
+	return ok && f.bin == PickerFzf
+}
+
+// pickedOf returns the picked record for one of the records given to a
+// picker
+func pickedOf(r SearchResult) *PickedRecord {
+	return &PickedRecord{ID: r.ID, Source: r.Source, Command: r.FullCommand()}
+}
+
+// pickerLine is a record on one line, as pickers without multi-line
+// records show it
+func pickerLine(r SearchResult) string {
+	newline := symbol("↵ ", `\n `)
+	line := strings.NewReplacer("\r\n", newline, "\n", newline, "\t", " ", "\x00", symbol("␀", `\0`)).Replace(r.FullCommand())
+	if r.Badge != "" {
+		line = r.Badge + " " + line
+	}
+	return line
+}
+
+// fzfPicker runs fzf, or skim, which takes the same flags apart from
+// fzf's actions
+type fzfPicker struct {
+	bin string
+}
+
+func (p fzfPicker) Pick(ctx context.Context, records []SearchResult, opts PickOptions) (*PickedRecord, error) {
+	self, err := os.Executable()
+	if err != nil {
+		self = "zist"
+	}
+	// --plain keeps to the list, without a preview pane or fzf's styling
+	args := pickerArgs(self, opts.Color, opts.Preview && !plainOutput)
+	if plainOutput && p.bin == PickerFzf {
+		args = append(args, "--no-color", "--no-bold", "--no-unicode")
+	}
+	if opts.Ordered {
+		args = append(args, "--no-sort", "--layout=reverse")
+	}
+	if opts.Header != "" {
+		args = append(args, "--header", opts.Header)
+	}
+	if p.bin == PickerFzf {
+		args = append(args, opts.FzfArgs...)
+	}
+
+	cmd := exec.CommandContext(ctx, p.bin, args...)
+	cmd.Stderr = os.Stderr
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create stdin pipe: %w", err)
+	}
+	go func() {
+		writeSearchRecords(stdin, records, opts.Color)
+		stdin.Close()
+	}()
+
+	stdout, err := cmd.Output()
+	if err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) && exitErr.ExitCode() == 130 {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("%s failed: %w", p.bin, err)
+	}
+	if len(stdout) == 0 {
+		return nil, nil
+	}
+	id, source, command := pickedRecord(string(stdout))
+	return &PickedRecord{ID: id, Source: source, Command: command}, nil
+}
+
+// pickerArgs returns the fzf flags for browsing records in the picker's
+// format, with a preview pane from `zist show --record`, self, showing
+// source, timestamp, note and links
+func pickerArgs(self string, color, preview bool) []string {
+	// Use --read0 to handle multiline commands (null-byte separated records)
+	args := []string{
+		"--read0",
+		"--print0",
+		"--delimiter=\t",
+		"--with-nth=7,8..", // Only display the badge (field 7) and command (field 8 on)
+	}
+	if preview {
+		previewColor := ColorNever
+		if color {
+			previewColor = ColorAlways
+		}
+		args = append(args,
+			"--preview", fmt.Sprintf("%s show --color %s --record {}", shellQuote(self), previewColor),
+			"--preview-window=right:40%:wrap",
+		)
+	}
+	if color {
+		args = append(args, "--ansi")
+	}
+	return args
+}
+
+// writeSearchRecords writes results in the picker's record format. With color
+// the badge starts with a dot in the color of the command's source.
+func writeSearchRecords(w io.Writer, commands []SearchResult, color bool) {
+	for _, result := range commands {
+		// Tab-separated and null-byte terminated: source, timestamp, id, note,
+		// links, command, badge, then the command again as shown. The source
+		// and first copy of the command are escaped to come back exactly from
+		// the picker; the rest is only shown, so made safe instead. The shown
+		// command goes last so tabs and newlines in it display as typed.
+		formattedTime := ""
+		if result.Timestamp > 0 {
+			formattedTime = unixTime(result.Timestamp).Format(recordTimeLayout)
+		}
+		badge := ""
+		if result.Badge != "" {
+			badge = pickerText(result.Badge) + " "
+		}
+		if color {
+			badge = colorSource("●", result.Source) + " " + badge
+		}
+		id := ""
+		if result.ID > 0 {
+			id = strconv.FormatInt(result.ID, 10)
+		}
+		links := make([]string, len(result.Links))
+		for i, link := range result.Links {
+			links[i] = pickerText(link)
+		}
+		command := result.FullCommand()
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\x00",
+			encodePickerField(result.Source), formattedTime, id, pickerText(result.Note), strings.Join(links, "\n"),
+			encodePickerField(command), badge, strings.ReplaceAll(command, "\x00", symbol("␀", `\0`)))
+	}
+}
+
+// pickerFieldEscaper escapes what would end a field or a record
+var pickerFieldEscaper = strings.NewReplacer(`\`, `\\`, "\t", `\t`, "\n", `\n`, "\x00", `\0`)
+
+// encodePickerField escapes s for a record field that has to come back
+// from the picker byte for byte
+func encodePickerField(s string) string {
+	return pickerFieldEscaper.Replace(s)
+}
+
+// decodePickerField reverses encodePickerField
+func decodePickerField(s string) string {
+	if !strings.Contains(s, `\`) {
+		return s
+	}
+	var sb strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' || i+1 == len(s) {
+			sb.WriteByte(s[i])
+			continue
+		}
+		i++
+		switch s[i] {
+		case 't':
+			sb.WriteByte('\t')
+		case 'n':
+			sb.WriteByte('\n')
+		case '0':
+			sb.WriteByte(0)
+		default:
+			sb.WriteByte(s[i])
+		}
+	}
+	return sb.String()
+}
+
+// pickerText makes s safe for a record field that is only shown, keeping
+// newlines, which don't end a record
+func pickerText(s string) string {
+	return strings.NewReplacer("\t", " ", "\x00", symbol("␀", `\0`)).Replace(s)
+}
+
+// pickedRecord returns the ID, source and command of the record fzf
+// printed with --print0, the command exactly as written so here-documents,
+// tabs and NULs survive
+func pickedRecord(record string) (int64, string, string) {
+	fields := strings.SplitN(strings.TrimSuffix(record, "\x00"), "\t", 8)
+	if len(fields) < 8 {
+		return 0, "", ""
+	}
+	id, _ := strconv.ParseInt(fields[2], 10, 64)
+	return id, decodePickerField(fields[0]), decodePickerField(fields[5])
+}
+
+// pecoPicker runs peco, which shows one line per record and prints the
+// part of the chosen line after a NUL, here its index
+type pecoPicker struct{}
+
+func (pecoPicker) Pick(ctx context.Context, records []SearchResult, opts PickOptions) (*PickedRecord, error) {
+	args := []string{"--null"}
+	if opts.Header != "" {
+		args = append(args, "--prompt", opts.Header+" >")
+	}
+	cmd := exec.CommandContext(ctx, PickerPeco, args...)
+	cmd.Stderr = os.Stderr
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create stdin pipe: %w", err)
+	}
+	go func() {
+		w := bufio.NewWriter(stdin)
+		for i, r := range records {
+			fmt.Fprintf(w, "%s\x00%d\n", pickerLine(r), i)
+		}
+		w.Flush()
+		stdin.Close()
+	}()
+
+	stdout, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("peco failed: %w", err)
+	}
+	i, err := strconv.Atoi(strings.TrimSpace(string(stdout)))
+	if err != nil || i < 0 || i >= len(records) {
+		return nil, nil
+	}
+	return pickedOf(records[i]), nil
+}
+
+// internalPicker is a small picker drawn by zist itself, for when no
+// other is installed. It narrows the records down to those containing
+// every typed word, keeping their order.
+type internalPicker struct{}
+
+func (internalPicker) Pick(ctx context.Context, records []SearchResult, opts PickOptions) (*PickedRecord, error) {
+	// Draw on the terminal, as stdout goes back to the shell widget
+	tty, err := os.OpenFile("/dev/tty", os.O_RDWR, 0)
+	if err != nil {
+		return nil, fmt.Errorf("the internal picker needs a terminal: %w", err)
+	}
+	defer tty.Close()
+	rows, cols, err := terminalSize(tty)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the terminal size: %w", err)
+	}
+	restore, err := rawTerminal(tty)
+	if err != nil {
+		return nil, err
+	}
+	defer restore()
+
+	// The alternate screen leaves the shell's screen as it was
+	fmt.Fprint(tty, "\x1b[?1049h")
+	defer fmt.Fprint(tty, "\x1b[?1049l")
+	i, err := runInternalPicker(tty, tty, rows, cols, records, opts)
+	if err != nil || i < 0 {
+		return nil, err
+	}
+	return pickedOf(records[i]), nil
+}
+
+// Keys the internal picker understands
+const (
+	keyNone = iota
+	keyRune
+	keyBackspace
+	keyClear      // Ctrl+U
+	keyDeleteWord // Ctrl+W
+	keyUp
+	keyDown
+	keyPageUp
+	keyPageDown
+	keyAccept
+	keyCancel
+)
+
+// readKey reads one key press from the terminal. A lone Esc cancels; Alt
+// combinations and keys without a use are keyNone.
+func readKey(r *bufio.Reader) (int, rune, error) {
+	c, _, err := r.ReadRune()
+	if err != nil {
+		return keyNone, 0, err
+	}
+	switch c {
+	case '\r', '\n':
+		return keyAccept, 0, nil
+	case 0x7f, 0x08:
+		return keyBackspace, 0, nil
+	case 0x15:
+		return keyClear, 0, nil
+	case 0x17:
+		return keyDeleteWord, 0, nil
+	case 0x10:
+		return keyUp, 0, nil
+	case 0x0e:
+		return keyDown, 0, nil
+	case 0x03, 0x04, 0x07:
+		return keyCancel, 0, nil
+	case 0x1b:
+		if r.Buffered() == 0 {
+			return keyCancel, 0, nil
+		}
+		b, err := r.ReadByte()
+		if err != nil || b != '[' && b != 'O' {
+			return keyNone, 0, err
+		}
+		// Parameters, then the final byte
+		var seq []byte
+		for {
+			b, err := r.ReadByte()
+			if err != nil {
+				return keyNone, 0, err
+			}
+			seq = append(seq, b)
+			if b >= 0x40 && b <= 0x7e {
+				break
+			}
+		}
+		switch string(seq) {
+		case "A":
+			return keyUp, 0, nil
+		case "B":
+			return keyDown, 0, nil
+		case "5~":
+			return keyPageUp, 0, nil
+		case "6~":
+			return keyPageDown, 0, nil
+		}
+		return keyNone, 0, nil
+	}
+	if c < 0x20 || c == utf8.RuneError {
+		return keyNone, 0, nil
+	}
+	return keyRune, c, nil
+}
+
+// pickerModel is what the internal picker shows
+type pickerModel struct {
+	lines   []string // Each record on one line
+	lower   []string // lines in lower case, for matching
+	query   []rune
+	matches []int // Indexes of the records matching query
+	cursor  int   // Highlighted match
+	top     int   // First match on screen
+}
+
+func newPickerModel(records []SearchResult) *pickerModel {
+	m := &pickerModel{}
+	for _, r := range records {
+		line := pickerLine(r)
+		m.lines = append(m.lines, line)
+		m.lower = append(m.lower, strings.ToLower(line))
+	}
+	m.filter()
+	return m
+}
+
+// filter finds the records containing every word of the query, ignoring
+// case, and highlights the first
+func (m *pickerModel) filter() {
+	words := strings.Fields(strings.ToLower(string(m.query)))
+	m.matches = m.matches[:0]
+	for i, line := range m.lower {
+		matched := true
+		for _, w := range words {
+			if !strings.Contains(line, w) {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			m.matches = append(m.matches, i)
+		}
+	}
+	m.cursor, m.top = 0, 0
+}
+
+// move moves the highlight by n matches, scrolling a page of size rows
+func (m *pickerModel) move(n, page int) {
+	m.cursor = max(0, min(m.cursor+n, len(m.matches)-1))
+	if m.cursor < m.top {
+		m.top = m.cursor
+	}
+	if m.cursor >= m.top+page {
+		m.top = m.cursor - page + 1
+	}
+}
+
+// runInternalPicker runs the internal picker on a terminal of rows by cols
+// already in raw mode, returning the index of the chosen record or -1
+func runInternalPicker(in io.Reader, out io.Writer, rows, cols int, records []SearchResult, opts PickOptions) (int, error) {
+	m := newPickerModel(records)
+	r := bufio.NewReader(in)
+	// The query and status lines, and a line for the highlighted record's
+	// details
+	page := rows - 2
+	if opts.Preview {
+		page--
+	}
+	page = max(page, 1)
+	for {
+		renderPicker(out, m, records, page, cols, opts)
+		key, c, err := readKey(r)
+		if err == io.EOF {
+			return -1, nil
+		}
+		if err != nil {
+			return -1, fmt.Errorf("failed to read the terminal: %w", err)
+		}
+		switch key {
+		case keyRune:
+			m.query = append(m.query, c)
+			m.filter()
+		case keyBackspace:
+			if len(m.query) > 0 {
+				m.query = m.query[:len(m.query)-1]
+				m.filter()
+			}
+		case keyClear:
+			m.query = m.query[:0]
+			m.filter()
+		case keyDeleteWord:
+			q := strings.TrimRight(string(m.query), " ")
+			m.query = []rune(q[:strings.LastIndex(q, " ")+1])
+			m.filter()
+		case keyUp:
+			m.move(-1, page)
+		case keyDown:
+			m.move(1, page)
+		case keyPageUp:
+			m.move(-page, page)
+		case keyPageDown:
+			m.move(page, page)
+		case keyAccept:
+			if len(m.matches) > 0 {
+				return m.matches[m.cursor], nil
+			}
+		case keyCancel:
+			return -1, nil
+		}
+	}
+}
+
+// renderPicker draws the query, a status line and a page of matches from
+// the top of the screen, with the details of the highlighted record at the
+// bottom for opts.Preview. With --plain it leaves out dim and reverse video,
+// so the "> " marker alone shows the highlighted record.
+func renderPicker(w io.Writer, m *pickerModel, records []SearchResult, page, cols int, opts PickOptions) {
+	var sb strings.Builder
+	style := func(s, sgr string) string {
+		if plainOutput {
+			return s
+		}
+		return "\x1b[" + sgr + "m" + s + "\x1b[0m"
+	}
+	fit := func(s string, width int) string {
+		if width <= 0 {
+			return ""
+		}
+		if utf8.RuneCountInString(s) > width {
+			ellipsis := symbol("…", "...")
+			keep := width - utf8.RuneCountInString(ellipsis)
+			if keep < 0 {
+				return string([]rune(s)[:width])
+			}
+			s = string([]rune(s)[:keep]) + ellipsis
+		}
+		return s
+	}
+	sb.WriteString("\x1b[H")
+	fmt.Fprintf(&sb, "> %s\x1b[K\r\n", string(m.query))
+	status := fmt.Sprintf("  %d/%d", len(m.matches), len(m.lines))
+	if opts.Header != "" {
+		status += "  " + opts.Header
+	}
+	fmt.Fprintf(&sb, "%s\x1b[K\r\n", style(fit(status, cols), "2"))
+	for i := m.top; i < m.top+page; i++ {
+		if i < len(m.matches) {
+			r := m.matches[i]
+			width := cols - 2
+			marker := "  "
+			if i == m.cursor {
+				marker = "> "
+			}
+			sb.WriteString(marker)
+			if opts.Color {
+				sb.WriteString(colorSource("●", records[r].Source) + " ")
+				width -= 2
+			}
+			line := fit(m.lines[r], width)
+			if i == m.cursor {
+				line = style(line, "7")
+			} else if opts.Color {
+				line = highlightCommand(line)
+			}
+			sb.WriteString(line)
+		}
+		sb.WriteString("\x1b[K\r\n")
+	}
+	if opts.Preview && len(m.matches) > 0 {
+		r := records[m.matches[m.cursor]]
+		details := r.Source
+		if r.Timestamp > 0 {
+			details += "  " + FormatTimestamp(r.Timestamp)
+		}
+		if r.Note != "" {
+			details += "  # " + strings.ReplaceAll(r.Note, "\n", " ")
+		}
+		sb.WriteString(style(fit(details, cols), "2"))
+	}
+	// Leave the cursor after the query
+	fmt.Fprintf(&sb, "\x1b[K\x1b[J\x1b[1;%dH", 3+len(m.query))
+	io.WriteString(w, sb.String())
+}
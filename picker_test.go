@@ -0,0 +1,135 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestNewPicker(t *testing.T) {
+	t.Setenv("PATH", t.TempDir())
+	if p, err := newPicker(PickerAuto); err != nil || p != (internalPicker{}) {
+		t.Errorf("newPicker(auto) without pickers on PATH = %#v, %v, want the internal picker", p, err)
+	}
+	for _, name := range []string{PickerFzf, PickerSkim, PickerPeco, "dmenu"} {
+		if _, err := newPicker(name); err == nil {
+			t.Errorf("newPicker(%q) succeeded without it on PATH", name)
+		}
+	}
+}
+
+func TestReadKey(t *testing.T) {
+	tests := []struct {
+		input string
+		key   int
+		ch    rune
+	}{
+		{"a", keyRune, 'a'},
+		{"é", keyRune, 'é'},
+		{"\r", keyAccept, 0},
+		{"\x7f", keyBackspace, 0},
+		{"\x15", keyClear, 0},
+		{"\x1b", keyCancel, 0},
+		{"\x03", keyCancel, 0},
+		{"\x1b[A", keyUp, 0},
+		{"\x1bOB", keyDown, 0},
+		{"\x1b[6~", keyPageDown, 0},
+		{"\x1b[1;5C", keyNone, 0},
+		{"\x1bx", keyNone, 0},
+		{"\x01", keyNone, 0},
+	}
+	for _, tt := range tests {
+		r := bufio.NewReader(strings.NewReader(tt.input))
+		key, ch, err := readKey(r)
+		if err != nil || key != tt.key || ch != tt.ch {
+			t.Errorf("readKey(%q) = %d, %q, %v, want %d, %q", tt.input, key, ch, err, tt.key, tt.ch)
+		}
+		if _, err := r.ReadByte(); err != io.EOF {
+			t.Errorf("readKey(%q) left input unread", tt.input)
+		}
+	}
+}
+
+func TestRunInternalPicker(t *testing.T) {
+	records := []SearchResult{
+		{Command: "git push origin main", Source: "/laptop"},
+		{Command: "kubectl get pods", Source: "/laptop", EnvPrefix: "KUBECONFIG=prod"},
+		{Command: "git status", Source: "/server"},
+		{Command: "cat <<EOF\nGit\nEOF", Source: "/server"},
+	}
+	tests := []struct {
+		name  string
+		input string
+		want  int
+	}{
+		{"first", "\r", 0},
+		{"down", "\x1b[B\x1b[B\r", 2},
+		{"not past the end", "\x1b[6~\x1b[B\r", 3},
+		{"words in any order, any case", "MAIN GIT\r", 0},
+		{"filter then move", "git\x1b[B\x1b[B\r", 3},
+		{"env prefix matches", "prod\r", 1},
+		{"backspace", "kx\x7f\r", 1},
+		{"clear", "pods\x15\x1b[B\r", 1},
+		{"delete word", "status push\x17\r", 2},
+		{"no match ignores enter", "nothing\r\x1b", -1},
+		{"escape", "\x1b", -1},
+		{"end of input", "git", -1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out strings.Builder
+			got, err := runInternalPicker(strings.NewReader(tt.input), &out, 10, 40, records, PickOptions{Header: "history", Preview: true})
+			if err != nil {
+				t.Fatalf("runInternalPicker() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("runInternalPicker(%q) = %d, want %d", tt.input, got, tt.want)
+			}
+		})
+	}
+
+	var out strings.Builder
+	runInternalPicker(strings.NewReader("\x1b"), &out, 10, 20, records, PickOptions{Header: "history", Preview: true})
+	for _, want := range []string{"> \x1b[K", "  4/4  history", "> \x1b[7mgit push origin m…\x1b[0m", "  cat <<EOF↵ Git↵ E…", "/laptop"} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("runInternalPicker() drew %q, missing %q", out.String(), want)
+		}
+	}
+}
+
+func TestPickerRecords(t *testing.T) {
+	results := []SearchResult{
+		{ID: 1, Source: "/home/me/.zsh_history", Command: "printf 'a\tb\\n'"},
+		{ID: 2, Source: "odd\tname\\with\nbreaks", Command: "echo \\t \\\\t \\0 \\"},
+		{ID: 3, Source: "/a", Command: "printf '\x00' | xxd", EnvPrefix: "LC_ALL=C"},
+		{ID: 4, Source: "/a", Command: "cat <<EOF\n\tindented\nEOF", Note: "tab\there\x00", Links: []string{"https://x\ty"}},
+		{Source: "team", Command: "\\\t\x00\n", Badge: "[team\tsnippet]"},
+	}
+	var records strings.Builder
+	writeSearchRecords(&records, results, true)
+	got := strings.Split(strings.TrimSuffix(records.String(), "\x00"), "\x00")
+	if len(got) != len(results) {
+		t.Fatalf("writeSearchRecords() wrote %d records, want %d: %q", len(got), len(results), records.String())
+	}
+	for i, record := range got {
+		want := results[i]
+		// Fields before the shown command don't contain the delimiter
+		if fields := strings.SplitN(record, "\t", 8); len(fields) != 8 || strings.Contains(fields[3], "\t") {
+			t.Errorf("record %d = %q, want 8 fields", i, record)
+		}
+		id, source, command := pickedRecord(record + "\x00")
+		if id != want.ID || source != want.Source || command != want.FullCommand() {
+			t.Errorf("pickedRecord(%q) = %d, %q, %q, want %d, %q, %q", record, id, source, command, want.ID, want.Source, want.FullCommand())
+		}
+	}
+
+	for _, s := range []string{"", `\`, `\\`, `a\tb`, "\t\n\x00", `trailing\`} {
+		if got := decodePickerField(encodePickerField(s)); got != s {
+			t.Errorf("decodePickerField(encodePickerField(%q)) = %q", s, got)
+		}
+	}
+	if f, err := ParseSearchFilter("source=" + encodePickerField("odd\tname")); err != nil || f.Value != "odd\tname" {
+		t.Errorf("ParseSearchFilter() of an escaped source = %+v, %v", f, err)
+	}
+}
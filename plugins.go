@@ -0,0 +1,87 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// pluginPrefix names executables that add subcommands, like git: zist-foo
+// on PATH runs as `zist foo ARGS...`
+const pluginPrefix = "zist-"
+
+// Plugin is an external subcommand found on PATH
+type Plugin struct {
+	Name string // Subcommand it adds
+	Path string
+}
+
+// findPlugin returns the executable providing subcommand name, if any
+func findPlugin(name string) (string, bool) {
+	if name == "" || strings.ContainsAny(name, `/\`) {
+		return "", false
+	}
+	path, err := exec.LookPath(pluginPrefix + name)
+	return path, err == nil
+}
+
+// ListPlugins returns the plugins on PATH by name. When several share a
+// name, the one earlier on PATH wins, as it does when run.
+func ListPlugins() []Plugin {
+	seen := make(map[string]bool)
+	var plugins []Plugin
+	for _, dir := range filepath.SplitList(os.Getenv("PATH")) {
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			continue
+		}
+		for _, e := range entries {
+			name, ok := strings.CutPrefix(e.Name(), pluginPrefix)
+			name = strings.TrimSuffix(name, ".exe")
+			if !ok || name == "" || seen[name] {
+				continue
+			}
+			path := filepath.Join(dir, e.Name())
+			if _, err := exec.LookPath(path); err != nil {
+				continue
+			}
+			seen[name] = true
+			plugins = append(plugins, Plugin{Name: name, Path: path})
+		}
+	}
+	sort.Slice(plugins, func(i, j int) bool { return plugins[i].Name < plugins[j].Name })
+	return plugins
+}
+
+// runPlugin runs a plugin with args and zist's standard streams and returns
+// its exit status. Plugins find the database in ZIST_DB and zist itself in
+// ZIST_BIN, and see every other ZIST_* setting the user made.
+func runPlugin(path string, args []string) (int, error) {
+	cmd := exec.Command(path, args...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	cmd.Env = os.Environ()
+	if os.Getenv(EnvVarPrefix+"_DB") == "" {
+		cmd.Env = append(cmd.Env, EnvVarPrefix+"_DB="+expandTilde(DefaultDBPath))
+	}
+	if self, err := os.Executable(); err == nil {
+		cmd.Env = append(cmd.Env, EnvVarPrefix+"_BIN="+self)
+	}
+
+	// Ctrl+C is the plugin's to handle; zist exits once it does
+	signal.Ignore(os.Interrupt)
+	defer signal.Reset(os.Interrupt)
+	err := cmd.Run()
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return max(exitErr.ExitCode(), 1), nil
+	}
+	if err != nil {
+		return 1, fmt.Errorf("failed to run plugin %s: %w", path, err)
+	}
+	return 0, nil
+}
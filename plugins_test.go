@@ -0,0 +1,52 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestPlugins(t *testing.T) {
+	first, second := t.TempDir(), t.TempDir()
+	out := filepath.Join(t.TempDir(), "out")
+	for path, script := range map[string]string{
+		filepath.Join(first, "zist-export"):  "#!/bin/sh\necho \"$1 $ZIST_DB\" > " + shellQuote(out) + "\nexit 3\n",
+		filepath.Join(second, "zist-export"): "#!/bin/sh\nexit 0\n",
+		filepath.Join(second, "zist-report"): "#!/bin/sh\nexit 0\n",
+	} {
+		if err := os.WriteFile(path, []byte(script), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Not executable, so not a plugin
+	if err := os.WriteFile(filepath.Join(second, "zist-notes.txt"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", first+string(os.PathListSeparator)+second)
+	t.Setenv("ZIST_DB", "/tmp/plugin.db")
+
+	want := []Plugin{
+		{"export", filepath.Join(first, "zist-export")},
+		{"report", filepath.Join(second, "zist-report")},
+	}
+	if got := ListPlugins(); !reflect.DeepEqual(got, want) {
+		t.Errorf("ListPlugins() = %v, want %v", got, want)
+	}
+
+	path, ok := findPlugin("export")
+	if !ok || path != want[0].Path {
+		t.Fatalf("findPlugin(export) = %q, %t, want %q", path, ok, want[0].Path)
+	}
+	if _, ok := findPlugin("../export"); ok {
+		t.Error("findPlugin(../export) found a plugin outside PATH")
+	}
+
+	code, err := runPlugin(path, []string{"--csv"})
+	if err != nil || code != 3 {
+		t.Errorf("runPlugin() = %d, %v, want exit status 3", code, err)
+	}
+	if b, err := os.ReadFile(out); err != nil || string(b) != "--csv /tmp/plugin.db\n" {
+		t.Errorf("plugin wrote %q, %v, want its args and ZIST_DB", b, err)
+	}
+}
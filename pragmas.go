@@ -0,0 +1,149 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Pragmas are the SQLite settings zist opens databases with. All but
+// PageSize last as long as the connection; PageSize only takes effect on a
+// new database or at the next VACUUM.
+type Pragmas struct {
+	PageSize    int64  // Bytes per page, a power of two from 512 to 65536
+	CacheSize   int64  // Bytes of pages each connection keeps in memory
+	MmapSize    int64  // Bytes of the file read through memory mapping, 0 for none
+	TempStore   string // Where temporary tables and indexes go: default, file or memory
+	Synchronous string // How hard writes wait for the disk: off, normal, full or extra
+}
+
+// DefaultPragmas suit interactive use: a database that can take a power
+// cut during a collect, opened many times a minute by short commands
+var DefaultPragmas = Pragmas{
+	PageSize:    4096,
+	CacheSize:   8 << 20,
+	MmapSize:    0,
+	TempStore:   "default",
+	Synchronous: "full",
+}
+
+// BulkPragmas suit importing a lot of history at once with `collect
+// --bulk`: a bigger cache, and not waiting for the disk after every batch.
+// A power cut during one can corrupt the database.
+var BulkPragmas = Pragmas{
+	PageSize:    4096,
+	CacheSize:   64 << 20,
+	MmapSize:    256 << 20,
+	TempStore:   "memory",
+	Synchronous: "off",
+}
+
+// sqlitePragmas and sqliteBulkPragmas are the settings in use, from
+// --sqlite-pragma and --sqlite-bulk-pragma over the defaults
+var (
+	sqlitePragmas     = DefaultPragmas
+	sqliteBulkPragmas = BulkPragmas
+)
+
+var (
+	tempStoreModes   = []string{"default", "file", "memory"}
+	synchronousModes = []string{"off", "normal", "full", "extra"}
+)
+
+// ParsePragmas sets the NAME=VALUE settings of flag over base, e.g.
+// cache_size=32MB or synchronous=normal. Sizes take a unit as for
+// --max-size.
+func ParsePragmas(flag string, base Pragmas, settings []string) (Pragmas, error) {
+	p := base
+	for _, s := range settings {
+		if s == "" {
+			continue
+		}
+		name, value, ok := strings.Cut(s, "=")
+		name, value = strings.ToLower(strings.TrimSpace(name)), strings.ToLower(strings.TrimSpace(value))
+		if !ok {
+			return base, fmt.Errorf("invalid --%s %q (use NAME=VALUE)", flag, s)
+		}
+		var err error
+		switch name {
+		case "page_size":
+			p.PageSize, err = parseSize(value)
+			if err == nil && (p.PageSize < 512 || p.PageSize > 65536 || p.PageSize&(p.PageSize-1) != 0) {
+				err = fmt.Errorf("page_size must be a power of two from 512 to 65536")
+			}
+		case "cache_size":
+			p.CacheSize, err = parseSize(value)
+		case "mmap_size":
+			p.MmapSize, err = parseSize(value)
+		case "temp_store":
+			p.TempStore = value
+			if !slices.Contains(tempStoreModes, value) {
+				err = fmt.Errorf("temp_store must be one of %s", strings.Join(tempStoreModes, ", "))
+			}
+		case "synchronous":
+			p.Synchronous = value
+			if !slices.Contains(synchronousModes, value) {
+				err = fmt.Errorf("synchronous must be one of %s", strings.Join(synchronousModes, ", "))
+			}
+		default:
+			err = fmt.Errorf("unknown pragma %q (use page_size, cache_size, mmap_size, temp_store or synchronous)", name)
+		}
+		if err != nil {
+			return base, fmt.Errorf("invalid --%s %q: %w", flag, s, err)
+		}
+	}
+	return p, nil
+}
+
+// settings returns each pragma with its value as SQLite takes it
+func (p Pragmas) settings() [][2]string {
+	return [][2]string{
+		{"page_size", strconv.FormatInt(p.PageSize, 10)},
+		// Negative is KiB rather than pages, so it doesn't depend on page_size
+		{"cache_size", strconv.FormatInt(-p.CacheSize/1024, 10)},
+		{"mmap_size", strconv.FormatInt(p.MmapSize, 10)},
+		{"temp_store", p.TempStore},
+		{"synchronous", p.Synchronous},
+	}
+}
+
+// dsn returns the pragmas as query parameters of a database name, so the
+// driver sets them on every connection it opens
+func (p Pragmas) dsn() string {
+	var params []string
+	for _, s := range p.settings() {
+		params = append(params, "_pragma="+url.QueryEscape(s[0]+"("+s[1]+")"))
+	}
+	return strings.Join(params, "&")
+}
+
+// ApplyPragmas sets p on db until the returned restore sets them back to
+// what they were. db must be pinned to one connection, or others in its
+// pool keep the old settings.
+func ApplyPragmas(ctx context.Context, db *sql.DB, p Pragmas) (restore func() error, err error) {
+	var previous [][2]string
+	for _, s := range p.settings() {
+		var value string
+		if err := db.QueryRowContext(ctx, "PRAGMA "+s[0]).Scan(&value); err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", s[0], err)
+		}
+		previous = append(previous, [2]string{s[0], value})
+	}
+	set := func(settings [][2]string) error {
+		for _, s := range settings {
+			if _, err := db.Exec("PRAGMA " + s[0] + " = " + s[1]); err != nil {
+				return fmt.Errorf("failed to set %s: %w", s[0], err)
+			}
+		}
+		return nil
+	}
+	if err := set(p.settings()); err != nil {
+		set(previous)
+		return nil, err
+	}
+	return func() error { return set(previous) }, nil
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+)
+
+func TestParsePragmas(t *testing.T) {
+	tests := []struct {
+		settings []string
+		want     Pragmas
+		wantErr  bool
+	}{
+		{settings: nil, want: DefaultPragmas},
+		{settings: []string{""}, want: DefaultPragmas},
+		{
+			settings: []string{"cache_size=32MB", "mmap_size=1G", "synchronous=NORMAL", " temp_store = memory ", "page_size=8192"},
+			want:     Pragmas{PageSize: 8192, CacheSize: 32 << 20, MmapSize: 1 << 30, TempStore: "memory", Synchronous: "normal"},
+		},
+		{settings: []string{"mmap_size=0"}, want: DefaultPragmas},
+		{settings: []string{"page_size=1000"}, wantErr: true},
+		{settings: []string{"page_size=128K"}, wantErr: true},
+		{settings: []string{"cache_size=lots"}, wantErr: true},
+		{settings: []string{"synchronous=sometimes"}, wantErr: true},
+		{settings: []string{"temp_store=disk"}, wantErr: true},
+		{settings: []string{"journal_mode=wal"}, wantErr: true},
+		{settings: []string{"synchronous"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := ParsePragmas("sqlite-pragma", DefaultPragmas, tt.settings)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParsePragmas(%q) error = %v, wantErr %v", tt.settings, err, tt.wantErr)
+			continue
+		}
+		if !tt.wantErr && got != tt.want {
+			t.Errorf("ParsePragmas(%q) = %+v, want %+v", tt.settings, got, tt.want)
+		}
+	}
+}
+
+func TestApplyPragmas(t *testing.T) {
+	ctx := context.Background()
+	db, err := InitDB(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("InitDB() error = %v", err)
+	}
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+
+	read := func() (cacheSize, synchronous, tempStore int64) {
+		t.Helper()
+		if err := db.QueryRow(`SELECT * FROM pragma_cache_size, pragma_synchronous, pragma_temp_store`).Scan(&cacheSize, &synchronous, &tempStore); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+
+	// InitDB opens with DefaultPragmas
+	if cacheSize, synchronous, tempStore := read(); cacheSize != -8192 || synchronous != 2 || tempStore != 0 {
+		t.Errorf("after InitDB: cache_size %d, synchronous %d, temp_store %d, want -8192, 2 (full), 0 (default)", cacheSize, synchronous, tempStore)
+	}
+
+	restore, err := ApplyPragmas(ctx, db, BulkPragmas)
+	if err != nil {
+		t.Fatalf("ApplyPragmas() error = %v", err)
+	}
+	if cacheSize, synchronous, tempStore := read(); cacheSize != -65536 || synchronous != 0 || tempStore != 2 {
+		t.Errorf("bulk: cache_size %d, synchronous %d, temp_store %d, want -65536, 0 (off), 2 (memory)", cacheSize, synchronous, tempStore)
+	}
+
+	if err := restore(); err != nil {
+		t.Fatalf("restore() error = %v", err)
+	}
+	if cacheSize, synchronous, tempStore := read(); cacheSize != -8192 || synchronous != 2 || tempStore != 0 {
+		t.Errorf("restored: cache_size %d, synchronous %d, temp_store %d, want -8192, 2 (full), 0 (default)", cacheSize, synchronous, tempStore)
+	}
+}
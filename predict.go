@@ -0,0 +1,303 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+)
+
+// DefaultPredictLimit is how many commands zist predict lists
+const DefaultPredictLimit = 3
+
+// How much each context counts towards a prediction: what followed the
+// previous command in this directory, what followed it anywhere, and what
+// is run in this directory at all. Contexts with no history drop out and
+// the others make up the difference.
+const (
+	predictWeightBoth = 0.5
+	predictWeightPrev = 0.3
+	predictWeightDir  = 0.2
+)
+
+// predictRowidKey is the meta key of the last rowid TrainPredictor counted
+const predictRowidKey = "predict_rowid"
+
+// Prediction is a command likely to be run next
+type Prediction struct {
+	Command     string  `json:"command"`
+	Probability float64 `json:"probability"`
+	Count       int     `json:"count"` // Times seen in the contexts that suggested it
+}
+
+// PredictOptions is the context to predict the next command in
+type PredictOptions struct {
+	Prev  string // The command just run, empty if unknown
+	Dir   string // Directory the next command runs in, empty if unknown
+	Limit int
+}
+
+// predictState is where a history was after the last command counted
+type predictState struct {
+	command   string
+	dir       string
+	prevDir   string
+	timestamp float64
+}
+
+// transitionKey is a command run after prev in dir. An empty prev counts
+// the command for dir alone, and an empty dir for prev alone.
+type transitionKey struct {
+	prev, dir, next string
+}
+
+// transition is how often and when last a transitionKey happened
+type transition struct {
+	count    int
+	lastSeen float64
+}
+
+// TrainPredictor counts the commands added since it last ran into the
+// transitions table: which command followed which within a session, by
+// directory, which is followed through cd commands where history doesn't
+// record it. Each history carries on from where it stopped last time. It
+// returns how many commands it counted.
+func TrainPredictor(ctx context.Context, db *sql.DB) (int, error) {
+	var after int64
+	err := db.QueryRowContext(ctx, `SELECT CAST(value AS INTEGER) FROM meta WHERE key = ?`, predictRowidKey).Scan(&after)
+	if err != nil && err != sql.ErrNoRows {
+		return 0, fmt.Errorf("failed to read predictor progress: %w", err)
+	}
+
+	states := make(map[string]*predictState)
+	rows, err := db.QueryContext(ctx, `SELECT source, command, dir, prev_dir, timestamp FROM predict_state`)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read predictor state: %w", err)
+	}
+	for rows.Next() {
+		var source string
+		var s predictState
+		if err := rows.Scan(&source, &s.command, &s.dir, &s.prevDir, &s.timestamp); err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("failed to scan predictor state: %w", err)
+		}
+		states[source] = &s
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, fmt.Errorf("error iterating predictor state: %w", err)
+	}
+
+	rows, err = db.QueryContext(ctx, `SELECT rowid, source, command, timestamp, COALESCE(cwd, '') FROM commands
+		WHERE rowid > ? ORDER BY source, timestamp`, after)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read new commands: %w", err)
+	}
+	home, _ := os.UserHomeDir()
+	counts := make(map[transitionKey]*transition)
+	count := func(key transitionKey, timestamp float64) {
+		t, ok := counts[key]
+		if !ok {
+			t = &transition{}
+			counts[key] = t
+		}
+		t.count++
+		t.lastSeen = max(t.lastSeen, timestamp)
+	}
+	trained := 0
+	last := after
+	for rows.Next() {
+		var rowid int64
+		var source, command, cwd string
+		var timestamp float64
+		if err := rows.Scan(&rowid, &source, &command, &timestamp, &cwd); err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("failed to scan command: %w", err)
+		}
+		last = max(last, rowid)
+		trained++
+
+		s, ok := states[source]
+		if !ok || timestamp-s.timestamp > timelineSessionGap.Seconds() {
+			s = &predictState{}
+			states[source] = s
+		}
+		if cwd != "" {
+			s.dir = cwd
+		}
+		if s.command != "" && s.command != command {
+			count(transitionKey{s.command, s.dir, command}, timestamp)
+			if s.dir != "" {
+				count(transitionKey{s.command, "", command}, timestamp)
+			}
+		}
+		if s.dir != "" {
+			count(transitionKey{"", s.dir, command}, timestamp)
+		}
+		s.command, s.timestamp = command, timestamp
+		s.dir, s.prevDir = followCD(command, s.dir, s.prevDir, home)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, fmt.Errorf("error iterating new commands: %w", err)
+	}
+	if trained == 0 {
+		return 0, nil
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.PrepareContext(ctx, `INSERT INTO transitions (prev, dir, next, count, last_seen) VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT(prev, dir, next) DO UPDATE SET count = count + excluded.count, last_seen = max(last_seen, excluded.last_seen)`)
+	if err != nil {
+		return 0, fmt.Errorf("failed to prepare statement: %w", err)
+	}
+	defer stmt.Close()
+	for key, t := range counts {
+		if _, err := stmt.ExecContext(ctx, key.prev, key.dir, key.next, t.count, t.lastSeen); err != nil {
+			return 0, fmt.Errorf("failed to store transition: %w", err)
+		}
+	}
+	for source, s := range states {
+		if _, err := tx.ExecContext(ctx, `INSERT OR REPLACE INTO predict_state (source, command, dir, prev_dir, timestamp) VALUES (?, ?, ?, ?, ?)`,
+			source, s.command, s.dir, s.prevDir, s.timestamp); err != nil {
+			return 0, fmt.Errorf("failed to store predictor state: %w", err)
+		}
+	}
+	if _, err := tx.ExecContext(ctx, `INSERT INTO meta (key, value) VALUES (?, ?)
+		ON CONFLICT(key) DO UPDATE SET value = excluded.value`, predictRowidKey, last); err != nil {
+		return 0, fmt.Errorf("failed to save predictor progress: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to commit transitions: %w", err)
+	}
+	return trained, nil
+}
+
+// ResetPredictor forgets every transition, so the next TrainPredictor
+// counts all history again
+func ResetPredictor(ctx context.Context, db *sql.DB) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+	if err := resetPredictor(ctx, tx); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit reset: %w", err)
+	}
+	return nil
+}
+
+// resetPredictor is ResetPredictor within tx
+func resetPredictor(ctx context.Context, tx *sql.Tx) error {
+	for _, query := range []string{
+		`DELETE FROM transitions`,
+		`DELETE FROM predict_state`,
+		`DELETE FROM meta WHERE key = '` + predictRowidKey + `'`,
+	} {
+		if _, err := tx.ExecContext(ctx, query); err != nil {
+			return fmt.Errorf("failed to reset predictor: %w", err)
+		}
+	}
+	return nil
+}
+
+// Predict returns up to opts.Limit commands most likely to be run after
+// opts.Prev in opts.Dir, most likely first. Each context's share of a
+// command is weighed by how much the context counts. The previous command
+// itself and multi-line commands aren't predicted.
+func Predict(ctx context.Context, db *sql.DB, opts PredictOptions) ([]Prediction, error) {
+	if opts.Limit <= 0 {
+		opts.Limit = DefaultPredictLimit
+	}
+	type given struct {
+		prev, dir string
+		weight    float64
+	}
+	var contexts []given
+	if opts.Prev != "" && opts.Dir != "" {
+		contexts = append(contexts, given{opts.Prev, opts.Dir, predictWeightBoth})
+	}
+	if opts.Prev != "" {
+		contexts = append(contexts, given{opts.Prev, "", predictWeightPrev})
+	}
+	if opts.Dir != "" {
+		contexts = append(contexts, given{"", opts.Dir, predictWeightDir})
+	}
+
+	type candidate struct {
+		Prediction
+		lastSeen float64
+	}
+	candidates := make(map[string]*candidate)
+	total := 0.0
+	for _, c := range contexts {
+		rows, err := db.QueryContext(ctx, `SELECT next, count, last_seen FROM transitions WHERE prev = ? AND dir = ?`, c.prev, c.dir)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read transitions: %w", err)
+		}
+		seen := make(map[string]int)
+		lastSeen := make(map[string]float64)
+		sum := 0
+		for rows.Next() {
+			var next string
+			var n int
+			var last float64
+			if err := rows.Scan(&next, &n, &last); err != nil {
+				rows.Close()
+				return nil, fmt.Errorf("failed to scan transition: %w", err)
+			}
+			sum += n
+			if next == opts.Prev || strings.Contains(next, "\n") {
+				continue
+			}
+			seen[next] = n
+			lastSeen[next] = last
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return nil, fmt.Errorf("error iterating transitions: %w", err)
+		}
+		if sum == 0 {
+			continue
+		}
+		total += c.weight
+		for next, n := range seen {
+			p, ok := candidates[next]
+			if !ok {
+				p = &candidate{Prediction: Prediction{Command: next}}
+				candidates[next] = p
+			}
+			p.Probability += c.weight * float64(n) / float64(sum)
+			p.Count += n
+			p.lastSeen = max(p.lastSeen, lastSeen[next])
+		}
+	}
+
+	predictions := make([]candidate, 0, len(candidates))
+	for _, p := range candidates {
+		p.Probability /= total
+		predictions = append(predictions, *p)
+	}
+	slices.SortFunc(predictions, func(a, b candidate) int {
+		return cmp.Or(cmp.Compare(b.Probability, a.Probability), cmp.Compare(b.lastSeen, a.lastSeen), strings.Compare(a.Command, b.Command))
+	})
+	if len(predictions) > opts.Limit {
+		predictions = predictions[:opts.Limit]
+	}
+	results := make([]Prediction, len(predictions))
+	for i, p := range predictions {
+		results[i] = p.Prediction
+	}
+	return results, nil
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+)
+
+func TestPredict(t *testing.T) {
+	ctx := context.Background()
+	db, err := InitDB(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("InitDB() error = %v", err)
+	}
+	defer db.Close()
+
+	first := []Command{
+		{Source: "/laptop", Timestamp: 1000, Command: "cd /src/app"},
+		{Source: "/laptop", Timestamp: 1010, Command: "make build"},
+		{Source: "/laptop", Timestamp: 1020, Command: "make test"},
+		{Source: "/laptop", Timestamp: 1030, Command: "cd /src/docs"},
+		{Source: "/laptop", Timestamp: 1040, Command: "make build"},
+		{Source: "/laptop", Timestamp: 1050, Command: "make serve"},
+	}
+	if _, _, err := InsertCommands(ctx, db, first); err != nil {
+		t.Fatal(err)
+	}
+	trained, err := TrainPredictor(ctx, db)
+	if err != nil || trained != len(first) {
+		t.Fatalf("TrainPredictor() = %d, %v, want %d", trained, err, len(first))
+	}
+
+	// Carries on in /src/docs where the history stopped, and a long break
+	// starts a new session with no previous command
+	second := []Command{
+		{Source: "/laptop", Timestamp: 1060, Command: "make build"},
+		{Source: "/laptop", Timestamp: 1070, Command: "make serve"},
+		{Source: "/laptop", Timestamp: 1070 + 2*timelineSessionGap.Seconds(), Command: "ls"},
+		{Source: "/server", Timestamp: 500, Command: "make build", CWD: "/srv"},
+		{Source: "/server", Timestamp: 510, Command: "make build", CWD: "/srv"},
+		{Source: "/server", Timestamp: 520, Command: "make install", CWD: "/srv"},
+		{Source: "/server", Timestamp: 530, Command: "printf 'a\nb'", CWD: "/srv"},
+	}
+	if _, _, err := InsertCommands(ctx, db, second); err != nil {
+		t.Fatal(err)
+	}
+	if trained, err := TrainPredictor(ctx, db); err != nil || trained != len(second) {
+		t.Fatalf("TrainPredictor() = %d, %v, want %d", trained, err, len(second))
+	}
+	if trained, err := TrainPredictor(ctx, db); err != nil || trained != 0 {
+		t.Fatalf("TrainPredictor() again = %d, %v, want 0", trained, err)
+	}
+
+	var afterLS int
+	db.QueryRow(`SELECT COUNT(*) FROM transitions WHERE next = 'ls' AND prev != ''`).Scan(&afterLS)
+	if afterLS != 0 {
+		t.Errorf("ls after a long break counted as following %d command(s)", afterLS)
+	}
+
+	tests := []struct {
+		name string
+		opts PredictOptions
+		want []string
+	}{
+		{"in app", PredictOptions{Prev: "make build", Dir: "/src/app"}, []string{"make test", "make serve", "make install"}},
+		{"in docs", PredictOptions{Prev: "make build", Dir: "/src/docs"}, []string{"make serve", "make test", "make install"}},
+		{"anywhere", PredictOptions{Prev: "make build"}, []string{"make serve", "make test", "make install"}},
+		{"limit", PredictOptions{Prev: "make build", Dir: "/src/docs", Limit: 1}, []string{"make serve"}},
+		{"directory only", PredictOptions{Dir: "/srv"}, []string{"make build", "make install"}},
+		{"unknown directory", PredictOptions{Prev: "make install", Dir: "/tmp"}, nil},
+		{"nothing known", PredictOptions{Prev: "htop", Dir: "/tmp"}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			predictions, err := Predict(ctx, db, tt.opts)
+			if err != nil {
+				t.Fatalf("Predict() error = %v", err)
+			}
+			var got []string
+			sum := 0.0
+			for _, p := range predictions {
+				got = append(got, p.Command)
+				sum += p.Probability
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("Predict() = %v, want %v", got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Fatalf("Predict() = %v, want %v", got, tt.want)
+				}
+			}
+			if sum > 1.0001 {
+				t.Errorf("Predict() probabilities add up to %f", sum)
+			}
+		})
+	}
+
+	if err := ResetPredictor(ctx, db); err != nil {
+		t.Fatalf("ResetPredictor() error = %v", err)
+	}
+	if predictions, _ := Predict(ctx, db, PredictOptions{Prev: "make build"}); len(predictions) != 0 {
+		t.Errorf("Predict() after reset = %+v", predictions)
+	}
+	if trained, err := TrainPredictor(ctx, db); err != nil || trained != len(first)+len(second) {
+		t.Errorf("TrainPredictor() after reset = %d, %v, want %d", trained, err, len(first)+len(second))
+	}
+}
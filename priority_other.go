@@ -0,0 +1,11 @@
+//go:build !(linux || darwin || freebsd)
+
+package main
+
+import "syscall"
+
+func lowerPriority() {}
+
+func detachedAttr() *syscall.SysProcAttr {
+	return nil
+}
@@ -0,0 +1,19 @@
+//go:build linux || darwin || freebsd
+
+package main
+
+import "syscall"
+
+// backgroundNice is the niceness background jobs run at
+const backgroundNice = 10
+
+// lowerPriority makes the process yield the CPU to interactive work
+func lowerPriority() {
+	syscall.Setpriority(syscall.PRIO_PROCESS, 0, backgroundNice)
+}
+
+// detachedAttr starts a process in its own session, so it outlives the
+// terminal it was started from
+func detachedAttr() *syscall.SysProcAttr {
+	return &syscall.SysProcAttr{Setsid: true}
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+	"runtime/pprof"
+	"time"
+)
+
+// StartProfile starts writing a CPU profile to cpuPath and arranges for a
+// heap profile to be written to memPath, either of which may be empty. The
+// returned stop finishes both.
+func StartProfile(cpuPath, memPath string) (stop func() error, err error) {
+	var cpu *os.File
+	if cpuPath != "" {
+		cpu, err = os.Create(expandTilde(cpuPath))
+		if err != nil {
+			return nil, fmt.Errorf("failed to create CPU profile: %w", err)
+		}
+		if err := pprof.StartCPUProfile(cpu); err != nil {
+			cpu.Close()
+			return nil, fmt.Errorf("failed to start CPU profile: %w", err)
+		}
+	}
+
+	return func() error {
+		if cpu != nil {
+			pprof.StopCPUProfile()
+			if err := cpu.Close(); err != nil {
+				return fmt.Errorf("failed to write CPU profile: %w", err)
+			}
+		}
+		if memPath == "" {
+			return nil
+		}
+		mem, err := os.Create(expandTilde(memPath))
+		if err != nil {
+			return fmt.Errorf("failed to create memory profile: %w", err)
+		}
+		defer mem.Close()
+		// Up to date allocation statistics
+		runtime.GC()
+		if err := pprof.WriteHeapProfile(mem); err != nil {
+			return fmt.Errorf("failed to write memory profile: %w", err)
+		}
+		return mem.Close()
+	}, nil
+}
+
+// timings collects the breakdown printed by --timings; nil without it
+var timings *Timings
+
+// Timings records how long each stage of a command took
+type Timings struct {
+	start  time.Time
+	last   time.Time
+	stages []stageTiming
+}
+
+type stageTiming struct {
+	name string
+	took time.Duration
+}
+
+// NewTimings starts timing the first stage
+func NewTimings() *Timings {
+	now := time.Now()
+	return &Timings{start: now, last: now}
+}
+
+// Mark ends the stage called name, which began at the previous Mark. It
+// does nothing on a nil Timings, so callers needn't check --timings.
+func (t *Timings) Mark(name string) {
+	if t == nil {
+		return
+	}
+	now := time.Now()
+	t.stages = append(t.stages, stageTiming{name, now.Sub(t.last)})
+	t.last = now
+}
+
+// Write prints each stage and the total since NewTimings
+func (t *Timings) Write(w io.Writer) {
+	if t == nil || len(t.stages) == 0 {
+		return
+	}
+	width := len("total")
+	for _, s := range t.stages {
+		width = max(width, len(s.name))
+	}
+	fmt.Fprintln(w, "Timings:")
+	for _, s := range t.stages {
+		fmt.Fprintf(w, "  %-*s %10s\n", width, s.name, s.took.Round(time.Microsecond))
+	}
+	fmt.Fprintf(w, "  %-*s %10s\n", width, "total", time.Since(t.start).Round(time.Microsecond))
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestStartProfile(t *testing.T) {
+	dir := t.TempDir()
+	cpu, mem := filepath.Join(dir, "cpu.pprof"), filepath.Join(dir, "mem.pprof")
+	stop, err := StartProfile(cpu, mem)
+	if err != nil {
+		t.Fatalf("StartProfile() error = %v", err)
+	}
+	if err := stop(); err != nil {
+		t.Fatalf("stop() error = %v", err)
+	}
+	for _, path := range []string{cpu, mem} {
+		if info, err := os.Stat(path); err != nil || info.Size() == 0 {
+			t.Errorf("%s not written: %v", filepath.Base(path), err)
+		}
+	}
+
+	if _, err := StartProfile(filepath.Join(dir, "missing", "cpu.pprof"), ""); err == nil {
+		t.Error("StartProfile() error = nil for an unwritable path")
+	}
+}
+
+func TestTimings(t *testing.T) {
+	var off *Timings
+	off.Mark("ignored")
+
+	tm := NewTimings()
+	tm.Mark("open database")
+	tm.Mark("search commands")
+	var buf bytes.Buffer
+	tm.Write(&buf)
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 4 || !strings.HasPrefix(lines[1], "  open database ") || !strings.HasPrefix(lines[3], "  total ") {
+		t.Errorf("Write() =\n%s\nwant a line per stage and the total", buf.String())
+	}
+}
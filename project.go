@@ -0,0 +1,35 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// projectMarkers are files or directories that mark the root of a project
+var projectMarkers = []string{
+	".git", "go.mod", "package.json", "pyproject.toml", "setup.py", "requirements.txt",
+	"Cargo.toml", "Gemfile", "pom.xml", "build.gradle", "mix.exs", "composer.json",
+}
+
+// ProjectRoot returns the nearest directory at or above dir containing a
+// project marker, or "" if there is none. The home directory and / never
+// count, since dotfile repos there would make everything one project.
+func ProjectRoot(dir string) string {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return ""
+	}
+	home, _ := os.UserHomeDir()
+
+	for {
+		if dir == home || dir == filepath.Dir(dir) {
+			return ""
+		}
+		for _, marker := range projectMarkers {
+			if _, err := os.Stat(filepath.Join(dir, marker)); err == nil {
+				return dir
+			}
+		}
+		dir = filepath.Dir(dir)
+	}
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestProjectRoot(t *testing.T) {
+	base := t.TempDir()
+	mkdir := func(parts ...string) string {
+		dir := filepath.Join(append([]string{base}, parts...)...)
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+		return dir
+	}
+	touch := func(path string) {
+		if err := os.WriteFile(path, nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	repo := mkdir("repo")
+	mkdir("repo", ".git")
+	sub := mkdir("repo", "services", "api")
+	touch(filepath.Join(sub, "go.mod"))
+	deep := mkdir("repo", "services", "api", "internal", "db")
+	docs := mkdir("repo", "docs")
+	loose := mkdir("scratch")
+
+	tests := []struct {
+		dir  string
+		want string
+	}{
+		{repo, repo},
+		{docs, repo},
+		{sub, sub},
+		{deep, sub},
+		{loose, ""},
+	}
+	for _, tt := range tests {
+		if got := ProjectRoot(tt.dir); got != tt.want {
+			t.Errorf("ProjectRoot(%q) = %q, want %q", tt.dir, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Defaults for `zist prompt-segment`
+const (
+	DefaultPromptFormat = "{today}"
+	DefaultPromptMaxAge = 30 * time.Second
+	DefaultPromptBudget = 50 * time.Millisecond
+)
+
+// promptCacheKeep is how long a cached segment nobody asked for again is kept
+const promptCacheKeep = 24 * time.Hour
+
+// DefaultPromptCachePath is where prompt-segment keeps its counts between
+// prompts
+var DefaultPromptCachePath = filepath.Join(CacheDir(), "prompt-segment.json")
+
+// PromptSegmentRequest is what `zist prompt-segment` shows and how fast
+type PromptSegmentRequest struct {
+	DBPath    string
+	Dir       string        // Directory whose project is counted
+	Format    string        // Text with {today}, {project_today} and the like filled in
+	MaxAge    time.Duration // Cached counts younger than this are shown as they are; 0 always counts
+	Budget    time.Duration // Counting longer than this shows the cached counts, however old
+	CachePath string
+}
+
+// promptCacheEntry is the counts for one database and project
+type promptCacheEntry struct {
+	All     CommandCounts `json:"all"`
+	Project CommandCounts `json:"project"`
+	At      float64       `json:"at"` // Unix timestamp of the count
+}
+
+// runPromptSegment prints req.Format filled in with counts of commands,
+// taking no longer than req.Budget. Counts are cached per database and
+// project, so most prompts only read a small file. When counting runs out of
+// time, the last counts are shown however old, or nothing if there are
+// none. Formats that use the project print nothing outside one, so the
+// segment hides itself.
+func runPromptSegment(ctx context.Context, req PromptSegmentRequest, w io.Writer) error {
+	project := ProjectRoot(req.Dir)
+	if project == "" && strings.Contains(req.Format, "{project") {
+		return nil
+	}
+	now := time.Now()
+	key := expandTilde(req.DBPath) + "\t" + project
+	cache := readPromptCache(req.CachePath)
+	cached, ok := cache[key]
+	if ok && req.MaxAge > 0 && now.Sub(time.Unix(int64(cached.At), 0)) < req.MaxAge {
+		return writePromptSegment(w, req.Format, project, cached)
+	}
+
+	if req.Budget > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, req.Budget)
+		defer cancel()
+	}
+	entry, err := countPromptSegment(ctx, req.DBPath, now, project)
+	switch {
+	case err != nil && ok:
+		return writePromptSegment(w, req.Format, project, cached)
+	case errors.Is(err, context.DeadlineExceeded) || errors.Is(ctx.Err(), context.DeadlineExceeded):
+		return nil
+	case err != nil:
+		return err
+	}
+
+	if req.MaxAge > 0 {
+		cache[key] = entry
+		// A cache that can't be written only costs the next prompt a count
+		writePromptCache(req.CachePath, cache, now)
+	}
+	return writePromptSegment(w, req.Format, project, entry)
+}
+
+// countPromptSegment counts the commands in the database and, unless
+// project is "", those run in the project
+func countPromptSegment(ctx context.Context, dbPath string, now time.Time, project string) (promptCacheEntry, error) {
+	db, err := OpenReadOnlyDB(dbPath)
+	if err != nil {
+		return promptCacheEntry{}, err
+	}
+	defer db.Close()
+
+	entry := promptCacheEntry{At: float64(now.Unix())}
+	if entry.All, err = CountCommands(ctx, db, now, ""); err != nil {
+		return promptCacheEntry{}, err
+	}
+	if project != "" {
+		if entry.Project, err = CountCommands(ctx, db, now, project); err != nil {
+			return promptCacheEntry{}, err
+		}
+	}
+	return entry, nil
+}
+
+// writePromptSegment prints format with its placeholders filled in
+func writePromptSegment(w io.Writer, format, project string, e promptCacheEntry) error {
+	itoa := func(n int64) string { return strconv.FormatInt(n, 10) }
+	r := strings.NewReplacer(
+		"{today}", itoa(e.All.Today),
+		"{week}", itoa(e.All.Week),
+		"{total}", itoa(e.All.Total),
+		"{project}", filepath.Base(project),
+		"{project_today}", itoa(e.Project.Today),
+		"{project_week}", itoa(e.Project.Week),
+		"{project_total}", itoa(e.Project.Total),
+	)
+	_, err := fmt.Fprintln(w, r.Replace(format))
+	return err
+}
+
+// readPromptCache reads the cached counts, treating a missing or damaged
+// cache as empty
+func readPromptCache(path string) map[string]promptCacheEntry {
+	cache := map[string]promptCacheEntry{}
+	if data, err := os.ReadFile(expandTilde(path)); err == nil {
+		if json.Unmarshal(data, &cache) != nil {
+			return map[string]promptCacheEntry{}
+		}
+	}
+	return cache
+}
+
+// writePromptCache replaces the cache file with cache, leaving out entries
+// not counted for promptCacheKeep. The file is replaced in one go, so
+// prompts drawn at the same time never read half of it.
+func writePromptCache(path string, cache map[string]promptCacheEntry, now time.Time) error {
+	for key, e := range cache {
+		if now.Sub(time.Unix(int64(e.At), 0)) > promptCacheKeep {
+			delete(cache, key)
+		}
+	}
+	data, err := json.Marshal(cache)
+	if err != nil {
+		return err
+	}
+
+	path = expandTilde(path)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to create %s: %w", filepath.Dir(path), err)
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".zist-*")
+	if err != nil {
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	return nil
+}
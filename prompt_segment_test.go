@@ -0,0 +1,92 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestRunPromptSegment(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	dbPath := filepath.Join(dir, "test.db")
+	project := filepath.Join(dir, "src", "api")
+	if err := os.MkdirAll(filepath.Join(project, ".git"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	db, err := InitDB(dbPath)
+	if err != nil {
+		t.Fatalf("InitDB() error = %v", err)
+	}
+	defer db.Close()
+
+	now := float64(time.Now().Unix())
+	if _, _, err := InsertCommands(ctx, db, []Command{
+		{Source: "/h", Timestamp: now - 2, Command: "make", CWD: project},
+		{Source: "/h", Timestamp: now - 1, Command: "ls", CWD: dir},
+		{Source: "/h", Timestamp: now - 400*24*3600, Command: "git init", CWD: project},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	req := PromptSegmentRequest{
+		DBPath:    dbPath,
+		Dir:       filepath.Join(project, "cmd"),
+		Format:    "{project}: {project_today}/{project_total} of {today}",
+		MaxAge:    time.Minute,
+		Budget:    time.Second,
+		CachePath: filepath.Join(dir, "cache", "prompt.json"),
+	}
+	segment := func(ctx context.Context, req PromptSegmentRequest) string {
+		t.Helper()
+		var buf bytes.Buffer
+		if err := runPromptSegment(ctx, req, &buf); err != nil {
+			t.Fatalf("runPromptSegment() error = %v", err)
+		}
+		return buf.String()
+	}
+
+	if got := segment(ctx, req); got != "api: 1/2 of 2\n" {
+		t.Errorf("runPromptSegment() = %q, want api: 1/2 of 2", got)
+	}
+
+	// Cached counts are shown until they're older than MaxAge
+	if _, _, err := InsertCommands(ctx, db, []Command{{Source: "/h", Timestamp: now, Command: "make test", CWD: project}}); err != nil {
+		t.Fatal(err)
+	}
+	if got := segment(ctx, req); got != "api: 1/2 of 2\n" {
+		t.Errorf("runPromptSegment() = %q, want the cached counts", got)
+	}
+	fresh := req
+	fresh.MaxAge = 0
+	if got := segment(ctx, fresh); got != "api: 2/3 of 3\n" {
+		t.Errorf("runPromptSegment() without caching = %q, want api: 2/3 of 3", got)
+	}
+
+	// Out of time, the last counts are shown however old
+	canceled, cancel := context.WithTimeout(ctx, 0)
+	defer cancel()
+	stale := req
+	stale.MaxAge = time.Nanosecond
+	if got := segment(canceled, stale); got != "api: 1/2 of 2\n" {
+		t.Errorf("runPromptSegment() out of time = %q, want the cached counts", got)
+	}
+	stale.CachePath = filepath.Join(dir, "empty.json")
+	if got := segment(canceled, stale); got != "" {
+		t.Errorf("runPromptSegment() out of time with no cache = %q, want nothing", got)
+	}
+
+	// Outside a project, formats using it hide the segment
+	outside := req
+	outside.Dir = dir
+	if got := segment(ctx, outside); got != "" {
+		t.Errorf("runPromptSegment() outside a project = %q, want nothing", got)
+	}
+	outside.Format = DefaultPromptFormat
+	if got := segment(ctx, outside); got != "3\n" {
+		t.Errorf("runPromptSegment() = %q, want 3", got)
+	}
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// QuickVersion is the version of the QuickResponse JSON shape. Fields are
+// only ever added; anything else bumps the version.
+const QuickVersion = 1
+
+// DefaultQuickBudget is how long zist quick may spend searching
+const DefaultQuickBudget = 50 * time.Millisecond
+
+// quickScanFactor bounds how many rows are read per result wanted, so a
+// common word in a huge history can't blow the budget
+const quickScanFactor = 20
+
+// QuickResult is one history entry for a launcher
+type QuickResult struct {
+	ID        int64   `json:"id"`
+	Command   string  `json:"command"`
+	Source    string  `json:"source"`
+	Timestamp float64 `json:"timestamp"`
+	Time      string  `json:"time"`  // Timestamp in RFC 3339
+	Count     int     `json:"count"` // times seen among the rows scanned
+}
+
+// QuickResponse is the output of zist quick --json
+type QuickResponse struct {
+	Version int           `json:"version"`
+	Query   string        `json:"query"`
+	Results []QuickResult `json:"results"`
+	Partial bool          `json:"partial"` // the budget ran out before the scan finished
+	TookMs  float64       `json:"took_ms"`
+}
+
+// OpenReadOnlyDB opens an existing database without creating or migrating
+// anything, so it is cheap to open and safe alongside a running collect
+func OpenReadOnlyDB(dbPath string) (*sql.DB, error) {
+	expandedPath := expandTilde(dbPath)
+	if _, err := os.Stat(expandedPath); err != nil {
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("no database at %s, run zist collect first", expandedPath)
+		}
+		return nil, fmt.Errorf("failed to open database: %w", err)
+	}
+
+	db, err := sql.Open("sqlite", "file:"+expandedPath+"?mode=ro&_pragma=query_only(1)&_pragma=busy_timeout(20)")
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database: %w", err)
+	}
+	return db, nil
+}
+
+// QuickSearch finds up to limit distinct commands matching query, newest
+// first, with commands starting with the query ranked ahead of the rest.
+// Only the newest rows are scanned, and whatever was found is returned when
+// ctx expires, with partial set.
+func QuickSearch(ctx context.Context, db *sql.DB, query string, limit int) (results []QuickResult, partial bool, err error) {
+	if limit <= 0 {
+		limit = 10
+	}
+	scan := limit * quickScanFactor
+
+	var rows *sql.Rows
+	if ftsQuery := buildFTSQuery(query, false); ftsQuery != "" {
+		// FTS rowids come back in insertion order, which is close enough to
+		// recency and avoids sorting every match
+		rows, err = db.QueryContext(ctx, `SELECT c.rowid, c.command, c.source, c.timestamp, c.env_prefix
+			FROM commands_fts f JOIN commands c ON c.rowid = f.rowid
+			WHERE commands_fts MATCH ?
+			ORDER BY f.rowid DESC LIMIT ?`, ftsQuery, scan)
+	} else {
+		rows, err = db.QueryContext(ctx, `SELECT rowid, command, source, timestamp, env_prefix
+			FROM commands ORDER BY CAST(timestamp AS INTEGER) DESC, seq DESC LIMIT ?`, scan)
+	}
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, true, nil
+		}
+		return nil, false, fmt.Errorf("failed to search commands: %w", err)
+	}
+	defer rows.Close()
+
+	seen := make(map[string]int)
+	for rows.Next() {
+		var r SearchResult
+		if err := rows.Scan(&r.ID, &r.Command, &r.Source, &r.Timestamp, &r.EnvPrefix); err != nil {
+			return nil, false, fmt.Errorf("failed to scan command: %w", err)
+		}
+		full := r.FullCommand()
+		if i, ok := seen[full]; ok {
+			results[i].Count++
+			continue
+		}
+		seen[full] = len(results)
+		results = append(results, QuickResult{ID: r.ID, Command: full, Source: r.Source, Timestamp: r.Timestamp, Time: JSONTimestamp(r.Timestamp), Count: 1})
+	}
+	if err := rows.Err(); err != nil {
+		if ctx.Err() == nil && !errors.Is(err, context.DeadlineExceeded) {
+			return nil, false, fmt.Errorf("error iterating results: %w", err)
+		}
+		partial = true
+	}
+
+	return rankQuick(results, query, limit), partial, nil
+}
+
+// rankQuick moves commands that start with the query ahead of the rest,
+// keeping recency order within each group
+func rankQuick(results []QuickResult, query string, limit int) []QuickResult {
+	prefix := strings.ToLower(strings.TrimSpace(query))
+	ranked := make([]QuickResult, 0, len(results))
+	var rest []QuickResult
+	for _, r := range results {
+		if prefix != "" && strings.HasPrefix(strings.ToLower(r.Command), prefix) {
+			ranked = append(ranked, r)
+		} else {
+			rest = append(rest, r)
+		}
+	}
+	ranked = append(ranked, rest...)
+
+	if len(ranked) > limit {
+		ranked = ranked[:limit]
+	}
+	return ranked
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func newQuickDB(t *testing.T) string {
+	t.Helper()
+
+	dbPath := filepath.Join(t.TempDir(), "test.db")
+	db, err := InitDB(dbPath)
+	if err != nil {
+		t.Fatalf("InitDB() error = %v", err)
+	}
+	defer db.Close()
+
+	commands := []Command{
+		{Source: "/h", Timestamp: 100, Command: "git status"},
+		{Source: "/h", Timestamp: 200, Command: "echo git"},
+		{Source: "/h", Timestamp: 300, Command: "git status"},
+		{Source: "/h", Timestamp: 400, Command: "ls -la"},
+		{Source: "/h", Timestamp: 500, Command: "push", EnvPrefix: "GIT_TRACE=1"},
+	}
+	if _, _, err := InsertCommands(context.Background(), db, commands); err != nil {
+		t.Fatalf("InsertCommands() error = %v", err)
+	}
+	return dbPath
+}
+
+func TestQuickSearch(t *testing.T) {
+	db, err := OpenReadOnlyDB(newQuickDB(t))
+	if err != nil {
+		t.Fatalf("OpenReadOnlyDB() error = %v", err)
+	}
+	defer db.Close()
+
+	tests := []struct {
+		name  string
+		query string
+		limit int
+		want  []string
+	}{
+		{"prefix matches first", "git", 10, []string{"git status", "echo git"}},
+		{"empty query is newest first", "", 10, []string{"GIT_TRACE=1 push", "ls -la", "git status", "echo git"}},
+		{"limit", "", 2, []string{"GIT_TRACE=1 push", "ls -la"}},
+		{"no match", "kubectl", 10, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			results, partial, err := QuickSearch(context.Background(), db, tt.query, tt.limit)
+			if err != nil {
+				t.Fatalf("QuickSearch() error = %v", err)
+			}
+			if partial {
+				t.Error("QuickSearch() partial = true without a deadline")
+			}
+			var got []string
+			for _, r := range results {
+				got = append(got, r.Command)
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("QuickSearch() = %q, want %q", got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Errorf("QuickSearch()[%d] = %q, want %q", i, got[i], tt.want[i])
+				}
+			}
+		})
+	}
+
+	results, _, _ := QuickSearch(context.Background(), db, "status", 10)
+	if len(results) != 1 || results[0].Count != 2 || results[0].Timestamp != 300 {
+		t.Errorf("duplicates should collapse into the newest with a count, got %+v", results)
+	}
+}
+
+func TestQuickReadOnly(t *testing.T) {
+	db, err := OpenReadOnlyDB(newQuickDB(t))
+	if err != nil {
+		t.Fatalf("OpenReadOnlyDB() error = %v", err)
+	}
+	defer db.Close()
+
+	if _, err := db.Exec(`DELETE FROM commands`); err == nil {
+		t.Error("write through a read-only database succeeded")
+	}
+
+	if _, err := OpenReadOnlyDB(filepath.Join(t.TempDir(), "missing.db")); err == nil {
+		t.Error("OpenReadOnlyDB() of a missing file should fail instead of creating it")
+	}
+}
+
+func TestQuickBudgetExpired(t *testing.T) {
+	db, err := OpenReadOnlyDB(newQuickDB(t))
+	if err != nil {
+		t.Fatalf("OpenReadOnlyDB() error = %v", err)
+	}
+	defer db.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), -time.Second)
+	defer cancel()
+
+	_, partial, err := QuickSearch(ctx, db, "git", 10)
+	if err != nil {
+		t.Fatalf("QuickSearch() with an expired budget error = %v, want partial results", err)
+	}
+	if !partial {
+		t.Error("QuickSearch() partial = false with an expired budget")
+	}
+}
+
+func TestRunQuickJSON(t *testing.T) {
+	dbPath := newQuickDB(t)
+
+	var out bytes.Buffer
+	if err := runQuick(context.Background(), dbPath, "kubectl", 10, time.Second, true, ColorAuto, &out); err != nil {
+		t.Fatalf("runQuick() error = %v", err)
+	}
+
+	// Launchers rely on these keys being present even with no results
+	var resp map[string]json.RawMessage
+	if err := json.Unmarshal(out.Bytes(), &resp); err != nil {
+		t.Fatalf("output is not JSON: %v", err)
+	}
+	for _, key := range []string{"version", "query", "results", "partial", "took_ms"} {
+		if _, ok := resp[key]; !ok {
+			t.Errorf("response is missing %q: %s", key, out.String())
+		}
+	}
+	if string(resp["results"]) != "[]" {
+		t.Errorf("results = %s, want []", resp["results"])
+	}
+}
+
+func TestPrefixSearch(t *testing.T) {
+	dbPath := newQuickDB(t)
+	db, err := InitDB(dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	extra := []Command{
+		{Source: "/other", Timestamp: 600, Command: "git stash\npop"},
+		{Source: "/other", Timestamp: 700, Command: "Git log"},
+		{Source: "/other", Timestamp: 800, Command: "git"},
+		{Source: "/other", Timestamp: 900, Command: "git_100%"},
+	}
+	if _, _, err := InsertCommands(context.Background(), db, extra); err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+
+	tests := []struct {
+		prefix string
+		want   string
+	}{
+		// Distinct, newest first, matching case; not the prefix itself
+		{"git", "git_100%\x00git stash\npop\x00git status\x00"},
+		{"git s", "git stash\npop\x00git status\x00"},
+		// Wildcards are plain text
+		{"git_1", "git_100%\x00"},
+		{"git%", ""},
+		{"GIT_TRACE=1 p", "GIT_TRACE=1 push\x00"},
+	}
+	for _, tt := range tests {
+		var buf bytes.Buffer
+		if err := runPrefixSearch(context.Background(), dbPath, tt.prefix, 10, true, &buf); err != nil {
+			t.Fatalf("runPrefixSearch(%q) error = %v", tt.prefix, err)
+		}
+		if buf.String() != tt.want {
+			t.Errorf("runPrefixSearch(%q) = %q, want %q", tt.prefix, buf.String(), tt.want)
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := runPrefixSearch(context.Background(), dbPath, "", 2, false, &buf); err != nil || buf.String() != "git_100%\ngit\n" {
+		t.Errorf("runPrefixSearch(\"\", limit 2) = %q, %v", buf.String(), err)
+	}
+}
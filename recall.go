@@ -0,0 +1,162 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"text/tabwriter"
+
+	"github.com/tchaudhry91/zist/llm"
+)
+
+// DefaultRecallK is how many results per query recall is measured over
+const DefaultRecallK = 10
+
+// RecallCase is a labelled query for comparing retrieval modes: the
+// distinct commands matching Relevant are the ones it should find
+type RecallCase struct {
+	Query    string `json:"query"`
+	Relevant string `json:"relevant"` // Regular expression
+}
+
+// DefaultRecallCases ask for commands of the `zist devgen` fixture in
+// words they mostly don't contain
+var DefaultRecallCases = []RecallCase{
+	{"compress a folder into an archive", `^tar |gzip`},
+	{"see running containers", `^docker ps`},
+	{"follow the logs of a service", `logs -f|^tail -f`},
+	{"list kubernetes pods", `get pods|describe pod`},
+	{"copy a file to another machine", `^scp `},
+	{"how much disk space is left", `^(df|du) `},
+	{"put my changes aside for later", `^git stash`},
+	{"switch to another branch", `^git checkout`},
+	{"when does the tls certificate expire", `openssl`},
+	{"install a package", `(apt|brew) install`},
+	{"run the tests", `go test|make test`},
+	{"connect to the database", `^psql `},
+	{"show recent commits", `^git log`},
+	{"search files for some text", `^(rg|grep) `},
+}
+
+// Retrieval modes RecallResult compares
+const (
+	RecallKeyword  = "keyword"  // SearchHistoryByKeywords, as the wizard used alone
+	RecallSemantic = "semantic" // SemanticSearch
+	RecallHybrid   = "hybrid"   // HybridSearch
+)
+
+// RecallResult is the recall@k of each retrieval mode for one case
+type RecallResult struct {
+	Case     RecallCase
+	Relevant int                // Distinct commands in the database matching Case.Relevant
+	Recall   map[string]float64 // By mode: relevant commands in the top k over min(k, Relevant)
+}
+
+// LoadRecallCases reads a JSON array of RecallCase from path
+func LoadRecallCases(path string) ([]RecallCase, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read queries: %w", err)
+	}
+	var cases []RecallCase
+	if err := json.Unmarshal(data, &cases); err != nil {
+		return nil, fmt.Errorf("failed to parse queries %s: %w", path, err)
+	}
+	return cases, nil
+}
+
+// MeasureRecall runs every case through each retrieval mode of search and
+// scores the top k results of each
+func MeasureRecall(ctx context.Context, db *sql.DB, search Searcher, cases []RecallCase, k int) ([]RecallResult, error) {
+	commands, err := distinctCommands(ctx, db)
+	if err != nil {
+		return nil, err
+	}
+
+	var results []RecallResult
+	for _, c := range cases {
+		relevant, err := regexp.Compile(c.Relevant)
+		if err != nil {
+			return nil, fmt.Errorf("invalid pattern for %q: %w", c.Query, err)
+		}
+		result := RecallResult{Case: c, Recall: map[string]float64{}}
+		for _, command := range commands {
+			if relevant.MatchString(command) {
+				result.Relevant++
+			}
+		}
+		if result.Relevant == 0 {
+			return nil, fmt.Errorf("no command matches %q for %q", c.Relevant, c.Query)
+		}
+
+		for _, mode := range []string{RecallKeyword, RecallSemantic, RecallHybrid} {
+			found, err := search(ctx, mode, c.Query, k)
+			if err != nil {
+				return nil, fmt.Errorf("%s search for %q failed: %w", mode, c.Query, err)
+			}
+			hits := 0
+			for _, command := range found {
+				if relevant.MatchString(command) {
+					hits++
+				}
+			}
+			result.Recall[mode] = float64(hits) / float64(min(k, result.Relevant))
+		}
+		results = append(results, result)
+	}
+	return results, nil
+}
+
+// Searcher returns the top k distinct commands for query in a retrieval mode
+type Searcher func(ctx context.Context, mode, query string, k int) ([]string, error)
+
+// dbSearcher searches db in each mode, embedding queries with model
+func dbSearcher(db *sql.DB, e llm.Embedder, model string) Searcher {
+	return func(ctx context.Context, mode, query string, k int) ([]string, error) {
+		var results []SearchResult
+		var err error
+		switch mode {
+		case RecallKeyword:
+			results, err = SearchHistoryByKeywords(ctx, db, extractKeywords(query), k)
+		case RecallSemantic:
+			results, err = SemanticSearch(ctx, db, e, model, SearchOptions{Query: query, Limit: k})
+		case RecallHybrid:
+			results, err = HybridSearch(ctx, db, e, model, SearchOptions{Query: query, Limit: k})
+		default:
+			return nil, fmt.Errorf("unknown retrieval mode %q", mode)
+		}
+		commands := make([]string, len(results))
+		for i, r := range results {
+			commands[i] = r.Command
+		}
+		return commands, err
+	}
+}
+
+// distinctCommands returns every distinct command in the database
+func distinctCommands(ctx context.Context, db *sql.DB) ([]string, error) {
+	rows, err := db.QueryContext(ctx, `SELECT DISTINCT command FROM commands`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list commands: %w", err)
+	}
+	return scanCommands(rows)
+}
+
+// writeRecallReport prints recall@k per case and mode, then the mean
+func writeRecallReport(w io.Writer, results []RecallResult, k int) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "QUERY\tRELEVANT\t%s\t%s\t%s\n", RecallKeyword, RecallSemantic, RecallHybrid)
+	mean := map[string]float64{}
+	for _, r := range results {
+		fmt.Fprintf(tw, "%s\t%d\t%.2f\t%.2f\t%.2f\n", r.Case.Query, r.Relevant, r.Recall[RecallKeyword], r.Recall[RecallSemantic], r.Recall[RecallHybrid])
+		for mode, recall := range r.Recall {
+			mean[mode] += recall / float64(len(results))
+		}
+	}
+	fmt.Fprintf(tw, "mean recall@%d\t\t%.2f\t%.2f\t%.2f\n", k, mean[RecallKeyword], mean[RecallSemantic], mean[RecallHybrid])
+	tw.Flush()
+}
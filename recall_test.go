@@ -0,0 +1,65 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/tchaudhry91/zist/llm"
+)
+
+func TestMeasureRecall(t *testing.T) {
+	ctx := context.Background()
+	db, err := InitDB(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("InitDB() error = %v", err)
+	}
+	defer db.Close()
+
+	commands := []Command{
+		{Source: "/h", Timestamp: 1000, Command: "tar czf public.tgz public/"},
+		{Source: "/h", Timestamp: 1100, Command: "gzip access.log"},
+		{Source: "/h", Timestamp: 1200, Command: "kubectl get pods"},
+		{Source: "/h", Timestamp: 1300, Command: "git commit -m wip"},
+	}
+	if _, _, err := InsertCommands(ctx, db, commands); err != nil {
+		t.Fatal(err)
+	}
+	mock := &llm.Mock{Embedding: conceptEmbedding}
+	if _, err := EmbedMissingCommands(ctx, db, mock, "mock-embed", 0); err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []RecallCase{
+		{Query: "compress a directory", Relevant: `^(tar|gzip) `},
+		{Query: "kubectl pods", Relevant: `^kubectl `},
+	}
+	results, err := MeasureRecall(ctx, db, dbSearcher(db, mock, "mock-embed"), cases, 1)
+	if err != nil {
+		t.Fatalf("MeasureRecall() error = %v", err)
+	}
+	if len(results) != 2 || results[0].Relevant != 2 {
+		t.Fatalf("MeasureRecall() = %+v, want 2 results with 2 relevant for the first", results)
+	}
+	want := map[string]float64{RecallKeyword: 0, RecallSemantic: 1, RecallHybrid: 1}
+	for mode, recall := range want {
+		if results[0].Recall[mode] != recall {
+			t.Errorf("%s recall@1 for %q = %v, want %v", mode, cases[0].Query, results[0].Recall[mode], recall)
+		}
+	}
+	if results[1].Recall[RecallKeyword] != 1 || results[1].Recall[RecallHybrid] != 1 {
+		t.Errorf("recall for %q = %v, want keyword and hybrid to find it", cases[1].Query, results[1].Recall)
+	}
+
+	var out bytes.Buffer
+	writeRecallReport(&out, results, 1)
+	if !strings.Contains(out.String(), "mean recall@1") || !strings.Contains(out.String(), "compress a directory") {
+		t.Errorf("writeRecallReport() =\n%s", out.String())
+	}
+
+	if _, err := MeasureRecall(ctx, db, dbSearcher(db, mock, "mock-embed"), []RecallCase{{Query: "x", Relevant: "^terraform"}}, 1); err == nil {
+		t.Error("MeasureRecall() error = nil for a case nothing matches")
+	}
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"math"
+)
+
+// RecordCommand stores cmd, a command the shell just ran, with the
+// directory, exit code and duration history files leave out. cmd.Source
+// and cmd.Timestamp are the history file the shell writes and the start
+// time it writes there, so the row is the one collecting that file would
+// store: if a collect got there first its row gets the details, and a
+// later collect finds the row already stored. Reports whether a new row
+// was inserted.
+func RecordCommand(ctx context.Context, db *sql.DB, cmd Command) (bool, error) {
+	offsets, err := GetClockOffsets(ctx, db)
+	if err != nil {
+		return false, err
+	}
+	second := math.Floor(cmd.Timestamp + offsets[cmd.Source].Seconds())
+	stored, _ := truncateCommand(cmd.Command, maxCommandLength)
+
+	// The first of the second's rows without details, in case the same
+	// command ran more than once in it
+	result, err := db.ExecContext(ctx, `UPDATE commands SET cwd = ?, exit_code = ?, duration = ?
+		WHERE rowid = (SELECT rowid FROM commands
+			WHERE source = ? AND timestamp >= ? AND timestamp < ? AND command = ? AND env_prefix = ? AND COALESCE(cwd, '') = ''
+			ORDER BY timestamp LIMIT 1)`,
+		cmd.CWD, cmd.ExitCode, cmd.Duration, cmd.Source, second, second+1, stored, cmd.EnvPrefix)
+	if err != nil {
+		return false, fmt.Errorf("failed to record command: %w", err)
+	}
+	if n, err := result.RowsAffected(); err != nil {
+		return false, fmt.Errorf("failed to get rows affected: %w", err)
+	} else if n > 0 {
+		return false, nil
+	}
+
+	// Collect numbers the commands of a second in the order they ran,
+	// which is the order they were recorded
+	var earlier int
+	if err := db.QueryRowContext(ctx, `SELECT COUNT(*) FROM commands WHERE source = ? AND timestamp >= ? AND timestamp < ?`,
+		cmd.Source, second, second+1).Scan(&earlier); err != nil {
+		return false, fmt.Errorf("failed to count commands: %w", err)
+	}
+	cmd.Timestamp = second + float64(earlier)*0.001
+	if cmd.Seq, err = MaxSeq(ctx, db, cmd.Source); err != nil {
+		return false, err
+	}
+	cmd.Seq++
+	inserted, _, err := InsertCommands(ctx, db, []Command{cmd})
+	return inserted > 0, err
+}
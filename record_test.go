@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRecordCommand(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	dbPath := filepath.Join(dir, "test.db")
+	history := filepath.Join(dir, "zsh_history")
+
+	type row struct {
+		timestamp float64
+		command   string
+		cwd       string
+		exitCode  int
+		duration  int
+	}
+	rows := func() []row {
+		t.Helper()
+		db, err := InitDB(dbPath)
+		if err != nil {
+			t.Fatalf("InitDB() error = %v", err)
+		}
+		defer db.Close()
+		res, err := db.Query(`SELECT timestamp, command, COALESCE(cwd, ''), COALESCE(exit_code, 0), COALESCE(duration, 0) FROM commands ORDER BY timestamp`)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Close()
+		var got []row
+		for res.Next() {
+			var r row
+			if err := res.Scan(&r.timestamp, &r.command, &r.cwd, &r.exitCode, &r.duration); err != nil {
+				t.Fatal(err)
+			}
+			got = append(got, r)
+		}
+		return got
+	}
+	record := func(ts float64, command, cwd string, exitCode, duration int) {
+		t.Helper()
+		cmd := Command{Source: history, Timestamp: ts, Command: command, CWD: cwd, ExitCode: exitCode, Duration: duration}
+		if err := runRecord(ctx, dbPath, cmd, false, NewIgnoreRules(DefaultIgnore)); err != nil {
+			t.Fatalf("runRecord(%q) error = %v", command, err)
+		}
+	}
+	collect := func(content string) {
+		t.Helper()
+		if err := os.WriteFile(history, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := runCollect(ctx, dbPath, []string{history}, CollectOptions{Quiet: true, Ignore: NewIgnoreRules(DefaultIgnore)}); err != nil {
+			t.Fatalf("runCollect() error = %v", err)
+		}
+	}
+
+	// Recorded before the history file is collected, twice in one second
+	record(1700000000, "make build", "/src/app", 2, 3)
+	record(1700000000, "make test", "/src/app", 0, 0)
+	// Left out as collect leaves it out
+	record(1700000001, "zist search foo", "/src/app", 0, 0)
+	collect(": 1700000000:3;make build\n: 1700000000:0;make test\n")
+
+	want := []row{
+		{1700000000, "make build", "/src/app", 2, 3},
+		{1700000000.001, "make test", "/src/app", 0, 0},
+	}
+	if got := rows(); len(got) != len(want) || got[0] != want[0] || got[1] != want[1] {
+		t.Fatalf("after record then collect: %+v, want %+v", got, want)
+	}
+
+	// Collected before it's recorded
+	collect(": 1700000000:3;make build\n: 1700000000:0;make test\n: 1700000010:0;git push\n")
+	record(1700000010, "git push", "/src/app", 1, 4)
+	got := rows()
+	if len(got) != 3 || got[2] != (row{1700000010, "git push", "/src/app", 1, 4}) {
+		t.Errorf("after collect then record: %+v, want git push with its details", got)
+	}
+}
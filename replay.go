@@ -0,0 +1,78 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Values of replay --format
+const (
+	ReplayScript = "script"
+)
+
+// ReplayOptions controls how sessions are written out for replay
+type ReplayOptions struct {
+	Speed    float64       // Pauses are divided by this, 1 keeps them as they were
+	MaxSleep time.Duration // Longest pause between two commands, 0 for no limit
+}
+
+// replaySleep is the pause before a command that started gap seconds
+// after the previous one ended, scaled and capped by opts, or "" for none
+// worth waiting
+func replaySleep(gap float64, opts ReplayOptions) string {
+	if opts.Speed > 0 {
+		gap /= opts.Speed
+	}
+	if opts.MaxSleep > 0 {
+		gap = min(gap, opts.MaxSleep.Seconds())
+	}
+	if gap < 0.1 {
+		return ""
+	}
+	return strconv.FormatFloat(float64(int(gap*10+0.5))/10, 'f', -1, 64)
+}
+
+// writeReplayScript writes sessions as a zsh script that runs their
+// commands again, pausing as long as there was between them. Each command
+// is preceded by a comment with when it ran and how it went, and by a cd
+// where the history recorded a directory the script wouldn't be in.
+func writeReplayScript(w io.Writer, sessions []TimelineSession, opts ReplayOptions) {
+	fmt.Fprintln(w, "#!/usr/bin/env zsh")
+	fmt.Fprintf(w, "# Replay of %d session(s), %d command(s), written by zist replay\n", len(sessions), timelineCommands(sessions))
+	fmt.Fprintln(w, "# Every command runs again as it was typed: read this through before running it")
+
+	home, _ := os.UserHomeDir()
+	for _, s := range sessions {
+		fmt.Fprintf(w, "\n# ── %s on %s\n", timelineSpan(s), s.Source)
+		var dir, prevDir string
+		var ended float64
+		for i, e := range s.Entries {
+			if i > 0 {
+				if sleep := replaySleep(e.Timestamp-ended, opts); sleep != "" {
+					fmt.Fprintf(w, "sleep %s\n", sleep)
+				}
+			}
+			ended = e.Timestamp + float64(e.Duration)
+
+			comment := FormatTimestamp(e.Timestamp)
+			if outcome := timelineOutcome(e); outcome != "" {
+				comment += "  [" + outcome + "]"
+			}
+			fmt.Fprintf(w, "# %s\n", comment)
+			if e.Note != "" {
+				fmt.Fprintf(w, "# note: %s\n", strings.ReplaceAll(e.Note, "\n", "\n#       "))
+			}
+
+			if e.CWD != "" && e.CWD != dir {
+				fmt.Fprintf(w, "cd %s\n", shellQuote(e.CWD))
+				dir, prevDir = e.CWD, dir
+			}
+			fmt.Fprintln(w, e.FullCommand())
+			dir, prevDir = followCD(e.Command, dir, prevDir, home)
+		}
+	}
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestReplaySleep(t *testing.T) {
+	tests := []struct {
+		gap  float64
+		opts ReplayOptions
+		want string
+	}{
+		{4, ReplayOptions{}, "4"},
+		{2.46, ReplayOptions{}, "2.5"},
+		{0.04, ReplayOptions{}, ""},
+		{-3, ReplayOptions{}, ""},
+		{10, ReplayOptions{Speed: 4}, "2.5"},
+		{600, ReplayOptions{MaxSleep: 5 * time.Second}, "5"},
+		{600, ReplayOptions{Speed: 2, MaxSleep: time.Minute}, "60"},
+	}
+	for _, tt := range tests {
+		if got := replaySleep(tt.gap, tt.opts); got != tt.want {
+			t.Errorf("replaySleep(%v, %+v) = %q, want %q", tt.gap, tt.opts, got, tt.want)
+		}
+	}
+}
+
+func TestWriteReplayScript(t *testing.T) {
+	ctx := context.Background()
+	t.Setenv("HOME", "/home/me")
+	db, err := InitDB(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("InitDB() error = %v", err)
+	}
+	defer db.Close()
+
+	start := float64(time.Date(2026, 10, 14, 9, 12, 0, 0, time.Local).Unix())
+	commands := []Command{
+		{Source: "/laptop", Timestamp: start, Command: "ls", Seq: 1},
+		{Source: "/laptop", Timestamp: start + 5, Command: "cd ~/src/api", Seq: 2},
+		{Source: "/laptop", Timestamp: start + 10, Command: "go test ./...", Duration: 8, ExitCode: 1, Seq: 3},
+		{Source: "/laptop", Timestamp: start + 20, Command: "cat <<EOF\nhi\nEOF", Seq: 4},
+		{Source: "/server", Timestamp: start + 3600, Command: "git pull", CWD: "/srv/app", Seq: 1},
+		{Source: "/server", Timestamp: start + 3660, Command: "make", CWD: "/srv/app", Seq: 2},
+		{Source: "/server", Timestamp: start + 3670, Command: "ls", CWD: "/tmp", Seq: 3},
+	}
+	if _, _, err := InsertCommands(ctx, db, commands); err != nil {
+		t.Fatal(err)
+	}
+	if err := SetNote(ctx, db, "/laptop", start+10, "flaky\nagain"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Without a directory, commands before the first cd are kept
+	sessions, err := GetTimeline(ctx, db, TimelineOptions{})
+	if err != nil {
+		t.Fatalf("GetTimeline() error = %v", err)
+	}
+	if len(sessions) != 2 || len(sessions[0].Entries) != 4 || len(sessions[1].Entries) != 3 {
+		t.Fatalf("GetTimeline() = %+v, want sessions of 4 and 3 commands", sessions)
+	}
+
+	var buf bytes.Buffer
+	writeReplayScript(&buf, sessions, ReplayOptions{Speed: 1, MaxSleep: 30 * time.Second})
+	want := `#!/usr/bin/env zsh
+# Replay of 2 session(s), 7 command(s), written by zist replay
+# Every command runs again as it was typed: read this through before running it
+
+# ── 2026-10-14 09:12-09:12 on /laptop
+# 2026-10-14 09:12:00
+ls
+sleep 5
+# 2026-10-14 09:12:05
+cd ~/src/api
+sleep 5
+# 2026-10-14 09:12:10  [exit 1, 8s]
+# note: flaky
+#       again
+go test ./...
+sleep 2
+# 2026-10-14 09:12:20
+cat <<EOF
+hi
+EOF
+
+# ── 2026-10-14 10:12-10:13 on /server
+# 2026-10-14 10:12:00
+cd '/srv/app'
+git pull
+sleep 30
+# 2026-10-14 10:13:00
+make
+sleep 10
+# 2026-10-14 10:13:10
+cd '/tmp'
+ls
+`
+	if got := buf.String(); got != want {
+		t.Errorf("writeReplayScript() =\n%s\nwant\n%s", got, want)
+	}
+}
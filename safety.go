@@ -0,0 +1,339 @@
+package main
+
+import (
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// What a command is estimated to do, from SafetyReport.EstimatedEffect
+const (
+	EffectReadOnly    = "read-only"   // Only reads and prints
+	EffectModifies    = "modifies"    // Changes files or state, or may: anything not known to be read-only
+	EffectDestructive = "destructive" // Deletes or overwrites what can't easily be brought back
+)
+
+// SafetyReport is what AnalyzeCommand makes of a command before it reaches
+// the prompt
+type SafetyReport struct {
+	RequiresConfirmation bool     `json:"requires_confirmation"`
+	DangerReasons        []string `json:"danger_reasons"`
+	EstimatedEffect      string   `json:"estimated_effect"`
+	NeedsRoot            bool     `json:"needs_root"` // Likely needs sudo, per NeedsRoot, and --sudo doesn't say to keep quiet
+}
+
+// readOnlyPrograms only read and print, whatever their arguments, short of
+// the few flags readsOnly knows about
+var readOnlyPrograms = map[string]bool{
+	"ls": true, "ll": true, "cat": true, "less": true, "more": true, "head": true, "tail": true,
+	"grep": true, "egrep": true, "rg": true, "ag": true, "find": true, "fd": true, "tree": true,
+	"wc": true, "sort": true, "uniq": true, "cut": true, "tr": true, "awk": true, "sed": true,
+	"jq": true, "yq": true, "echo": true, "printf": true, "pwd": true, "whoami": true, "id": true,
+	"which": true, "type": true, "file": true, "stat": true, "du": true, "df": true, "free": true,
+	"uptime": true, "ps": true, "top": true, "htop": true, "date": true, "printenv": true,
+	"diff": true, "cmp": true, "md5sum": true, "sha1sum": true, "sha256sum": true, "lsof": true,
+	"netstat": true, "ss": true, "dig": true, "nslookup": true, "host": true, "ping": true,
+	"curl": true, "journalctl": true, "man": true, "history": true, "uname": true, "hostname": true,
+}
+
+// readOnlySubcommands are the subcommands of tools like git that only read
+var readOnlySubcommands = map[string][]string{
+	"git":       {"status", "log", "diff", "show", "blame", "grep", "ls-files", "rev-parse", "describe", "shortlog", "reflog"},
+	"kubectl":   {"get", "describe", "logs", "top", "explain", "version", "api-resources"},
+	"docker":    {"ps", "images", "logs", "inspect", "stats", "version", "info"},
+	"systemctl": {"status", "list-units", "list-unit-files", "is-active", "is-enabled"},
+}
+
+// AnalyzeCommand estimates what command does and lists why it might deserve
+// a second look before running: deleting files, rewriting history, running
+// as root. It reads the command, never runs it, so the report is a hint
+// rather than a guarantee.
+func AnalyzeCommand(command string) SafetyReport {
+	report := SafetyReport{DangerReasons: []string{}, EstimatedEffect: EffectReadOnly}
+	danger := func(reason string, destructive bool) {
+		if !slices.Contains(report.DangerReasons, reason) {
+			report.DangerReasons = append(report.DangerReasons, reason)
+		}
+		if destructive {
+			report.EstimatedEffect = EffectDestructive
+		} else if report.EstimatedEffect == EffectReadOnly {
+			report.EstimatedEffect = EffectModifies
+		}
+	}
+
+	if strings.Contains(strings.ReplaceAll(command, " ", ""), ":(){:|:&};:") {
+		danger("starts processes until the machine locks up (a fork bomb)", true)
+	}
+	upper := strings.ToUpper(command)
+	for _, sql := range []string{"DROP TABLE", "DROP DATABASE", "TRUNCATE TABLE"} {
+		if strings.Contains(upper, sql) {
+			danger("drops or empties database tables", true)
+		}
+	}
+
+	segments := splitShellCommands(command)
+	for i, seg := range segments {
+		for _, target := range seg.redirects {
+			switch {
+			case target == "/dev/null" || strings.HasPrefix(target, "/dev/std") || strings.HasPrefix(target, "/dev/tty"):
+			case strings.HasPrefix(target, "/dev/"):
+				danger("writes straight to device "+target, true)
+			default:
+				if report.EstimatedEffect == EffectReadOnly {
+					report.EstimatedEffect = EffectModifies
+				}
+			}
+		}
+
+		program, args, root := unwrapCommand(seg.words)
+		if root {
+			danger("runs as root", false)
+		}
+		if program == "" {
+			continue
+		}
+		if seg.piped && (program == "sh" || program == "bash" || program == "zsh") && i > 0 {
+			if prev, _, _ := unwrapCommand(segments[i-1].words); prev == "curl" || prev == "wget" {
+				danger("runs a script straight from the internet", false)
+			}
+		}
+		if reason, destructive := dangerOf(program, args); reason != "" {
+			danger(reason, destructive)
+		} else if report.EstimatedEffect == EffectReadOnly && !readsOnly(program, args) {
+			report.EstimatedEffect = EffectModifies
+		}
+	}
+
+	report.RequiresConfirmation = len(report.DangerReasons) > 0
+	report.NeedsRoot = sudoRules.Hints(NeedsRoot(command))
+	return report
+}
+
+// dangerOf says why running program with args is risky, and whether what
+// it does is hard to undo, or "" if it isn't known to be
+func dangerOf(program string, args []string) (string, bool) {
+	has := func(flags ...string) bool {
+		for _, arg := range args {
+			if slices.Contains(flags, arg) {
+				return true
+			}
+			// Bundled short flags such as -rf
+			if len(arg) > 1 && arg[0] == '-' && arg[1] != '-' {
+				for _, flag := range flags {
+					if len(flag) == 2 && flag[0] == '-' && flag[1] != '-' && strings.ContainsRune(arg[1:], rune(flag[1])) {
+						return true
+					}
+				}
+			}
+		}
+		return false
+	}
+	sub := ""
+	if len(args) > 0 {
+		sub = args[0]
+	}
+
+	switch {
+	case program == "rm" && has("-r", "-R", "--recursive"):
+		return "deletes files and directories recursively", true
+	case program == "rm":
+		return "deletes files", true
+	case program == "shred":
+		return "destroys file contents", true
+	case program == "dd" && slices.ContainsFunc(args, func(a string) bool { return strings.HasPrefix(a, "of=") }):
+		return "writes raw data over a file or disk", true
+	case strings.HasPrefix(program, "mkfs") || program == "fdisk" || program == "parted" || program == "wipefs":
+		return "formats or repartitions a disk", true
+	case program == "find" && (has("-delete") || slices.Contains(args, "rm")):
+		return "deletes the files it finds", true
+	case (program == "chmod" || program == "chown" || program == "chgrp") && has("-R", "--recursive"):
+		return "changes ownership or permissions recursively", false
+	case program == "sed" && has("-i", "--in-place"):
+		return "edits files in place", false
+	case program == "git" && sub == "push" && has("-f", "--force", "--force-with-lease"):
+		return "rewrites history on the remote", true
+	case program == "git" && sub == "reset" && has("--hard"):
+		return "discards uncommitted changes", true
+	case program == "git" && sub == "clean" && has("-f", "--force"):
+		return "deletes untracked files", true
+	case program == "git" && sub == "branch" && has("-D"):
+		return "deletes a branch, merged or not", true
+	case program == "kubectl" && sub == "delete", program == "helm" && (sub == "uninstall" || sub == "delete"):
+		return "deletes cluster resources", true
+	case program == "docker" && (sub == "rm" || sub == "rmi" || sub == "prune" || slices.Contains(args, "prune") || (sub == "volume" && slices.Contains(args, "rm"))):
+		return "deletes containers, images or volumes", true
+	case program == "terraform" && (sub == "destroy" || (sub == "apply" && has("-destroy"))):
+		return "destroys infrastructure", true
+	case program == "kill" && has("-9", "-KILL", "-SIGKILL"), program == "killall", program == "pkill":
+		return "kills processes", false
+	case program == "shutdown" || program == "reboot" || program == "poweroff" || program == "halt":
+		return "shuts down or restarts the machine", false
+	}
+	return "", false
+}
+
+// readsOnly reports whether program with args is known to only read
+func readsOnly(program string, args []string) bool {
+	if subs, ok := readOnlySubcommands[program]; ok {
+		return len(args) > 0 && slices.Contains(subs, args[0])
+	}
+	if !readOnlyPrograms[program] {
+		return false
+	}
+	// The few read-only programs that write with the right flags
+	for _, arg := range args {
+		switch {
+		case program == "curl" && (arg == "-o" || arg == "-O" || arg == "-X" || arg == "-T" || strings.HasPrefix(arg, "-d") || strings.HasPrefix(arg, "--data") || strings.HasPrefix(arg, "--upload")):
+			return false
+		case program == "find" && (arg == "-exec" || arg == "-execdir" || arg == "-fprint"):
+			return false
+		case (program == "sort" || program == "tree") && arg == "-o":
+			return false
+		}
+	}
+	return true
+}
+
+// unwrapCommand finds the program a simple command runs past any VAR=value
+// assignments and wrappers such as sudo, env or xargs, and reports whether
+// it runs as root
+func unwrapCommand(words []string) (string, []string, bool) {
+	root := false
+	for len(words) > 0 {
+		word := words[0]
+		if envAssignmentLen(word) > 0 {
+			words = words[1:]
+			continue
+		}
+		switch filepath.Base(word) {
+		case "sudo", "doas":
+			root = true
+			words = skipFlags(words[1:], "-u", "-g", "-C", "-D", "-h", "-p", "-U")
+		case "env", "xargs":
+			words = skipFlags(words[1:], "-u", "-C", "-S", "-I", "-n", "-P", "-d", "-L", "-s", "-E")
+		case "nice":
+			words = skipFlags(words[1:], "-n")
+		case "nohup", "time", "exec", "command", "builtin":
+			words = words[1:]
+		default:
+			return filepath.Base(word), words[1:], root
+		}
+	}
+	return "", nil, root
+}
+
+// skipFlags drops the leading flags of a wrapper, with the argument of each
+// flag in withArg
+func skipFlags(words []string, withArg ...string) []string {
+	for len(words) > 0 && strings.HasPrefix(words[0], "-") {
+		if slices.Contains(withArg, words[0]) && len(words) > 1 {
+			words = words[1:]
+		}
+		words = words[1:]
+	}
+	return words
+}
+
+// shellSegment is one simple command of a command line
+type shellSegment struct {
+	words     []string
+	redirects []string // Files output goes to with > or >>
+	piped     bool     // Reads the output of the segment before
+}
+
+// splitShellCommands splits a command line into its simple commands at
+// pipes, ;, &&, ||, & and newlines, and into unquoted words. Subshells and
+// command substitutions count as commands of their own. It is a reading
+// good enough for AnalyzeCommand, not a shell parser.
+func splitShellCommands(command string) []shellSegment {
+	var segments []shellSegment
+	var seg shellSegment
+	var word strings.Builder
+	inWord, toRedirect, fromFile := false, false, false
+	var quote rune
+
+	endWord := func() {
+		if !inWord {
+			return
+		}
+		if toRedirect {
+			seg.redirects = append(seg.redirects, word.String())
+			toRedirect = false
+		} else if fromFile {
+			fromFile = false
+		} else {
+			seg.words = append(seg.words, word.String())
+		}
+		word.Reset()
+		inWord = false
+	}
+	endSegment := func(piped bool) {
+		endWord()
+		if len(seg.words) > 0 || len(seg.redirects) > 0 {
+			segments = append(segments, seg)
+		}
+		seg = shellSegment{piped: piped}
+	}
+
+	runes := []rune(command)
+	for i := 0; i < len(runes); i++ {
+		r := runes[i]
+		switch {
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			} else if r == '\\' && quote == '"' && i+1 < len(runes) {
+				i++
+				word.WriteRune(runes[i])
+			} else {
+				word.WriteRune(r)
+			}
+		case r == '\'' || r == '"':
+			quote, inWord = r, true
+		case r == '\\' && i+1 < len(runes):
+			i++
+			if runes[i] != '\n' {
+				word.WriteRune(runes[i])
+				inWord = true
+			}
+		case r == ' ' || r == '\t':
+			endWord()
+		case r == '|':
+			piped := i+1 >= len(runes) || runes[i+1] != '|'
+			if !piped {
+				i++
+			}
+			endSegment(piped)
+		case r == ';' || r == '&' || r == '\n' || r == '(' || r == ')' || r == '`':
+			endSegment(false)
+		case r == '$' && i+1 < len(runes) && runes[i+1] == '(':
+			i++
+			endSegment(false)
+		case r == '>':
+			// 2> and the like name the descriptor before the file
+			if inWord && strings.Trim(word.String(), "0123456789") == "" {
+				word.Reset()
+				inWord = false
+			}
+			endWord()
+			if i+1 < len(runes) && runes[i+1] == '>' {
+				i++
+			}
+			if i+1 < len(runes) && runes[i+1] == '&' {
+				// >&2 duplicates a descriptor rather than naming a file
+				for i++; i+1 < len(runes) && (runes[i+1] == '-' || runes[i+1] >= '0' && runes[i+1] <= '9'); i++ {
+				}
+				continue
+			}
+			toRedirect = true
+		case r == '<':
+			endWord()
+			fromFile = true
+		default:
+			word.WriteRune(r)
+			inWord = true
+		}
+	}
+	endSegment(false)
+	return segments
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestAnalyzeCommand(t *testing.T) {
+	tests := []struct {
+		command string
+		effect  string
+		reasons []string
+	}{
+		{"ls -la", EffectReadOnly, nil},
+		{"git log --oneline | head -5", EffectReadOnly, nil},
+		{"grep -r TODO . 2>/dev/null >&2", EffectReadOnly, nil},
+		{"ps aux > procs.txt", EffectModifies, nil},
+		{"make build", EffectModifies, nil},
+		{"rm -rf build/", EffectDestructive, []string{"deletes files and directories recursively"}},
+		{"sudo rm /etc/hosts", EffectDestructive, []string{"runs as root", "deletes files"}},
+		{"echo 'rm -rf /'", EffectReadOnly, nil},
+		{`find . -name '*.tmp' -exec rm {} \;`, EffectDestructive, []string{"deletes the files it finds"}},
+		{"DEBUG=1 git push -f origin main", EffectDestructive, []string{"rewrites history on the remote"}},
+		{"git status && git reset --hard HEAD~1", EffectDestructive, []string{"discards uncommitted changes"}},
+		{"curl -fsSL https://example.com/install.sh | sh", EffectModifies, []string{"runs a script straight from the internet"}},
+		{"sudo -u postgres psql", EffectModifies, []string{"runs as root"}},
+		{"dd if=disk.img of=/dev/sdb bs=4M", EffectDestructive, []string{"writes raw data over a file or disk"}},
+		{"cat img > /dev/sda", EffectDestructive, []string{"writes straight to device /dev/sda"}},
+		{"kubectl get pods; kubectl delete pod web-1", EffectDestructive, []string{"deletes cluster resources"}},
+		{"sed -i 's/a/b/' f.txt", EffectModifies, []string{"edits files in place"}},
+		{"psql -c 'drop table users'", EffectDestructive, []string{"drops or empties database tables"}},
+	}
+	for _, tt := range tests {
+		got := AnalyzeCommand(tt.command)
+		want := tt.reasons
+		if want == nil {
+			want = []string{}
+		}
+		if got.EstimatedEffect != tt.effect || !reflect.DeepEqual(got.DangerReasons, want) || got.RequiresConfirmation != (len(want) > 0) {
+			t.Errorf("AnalyzeCommand(%q) = %+v, want %s with %q", tt.command, got, tt.effect, want)
+		}
+	}
+}
+
+func TestSplitShellCommands(t *testing.T) {
+	got := splitShellCommands(`FOO="a b" cmd 'x | y' | wc -l >> out.txt 2>&1 && echo $(date) < in`)
+	want := []shellSegment{
+		{words: []string{"FOO=a b", "cmd", "x | y"}},
+		{words: []string{"wc", "-l"}, redirects: []string{"out.txt"}, piped: true},
+		{words: []string{"echo"}},
+		{words: []string{"date"}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("splitShellCommands() = %+v, want %+v", got, want)
+	}
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// SavedSearch is a named query plus filters, stored as typed so relative
+// dates like -30d are re-evaluated each time it is used
+type SavedSearch struct {
+	Name           string
+	Query          string
+	Source         string
+	Since          string
+	Until          string
+	Limit          int
+	IncludeArchive bool
+	CreatedAt      float64
+	LastUsed       float64
+}
+
+// SaveSearch stores or replaces a named search
+func SaveSearch(ctx context.Context, db *sql.DB, s SavedSearch) error {
+	now := float64(time.Now().Unix())
+
+	_, err := db.ExecContext(ctx, `INSERT INTO saved_searches (name, query, source, since, until, result_limit, include_archive, created_at, last_used)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT(name) DO UPDATE SET
+			query = excluded.query,
+			source = excluded.source,
+			since = excluded.since,
+			until = excluded.until,
+			result_limit = excluded.result_limit,
+			include_archive = excluded.include_archive,
+			last_used = excluded.last_used`,
+		s.Name, s.Query, s.Source, s.Since, s.Until, s.Limit, s.IncludeArchive, now, now)
+	if err != nil {
+		return fmt.Errorf("failed to save search: %w", err)
+	}
+
+	return nil
+}
+
+// GetSavedSearch looks up a saved search by name and marks it as used
+func GetSavedSearch(ctx context.Context, db *sql.DB, name string) (*SavedSearch, error) {
+	row := db.QueryRowContext(ctx, `SELECT name, query, source, since, until, result_limit, include_archive, created_at, last_used
+		FROM saved_searches WHERE name = ?`, name)
+
+	var s SavedSearch
+	err := row.Scan(&s.Name, &s.Query, &s.Source, &s.Since, &s.Until, &s.Limit, &s.IncludeArchive, &s.CreatedAt, &s.LastUsed)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get saved search: %w", err)
+	}
+
+	if _, err := db.ExecContext(ctx, `UPDATE saved_searches SET last_used = ? WHERE name = ?`, float64(time.Now().Unix()), name); err != nil {
+		return nil, fmt.Errorf("failed to update saved search: %w", err)
+	}
+
+	return &s, nil
+}
+
+// ListSavedSearches returns all saved searches, most recently used first
+func ListSavedSearches(ctx context.Context, db *sql.DB) ([]SavedSearch, error) {
+	rows, err := db.QueryContext(ctx, `SELECT name, query, source, since, until, result_limit, include_archive, created_at, last_used
+		FROM saved_searches ORDER BY last_used DESC`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list saved searches: %w", err)
+	}
+	defer rows.Close()
+
+	var searches []SavedSearch
+	for rows.Next() {
+		var s SavedSearch
+		if err := rows.Scan(&s.Name, &s.Query, &s.Source, &s.Since, &s.Until, &s.Limit, &s.IncludeArchive, &s.CreatedAt, &s.LastUsed); err != nil {
+			return nil, fmt.Errorf("failed to scan saved search: %w", err)
+		}
+		searches = append(searches, s)
+	}
+
+	return searches, rows.Err()
+}
+
+// DeleteSavedSearch removes a saved search, reporting whether it existed
+func DeleteSavedSearch(ctx context.Context, db *sql.DB, name string) (bool, error) {
+	result, err := db.ExecContext(ctx, `DELETE FROM saved_searches WHERE name = ?`, name)
+	if err != nil {
+		return false, fmt.Errorf("failed to delete saved search: %w", err)
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to get rows affected: %w", err)
+	}
+	return n > 0, nil
+}
+
+// Describe summarises the search's query and filters on one line
+func (s SavedSearch) Describe() string {
+	desc := ""
+	add := func(label, value string) {
+		if value == "" {
+			return
+		}
+		if desc != "" {
+			desc += " "
+		}
+		desc += label + value
+	}
+	add("--source ", s.Source)
+	add("--since ", s.Since)
+	add("--until ", s.Until)
+	if s.IncludeArchive {
+		add("", "--include-archive")
+	}
+	add("", s.Query)
+	if desc == "" {
+		return "(all commands)"
+	}
+	return desc
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+)
+
+func TestSavedSearchRoundTrip(t *testing.T) {
+	db, err := InitDB(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("InitDB() error = %v", err)
+	}
+	defer db.Close()
+
+	want := SavedSearch{Name: "docker", Query: "docker", Source: "laptop", Since: "-30d", Limit: 100, IncludeArchive: true}
+	if err := SaveSearch(context.Background(), db, want); err != nil {
+		t.Fatalf("SaveSearch() error = %v", err)
+	}
+
+	got, err := GetSavedSearch(context.Background(), db, "docker")
+	if err != nil {
+		t.Fatalf("GetSavedSearch() error = %v", err)
+	}
+	if got == nil || got.Query != want.Query || got.Source != want.Source || got.Since != want.Since ||
+		got.Limit != want.Limit || got.IncludeArchive != want.IncludeArchive {
+		t.Fatalf("GetSavedSearch() = %+v, want %+v", got, want)
+	}
+
+	// Saving under the same name replaces the filters
+	want.Source = "desktop"
+	if err := SaveSearch(context.Background(), db, want); err != nil {
+		t.Fatalf("SaveSearch() second call error = %v", err)
+	}
+	searches, err := ListSavedSearches(context.Background(), db)
+	if err != nil {
+		t.Fatalf("ListSavedSearches() error = %v", err)
+	}
+	if len(searches) != 1 || searches[0].Source != "desktop" {
+		t.Errorf("ListSavedSearches() = %+v, want one search with source desktop", searches)
+	}
+
+	deleted, err := DeleteSavedSearch(context.Background(), db, "docker")
+	if err != nil || !deleted {
+		t.Fatalf("DeleteSavedSearch() = %v, %v, want true", deleted, err)
+	}
+	got, err = GetSavedSearch(context.Background(), db, "docker")
+	if err != nil || got != nil {
+		t.Errorf("GetSavedSearch() after delete = %+v, %v, want nil", got, err)
+	}
+}
+
+func TestSearchRequestApplySaved(t *testing.T) {
+	saved := &SavedSearch{Query: "docker", Source: "laptop", Since: "-30d", Limit: 50, IncludeArchive: true}
+
+	req := SearchRequest{Limit: 500, Source: "desktop"}
+	isSet := func(name string) bool { return name == "source" }
+	req.applySaved(saved, isSet, "/tmp/archive.db")
+
+	if req.Query != "docker" || req.Since != "-30d" || req.Limit != 50 {
+		t.Errorf("applySaved() = %+v, want saved query, since and limit", req)
+	}
+	if req.Source != "desktop" {
+		t.Errorf("applySaved() Source = %q, want explicit flag to win", req.Source)
+	}
+	if req.ArchivePath != "/tmp/archive.db" {
+		t.Errorf("applySaved() ArchivePath = %q, want archive included", req.ArchivePath)
+	}
+}
+
+func TestParseRelativeDuration(t *testing.T) {
+	tests := []struct {
+		input  string
+		wantOK bool
+		hours  int
+	}{
+		{"-12h", true, 12},
+		{"-30d", true, 30 * 24},
+		{"-2w", true, 2 * 7 * 24},
+		{"-3m", false, 0},
+		{"30d", false, 0},
+		{"-d", false, 0},
+	}
+
+	for _, tt := range tests {
+		got, ok := parseRelativeDuration(tt.input)
+		if ok != tt.wantOK || (ok && int(got.Hours()) != tt.hours) {
+			t.Errorf("parseRelativeDuration(%q) = %v, %v, want %dh, %v", tt.input, got, ok, tt.hours, tt.wantOK)
+		}
+	}
+}
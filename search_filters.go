@@ -0,0 +1,241 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/tchaudhry91/zist/llm"
+)
+
+// SearchFilter is one drill-down refinement applied inside the picker
+type SearchFilter struct {
+	Kind  string `json:"kind"` // "source", "text", "day" or "range"
+	Value string `json:"value"`
+}
+
+// PickerState is shared between a running search picker and the
+// `zist refine` calls its key bindings make, via a temporary JSON file
+type PickerState struct {
+	DBPath      string         `json:"db"`
+	ArchivePath string         `json:"archive,omitempty"`
+	Base        SearchOptions  `json:"base"`
+	Filters     []SearchFilter `json:"filters"`
+	Color       bool           `json:"color,omitempty"`   // Mark each row with the color of its source
+	Embed       *llm.Config    `json:"embed,omitempty"`   // Embedding model and server for a semantic search
+	Timeout     time.Duration  `json:"timeout,omitempty"` // Give up on each search after this, 0 for never
+}
+
+// ParseSearchFilter parses a KIND=VALUE refinement
+func ParseSearchFilter(s string) (SearchFilter, error) {
+	kind, value, ok := strings.Cut(s, "=")
+	value = strings.TrimSpace(value)
+	if !ok || value == "" {
+		return SearchFilter{}, fmt.Errorf("invalid filter %q (use KIND=VALUE)", s)
+	}
+
+	switch kind {
+	case "source":
+		// Sources come from the picker record, where they are escaped
+		value = decodePickerField(value)
+	case "text":
+	case "day":
+		if len(value) < len("2006-01-02") {
+			return SearchFilter{}, fmt.Errorf("invalid day %q", value)
+		}
+		value = value[:len("2006-01-02")]
+		if _, err := time.ParseInLocation("2006-01-02", value, time.Local); err != nil {
+			return SearchFilter{}, fmt.Errorf("invalid day %q: %w", value, err)
+		}
+	case "range":
+		if _, _, err := ResolveTimeRange(value, time.Now()); err != nil {
+			return SearchFilter{}, err
+		}
+	default:
+		return SearchFilter{}, fmt.Errorf("unknown filter kind %q", kind)
+	}
+
+	return SearchFilter{Kind: kind, Value: value}, nil
+}
+
+// Options combines the base search with every active filter. Text filters
+// narrow the query further and time filters intersect.
+func (s *PickerState) Options() SearchOptions {
+	opts := s.Base
+	for _, f := range s.Filters {
+		switch f.Kind {
+		case "source":
+			opts.Source = f.Value
+		case "text":
+			opts.Query = strings.TrimSpace(opts.Query + " " + f.Value)
+		case "day":
+			day, err := time.ParseInLocation("2006-01-02", f.Value, time.Local)
+			if err != nil {
+				continue
+			}
+			since := float64(day.Unix())
+			until := float64(day.AddDate(0, 0, 1).Unix()) - 0.001
+			opts.Since, opts.Until = intersectRange(opts.Since, opts.Until, since, until)
+		case "range":
+			since, until, err := ResolveTimeRange(f.Value, time.Now())
+			if err != nil {
+				continue
+			}
+			opts.Since, opts.Until = intersectRange(opts.Since, opts.Until, since, until)
+		}
+	}
+	return opts
+}
+
+// ResolveTimeRange turns a named range (today, yesterday, week, month) or a
+// custom FROM..TO range into Unix timestamps, where 0 means unbounded.
+// Either side of a custom range may be omitted and a date-only TO includes
+// the whole day.
+func ResolveTimeRange(value string, now time.Time) (float64, float64, error) {
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+
+	switch value {
+	case "today":
+		return float64(today.Unix()), 0, nil
+	case "yesterday":
+		return float64(today.AddDate(0, 0, -1).Unix()), float64(today.Unix()) - 0.001, nil
+	case "week":
+		offset := (int(today.Weekday()) + 6) % 7 // Weeks start on Monday
+		return float64(today.AddDate(0, 0, -offset).Unix()), 0, nil
+	case "month":
+		return float64(today.AddDate(0, 0, 1-today.Day()).Unix()), 0, nil
+	}
+
+	from, to, ok := strings.Cut(value, "..")
+	if !ok {
+		return 0, 0, fmt.Errorf("invalid time range %q (use today, yesterday, week, month or FROM..TO)", value)
+	}
+
+	since, err := parseDateTime(strings.TrimSpace(from))
+	if err != nil {
+		return 0, 0, err
+	}
+
+	to = strings.TrimSpace(to)
+	until, err := parseDateTime(to)
+	if err != nil {
+		return 0, 0, err
+	}
+	if until != 0 && len(to) == len("2006-01-02") {
+		until += 24*60*60 - 0.001
+	}
+
+	if since == 0 && until == 0 {
+		return 0, 0, fmt.Errorf("invalid time range %q: FROM or TO is required", value)
+	}
+	return since, until, nil
+}
+
+// withFilter appends f, replacing any earlier time range so picking a new
+// preset switches ranges instead of intersecting them
+func (s *PickerState) withFilter(f SearchFilter) {
+	if f.Kind == "range" {
+		kept := s.Filters[:0]
+		for _, existing := range s.Filters {
+			if existing.Kind != "range" {
+				kept = append(kept, existing)
+			}
+		}
+		s.Filters = kept
+	}
+	s.Filters = append(s.Filters, f)
+}
+
+// intersectRange narrows [since, until] by [since2, until2], where 0 means
+// unbounded
+func intersectRange(since, until, since2, until2 float64) (float64, float64) {
+	if since2 > since {
+		since = since2
+	}
+	if until == 0 || (until2 != 0 && until2 < until) {
+		until = until2
+	}
+	return since, until
+}
+
+// Breadcrumb renders the active filters for the picker header
+func (s *PickerState) Breadcrumb() string {
+	if len(s.Filters) == 0 {
+		return "no filters"
+	}
+	parts := make([]string, len(s.Filters))
+	for i, f := range s.Filters {
+		parts[i] = f.Kind + ":" + f.Value
+	}
+	return strings.Join(parts, symbol(" › ", " > "))
+}
+
+// LoadPickerState reads picker state from path
+func LoadPickerState(path string) (*PickerState, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read picker state: %w", err)
+	}
+	var state PickerState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return nil, fmt.Errorf("failed to parse picker state: %w", err)
+	}
+	return &state, nil
+}
+
+// Save writes picker state to path
+func (s *PickerState) Save(path string) error {
+	data, err := json.Marshal(s)
+	if err != nil {
+		return fmt.Errorf("failed to encode picker state: %w", err)
+	}
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		return fmt.Errorf("failed to write picker state: %w", err)
+	}
+	return nil
+}
+
+// pickerKeys documents the drill-down bindings shown in the picker header
+var pickerKeys = []string{"ctrl-s source", "ctrl-t day", "ctrl-f text", "alt-1..4 today/yesterday/week/month", "alt-r FROM..TO", "alt-bs undo", "alt-c clear"}
+
+// pickerHeader is the header line for the given state
+func pickerHeader(state *PickerState) string {
+	return "filters: " + state.Breadcrumb() + "  (" + strings.Join(pickerKeys, symbol(" · ", ", ")) + ")"
+}
+
+// pickerBindings returns fzf flags wiring drill-down keys to `zist refine`.
+// Each binding updates the state file and prints the fzf actions to reload
+// results and refresh the breadcrumb.
+func pickerBindings(self, stateFile string) []string {
+	refine := func(args string) string {
+		return fmt.Sprintf("transform(%s refine --state %s %s)", shellQuote(self), shellQuote(stateFile), args)
+	}
+	return []string{
+		"--bind", "ctrl-s:" + refine("--add source={1}"),
+		"--bind", "ctrl-t:" + refine("--add day={2}"),
+		"--bind", "ctrl-f:" + refine("--add text={q}"),
+		"--bind", "alt-1:" + refine("--add range=today"),
+		"--bind", "alt-2:" + refine("--add range=yesterday"),
+		"--bind", "alt-3:" + refine("--add range=week"),
+		"--bind", "alt-4:" + refine("--add range=month"),
+		"--bind", "alt-r:" + refine("--add range={q}"),
+		"--bind", "alt-bspace:" + refine("--pop"),
+		"--bind", "alt-c:" + refine("--clear"),
+	}
+}
+
+// refineActions is what `zist refine` prints for fzf's transform action
+func refineActions(self, stateFile string, state *PickerState, clearQuery bool) string {
+	actions := fmt.Sprintf("reload(%s refine --state %s --emit)", shellQuote(self), shellQuote(stateFile))
+	if clearQuery {
+		actions += "+clear-query"
+	}
+	return actions + "+change-header:" + pickerHeader(state)
+}
+
+// shellQuote quotes s for safe use as a single POSIX shell word
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
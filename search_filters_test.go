@@ -0,0 +1,164 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseSearchFilter(t *testing.T) {
+	tests := []struct {
+		input   string
+		want    SearchFilter
+		wantErr bool
+	}{
+		{"source=/home/me/.zsh_history", SearchFilter{"source", "/home/me/.zsh_history"}, false},
+		{"text=docker", SearchFilter{"text", "docker"}, false},
+		{"day=2024-01-05 16:00:00", SearchFilter{"day", "2024-01-05"}, false},
+		{"text=", SearchFilter{}, true},
+		{"day=yesterday", SearchFilter{}, true},
+		{"color=red", SearchFilter{}, true},
+		{"docker", SearchFilter{}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			got, err := ParseSearchFilter(tt.input)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseSearchFilter(%q) error = %v, wantErr %v", tt.input, err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("ParseSearchFilter(%q) = %+v, want %+v", tt.input, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPickerStateOptions(t *testing.T) {
+	day := time.Date(2024, 1, 5, 0, 0, 0, 0, time.Local)
+	state := &PickerState{
+		Base: SearchOptions{Query: "git", Limit: 100},
+		Filters: []SearchFilter{
+			{"source", "laptop"},
+			{"text", "push"},
+			{"day", "2024-01-05"},
+		},
+	}
+
+	opts := state.Options()
+	if opts.Query != "git push" {
+		t.Errorf("Options().Query = %q, want 'git push'", opts.Query)
+	}
+	if opts.Source != "laptop" {
+		t.Errorf("Options().Source = %q, want 'laptop'", opts.Source)
+	}
+	if opts.Since != float64(day.Unix()) {
+		t.Errorf("Options().Since = %v, want %v", opts.Since, float64(day.Unix()))
+	}
+	if opts.Until <= opts.Since || opts.Until >= float64(day.AddDate(0, 0, 1).Unix()) {
+		t.Errorf("Options().Until = %v, want within the day", opts.Until)
+	}
+	if state.Breadcrumb() != "source:laptop › text:push › day:2024-01-05" {
+		t.Errorf("Breadcrumb() = %q", state.Breadcrumb())
+	}
+}
+
+func TestPickerStateSaveLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+	want := &PickerState{DBPath: "/tmp/zist.db", Base: SearchOptions{Limit: 10}, Filters: []SearchFilter{{"text", "ls"}}}
+
+	if err := want.Save(path); err != nil {
+		t.Fatalf("Save() error = %v", err)
+	}
+	got, err := LoadPickerState(path)
+	if err != nil {
+		t.Fatalf("LoadPickerState() error = %v", err)
+	}
+	if got.DBPath != want.DBPath || got.Base.Limit != 10 || len(got.Filters) != 1 || got.Filters[0] != want.Filters[0] {
+		t.Errorf("LoadPickerState() = %+v, want %+v", got, want)
+	}
+}
+
+func TestResolveTimeRange(t *testing.T) {
+	// Wednesday
+	now := time.Date(2024, 5, 15, 14, 30, 0, 0, time.Local)
+	day := func(y int, m time.Month, d int) float64 {
+		return float64(time.Date(y, m, d, 0, 0, 0, 0, time.Local).Unix())
+	}
+
+	tests := []struct {
+		value     string
+		wantSince float64
+		wantUntil float64
+		wantErr   bool
+	}{
+		{"today", day(2024, 5, 15), 0, false},
+		{"yesterday", day(2024, 5, 14), day(2024, 5, 15) - 0.001, false},
+		{"week", day(2024, 5, 13), 0, false},
+		{"month", day(2024, 5, 1), 0, false},
+		{"2024-01-01..2024-01-31", day(2024, 1, 1), day(2024, 2, 1) - 0.001, false},
+		{"2024-01-01..", day(2024, 1, 1), 0, false},
+		{"..2024-01-31 12:00:00", 0, day(2024, 1, 31) + 12*3600, false},
+		{"..", 0, 0, true},
+		{"lastweek", 0, 0, true},
+		{"2024-13-01..", 0, 0, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			since, until, err := ResolveTimeRange(tt.value, now)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ResolveTimeRange(%q) error = %v, wantErr %v", tt.value, err, tt.wantErr)
+			}
+			if since != tt.wantSince || until != tt.wantUntil {
+				t.Errorf("ResolveTimeRange(%q) = (%v, %v), want (%v, %v)", tt.value, since, until, tt.wantSince, tt.wantUntil)
+			}
+		})
+	}
+}
+
+func TestPickerStateRangeReplaces(t *testing.T) {
+	state := &PickerState{}
+	state.withFilter(SearchFilter{"range", "today"})
+	state.withFilter(SearchFilter{"source", "laptop"})
+	state.withFilter(SearchFilter{"range", "week"})
+
+	if state.Breadcrumb() != "source:laptop › range:week" {
+		t.Errorf("Breadcrumb() = %q, want 'source:laptop › range:week'", state.Breadcrumb())
+	}
+}
+
+// Multi-line commands and tabs survive collect, search and the picker record
+func TestPickerMultilineRoundTrip(t *testing.T) {
+	db, err := InitDB(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("InitDB() error = %v", err)
+	}
+	defer db.Close()
+
+	history := filepath.Join(t.TempDir(), "history")
+	content := ": 1704384000:0;cat <<'EOF' > run.sh\\\n#!/bin/sh\\\nprintf 'a\\tb\\n'\\\nEOF\n" +
+		": 1704384010:0;printf '%s\t%s\\n' x y\n"
+	if err := os.WriteFile(history, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, _, err := collectFile(context.Background(), db, history, nil, 100, CollectOptions{}); err != nil {
+		t.Fatalf("collectFile() error = %v", err)
+	}
+
+	want := []string{"printf '%s\t%s\\n' x y", "cat <<'EOF' > run.sh\n#!/bin/sh\nprintf 'a\\tb\\n'\nEOF"}
+	for i, query := range []string{"printf x", "run.sh"} {
+		results, err := SearchCommands(context.Background(), db, SearchOptions{Query: query, Limit: 1})
+		if err != nil || len(results) != 1 {
+			t.Fatalf("SearchCommands(%q) = %v, %v", query, results, err)
+		}
+		var records strings.Builder
+		writeSearchRecords(&records, results, true)
+		if _, _, got := pickedRecord(records.String()); got != want[i] {
+			t.Errorf("picked %q, want %q", got, want[i])
+		}
+	}
+}
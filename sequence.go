@@ -0,0 +1,214 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"database/sql"
+	"fmt"
+	"io"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Defaults of zist sequence
+const (
+	DefaultSequenceWithin = 5 * time.Minute
+	DefaultSequenceLimit  = 20
+)
+
+// SequenceOptions describes the commands a sequence search looks for
+type SequenceOptions struct {
+	Steps  []string      // Each step is a command prefix, or a regular expression with Regexp
+	Regexp bool          // Match steps as regular expressions anywhere in the command
+	Within time.Duration // Longest time from one step to the next
+	Since  float64       // Unix timestamp, 0 means no filter
+	Until  float64       // Unix timestamp, 0 means no filter
+	Source string        // Only sources containing this, empty means all
+}
+
+// SequenceStep is the command that matched one step of an occurrence
+type SequenceStep struct {
+	ID        int64   `json:"id"`
+	Command   string  `json:"command"`
+	Timestamp float64 `json:"timestamp"`
+	Time      string  `json:"time,omitempty"` // Timestamp in RFC 3339, set for --json
+}
+
+// SequenceMatch is one occurrence of a sequence, its steps run in order in
+// one history
+type SequenceMatch struct {
+	Source string         `json:"source"`
+	Steps  []SequenceStep `json:"steps"`
+}
+
+// Span is the time from the first step to the last
+func (m SequenceMatch) Span() time.Duration {
+	return time.Duration((m.Steps[len(m.Steps)-1].Timestamp - m.Steps[0].Timestamp) * float64(time.Second))
+}
+
+// sequenceMatcher reports whether a command matches each step
+type sequenceMatcher []func(command string) bool
+
+// newSequenceMatcher compiles opts.Steps
+func newSequenceMatcher(opts SequenceOptions) (sequenceMatcher, error) {
+	if len(opts.Steps) < 2 {
+		return nil, fmt.Errorf("a sequence needs at least 2 steps, got %d", len(opts.Steps))
+	}
+	m := make(sequenceMatcher, len(opts.Steps))
+	for i, step := range opts.Steps {
+		step = strings.TrimSpace(step)
+		if step == "" {
+			return nil, fmt.Errorf("step %d is empty", i+1)
+		}
+		if !opts.Regexp {
+			m[i] = func(command string) bool { return strings.HasPrefix(command, step) }
+			continue
+		}
+		re, err := regexp.Compile(step)
+		if err != nil {
+			return nil, fmt.Errorf("invalid step %d: %w", i+1, err)
+		}
+		m[i] = re.MatchString
+	}
+	return m, nil
+}
+
+// FindSequences returns every occurrence of opts.Steps run in order in the
+// same history, each step within opts.Within of the one before, newest
+// first. Other commands may come between the steps. Occurrences don't
+// share commands; where a step repeats before the sequence goes on, the
+// latest run of it counts.
+func FindSequences(ctx context.Context, db *sql.DB, opts SequenceOptions) ([]SequenceMatch, error) {
+	matcher, err := newSequenceMatcher(opts)
+	if err != nil {
+		return nil, err
+	}
+	if opts.Within <= 0 {
+		opts.Within = DefaultSequenceWithin
+	}
+
+	query := `SELECT rowid, source, timestamp, command FROM commands WHERE 1=1`
+	var args []interface{}
+	if !opts.Regexp {
+		// Only commands starting with a step can take part
+		var prefixes []string
+		for _, step := range opts.Steps {
+			prefixes = append(prefixes, "substr(command, 1, length(?)) = ?")
+			args = append(args, strings.TrimSpace(step), strings.TrimSpace(step))
+		}
+		query += " AND (" + strings.Join(prefixes, " OR ") + ")"
+	}
+	if opts.Since > 0 {
+		query += " AND timestamp >= ?"
+		args = append(args, opts.Since)
+	}
+	if opts.Until > 0 {
+		query += " AND timestamp <= ?"
+		args = append(args, opts.Until)
+	}
+	if opts.Source != "" {
+		query += " AND source LIKE ?"
+		args = append(args, "%"+opts.Source+"%")
+	}
+	query += " ORDER BY source, timestamp, seq"
+
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to search sequences: %w", err)
+	}
+	defer rows.Close()
+
+	var matches []SequenceMatch
+	// partial[i] is the latest run of the first i+1 steps still in time for
+	// the next one
+	partial := make([][]SequenceStep, len(matcher))
+	source := ""
+	for rows.Next() {
+		var s SequenceStep
+		var rowSource string
+		if err := rows.Scan(&s.ID, &rowSource, &s.Timestamp, &s.Command); err != nil {
+			return nil, fmt.Errorf("failed to scan command: %w", err)
+		}
+		if rowSource != source {
+			source = rowSource
+			clear(partial)
+		}
+
+		completed := false
+		// From the furthest along, so one command takes one step of a run
+		for i := len(matcher) - 2; i >= 0; i-- {
+			p := partial[i]
+			if p == nil {
+				continue
+			}
+			if s.Timestamp-p[len(p)-1].Timestamp > opts.Within.Seconds() {
+				partial[i] = nil
+				continue
+			}
+			if !matcher[i+1](s.Command) {
+				continue
+			}
+			next := append(slices.Clip(p), s)
+			if i+1 == len(matcher)-1 {
+				matches = append(matches, SequenceMatch{Source: source, Steps: next})
+				clear(partial)
+				completed = true
+				break
+			}
+			partial[i+1] = next
+		}
+		if !completed && matcher[0](s.Command) {
+			partial[0] = []SequenceStep{s}
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating commands: %w", err)
+	}
+
+	slices.SortStableFunc(matches, func(a, b SequenceMatch) int {
+		return cmp.Compare(b.Steps[0].Timestamp, a.Steps[0].Timestamp)
+	})
+	return matches, nil
+}
+
+// writeSequencesText prints how often the sequence occurred and how far
+// apart its steps usually were, then up to limit occurrences
+func writeSequencesText(w io.Writer, steps []string, matches []SequenceMatch, limit int, color bool) {
+	name := strings.Join(steps, " "+symbol("→", "->")+" ")
+	if len(matches) == 0 {
+		fmt.Fprintf(w, "%s: no occurrences\n", name)
+		return
+	}
+	sources := map[string]bool{}
+	spans := make([]time.Duration, len(matches))
+	for i, m := range matches {
+		sources[m.Source] = true
+		spans[i] = m.Span()
+	}
+	slices.Sort(spans)
+	fmt.Fprintf(w, "%s: %d occurrence(s) in %d source(s), first to last step %s apart (median)\n",
+		name, len(matches), len(sources), spans[len(spans)/2].Round(time.Second))
+
+	for i, m := range matches {
+		if limit > 0 && i == limit {
+			fmt.Fprintf(w, "\n... %d older occurrence(s), raise --limit to see them\n", len(matches)-limit)
+			break
+		}
+		header := fmt.Sprintf("%s  %s", FormatTimestamp(m.Steps[0].Timestamp), m.Source)
+		if color {
+			header = colorSource(header, m.Source)
+		}
+		fmt.Fprintf(w, "\n%s\n", header)
+		for j, s := range m.Steps {
+			command, _ := truncateCommand(s.Command, 100)
+			if j == 0 {
+				fmt.Fprintf(w, "  %s\n", command)
+			} else {
+				after := time.Duration((s.Timestamp - m.Steps[j-1].Timestamp) * float64(time.Second)).Round(time.Second)
+				fmt.Fprintf(w, "  %s  (+%s)\n", command, after)
+			}
+		}
+	}
+}
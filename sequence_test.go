@@ -0,0 +1,132 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFindSequences(t *testing.T) {
+	ctx := context.Background()
+	db, err := InitDB(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("InitDB() error = %v", err)
+	}
+	defer db.Close()
+
+	commands := []Command{
+		{Source: "/laptop", Timestamp: 1000, Command: "git stash", Seq: 1},
+		{Source: "/laptop", Timestamp: 1010, Command: "git status", Seq: 2},
+		{Source: "/laptop", Timestamp: 1060, Command: "git checkout main", Seq: 3},
+		{Source: "/laptop", Timestamp: 1100, Command: "git stash pop", Seq: 4},
+		// Too long before the checkout
+		{Source: "/laptop", Timestamp: 2000, Command: "git stash", Seq: 5},
+		{Source: "/laptop", Timestamp: 2000 + 600, Command: "git checkout dev", Seq: 6},
+		// The later stash counts
+		{Source: "/laptop", Timestamp: 4000, Command: "git stash", Seq: 7},
+		{Source: "/laptop", Timestamp: 4200, Command: "git stash", Seq: 8},
+		{Source: "/laptop", Timestamp: 4400, Command: "git checkout dev", Seq: 9},
+		{Source: "/laptop", Timestamp: 4410, Command: "git stash pop", Seq: 10},
+		// Another history doesn't continue this one
+		{Source: "/server", Timestamp: 4005, Command: "git checkout main", Seq: 1},
+		{Source: "/server", Timestamp: 5000, Command: "git stash", Seq: 2},
+		{Source: "/server", Timestamp: 5000.5, Command: "git checkout -b fix", Seq: 3},
+	}
+	if _, _, err := InsertCommands(ctx, db, commands); err != nil {
+		t.Fatal(err)
+	}
+
+	type occurrence struct {
+		source string
+		start  float64
+		steps  int
+	}
+	tests := []struct {
+		name    string
+		opts    SequenceOptions
+		want    []occurrence
+		wantErr bool
+	}{
+		{
+			name: "two steps",
+			opts: SequenceOptions{Steps: []string{"git stash", "git checkout"}},
+			want: []occurrence{{"/server", 5000, 2}, {"/laptop", 4200, 2}, {"/laptop", 1000, 2}},
+		},
+		{
+			name: "three steps",
+			opts: SequenceOptions{Steps: []string{"git stash", "git checkout", "git stash pop"}},
+			want: []occurrence{{"/laptop", 4200, 3}, {"/laptop", 1000, 3}},
+		},
+		{
+			name: "longer window",
+			opts: SequenceOptions{Steps: []string{"git stash", "git checkout"}, Within: 15 * time.Minute},
+			want: []occurrence{{"/server", 5000, 2}, {"/laptop", 4200, 2}, {"/laptop", 2000, 2}, {"/laptop", 1000, 2}},
+		},
+		{
+			name: "regexp",
+			opts: SequenceOptions{Steps: []string{"stash$", "checkout (main|-b)"}, Regexp: true},
+			want: []occurrence{{"/server", 5000, 2}, {"/laptop", 1000, 2}},
+		},
+		{
+			name: "source and since",
+			opts: SequenceOptions{Steps: []string{"git stash", "git checkout"}, Source: "laptop", Since: 3000},
+			want: []occurrence{{"/laptop", 4200, 2}},
+		},
+		{
+			name:    "one step",
+			opts:    SequenceOptions{Steps: []string{"git stash"}},
+			wantErr: true,
+		},
+		{
+			name:    "empty step",
+			opts:    SequenceOptions{Steps: []string{"git stash", " "}},
+			wantErr: true,
+		},
+		{
+			name:    "invalid regexp",
+			opts:    SequenceOptions{Steps: []string{"git stash", "("}, Regexp: true},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			matches, err := FindSequences(ctx, db, tt.opts)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("FindSequences() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			var got []occurrence
+			for _, m := range matches {
+				got = append(got, occurrence{m.Source, m.Steps[0].Timestamp, len(m.Steps)})
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("FindSequences() = %v, want %v", got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Errorf("FindSequences() = %v, want %v", got, tt.want)
+					break
+				}
+			}
+		})
+	}
+
+	matches, err := FindSequences(ctx, db, SequenceOptions{Steps: []string{"git stash", "git checkout"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	writeSequencesText(&buf, []string{"git stash", "git checkout"}, matches, 2, false)
+	for _, want := range []string{
+		"git stash → git checkout: 3 occurrence(s) in 2 source(s), first to last step 1m0s apart (median)",
+		"  git checkout -b fix  (+1s)",
+		"  git checkout dev  (+3m20s)",
+		"1 older occurrence(s)",
+	} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("writeSequencesText() missing %q in\n%s", want, buf.String())
+		}
+	}
+}
@@ -0,0 +1,688 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tchaudhry91/zist/llm"
+)
+
+type contextKey string
+
+const userKey contextKey = "user"
+
+// The most rows one request returns; larger limits are clamped to these
+var (
+	maxSearchLimit      = 5000
+	maxStatsTop         = 1000
+	maxWizardCacheLimit = 1000
+)
+
+// Server serves the zist HTTP API. Each user has a separate history
+// database so namespaces never mix; shares grant read access across them.
+type Server struct {
+	dataDir string
+	authDB  *sql.DB
+	limits  ServerLimits
+	limiter *rateLimiter
+	health  healthState
+	ui      bool       // serve the web UI at /
+	llm     llm.Client // answers POST /api/v1/wizard, nil to disable it
+
+	mu      sync.Mutex
+	userDBs map[string]*sql.DB
+}
+
+// NewServer opens the server database in dataDir
+func NewServer(dataDir string, limits ServerLimits) (*Server, error) {
+	authDB, err := InitServerDB(dataDir)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Server{
+		dataDir: dataDir,
+		authDB:  authDB,
+		limits:  limits,
+		userDBs: make(map[string]*sql.DB),
+	}
+	if limits.RequestsPerMinute > 0 {
+		s.limiter = newRateLimiter(limits.RequestsPerMinute, limits.Burst)
+	}
+	return s, nil
+}
+
+// Close closes the server and all user databases
+func (s *Server) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for name, db := range s.userDBs {
+		db.Close()
+		delete(s.userDBs, name)
+	}
+	return s.authDB.Close()
+}
+
+// userDB returns the history database for a user, opening it on first use
+func (s *Server) userDB(name string) (*sql.DB, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if db, ok := s.userDBs[name]; ok {
+		return db, nil
+	}
+
+	db, err := InitDB(UserDBPath(s.dataDir, name))
+	if err != nil {
+		return nil, err
+	}
+	s.userDBs[name] = db
+	return db, nil
+}
+
+// forgetUserDB closes a deleted user's database handle
+func (s *Server) forgetUserDB(name string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if db, ok := s.userDBs[name]; ok {
+		db.Close()
+		delete(s.userDBs, name)
+	}
+}
+
+// route is one API endpoint. Routes are listed in one place so the OpenAPI
+// spec can be checked against them.
+type route struct {
+	pattern string
+	handler http.Handler
+}
+
+// routes returns every API endpoint
+func (s *Server) routes() []route {
+	return []route{
+		// Unauthenticated so systemd and k8s probes can reach them
+		{"GET /healthz", http.HandlerFunc(s.handleHealthz)},
+		{"GET /readyz", http.HandlerFunc(s.handleReadyz)},
+		{"GET /openapi.json", http.HandlerFunc(handleOpenAPI)},
+
+		{"POST /api/v1/commands", s.auth(s.handlePushCommands)},
+		{"POST /api/v1/commands/diff", s.auth(s.handleDiffCommands)},
+		{"GET /api/v1/search", s.auth(s.handleSearch)},
+		{"GET /api/v1/stats", s.auth(s.handleStats)},
+
+		{"GET /api/v1/wizard-cache", s.auth(s.handleListWizardCache)},
+		{"PUT /api/v1/wizard-cache", s.auth(s.handleSetWizardCache)},
+		{"POST /api/v1/wizard", s.auth(s.handleWizard)},
+
+		{"GET /api/v1/shares", s.auth(s.handleListShares)},
+		{"POST /api/v1/shares", s.auth(s.handleShare)},
+		{"DELETE /api/v1/shares/{user}", s.auth(s.handleUnshare)},
+
+		{"GET /api/v1/users", s.auth(s.admin(s.handleListUsers))},
+		{"POST /api/v1/users", s.auth(s.admin(s.handleCreateUser))},
+		{"DELETE /api/v1/users/{user}", s.auth(s.admin(s.handleDeleteUser))},
+	}
+}
+
+// Handler returns the API routes
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	for _, r := range s.routes() {
+		mux.Handle(r.pattern, r.handler)
+	}
+	if s.ui {
+		mux.Handle("GET /", uiHandler())
+	}
+	return s.limitRequests(mux)
+}
+
+// auth resolves the bearer token to a user and applies their rate limit.
+// Failed attempts are limited per client IP to slow down token guessing.
+func (s *Server) auth(next http.HandlerFunc) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok {
+			if !s.rateLimited(w, "ip:"+clientIP(r)) {
+				writeError(w, http.StatusUnauthorized, "missing bearer token")
+			}
+			return
+		}
+
+		user, err := Authenticate(r.Context(), s.authDB, token)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		if user == nil {
+			if !s.rateLimited(w, "ip:"+clientIP(r)) {
+				writeError(w, http.StatusUnauthorized, "invalid token")
+			}
+			return
+		}
+		if s.rateLimited(w, "user:"+user.Name) {
+			return
+		}
+
+		next(w, r.WithContext(context.WithValue(r.Context(), userKey, user)))
+	})
+}
+
+// admin restricts a handler to admins
+func (s *Server) admin(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if requestUser(r).Role != RoleAdmin {
+			writeError(w, http.StatusForbidden, "admin role required")
+			return
+		}
+		next(w, r)
+	}
+}
+
+func requestUser(r *http.Request) *ServerUser {
+	return r.Context().Value(userKey).(*ServerUser)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("failed to write response: %v", err)
+	}
+}
+
+func writeError(w http.ResponseWriter, status int, msg string) {
+	writeJSON(w, status, map[string]string{"error": msg})
+}
+
+// APICommand is a command as sent to and returned by the API
+type APICommand struct {
+	ID        int64   `json:"id,omitempty"`
+	User      string  `json:"user,omitempty"`
+	Source    string  `json:"source"`
+	Timestamp float64 `json:"timestamp"`
+	Command   string  `json:"command"`
+	EnvPrefix string  `json:"env_prefix,omitempty"`
+	Duration  int     `json:"duration,omitempty"`
+	Seq       int64   `json:"seq,omitempty"`
+	Note      string  `json:"note,omitempty"`
+}
+
+// readPushedCommands decodes and validates a pushed command list, writing
+// the error response and returning false if it is unusable
+func (s *Server) readPushedCommands(w http.ResponseWriter, r *http.Request) ([]Command, bool) {
+	var pushed []APICommand
+	if err := json.NewDecoder(r.Body).Decode(&pushed); err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			writeError(w, http.StatusRequestEntityTooLarge, fmt.Sprintf("request body over %d bytes", tooLarge.Limit))
+			return nil, false
+		}
+		writeError(w, http.StatusBadRequest, "invalid JSON: "+err.Error())
+		return nil, false
+	}
+	if s.limits.MaxPushCommands > 0 && len(pushed) > s.limits.MaxPushCommands {
+		writeError(w, http.StatusRequestEntityTooLarge, fmt.Sprintf("at most %d commands per push", s.limits.MaxPushCommands))
+		return nil, false
+	}
+
+	commands := make([]Command, 0, len(pushed))
+	for _, c := range pushed {
+		if c.Source == "" || c.Command == "" {
+			writeError(w, http.StatusBadRequest, "source and command are required")
+			return nil, false
+		}
+		commands = append(commands, Command{
+			Source:    c.Source,
+			Timestamp: c.Timestamp,
+			Command:   c.Command,
+			EnvPrefix: c.EnvPrefix,
+			Duration:  c.Duration,
+			Seq:       c.Seq,
+		})
+	}
+
+	return commands, true
+}
+
+// PushResult is the response to a push. ClockSkew lists pushed sources with
+// commands in the future by the server's clock.
+type PushResult struct {
+	Inserted  int         `json:"inserted"`
+	Ignored   int         `json:"ignored"`
+	ClockSkew []ClockSkew `json:"clock_skew,omitempty"`
+}
+
+func (s *Server) handlePushCommands(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	commands, ok := s.readPushedCommands(w, r)
+	if !ok {
+		return
+	}
+
+	db, err := s.userDB(requestUser(r).Name)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	offsets, err := GetClockOffsets(ctx, db)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	ApplyClockOffsets(commands, offsets)
+
+	inserted, ignored, err := InsertCommandsBatch(ctx, db, commands, 1000)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	writeJSON(w, http.StatusOK, PushResult{
+		Inserted:  inserted,
+		Ignored:   ignored,
+		ClockSkew: futureCommands(commands, time.Now()),
+	})
+}
+
+// handleDiffCommands reports what pushing the commands would do, for
+// `zist sync --diff`
+func (s *Server) handleDiffCommands(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	commands, ok := s.readPushedCommands(w, r)
+	if !ok {
+		return
+	}
+
+	db, err := s.userDB(requestUser(r).Name)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	offsets, err := GetClockOffsets(ctx, db)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	ApplyClockOffsets(commands, offsets)
+
+	diff, err := DiffCommands(ctx, db, commands)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, diff)
+}
+
+// handleSearch searches the caller's history, plus histories shared with
+// them when ?shared=true
+func (s *Server) handleSearch(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	user := requestUser(r)
+	q := r.URL.Query()
+
+	// Results from several users are merged newest first
+	opts := SearchOptions{
+		Query:  q.Get("q"),
+		Source: q.Get("source"),
+		Sort:   SortTime,
+	}
+	opts.Exact, _ = strconv.ParseBool(q.Get("exact"))
+	opts.CaseSensitive, _ = strconv.ParseBool(q.Get("case_sensitive"))
+	var err error
+	if opts.Limit, err = queryInt(q.Get("limit"), 500); err != nil || opts.Limit == 0 {
+		writeError(w, http.StatusBadRequest, "invalid limit")
+		return
+	}
+	opts.Limit = min(opts.Limit, maxSearchLimit)
+	if opts.Since, err = parseDateTime(q.Get("since")); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if opts.Until, err = parseDateTime(q.Get("until")); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	users := []string{user.Name}
+	if shared, _ := strconv.ParseBool(q.Get("shared")); shared {
+		owners, err := SharedWith(ctx, s.authDB, user.Name)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		users = append(users, owners...)
+	}
+
+	var results []APICommand
+	for _, name := range users {
+		db, err := s.userDB(name)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		found, err := SearchCommands(ctx, db, opts)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		for _, f := range found {
+			results = append(results, APICommand{
+				ID:        f.ID,
+				User:      name,
+				Source:    f.Source,
+				Timestamp: f.Timestamp,
+				Command:   f.Command,
+				EnvPrefix: f.EnvPrefix,
+				Note:      f.Note,
+			})
+		}
+	}
+
+	sort.SliceStable(results, func(i, j int) bool { return results[i].Timestamp > results[j].Timestamp })
+	if len(results) > opts.Limit {
+		results = results[:opts.Limit]
+	}
+	if results == nil {
+		results = []APICommand{}
+	}
+
+	writeJSON(w, http.StatusOK, results)
+}
+
+// APIStats summarises the caller's history for the web UI stats page
+type APIStats struct {
+	TotalCommands int64             `json:"total_commands"`
+	Sources       []SourceCount     `json:"sources"`
+	TopCommands   []FrequentCommand `json:"top_commands"`
+	Daily         []DayCount        `json:"daily"`
+}
+
+// handleStats returns totals, per-source counts, the most used commands and
+// commands per day over the last ?days=N (default 30)
+func (s *Server) handleStats(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	q := r.URL.Query()
+	days, err := queryInt(q.Get("days"), 30)
+	if err != nil || days == 0 || days > 366 {
+		writeError(w, http.StatusBadRequest, "days must be between 1 and 366")
+		return
+	}
+	top, err := queryInt(q.Get("top"), 20)
+	if err != nil || top == 0 {
+		writeError(w, http.StatusBadRequest, "invalid top")
+		return
+	}
+	top = min(top, maxStatsTop)
+
+	db, err := s.userDB(requestUser(r).Name)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	stats := APIStats{Sources: []SourceCount{}, TopCommands: []FrequentCommand{}, Daily: []DayCount{}}
+	if err := db.QueryRowContext(ctx, "SELECT COUNT(*) FROM commands").Scan(&stats.TotalCommands); err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	sources, err := GetSourceCounts(ctx, db)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	topCommands, err := GetFrequentCommands(ctx, db, "", top)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	since := time.Now().AddDate(0, 0, -days)
+	daily, err := GetDailyCounts(ctx, db, float64(since.Unix()))
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	stats.Sources = append(stats.Sources, sources...)
+	stats.TopCommands = append(stats.TopCommands, topCommands...)
+	stats.Daily = append(stats.Daily, daily...)
+	writeJSON(w, http.StatusOK, stats)
+}
+
+func (s *Server) handleListWizardCache(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	db, err := s.userDB(requestUser(r).Name)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	if query := r.URL.Query().Get("q"); query != "" {
+		entry, err := GetWizardCache(ctx, db, r.URL.Query().Get("project"), query)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		if entry == nil {
+			writeError(w, http.StatusNotFound, "not cached")
+			return
+		}
+		writeJSON(w, http.StatusOK, entry)
+		return
+	}
+
+	limit, err := queryInt(r.URL.Query().Get("limit"), 50)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid limit")
+		return
+	}
+	limit = min(limit, maxWizardCacheLimit)
+	entries, err := ListWizardCache(ctx, db, limit)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if entries == nil {
+		entries = []WizardCacheEntry{}
+	}
+	writeJSON(w, http.StatusOK, entries)
+}
+
+func (s *Server) handleSetWizardCache(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	var req struct {
+		Project string `json:"project"`
+		Query   string `json:"query"`
+		Command string `json:"command"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Query == "" || req.Command == "" {
+		writeError(w, http.StatusBadRequest, "query and command are required")
+		return
+	}
+
+	db, err := s.userDB(requestUser(r).Name)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if err := SetWizardCache(ctx, db, req.Project, req.Query, req.Command); err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleWizard generates a command from the caller's wizard cache and
+// history. The LLM client stays warm between requests, which keeps latency
+// low enough for the shell's ghost text preview.
+func (s *Server) handleWizard(w http.ResponseWriter, r *http.Request) {
+	if s.llm == nil {
+		writeError(w, http.StatusNotImplemented, "wizard is not enabled on this server")
+		return
+	}
+
+	var req struct {
+		Project string `json:"project"`
+		Query   string `json:"query"`
+		PWD     string `json:"pwd"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || strings.TrimSpace(req.Query) == "" {
+		writeError(w, http.StatusBadRequest, "query is required")
+		return
+	}
+
+	db, err := s.userDB(requestUser(r).Name)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	resp, err := NewWizard(db, s.llm).Generate(r.Context(), WizardRequest{
+		Query:    req.Query,
+		PWD:      req.PWD,
+		Project:  req.Project,
+		Examples: DefaultWizardExamples,
+	})
+	if err != nil {
+		writeError(w, http.StatusBadGateway, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"command":    resp.Command,
+		"source":     resp.Source,
+		"latency_ms": resp.Latency.Milliseconds(),
+	})
+}
+
+func (s *Server) handleListShares(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	user := requestUser(r)
+
+	sharedBy, err := SharedBy(ctx, s.authDB, user.Name)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	sharedWith, err := SharedWith(ctx, s.authDB, user.Name)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string][]string{
+		"shared_with":    nonNil(sharedBy),
+		"shared_with_me": nonNil(sharedWith),
+	})
+}
+
+func (s *Server) handleShare(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	var req struct {
+		User string `json:"user"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.User == "" {
+		writeError(w, http.StatusBadRequest, "user is required")
+		return
+	}
+
+	if err := ShareHistory(ctx, s.authDB, requestUser(r).Name, req.User); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) handleUnshare(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	if err := UnshareHistory(ctx, s.authDB, requestUser(r).Name, r.PathValue("user")); err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) handleListUsers(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	users, err := ListUsers(ctx, s.authDB)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if users == nil {
+		users = []ServerUser{}
+	}
+	writeJSON(w, http.StatusOK, users)
+}
+
+func (s *Server) handleCreateUser(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	var req struct {
+		Name string `json:"name"`
+		Role string `json:"role"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid JSON: "+err.Error())
+		return
+	}
+	if req.Role == "" {
+		req.Role = RoleMember
+	}
+
+	token, err := CreateUser(ctx, s.authDB, req.Name, req.Role)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, map[string]string{"name": req.Name, "role": req.Role, "token": token})
+}
+
+func (s *Server) handleDeleteUser(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	name := r.PathValue("user")
+	deleted, err := DeleteUser(ctx, s.authDB, name)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if !deleted {
+		writeError(w, http.StatusNotFound, fmt.Sprintf("no user named %q", name))
+		return
+	}
+	s.forgetUserDB(name)
+	if _, err := RetireUserDB(s.dataDir, name); err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func queryInt(s string, def int) (int, error) {
+	if s == "" {
+		return def, nil
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil || n < 0 {
+		return 0, errors.New("invalid integer")
+	}
+	return n, nil
+}
+
+func nonNil(s []string) []string {
+	if s == nil {
+		return []string{}
+	}
+	return s
+}
@@ -0,0 +1,264 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"time"
+)
+
+const (
+	// RoleAdmin can manage users in addition to using their own namespace
+	RoleAdmin = "admin"
+	// RoleMember can only use their own namespace and histories shared with them
+	RoleMember = "member"
+)
+
+var userNameRe = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,31}$`)
+
+// ServerUser is an account on a zist server
+type ServerUser struct {
+	Name      string  `json:"name"`
+	Role      string  `json:"role"`
+	CreatedAt float64 `json:"created_at"`
+}
+
+// InitServerDB opens the server's user database in dataDir
+func InitServerDB(dataDir string) (*sql.DB, error) {
+	dataDir = expandTilde(dataDir)
+	if err := os.MkdirAll(filepath.Join(dataDir, "users"), 0700); err != nil {
+		return nil, fmt.Errorf("failed to create server directory: %w", err)
+	}
+
+	db, err := sql.Open("sqlite", filepath.Join(dataDir, "server.db")+"?_foreign_keys=on")
+	if err != nil {
+		return nil, fmt.Errorf("failed to open server database: %w", err)
+	}
+
+	queries := []string{
+		`CREATE TABLE IF NOT EXISTS users (
+			name TEXT PRIMARY KEY,
+			role TEXT NOT NULL,
+			token_hash TEXT NOT NULL UNIQUE,
+			created_at REAL NOT NULL
+		);`,
+		// owner's history is readable by grantee
+		`CREATE TABLE IF NOT EXISTS shares (
+			owner TEXT NOT NULL,
+			grantee TEXT NOT NULL,
+			created_at REAL NOT NULL,
+			PRIMARY KEY (owner, grantee)
+		);`,
+	}
+	for _, query := range queries {
+		if _, err := db.Exec(query); err != nil {
+			db.Close()
+			return nil, fmt.Errorf("failed to execute query '%s': %w", query, err)
+		}
+	}
+
+	return db, nil
+}
+
+// UserDBPath returns where a user's history database lives
+func UserDBPath(dataDir, name string) string {
+	return filepath.Join(expandTilde(dataDir), "users", name+".db")
+}
+
+// RetireUserDB moves a deleted user's history database aside, with its WAL
+// and shared memory files, so a new user of the same name starts empty. It
+// returns where the database went, or "" if the user had none.
+func RetireUserDB(dataDir, name string) (string, error) {
+	path := UserDBPath(dataDir, name)
+	retired := fmt.Sprintf("%s.deleted-%s", path, time.Now().Format("20060102-150405.000"))
+	if err := os.Rename(path, retired); err != nil {
+		if os.IsNotExist(err) {
+			return "", nil
+		}
+		return "", fmt.Errorf("failed to move history database: %w", err)
+	}
+	for _, suffix := range []string{"-wal", "-shm"} {
+		if err := os.Rename(path+suffix, retired+suffix); err != nil && !os.IsNotExist(err) {
+			return "", fmt.Errorf("failed to move history database: %w", err)
+		}
+	}
+	return retired, nil
+}
+
+// newToken returns a random API token and the hash stored for it
+func newToken() (string, string, error) {
+	buf := make([]byte, 24)
+	if _, err := rand.Read(buf); err != nil {
+		return "", "", fmt.Errorf("failed to generate token: %w", err)
+	}
+	token := "zist_" + hex.EncodeToString(buf)
+	return token, hashToken(token), nil
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// CreateUser adds a user and returns their API token. The token is only
+// stored hashed, so this is the one chance to show it.
+func CreateUser(ctx context.Context, db *sql.DB, name, role string) (string, error) {
+	if !userNameRe.MatchString(name) {
+		return "", fmt.Errorf("invalid user name %q (use lowercase letters, digits, - and _)", name)
+	}
+	if role != RoleAdmin && role != RoleMember {
+		return "", fmt.Errorf("invalid role %q (use %s or %s)", role, RoleAdmin, RoleMember)
+	}
+
+	token, hash, err := newToken()
+	if err != nil {
+		return "", err
+	}
+
+	_, err = db.ExecContext(ctx, `INSERT INTO users (name, role, token_hash, created_at) VALUES (?, ?, ?, ?)`,
+		name, role, hash, float64(time.Now().Unix()))
+	if err != nil {
+		return "", fmt.Errorf("failed to create user: %w", err)
+	}
+
+	return token, nil
+}
+
+// RotateToken replaces a user's API token, invalidating the old one
+func RotateToken(ctx context.Context, db *sql.DB, name string) (string, error) {
+	token, hash, err := newToken()
+	if err != nil {
+		return "", err
+	}
+
+	result, err := db.ExecContext(ctx, `UPDATE users SET token_hash = ? WHERE name = ?`, hash, name)
+	if err != nil {
+		return "", fmt.Errorf("failed to rotate token: %w", err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return "", fmt.Errorf("no user named %q", name)
+	}
+
+	return token, nil
+}
+
+// DeleteUser removes a user and any shares involving them. Their history
+// database is left on disk for RetireUserDB.
+func DeleteUser(ctx context.Context, db *sql.DB, name string) (bool, error) {
+	result, err := db.ExecContext(ctx, `DELETE FROM users WHERE name = ?`, name)
+	if err != nil {
+		return false, fmt.Errorf("failed to delete user: %w", err)
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to get rows affected: %w", err)
+	}
+
+	if _, err := db.ExecContext(ctx, `DELETE FROM shares WHERE owner = ? OR grantee = ?`, name, name); err != nil {
+		return false, fmt.Errorf("failed to delete shares: %w", err)
+	}
+
+	return n > 0, nil
+}
+
+// ListUsers returns all users by name
+func ListUsers(ctx context.Context, db *sql.DB) ([]ServerUser, error) {
+	rows, err := db.QueryContext(ctx, `SELECT name, role, created_at FROM users ORDER BY name`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list users: %w", err)
+	}
+	defer rows.Close()
+
+	var users []ServerUser
+	for rows.Next() {
+		var u ServerUser
+		if err := rows.Scan(&u.Name, &u.Role, &u.CreatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan user: %w", err)
+		}
+		users = append(users, u)
+	}
+
+	return users, rows.Err()
+}
+
+// Authenticate returns the user owning the token, or nil if there is none
+func Authenticate(ctx context.Context, db *sql.DB, token string) (*ServerUser, error) {
+	if token == "" {
+		return nil, nil
+	}
+
+	var u ServerUser
+	err := db.QueryRowContext(ctx, `SELECT name, role, created_at FROM users WHERE token_hash = ?`, hashToken(token)).
+		Scan(&u.Name, &u.Role, &u.CreatedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to authenticate: %w", err)
+	}
+
+	return &u, nil
+}
+
+// ShareHistory lets grantee search owner's history
+func ShareHistory(ctx context.Context, db *sql.DB, owner, grantee string) error {
+	var exists int
+	if err := db.QueryRowContext(ctx, `SELECT COUNT(*) FROM users WHERE name = ?`, grantee).Scan(&exists); err != nil {
+		return fmt.Errorf("failed to look up user: %w", err)
+	}
+	if exists == 0 {
+		return fmt.Errorf("no user named %q", grantee)
+	}
+	if owner == grantee {
+		return fmt.Errorf("cannot share history with yourself")
+	}
+
+	_, err := db.ExecContext(ctx, `INSERT OR IGNORE INTO shares (owner, grantee, created_at) VALUES (?, ?, ?)`,
+		owner, grantee, float64(time.Now().Unix()))
+	if err != nil {
+		return fmt.Errorf("failed to share history: %w", err)
+	}
+	return nil
+}
+
+// UnshareHistory revokes grantee's access to owner's history
+func UnshareHistory(ctx context.Context, db *sql.DB, owner, grantee string) error {
+	if _, err := db.ExecContext(ctx, `DELETE FROM shares WHERE owner = ? AND grantee = ?`, owner, grantee); err != nil {
+		return fmt.Errorf("failed to unshare history: %w", err)
+	}
+	return nil
+}
+
+// SharedWith returns the users whose history grantee may search
+func SharedWith(ctx context.Context, db *sql.DB, grantee string) ([]string, error) {
+	return queryNames(ctx, db, `SELECT owner FROM shares WHERE grantee = ? ORDER BY owner`, grantee)
+}
+
+// SharedBy returns the users owner has shared their history with
+func SharedBy(ctx context.Context, db *sql.DB, owner string) ([]string, error) {
+	return queryNames(ctx, db, `SELECT grantee FROM shares WHERE owner = ? ORDER BY grantee`, owner)
+}
+
+func queryNames(ctx context.Context, db *sql.DB, query string, arg string) ([]string, error) {
+	rows, err := db.QueryContext(ctx, query, arg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list shares: %w", err)
+	}
+	defer rows.Close()
+
+	var names []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, fmt.Errorf("failed to scan share: %w", err)
+		}
+		names = append(names, name)
+	}
+	return names, rows.Err()
+}
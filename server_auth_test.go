@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"testing"
+)
+
+func TestServerUsers(t *testing.T) {
+	db, err := InitServerDB(t.TempDir())
+	if err != nil {
+		t.Fatalf("InitServerDB() error = %v", err)
+	}
+	defer db.Close()
+
+	token, err := CreateUser(context.Background(), db, "alice", RoleAdmin)
+	if err != nil {
+		t.Fatalf("CreateUser() error = %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		user    string
+		role    string
+		wantErr bool
+	}{
+		{"member", "bob", RoleMember, false},
+		{"duplicate", "alice", RoleMember, true},
+		{"bad role", "carol", "root", true},
+		{"bad name", "../etc", RoleMember, true},
+		{"uppercase name", "Dave", RoleMember, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := CreateUser(context.Background(), db, tt.user, tt.role)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("CreateUser(%q, %q) error = %v, wantErr %v", tt.user, tt.role, err, tt.wantErr)
+			}
+		})
+	}
+
+	user, err := Authenticate(context.Background(), db, token)
+	if err != nil || user == nil || user.Name != "alice" || user.Role != RoleAdmin {
+		t.Fatalf("Authenticate() = %+v, %v, want alice admin", user, err)
+	}
+
+	newToken, err := RotateToken(context.Background(), db, "alice")
+	if err != nil {
+		t.Fatalf("RotateToken() error = %v", err)
+	}
+	if user, _ := Authenticate(context.Background(), db, token); user != nil {
+		t.Error("Authenticate() with rotated-out token succeeded")
+	}
+	if user, _ := Authenticate(context.Background(), db, newToken); user == nil {
+		t.Error("Authenticate() with new token failed")
+	}
+
+	if err := ShareHistory(context.Background(), db, "alice", "bob"); err != nil {
+		t.Fatalf("ShareHistory() error = %v", err)
+	}
+	if err := ShareHistory(context.Background(), db, "alice", "nobody"); err == nil {
+		t.Error("ShareHistory() with unknown user error = nil, want error")
+	}
+	owners, err := SharedWith(context.Background(), db, "bob")
+	if err != nil || len(owners) != 1 || owners[0] != "alice" {
+		t.Errorf("SharedWith(bob) = %v, %v, want [alice]", owners, err)
+	}
+
+	deleted, err := DeleteUser(context.Background(), db, "alice")
+	if err != nil || !deleted {
+		t.Fatalf("DeleteUser() = %v, %v, want true", deleted, err)
+	}
+	owners, _ = SharedWith(context.Background(), db, "bob")
+	if len(owners) != 0 {
+		t.Errorf("SharedWith(bob) after deleting alice = %v, want none", owners)
+	}
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ftsCheckInterval bounds how often /readyz runs the FTS integrity check,
+// which reads the whole index
+const ftsCheckInterval = time.Minute
+
+// DefaultMinFreeDisk is the free space /readyz requires in the data dir
+const DefaultMinFreeDisk = 100 << 20
+
+// healthState caches the last FTS check for /readyz, and what it last
+// logged about each check
+type healthState struct {
+	mu        sync.Mutex
+	checkedAt time.Time
+	ftsErr    error
+	// checking is closed when the FTS check under way ends, nil when none is
+	checking chan struct{}
+	logged   map[string]string
+}
+
+// ReadinessCheck is the outcome of one readiness check. /readyz needs no
+// token, so what went wrong is only logged.
+type ReadinessCheck struct {
+	OK bool `json:"ok"`
+}
+
+// handleHealthz reports that the process is up and serving requests
+func (s *Server) handleHealthz(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+// handleReadyz reports whether the server can do useful work: its databases
+// answer, their FTS indexes are intact and there is disk space left
+func (s *Server) handleReadyz(w http.ResponseWriter, r *http.Request) {
+	results := map[string]error{
+		"database": s.checkDatabase(),
+		"fts":      s.checkFTS(),
+		"disk":     s.checkDisk(),
+	}
+
+	status, code := "ok", http.StatusOK
+	checks := map[string]ReadinessCheck{}
+	for name, err := range results {
+		checks[name] = ReadinessCheck{OK: err == nil}
+		if err != nil {
+			status, code = "unavailable", http.StatusServiceUnavailable
+		}
+		s.logReadiness(name, err)
+	}
+
+	writeJSON(w, code, map[string]interface{}{"status": status, "checks": checks})
+}
+
+// logReadiness logs a check failing, or passing again, once rather than at
+// every probe
+func (s *Server) logReadiness(name string, err error) {
+	msg := ""
+	if err != nil {
+		msg = err.Error()
+	}
+	s.health.mu.Lock()
+	defer s.health.mu.Unlock()
+	if s.health.logged[name] == msg {
+		return
+	}
+	if s.health.logged == nil {
+		s.health.logged = map[string]string{}
+	}
+	s.health.logged[name] = msg
+	if err != nil {
+		log.Printf("readyz: %s check failed: %v", name, err)
+	} else {
+		log.Printf("readyz: %s check ok again", name)
+	}
+}
+
+func (s *Server) checkDatabase() error {
+	if err := s.authDB.Ping(); err != nil {
+		return err
+	}
+	var n int
+	return s.authDB.QueryRow(`SELECT COUNT(*) FROM users`).Scan(&n)
+}
+
+// checkFTS verifies every user's FTS index, at most once per interval.
+// The check reads every index, so other probes meanwhile get the last
+// result rather than waiting, unless there is none yet.
+func (s *Server) checkFTS() error {
+	s.health.mu.Lock()
+	defer s.health.mu.Unlock()
+
+	if time.Since(s.health.checkedAt) >= ftsCheckInterval && s.health.checking == nil {
+		done := make(chan struct{})
+		s.health.checking = done
+		s.health.mu.Unlock()
+		// Shared by every request until the next check, so not tied to this one
+		err := s.checkAllFTS(context.Background())
+		s.health.mu.Lock()
+		s.health.ftsErr, s.health.checkedAt, s.health.checking = err, time.Now(), nil
+		close(done)
+	}
+	for s.health.checkedAt.IsZero() && s.health.checking != nil {
+		checking := s.health.checking
+		s.health.mu.Unlock()
+		<-checking
+		s.health.mu.Lock()
+	}
+	return s.health.ftsErr
+}
+
+func (s *Server) checkAllFTS(ctx context.Context) error {
+	users, err := ListUsers(ctx, s.authDB)
+	if err != nil {
+		return err
+	}
+
+	var broken []string
+	for _, u := range users {
+		if _, err := os.Stat(UserDBPath(s.dataDir, u.Name)); err != nil {
+			continue // Nothing pushed yet
+		}
+		db, err := s.userDB(u.Name)
+		if err != nil {
+			return err
+		}
+		if err := CheckFTS(ctx, db); err != nil {
+			broken = append(broken, u.Name)
+		}
+	}
+
+	if len(broken) > 0 {
+		return fmt.Errorf("FTS index out of sync for %s (run: zist fts rebuild --db DATA-DIR/users/NAME.db)", strings.Join(broken, ", "))
+	}
+	return nil
+}
+
+func (s *Server) checkDisk() error {
+	free, ok := diskFree(filepath.Clean(expandTilde(s.dataDir)))
+	if !ok {
+		// Unknown on this platform
+		return nil
+	}
+	if s.limits.MinFreeDisk > 0 && free < s.limits.MinFreeDisk {
+		return fmt.Errorf("%d MiB free, need %d MiB", free>>20, s.limits.MinFreeDisk>>20)
+	}
+	return nil
+}
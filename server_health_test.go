@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestServerHealth(t *testing.T) {
+	server, err := NewServer(t.TempDir(), ServerLimits{})
+	if err != nil {
+		t.Fatalf("NewServer() error = %v", err)
+	}
+	defer server.Close()
+
+	if _, err := CreateUser(context.Background(), server.authDB, "alice", RoleMember); err != nil {
+		t.Fatalf("CreateUser() error = %v", err)
+	}
+	db, err := server.userDB("alice")
+	if err != nil {
+		t.Fatalf("userDB() error = %v", err)
+	}
+	if _, _, err := InsertCommands(context.Background(), db, []Command{{Source: "/h", Timestamp: 1000, Command: "ls"}}); err != nil {
+		t.Fatalf("InsertCommands() error = %v", err)
+	}
+
+	ts := httptest.NewServer(server.Handler())
+	defer ts.Close()
+
+	readyz := func() (int, map[string]ReadinessCheck) {
+		t.Helper()
+		resp, err := http.Get(ts.URL + "/readyz")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+
+		raw, err := io.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// No token is needed, so nothing about users may show
+		if strings.Contains(string(raw), "alice") || strings.Contains(string(raw), "users") {
+			t.Errorf("/readyz body %s tells about users", raw)
+		}
+		var body struct {
+			Checks map[string]ReadinessCheck `json:"checks"`
+		}
+		if err := json.Unmarshal(raw, &body); err != nil {
+			t.Fatal(err)
+		}
+		return resp.StatusCode, body.Checks
+	}
+
+	resp, err := http.Get(ts.URL + "/healthz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("/healthz status = %d, want 200", resp.StatusCode)
+	}
+
+	if status, checks := readyz(); status != http.StatusOK {
+		t.Errorf("/readyz status = %d, checks %+v, want 200", status, checks)
+	}
+
+	// Break alice's index; the cached result hides it until the interval passes
+	if _, err := db.Exec(`INSERT INTO commands_fts(commands_fts) VALUES('delete-all')`); err != nil {
+		t.Fatal(err)
+	}
+	if status, _ := readyz(); status != http.StatusOK {
+		t.Errorf("/readyz status = %d within the check interval, want cached 200", status)
+	}
+
+	server.health.mu.Lock()
+	server.health.checkedAt = time.Time{}
+	server.health.mu.Unlock()
+
+	status, checks := readyz()
+	if status != http.StatusServiceUnavailable || checks["fts"].OK {
+		t.Errorf("/readyz = %d, checks %+v, want 503 with failing fts", status, checks)
+	}
+	if !checks["database"].OK {
+		t.Errorf("/readyz database check = %+v, want ok", checks["database"])
+	}
+
+	// A probe while a check runs gets the last result instead of waiting
+	server.health.mu.Lock()
+	server.health.checkedAt = time.Now().Add(-2 * ftsCheckInterval)
+	server.health.checking = make(chan struct{})
+	server.health.mu.Unlock()
+	done := make(chan error, 1)
+	go func() { done <- server.checkFTS() }()
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Error("checkFTS() during a check = nil, want the last result, failing")
+		}
+	case <-time.After(5 * time.Second):
+		t.Error("checkFTS() waited for the check under way")
+	}
+}
+
+func TestServerHealthDisk(t *testing.T) {
+	server, err := NewServer(t.TempDir(), ServerLimits{MinFreeDisk: 1 << 62})
+	if err != nil {
+		t.Fatalf("NewServer() error = %v", err)
+	}
+	defer server.Close()
+
+	if _, ok := diskFree(server.dataDir); !ok {
+		t.Skip("free space not available on this platform")
+	}
+	if err := server.checkDisk(); err == nil {
+		t.Error("checkDisk() with impossible minimum = nil, want an error")
+	}
+}
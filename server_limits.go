@@ -0,0 +1,136 @@
+package main
+
+import (
+	"log"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// ServerLimits protects the server's databases from misbehaving clients
+type ServerLimits struct {
+	RequestsPerMinute int           // Per token, 0 disables rate limiting
+	Burst             int           // Requests allowed at once before the rate applies
+	MaxBodyBytes      int64         // Largest accepted request body
+	MaxPushCommands   int           // Most commands accepted in one push
+	SlowRequest       time.Duration // Log requests slower than this, 0 disables
+	MinFreeDisk       uint64        // /readyz fails below this many free bytes in the data dir
+}
+
+// DefaultServerLimits returns limits suited to a small team
+func DefaultServerLimits() ServerLimits {
+	return ServerLimits{
+		RequestsPerMinute: 120,
+		Burst:             30,
+		MaxBodyBytes:      8 << 20,
+		MaxPushCommands:   5000,
+		SlowRequest:       500 * time.Millisecond,
+		MinFreeDisk:       DefaultMinFreeDisk,
+	}
+}
+
+// rateLimiter is a token bucket per key
+type rateLimiter struct {
+	mu      sync.Mutex
+	rate    float64 // tokens per second
+	burst   float64
+	buckets map[string]*bucket
+	now     func() time.Time
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// maxBuckets bounds memory when many distinct clients fail to authenticate
+const maxBuckets = 10000
+
+func newRateLimiter(perMinute, burst int) *rateLimiter {
+	if burst < 1 {
+		burst = 1
+	}
+	return &rateLimiter{
+		rate:    float64(perMinute) / 60,
+		burst:   float64(burst),
+		buckets: make(map[string]*bucket),
+		now:     time.Now,
+	}
+}
+
+// Allow takes a token for key, or reports how long until one is available
+func (l *rateLimiter) Allow(key string) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	b, ok := l.buckets[key]
+	if !ok {
+		if len(l.buckets) >= maxBuckets {
+			l.prune(now)
+		}
+		b = &bucket{tokens: l.burst, last: now}
+		l.buckets[key] = b
+	}
+
+	b.tokens = math.Min(l.burst, b.tokens+now.Sub(b.last).Seconds()*l.rate)
+	b.last = now
+
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+	wait := time.Duration((1 - b.tokens) / l.rate * float64(time.Second))
+	return false, wait
+}
+
+// prune drops buckets that have refilled, since they behave like new ones
+func (l *rateLimiter) prune(now time.Time) {
+	for key, b := range l.buckets {
+		if b.tokens+now.Sub(b.last).Seconds()*l.rate >= l.burst {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+// rateLimited answers 429 with Retry-After if key is over its limit
+func (s *Server) rateLimited(w http.ResponseWriter, key string) bool {
+	if s.limiter == nil {
+		return false
+	}
+	ok, wait := s.limiter.Allow(key)
+	if ok {
+		return false
+	}
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+	writeError(w, http.StatusTooManyRequests, "rate limit exceeded")
+	return true
+}
+
+// limitRequests caps request bodies and logs slow requests
+func (s *Server) limitRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s.limits.MaxBodyBytes > 0 {
+			r.Body = http.MaxBytesReader(w, r.Body, s.limits.MaxBodyBytes)
+		}
+
+		start := time.Now()
+		next.ServeHTTP(w, r)
+
+		if elapsed := time.Since(start); s.limits.SlowRequest > 0 && elapsed > s.limits.SlowRequest {
+			log.Printf("slow request: %s %s from %s took %s", r.Method, r.URL.RequestURI(), clientIP(r), elapsed.Round(time.Millisecond))
+		}
+	})
+}
+
+// clientIP returns the connecting address without port
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRateLimiter(t *testing.T) {
+	now := time.Unix(1000, 0)
+	limiter := newRateLimiter(60, 2) // one per second, burst of two
+	limiter.now = func() time.Time { return now }
+
+	steps := []struct {
+		advance time.Duration
+		key     string
+		want    bool
+	}{
+		{0, "a", true},
+		{0, "a", true},
+		{0, "a", false},
+		{0, "b", true}, // keys are independent
+		{500 * time.Millisecond, "a", false},
+		{500 * time.Millisecond, "a", true},
+		{10 * time.Second, "a", true}, // refills up to the burst only
+		{0, "a", true},
+		{0, "a", false},
+	}
+
+	for i, step := range steps {
+		now = now.Add(step.advance)
+		got, wait := limiter.Allow(step.key)
+		if got != step.want {
+			t.Errorf("step %d: Allow(%q) = %v, want %v", i, step.key, got, step.want)
+		}
+		if !got && wait <= 0 {
+			t.Errorf("step %d: Allow(%q) wait = %v, want > 0", i, step.key, wait)
+		}
+	}
+}
+
+func TestServerLimits(t *testing.T) {
+	ts, tokens := newTestServerWithLimits(t, ServerLimits{
+		RequestsPerMinute: 60,
+		Burst:             3,
+		MaxBodyBytes:      1024,
+		MaxPushCommands:   2,
+	})
+
+	t.Run("body too large", func(t *testing.T) {
+		body := `[{"source": "/h", "timestamp": 1, "command": "` + strings.Repeat("x", 2048) + `"}]`
+		status, _ := apiRequest(t, "POST", ts.URL+"/api/v1/commands", tokens["alice"], body)
+		if status != http.StatusRequestEntityTooLarge {
+			t.Errorf("status = %d, want 413", status)
+		}
+	})
+
+	t.Run("too many commands", func(t *testing.T) {
+		var cmds []string
+		for i := 0; i < 3; i++ {
+			cmds = append(cmds, fmt.Sprintf(`{"source": "/h", "timestamp": %d, "command": "ls"}`, i))
+		}
+		status, _ := apiRequest(t, "POST", ts.URL+"/api/v1/commands", tokens["alice"], "["+strings.Join(cmds, ",")+"]")
+		if status != http.StatusRequestEntityTooLarge {
+			t.Errorf("status = %d, want 413", status)
+		}
+	})
+
+	t.Run("rate limited per token", func(t *testing.T) {
+		// alice has used her burst of 3 above
+		status, _ := apiRequest(t, "GET", ts.URL+"/api/v1/search", tokens["alice"], "")
+		if status != http.StatusOK {
+			t.Fatalf("third request status = %d, want 200", status)
+		}
+		status, _ = apiRequest(t, "GET", ts.URL+"/api/v1/search", tokens["alice"], "")
+		if status != http.StatusTooManyRequests {
+			t.Errorf("fourth request status = %d, want 429", status)
+		}
+		status, _ = apiRequest(t, "GET", ts.URL+"/api/v1/search", tokens["bob"], "")
+		if status != http.StatusOK {
+			t.Errorf("bob status = %d, want 200 (separate bucket)", status)
+		}
+	})
+
+	t.Run("failed auth rate limited", func(t *testing.T) {
+		var last int
+		for i := 0; i < 4; i++ {
+			last, _ = apiRequest(t, "GET", ts.URL+"/api/v1/search", "zist_guess", "")
+		}
+		if last != http.StatusTooManyRequests {
+			t.Errorf("status after repeated bad tokens = %d, want 429", last)
+		}
+	})
+}
@@ -0,0 +1,395 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestServer(t *testing.T) (*httptest.Server, map[string]string) {
+	t.Helper()
+	return newTestServerWithLimits(t, ServerLimits{})
+}
+
+func newTestServerWithLimits(t *testing.T, limits ServerLimits) (*httptest.Server, map[string]string) {
+	t.Helper()
+
+	server, err := NewServer(t.TempDir(), limits)
+	if err != nil {
+		t.Fatalf("NewServer() error = %v", err)
+	}
+	t.Cleanup(func() { server.Close() })
+
+	tokens := map[string]string{}
+	for name, role := range map[string]string{"alice": RoleAdmin, "bob": RoleMember} {
+		token, err := CreateUser(context.Background(), server.authDB, name, role)
+		if err != nil {
+			t.Fatalf("CreateUser() error = %v", err)
+		}
+		tokens[name] = token
+	}
+
+	ts := httptest.NewServer(server.Handler())
+	t.Cleanup(ts.Close)
+	return ts, tokens
+}
+
+func apiRequest(t *testing.T, method, url, token, body string) (int, string) {
+	t.Helper()
+
+	req, err := http.NewRequest(method, url, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp.StatusCode, string(respBody)
+}
+
+func searchUsers(t *testing.T, body string) []string {
+	t.Helper()
+
+	var results []APICommand
+	if err := json.Unmarshal([]byte(body), &results); err != nil {
+		t.Fatalf("failed to decode search results %q: %v", body, err)
+	}
+	var users []string
+	for _, r := range results {
+		users = append(users, r.User)
+	}
+	return users
+}
+
+func TestServerNamespaces(t *testing.T) {
+	ts, tokens := newTestServer(t)
+
+	push := `[{"source": "laptop:/h", "timestamp": 1000, "command": "kubectl get pods"}]`
+	if status, body := apiRequest(t, "POST", ts.URL+"/api/v1/commands", tokens["alice"], push); status != http.StatusOK {
+		t.Fatalf("push status = %d, body %s", status, body)
+	}
+
+	_, body := apiRequest(t, "GET", ts.URL+"/api/v1/search?q=kubectl", tokens["alice"], "")
+	if users := searchUsers(t, body); len(users) != 1 || users[0] != "alice" {
+		t.Errorf("alice search users = %v, want [alice]", users)
+	}
+
+	_, body = apiRequest(t, "GET", ts.URL+"/api/v1/search?q=kubectl&shared=true", tokens["bob"], "")
+	if users := searchUsers(t, body); len(users) != 0 {
+		t.Errorf("bob search before share = %v, want none", users)
+	}
+
+	if status, body := apiRequest(t, "POST", ts.URL+"/api/v1/shares", tokens["alice"], `{"user": "bob"}`); status != http.StatusNoContent {
+		t.Fatalf("share status = %d, body %s", status, body)
+	}
+
+	_, body = apiRequest(t, "GET", ts.URL+"/api/v1/search?q=kubectl", tokens["bob"], "")
+	if users := searchUsers(t, body); len(users) != 0 {
+		t.Errorf("bob search without shared=true = %v, want none", users)
+	}
+	_, body = apiRequest(t, "GET", ts.URL+"/api/v1/search?q=kubectl&shared=true", tokens["bob"], "")
+	if users := searchUsers(t, body); len(users) != 1 || users[0] != "alice" {
+		t.Errorf("bob search after share = %v, want [alice]", users)
+	}
+}
+
+func TestServerAuth(t *testing.T) {
+	ts, tokens := newTestServer(t)
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		token  string
+		body   string
+		want   int
+	}{
+		{"no token", "GET", "/api/v1/search", "", "", http.StatusUnauthorized},
+		{"bad token", "GET", "/api/v1/search", "zist_nope", "", http.StatusUnauthorized},
+		{"member search", "GET", "/api/v1/search", tokens["bob"], "", http.StatusOK},
+		{"member lists users", "GET", "/api/v1/users", tokens["bob"], "", http.StatusForbidden},
+		{"member creates user", "POST", "/api/v1/users", tokens["bob"], `{"name": "eve"}`, http.StatusForbidden},
+		{"admin creates user", "POST", "/api/v1/users", tokens["alice"], `{"name": "carol"}`, http.StatusCreated},
+		{"admin lists users", "GET", "/api/v1/users", tokens["alice"], "", http.StatusOK},
+		{"admin deletes unknown", "DELETE", "/api/v1/users/zed", tokens["alice"], "", http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			status, body := apiRequest(t, tt.method, ts.URL+tt.path, tt.token, tt.body)
+			if status != tt.want {
+				t.Errorf("%s %s status = %d, want %d (body %s)", tt.method, tt.path, status, tt.want, body)
+			}
+		})
+	}
+}
+
+func TestServerDeleteUserHistory(t *testing.T) {
+	ts, tokens := newTestServer(t)
+
+	push := `[{"source": "laptop:zsh", "timestamp": 100, "command": "git status"}]`
+	if status, body := apiRequest(t, "POST", ts.URL+"/api/v1/commands", tokens["bob"], push); status != http.StatusOK {
+		t.Fatalf("push status = %d: %s", status, body)
+	}
+	if status, body := apiRequest(t, "DELETE", ts.URL+"/api/v1/users/bob", tokens["alice"], ""); status != http.StatusNoContent {
+		t.Fatalf("delete status = %d: %s", status, body)
+	}
+
+	// A new bob doesn't get the old one's history
+	status, body := apiRequest(t, "POST", ts.URL+"/api/v1/users", tokens["alice"], `{"name": "bob"}`)
+	if status != http.StatusCreated {
+		t.Fatalf("create status = %d: %s", status, body)
+	}
+	var created map[string]string
+	if err := json.Unmarshal([]byte(body), &created); err != nil {
+		t.Fatal(err)
+	}
+	if _, body := apiRequest(t, "GET", ts.URL+"/api/v1/search", created["token"], ""); strings.TrimSpace(body) != "[]" {
+		t.Errorf("search as the new bob = %s, want nothing", body)
+	}
+}
+
+func TestServerWizardCachePerUser(t *testing.T) {
+	ts, tokens := newTestServer(t)
+
+	status, _ := apiRequest(t, "PUT", ts.URL+"/api/v1/wizard-cache", tokens["alice"], `{"query": "list pods", "command": "kubectl get pods"}`)
+	if status != http.StatusNoContent {
+		t.Fatalf("set wizard cache status = %d", status)
+	}
+
+	if status, _ := apiRequest(t, "GET", ts.URL+"/api/v1/wizard-cache?q=list+pods", tokens["alice"], ""); status != http.StatusOK {
+		t.Errorf("alice cache lookup status = %d, want 200", status)
+	}
+	if status, _ := apiRequest(t, "GET", ts.URL+"/api/v1/wizard-cache?q=list+pods", tokens["bob"], ""); status != http.StatusNotFound {
+		t.Errorf("bob cache lookup status = %d, want 404", status)
+	}
+}
+
+func TestServerStats(t *testing.T) {
+	ts, tokens := newTestServer(t)
+
+	now := float64(time.Now().Unix())
+	push := fmt.Sprintf(`[
+		{"source": "laptop:zsh", "timestamp": %f, "command": "git status"},
+		{"source": "laptop:zsh", "timestamp": %f, "command": "git status"},
+		{"source": "server:zsh", "timestamp": %f, "command": "uptime"},
+		{"source": "server:zsh", "timestamp": 1000, "command": "uptime"}
+	]`, now, now+1, now+2)
+	if status, body := apiRequest(t, "POST", ts.URL+"/api/v1/commands", tokens["alice"], push); status != http.StatusOK {
+		t.Fatalf("push status = %d: %s", status, body)
+	}
+
+	status, body := apiRequest(t, "GET", ts.URL+"/api/v1/stats?top=1", tokens["alice"], "")
+	if status != http.StatusOK {
+		t.Fatalf("stats status = %d: %s", status, body)
+	}
+	var stats APIStats
+	if err := json.Unmarshal([]byte(body), &stats); err != nil {
+		t.Fatal(err)
+	}
+	if stats.TotalCommands != 4 || len(stats.Sources) != 2 {
+		t.Errorf("stats = %+v, want 4 commands from 2 sources", stats)
+	}
+	if len(stats.TopCommands) != 1 || stats.TopCommands[0].Count != 2 {
+		t.Errorf("top commands = %+v, want one entry run twice", stats.TopCommands)
+	}
+	var recent int64
+	for _, d := range stats.Daily {
+		recent += d.Count
+	}
+	if recent != 3 {
+		t.Errorf("daily counts sum to %d, want 3 (the 1970 command is out of range)", recent)
+	}
+
+	// Stats only cover the caller's own history
+	_, body = apiRequest(t, "GET", ts.URL+"/api/v1/stats", tokens["bob"], "")
+	if !strings.Contains(body, `"total_commands":0`) {
+		t.Errorf("bob stats = %s, want empty", body)
+	}
+
+	if status, _ := apiRequest(t, "GET", ts.URL+"/api/v1/stats?days=0", tokens["alice"], ""); status != http.StatusBadRequest {
+		t.Errorf("days=0 status = %d, want 400", status)
+	}
+}
+
+func TestServerClampsLimits(t *testing.T) {
+	ts, tokens := newTestServer(t)
+	defer func(search, top int) { maxSearchLimit, maxStatsTop = search, top }(maxSearchLimit, maxStatsTop)
+	maxSearchLimit, maxStatsTop = 2, 1
+
+	push := `[
+		{"source": "laptop:zsh", "timestamp": 100, "command": "git status"},
+		{"source": "laptop:zsh", "timestamp": 200, "command": "git push"},
+		{"source": "laptop:zsh", "timestamp": 300, "command": "uptime"}
+	]`
+	if status, body := apiRequest(t, "POST", ts.URL+"/api/v1/commands", tokens["alice"], push); status != http.StatusOK {
+		t.Fatalf("push status = %d: %s", status, body)
+	}
+
+	_, body := apiRequest(t, "GET", ts.URL+"/api/v1/search?limit=1000000", tokens["alice"], "")
+	var results []APICommand
+	if err := json.Unmarshal([]byte(body), &results); err != nil || len(results) != 2 {
+		t.Errorf("search with a huge limit = %s, want the 2 newest", body)
+	}
+
+	_, body = apiRequest(t, "GET", ts.URL+"/api/v1/stats?top=1000000", tokens["alice"], "")
+	var stats APIStats
+	if err := json.Unmarshal([]byte(body), &stats); err != nil || len(stats.TopCommands) != 1 {
+		t.Errorf("stats with a huge top = %s, want 1 top command", body)
+	}
+}
+
+func TestSyncPush(t *testing.T) {
+	ts, tokens := newTestServer(t)
+
+	db, err := InitDB(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("InitDB() error = %v", err)
+	}
+	defer db.Close()
+
+	commands := make([]Command, 0, syncBatchSize+5)
+	for i := 0; i < syncBatchSize+5; i++ {
+		commands = append(commands, Command{Source: "/h", Timestamp: float64(1000 + i), Command: "echo"})
+	}
+	if _, _, err := InsertCommands(context.Background(), db, commands); err != nil {
+		t.Fatalf("InsertCommands() error = %v", err)
+	}
+
+	client := &SyncClient{Server: ts.URL, Token: tokens["bob"]}
+	pushed, _, err := client.Push(context.Background(), db, "laptop")
+	if err != nil || pushed != len(commands) {
+		t.Fatalf("Push() = %d, %v, want %d", pushed, err, len(commands))
+	}
+
+	pushed, _, err = client.Push(context.Background(), db, "laptop")
+	if err != nil || pushed != 0 {
+		t.Errorf("second Push() = %d, %v, want 0", pushed, err)
+	}
+
+	_, body := apiRequest(t, "GET", ts.URL+"/api/v1/search?limit=1", tokens["bob"], "")
+	var results []APICommand
+	if err := json.Unmarshal([]byte(body), &results); err != nil || len(results) != 1 || results[0].Source != "laptop:/h" {
+		t.Errorf("search after push = %s, want source laptop:/h", body)
+	}
+
+	client.Token = "zist_nope"
+	if _, _, err := InsertCommands(context.Background(), db, []Command{{Source: "/h", Timestamp: 5000, Command: "ls"}}); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := client.Push(context.Background(), db, "laptop"); err == nil {
+		t.Error("Push() with bad token error = nil, want error")
+	}
+}
+
+func TestSyncPushTooLarge(t *testing.T) {
+	ts, tokens := newTestServerWithLimits(t, ServerLimits{MaxBodyBytes: 4096})
+	defer func(limit int) { maxCommandLength = limit }(maxCommandLength)
+	maxCommandLength = 1000
+
+	db, err := InitDB(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("InitDB() error = %v", err)
+	}
+	defer db.Close()
+
+	// Small enough for one batch by length, too large for the server
+	var commands []Command
+	for i := 0; i < 10; i++ {
+		commands = append(commands, Command{Source: "/h", Timestamp: float64(1000 + i), Command: "echo " + strings.Repeat("x", 900)})
+	}
+	// Too large on its own, whole, but not as stored
+	commands = append(commands, Command{Source: "/h", Timestamp: 2000, Command: "echo " + strings.Repeat("y", 6000)})
+	if _, _, err := InsertCommands(context.Background(), db, commands); err != nil {
+		t.Fatalf("InsertCommands() error = %v", err)
+	}
+
+	client := &SyncClient{Server: ts.URL, Token: tokens["bob"]}
+	pushed, _, err := client.Push(context.Background(), db, "laptop")
+	if err != nil || pushed != len(commands) {
+		t.Fatalf("Push() = %d, %v, want %d", pushed, err, len(commands))
+	}
+	pushed, _, err = client.Push(context.Background(), db, "laptop")
+	if err != nil || pushed != 0 {
+		t.Errorf("second Push() = %d, %v, want 0", pushed, err)
+	}
+
+	_, body := apiRequest(t, "GET", ts.URL+"/api/v1/search?q=echo&limit=20", tokens["bob"], "")
+	if users := searchUsers(t, body); len(users) != len(commands) {
+		t.Errorf("search after push found %d commands, want %d", len(users), len(commands))
+	}
+}
+
+func TestSyncDiff(t *testing.T) {
+	ts, tokens := newTestServer(t)
+
+	db, err := InitDB(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("InitDB() error = %v", err)
+	}
+	defer db.Close()
+
+	// Pushed earlier by hand, e.g. from a copy of the history file
+	status, body := apiRequest(t, "POST", ts.URL+"/api/v1/commands", tokens["bob"],
+		`[{"source": "laptop:/h", "timestamp": 1000, "command": "ls"}, {"source": "laptop:/h", "timestamp": 1001, "command": "pwd"}]`)
+	if status != http.StatusOK {
+		t.Fatalf("push = %d %s", status, body)
+	}
+
+	if _, _, err := InsertCommands(context.Background(), db, []Command{
+		{Source: "/h", Timestamp: 1000, Command: "ls"},
+		{Source: "/h", Timestamp: 1001, Command: "whoami"},
+		{Source: "/h", Timestamp: 1002, Command: "date"},
+	}); err != nil {
+		t.Fatalf("InsertCommands() error = %v", err)
+	}
+
+	client := &SyncClient{Server: ts.URL, Token: tokens["bob"]}
+	diff, err := client.Diff(context.Background(), db, "laptop")
+	day := time.Unix(1000, 0).Format("2006-01-02")
+	want := []DiffBucket{{Source: "laptop:/h", Day: day, New: 1, Duplicate: 1, Conflicting: 1}}
+	if err != nil || fmt.Sprint(diff) != fmt.Sprint(want) {
+		t.Fatalf("Diff() = %v, %v, want %v", diff, err, want)
+	}
+
+	// Nothing was pushed
+	if cursor, err := GetSyncCursor(context.Background(), db, ts.URL); err != nil || cursor != 0 {
+		t.Errorf("sync cursor = %d, %v, want 0", cursor, err)
+	}
+	_, body = apiRequest(t, "GET", ts.URL+"/api/v1/search?q=date", tokens["bob"], "")
+	if users := searchUsers(t, body); len(users) != 0 {
+		t.Errorf("search after diff = %s, want nothing pushed", body)
+	}
+}
+
+func TestServerClockSkew(t *testing.T) {
+	ts, tokens := newTestServer(t)
+
+	future := time.Now().Add(2 * time.Hour).Unix()
+	status, body := apiRequest(t, "POST", ts.URL+"/api/v1/commands", tokens["bob"],
+		fmt.Sprintf(`[{"source": "laptop:/h", "timestamp": %d, "command": "ls"}, {"source": "server:/h", "timestamp": 1000, "command": "ls"}]`, future))
+	var result PushResult
+	if err := json.Unmarshal([]byte(body), &result); err != nil || status != http.StatusOK {
+		t.Fatalf("push = %d %s", status, body)
+	}
+	if len(result.ClockSkew) != 1 || result.ClockSkew[0].Source != "laptop:/h" || result.ClockSkew[0].AheadSeconds < 3600 {
+		t.Errorf("clock_skew = %v, want laptop:/h about 2h ahead", result.ClockSkew)
+	}
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// What collect does when the database outgrows --max-size
+const (
+	MaxSizeArchive = "archive" // Move the oldest commands into the archive database
+	MaxSizeWarn    = "warn"    // Only warn, in collect and zist status
+)
+
+// maxSizeTarget is the share of --max-size archiving brings the database
+// down to, so it doesn't archive again at the next collect
+const maxSizeTarget = 0.9
+
+// maxSizeRounds bounds how many times archiving re-measures and moves more,
+// since it can only guess the bytes a command takes up front
+const maxSizeRounds = 4
+
+// sizeUnits are the suffixes parseSize accepts, binary like formatSize
+var sizeUnits = []struct {
+	suffix string
+	bytes  int64
+}{
+	{"TB", 1 << 40}, {"GB", 1 << 30}, {"MB", 1 << 20}, {"KB", 1 << 10},
+	{"T", 1 << 40}, {"G", 1 << 30}, {"M", 1 << 20}, {"K", 1 << 10},
+	{"B", 1},
+}
+
+// parseSize parses a size such as 500MB, 1.5G or 4096, where 1 KB is 1024
+// bytes. Empty and 0 mean no limit.
+func parseSize(s string) (int64, error) {
+	value := strings.ToUpper(strings.TrimSpace(s))
+	if value == "" {
+		return 0, nil
+	}
+	value = strings.Replace(value, "IB", "B", 1)
+	multiplier := int64(1)
+	for _, unit := range sizeUnits {
+		if strings.HasSuffix(value, unit.suffix) {
+			value, multiplier = strings.TrimSpace(strings.TrimSuffix(value, unit.suffix)), unit.bytes
+			break
+		}
+	}
+	n, err := strconv.ParseFloat(value, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size %q (use e.g. 500MB or 2GB)", s)
+	}
+	return int64(n * float64(multiplier)), nil
+}
+
+// DBUsedBytes returns the bytes the pages of db's main database hold. Free
+// pages are left out: new rows reuse them before the file grows, so this is
+// what --max-size caps even though the file only shrinks on VACUUM.
+func DBUsedBytes(ctx context.Context, db *sql.DB) (int64, error) {
+	var pages, free, pageSize int64
+	if err := db.QueryRowContext(ctx, `SELECT * FROM pragma_page_count, pragma_freelist_count, pragma_page_size`).Scan(&pages, &free, &pageSize); err != nil {
+		return 0, fmt.Errorf("failed to measure database: %w", err)
+	}
+	return (pages - free) * pageSize, nil
+}
+
+// ArchiveOldest moves the oldest commands of db into the archive database
+// until db's pages hold no more than target bytes, returning how many it
+// moved
+func ArchiveOldest(ctx context.Context, db *sql.DB, archivePath string, target int64) (int64, error) {
+	used, err := DBUsedBytes(ctx, db)
+	if err != nil || used <= target {
+		return 0, err
+	}
+	var count int64
+	if err := db.QueryRowContext(ctx, `SELECT COUNT(*) FROM commands`).Scan(&count); err != nil {
+		return 0, fmt.Errorf("failed to count commands: %w", err)
+	}
+	if count == 0 {
+		return 0, nil
+	}
+	// At first everything in the file is put down to commands, so the first
+	// round moves too few rather than too many. Later rounds go by what the
+	// round before freed.
+	perCommand := float64(used) / float64(count)
+
+	var moved int64
+	for range maxSizeRounds {
+		n := int64(math.Ceil(float64(used-target) / perCommand))
+		cutoff := math.Inf(1)
+		err = db.QueryRowContext(ctx, `SELECT timestamp FROM commands ORDER BY timestamp LIMIT 1 OFFSET ?`, n).Scan(&cutoff)
+		if err != nil && err != sql.ErrNoRows {
+			return moved, fmt.Errorf("failed to find the oldest commands: %w", err)
+		}
+		n, err = ArchiveCommands(ctx, db, archivePath, cutoff)
+		moved += n
+		if err != nil || n == 0 {
+			return moved, err
+		}
+
+		now, err := DBUsedBytes(ctx, db)
+		if err != nil || now <= target {
+			return moved, err
+		}
+		if now >= used {
+			// Nothing to gain from moving more
+			return moved, nil
+		}
+		perCommand = float64(used-now) / float64(n)
+		used = now
+	}
+	return moved, nil
+}
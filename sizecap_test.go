@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParseSize(t *testing.T) {
+	tests := []struct {
+		in   string
+		want int64
+	}{
+		{"", 0},
+		{"0", 0},
+		{"4096", 4096},
+		{"512B", 512},
+		{"1K", 1024},
+		{"500MB", 500 << 20},
+		{"1.5 GiB", 3 << 29},
+		{"2gb", 2 << 30},
+		{"1T", 1 << 40},
+	}
+	for _, tt := range tests {
+		if got, err := parseSize(tt.in); err != nil || got != tt.want {
+			t.Errorf("parseSize(%q) = %d, %v, want %d", tt.in, got, err, tt.want)
+		}
+	}
+	for _, in := range []string{"big", "-1GB", "GB", "1PB"} {
+		if _, err := parseSize(in); err == nil {
+			t.Errorf("parseSize(%q) succeeded", in)
+		}
+	}
+}
+
+func TestArchiveOldest(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	db, err := InitDB(filepath.Join(dir, "test.db"))
+	if err != nil {
+		t.Fatalf("InitDB() error = %v", err)
+	}
+	defer db.Close()
+
+	var commands []Command
+	for i := range 2000 {
+		commands = append(commands, Command{Source: "/h", Timestamp: float64(1000 + i), Command: fmt.Sprintf("echo %d %s", i, strings.Repeat("x", 200))})
+	}
+	if _, _, err := InsertCommandsBatch(ctx, db, commands, 500); err != nil {
+		t.Fatal(err)
+	}
+	used, err := DBUsedBytes(ctx, db)
+	if err != nil || used == 0 {
+		t.Fatalf("DBUsedBytes() = %d, %v", used, err)
+	}
+
+	target := used / 2
+	moved, err := ArchiveOldest(ctx, db, filepath.Join(dir, "archive.db"), target)
+	if err != nil {
+		t.Fatalf("ArchiveOldest() error = %v", err)
+	}
+	if after, _ := DBUsedBytes(ctx, db); after > target {
+		t.Errorf("ArchiveOldest() left %d bytes in use, want at most %d", after, target)
+	}
+	var left int64
+	var oldest float64
+	if err := db.QueryRowContext(ctx, `SELECT COUNT(*), MIN(timestamp) FROM commands`).Scan(&left, &oldest); err != nil {
+		t.Fatal(err)
+	}
+	if moved == 0 || left+moved != 2000 || oldest != float64(1000+moved) {
+		t.Errorf("ArchiveOldest() moved %d, left %d from %v, want the oldest moved", moved, left, oldest)
+	}
+
+	// Under the target it moves nothing
+	if moved, err := ArchiveOldest(ctx, db, filepath.Join(dir, "archive.db"), used); err != nil || moved != 0 {
+		t.Errorf("ArchiveOldest() under the target = %d, %v", moved, err)
+	}
+}
+
+func TestCollectMaxSize(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	history, dbPath, archivePath := filepath.Join(dir, "zsh_history"), filepath.Join(dir, "test.db"), filepath.Join(dir, "archive.db")
+	var data strings.Builder
+	for i := range 5000 {
+		fmt.Fprintf(&data, ": %d:0;echo %d %s\n", 1704384000+i, i%20, strings.Repeat("x", 500))
+	}
+	if err := os.WriteFile(history, []byte(data.String()), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	opts := CollectOptions{Quiet: true, MaxSize: 1, MaxSizeAction: MaxSizeWarn, ArchivePath: archivePath}
+	if err := runCollect(ctx, dbPath, []string{history}, opts); err != nil {
+		t.Fatalf("runCollect() error = %v", err)
+	}
+	if _, err := os.Stat(archivePath); !os.IsNotExist(err) {
+		t.Error("runCollect() with --max-size-action warn archived")
+	}
+
+	db, err := InitDB(dbPath)
+	if err != nil {
+		t.Fatalf("InitDB() error = %v", err)
+	}
+	defer db.Close()
+	used, err := DBUsedBytes(ctx, db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts.MaxSize, opts.MaxSizeAction = used*3/4, MaxSizeArchive
+	if err := runCollect(ctx, dbPath, []string{history}, opts); err != nil {
+		t.Fatalf("runCollect() error = %v", err)
+	}
+	if after, _ := DBUsedBytes(ctx, db); after > opts.MaxSize {
+		t.Errorf("runCollect() left %d bytes in use, over max-size %d", after, opts.MaxSize)
+	}
+	archive, err := InitDB(archivePath)
+	if err != nil {
+		t.Fatalf("InitDB() error = %v", err)
+	}
+	defer archive.Close()
+	var archived int
+	if err := archive.QueryRowContext(ctx, `SELECT COUNT(*) FROM commands`).Scan(&archived); err != nil || archived == 0 || archived == 5000 {
+		t.Errorf("archive has %d command(s), %v, want the oldest", archived, err)
+	}
+}
@@ -0,0 +1,262 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	// SnippetLocal marks snippets added on this machine
+	SnippetLocal = "local"
+	// SnippetTeam marks snippets synced from the team library
+	SnippetTeam = "team"
+
+	// TeamSnippetsFile is the library file at the root of a team snippets repo
+	TeamSnippetsFile = "snippets.json"
+)
+
+// Snippet is a named, blessed command
+type Snippet struct {
+	Name        string  `json:"name"`
+	Command     string  `json:"command"`
+	Description string  `json:"description,omitempty"`
+	Origin      string  `json:"-"`
+	UpdatedAt   float64 `json:"-"`
+}
+
+// Badge returns the tag shown next to the snippet in search
+func (s Snippet) Badge() string {
+	if s.Origin == SnippetTeam {
+		return "[team]"
+	}
+	return "[snippet]"
+}
+
+// SearchResult converts the snippet into a picker row. Snippets have no ID
+// or timestamp; the source names the library it came from.
+func (s Snippet) SearchResult() SearchResult {
+	return SearchResult{
+		Command: s.Command,
+		Source:  "snippet:" + s.Origin + "/" + s.Name,
+		Note:    s.Description,
+		Badge:   s.Badge(),
+	}
+}
+
+// AddSnippet stores or replaces a snippet
+func AddSnippet(ctx context.Context, db *sql.DB, s Snippet) error {
+	if s.Origin == "" {
+		s.Origin = SnippetLocal
+	}
+
+	_, err := db.ExecContext(ctx, `INSERT INTO snippets (origin, name, command, description, updated_at)
+		VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT(origin, name) DO UPDATE SET
+			command = excluded.command,
+			description = excluded.description,
+			updated_at = excluded.updated_at`,
+		s.Origin, s.Name, s.Command, s.Description, float64(time.Now().Unix()))
+	if err != nil {
+		return fmt.Errorf("failed to add snippet: %w", err)
+	}
+
+	return nil
+}
+
+// GetSnippet looks up a snippet by origin and name, returning nil if missing
+func GetSnippet(ctx context.Context, db *sql.DB, origin, name string) (*Snippet, error) {
+	row := db.QueryRowContext(ctx, `SELECT origin, name, command, description, updated_at FROM snippets
+		WHERE origin = ? AND name = ?`, origin, name)
+
+	var s Snippet
+	err := row.Scan(&s.Origin, &s.Name, &s.Command, &s.Description, &s.UpdatedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get snippet: %w", err)
+	}
+	return &s, nil
+}
+
+// DeleteSnippet removes a local snippet, reporting whether it existed
+func DeleteSnippet(ctx context.Context, db *sql.DB, name string) (bool, error) {
+	result, err := db.ExecContext(ctx, `DELETE FROM snippets WHERE origin = ? AND name = ?`, SnippetLocal, name)
+	if err != nil {
+		return false, fmt.Errorf("failed to delete snippet: %w", err)
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to get rows affected: %w", err)
+	}
+	return n > 0, nil
+}
+
+// SearchSnippets returns snippets whose name, command or description contains
+// every word of the query. An empty query returns all snippets.
+func SearchSnippets(ctx context.Context, db *sql.DB, query string) ([]Snippet, error) {
+	var sb strings.Builder
+	var args []interface{}
+
+	sb.WriteString("SELECT origin, name, command, description, updated_at FROM snippets WHERE 1=1")
+	for _, word := range strings.Fields(query) {
+		sb.WriteString(" AND instr(lower(name || ' ' || command || ' ' || description), ?) > 0")
+		args = append(args, strings.ToLower(word))
+	}
+	sb.WriteString(" ORDER BY origin = 'team' DESC, name")
+
+	rows, err := db.QueryContext(ctx, sb.String(), args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to search snippets: %w", err)
+	}
+	defer rows.Close()
+
+	var snippets []Snippet
+	for rows.Next() {
+		var s Snippet
+		if err := rows.Scan(&s.Origin, &s.Name, &s.Command, &s.Description, &s.UpdatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan snippet: %w", err)
+		}
+		snippets = append(snippets, s)
+	}
+
+	return snippets, rows.Err()
+}
+
+// ReplaceTeamSnippets swaps the synced team library for a new copy
+func ReplaceTeamSnippets(ctx context.Context, db *sql.DB, snippets []Snippet) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM snippets WHERE origin = ?`, SnippetTeam); err != nil {
+		return fmt.Errorf("failed to clear team snippets: %w", err)
+	}
+
+	now := float64(time.Now().Unix())
+	for _, s := range snippets {
+		if _, err := tx.ExecContext(ctx, `INSERT OR REPLACE INTO snippets (origin, name, command, description, updated_at)
+			VALUES (?, ?, ?, ?, ?)`, SnippetTeam, s.Name, s.Command, s.Description, now); err != nil {
+			return fmt.Errorf("failed to insert team snippet: %w", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit: %w", err)
+	}
+	return nil
+}
+
+// LoadSnippetFile reads a team library file. A missing file is an empty library.
+func LoadSnippetFile(path string) ([]Snippet, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read snippets: %w", err)
+	}
+
+	var snippets []Snippet
+	if err := json.Unmarshal(data, &snippets); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+
+	for _, s := range snippets {
+		if s.Name == "" || s.Command == "" {
+			return nil, fmt.Errorf("invalid snippet in %s: name and command are required", path)
+		}
+	}
+	return snippets, nil
+}
+
+// WriteSnippetFile writes a team library file sorted by name, so diffs in
+// the shared repo stay small
+func WriteSnippetFile(path string, snippets []Snippet) error {
+	sort.Slice(snippets, func(i, j int) bool { return snippets[i].Name < snippets[j].Name })
+
+	data, err := json.MarshalIndent(snippets, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode snippets: %w", err)
+	}
+
+	if err := os.WriteFile(path, append(data, '\n'), 0644); err != nil {
+		return fmt.Errorf("failed to write snippets: %w", err)
+	}
+	return nil
+}
+
+// SyncTeamRepo clones the team snippets repo into dir, or pulls it if it
+// is already checked out
+func SyncTeamRepo(ctx context.Context, repo, dir string) error {
+	if _, err := os.Stat(filepath.Join(dir, ".git")); err == nil {
+		return runGit(ctx, dir, "pull", "--ff-only", "--quiet")
+	}
+
+	if repo == "" {
+		return fmt.Errorf("team snippets repo is not set (use --repo or ZIST_TEAM_SNIPPETS_REPO)")
+	}
+	if err := os.MkdirAll(filepath.Dir(dir), 0755); err != nil {
+		return fmt.Errorf("failed to create directory: %w", err)
+	}
+	return runGit(ctx, "", "clone", "--quiet", repo, dir)
+}
+
+// PublishTeamSnippet adds or updates a snippet in the team repo and pushes it
+func PublishTeamSnippet(ctx context.Context, dir string, s Snippet) error {
+	path := filepath.Join(dir, TeamSnippetsFile)
+
+	snippets, err := LoadSnippetFile(path)
+	if err != nil {
+		return err
+	}
+
+	replaced := false
+	for i := range snippets {
+		if snippets[i].Name == s.Name {
+			snippets[i] = s
+			replaced = true
+		}
+	}
+	if !replaced {
+		snippets = append(snippets, s)
+	}
+
+	if err := WriteSnippetFile(path, snippets); err != nil {
+		return err
+	}
+
+	if err := runGit(ctx, dir, "add", TeamSnippetsFile); err != nil {
+		return err
+	}
+	// Publishing an unchanged snippet leaves nothing to commit
+	if err := runGit(ctx, dir, "diff", "--cached", "--quiet"); err == nil {
+		return nil
+	}
+	if err := runGit(ctx, dir, "commit", "--quiet", "-m", "Publish snippet "+s.Name); err != nil {
+		return err
+	}
+	return runGit(ctx, dir, "push", "--quiet")
+}
+
+func runGit(ctx context.Context, dir string, args ...string) error {
+	cmd := exec.CommandContext(ctx, "git", args...)
+	cmd.Dir = dir
+	if args[0] != "diff" {
+		cmd.Stderr = os.Stderr
+	}
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("git %s failed: %w", args[0], err)
+	}
+	return nil
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSnippets(t *testing.T) {
+	db, err := InitDB(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("InitDB() error = %v", err)
+	}
+	defer db.Close()
+
+	if err := AddSnippet(context.Background(), db, Snippet{Name: "rebuild", Command: "docker compose build --no-cache"}); err != nil {
+		t.Fatalf("AddSnippet() error = %v", err)
+	}
+	team := []Snippet{
+		{Name: "pods", Command: "kubectl get pods -A", Description: "All pods in the cluster"},
+		{Name: "rebuild", Command: "docker compose build --pull"},
+	}
+	if err := ReplaceTeamSnippets(context.Background(), db, team); err != nil {
+		t.Fatalf("ReplaceTeamSnippets() error = %v", err)
+	}
+
+	tests := []struct {
+		query string
+		want  []string // badge + name
+	}{
+		{"", []string{"[team] pods", "[team] rebuild", "[snippet] rebuild"}},
+		{"docker", []string{"[team] rebuild", "[snippet] rebuild"}},
+		{"CLUSTER", []string{"[team] pods"}},
+		{"docker cache", []string{"[snippet] rebuild"}},
+		{"nothing", nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			snippets, err := SearchSnippets(context.Background(), db, tt.query)
+			if err != nil {
+				t.Fatalf("SearchSnippets() error = %v", err)
+			}
+			var got []string
+			for _, s := range snippets {
+				got = append(got, s.Badge()+" "+s.Name)
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("SearchSnippets(%q) = %v, want %v", tt.query, got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Errorf("SearchSnippets(%q)[%d] = %q, want %q", tt.query, i, got[i], tt.want[i])
+				}
+			}
+		})
+	}
+
+	// A new sync replaces the team library but keeps local snippets
+	if err := ReplaceTeamSnippets(context.Background(), db, team[:1]); err != nil {
+		t.Fatalf("ReplaceTeamSnippets() error = %v", err)
+	}
+	snippets, err := SearchSnippets(context.Background(), db, "")
+	if err != nil || len(snippets) != 2 {
+		t.Errorf("SearchSnippets() after resync = %+v, %v, want 2 snippets", snippets, err)
+	}
+
+	deleted, err := DeleteSnippet(context.Background(), db, "rebuild")
+	if err != nil || !deleted {
+		t.Errorf("DeleteSnippet() = %v, %v, want true", deleted, err)
+	}
+}
+
+func TestSnippetFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), TeamSnippetsFile)
+
+	snippets, err := LoadSnippetFile(path)
+	if err != nil || snippets != nil {
+		t.Fatalf("LoadSnippetFile() missing file = %v, %v, want empty", snippets, err)
+	}
+
+	want := []Snippet{
+		{Name: "b", Command: "cmd b"},
+		{Name: "a", Command: "cmd a", Description: "first"},
+	}
+	if err := WriteSnippetFile(path, want); err != nil {
+		t.Fatalf("WriteSnippetFile() error = %v", err)
+	}
+
+	got, err := LoadSnippetFile(path)
+	if err != nil {
+		t.Fatalf("LoadSnippetFile() error = %v", err)
+	}
+	if len(got) != 2 || got[0].Name != "a" || got[0].Description != "first" || got[1].Name != "b" {
+		t.Errorf("LoadSnippetFile() = %+v, want snippets sorted by name", got)
+	}
+
+	if err := os.WriteFile(path, []byte(`[{"name": "x"}]`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadSnippetFile(path); err == nil {
+		t.Error("LoadSnippetFile() with missing command error = nil, want error")
+	}
+}
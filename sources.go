@@ -0,0 +1,322 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// headHashSize is how much of the start of a history file is fingerprinted
+const headHashSize int64 = 4096
+
+// SourceState is the last observed state of a collected history file
+type SourceState struct {
+	Path          string
+	Size          int64
+	Inode         uint64  // 0 when the platform does not expose inodes
+	ModTime       float64 // Unix timestamp
+	HeadHash      string  // SHA-256 of the first HeadLen bytes
+	HeadLen       int64
+	LastCollected float64
+	RewrittenAt   float64 // Last time the file was found rewritten, 0 if never
+	Offset        int64   // Where the next collect resumes parsing
+	// Subseconds counts the commands of each second before Offset, for the
+	// seconds within subsecondWindow of the newest
+	Subseconds map[int64]int
+}
+
+// subsecondWindow is how far, in seconds, before the newest command parsed
+// SourceState.Subseconds goes back. A command written later than this
+// after ones that started after it is numbered after what is stored for
+// its second.
+const subsecondWindow = 24 * 60 * 60
+
+// encodeSubseconds writes counts as "second:count" pairs, oldest first
+func encodeSubseconds(counts map[int64]int) string {
+	seconds := slices.Sorted(maps.Keys(counts))
+	pairs := make([]string, len(seconds))
+	for i, s := range seconds {
+		pairs[i] = fmt.Sprintf("%d:%d", s, counts[s])
+	}
+	return strings.Join(pairs, ",")
+}
+
+// decodeSubseconds undoes encodeSubseconds, returning nil for ""
+func decodeSubseconds(encoded string) (map[int64]int, error) {
+	if encoded == "" {
+		return nil, nil
+	}
+	counts := map[int64]int{}
+	for _, pair := range strings.Split(encoded, ",") {
+		second, count, ok := strings.Cut(pair, ":")
+		s, err1 := strconv.ParseInt(second, 10, 64)
+		n, err2 := strconv.Atoi(count)
+		if !ok || err1 != nil || err2 != nil {
+			return nil, fmt.Errorf("invalid subsecond count %q", pair)
+		}
+		counts[s] = n
+	}
+	return counts, nil
+}
+
+// StatSource captures the current state of a history file
+func StatSource(path string) (*SourceState, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to stat %s: %w", path, err)
+	}
+
+	headLen := min(info.Size(), headHashSize)
+	headHash, err := hashFilePrefix(path, headLen)
+	if err != nil {
+		return nil, err
+	}
+
+	return &SourceState{
+		Path:     path,
+		Size:     info.Size(),
+		Inode:    fileInode(info),
+		ModTime:  float64(info.ModTime().UnixNano()) / 1e9,
+		HeadHash: headHash,
+		HeadLen:  headLen,
+	}, nil
+}
+
+func hashFilePrefix(path string, n int64) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to open %s: %w", path, err)
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.CopyN(h, f, n); err != nil {
+		return "", fmt.Errorf("failed to hash %s: %w", path, err)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// DetectRewrite reports whether a history file no longer looks like an
+// append-only continuation of what was collected before, and why
+func DetectRewrite(prev, cur *SourceState) (bool, string, error) {
+	if prev == nil {
+		return false, "", nil
+	}
+	if cur.Size < prev.Size {
+		return true, fmt.Sprintf("shrank from %d to %d bytes", prev.Size, cur.Size), nil
+	}
+	if prev.Inode != 0 && cur.Inode != 0 && prev.Inode != cur.Inode {
+		return true, "file was replaced", nil
+	}
+
+	headHash := cur.HeadHash
+	if cur.HeadLen != prev.HeadLen {
+		var err error
+		if headHash, err = hashFilePrefix(cur.Path, prev.HeadLen); err != nil {
+			return false, "", err
+		}
+	}
+	if headHash != prev.HeadHash {
+		return true, "start of file changed", nil
+	}
+	return false, "", nil
+}
+
+// GetSourceState returns the stored state for a history file, or nil if it
+// has never been collected
+func GetSourceState(ctx context.Context, db *sql.DB, path string) (*SourceState, error) {
+	row := db.QueryRowContext(ctx, `SELECT path, size, inode, mtime, head_hash, head_len, last_collected, rewritten_at, resume_offset, resume_subseconds
+		FROM sources WHERE path = ?`, path)
+
+	var state SourceState
+	var inode int64
+	var subseconds string
+	err := row.Scan(&state.Path, &state.Size, &inode, &state.ModTime, &state.HeadHash,
+		&state.HeadLen, &state.LastCollected, &state.RewrittenAt, &state.Offset, &subseconds)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get source state: %w", err)
+	}
+	state.Inode = uint64(inode)
+	if state.Subseconds, err = decodeSubseconds(subseconds); err != nil {
+		return nil, fmt.Errorf("failed to get source state: %w", err)
+	}
+
+	return &state, nil
+}
+
+// SetSourceState records the state of a history file after collecting it
+func SetSourceState(ctx context.Context, db *sql.DB, state *SourceState) error {
+	if state.LastCollected == 0 {
+		state.LastCollected = float64(time.Now().Unix())
+	}
+
+	_, err := db.ExecContext(ctx, `INSERT INTO sources (path, size, inode, mtime, head_hash, head_len, last_collected, rewritten_at, resume_offset, resume_subseconds)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT(path) DO UPDATE SET
+			size = excluded.size,
+			inode = excluded.inode,
+			mtime = excluded.mtime,
+			head_hash = excluded.head_hash,
+			head_len = excluded.head_len,
+			last_collected = excluded.last_collected,
+			rewritten_at = excluded.rewritten_at,
+			resume_offset = excluded.resume_offset,
+			resume_subseconds = excluded.resume_subseconds`,
+		state.Path, state.Size, int64(state.Inode), state.ModTime, state.HeadHash,
+		state.HeadLen, state.LastCollected, state.RewrittenAt, state.Offset, encodeSubseconds(state.Subseconds))
+	if err != nil {
+		return fmt.Errorf("failed to set source state: %w", err)
+	}
+
+	return nil
+}
+
+// SourceInfo summarises what has been collected from one source
+type SourceInfo struct {
+	Source    string
+	Count     int64
+	FirstSeen float64
+	LastSeen  float64
+	Exists    bool // The history file is still there; false for sources that aren't local files
+}
+
+// Kind says what a source name refers to: "file" for a history file,
+// "pipe" for the path of a pipe collected before --source-label, which means
+// nothing now, and "label" for any other name, such as a --source-label or
+// a synced host:path
+func (s SourceInfo) Kind() string {
+	switch {
+	case strings.HasPrefix(s.Source, "/dev/fd/") || strings.HasPrefix(s.Source, "/proc/"):
+		return "pipe"
+	case filepath.IsAbs(s.Source):
+		return "file"
+	}
+	return "label"
+}
+
+// ListSources returns every source with commands in the database, most
+// recently active first
+func ListSources(ctx context.Context, db *sql.DB) ([]SourceInfo, error) {
+	rows, err := db.QueryContext(ctx, `SELECT source, COUNT(*), MIN(timestamp), MAX(timestamp)
+		FROM commands GROUP BY source ORDER BY MAX(timestamp) DESC`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list sources: %w", err)
+	}
+	defer rows.Close()
+
+	var sources []SourceInfo
+	for rows.Next() {
+		var s SourceInfo
+		if err := rows.Scan(&s.Source, &s.Count, &s.FirstSeen, &s.LastSeen); err != nil {
+			return nil, fmt.Errorf("failed to scan source: %w", err)
+		}
+		if _, err := os.Stat(s.Source); err == nil {
+			s.Exists = true
+		}
+		sources = append(sources, s)
+	}
+	return sources, rows.Err()
+}
+
+// RenameSource moves every command, note, link, collect state and clock
+// offset of from to to, e.g. after a history file was moved, and returns how
+// many commands from had. Commands already collected under to are kept,
+// dropping the copy under from.
+func RenameSource(ctx context.Context, db *sql.DB, from, to string) (int64, error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var count int64
+	if err := tx.QueryRowContext(ctx, `SELECT COUNT(*) FROM commands WHERE source = ?`, from).Scan(&count); err != nil {
+		return 0, fmt.Errorf("failed to count commands: %w", err)
+	}
+	for _, query := range []string{
+		`UPDATE OR IGNORE commands SET source = ? WHERE source = ?`,
+		`UPDATE OR IGNORE notes SET source = ? WHERE source = ?`,
+		`UPDATE OR IGNORE links SET source = ? WHERE source = ?`,
+		`UPDATE OR IGNORE command_overflow SET source = ? WHERE source = ?`,
+		`UPDATE OR IGNORE sources SET path = ? WHERE path = ?`,
+		`UPDATE OR IGNORE clock_offsets SET source = ? WHERE source = ?`,
+		`UPDATE OR IGNORE predict_state SET source = ? WHERE source = ?`,
+	} {
+		if _, err := tx.ExecContext(ctx, query, to, from); err != nil {
+			return 0, fmt.Errorf("failed to rename source: %w", err)
+		}
+	}
+	if err := deleteSource(ctx, tx, from); err != nil {
+		return 0, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to commit rename: %w", err)
+	}
+	return count, nil
+}
+
+// ForgetSource deletes every command, note, link and collect state of a
+// source, such as a decommissioned host, and returns how many commands went.
+// The predictor starts over, counting the rest at the next collect.
+func ForgetSource(ctx context.Context, db *sql.DB, source string) (int64, error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var count int64
+	if err := tx.QueryRowContext(ctx, `SELECT COUNT(*) FROM commands WHERE source = ?`, source).Scan(&count); err != nil {
+		return 0, fmt.Errorf("failed to count commands: %w", err)
+	}
+	// Vectors are keyed by command, so keep those other sources ran too
+	if _, err := tx.ExecContext(ctx, `DELETE FROM embeddings WHERE command IN (SELECT command FROM commands WHERE source = ?)
+		AND command NOT IN (SELECT command FROM commands WHERE source != ?)`, source, source); err != nil {
+		return 0, fmt.Errorf("failed to delete embeddings: %w", err)
+	}
+	if err := deleteSource(ctx, tx, source); err != nil {
+		return 0, err
+	}
+	// What followed what can't be taken apart by source, so it is counted
+	// again from what is left
+	if err := resetPredictor(ctx, tx); err != nil {
+		return 0, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to commit forget: %w", err)
+	}
+	return count, nil
+}
+
+// deleteSource removes whatever is left of a source
+func deleteSource(ctx context.Context, tx *sql.Tx, source string) error {
+	for _, query := range []string{
+		`DELETE FROM commands WHERE source = ?`,
+		`DELETE FROM notes WHERE source = ?`,
+		`DELETE FROM links WHERE source = ?`,
+		`DELETE FROM command_overflow WHERE source = ?`,
+		`DELETE FROM sources WHERE path = ?`,
+		`DELETE FROM clock_offsets WHERE source = ?`,
+		`DELETE FROM predict_state WHERE source = ?`,
+	} {
+		if _, err := tx.ExecContext(ctx, query, source); err != nil {
+			return fmt.Errorf("failed to delete source: %w", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,342 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestDetectRewrite(t *testing.T) {
+	tmpDir := t.TempDir()
+	historyFile := filepath.Join(tmpDir, "zsh_history")
+
+	write := func(content string) *SourceState {
+		t.Helper()
+		if err := os.WriteFile(historyFile, []byte(content), 0644); err != nil {
+			t.Fatalf("failed to write history file: %v", err)
+		}
+		state, err := StatSource(historyFile)
+		if err != nil {
+			t.Fatalf("StatSource() error = %v", err)
+		}
+		return state
+	}
+
+	original := write(": 1704384000:0;ls\n: 1704384001:0;pwd\n")
+
+	tests := []struct {
+		name    string
+		content string
+		want    bool
+	}{
+		{"unchanged", ": 1704384000:0;ls\n: 1704384001:0;pwd\n", false},
+		{"appended", ": 1704384000:0;ls\n: 1704384001:0;pwd\n: 1704384002:0;whoami\n", false},
+		{"truncated", ": 1704384001:0;pwd\n", true},
+		{"edited start", ": 1704384000:0;cd\n: 1704384001:0;pwd\n: 1704384002:0;whoami\n", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cur := write(tt.content)
+			got, reason, err := DetectRewrite(original, cur)
+			if err != nil {
+				t.Fatalf("DetectRewrite() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("DetectRewrite() = %v (%s), want %v", got, reason, tt.want)
+			}
+		})
+	}
+
+	t.Run("never collected", func(t *testing.T) {
+		got, _, err := DetectRewrite(nil, original)
+		if err != nil {
+			t.Fatalf("DetectRewrite() error = %v", err)
+		}
+		if got {
+			t.Errorf("DetectRewrite(nil, ...) = true, want false")
+		}
+	})
+}
+
+func TestSourceStateRoundTrip(t *testing.T) {
+	db, err := InitDB(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("InitDB() error = %v", err)
+	}
+	defer db.Close()
+
+	state, err := GetSourceState(context.Background(), db, "/missing")
+	if err != nil {
+		t.Fatalf("GetSourceState() error = %v", err)
+	}
+	if state != nil {
+		t.Errorf("GetSourceState() for unknown path = %+v, want nil", state)
+	}
+
+	want := &SourceState{Path: "/hist", Size: 42, Inode: 7, ModTime: 1000.5, HeadHash: "abc", HeadLen: 42, Offset: 17,
+		Subseconds: map[int64]int{1000: 2, 1001: 1}}
+	if err := SetSourceState(context.Background(), db, want); err != nil {
+		t.Fatalf("SetSourceState() error = %v", err)
+	}
+
+	got, err := GetSourceState(context.Background(), db, "/hist")
+	if err != nil {
+		t.Fatalf("GetSourceState() error = %v", err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("GetSourceState() = %+v, want %+v", got, want)
+	}
+}
+
+func TestRenameAndForgetSource(t *testing.T) {
+	db, err := InitDB(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("InitDB() error = %v", err)
+	}
+	defer db.Close()
+
+	historyFile := filepath.Join(t.TempDir(), "zsh_history")
+	if err := os.WriteFile(historyFile, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	commands := []Command{
+		{Source: "/old/zsh_history", Timestamp: 1, Command: "ls"},
+		{Source: "/old/zsh_history", Timestamp: 2, Command: "pwd"},
+		{Source: historyFile, Timestamp: 2, Command: "pwd"},
+		{Source: "laptop:~/.zsh_history", Timestamp: 3, Command: "whoami"},
+	}
+	if _, _, err := InsertCommands(context.Background(), db, commands); err != nil {
+		t.Fatalf("InsertCommands() error = %v", err)
+	}
+	if err := SetNote(context.Background(), db, "/old/zsh_history", 1, "lists things"); err != nil {
+		t.Fatalf("SetNote() error = %v", err)
+	}
+
+	sources, err := ListSources(context.Background(), db)
+	if err != nil || len(sources) != 3 {
+		t.Fatalf("ListSources() = %v, %v, want 3 sources", sources, err)
+	}
+	if s := sources[0]; s.Source != "laptop:~/.zsh_history" || s.Count != 1 || s.Exists {
+		t.Errorf("ListSources()[0] = %+v, want the remote source, not on disk", s)
+	}
+	if s := sources[1]; s.Source != "/old/zsh_history" || s.Count != 2 || s.FirstSeen != 1 || s.LastSeen != 2 {
+		t.Errorf("ListSources()[1] = %+v, want 2 commands from 1 to 2", s)
+	}
+
+	// The shared timestamp was collected under both names, so it's kept once
+	renamed, err := RenameSource(context.Background(), db, "/old/zsh_history", historyFile)
+	if err != nil || renamed != 2 {
+		t.Fatalf("RenameSource() = %d, %v, want 2", renamed, err)
+	}
+	sources, err = ListSources(context.Background(), db)
+	if err != nil || len(sources) != 2 {
+		t.Fatalf("ListSources() = %v, %v, want 2 sources", sources, err)
+	}
+	if s := sources[1]; s.Source != historyFile || s.Count != 2 || !s.Exists {
+		t.Errorf("ListSources()[1] = %+v, want both commands under the existing file", s)
+	}
+	var note string
+	if err := db.QueryRow(`SELECT note FROM notes WHERE source = ?`, historyFile).Scan(&note); err != nil || note != "lists things" {
+		t.Errorf("note = %q, %v, want it to follow its command", note, err)
+	}
+
+	forgotten, err := ForgetSource(context.Background(), db, "laptop:~/.zsh_history")
+	if err != nil || forgotten != 1 {
+		t.Fatalf("ForgetSource() = %d, %v, want 1", forgotten, err)
+	}
+	results, err := SearchCommands(context.Background(), db, SearchOptions{Query: "whoami", Limit: 10})
+	if err != nil || len(results) != 0 {
+		t.Errorf("SearchCommands(whoami) = %v, %v, want it forgotten", results, err)
+	}
+}
+
+func TestCollectIncremental(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	dbPath, history := filepath.Join(dir, "test.db"), filepath.Join(dir, "zsh_history")
+	first := ": 1704384000:0;ls\n: 1704384010:0;pwd\n: 1704384010:0;df -h\n"
+	if err := os.WriteFile(history, []byte(first), 0644); err != nil {
+		t.Fatal(err)
+	}
+	collect := func(opts CollectOptions) {
+		t.Helper()
+		opts.Quiet = true
+		if err := runCollect(ctx, dbPath, []string{history}, opts); err != nil {
+			t.Fatalf("runCollect() error = %v", err)
+		}
+	}
+	collect(CollectOptions{})
+
+	db, err := InitDB(dbPath)
+	if err != nil {
+		t.Fatalf("InitDB() error = %v", err)
+	}
+	defer db.Close()
+	lastParsed := func() int {
+		t.Helper()
+		var parsed int
+		if err := db.QueryRow(`SELECT parsed FROM collect_runs ORDER BY rowid DESC LIMIT 1`).Scan(&parsed); err != nil {
+			t.Fatal(err)
+		}
+		return parsed
+	}
+
+	// The next collect starts at the last second, "pwd"
+	state, err := GetSourceState(ctx, db, history)
+	if err != nil || state == nil || state.Offset != int64(len(": 1704384000:0;ls\n")) {
+		t.Fatalf("GetSourceState() = %+v, %v, want the offset of pwd", state, err)
+	}
+
+	f, err := os.OpenFile(history, os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString(": 1704384010:0;free -m\n: 1704384020:0;uptime\n")
+	f.Close()
+	collect(CollectOptions{})
+	if parsed := lastParsed(); parsed != 4 {
+		t.Errorf("incremental collect parsed %d commands, want 4 from pwd on", parsed)
+	}
+
+	results, err := SearchCommands(ctx, db, SearchOptions{Sort: SortTime})
+	if err != nil {
+		t.Fatalf("SearchCommands() error = %v", err)
+	}
+	var got []string
+	for _, r := range results {
+		got = append(got, fmt.Sprintf("%.3f %s", r.Timestamp, r.Command))
+	}
+	want := []string{"1704384020.000 uptime", "1704384010.002 free -m", "1704384010.001 df -h", "1704384010.000 pwd", "1704384000.000 ls"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("after incremental collect = %v, want %v", got, want)
+	}
+
+	collect(CollectOptions{Full: true})
+	if parsed := lastParsed(); parsed != 5 {
+		t.Errorf("collect --full parsed %d commands, want all 5", parsed)
+	}
+}
+
+func TestCollectInterleavedSeconds(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	dbPath, history := filepath.Join(dir, "test.db"), filepath.Join(dir, "zsh_history")
+	// Two shells sharing the file: make started before git log but was
+	// written after it, so the resume point is make's, in a second ls has
+	// a command in before it
+	first := ": 1704384000:0;ls\n: 1704384001:0;git log\n: 1704384000:5;make\n"
+	if err := os.WriteFile(history, []byte(first), 0644); err != nil {
+		t.Fatal(err)
+	}
+	collect := func(opts CollectOptions) {
+		t.Helper()
+		opts.Quiet = true
+		if err := runCollect(ctx, dbPath, []string{history}, opts); err != nil {
+			t.Fatalf("runCollect() error = %v", err)
+		}
+	}
+	collect(CollectOptions{})
+
+	f, err := os.OpenFile(history, os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString(": 1704384000:2;make test\n")
+	f.Close()
+	collect(CollectOptions{})
+
+	db, err := InitDB(dbPath)
+	if err != nil {
+		t.Fatalf("InitDB() error = %v", err)
+	}
+	defer db.Close()
+	commands := func() []string {
+		t.Helper()
+		results, err := SearchCommands(ctx, db, SearchOptions{Sort: SortTime})
+		if err != nil {
+			t.Fatalf("SearchCommands() error = %v", err)
+		}
+		var got []string
+		for _, r := range results {
+			got = append(got, fmt.Sprintf("%.3f %s", r.Timestamp, r.Command))
+		}
+		return got
+	}
+	want := []string{"1704384001.000 git log", "1704384000.002 make test", "1704384000.001 make", "1704384000.000 ls"}
+	if got := commands(); !reflect.DeepEqual(got, want) {
+		t.Errorf("after incremental collect = %v, want %v", got, want)
+	}
+
+	// A full collect numbers them the same, so adds nothing
+	collect(CollectOptions{Full: true})
+	if got := commands(); !reflect.DeepEqual(got, want) {
+		t.Errorf("after collect --full = %v, want %v", got, want)
+	}
+}
+
+func TestCollectSourceLabel(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	dbPath, history := filepath.Join(dir, "test.db"), filepath.Join(dir, "zsh_history")
+	if err := os.WriteFile(history, []byte(": 1704384000:0;ls\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdin := os.Stdin
+	defer func() { os.Stdin = stdin }()
+	os.Stdin = r
+	go func() {
+		w.WriteString(": 1704384010:0;uptime\n: 1704384020:0;df -h\n")
+		w.Close()
+	}()
+
+	if err := runCollect(ctx, dbPath, []string{"-"}, CollectOptions{Quiet: true}); err == nil || !strings.Contains(err.Error(), "--source-label") {
+		t.Errorf("runCollect(-) without a label error = %v, want one asking for --source-label", err)
+	}
+	if err := runCollect(ctx, dbPath, []string{"-"}, CollectOptions{Quiet: true, SourceLabel: "server:~/.zsh_history"}); err != nil {
+		t.Fatalf("runCollect(-) error = %v", err)
+	}
+	if err := runCollect(ctx, dbPath, []string{history}, CollectOptions{Quiet: true, SourceLabel: "laptop"}); err != nil {
+		t.Fatalf("runCollect() error = %v", err)
+	}
+	if err := runCollect(ctx, dbPath, []string{history, "-"}, CollectOptions{Quiet: true, SourceLabel: "laptop"}); err == nil {
+		t.Error("runCollect() labelled two inputs")
+	}
+	if err := runCollect(ctx, dbPath, []string{history}, CollectOptions{Quiet: true, SourceLabel: "a\tb"}); err == nil {
+		t.Error("runCollect() accepted a label with a tab")
+	}
+
+	db, err := InitDB(dbPath)
+	if err != nil {
+		t.Fatalf("InitDB() error = %v", err)
+	}
+	defer db.Close()
+	sources, err := ListSources(ctx, db)
+	if err != nil || len(sources) != 2 {
+		t.Fatalf("ListSources() = %+v, %v, want 2 sources", sources, err)
+	}
+	if s := sources[0]; s.Source != "server:~/.zsh_history" || s.Count != 2 {
+		t.Errorf("ListSources()[0] = %+v, want the labelled stdin", s)
+	}
+	if s := sources[1]; s.Source != "laptop" || s.Count != 1 {
+		t.Errorf("ListSources()[1] = %+v, want the labelled file", s)
+	}
+	if state, err := GetSourceState(ctx, db, history); err != nil || state != nil {
+		t.Errorf("GetSourceState() = %+v, %v, want no state for a labelled file", state, err)
+	}
+
+	for source, want := range map[string]string{"/home/me/.zsh_history": "file", "/dev/fd/63": "pipe", "/proc/self/fd/11": "pipe", "server:~/.zsh_history": "label", "laptop": "label"} {
+		if got := (SourceInfo{Source: source}).Kind(); got != want {
+			t.Errorf("Kind() of %q = %q, want %q", source, got, want)
+		}
+	}
+}
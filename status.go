@@ -0,0 +1,256 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/tchaudhry91/zist/llm"
+)
+
+// statusTimeout bounds each network check `zist status` makes
+const statusTimeout = 2 * time.Second
+
+// Status is the state of every part of zist, as `zist status` shows it
+type Status struct {
+	RCFile      string
+	Integration string // "installed", "edited", "not installed", or what is wrong with the block
+
+	DBPath        string
+	DBSize        int64 // With the WAL; 0 when there is no database yet
+	DBUsed        int64 // Bytes in pages that aren't free, what max-size caps
+	MaxSize       int64 // 0 for no cap
+	Commands      int64
+	Sources       int64
+	SchemaVersion int
+	Collecting    bool // A collect or other bulk write holds the write lock
+	Daemon        bool // zist daemon is collecting into the database
+	Collects      []CollectStats
+	Sync          []SyncStatus
+
+	Wizard WizardStatus
+	Embed  EmbedStatus
+	Errors []string // Checks that couldn't run
+}
+
+// EmbedStatus is how much history semantic search covers
+type EmbedStatus struct {
+	Model    string // Empty when no embedding model is set
+	Embedded int
+	Backfill *BackfillProgress // Nil if none was started
+}
+
+// SyncStatus is how far behind local history a sync server is
+type SyncStatus struct {
+	Server     string
+	Pending    int64   // Commands collected since the last push
+	LastSynced float64 // Unix time
+}
+
+// WizardStatus is whether the wizard's LLM can be used
+type WizardStatus struct {
+	Backend     string
+	URL         string
+	Model       string
+	Reachable   bool
+	PausedUntil float64 // Unix time the circuit breaker fails fast until, 0 if closed
+}
+
+// GetSyncStatus returns every server local history was pushed to
+func GetSyncStatus(ctx context.Context, db *sql.DB) ([]SyncStatus, error) {
+	rows, err := db.QueryContext(ctx, `SELECT s.server, (SELECT COUNT(*) FROM commands c WHERE c.rowid > s.last_rowid), s.last_synced
+		FROM sync_state s ORDER BY s.server`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query sync state: %w", err)
+	}
+	defer rows.Close()
+
+	var servers []SyncStatus
+	for rows.Next() {
+		var s SyncStatus
+		if err := rows.Scan(&s.Server, &s.Pending, &s.LastSynced); err != nil {
+			return nil, fmt.Errorf("failed to scan sync state: %w", err)
+		}
+		servers = append(servers, s)
+	}
+	return servers, rows.Err()
+}
+
+// integrationStatus describes the zist block in the rc file
+func integrationStatus(rcPath string) string {
+	content, err := os.ReadFile(rcPath)
+	if os.IsNotExist(err) {
+		return "not installed"
+	}
+	if err != nil {
+		return err.Error()
+	}
+	block, found, err := findIntegration(string(content))
+	switch {
+	case err != nil:
+		return err.Error()
+	case !found:
+		return "not installed"
+	case block.edited():
+		return "edited, or from another zist version"
+	}
+	return "installed"
+}
+
+// GetStatus gathers the status of the rc file, the database at dbPath and
+// the wizard's LLM. The database is opened read-only, so status changes
+// nothing, not even the schema; checks that fail are noted in Errors.
+func GetStatus(ctx context.Context, dbPath, rcPath string, llmConfig llm.Config) Status {
+	st := Status{RCFile: rcPath, Integration: integrationStatus(rcPath), DBPath: expandTilde(dbPath)}
+	note := func(err error) {
+		if err != nil {
+			st.Errors = append(st.Errors, err.Error())
+		}
+	}
+
+	var db *sql.DB
+	if info, err := os.Stat(st.DBPath); err == nil {
+		st.DBSize = info.Size()
+		if wal, err := os.Stat(st.DBPath + "-wal"); err == nil {
+			st.DBSize += wal.Size()
+		}
+		db, err = sql.Open("sqlite", "file:"+st.DBPath+"?mode=ro")
+		if err != nil {
+			note(fmt.Errorf("failed to open database: %w", err))
+		} else {
+			defer db.Close()
+		}
+	} else if !os.IsNotExist(err) {
+		note(err)
+	}
+
+	if db != nil {
+		var err error
+		st.SchemaVersion, err = SchemaVersion(db)
+		note(err)
+		st.DBUsed, err = DBUsedBytes(ctx, db)
+		note(err)
+		note(db.QueryRowContext(ctx, `SELECT COUNT(*), COUNT(DISTINCT source) FROM commands`).Scan(&st.Commands, &st.Sources))
+		st.Collects, err = GetCollectStats(ctx, db, 0)
+		note(err)
+		st.Sync, err = GetSyncStatus(ctx, db)
+		note(err)
+
+		if embedConfig.Model != "" {
+			st.Embed.Model = embedConfig.Model
+			st.Embed.Embedded, err = CountEmbeddings(ctx, db, embedConfig.Model)
+			note(err)
+			st.Embed.Backfill, err = GetBackfillProgress(ctx, db, embedConfig.Model)
+			note(err)
+		}
+	}
+	st.Collecting = WriteLocked(dbPath)
+	st.Daemon = DaemonRunning(dbPath)
+
+	config, err := llmSettings(llmConfig)
+	if err != nil {
+		note(err)
+		return st
+	}
+	st.Wizard = WizardStatus{Backend: config.Backend, URL: config.BaseURL, Model: config.Model}
+	if db != nil {
+		health, err := GetEndpointHealth(ctx, db, config.BaseURL)
+		note(err)
+		if health.Open(time.Now()) {
+			st.Wizard.PausedUntil = health.OpenUntil
+		}
+	}
+	client, err := llm.New(config)
+	if err != nil {
+		note(err)
+		return st
+	}
+	checkCtx, cancel := context.WithTimeout(ctx, statusTimeout)
+	defer cancel()
+	st.Wizard.Reachable = client.IsAvailable(checkCtx)
+	return st
+}
+
+// writeStatus prints st as a short report
+func writeStatus(w io.Writer, st Status) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "Integration:\t%s (%s)\n", st.Integration, st.RCFile)
+
+	if st.DBSize == 0 {
+		fmt.Fprintf(tw, "Database:\tnone yet at %s\n", st.DBPath)
+	} else {
+		fmt.Fprintf(tw, "Database:\t%s (%s)\n", st.DBPath, formatSize(st.DBSize))
+		if st.MaxSize > 0 {
+			if st.DBUsed > st.MaxSize {
+				fmt.Fprintf(tw, "Max size:\tWARNING: %s in use, over max-size %s; run `zist archive`, collect with --max-size-action archive, or raise max-size\n", formatSize(st.DBUsed), formatSize(st.MaxSize))
+			} else {
+				fmt.Fprintf(tw, "Max size:\t%s in use of %s\n", formatSize(st.DBUsed), formatSize(st.MaxSize))
+			}
+		}
+		fmt.Fprintf(tw, "Commands:\t%d from %d source(s)\n", st.Commands, st.Sources)
+		schema := fmt.Sprintf("%d", st.SchemaVersion)
+		if st.SchemaVersion < len(migrations) {
+			schema += fmt.Sprintf(" (%d after the next zist command opens it)", len(migrations))
+		}
+		fmt.Fprintf(tw, "Schema version:\t%s\n", schema)
+	}
+	if st.Collecting {
+		fmt.Fprintf(tw, "Collect:\trunning now\n")
+	}
+	if st.Daemon {
+		fmt.Fprintf(tw, "Daemon:\trunning, collecting as history files change\n")
+	} else {
+		fmt.Fprintf(tw, "Daemon:\tstopped\n")
+	}
+
+	wizard := "unreachable"
+	switch {
+	case st.Wizard.PausedUntil > 0:
+		wizard = "paused after repeated failures until " + FormatTimestamp(st.Wizard.PausedUntil)
+	case st.Wizard.Reachable:
+		wizard = "reachable"
+	}
+	if st.Wizard.URL != "" {
+		fmt.Fprintf(tw, "Wizard LLM:\t%s, %s at %s (%s)\n", wizard, st.Wizard.Model, st.Wizard.URL, st.Wizard.Backend)
+	}
+
+	if st.Embed.Model != "" {
+		backfill := "no backfill yet"
+		if b := st.Embed.Backfill; b != nil {
+			backfill = fmt.Sprintf("backfill %.0f%% done", b.Percent())
+		}
+		fmt.Fprintf(tw, "Embeddings:\t%d command(s) with %s, %s\n", st.Embed.Embedded, st.Embed.Model, backfill)
+	}
+
+	for _, s := range st.Sync {
+		fmt.Fprintf(tw, "Sync:\t%s, %d command(s) to push, last pushed %s\n", s.Server, s.Pending, FormatTimestamp(s.LastSynced))
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+
+	if len(st.Collects) > 0 {
+		fmt.Fprintln(w, "\nLast collect per source:")
+		tw = tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		for _, c := range st.Collects {
+			result := "ok"
+			if c.LastFailed {
+				result = "failed: " + c.LastError
+			}
+			fmt.Fprintf(tw, "  %s\t%s\t%s\n", c.Source, FormatTimestamp(c.LastRun), result)
+		}
+		if err := tw.Flush(); err != nil {
+			return err
+		}
+	}
+
+	if len(st.Errors) > 0 {
+		fmt.Fprintf(w, "\nCouldn't check everything:\n  %s\n", strings.Join(st.Errors, "\n  "))
+	}
+	return nil
+}
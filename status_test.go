@@ -0,0 +1,102 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/tchaudhry91/zist/llm"
+)
+
+func TestGetStatus(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	dbPath, rc := filepath.Join(dir, "test.db"), filepath.Join(dir, ".zshrc")
+	if err := os.WriteFile(rc, []byte(zshIntegration), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	db, err := InitDB(dbPath)
+	if err != nil {
+		t.Fatalf("InitDB() error = %v", err)
+	}
+	commands := []Command{
+		{Source: "/h", Timestamp: 1000, Command: "ls"},
+		{Source: "/h", Timestamp: 1001, Command: "pwd"},
+		{Source: "/g", Timestamp: 1002, Command: "id"},
+	}
+	if _, _, err := InsertCommands(ctx, db, commands); err != nil {
+		t.Fatal(err)
+	}
+	if err := SetSyncCursor(ctx, db, "https://zist.example.com", 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := LogCollectRuns(ctx, db, []CollectRun{{Timestamp: float64(time.Now().Unix()), Source: "/h", Parsed: 2, Inserted: 2}}); err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+
+	st := GetStatus(ctx, dbPath, rc, llm.Config{BaseURL: "http://127.0.0.1:1"})
+	if len(st.Errors) > 0 {
+		t.Errorf("GetStatus() errors = %q", st.Errors)
+	}
+	if st.Integration != "installed" || st.Commands != 3 || st.Sources != 2 || st.SchemaVersion != len(migrations) || st.DBSize == 0 {
+		t.Errorf("GetStatus() = %+v", st)
+	}
+	if len(st.Sync) != 1 || st.Sync[0].Pending != 2 {
+		t.Errorf("GetStatus().Sync = %+v, want 2 commands pending", st.Sync)
+	}
+	if len(st.Collects) != 1 || st.Collects[0].Source != "/h" {
+		t.Errorf("GetStatus().Collects = %+v", st.Collects)
+	}
+	if st.Wizard.Reachable {
+		t.Error("GetStatus() found the LLM reachable on a closed port")
+	}
+
+	var out bytes.Buffer
+	if err := writeStatus(&out, st); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"installed", "3 from 2 source(s)", "2 command(s) to push", "unreachable", "stopped"} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("status is missing %q:\n%s", want, out.String())
+		}
+	}
+
+	out.Reset()
+	st.MaxSize = st.DBUsed - 1
+	if err := writeStatus(&out, st); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out.String(), "WARNING: "+formatSize(st.DBUsed)+" in use, over max-size") {
+		t.Errorf("status over max-size doesn't warn:\n%s", out.String())
+	}
+
+	// No database yet: nothing is created
+	missing := filepath.Join(dir, "missing.db")
+	if st := GetStatus(ctx, missing, filepath.Join(dir, "nope"), llm.Config{BaseURL: "http://127.0.0.1:1"}); st.DBSize != 0 || st.Integration != "not installed" || len(st.Errors) > 0 {
+		t.Errorf("GetStatus() without a database = %+v", st)
+	}
+	if _, err := os.Stat(missing); !os.IsNotExist(err) {
+		t.Error("GetStatus() created the database")
+	}
+	for _, path := range []string{writeLockPath(dbPath), writeLockPath(missing)} {
+		if _, err := os.Stat(path); !os.IsNotExist(err) {
+			t.Errorf("GetStatus() created the write lock %s", path)
+		}
+	}
+
+	// A collect holding the lock shows as running
+	lock, err := AcquireWriteLock(ctx, dbPath, false, nil)
+	if err != nil {
+		t.Fatalf("AcquireWriteLock() error = %v", err)
+	}
+	defer lock.Release()
+	if st := GetStatus(ctx, dbPath, rc, llm.Config{BaseURL: "http://127.0.0.1:1"}); !st.Collecting {
+		t.Error("GetStatus() with the write lock held: Collecting = false, want true")
+	}
+}
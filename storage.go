@@ -0,0 +1,229 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"database/sql"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// Thresholds past which `zist stats --storage` recommends cleaning up
+const (
+	storageGrowthDays  = 30        // Recent commands the growth rate is averaged over
+	storageArchiveSize = 256 << 20 // Database size worth archiving old commands at
+	storageCacheShare  = 0.25      // Share of the file wizard caches may take
+	storageFreeShare   = 0.25      // Share of the file free pages may take before VACUUM pays off
+	storageMinReclaim  = 1 << 20   // Less than this isn't worth a recommendation
+	storageProjectDays = 365       // How far ahead growth is projected
+)
+
+// storageCacheTables hold wizard answers `zist wizard --clear-cache` empties
+var storageCacheTables = []string{"wizard_cache", "llm_cache"}
+
+// TableStorage is the space a table takes, its indexes included. FTS
+// tables include their shadow tables.
+type TableStorage struct {
+	Name  string
+	Rows  int64
+	Bytes int64
+}
+
+// StorageStats is where the space in a database goes and how fast it grows
+type StorageStats struct {
+	FileSize      int64          // The database file with its WAL
+	FreeBytes     int64          // Unused pages VACUUM would give back
+	Tables        []TableStorage // Largest first
+	FTSBytes      int64          // Full-text indexes of commands and notes
+	Commands      int64
+	CommandBytes  float64 // Average bytes per command, with its indexes and FTS entry
+	DailyCommands float64 // Commands a day over the last storageGrowthDays
+	Archivable    int64   // Commands older than DefaultArchiveYears
+}
+
+// GetStorageStats measures db, the database at dbPath, from its pages
+func GetStorageStats(ctx context.Context, db *sql.DB, dbPath string) (StorageStats, error) {
+	var st StorageStats
+	path := expandTilde(dbPath)
+	if info, err := os.Stat(path); err == nil {
+		st.FileSize = info.Size()
+	}
+	if info, err := os.Stat(path + "-wal"); err == nil {
+		st.FileSize += info.Size()
+	}
+
+	owners, virtual, err := storageOwners(ctx, db)
+	if err != nil {
+		return st, err
+	}
+
+	rows, err := db.QueryContext(ctx, `SELECT name, SUM(pgsize) FROM dbstat GROUP BY name`)
+	if err != nil {
+		return st, fmt.Errorf("failed to read page usage: %w", err)
+	}
+	defer rows.Close()
+	bytes := make(map[string]int64)
+	for rows.Next() {
+		var name string
+		var size int64
+		if err := rows.Scan(&name, &size); err != nil {
+			return st, fmt.Errorf("failed to scan page usage: %w", err)
+		}
+		owner, ok := owners[name]
+		if !ok {
+			owner = name
+		}
+		// FTS5 keeps its index in shadow tables named after the virtual table
+		for _, v := range virtual {
+			if strings.HasPrefix(owner, v+"_") {
+				owner = v
+			}
+		}
+		bytes[owner] += size
+	}
+	if err := rows.Err(); err != nil {
+		return st, fmt.Errorf("failed to read page usage: %w", err)
+	}
+
+	for name, size := range bytes {
+		t := TableStorage{Name: name, Bytes: size}
+		if !strings.HasPrefix(name, "sqlite_") {
+			if err := db.QueryRowContext(ctx, `SELECT COUNT(*) FROM "`+name+`"`).Scan(&t.Rows); err != nil {
+				return st, fmt.Errorf("failed to count rows of %s: %w", name, err)
+			}
+		}
+		if slices.Contains(virtual, name) {
+			st.FTSBytes += size
+		}
+		st.Tables = append(st.Tables, t)
+	}
+	slices.SortFunc(st.Tables, func(a, b TableStorage) int {
+		return cmp.Or(cmp.Compare(b.Bytes, a.Bytes), strings.Compare(a.Name, b.Name))
+	})
+
+	var pageSize, freePages int64
+	if err := db.QueryRowContext(ctx, `PRAGMA page_size`).Scan(&pageSize); err != nil {
+		return st, fmt.Errorf("failed to read page size: %w", err)
+	}
+	if err := db.QueryRowContext(ctx, `PRAGMA freelist_count`).Scan(&freePages); err != nil {
+		return st, fmt.Errorf("failed to read free pages: %w", err)
+	}
+	st.FreeBytes = pageSize * freePages
+
+	now := time.Now()
+	recent := float64(now.AddDate(0, 0, -storageGrowthDays).Unix())
+	old := float64(now.AddDate(-DefaultArchiveYears, 0, 0).Unix())
+	var recentCount int64
+	if err := db.QueryRowContext(ctx, `SELECT COUNT(*),
+		COUNT(CASE WHEN timestamp >= ? THEN 1 END),
+		COUNT(CASE WHEN timestamp < ? THEN 1 END) FROM commands`, recent, old).Scan(&st.Commands, &recentCount, &st.Archivable); err != nil {
+		return st, fmt.Errorf("failed to count commands: %w", err)
+	}
+	st.DailyCommands = float64(recentCount) / storageGrowthDays
+	if st.Commands > 0 {
+		st.CommandBytes = float64(bytes["commands"]+bytes["commands_fts"]+bytes["command_overflow"]) / float64(st.Commands)
+	}
+	return st, nil
+}
+
+// storageOwners maps each index to its table, and lists the virtual tables
+func storageOwners(ctx context.Context, db *sql.DB) (map[string]string, []string, error) {
+	rows, err := db.QueryContext(ctx, `SELECT type, name, tbl_name, COALESCE(sql, '') FROM sqlite_master WHERE type IN ('table', 'index')`)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read schema: %w", err)
+	}
+	defer rows.Close()
+
+	owners := make(map[string]string)
+	var virtual []string
+	for rows.Next() {
+		var kind, name, table, create string
+		if err := rows.Scan(&kind, &name, &table, &create); err != nil {
+			return nil, nil, fmt.Errorf("failed to scan schema: %w", err)
+		}
+		owners[name] = table
+		if kind == "table" && strings.HasPrefix(strings.ToUpper(create), "CREATE VIRTUAL TABLE") {
+			virtual = append(virtual, name)
+		}
+	}
+	return owners, virtual, rows.Err()
+}
+
+// Projected returns the expected file size in days at the recent rate
+func (s StorageStats) Projected(days int) int64 {
+	return s.FileSize + int64(s.DailyCommands*float64(days)*s.CommandBytes)
+}
+
+// table returns the storage of the named table, zero when it has no pages
+func (s StorageStats) table(name string) TableStorage {
+	for _, t := range s.Tables {
+		if t.Name == name {
+			return t
+		}
+	}
+	return TableStorage{Name: name}
+}
+
+// Recommendations suggests what would free space, for sizes past the
+// thresholds above
+func (s StorageStats) Recommendations(dbPath string) []string {
+	var recs []string
+	if s.Archivable > 0 && max(s.FileSize, s.Projected(storageProjectDays)) >= storageArchiveSize {
+		share := float64(s.Archivable) / float64(s.Commands)
+		recs = append(recs, fmt.Sprintf("%d command(s) (%.0f%%) are older than %d years, `zist archive` moves them out of the main database (about %s)",
+			s.Archivable, share*100, DefaultArchiveYears, formatSize(int64(float64(s.Archivable)*s.CommandBytes))))
+	}
+
+	var cache int64
+	for _, name := range storageCacheTables {
+		cache += s.table(name).Bytes
+	}
+	if cache >= storageMinReclaim && float64(cache) >= storageCacheShare*float64(s.FileSize) {
+		recs = append(recs, fmt.Sprintf("Wizard caches take %s, `zist wizard --clear-cache` empties them", formatSize(cache)))
+	}
+
+	if s.FreeBytes >= storageMinReclaim && float64(s.FreeBytes) >= storageFreeShare*float64(s.FileSize) {
+		recs = append(recs, fmt.Sprintf("%s of the file is free pages, `sqlite3 %s VACUUM` gives them back while no zist runs", formatSize(s.FreeBytes), dbPath))
+	}
+	return recs
+}
+
+// writeStorageStats prints s as a breakdown with a forecast
+func writeStorageStats(w io.Writer, s StorageStats, dbPath string) error {
+	fmt.Fprintf(w, "Database: %s (%s)\n", dbPath, formatSize(s.FileSize))
+	if s.FileSize > 0 {
+		fmt.Fprintf(w, "Full-text index: %s (%.0f%%)\n", formatSize(s.FTSBytes), 100*float64(s.FTSBytes)/float64(s.FileSize))
+		fmt.Fprintf(w, "Free pages: %s\n", formatSize(s.FreeBytes))
+	}
+
+	fmt.Fprintln(w)
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "TABLE\tROWS\tSIZE\tAVG ROW\t")
+	for _, t := range s.Tables {
+		rows, avg := "-", "-"
+		if !strings.HasPrefix(t.Name, "sqlite_") {
+			rows = fmt.Sprint(t.Rows)
+		}
+		if t.Rows > 0 {
+			avg = formatSize(t.Bytes / t.Rows)
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t\n", t.Name, rows, formatSize(t.Bytes), avg)
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(w, "\nGrowth: %.0f command(s) a day over the last %d days, %s per command with indexes\n",
+		s.DailyCommands, storageGrowthDays, formatSize(int64(s.CommandBytes)))
+	fmt.Fprintf(w, "Projected size: %s in 30 days, %s in a year\n", formatSize(s.Projected(30)), formatSize(s.Projected(storageProjectDays)))
+
+	if recs := s.Recommendations(dbPath); len(recs) > 0 {
+		fmt.Fprintf(w, "\nRecommended:\n  %s\n", strings.Join(recs, "\n  "))
+	}
+	return nil
+}
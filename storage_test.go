@@ -0,0 +1,93 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestGetStorageStats(t *testing.T) {
+	ctx := context.Background()
+	dbPath := filepath.Join(t.TempDir(), "test.db")
+	db, err := InitDB(dbPath)
+	if err != nil {
+		t.Fatalf("InitDB() error = %v", err)
+	}
+	defer db.Close()
+
+	now := float64(time.Now().Unix())
+	var commands []Command
+	for i := range 60 {
+		commands = append(commands, Command{Source: "/h", Timestamp: now - float64(i*3600), Command: "git status"})
+	}
+	for i := range 40 {
+		commands = append(commands, Command{Source: "/h", Timestamp: now - float64(3*365*24*3600+i), Command: "ls -la"})
+	}
+	if _, _, err := InsertCommands(ctx, db, commands); err != nil {
+		t.Fatal(err)
+	}
+
+	st, err := GetStorageStats(ctx, db, dbPath)
+	if err != nil {
+		t.Fatalf("GetStorageStats() error = %v", err)
+	}
+	if st.FileSize == 0 || st.Commands != 100 || st.Archivable != 40 || st.DailyCommands != 2 {
+		t.Errorf("GetStorageStats() = %+v", st)
+	}
+	if cmds := st.table("commands"); cmds.Rows != 100 || cmds.Bytes == 0 {
+		t.Errorf("commands table = %+v", cmds)
+	}
+	if fts := st.table("commands_fts"); fts.Bytes == 0 || st.FTSBytes < fts.Bytes {
+		t.Errorf("commands_fts = %+v, FTSBytes = %d", fts, st.FTSBytes)
+	}
+	for _, tbl := range st.Tables {
+		if strings.HasPrefix(tbl.Name, "commands_fts_") || strings.HasPrefix(tbl.Name, "idx_") {
+			t.Errorf("%s listed apart from the table it belongs to", tbl.Name)
+		}
+	}
+	if st.CommandBytes <= 0 || st.Projected(365) <= st.FileSize {
+		t.Errorf("CommandBytes = %v, Projected(365) = %d, want growth", st.CommandBytes, st.Projected(365))
+	}
+
+	var out bytes.Buffer
+	if err := writeStorageStats(&out, st, dbPath); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"Full-text index:", "commands_fts", "Projected size:"} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("writeStorageStats() missing %q:\n%s", want, out.String())
+		}
+	}
+}
+
+func TestStorageRecommendations(t *testing.T) {
+	tests := []struct {
+		name  string
+		stats StorageStats
+		want  []string
+	}{
+		{"small", StorageStats{FileSize: 10 << 20, Commands: 1000, Archivable: 500, CommandBytes: 300}, nil},
+		{"large with old commands", StorageStats{FileSize: 300 << 20, Commands: 1000, Archivable: 500, CommandBytes: 300}, []string{"zist archive"}},
+		{"growing past the threshold", StorageStats{FileSize: 200 << 20, Commands: 1000, Archivable: 10, CommandBytes: 300, DailyCommands: 1000}, []string{"zist archive"}},
+		{"large without old commands", StorageStats{FileSize: 300 << 20, Commands: 1000, CommandBytes: 300}, nil},
+		{"big wizard cache", StorageStats{FileSize: 8 << 20, Tables: []TableStorage{{Name: "llm_cache", Bytes: 3 << 20}}}, []string{"--clear-cache"}},
+		{"free pages", StorageStats{FileSize: 8 << 20, FreeBytes: 4 << 20}, []string{"VACUUM"}},
+		{"few free pages", StorageStats{FileSize: 2 << 20, FreeBytes: 512 << 10}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			recs := tt.stats.Recommendations("zist.db")
+			if len(recs) != len(tt.want) {
+				t.Fatalf("Recommendations() = %q, want %d", recs, len(tt.want))
+			}
+			for i, want := range tt.want {
+				if !strings.Contains(recs[i], want) {
+					t.Errorf("Recommendations()[%d] = %q, want it to mention %q", i, recs[i], want)
+				}
+			}
+		})
+	}
+}
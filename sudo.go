@@ -0,0 +1,200 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// What --sudo does with commands that likely need root
+const (
+	SudoHint = "hint" // Point them out, in wizard --json and the Ctrl+G widget
+	SudoAuto = "auto" // Also prepend sudo where history shows you usually do
+	SudoOff  = "off"  // Leave them be
+)
+
+// What --sudo-program sets for one program, overriding --sudo
+const (
+	SudoAlways  = "always"  // Prepend sudo whenever the command runs it
+	SudoNever   = "never"   // Never prepend sudo, nor point it out
+	SudoHistory = "history" // Prepend sudo when you've mostly run it with sudo
+)
+
+// SudoRules decide whether a generated or picked command gets sudo in front
+type SudoRules struct {
+	Mode     string
+	Programs map[string]string // Program to SudoAlways, SudoNever or SudoHistory, overriding Mode
+}
+
+// sudoRules are the rules from --sudo and --sudo-program
+var sudoRules = SudoRules{Mode: SudoHint}
+
+// ParseSudoRules checks mode and parses NAME=always|never|history settings
+func ParseSudoRules(mode string, programs []string) (SudoRules, error) {
+	if mode == "" {
+		mode = SudoHint
+	}
+	if mode != SudoHint && mode != SudoAuto && mode != SudoOff {
+		return SudoRules{}, fmt.Errorf("unknown --sudo %q (use %s, %s or %s)", mode, SudoHint, SudoAuto, SudoOff)
+	}
+	rules := SudoRules{Mode: mode, Programs: map[string]string{}}
+	for _, p := range programs {
+		if p == "" {
+			continue
+		}
+		name, setting, ok := strings.Cut(p, "=")
+		if !ok || name == "" || (setting != SudoAlways && setting != SudoNever && setting != SudoHistory) {
+			return SudoRules{}, fmt.Errorf("invalid --sudo-program %q (use NAME=%s, NAME=%s or NAME=%s)", p, SudoAlways, SudoNever, SudoHistory)
+		}
+		rules.Programs[name] = setting
+	}
+	return rules, nil
+}
+
+// packageManagers are the programs whose changing subcommands need root
+var packageManagers = map[string][]string{
+	"apt":     {"install", "remove", "purge", "update", "upgrade", "full-upgrade", "dist-upgrade", "autoremove", "reinstall"},
+	"apt-get": {"install", "remove", "purge", "update", "upgrade", "dist-upgrade", "autoremove", "reinstall"},
+	"dnf":     {"install", "remove", "erase", "update", "upgrade", "downgrade", "reinstall", "autoremove"},
+	"yum":     {"install", "remove", "erase", "update", "upgrade", "downgrade", "reinstall", "autoremove"},
+	"zypper":  {"install", "in", "remove", "rm", "update", "up", "dist-upgrade", "dup"},
+	"apk":     {"add", "del", "update", "upgrade"},
+	"snap":    {"install", "remove", "refresh"},
+}
+
+// rootPrograms always need root to do anything useful
+var rootPrograms = []string{
+	"mount", "umount", "modprobe", "insmod", "rmmod", "useradd", "userdel", "usermod",
+	"groupadd", "groupdel", "visudo", "iptables", "ip6tables", "nft", "ufw", "swapon", "swapoff", "chroot",
+}
+
+// systemPaths are where only root may write
+var systemPaths = []string{"/etc/", "/usr/", "/boot/", "/opt/", "/var/", "/lib/", "/lib64/", "/sbin/", "/bin/", "/srv/"}
+
+// pathWriters change the files they are given
+var pathWriters = []string{
+	"cp", "mv", "tee", "rm", "rmdir", "mkdir", "touch", "ln", "chmod", "chown", "chgrp",
+	"install", "truncate", "vi", "vim", "nvim", "nano", "emacs",
+}
+
+// NeedsRoot returns the program of command that likely needs root: a
+// package manager installing or removing, systemctl changing a service,
+// or something writing under /etc, /usr and the like. It is "" when nothing
+// does, or when command already runs it with sudo.
+func NeedsRoot(command string) string {
+	for _, seg := range splitShellCommands(command) {
+		if program := segmentNeedsRoot(seg); program != "" {
+			return program
+		}
+	}
+	return ""
+}
+
+// segmentNeedsRoot returns the program of one simple command if it likely
+// needs root
+func segmentNeedsRoot(seg shellSegment) string {
+	program, args, root := unwrapCommand(seg.words)
+	if root || program == "" {
+		return ""
+	}
+	if slices.ContainsFunc(seg.redirects, isSystemPath) {
+		return program
+	}
+	sub := ""
+	if len(args) > 0 {
+		sub = args[0]
+	}
+
+	switch {
+	case slices.Contains(packageManagers[program], sub),
+		program == "pacman" && len(args) > 0 && (strings.HasPrefix(sub, "-S") || strings.HasPrefix(sub, "-R") || strings.HasPrefix(sub, "-U")),
+		program == "dpkg" && slices.ContainsFunc(args, func(a string) bool {
+			return a == "-i" || a == "-r" || a == "-P" || a == "--install" || a == "--remove" || a == "--purge"
+		}),
+		program == "systemctl" && !slices.Contains(args, "--user") && slices.ContainsFunc(args, func(a string) bool {
+			return slices.Contains([]string{"start", "stop", "restart", "reload", "enable", "disable", "mask", "unmask", "daemon-reload", "edit"}, a)
+		}),
+		program == "service" && len(args) > 1,
+		program == "sysctl" && slices.Contains(args, "-w"),
+		slices.Contains(rootPrograms, program) && (program != "mount" || len(args) > 0),
+		(slices.Contains(pathWriters, program) || program == "sed" && slices.Contains(args, "-i")) && slices.ContainsFunc(args, isSystemPath):
+		return program
+	}
+	return ""
+}
+
+// isSystemPath reports whether path is under one of systemPaths
+func isSystemPath(path string) bool {
+	return slices.ContainsFunc(systemPaths, func(dir string) bool {
+		return strings.HasPrefix(path, dir) || path == strings.TrimSuffix(dir, "/")
+	})
+}
+
+// SudoHabit counts how often program was run with sudo in front and
+// without
+func SudoHabit(ctx context.Context, db *sql.DB, program string) (int64, int64, error) {
+	plain, withSudo := program+" ", "sudo "+program+" "
+	var sudoRuns, plainRuns int64
+	err := db.QueryRowContext(ctx, `SELECT
+			COUNT(CASE WHEN substr(command || ' ', 1, ?) = ? THEN 1 END),
+			COUNT(CASE WHEN substr(command || ' ', 1, ?) = ? THEN 1 END)
+		FROM commands`, len(withSudo), withSudo, len(plain), plain).Scan(&sudoRuns, &plainRuns)
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to count sudo runs: %w", err)
+	}
+	return sudoRuns, plainRuns, nil
+}
+
+// Apply prepends sudo to command if the rules call for it. Only a command
+// whose first program needs root is changed, since sudo in front of a
+// pipeline or list only covers what comes first.
+func (r SudoRules) Apply(ctx context.Context, db *sql.DB, command string) (string, error) {
+	segments := splitShellCommands(command)
+	if len(segments) == 0 {
+		return command, nil
+	}
+	program, _, root := unwrapCommand(segments[0].words)
+	if root || program == "" {
+		return command, nil
+	}
+
+	setting, ok := r.Programs[program]
+	if !ok {
+		if r.Mode != SudoAuto || segmentNeedsRoot(segments[0]) != program {
+			return command, nil
+		}
+		setting = SudoHistory
+	}
+	switch setting {
+	case SudoNever:
+		return command, nil
+	case SudoHistory:
+		sudoRuns, plainRuns, err := SudoHabit(ctx, db, program)
+		if err != nil || sudoRuns <= plainRuns {
+			return command, err
+		}
+	}
+	return "sudo " + strings.TrimLeft(command, " \t"), nil
+}
+
+// Hints reports whether the rules let a command that needs root be pointed
+// out
+func (r SudoRules) Hints(program string) bool {
+	return program != "" && r.Mode != SudoOff && r.Programs[program] != SudoNever
+}
+
+// Changes reports whether Apply might change any command, so callers can
+// skip opening the database when it won't
+func (r SudoRules) Changes() bool {
+	if r.Mode == SudoAuto {
+		return true
+	}
+	for _, setting := range r.Programs {
+		if setting != SudoNever {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+)
+
+func TestNeedsRoot(t *testing.T) {
+	tests := []struct {
+		command string
+		want    string
+	}{
+		{"apt install ripgrep", "apt"},
+		{"sudo apt install ripgrep", ""},
+		{"apt search ripgrep", ""},
+		{"pacman -Syu", "pacman"},
+		{"systemctl restart nginx", "systemctl"},
+		{"systemctl --user restart pipewire", ""},
+		{"systemctl status nginx", ""},
+		{"echo '127.0.0.1 dev' >> /etc/hosts", "echo"},
+		{"echo nameserver 1.1.1.1 | tee /etc/resolv.conf", "tee"},
+		{"vim /etc/nginx/nginx.conf", "vim"},
+		{"cp build/zist ~/bin/", ""},
+		{"mount /dev/sdb1 /mnt", "mount"},
+		{"mount", ""},
+		{"ls /etc", ""},
+	}
+	for _, tt := range tests {
+		if got := NeedsRoot(tt.command); got != tt.want {
+			t.Errorf("NeedsRoot(%q) = %q, want %q", tt.command, got, tt.want)
+		}
+	}
+}
+
+func TestParseSudoRules(t *testing.T) {
+	rules, err := ParseSudoRules(SudoAuto, []string{"docker=always", "apt=never", ""})
+	if err != nil || rules.Mode != SudoAuto || rules.Programs["docker"] != SudoAlways || rules.Programs["apt"] != SudoNever {
+		t.Errorf("ParseSudoRules() = %+v, %v", rules, err)
+	}
+	for _, bad := range [][2]string{{"sometimes", ""}, {SudoHint, "docker"}, {SudoHint, "docker=maybe"}, {SudoHint, "=always"}} {
+		if _, err := ParseSudoRules(bad[0], []string{bad[1]}); err == nil {
+			t.Errorf("ParseSudoRules(%q, %q) succeeded", bad[0], bad[1])
+		}
+	}
+}
+
+func TestSudoRulesApply(t *testing.T) {
+	ctx := context.Background()
+	db, err := InitDB(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("InitDB() error = %v", err)
+	}
+	defer db.Close()
+	// apt mostly with sudo, systemctl mostly without (a user unit, say)
+	commands := []Command{
+		{Source: "/h", Timestamp: 1, Command: "sudo apt install jq"},
+		{Source: "/h", Timestamp: 2, Command: "sudo apt update"},
+		{Source: "/h", Timestamp: 3, Command: "apt install fd"},
+		{Source: "/h", Timestamp: 4, Command: "systemctl restart foo"},
+		{Source: "/h", Timestamp: 5, Command: "sudo systemctl restart foo"},
+		{Source: "/h", Timestamp: 6, Command: "systemctl restart bar"},
+	}
+	if _, _, err := InsertCommands(ctx, db, commands); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		mode     string
+		programs []string
+		command  string
+		want     string
+	}{
+		{SudoAuto, nil, "apt install ripgrep", "sudo apt install ripgrep"},
+		{SudoAuto, nil, "systemctl restart nginx", "systemctl restart nginx"},
+		{SudoAuto, nil, "sudo apt install ripgrep", "sudo apt install ripgrep"},
+		{SudoAuto, nil, "apt search ripgrep", "apt search ripgrep"},
+		{SudoAuto, nil, "echo hi | apt install -y ripgrep", "echo hi | apt install -y ripgrep"},
+		{SudoHint, nil, "apt install ripgrep", "apt install ripgrep"},
+		{SudoAuto, []string{"apt=never"}, "apt install ripgrep", "apt install ripgrep"},
+		{SudoHint, []string{"docker=always"}, "docker ps", "sudo docker ps"},
+		{SudoOff, []string{"systemctl=always"}, "systemctl restart nginx", "sudo systemctl restart nginx"},
+		{SudoHint, []string{"apt=history"}, "apt install ripgrep", "sudo apt install ripgrep"},
+	}
+	for _, tt := range tests {
+		rules, err := ParseSudoRules(tt.mode, tt.programs)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := rules.Apply(ctx, db, tt.command)
+		if err != nil || got != tt.want {
+			t.Errorf("Apply(%q) with --sudo %s %q = %q, %v, want %q", tt.command, tt.mode, tt.programs, got, err, tt.want)
+		}
+	}
+}
@@ -0,0 +1,261 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// syncBatchSize is how many commands are pushed per request
+const syncBatchSize = 1000
+
+// syncBatchBytes caps the commands of a request by their length, half the
+// server's default MaxBodyBytes to leave room for JSON escaping. Batches a
+// server still refuses as too large are split.
+const syncBatchBytes = 4 << 20
+
+// syncCommandOverhead is about what a pushed command's JSON adds to its
+// source and text: keys, numbers and the host prefix
+const syncCommandOverhead = 128
+
+// ErrRequestTooLarge is returned when the server refuses a request as too
+// large, with 413
+var ErrRequestTooLarge = errors.New("request too large for server")
+
+// SyncClient pushes local history to a zist server
+type SyncClient struct {
+	Server string
+	Token  string
+	Client *http.Client
+}
+
+// GetSyncCursor returns the last local rowid pushed to server
+func GetSyncCursor(ctx context.Context, db *sql.DB, server string) (int64, error) {
+	var rowid int64
+	err := db.QueryRowContext(ctx, `SELECT last_rowid FROM sync_state WHERE server = ?`, server).Scan(&rowid)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to get sync state: %w", err)
+	}
+	return rowid, nil
+}
+
+// SetSyncCursor records the last local rowid pushed to server
+func SetSyncCursor(ctx context.Context, db *sql.DB, server string, rowid int64) error {
+	_, err := db.ExecContext(ctx, `INSERT INTO sync_state (server, last_rowid, last_synced) VALUES (?, ?, ?)
+		ON CONFLICT(server) DO UPDATE SET last_rowid = excluded.last_rowid, last_synced = excluded.last_synced`,
+		server, rowid, float64(time.Now().Unix()))
+	if err != nil {
+		return fmt.Errorf("failed to set sync state: %w", err)
+	}
+	return nil
+}
+
+// commandsAfter returns up to limit commands inserted after rowid, in
+// insertion order, and the rowid of each. It stops early once they add up
+// to maxBytes, but always returns at least one if there is one.
+func commandsAfter(ctx context.Context, db *sql.DB, rowid int64, limit, maxBytes int) ([]APICommand, []int64, error) {
+	// Truncated commands are pushed whole; the server applies its own limit
+	rows, err := db.QueryContext(ctx, `SELECT c.rowid, c.source, c.timestamp, COALESCE(o.command, c.command), c.env_prefix, COALESCE(c.duration, 0), c.seq
+		FROM commands c LEFT JOIN command_overflow o ON o.source = c.source AND o.timestamp = c.timestamp
+		WHERE c.rowid > ? ORDER BY c.rowid LIMIT ?`, rowid, limit)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read commands: %w", err)
+	}
+	defer rows.Close()
+
+	var commands []APICommand
+	var rowids []int64
+	size := 0
+	for rows.Next() {
+		var c APICommand
+		var id int64
+		if err := rows.Scan(&id, &c.Source, &c.Timestamp, &c.Command, &c.EnvPrefix, &c.Duration, &c.Seq); err != nil {
+			return nil, nil, fmt.Errorf("failed to scan command: %w", err)
+		}
+		size += len(c.Source) + len(c.Command) + len(c.EnvPrefix) + syncCommandOverhead
+		if len(commands) > 0 && size > maxBytes {
+			break
+		}
+		commands = append(commands, c)
+		rowids = append(rowids, id)
+	}
+
+	return commands, rowids, rows.Err()
+}
+
+// Push sends every command not yet pushed to the server. Sources are
+// prefixed with host so histories from several machines stay apart. Also
+// returns the sources the server found commands from the future in.
+func (c *SyncClient) Push(ctx context.Context, db *sql.DB, host string) (int, []ClockSkew, error) {
+	cursor, err := GetSyncCursor(ctx, db, c.Server)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	pushed, limit := 0, syncBatchSize
+	var skews []ClockSkew
+	for {
+		commands, rowids, err := commandsAfter(ctx, db, cursor, limit, syncBatchBytes)
+		if err != nil {
+			return pushed, skews, err
+		}
+		if len(commands) == 0 {
+			return pushed, skews, nil
+		}
+
+		for i := range commands {
+			commands[i].Source = host + ":" + commands[i].Source
+		}
+
+		var result PushResult
+		n, err := c.postCommands(ctx, "/api/v1/commands", commands, &result)
+		if err != nil {
+			return pushed, skews, err
+		}
+		if err := SetSyncCursor(ctx, db, c.Server, rowids[n-1]); err != nil {
+			return pushed, skews, err
+		}
+		skews = mergeSkews(skews, result.ClockSkew)
+
+		pushed += n
+		cursor = rowids[n-1]
+		if n < len(commands) {
+			limit = n
+		}
+	}
+}
+
+// mergeSkews adds skews to seen, keeping the largest of each source
+func mergeSkews(seen, skews []ClockSkew) []ClockSkew {
+next:
+	for _, skew := range skews {
+		for i := range seen {
+			if seen[i].Source == skew.Source {
+				seen[i].AheadSeconds = max(seen[i].AheadSeconds, skew.AheadSeconds)
+				continue next
+			}
+		}
+		seen = append(seen, skew)
+	}
+	return seen
+}
+
+// Diff asks the server what pushing every command not yet pushed would do,
+// without pushing anything
+func (c *SyncClient) Diff(ctx context.Context, db *sql.DB, host string) ([]DiffBucket, error) {
+	cursor, err := GetSyncCursor(ctx, db, c.Server)
+	if err != nil {
+		return nil, err
+	}
+
+	limit := syncBatchSize
+	var diffs [][]DiffBucket
+	for {
+		commands, rowids, err := commandsAfter(ctx, db, cursor, limit, syncBatchBytes)
+		if err != nil {
+			return nil, err
+		}
+		if len(commands) == 0 {
+			return MergeDiff(diffs...), nil
+		}
+
+		for i := range commands {
+			commands[i].Source = host + ":" + commands[i].Source
+		}
+
+		var diff []DiffBucket
+		n, err := c.postCommands(ctx, "/api/v1/commands/diff", commands, &diff)
+		if err != nil {
+			return nil, err
+		}
+		diffs = append(diffs, diff)
+		cursor = rowids[n-1]
+		if n < len(commands) {
+			limit = n
+		}
+	}
+}
+
+// postCommands posts commands to path, halving them while the server
+// refuses them as too large, and returns how many of the first it sent. A
+// single command still too large is sent cut to maxCommandLength, as
+// stored locally.
+func (c *SyncClient) postCommands(ctx context.Context, path string, commands []APICommand, out interface{}) (int, error) {
+	for {
+		err := c.post(ctx, path, commands, out)
+		if !errors.Is(err, ErrRequestTooLarge) {
+			return len(commands), err
+		}
+		if len(commands) > 1 {
+			commands = commands[:len(commands)/2]
+			continue
+		}
+		command, truncated := truncateCommand(commands[0].Command, maxCommandLength)
+		if !truncated {
+			return 0, err
+		}
+		commands[0].Command = command
+	}
+}
+
+// post sends body as JSON and decodes the response into out, unless out is nil
+func (c *SyncClient) post(ctx context.Context, path string, body, out interface{}) error {
+	payload, err := json.Marshal(body)
+	if err != nil {
+		return fmt.Errorf("failed to encode request: %w", err)
+	}
+
+	url := strings.TrimRight(c.Server, "/") + path
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(payload))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+c.Token)
+	req.Header.Set("Content-Type", "application/json")
+
+	client := c.Client
+	if client == nil {
+		client = &http.Client{Timeout: 60 * time.Second}
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to reach server: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		var apiErr struct {
+			Error string `json:"error"`
+		}
+		json.NewDecoder(resp.Body).Decode(&apiErr)
+		if resp.StatusCode == http.StatusRequestEntityTooLarge {
+			return fmt.Errorf("%w: %s", ErrRequestTooLarge, apiErr.Error)
+		}
+		return fmt.Errorf("server returned %s: %s", resp.Status, apiErr.Error)
+	}
+	if out != nil {
+		if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+			return fmt.Errorf("failed to decode response: %w", err)
+		}
+	}
+	return nil
+}
+
+// syncHostname names this machine in pushed sources
+func syncHostname() string {
+	host, err := os.Hostname()
+	if err != nil || host == "" {
+		return "unknown"
+	}
+	return host
+}
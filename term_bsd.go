@@ -0,0 +1,11 @@
+//go:build darwin || freebsd
+
+package main
+
+import "syscall"
+
+// ioctl requests reading and setting terminal attributes
+const (
+	ioctlGetTermios = syscall.TIOCGETA
+	ioctlSetTermios = syscall.TIOCSETA
+)
@@ -0,0 +1,20 @@
+//go:build !(linux || darwin || freebsd)
+
+package main
+
+import (
+	"errors"
+	"os"
+)
+
+var errNoRawTerminal = errors.New("the internal picker isn't supported on this platform, please install fzf")
+
+// rawTerminal isn't available here, so the internal picker can't run
+func rawTerminal(f *os.File) (func() error, error) {
+	return nil, errNoRawTerminal
+}
+
+// terminalSize isn't available here
+func terminalSize(f *os.File) (int, int, error) {
+	return 0, 0, errNoRawTerminal
+}
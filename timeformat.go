@@ -0,0 +1,108 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Time formats for --time-format. Anything else containing 2006 is taken
+// as a Go layout.
+const (
+	TimeFormatDefault = "default" // 2006-01-02 15:04:05
+	TimeFormatISO     = "iso"     // ISO-8601 with the UTC offset
+	TimeFormatLocale  = "locale"  // Date order and clock of LC_ALL, LC_TIME or LANG
+	TimeFormatEpoch   = "epoch"   // Unix seconds
+)
+
+// recordTimeLayout is how timestamps are written where zist reads them
+// back, like the picker's records, whatever --time-format says
+const recordTimeLayout = "2006-01-02 15:04:05"
+
+// timeLayout is the Go layout FormatTimestamp uses, or "" for epoch
+// seconds. It is set from --time-format.
+var timeLayout = recordTimeLayout
+
+// localeLayouts are date and time layouts for locales that don't write
+// dates year first, by language_TERRITORY or language. Anything not here
+// falls back to the default.
+var localeLayouts = map[string]string{
+	"en_US": "01/02/2006 03:04:05 PM",
+	"en_PH": "01/02/2006 03:04:05 PM",
+	"en":    "02/01/2006 15:04:05",
+	"es":    "02/01/2006 15:04:05",
+	"fr":    "02/01/2006 15:04:05",
+	"it":    "02/01/2006 15:04:05",
+	"pt":    "02/01/2006 15:04:05",
+	"nl":    "02-01-2006 15:04:05",
+	"de":    "02.01.2006 15:04:05",
+	"ru":    "02.01.2006 15:04:05",
+	"pl":    "02.01.2006 15:04:05",
+	"cs":    "02.01.2006 15:04:05",
+	"fi":    "02.01.2006 15:04:05",
+	"nb":    "02.01.2006 15:04:05",
+	"tr":    "02.01.2006 15:04:05",
+	"uk":    "02.01.2006 15:04:05",
+	"ja":    "2006/01/02 15:04:05",
+	"zh":    "2006/01/02 15:04:05",
+	"ko":    "2006. 01. 02. 15:04:05",
+}
+
+// parseTimeFormat returns the layout for a --time-format value, reading
+// the locale from the environment for "locale"
+func parseTimeFormat(format string) (string, error) {
+	switch format {
+	case TimeFormatDefault, "":
+		return recordTimeLayout, nil
+	case TimeFormatISO:
+		return time.RFC3339, nil
+	case TimeFormatEpoch:
+		return "", nil
+	case TimeFormatLocale:
+		return localeLayout(localeName()), nil
+	}
+	if strings.Contains(format, "2006") {
+		return format, nil
+	}
+	return "", fmt.Errorf("invalid time format %q (use default, iso, locale, epoch or a Go layout such as \"Jan 2 2006 15:04\")", format)
+}
+
+// localeName is the locale used for times, as the C library picks it
+func localeName() string {
+	for _, name := range []string{"LC_ALL", "LC_TIME", "LANG"} {
+		if value := os.Getenv(name); value != "" {
+			return value
+		}
+	}
+	return ""
+}
+
+// localeLayout returns the layout for a locale such as de_DE.UTF-8
+func localeLayout(locale string) string {
+	locale, _, _ = strings.Cut(locale, ".")
+	locale, _, _ = strings.Cut(locale, "@")
+	if layout, ok := localeLayouts[locale]; ok {
+		return layout
+	}
+	language, _, _ := strings.Cut(locale, "_")
+	if layout, ok := localeLayouts[language]; ok {
+		return layout
+	}
+	return recordTimeLayout
+}
+
+// FormatTimestamp formats ts for people to read, as --time-format says
+func FormatTimestamp(ts float64) string {
+	if timeLayout == "" {
+		return strconv.FormatFloat(ts, 'f', -1, 64)
+	}
+	return unixTime(ts).Format(timeLayout)
+}
+
+// JSONTimestamp formats ts as RFC 3339 for JSON output, which is read by
+// programs rather than people, so it ignores --time-format
+func JSONTimestamp(ts float64) string {
+	return unixTime(ts).Format(time.RFC3339)
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseTimeFormat(t *testing.T) {
+	tests := []struct {
+		format string
+		locale string
+		want   string
+	}{
+		{TimeFormatDefault, "", "2006-01-02 15:04:05"},
+		{"", "", "2006-01-02 15:04:05"},
+		{TimeFormatISO, "", time.RFC3339},
+		{TimeFormatEpoch, "", ""},
+		{TimeFormatLocale, "en_US.UTF-8", "01/02/2006 03:04:05 PM"},
+		{TimeFormatLocale, "en_GB.UTF-8", "02/01/2006 15:04:05"},
+		{TimeFormatLocale, "de_DE.UTF-8@euro", "02.01.2006 15:04:05"},
+		{TimeFormatLocale, "ja_JP", "2006/01/02 15:04:05"},
+		{TimeFormatLocale, "C", "2006-01-02 15:04:05"},
+		{TimeFormatLocale, "", "2006-01-02 15:04:05"},
+		{"Jan 2 2006 15:04", "", "Jan 2 2006 15:04"},
+	}
+	for _, tt := range tests {
+		t.Setenv("LC_ALL", "")
+		t.Setenv("LC_TIME", tt.locale)
+		t.Setenv("LANG", "fr_FR.UTF-8")
+		if tt.locale == "" {
+			t.Setenv("LANG", "")
+		}
+		got, err := parseTimeFormat(tt.format)
+		if err != nil || got != tt.want {
+			t.Errorf("parseTimeFormat(%q) with LC_TIME=%q = %q, %v, want %q", tt.format, tt.locale, got, err, tt.want)
+		}
+	}
+	for _, format := range []string{"rfc", "15:04"} {
+		if _, err := parseTimeFormat(format); err == nil {
+			t.Errorf("parseTimeFormat(%q) succeeded", format)
+		}
+	}
+}
+
+func TestFormatTimestampLayouts(t *testing.T) {
+	t.Cleanup(func() { timeLayout = recordTimeLayout })
+	ts := 1700000000.25
+	tests := []struct {
+		layout string
+		want   string
+	}{
+		{recordTimeLayout, time.Unix(1700000000, 0).Format("2006-01-02 15:04:05")},
+		{time.RFC3339, time.Unix(1700000000, 0).Format(time.RFC3339)},
+		{"", "1700000000.25"},
+	}
+	for _, tt := range tests {
+		timeLayout = tt.layout
+		if got := FormatTimestamp(ts); got != tt.want {
+			t.Errorf("FormatTimestamp() with layout %q = %q, want %q", tt.layout, got, tt.want)
+		}
+	}
+
+	timeLayout = ""
+	if parsed, err := time.Parse(time.RFC3339, JSONTimestamp(ts)); err != nil || parsed.Unix() != 1700000000 {
+		t.Errorf("JSONTimestamp() = %q, want RFC 3339 whatever the layout", JSONTimestamp(ts))
+	}
+}
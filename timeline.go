@@ -0,0 +1,275 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"database/sql"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+// timelineSessionGap is the idle time after which a source's next command
+// starts a new session
+const timelineSessionGap = 30 * time.Minute
+
+// Values of timeline --format
+const (
+	TimelineTUI      = "tui"
+	TimelineText     = "text"
+	TimelineMarkdown = "markdown"
+)
+
+// TimelineOptions selects the commands a timeline shows
+type TimelineOptions struct {
+	Dir    string  // Commands run in this directory or below it, empty for every command
+	Since  float64 // Unix timestamp, 0 means no filter
+	Until  float64 // Unix timestamp, 0 means no filter
+	Source string  // Only sources containing this, empty means all
+}
+
+// TimelineEntry is a command in a timeline
+type TimelineEntry struct {
+	SearchResult
+	Dir      string // Where it ran, recorded or followed from cd commands
+	CWD      string // Where it started as the history recorded, "" if it didn't
+	ExitCode int
+	Duration int // Seconds
+}
+
+// TimelineSession is a burst of work on one source
+type TimelineSession struct {
+	Source  string
+	Start   float64
+	End     float64
+	Entries []TimelineEntry
+}
+
+// GetTimeline returns the commands run in opts.Dir, grouped into sessions,
+// oldest first. History rarely records the working directory, so it is
+// followed through each session's cd commands where it wasn't; commands
+// before the first cd of a session have no known directory and are left out
+// unless opts.Dir is empty.
+func GetTimeline(ctx context.Context, db *sql.DB, opts TimelineOptions) ([]TimelineSession, error) {
+	query := `SELECT c.rowid, c.source, c.timestamp, c.command, c.env_prefix, COALESCE(c.cwd, ''), COALESCE(c.exit_code, 0),
+		COALESCE(c.duration, 0), COALESCE(n.note, ''), ` + linksColumn("main", "c") + `
+		FROM commands c LEFT JOIN notes n ON n.source = c.source AND n.timestamp = c.timestamp WHERE 1=1`
+	var args []interface{}
+	if opts.Since > 0 {
+		query += " AND c.timestamp >= ?"
+		args = append(args, opts.Since)
+	}
+	if opts.Until > 0 {
+		query += " AND c.timestamp <= ?"
+		args = append(args, opts.Until)
+	}
+	if opts.Source != "" {
+		query += " AND c.source LIKE ?"
+		args = append(args, "%"+opts.Source+"%")
+	}
+	query += " ORDER BY c.source, c.timestamp, c.seq"
+
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query timeline: %w", err)
+	}
+	defer rows.Close()
+
+	home, _ := os.UserHomeDir()
+	var sessions []TimelineSession
+	var session *TimelineSession
+	var dir, prevDir string
+	for rows.Next() {
+		var e TimelineEntry
+		var links string
+		if err := rows.Scan(&e.ID, &e.Source, &e.Timestamp, &e.Command, &e.EnvPrefix, &e.CWD, &e.ExitCode, &e.Duration, &e.Note, &links); err != nil {
+			return nil, fmt.Errorf("failed to scan command: %w", err)
+		}
+		e.Links = splitLinks(links)
+
+		if session == nil || session.Source != e.Source || e.Timestamp-session.End > timelineSessionGap.Seconds() {
+			if session != nil && len(session.Entries) > 0 {
+				sessions = append(sessions, *session)
+			}
+			session = &TimelineSession{Source: e.Source, Start: e.Timestamp}
+			dir, prevDir = "", ""
+		}
+		session.End = e.Timestamp
+
+		if e.CWD != "" {
+			dir = e.CWD
+		}
+		// A cd belongs to the directory it goes to
+		dir, prevDir = followCD(e.Command, dir, prevDir, home)
+		e.Dir = dir
+		if opts.Dir == "" || (dir != "" && within(dir, opts.Dir)) {
+			if len(session.Entries) == 0 {
+				session.Start = e.Timestamp
+			}
+			session.Entries = append(session.Entries, e)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating timeline: %w", err)
+	}
+	if session != nil && len(session.Entries) > 0 {
+		sessions = append(sessions, *session)
+	}
+
+	for i := range sessions {
+		s := &sessions[i]
+		s.End = s.Entries[len(s.Entries)-1].Timestamp
+	}
+	slices.SortStableFunc(sessions, func(a, b TimelineSession) int { return cmp.Compare(a.Start, b.Start) })
+	return sessions, nil
+}
+
+// followCD returns the working directory and the previous one (for cd -)
+// after command, given them before it. Every cd or pushd in a && or ; chain
+// is followed; an unknown directory is "".
+func followCD(command, dir, prevDir, home string) (string, string) {
+	for _, step := range strings.FieldsFunc(command, func(r rune) bool { return r == ';' || r == '&' || r == '\n' }) {
+		fields := strings.Fields(step)
+		if len(fields) == 0 || (fields[0] != "cd" && fields[0] != "pushd") {
+			continue
+		}
+		target := "~"
+		if len(fields) > 1 {
+			target = strings.Trim(strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(step), fields[0])), `"'`)
+		}
+		next := ""
+		switch {
+		case target == "-":
+			next = prevDir
+		case target == "~" || strings.HasPrefix(target, "~/"):
+			if home != "" {
+				next = filepath.Join(home, strings.TrimPrefix(target, "~"))
+			}
+		case filepath.IsAbs(target):
+			next = filepath.Clean(target)
+		case strings.ContainsAny(target, "$`~"):
+			// Variables and other users' homes can't be resolved afterwards
+		case dir != "":
+			next = filepath.Join(dir, target)
+		}
+		dir, prevDir = next, dir
+	}
+	return dir, prevDir
+}
+
+// timelineCommands counts the commands in sessions
+func timelineCommands(sessions []TimelineSession) int {
+	n := 0
+	for _, s := range sessions {
+		n += len(s.Entries)
+	}
+	return n
+}
+
+// timelineClock formats the time of day of a Unix timestamp
+func timelineClock(ts float64) string {
+	return time.Unix(int64(ts), 0).Format("15:04")
+}
+
+// timelineSpan formats when a session ran, e.g. 2026-10-14 09:12-09:48, or
+// from one --time-format time to another
+func timelineSpan(s TimelineSession) string {
+	if timeLayout != recordTimeLayout {
+		return FormatTimestamp(s.Start) + " to " + FormatTimestamp(s.End)
+	}
+	return time.Unix(int64(s.Start), 0).Format("2006-01-02 15:04") + "-" + timelineClock(s.End)
+}
+
+// timelineOutcome describes how an entry finished, "" for a quick success
+func timelineOutcome(e TimelineEntry) string {
+	var parts []string
+	if e.ExitCode != 0 {
+		parts = append(parts, fmt.Sprintf("exit %d", e.ExitCode))
+	}
+	if e.Duration > 0 {
+		parts = append(parts, (time.Duration(e.Duration) * time.Second).String())
+	}
+	return strings.Join(parts, ", ")
+}
+
+// writeTimelineText prints sessions for a terminal. With color, session
+// headers take the color of their source and failed commands are red.
+func writeTimelineText(w io.Writer, sessions []TimelineSession, color bool) {
+	for i, s := range sessions {
+		if i > 0 {
+			fmt.Fprintln(w)
+		}
+		header := fmt.Sprintf("%s %s  %s (%d command(s))", symbol("──", "--"), timelineSpan(s), s.Source, len(s.Entries))
+		if color {
+			header = colorSource(header, s.Source)
+		}
+		fmt.Fprintln(w, header)
+		for _, e := range s.Entries {
+			command := strings.ReplaceAll(e.FullCommand(), "\n", "\n         ")
+			if outcome := timelineOutcome(e); outcome != "" {
+				if color && e.ExitCode != 0 {
+					outcome = "\x1b[31m" + outcome + "\x1b[0m"
+				}
+				command += "  [" + outcome + "]"
+			}
+			fmt.Fprintf(w, "  %s  %s\n", timelineClock(e.Timestamp), command)
+			if e.Note != "" {
+				fmt.Fprintf(w, "         note: %s\n", strings.ReplaceAll(e.Note, "\n", "\n               "))
+			}
+			for _, link := range e.Links {
+				fmt.Fprintf(w, "         link: %s\n", link)
+			}
+		}
+	}
+}
+
+// writeTimelineMarkdown prints sessions as a markdown document, ready to
+// paste into a status update or postmortem
+func writeTimelineMarkdown(w io.Writer, sessions []TimelineSession, dir, period string) {
+	fmt.Fprintf(w, "# Timeline of %s\n\n", dir)
+	fmt.Fprintf(w, "%s: %d session(s), %d command(s)\n", period, len(sessions), timelineCommands(sessions))
+	for _, s := range sessions {
+		fmt.Fprintf(w, "\n## %s on %s\n\n", timelineSpan(s), s.Source)
+		for _, e := range s.Entries {
+			command := e.FullCommand()
+			outcome := timelineOutcome(e)
+			if outcome != "" {
+				outcome = " (" + outcome + ")"
+			}
+			if strings.Contains(command, "\n") || strings.Contains(command, "`") {
+				fmt.Fprintf(w, "- %s%s\n\n  ```sh\n  %s\n  ```\n\n", timelineClock(e.Timestamp), outcome, strings.ReplaceAll(command, "\n", "\n  "))
+			} else {
+				fmt.Fprintf(w, "- %s `%s`%s\n", timelineClock(e.Timestamp), command, outcome)
+			}
+			if e.Note != "" {
+				fmt.Fprintf(w, "  > %s\n", strings.ReplaceAll(e.Note, "\n", "\n  > "))
+			}
+			for _, link := range e.Links {
+				fmt.Fprintf(w, "  - %s\n", link)
+			}
+		}
+	}
+}
+
+// timelineRecords turns sessions into picker rows: a header row per
+// session, which picks nothing, followed by its commands
+func timelineRecords(sessions []TimelineSession) []SearchResult {
+	var records []SearchResult
+	for _, s := range sessions {
+		records = append(records, SearchResult{
+			Source:    s.Source,
+			Timestamp: s.Start,
+			Badge:     fmt.Sprintf("%s %s (%d)", symbol("──", "--"), timelineSpan(s), len(s.Entries)),
+		})
+		for _, e := range s.Entries {
+			r := e.SearchResult
+			r.Badge = "  " + timelineClock(e.Timestamp)
+			records = append(records, r)
+		}
+	}
+	return records
+}
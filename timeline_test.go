@@ -0,0 +1,103 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestFollowCD(t *testing.T) {
+	tests := []struct {
+		command      string
+		dir, prevDir string
+		wantDir      string
+		wantPrev     string
+	}{
+		{"ls -la", "/src/api", "", "/src/api", ""},
+		{"cd ~/src/api", "", "", "/home/me/src/api", ""},
+		{"cd", "/src/api", "", "/home/me", "/src/api"},
+		{"cd /tmp", "/src/api", "", "/tmp", "/src/api"},
+		{"cd ..", "/src/api", "", "/src", "/src/api"},
+		{"cd web", "/src", "", "/src/web", "/src"},
+		{"cd web", "", "", "", ""},
+		{"cd -", "/tmp", "/src/api", "/src/api", "/tmp"},
+		{`cd "/src/my app"`, "", "", "/src/my app", ""},
+		{"cd $GOPATH/src", "/src/api", "", "", "/src/api"},
+		{"cd /src && make test", "", "", "/src", ""},
+		{"git pull; cd api && go test ./...", "/src", "", "/src/api", "/src"},
+		{"pushd /srv", "/src", "", "/srv", "/src"},
+	}
+	for _, tt := range tests {
+		dir, prev := followCD(tt.command, tt.dir, tt.prevDir, "/home/me")
+		if dir != tt.wantDir || prev != tt.wantPrev {
+			t.Errorf("followCD(%q, %q, %q) = %q, %q, want %q, %q", tt.command, tt.dir, tt.prevDir, dir, prev, tt.wantDir, tt.wantPrev)
+		}
+	}
+}
+
+func TestGetTimeline(t *testing.T) {
+	ctx := context.Background()
+	t.Setenv("HOME", "/home/me")
+	db, err := InitDB(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("InitDB() error = %v", err)
+	}
+	defer db.Close()
+
+	commands := []Command{
+		// A session on the laptop that moves into the project
+		{Source: "/laptop", Timestamp: 1000, Command: "ls", Seq: 1},
+		{Source: "/laptop", Timestamp: 1010, Command: "cd ~/src/api", Seq: 2},
+		{Source: "/laptop", Timestamp: 1020, Command: "go test ./...", ExitCode: 1, Seq: 3},
+		{Source: "/laptop", Timestamp: 1030, Command: "cd ../web", Seq: 4},
+		{Source: "/laptop", Timestamp: 1040, Command: "npm test", Seq: 5},
+		// An hour later the directory isn't known any more
+		{Source: "/laptop", Timestamp: 1040 + 3600, Command: "make", Seq: 6},
+		// A recorded directory needs no cd
+		{Source: "/server", Timestamp: 1005, Command: "git pull", CWD: "/home/me/src/api/deploy", Seq: 1},
+		{Source: "/server", Timestamp: 9000, Command: "git log", CWD: "/home/me/src/api", Seq: 2},
+	}
+	if _, _, err := InsertCommands(ctx, db, commands); err != nil {
+		t.Fatal(err)
+	}
+
+	sessions, err := GetTimeline(ctx, db, TimelineOptions{Dir: "/home/me/src/api"})
+	if err != nil {
+		t.Fatalf("GetTimeline() error = %v", err)
+	}
+	var got []string
+	for _, s := range sessions {
+		var line []string
+		for _, e := range s.Entries {
+			line = append(line, e.Command)
+		}
+		got = append(got, s.Source+": "+strings.Join(line, ", "))
+	}
+	want := []string{"/server: git pull", "/laptop: cd ~/src/api, go test ./...", "/server: git log"}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("GetTimeline() sessions =\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+	if len(sessions) > 1 && (sessions[1].Start != 1010 || sessions[1].End != 1020) {
+		t.Errorf("laptop session runs %v-%v, want 1010-1020", sessions[1].Start, sessions[1].End)
+	}
+
+	sessions, err = GetTimeline(ctx, db, TimelineOptions{Dir: "/home/me/src/api", Since: 2000})
+	if err != nil || len(sessions) != 1 {
+		t.Errorf("GetTimeline(since) = %+v, %v, want the last session only", sessions, err)
+	}
+
+	var out bytes.Buffer
+	writeTimelineMarkdown(&out, sessions, "/home/me/src/api", "All time")
+	for _, want := range []string{"# Timeline of /home/me/src/api", "1 session(s), 1 command(s)", "## ", "`git log`"} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("writeTimelineMarkdown() missing %q:\n%s", want, out.String())
+		}
+	}
+
+	records := timelineRecords(sessions)
+	if len(records) != 2 || records[0].Command != "" || records[1].Command != "git log" {
+		t.Errorf("timelineRecords() = %+v, want a header row then the command", records)
+	}
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// ServerTLSOptions configures TLS for serve mode. Either provided cert files
+// or ACME domains enable TLS; a client CA additionally requires mutual TLS.
+type ServerTLSOptions struct {
+	CertFile     string
+	KeyFile      string
+	ACMEDomains  []string // Let's Encrypt certificates for these hosts
+	ACMECacheDir string
+	ACMEEmail    string
+	ClientCAFile string // Require client certificates signed by this CA
+}
+
+// Enabled reports whether any TLS option is set
+func (o ServerTLSOptions) Enabled() bool {
+	return o.CertFile != "" || o.KeyFile != "" || len(o.ACMEDomains) > 0 || o.ClientCAFile != ""
+}
+
+// Config builds the server TLS config, or returns nil if TLS is off
+func (o ServerTLSOptions) Config() (*tls.Config, error) {
+	if !o.Enabled() {
+		return nil, nil
+	}
+
+	var cfg *tls.Config
+	switch {
+	case len(o.ACMEDomains) > 0 && o.CertFile != "":
+		return nil, fmt.Errorf("use either --tls-cert/--tls-key or --acme-domain, not both")
+	case len(o.ACMEDomains) > 0:
+		manager := &autocert.Manager{
+			Prompt:     autocert.AcceptTOS,
+			HostPolicy: autocert.HostWhitelist(o.ACMEDomains...),
+			Cache:      autocert.DirCache(expandTilde(o.ACMECacheDir)),
+			Email:      o.ACMEEmail,
+		}
+		cfg = manager.TLSConfig()
+	case o.CertFile != "" && o.KeyFile != "":
+		cert, err := tls.LoadX509KeyPair(expandTilde(o.CertFile), expandTilde(o.KeyFile))
+		if err != nil {
+			return nil, fmt.Errorf("failed to load TLS certificate: %w", err)
+		}
+		cfg = &tls.Config{Certificates: []tls.Certificate{cert}}
+	default:
+		return nil, fmt.Errorf("TLS needs --tls-cert and --tls-key, or --acme-domain")
+	}
+	cfg.MinVersion = tls.VersionTLS12
+
+	if o.ClientCAFile != "" {
+		pool, err := loadCertPool(o.ClientCAFile)
+		if err != nil {
+			return nil, err
+		}
+		cfg.ClientCAs = pool
+		cfg.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+
+	return cfg, nil
+}
+
+// ClientTLSOptions configures how sync clients verify the server and
+// present a certificate for mutual TLS
+type ClientTLSOptions struct {
+	CAFile   string // Trust this CA in addition to the system roots
+	CertFile string
+	KeyFile  string
+}
+
+// Config builds the client TLS config, or returns nil for the defaults
+func (o ClientTLSOptions) Config() (*tls.Config, error) {
+	if o.CAFile == "" && o.CertFile == "" && o.KeyFile == "" {
+		return nil, nil
+	}
+
+	cfg := &tls.Config{MinVersion: tls.VersionTLS12}
+
+	if o.CAFile != "" {
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		pem, err := os.ReadFile(expandTilde(o.CAFile))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CA certificate: %w", err)
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", o.CAFile)
+		}
+		cfg.RootCAs = pool
+	}
+
+	if o.CertFile != "" || o.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(expandTilde(o.CertFile), expandTilde(o.KeyFile))
+		if err != nil {
+			return nil, fmt.Errorf("failed to load client certificate: %w", err)
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+
+	return cfg, nil
+}
+
+func loadCertPool(path string) (*x509.CertPool, error) {
+	pem, err := os.ReadFile(expandTilde(path))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CA certificate: %w", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no certificates found in %s", path)
+	}
+	return pool, nil
+}
+
+// defaultACMECacheDir keeps issued certificates next to the server data
+func defaultACMECacheDir(dataDir string) string {
+	return filepath.Join(dataDir, "acme")
+}
+
+// CheckTransport refuses to send history over plain HTTP to anything but
+// loopback, since it would cross the network unencrypted
+func CheckTransport(serverURL string, allowHTTP bool) error {
+	u, err := url.Parse(serverURL)
+	if err != nil {
+		return fmt.Errorf("invalid server URL: %w", err)
+	}
+
+	switch u.Scheme {
+	case "https":
+		return nil
+	case "http":
+		if allowHTTP || isLoopback(u.Hostname()) {
+			return nil
+		}
+		return fmt.Errorf("refusing to send history over plain HTTP to %s (use https, or --allow-http if you really mean it)", u.Host)
+	default:
+		return fmt.Errorf("unsupported server URL scheme %q", u.Scheme)
+	}
+}
+
+// isLoopback reports whether host (a name or IP, without port) is local
+func isLoopback(host string) bool {
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
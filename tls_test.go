@@ -0,0 +1,183 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeTestCert issues a certificate signed by parent (self-signed if nil)
+// and writes cert and key PEM files into dir
+func writeTestCert(t *testing.T, dir, name string, template *x509.Certificate, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parent == nil {
+		parent, parentKey = template, key
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+	if err := os.WriteFile(filepath.Join(dir, name+".pem"), certPEM, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, name+"-key.pem"), keyPEM, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	return cert, key
+}
+
+func TestMutualTLS(t *testing.T) {
+	dir := t.TempDir()
+	notAfter := time.Now().Add(time.Hour)
+
+	ca, caKey := writeTestCert(t, dir, "ca", &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "zist test CA"},
+		NotAfter:              notAfter,
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}, nil, nil)
+	writeTestCert(t, dir, "server", &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "server"},
+		NotAfter:     notAfter,
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+	}, ca, caKey)
+	writeTestCert(t, dir, "client", &x509.Certificate{
+		SerialNumber: big.NewInt(3),
+		Subject:      pkix.Name{CommonName: "laptop"},
+		NotAfter:     notAfter,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+	}, ca, caKey)
+
+	serverTLS, err := ServerTLSOptions{
+		CertFile:     filepath.Join(dir, "server.pem"),
+		KeyFile:      filepath.Join(dir, "server-key.pem"),
+		ClientCAFile: filepath.Join(dir, "ca.pem"),
+	}.Config()
+	if err != nil {
+		t.Fatalf("ServerTLSOptions.Config() error = %v", err)
+	}
+
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	ts.TLS = serverTLS
+	ts.StartTLS()
+	defer ts.Close()
+
+	tests := []struct {
+		name    string
+		opts    ClientTLSOptions
+		wantErr bool
+	}{
+		{"untrusted server", ClientTLSOptions{}, true},
+		{"no client cert", ClientTLSOptions{CAFile: filepath.Join(dir, "ca.pem")}, true},
+		{"client cert", ClientTLSOptions{
+			CAFile:   filepath.Join(dir, "ca.pem"),
+			CertFile: filepath.Join(dir, "client.pem"),
+			KeyFile:  filepath.Join(dir, "client-key.pem"),
+		}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, err := tt.opts.Config()
+			if err != nil {
+				t.Fatalf("ClientTLSOptions.Config() error = %v", err)
+			}
+			client := &http.Client{Transport: &http.Transport{TLSClientConfig: cfg}}
+
+			resp, err := client.Get(ts.URL)
+			if err == nil {
+				resp.Body.Close()
+			}
+			if (err != nil) != tt.wantErr {
+				t.Errorf("GET error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestServerTLSOptionsConfig(t *testing.T) {
+	tests := []struct {
+		name    string
+		opts    ServerTLSOptions
+		wantNil bool
+		wantErr bool
+	}{
+		{"disabled", ServerTLSOptions{}, true, false},
+		{"cert without key", ServerTLSOptions{CertFile: "cert.pem"}, true, true},
+		{"client CA without cert", ServerTLSOptions{ClientCAFile: "ca.pem"}, true, true},
+		{"acme and cert", ServerTLSOptions{ACMEDomains: []string{"a.example"}, CertFile: "c", KeyFile: "k"}, true, true},
+		{"acme", ServerTLSOptions{ACMEDomains: []string{"a.example"}, ACMECacheDir: t.TempDir()}, false, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, err := tt.opts.Config()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Config() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if (cfg == nil) != tt.wantNil {
+				t.Errorf("Config() = %v, wantNil %v", cfg, tt.wantNil)
+			}
+		})
+	}
+}
+
+func TestCheckTransport(t *testing.T) {
+	tests := []struct {
+		url       string
+		allowHTTP bool
+		wantErr   bool
+	}{
+		{"https://zist.example.com", false, false},
+		{"http://localhost:7474", false, false},
+		{"http://127.0.0.1:7474", false, false},
+		{"http://[::1]:7474", false, false},
+		{"http://zist.example.com", false, true},
+		{"http://zist.example.com", true, false},
+		{"ftp://zist.example.com", false, true},
+	}
+
+	for _, tt := range tests {
+		err := CheckTransport(tt.url, tt.allowHTTP)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("CheckTransport(%q, %v) error = %v, wantErr %v", tt.url, tt.allowHTTP, err, tt.wantErr)
+		}
+	}
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestServerUI(t *testing.T) {
+	server, err := NewServer(t.TempDir(), ServerLimits{})
+	if err != nil {
+		t.Fatalf("NewServer() error = %v", err)
+	}
+	defer server.Close()
+
+	off := httptest.NewServer(server.Handler())
+	defer off.Close()
+	if status, _ := apiRequest(t, "GET", off.URL+"/", "", ""); status != http.StatusNotFound {
+		t.Errorf("GET / without --ui = %d, want 404", status)
+	}
+
+	server.ui = true
+	on := httptest.NewServer(server.Handler())
+	defer on.Close()
+
+	for _, path := range []string{"/", "/app.js", "/style.css"} {
+		resp, err := http.Get(on.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK || len(body) == 0 {
+			t.Errorf("GET %s = %d, want 200 with a body", path, resp.StatusCode)
+		}
+		if csp := resp.Header.Get("Content-Security-Policy"); !strings.Contains(csp, "default-src 'self'") {
+			t.Errorf("GET %s Content-Security-Policy = %q", path, csp)
+		}
+	}
+
+	// The API still works alongside the UI
+	if status, _ := apiRequest(t, "GET", on.URL+"/healthz", "", ""); status != http.StatusOK {
+		t.Errorf("GET /healthz with --ui = %d, want 200", status)
+	}
+}
@@ -0,0 +1,74 @@
+//go:build linux
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+// watchEvents are the inotify events that mean a history file may have new
+// commands: appended to, written whole, or moved or created in place
+const watchEvents = syscall.IN_MODIFY | syscall.IN_CLOSE_WRITE | syscall.IN_CREATE | syscall.IN_MOVED_TO
+
+// watchDirs starts calling changed with the path of every file in dirs
+// that is written, created or moved there, until ctx is done. Once it
+// returns, changes are being watched; a failure after that is sent on the
+// channel. On Linux it uses inotify, so poll is unused.
+func watchDirs(ctx context.Context, dirs []string, poll time.Duration, changed func(string)) (<-chan error, error) {
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC | syscall.IN_NONBLOCK)
+	if err != nil {
+		return nil, fmt.Errorf("failed to start watching: %w", err)
+	}
+	// Non-blocking, so reads wait in the runtime's poller and Close stops them
+	f := os.NewFile(uintptr(fd), "inotify")
+
+	watched := make(map[int32]string, len(dirs))
+	for _, dir := range dirs {
+		wd, err := syscall.InotifyAddWatch(fd, dir, watchEvents)
+		if err != nil {
+			f.Close()
+			return nil, fmt.Errorf("failed to watch %s: %w", dir, err)
+		}
+		watched[int32(wd)] = dir
+	}
+
+	go func() {
+		<-ctx.Done()
+		f.Close()
+	}()
+
+	errs := make(chan error, 1)
+	go func() {
+		errs <- readInotify(ctx, f, watched, changed)
+	}()
+	return errs, nil
+}
+
+// readInotify calls changed for the events read from f until ctx is done
+func readInotify(ctx context.Context, f *os.File, watched map[int32]string, changed func(string)) error {
+	buf := make([]byte, 64*1024)
+	for {
+		n, err := f.Read(buf)
+		if ctx.Err() != nil {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read file events: %w", err)
+		}
+		for off := 0; off+syscall.SizeofInotifyEvent <= n; {
+			event := (*syscall.InotifyEvent)(unsafe.Pointer(&buf[off]))
+			name := buf[off+syscall.SizeofInotifyEvent : off+syscall.SizeofInotifyEvent+int(event.Len)]
+			off += syscall.SizeofInotifyEvent + int(event.Len)
+			if dir, ok := watched[event.Wd]; ok && event.Len > 0 {
+				changed(filepath.Join(dir, strings.TrimRight(string(name), "\x00")))
+			}
+		}
+	}
+}
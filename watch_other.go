@@ -0,0 +1,57 @@
+//go:build !linux
+
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// watchDirs starts calling changed with the path of every file in dirs
+// that is written, created or moved there, until ctx is done. Without
+// inotify it compares the size and modification time of every file each
+// poll, so the channel never gets an error.
+func watchDirs(ctx context.Context, dirs []string, poll time.Duration, changed func(string)) (<-chan error, error) {
+	type fileState struct {
+		size    int64
+		modTime time.Time
+	}
+	scan := func() map[string]fileState {
+		files := map[string]fileState{}
+		for _, dir := range dirs {
+			entries, err := os.ReadDir(dir)
+			if err != nil {
+				continue
+			}
+			for _, e := range entries {
+				if info, err := e.Info(); err == nil && info.Mode().IsRegular() {
+					files[filepath.Join(dir, e.Name())] = fileState{info.Size(), info.ModTime()}
+				}
+			}
+		}
+		return files
+	}
+
+	last := scan()
+	go func() {
+		ticker := time.NewTicker(poll)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+			files := scan()
+			for path, state := range files {
+				if prev, ok := last[path]; !ok || prev != state {
+					changed(path)
+				}
+			}
+			last = files
+		}
+	}()
+	return make(chan error), nil
+}
@@ -0,0 +1,257 @@
+// zist web UI. Talks to the same API as `zist sync` using a token kept in
+// localStorage, and renders with textContent only so history can't inject
+// markup.
+"use strict";
+
+const TOKEN_KEY = "zist-token";
+const $ = (id) => document.getElementById(id);
+
+let token = localStorage.getItem(TOKEN_KEY);
+let searchTimer;
+let searchSeq = 0;
+
+class AuthError extends Error {}
+
+async function api(path, params = {}) {
+  const qs = new URLSearchParams();
+  for (const [k, v] of Object.entries(params)) {
+    if (v !== "" && v !== undefined && v !== false) qs.set(k, v);
+  }
+  const url = qs.toString() ? `${path}?${qs}` : path;
+  const resp = await fetch(url, { headers: { Authorization: `Bearer ${token}` } });
+  const data = await resp.json().catch(() => ({}));
+  if (resp.status === 401) throw new AuthError(data.error || "invalid token");
+  if (!resp.ok) throw new Error(data.error || resp.statusText);
+  return data;
+}
+
+function el(tag, className, text) {
+  const e = document.createElement(tag);
+  if (className) e.className = className;
+  if (text !== undefined) e.textContent = text;
+  return e;
+}
+
+function hostOf(source) {
+  const i = source.indexOf(":");
+  return i > 0 ? source.slice(0, i) : "";
+}
+
+// Same FNV-1a hash and color order as hostColors in color.go, so a host has
+// the same color here as in the terminal
+function hostColor(source) {
+  const key = hostOf(source) || source;
+  let h = 0x811c9dc5;
+  for (const b of new TextEncoder().encode(key)) h = Math.imul(h ^ b, 0x01000193) >>> 0;
+  return `var(--host-${h % 6})`;
+}
+
+function fullCommand(c) {
+  return c.env_prefix ? `${c.env_prefix} ${c.command}` : c.command;
+}
+
+function formatTime(ts) {
+  return new Date(ts * 1000).toLocaleString();
+}
+
+function handleError(err, statusEl) {
+  if (err instanceof AuthError) {
+    signOut(err.message);
+    return;
+  }
+  statusEl.textContent = err.message;
+  statusEl.classList.add("error");
+}
+
+// --- login -----------------------------------------------------------------
+
+function showLogin(message = "") {
+  for (const id of ["nav", "search", "stats"]) $(id).hidden = true;
+  $("login").hidden = false;
+  $("login-error").textContent = message;
+  $("token").focus();
+}
+
+function signOut(message) {
+  token = null;
+  localStorage.removeItem(TOKEN_KEY);
+  showLogin(message);
+}
+
+$("login").addEventListener("submit", async (e) => {
+  e.preventDefault();
+  token = $("token").value.trim();
+  try {
+    await api("/api/v1/stats", { days: 1, top: 1 });
+  } catch (err) {
+    token = null;
+    $("login-error").textContent = err.message;
+    return;
+  }
+  localStorage.setItem(TOKEN_KEY, token);
+  $("token").value = "";
+  start();
+});
+
+$("logout").addEventListener("click", () => signOut());
+
+// --- search ----------------------------------------------------------------
+
+async function loadHosts() {
+  const stats = await api("/api/v1/stats", { days: 1, top: 1 });
+  const hosts = [...new Set(stats.sources.map((s) => hostOf(s.source)).filter(Boolean))].sort();
+  const select = $("host");
+  select.length = 1;
+  for (const h of hosts) select.add(new Option(h, h));
+}
+
+async function search() {
+  const seq = ++searchSeq;
+  const status = $("search-status");
+  status.classList.remove("error");
+  status.textContent = "Searching…";
+
+  const to = $("to").value;
+  const params = {
+    q: $("q").value.trim(),
+    source: $("host").value ? `${$("host").value}:` : "",
+    since: $("from").value || $("since").value,
+    until: to ? `${to} 23:59:59` : "",
+    shared: $("shared").checked,
+    limit: 200,
+  };
+
+  let results;
+  try {
+    results = await api("/api/v1/search", params);
+  } catch (err) {
+    if (seq === searchSeq) handleError(err, status);
+    return;
+  }
+  if (seq !== searchSeq) return; // a newer search is in flight
+
+  const body = $("results").tBodies[0];
+  body.replaceChildren();
+  for (const c of results) {
+    const row = body.insertRow();
+    row.append(el("td", "time", formatTime(c.timestamp)));
+    const host = el("td", "host", hostOf(c.source) || c.source);
+    host.style.color = hostColor(c.source);
+    row.append(host);
+
+    const cell = el("td");
+    if (params.shared && c.user) cell.append(el("span", "user", c.user));
+    cell.append(el("code", "", fullCommand(c)));
+    if (c.note) cell.append(el("div", "note", c.note));
+    row.append(cell);
+
+    row.title = "Click to copy";
+    row.addEventListener("click", () => copy(row, fullCommand(c)));
+  }
+  status.textContent = results.length === params.limit
+    ? `Showing the newest ${results.length} matches`
+    : `${results.length} ${results.length === 1 ? "match" : "matches"}`;
+}
+
+async function copy(row, text) {
+  try {
+    await navigator.clipboard.writeText(text);
+  } catch {
+    return; // clipboard needs HTTPS or localhost
+  }
+  row.classList.add("copied");
+  setTimeout(() => row.classList.remove("copied"), 600);
+}
+
+function scheduleSearch() {
+  clearTimeout(searchTimer);
+  searchTimer = setTimeout(search, 200);
+}
+
+$("search-form").addEventListener("submit", (e) => {
+  e.preventDefault();
+  search();
+});
+$("q").addEventListener("input", scheduleSearch);
+for (const id of ["host", "since", "from", "to", "shared"]) {
+  $(id).addEventListener("change", search);
+}
+$("from").addEventListener("change", () => { $("since").value = ""; });
+$("since").addEventListener("change", () => { $("from").value = ""; });
+
+// --- stats -----------------------------------------------------------------
+
+async function loadStats() {
+  const status = $("stats-status");
+  status.classList.remove("error");
+  status.textContent = "Loading…";
+
+  let stats;
+  try {
+    stats = await api("/api/v1/stats", { days: 30, top: 20 });
+  } catch (err) {
+    handleError(err, status);
+    return;
+  }
+  status.textContent = "";
+
+  $("total-commands").textContent = stats.total_commands.toLocaleString();
+  $("total-sources").textContent = stats.sources.length.toLocaleString();
+
+  // One bar per day, including days with no commands
+  const counts = new Map(stats.daily.map((d) => [d.day, d.count]));
+  const max = Math.max(1, ...stats.daily.map((d) => d.count));
+  const chart = $("daily");
+  chart.replaceChildren();
+  for (let i = 29; i >= 0; i--) {
+    const d = new Date();
+    d.setDate(d.getDate() - i);
+    const day = `${d.getFullYear()}-${String(d.getMonth() + 1).padStart(2, "0")}-${String(d.getDate()).padStart(2, "0")}`;
+    const n = counts.get(day) || 0;
+    const bar = el("div");
+    bar.style.height = `${(100 * n) / max}%`;
+    bar.title = `${day}: ${n}`;
+    chart.append(bar);
+  }
+
+  fillCounts($("top"), stats.top_commands.map((c) => [c.command, c.count]), true);
+  fillCounts($("sources"), stats.sources.map((s) => [s.source, s.count]), false);
+}
+
+function fillCounts(table, rows, asCode) {
+  const body = table.tBodies[0];
+  body.replaceChildren();
+  for (const [label, count] of rows) {
+    const row = body.insertRow();
+    const cell = el("td");
+    cell.append(asCode ? el("code", "", label) : document.createTextNode(label));
+    row.append(cell, el("td", "count", count.toLocaleString()));
+  }
+}
+
+// --- routing ---------------------------------------------------------------
+
+function route() {
+  if (!token) {
+    showLogin();
+    return;
+  }
+  const page = location.hash === "#stats" ? "stats" : "search";
+  $("login").hidden = true;
+  $("nav").hidden = false;
+  $("search").hidden = page !== "search";
+  $("stats").hidden = page !== "stats";
+  for (const a of document.querySelectorAll("nav a")) {
+    a.classList.toggle("active", a.hash === `#${page}`);
+  }
+  if (page === "stats") loadStats();
+}
+
+function start() {
+  route();
+  loadHosts().catch((err) => handleError(err, $("search-status")));
+  search();
+}
+
+window.addEventListener("hashchange", route);
+if (token) start(); else showLogin();
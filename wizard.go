@@ -3,42 +3,77 @@ package main
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"fmt"
+	"os"
 	"regexp"
+	"sort"
 	"strings"
 	"time"
+	"unicode/utf8"
+
+	"github.com/tchaudhry91/zist/llm"
 )
 
 // WizardRequest contains the input for generating a command
 type WizardRequest struct {
-	Query    string // Natural language query
-	PWD      string // Current working directory
-	Hostname string // Machine name
+	Query    string   // Natural language query
+	PWD      string   // Current working directory
+	Hostname string   // Machine name
+	Project  string   // Project root for project-scoped cache entries, "" for global only
+	ListDir  bool     // Include the names in PWD in the prompt
+	EnvHints []string // Allowlisted environment, e.g. "AWS_PROFILE=prod"
+	Examples int      // Most cached mappings to show as examples of the user's style
+	Offline  bool     // Answer from the cache and history only, never asking the LLM
 }
 
 // WizardResponse contains the generated command
 type WizardResponse struct {
 	Command   string        `json:"command"`
-	Source    string        `json:"source"` // "cache" or "llm"
+	Source    string        `json:"source"` // "cache", "history" or "llm"
 	Query     string        `json:"query"`
 	Latency   time.Duration `json:"latency_ms"`
 	FromCache bool          `json:"from_cache"`
+	SafetyReport
 }
 
+// Limits on the few-shot examples taken from the wizard cache
+const (
+	DefaultWizardExamples = 5
+	minExampleRuns        = 2   // accepted at least this often
+	maxExampleCommand     = 200 // bytes; long one-offs make poor examples
+)
+
+// maxHistoryContext is how many commands from history the LLM is shown
+const maxHistoryContext = 10
+
+// ErrWizardOffline is returned offline when neither the cache nor history
+// has a command for the query
+var ErrWizardOffline = errors.New("cache miss, offline")
+
 // Wizard generates shell commands from natural language
 type Wizard struct {
-	llm LLMClient
-	db  *sql.DB
+	llm        llm.Client
+	db         *sql.DB
+	embedder   llm.Embedder // Finds history context by meaning too when set
+	embedModel string
 }
 
 // NewWizard creates a new Wizard instance
-func NewWizard(db *sql.DB, llm LLMClient) *Wizard {
+func NewWizard(db *sql.DB, llm llm.Client) *Wizard {
 	return &Wizard{
 		llm: llm,
 		db:  db,
 	}
 }
 
+// UseEmbeddings makes the wizard gather history context with HybridSearch
+// over the vectors from model, rather than by keywords alone
+func (w *Wizard) UseEmbeddings(e llm.Embedder, model string) {
+	w.embedder = e
+	w.embedModel = model
+}
+
 // Generate produces a shell command from a natural language query
 func (w *Wizard) Generate(ctx context.Context, req WizardRequest) (*WizardResponse, error) {
 	start := time.Now()
@@ -48,18 +83,34 @@ func (w *Wizard) Generate(ctx context.Context, req WizardRequest) (*WizardRespon
 		return nil, fmt.Errorf("query cannot be empty")
 	}
 
-	// Check cache first
-	cached, err := GetWizardCache(w.db, query)
+	// Check cache first, preferring an entry for this project
+	cached, err := GetWizardCache(ctx, w.db, req.Project, query)
 	if err != nil {
 		// Log but continue - cache miss is not fatal
 	}
 	if cached != nil {
 		return &WizardResponse{
-			Command:   cached.Command,
-			Source:    "cache",
-			Query:     query,
-			Latency:   time.Since(start),
-			FromCache: true,
+			Command:      cached.Command,
+			Source:       "cache",
+			Query:        query,
+			Latency:      time.Since(start),
+			FromCache:    true,
+			SafetyReport: AnalyzeCommand(cached.Command),
+		}, nil
+	}
+
+	// Offline, the closest command from history is all there is to offer
+	if req.Offline {
+		results, err := SearchHistoryByKeywords(ctx, w.db, extractKeywords(query), 1)
+		if err != nil || len(results) == 0 {
+			return nil, ErrWizardOffline
+		}
+		return &WizardResponse{
+			Command:      results[0].Command,
+			Source:       "history",
+			Query:        query,
+			Latency:      time.Since(start),
+			SafetyReport: AnalyzeCommand(results[0].Command),
 		}, nil
 	}
 
@@ -69,11 +120,16 @@ func (w *Wizard) Generate(ctx context.Context, req WizardRequest) (*WizardRespon
 	}
 
 	// Gather history context
-	historyContext := w.gatherHistoryContext(query)
+	historyContext := w.gatherHistoryContext(ctx, query)
+
+	var dirListing []string
+	if req.ListDir && req.PWD != "" {
+		dirListing = listDirectory(req.PWD, maxDirEntries, maxDirListingBytes)
+	}
 
 	// Build prompts
-	systemPrompt := w.buildSystemPrompt()
-	userPrompt := w.buildUserPrompt(req, historyContext)
+	systemPrompt := w.buildSystemPrompt(w.gatherExamples(ctx, req.Project, query, req.Examples))
+	userPrompt := w.buildUserPrompt(req, historyContext, dirListing)
 
 	// Generate command
 	response, err := w.llm.Complete(ctx, userPrompt, systemPrompt)
@@ -88,27 +144,42 @@ func (w *Wizard) Generate(ctx context.Context, req WizardRequest) (*WizardRespon
 	}
 
 	return &WizardResponse{
-		Command:   command,
-		Source:    "llm",
-		Query:     query,
-		Latency:   time.Since(start),
-		FromCache: false,
+		Command:      command,
+		Source:       "llm",
+		Query:        query,
+		Latency:      time.Since(start),
+		FromCache:    false,
+		SafetyReport: AnalyzeCommand(command),
 	}, nil
 }
 
 // CacheCommand stores a query→command mapping (called when user runs the command)
-func (w *Wizard) CacheCommand(query, command string) error {
-	return SetWizardCache(w.db, query, command)
+func (w *Wizard) CacheCommand(ctx context.Context, project, query, command string) error {
+	return SetWizardCache(ctx, w.db, project, query, command)
 }
 
-// gatherHistoryContext extracts relevant commands from history based on query keywords
-func (w *Wizard) gatherHistoryContext(query string) []string {
+// gatherHistoryContext extracts relevant commands from history based on query
+// keywords, and their meaning when the wizard has embeddings
+func (w *Wizard) gatherHistoryContext(ctx context.Context, query string) []string {
+	if w.embedder != nil {
+		results, err := HybridSearch(ctx, w.db, w.embedder, w.embedModel, SearchOptions{Query: query, Limit: maxHistoryContext})
+		if err == nil && len(results) > 0 {
+			var commands []string
+			for _, r := range results {
+				commands = append(commands, r.Command)
+			}
+			return commands
+		}
+		// Fall back to keywords, with their substring matches, when that
+		// finds nothing or the embedding server is down
+	}
+
 	keywords := extractKeywords(query)
 	if len(keywords) == 0 {
 		return nil
 	}
 
-	results, err := SearchHistoryByKeywords(w.db, keywords, 10)
+	results, err := SearchHistoryByKeywords(ctx, w.db, keywords, maxHistoryContext)
 	if err != nil {
 		return nil
 	}
@@ -120,43 +191,76 @@ func (w *Wizard) gatherHistoryContext(query string) []string {
 	return commands
 }
 
-// extractKeywords pulls relevant keywords from the query for history search
-func extractKeywords(query string) []string {
-	// Common words to ignore
-	stopWords := map[string]bool{
-		"a": true, "an": true, "the": true, "is": true, "are": true,
-		"was": true, "were": true, "be": true, "been": true, "being": true,
-		"have": true, "has": true, "had": true, "do": true, "does": true,
-		"did": true, "will": true, "would": true, "could": true, "should": true,
-		"may": true, "might": true, "must": true, "shall": true,
-		"i": true, "me": true, "my": true, "we": true, "our": true,
-		"you": true, "your": true, "he": true, "she": true, "it": true,
-		"they": true, "them": true, "their": true,
-		"this": true, "that": true, "these": true, "those": true,
-		"what": true, "which": true, "who": true, "whom": true,
-		"how": true, "when": true, "where": true, "why": true,
-		"all": true, "any": true, "both": true, "each": true,
-		"few": true, "more": true, "most": true, "some": true,
-		"show": true, "get": true, "find": true, "list": true, "display": true,
-		"give": true, "tell": true, "can": true, "please": true,
-		"want": true, "need": true, "like": true,
-		"to": true, "of": true, "in": true, "for": true, "on": true,
-		"with": true, "at": true, "by": true, "from": true, "as": true,
-		"into": true, "through": true, "during": true, "before": true,
-		"after": true, "above": true, "below": true, "between": true,
-		"and": true, "or": true, "but": true, "not": true,
+// gatherExamples picks the user's most accepted query→command pairs, leaving
+// out the query being asked
+func (w *Wizard) gatherExamples(ctx context.Context, project, query string, limit int) []WizardCacheEntry {
+	if limit <= 0 {
+		return nil
+	}
+	entries, err := GetWizardExamples(ctx, w.db, project, minExampleRuns, limit+1)
+	if err != nil {
+		return nil
+	}
+
+	normalized := NormalizeQuery(query)
+	var examples []WizardCacheEntry
+	for _, e := range entries {
+		if e.QueryNormalized != normalized && len(examples) < limit {
+			examples = append(examples, e)
+		}
 	}
+	return examples
+}
+
+// DefaultKeywordStopwords are English words too common in requests to find
+// anything in history
+var DefaultKeywordStopwords = []string{
+	"a", "an", "the", "is", "are", "was", "were", "be", "been", "being",
+	"have", "has", "had", "do", "does", "did", "will", "would", "could", "should",
+	"may", "might", "must", "shall",
+	"i", "me", "my", "we", "our", "you", "your", "he", "she", "it",
+	"they", "them", "their",
+	"this", "that", "these", "those",
+	"what", "which", "who", "whom", "how", "when", "where", "why",
+	"all", "any", "both", "each", "few", "more", "most", "some",
+	"show", "get", "find", "list", "display", "give", "tell", "can", "please",
+	"want", "need", "like",
+	"to", "of", "in", "for", "on", "with", "at", "by", "from", "as",
+	"into", "through", "during", "before", "after", "above", "below", "between",
+	"and", "or", "but", "not",
+}
 
-	// Extract words, keeping only meaningful ones
-	words := regexp.MustCompile(`[a-zA-Z0-9_\-\.]+`).FindAllString(strings.ToLower(query), -1)
+// keywordStopwords are left out of history search keywords:
+// DefaultKeywordStopwords plus any from --keyword-stopwords
+var keywordStopwords = stopwordSet(DefaultKeywordStopwords)
+
+// stopwordSet lowercases words into a set
+func stopwordSet(words ...[]string) map[string]bool {
+	set := make(map[string]bool)
+	for _, list := range words {
+		for _, word := range list {
+			set[strings.ToLower(word)] = true
+		}
+	}
+	return set
+}
+
+// keywordPattern matches words in any script, along with the dashes, dots and
+// underscores of names like docker-compose or main.go
+var keywordPattern = regexp.MustCompile(`[\p{L}\p{M}\p{N}_\-\.]+`)
+
+// extractKeywords pulls relevant keywords from the query for history search
+func extractKeywords(query string) []string {
+	words := keywordPattern.FindAllString(strings.ToLower(query), -1)
 
 	var keywords []string
 	seen := make(map[string]bool)
 	for _, word := range words {
-		if len(word) < 2 {
+		word = strings.Trim(word, ".")
+		if utf8.RuneCountInString(word) < 2 {
 			continue
 		}
-		if stopWords[word] {
+		if keywordStopwords[word] {
 			continue
 		}
 		if seen[word] {
@@ -169,8 +273,21 @@ func extractKeywords(query string) []string {
 	return keywords
 }
 
-func (w *Wizard) buildSystemPrompt() string {
-	return `You are a shell command generator. Convert natural language requests into executable shell commands.
+func (w *Wizard) buildSystemPrompt(examples []WizardCacheEntry) string {
+	if len(examples) == 0 {
+		return baseSystemPrompt
+	}
+
+	var sb strings.Builder
+	sb.WriteString(baseSystemPrompt)
+	sb.WriteString("\n\nEXAMPLES THIS USER ACCEPTED (match their style and tools):")
+	for _, e := range examples {
+		fmt.Fprintf(&sb, "\nUser: %q\nOutput: %s\n", e.QueryOriginal, e.Command)
+	}
+	return strings.TrimSuffix(sb.String(), "\n")
+}
+
+const baseSystemPrompt = `You are a shell command generator. Convert natural language requests into executable shell commands.
 
 RULES:
 - Output ONLY the shell command, nothing else
@@ -193,21 +310,95 @@ Output: df -h
 
 User: "count lines in all python files"
 Output: find . -name "*.py" -exec wc -l {} +`
+
+// Limits on the PWD listing sent to the LLM
+const (
+	maxDirEntries      = 50
+	maxDirListingBytes = 2000
+	maxDirScan         = 1000 // entries read before giving up on huge directories
+)
+
+// listDirectory returns the sorted names in dir, directories with a trailing
+// slash. Hidden entries are skipped and the listing is capped by count and
+// total size; a final "... (N more)" line marks truncation.
+func listDirectory(dir string, maxEntries, maxBytes int) []string {
+	f, err := os.Open(dir)
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+
+	entries, _ := f.ReadDir(maxDirScan)
+	var names []string
+	for _, e := range entries {
+		name := e.Name()
+		if strings.HasPrefix(name, ".") {
+			continue
+		}
+		if e.IsDir() {
+			name += "/"
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var listing []string
+	size := 0
+	for i, name := range names {
+		if i == maxEntries || size+len(name) > maxBytes {
+			more := fmt.Sprintf("%d", len(names)-i)
+			if len(entries) == maxDirScan {
+				more += "+"
+			}
+			listing = append(listing, fmt.Sprintf("... (%s more)", more))
+			break
+		}
+		listing = append(listing, name)
+		size += len(name) + 1
+	}
+	return listing
 }
 
-func (w *Wizard) buildUserPrompt(req WizardRequest, historyContext []string) string {
+func (w *Wizard) buildUserPrompt(req WizardRequest, historyContext, dirListing []string) string {
 	var sb strings.Builder
 
 	sb.WriteString("Convert this request to a shell command:\n")
 	sb.WriteString(req.Query)
 	sb.WriteString("\n")
 
+	writePromptContext(&sb, req, historyContext, dirListing)
+
+	sb.WriteString("\nShell command:")
+
+	return sb.String()
+}
+
+// writePromptContext adds what the wizard knows about the user's situation:
+// directory, environment, files and related history
+func writePromptContext(sb *strings.Builder, req WizardRequest, historyContext, dirListing []string) {
 	if req.PWD != "" {
 		sb.WriteString("\nCurrent directory: ")
 		sb.WriteString(req.PWD)
 		sb.WriteString("\n")
 	}
 
+	if len(req.EnvHints) > 0 {
+		sb.WriteString("\nActive environment (prefer commands that fit it):\n")
+		for _, hint := range req.EnvHints {
+			sb.WriteString("- ")
+			sb.WriteString(hint)
+			sb.WriteString("\n")
+		}
+	}
+
+	if len(dirListing) > 0 {
+		sb.WriteString("\nFiles in current directory:\n")
+		for _, name := range dirListing {
+			sb.WriteString(name)
+			sb.WriteString("\n")
+		}
+	}
+
 	if len(historyContext) > 0 {
 		sb.WriteString("\nRelevant commands from user's history (for context/patterns):\n")
 		for _, cmd := range historyContext {
@@ -220,10 +411,6 @@ func (w *Wizard) buildUserPrompt(req WizardRequest, historyContext []string) str
 			sb.WriteString("\n")
 		}
 	}
-
-	sb.WriteString("\nShell command:")
-
-	return sb.String()
 }
 
 func (w *Wizard) parseResponse(response string) string {
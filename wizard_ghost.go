@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/tchaudhry91/zist/client"
+	"github.com/tchaudhry91/zist/llm"
+)
+
+// minGhostWords is how many words a buffer needs before it is treated as a
+// natural language query
+const minGhostWords = 3
+
+// LooksLikeQuery reports whether a command line buffer reads like natural
+// language rather than a command being typed, so the ghost preview does not
+// send every half-typed command to the LLM
+func LooksLikeQuery(buffer string) bool {
+	if strings.ContainsAny(buffer, "|;&<>`$(){}[]\\=") {
+		return false
+	}
+	words := strings.Fields(buffer)
+	if len(words) < minGhostWords {
+		return false
+	}
+	for _, w := range words {
+		if strings.HasPrefix(w, "-") {
+			return false
+		}
+	}
+	return true
+}
+
+// GhostSuggestion returns a one-line command for the ghost text preview, or
+// "" when the buffer doesn't look like a query, the answer won't fit on one
+// line or AnalyzeCommand flags it: Tab inserts the preview with no chance
+// to warn, so risky commands are left to Ctrl+G, which asks first. The
+// local cache is checked first; on a miss the server is asked
+// when remote is set, otherwise the local LLM. With req.Offline, neither is:
+// a miss in the cache and history leaves no suggestion.
+func GhostSuggestion(ctx context.Context, db *sql.DB, llm llm.Client, remote *client.Client, req WizardRequest) (string, error) {
+	if !LooksLikeQuery(req.Query) {
+		return "", nil
+	}
+
+	var command string
+	if cached, _ := GetWizardCache(ctx, db, req.Project, req.Query); cached != nil {
+		command = cached.Command
+	} else if remote != nil && !req.Offline {
+		suggestion, err := remote.Wizard(ctx, client.WizardRequest{Query: req.Query, PWD: req.PWD, Project: req.Project})
+		if err != nil {
+			return "", err
+		}
+		command = suggestion.Command
+	} else {
+		resp, err := NewWizard(db, llm).Generate(ctx, req)
+		if errors.Is(err, ErrWizardOffline) {
+			return "", nil
+		}
+		if err != nil {
+			return "", err
+		}
+		command = resp.Command
+	}
+
+	if strings.Contains(command, "\n") || AnalyzeCommand(command).RequiresConfirmation {
+		return "", nil
+	}
+	return command, nil
+}
+
+// remoteFromEnv returns a client for the server in ZIST_SERVER and
+// ZIST_TOKEN, or nil if they are not set
+func remoteFromEnv(timeout time.Duration) (*client.Client, error) {
+	server, token := os.Getenv("ZIST_SERVER"), os.Getenv("ZIST_TOKEN")
+	if server == "" || token == "" {
+		return nil, nil
+	}
+	if err := CheckTransport(server, false); err != nil {
+		return nil, err
+	}
+
+	tlsOpts := ClientTLSOptions{
+		CAFile:   os.Getenv("ZIST_CA_CERT"),
+		CertFile: os.Getenv("ZIST_CLIENT_CERT"),
+		KeyFile:  os.Getenv("ZIST_CLIENT_KEY"),
+	}
+	tlsConfig, err := tlsOpts.Config()
+	if err != nil {
+		return nil, err
+	}
+
+	remote := client.New(server, token)
+	remote.HTTPClient = &http.Client{
+		Timeout:   timeout,
+		Transport: &http.Transport{TLSClientConfig: tlsConfig},
+	}
+	return remote, nil
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/tchaudhry91/zist/client"
+	"github.com/tchaudhry91/zist/llm"
+)
+
+func TestLooksLikeQuery(t *testing.T) {
+	tests := []struct {
+		buffer string
+		want   bool
+	}{
+		{"list all running containers", true},
+		{"what's my public ip", true},
+		{"git status", false},
+		{"docker ps -a", false},
+		{"find . -name foo", false},
+		{"ls | grep foo bar", false},
+		{"echo $HOME and more", false},
+		{"FOO=1 make all things", false},
+		{"", false},
+	}
+	for _, tt := range tests {
+		if got := LooksLikeQuery(tt.buffer); got != tt.want {
+			t.Errorf("LooksLikeQuery(%q) = %v, want %v", tt.buffer, got, tt.want)
+		}
+	}
+}
+
+func TestGhostSuggestion(t *testing.T) {
+	db, err := InitDB(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if err := SetWizardCache(context.Background(), db, "", "list running containers", "docker ps"); err != nil {
+		t.Fatal(err)
+	}
+	if err := SetWizardCache(context.Background(), db, "", "loop over all files", "for f in *; do\n  echo $f\ndone"); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name        string
+		query       string
+		reply       string
+		want        string
+		wantPrompts int
+	}{
+		{"cache hit", "list running containers", "", "docker ps", 0},
+		{"llm", "show disk usage here", "du -sh .", "du -sh .", 1},
+		{"multi-line", "loop over all files", "", "", 0},
+		{"risky", "clean up the build folder", "rm -rf build", "", 1},
+		{"not a query", "git status", "git status", "", 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mock := &llm.Mock{Reply: tt.reply}
+			got, err := GhostSuggestion(context.Background(), db, mock, nil, WizardRequest{Query: tt.query})
+			if err != nil {
+				t.Fatalf("GhostSuggestion() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("GhostSuggestion() = %q, want %q", got, tt.want)
+			}
+			if len(mock.Prompts()) != tt.wantPrompts {
+				t.Errorf("LLM called %d times, want %d", len(mock.Prompts()), tt.wantPrompts)
+			}
+		})
+	}
+}
+
+func TestServerWizard(t *testing.T) {
+	server, err := NewServer(t.TempDir(), ServerLimits{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { server.Close() })
+	token, err := CreateUser(context.Background(), server.authDB, "alice", RoleMember)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := httptest.NewServer(server.Handler())
+	t.Cleanup(ts.Close)
+
+	if status, _ := apiRequest(t, "POST", ts.URL+"/api/v1/wizard", token, `{"query": "show disk usage here"}`); status != http.StatusNotImplemented {
+		t.Errorf("wizard without an LLM status = %d, want %d", status, http.StatusNotImplemented)
+	}
+
+	mock := &llm.Mock{Reply: "du -sh ."}
+	server.llm = mock
+	if status, _ := apiRequest(t, "POST", ts.URL+"/api/v1/wizard", token, `{}`); status != http.StatusBadRequest {
+		t.Errorf("wizard without a query status = %d, want %d", status, http.StatusBadRequest)
+	}
+	status, body := apiRequest(t, "POST", ts.URL+"/api/v1/wizard", token, `{"query": "show disk usage here", "pwd": "/srv/app"}`)
+	if status != http.StatusOK || !strings.Contains(body, `"command":"du -sh ."`) {
+		t.Errorf("wizard = %d %s", status, body)
+	}
+	if len(mock.Prompts()) != 1 || !strings.Contains(mock.Prompts()[0], "/srv/app") {
+		t.Errorf("prompts = %q", mock.Prompts())
+	}
+
+	// The ghost preview asks the server on a local cache miss
+	db, err := InitDB(filepath.Join(t.TempDir(), "local.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	got, err := GhostSuggestion(context.Background(), db, nil, client.New(ts.URL, token), WizardRequest{Query: "show disk usage here"})
+	if err != nil || got != "du -sh ." {
+		t.Errorf("GhostSuggestion() via server = %q, %v", got, err)
+	}
+}
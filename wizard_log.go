@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// WizardLogEntry records one wizard request and the tokens it cost
+type WizardLogEntry struct {
+	Timestamp        float64 // Unix timestamp
+	Model            string  // Model the request was configured with
+	Mode             string  // "query", "plan", "ghost" or "ask"
+	Source           string  // "cache", "history" or "llm"
+	Query            string  // Natural language request
+	Command          string  // Generated command, or plan steps joined with " && "
+	PromptTokens     int
+	CompletionTokens int
+	LatencyMs        int64
+}
+
+// LogWizardRequest appends a wizard request to wizard_log
+func LogWizardRequest(ctx context.Context, db *sql.DB, e WizardLogEntry) error {
+	if e.Timestamp == 0 {
+		e.Timestamp = float64(time.Now().Unix())
+	}
+	_, err := db.ExecContext(ctx, `INSERT INTO wizard_log (timestamp, model, mode, source, query, command, prompt_tokens, completion_tokens, latency_ms)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		e.Timestamp, e.Model, e.Mode, e.Source, e.Query, e.Command, e.PromptTokens, e.CompletionTokens, e.LatencyMs)
+	if err != nil {
+		return fmt.Errorf("failed to log wizard request: %w", err)
+	}
+	return nil
+}
+
+// ModelUsage totals wizard requests for one model
+type ModelUsage struct {
+	Model            string
+	Requests         int64
+	CacheHits        int64
+	PromptTokens     int64
+	CompletionTokens int64
+}
+
+// GetWizardUsage totals wizard requests logged since a Unix timestamp per
+// model, most tokens first
+func GetWizardUsage(ctx context.Context, db *sql.DB, since float64) ([]ModelUsage, error) {
+	rows, err := db.QueryContext(ctx, `SELECT model, COUNT(*), SUM(source = 'cache'), SUM(prompt_tokens), SUM(completion_tokens)
+		FROM wizard_log WHERE timestamp >= ?
+		GROUP BY model ORDER BY SUM(prompt_tokens + completion_tokens) DESC, model`, since)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query wizard usage: %w", err)
+	}
+	defer rows.Close()
+
+	var usage []ModelUsage
+	for rows.Next() {
+		var u ModelUsage
+		if err := rows.Scan(&u.Model, &u.Requests, &u.CacheHits, &u.PromptTokens, &u.CompletionTokens); err != nil {
+			return nil, fmt.Errorf("failed to scan wizard usage: %w", err)
+		}
+		usage = append(usage, u)
+	}
+	return usage, rows.Err()
+}
+
+// ModelPrice is what a model costs in USD per million tokens
+type ModelPrice struct {
+	Input  float64
+	Output float64
+}
+
+// DefaultModelPrices are list prices for common hosted models. Local models
+// are free and left out.
+var DefaultModelPrices = map[string]ModelPrice{
+	"gpt-4o":            {2.50, 10.00},
+	"gpt-4o-mini":       {0.15, 0.60},
+	"gpt-4.1":           {2.00, 8.00},
+	"gpt-4.1-mini":      {0.40, 1.60},
+	"gpt-4.1-nano":      {0.10, 0.40},
+	"o3-mini":           {1.10, 4.40},
+	"o4-mini":           {1.10, 4.40},
+	"claude-3-5-haiku":  {0.80, 4.00},
+	"claude-3-5-sonnet": {3.00, 15.00},
+	"gemini-2.0-flash":  {0.10, 0.40},
+}
+
+// ParseModelPrice parses MODEL=INPUT/OUTPUT, in USD per million tokens
+func ParseModelPrice(s string) (string, ModelPrice, error) {
+	model, prices, ok := strings.Cut(s, "=")
+	in, out, ok2 := strings.Cut(prices, "/")
+	if !ok || !ok2 || model == "" {
+		return "", ModelPrice{}, fmt.Errorf("invalid price %q, want MODEL=INPUT/OUTPUT", s)
+	}
+	var p ModelPrice
+	var err error
+	if p.Input, err = strconv.ParseFloat(in, 64); err != nil {
+		return "", ModelPrice{}, fmt.Errorf("invalid input price in %q: %w", s, err)
+	}
+	if p.Output, err = strconv.ParseFloat(out, 64); err != nil {
+		return "", ModelPrice{}, fmt.Errorf("invalid output price in %q: %w", s, err)
+	}
+	return model, p, nil
+}
+
+// priceFor finds the price of a model by its longest matching prefix, so
+// dated names like gpt-4o-mini-2024-07-18 use the gpt-4o-mini price
+func priceFor(prices map[string]ModelPrice, model string) (ModelPrice, bool) {
+	names := make([]string, 0, len(prices))
+	for name := range prices {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool { return len(names[i]) > len(names[j]) })
+
+	// Hosted gateways often prefix the vendor, e.g. openai/gpt-4o
+	if i := strings.LastIndex(model, "/"); i >= 0 {
+		model = model[i+1:]
+	}
+	for _, name := range names {
+		if strings.HasPrefix(model, name) {
+			return prices[name], true
+		}
+	}
+	return ModelPrice{}, false
+}
+
+// Cost estimates what the usage cost in USD, and whether the model's price
+// is known
+func (u ModelUsage) Cost(prices map[string]ModelPrice) (float64, bool) {
+	p, ok := priceFor(prices, u.Model)
+	if !ok {
+		return 0, false
+	}
+	return (float64(u.PromptTokens)*p.Input + float64(u.CompletionTokens)*p.Output) / 1e6, true
+}
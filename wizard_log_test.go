@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"math"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWizardUsage(t *testing.T) {
+	db, err := InitDB(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	old := float64(time.Now().AddDate(0, 0, -40).Unix())
+	entries := []WizardLogEntry{
+		{Model: "gpt-4o-mini", Mode: "query", Source: "llm", Query: "list pods", PromptTokens: 900, CompletionTokens: 100},
+		{Model: "gpt-4o-mini", Mode: "query", Source: "cache", Query: "list pods"},
+		{Model: "gpt-4o-mini", Mode: "plan", Source: "llm", Query: "set up venv", PromptTokens: 1100, CompletionTokens: 300},
+		{Model: "qwen2.5-coder:3b", Mode: "query", Source: "llm", Query: "disk usage", PromptTokens: 500, CompletionTokens: 20},
+		{Model: "gpt-4o", Mode: "query", Source: "llm", Query: "old", PromptTokens: 5000, CompletionTokens: 500, Timestamp: old},
+	}
+	for _, e := range entries {
+		if err := LogWizardRequest(context.Background(), db, e); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	usage, err := GetWizardUsage(context.Background(), db, float64(time.Now().AddDate(0, 0, -30).Unix()))
+	if err != nil {
+		t.Fatalf("GetWizardUsage() error = %v", err)
+	}
+	want := []ModelUsage{
+		{Model: "gpt-4o-mini", Requests: 3, CacheHits: 1, PromptTokens: 2000, CompletionTokens: 400},
+		{Model: "qwen2.5-coder:3b", Requests: 1, PromptTokens: 500, CompletionTokens: 20},
+	}
+	if !reflect.DeepEqual(usage, want) {
+		t.Errorf("GetWizardUsage() = %+v, want %+v", usage, want)
+	}
+
+	all, err := GetWizardUsage(context.Background(), db, 0)
+	if err != nil || len(all) != 3 || all[0].Model != "gpt-4o" {
+		t.Errorf("GetWizardUsage(all time) = %+v, %v", all, err)
+	}
+}
+
+func TestModelUsageCost(t *testing.T) {
+	prices := map[string]ModelPrice{"gpt-4o": {2.50, 10.00}, "gpt-4o-mini": {0.15, 0.60}}
+
+	tests := []struct {
+		model  string
+		want   float64
+		wantOK bool
+	}{
+		{"gpt-4o", 2.50 + 10.00, true},
+		{"gpt-4o-mini", 0.15 + 0.60, true},
+		{"gpt-4o-mini-2024-07-18", 0.15 + 0.60, true},
+		{"openai/gpt-4o", 2.50 + 10.00, true},
+		{"qwen2.5-coder:3b", 0, false},
+	}
+	for _, tt := range tests {
+		u := ModelUsage{Model: tt.model, PromptTokens: 1e6, CompletionTokens: 1e6}
+		got, ok := u.Cost(prices)
+		if ok != tt.wantOK || math.Abs(got-tt.want) > 1e-9 {
+			t.Errorf("Cost(%s) = %v, %v, want %v, %v", tt.model, got, ok, tt.want, tt.wantOK)
+		}
+	}
+}
+
+func TestParseModelPrice(t *testing.T) {
+	tests := []struct {
+		in      string
+		model   string
+		price   ModelPrice
+		wantErr bool
+	}{
+		{"my-model=0.5/1.5", "my-model", ModelPrice{0.5, 1.5}, false},
+		{"openrouter/llama=0/0", "openrouter/llama", ModelPrice{}, false},
+		{"my-model=0.5", "", ModelPrice{}, true},
+		{"=1/2", "", ModelPrice{}, true},
+		{"m=a/2", "", ModelPrice{}, true},
+	}
+	for _, tt := range tests {
+		model, price, err := ParseModelPrice(tt.in)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseModelPrice(%q) error = %v, wantErr %v", tt.in, err, tt.wantErr)
+			continue
+		}
+		if model != tt.model || price != tt.price {
+			t.Errorf("ParseModelPrice(%q) = %q, %+v", tt.in, model, price)
+		}
+	}
+}
+
+func TestRunStatsWizard(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "test.db")
+	db, err := InitDB(dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	LogWizardRequest(context.Background(), db, WizardLogEntry{Model: "gpt-4o-mini", Mode: "query", Source: "llm", Query: "q", PromptTokens: 1e6})
+	LogWizardRequest(context.Background(), db, WizardLogEntry{Model: "local", Mode: "query", Source: "llm", Query: "q", PromptTokens: 10})
+	db.Close()
+
+	var out strings.Builder
+	if err := runStatsWizard(context.Background(), dbPath, 0, []string{"local=1/1"}, &out); err != nil {
+		t.Fatalf("runStatsWizard() error = %v", err)
+	}
+	for _, want := range []string{"gpt-4o-mini", "$0.1500", "local", "$0.0000", "Estimated total: $0.1500"} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("output missing %q:\n%s", want, out.String())
+		}
+	}
+
+	if err := runStatsWizard(context.Background(), dbPath, 0, []string{"bad"}, &out); err == nil {
+		t.Error("runStatsWizard() with a bad price should fail")
+	}
+}
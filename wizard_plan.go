@@ -0,0 +1,175 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"regexp"
+	"strings"
+)
+
+// maxPlanSteps caps how many commands a plan may have
+const maxPlanSteps = 10
+
+// planStepPrefix matches list markers models like to add: "1.", "2)", "-", "*", "$"
+var planStepPrefix = regexp.MustCompile(`^\s*(?:\d+[.)]|[-*$])\s+`)
+
+// Plan asks the LLM for an ordered list of commands that together do what
+// the request asks
+func (w *Wizard) Plan(ctx context.Context, req WizardRequest) ([]string, error) {
+	query := strings.TrimSpace(req.Query)
+	if query == "" {
+		return nil, fmt.Errorf("query cannot be empty")
+	}
+	if w.llm == nil {
+		return nil, fmt.Errorf("LLM not available")
+	}
+
+	historyContext := w.gatherHistoryContext(ctx, query)
+	var dirListing []string
+	if req.ListDir && req.PWD != "" {
+		dirListing = listDirectory(req.PWD, maxDirEntries, maxDirListingBytes)
+	}
+
+	var sb strings.Builder
+	sb.WriteString("Break this task into shell commands:\n")
+	sb.WriteString(query)
+	sb.WriteString("\n")
+	writePromptContext(&sb, req, historyContext, dirListing)
+	sb.WriteString("\nCommands, one per line:")
+
+	response, err := w.llm.Complete(ctx, sb.String(), w.buildPlanSystemPrompt())
+	if err != nil {
+		return nil, fmt.Errorf("LLM generation failed: %w", err)
+	}
+
+	steps := parsePlan(response)
+	if len(steps) == 0 {
+		return nil, fmt.Errorf("LLM returned no commands")
+	}
+	return steps, nil
+}
+
+func (w *Wizard) buildPlanSystemPrompt() string {
+	return fmt.Sprintf(`You are a shell command planner. Turn a task into a short ordered list of shell commands.
+
+RULES:
+- Output ONLY the commands, one per line, in the order they must run
+- No explanations, no numbering, no markdown, no code blocks
+- At most %d commands; use as few as the task needs
+- Each command runs in a new shell in the same directory, so cd, export and
+  source do not carry over; use paths instead (e.g. .venv/bin/pip)
+- Prefer simple, readable commands
+
+EXAMPLE:
+User: "set up a python venv and install requirements"
+Output:
+python3 -m venv .venv
+.venv/bin/pip install --upgrade pip
+.venv/bin/pip install -r requirements.txt`, maxPlanSteps)
+}
+
+// parsePlan extracts commands from a plan response, dropping fences,
+// comments, list markers and blank lines
+func parsePlan(response string) []string {
+	var steps []string
+	for _, line := range strings.Split(response, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "```") || strings.HasPrefix(line, "#") {
+			continue
+		}
+		line = strings.TrimSpace(planStepPrefix.ReplaceAllString(line, ""))
+		if line == "" {
+			continue
+		}
+		steps = append(steps, line)
+		if len(steps) == maxPlanSteps {
+			break
+		}
+	}
+	return steps
+}
+
+// planSnippetName turns a plan request into a snippet name, e.g.
+// "Set up a Python venv!" becomes "plan-set-up-a-python-venv"
+func planSnippetName(query string) string {
+	words := strings.FieldsFunc(strings.ToLower(query), func(r rune) bool {
+		return !(r >= 'a' && r <= 'z' || r >= '0' && r <= '9')
+	})
+	name := "plan"
+	for _, w := range words {
+		if len(name)+1+len(w) > 48 {
+			break
+		}
+		name += "-" + w
+	}
+	return name
+}
+
+// RunPlan shows the steps and asks whether to run them all or one at a time,
+// reading answers from in. It stops at the first failing step and reports
+// whether every step ran and succeeded.
+func RunPlan(ctx context.Context, steps []string, in io.Reader, out io.Writer, run func(context.Context, string) error) (bool, error) {
+	for i, step := range steps {
+		fmt.Fprintf(out, "  %d. %s\n", i+1, step)
+	}
+
+	answers := bufio.NewScanner(in)
+	ask := func(prompt string) string {
+		fmt.Fprint(out, prompt)
+		if !answers.Scan() {
+			fmt.Fprintln(out)
+			return "q"
+		}
+		return strings.ToLower(strings.TrimSpace(answers.Text()))
+	}
+
+	var stepByStep bool
+	switch ask("\nRun [a]ll, [s]tep by step, or [c]ancel? ") {
+	case "a", "all":
+	case "s", "step":
+		stepByStep = true
+	default:
+		fmt.Fprintln(out, "Cancelled")
+		return false, nil
+	}
+
+	complete := true
+	for i, step := range steps {
+		fmt.Fprintf(out, "\n[%d/%d] %s\n", i+1, len(steps), step)
+		if stepByStep {
+			switch ask("Run? [Y]es, [s]kip, [q]uit: ") {
+			case "", "y", "yes":
+			case "s", "skip":
+				complete = false
+				continue
+			default:
+				fmt.Fprintln(out, "Stopped")
+				return false, nil
+			}
+		}
+
+		if err := run(ctx, step); err != nil {
+			return false, fmt.Errorf("step %d failed: %w", i+1, err)
+		}
+	}
+	return complete, nil
+}
+
+// runShellStep runs a plan step in the user's shell, attached to the terminal
+func runShellStep(ctx context.Context, dir, command string) error {
+	shell := os.Getenv("SHELL")
+	if shell == "" {
+		shell = "sh"
+	}
+
+	cmd := exec.CommandContext(ctx, shell, "-c", command)
+	cmd.Dir = dir
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	return cmd.Run()
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/tchaudhry91/zist/llm"
+)
+
+func TestParsePlan(t *testing.T) {
+	tests := []struct {
+		name     string
+		response string
+		want     []string
+	}{
+		{
+			"plain",
+			"python3 -m venv .venv\n.venv/bin/pip install -r requirements.txt\n",
+			[]string{"python3 -m venv .venv", ".venv/bin/pip install -r requirements.txt"},
+		},
+		{
+			"numbered and fenced",
+			"```bash\n1. mkdir build\n2) cmake ..\n```",
+			[]string{"mkdir build", "cmake .."},
+		},
+		{
+			"comments and bullets",
+			"# create the dir\n- mkdir -p out\n\n* $ ls out",
+			[]string{"mkdir -p out", "$ ls out"},
+		},
+		{
+			"prompt marker",
+			"$ git init",
+			[]string{"git init"},
+		},
+		{
+			"capped",
+			strings.Repeat("echo hi\n", maxPlanSteps+5),
+			strings.Split(strings.TrimSpace(strings.Repeat("echo hi\n", maxPlanSteps)), "\n"),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := parsePlan(tt.response); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parsePlan() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPlanSnippetName(t *testing.T) {
+	tests := []struct {
+		query string
+		want  string
+	}{
+		{"Set up a Python venv!", "plan-set-up-a-python-venv"},
+		{"set up a python venv and install requirements", "plan-set-up-a-python-venv-and-install"},
+		{"  ", "plan"},
+	}
+	for _, tt := range tests {
+		if got := planSnippetName(tt.query); got != tt.want {
+			t.Errorf("planSnippetName(%q) = %q, want %q", tt.query, got, tt.want)
+		}
+	}
+}
+
+func TestRunPlan(t *testing.T) {
+	steps := []string{"mkdir out", "touch out/a", "ls out"}
+
+	tests := []struct {
+		name         string
+		input        string
+		fail         string
+		wantRan      []string
+		wantComplete bool
+		wantErr      bool
+	}{
+		{"run all", "a\n", "", steps, true, false},
+		{"step by step", "s\ny\n\nyes\n", "", steps, true, false},
+		{"skip a step", "s\ny\ns\ny\n", "", []string{"mkdir out", "ls out"}, false, false},
+		{"quit midway", "s\ny\nq\n", "", []string{"mkdir out"}, false, false},
+		{"cancel", "c\n", "", nil, false, false},
+		{"no input", "", "", nil, false, false},
+		{"failure stops", "a\n", "touch out/a", []string{"mkdir out", "touch out/a"}, false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var ran []string
+			run := func(ctx context.Context, command string) error {
+				ran = append(ran, command)
+				if command == tt.fail {
+					return errors.New("exit status 1")
+				}
+				return nil
+			}
+
+			var out strings.Builder
+			complete, err := RunPlan(context.Background(), steps, strings.NewReader(tt.input), &out, run)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("RunPlan() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if complete != tt.wantComplete {
+				t.Errorf("RunPlan() complete = %v, want %v", complete, tt.wantComplete)
+			}
+			if !reflect.DeepEqual(ran, tt.wantRan) {
+				t.Errorf("ran %q, want %q", ran, tt.wantRan)
+			}
+			if !strings.Contains(out.String(), "3. ls out") {
+				t.Errorf("plan not shown:\n%s", out.String())
+			}
+		})
+	}
+}
+
+func TestWizardPlan(t *testing.T) {
+	db, err := InitDB(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	mock := &llm.Mock{Reply: "1. python3 -m venv .venv\n2. .venv/bin/pip install -r requirements.txt"}
+	steps, err := NewWizard(db, mock).Plan(context.Background(), WizardRequest{
+		Query:    "set up a python venv and install requirements",
+		PWD:      "/home/user/app",
+		EnvHints: []string{"VIRTUAL_ENV=app"},
+	})
+	if err != nil {
+		t.Fatalf("Plan() error = %v", err)
+	}
+	want := []string{"python3 -m venv .venv", ".venv/bin/pip install -r requirements.txt"}
+	if !reflect.DeepEqual(steps, want) {
+		t.Errorf("Plan() = %q, want %q", steps, want)
+	}
+
+	prompt := mock.Prompts()[0]
+	for _, s := range []string{"set up a python venv", "/home/user/app", "VIRTUAL_ENV=app"} {
+		if !strings.Contains(prompt, s) {
+			t.Errorf("prompt missing %q:\n%s", s, prompt)
+		}
+	}
+
+	mock.Reply = "```\n```"
+	if _, err := NewWizard(db, mock).Plan(context.Background(), WizardRequest{Query: "nothing"}); err == nil {
+		t.Error("Plan() with empty reply should fail")
+	}
+}
@@ -0,0 +1,444 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/tchaudhry91/zist/llm"
+)
+
+func TestExtractKeywords(t *testing.T) {
+	tests := []struct {
+		query string
+		extra []string
+		want  []string
+	}{
+		{"show me the docker-compose logs", nil, []string{"docker-compose", "logs"}},
+		{"count lines in main.go.", nil, []string{"count", "lines", "main.go"}},
+		{"Größe des Ordners mit du", nil, []string{"größe", "des", "ordners", "mit", "du"}},
+		{"Größe des Ordners mit du", []string{"des", "Mit"}, []string{"größe", "ordners", "du"}},
+		{"répertoire nginx", nil, []string{"répertoire", "nginx"}},
+	}
+	defer func() { keywordStopwords = stopwordSet(DefaultKeywordStopwords) }()
+	for _, tt := range tests {
+		keywordStopwords = stopwordSet(DefaultKeywordStopwords, tt.extra)
+		if got := extractKeywords(tt.query); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("extractKeywords(%q) with %q = %q, want %q", tt.query, tt.extra, got, tt.want)
+		}
+	}
+}
+
+func TestListDirectory(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"main.py", "data.tar.gz", ".env", "README.md"} {
+		if err := os.WriteFile(filepath.Join(dir, name), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, name := range []string{"src", ".git"} {
+		if err := os.Mkdir(filepath.Join(dir, name), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name       string
+		maxEntries int
+		maxBytes   int
+		want       []string
+	}{
+		{"all", 50, 2000, []string{"README.md", "data.tar.gz", "main.py", "src/"}},
+		{"entry cap", 2, 2000, []string{"README.md", "data.tar.gz", "... (2 more)"}},
+		{"byte cap", 50, 22, []string{"README.md", "data.tar.gz", "... (2 more)"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := listDirectory(dir, tt.maxEntries, tt.maxBytes)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("listDirectory() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	if got := listDirectory(filepath.Join(dir, "missing"), 50, 2000); got != nil {
+		t.Errorf("listDirectory() of a missing dir = %q, want nil", got)
+	}
+}
+
+func TestWizardDirContext(t *testing.T) {
+	db, err := InitDB(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("InitDB() error = %v", err)
+	}
+	defer db.Close()
+
+	pwd := t.TempDir()
+	if err := os.WriteFile(filepath.Join(pwd, "backup.tar.gz"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, listDir := range []bool{true, false} {
+		t.Run(fmt.Sprintf("ListDir=%v", listDir), func(t *testing.T) {
+			recorder := &llm.Mock{Reply: "tar xzf backup.tar.gz"}
+			resp, err := NewWizard(db, recorder).Generate(context.Background(), WizardRequest{
+				Query:   "extract that tarball",
+				PWD:     pwd,
+				ListDir: listDir,
+			})
+			if err != nil {
+				t.Fatalf("Generate() error = %v", err)
+			}
+			if resp.Command != "tar xzf backup.tar.gz" {
+				t.Errorf("Generate() = %q", resp.Command)
+			}
+			if got := strings.Contains(recorder.Prompts()[0], "backup.tar.gz"); got != listDir {
+				t.Errorf("prompt mentions backup.tar.gz = %v, want %v:\n%s", got, listDir, recorder.Prompts()[0])
+			}
+		})
+	}
+}
+
+func TestWizardOffline(t *testing.T) {
+	ctx := context.Background()
+	db, err := InitDB(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("InitDB() error = %v", err)
+	}
+	defer db.Close()
+	if err := SetWizardCache(ctx, db, "", "list running containers", "docker ps"); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := InsertCommands(ctx, db, []Command{{Source: "/h", Timestamp: 1000, Command: "kubectl get pods -n staging"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		query   string
+		want    string
+		source  string
+		wantErr error
+	}{
+		{"list running containers", "docker ps", "cache", nil},
+		{"show the staging pods", "kubectl get pods -n staging", "history", nil},
+		{"compress this folder", "", "", ErrWizardOffline},
+	}
+	mock := &llm.Mock{Reply: "du -sh ."}
+	for _, tt := range tests {
+		resp, err := NewWizard(db, mock).Generate(ctx, WizardRequest{Query: tt.query, Offline: true})
+		if err != tt.wantErr {
+			t.Errorf("Generate(%q) offline error = %v, want %v", tt.query, err, tt.wantErr)
+			continue
+		}
+		if err == nil && (resp.Command != tt.want || resp.Source != tt.source) {
+			t.Errorf("Generate(%q) offline = %q from %s, want %q from %s", tt.query, resp.Command, resp.Source, tt.want, tt.source)
+		}
+	}
+
+	// The ghost text stays empty on a miss rather than showing an error
+	if got, err := GhostSuggestion(ctx, db, mock, nil, WizardRequest{Query: "compress this folder", Offline: true}); err != nil || got != "" {
+		t.Errorf("GhostSuggestion() offline = %q, %v, want nothing", got, err)
+	}
+	if len(mock.Prompts()) != 0 {
+		t.Errorf("LLM called %d times offline", len(mock.Prompts()))
+	}
+}
+
+func TestWriteWizardJSON(t *testing.T) {
+	resp := &WizardResponse{
+		Command:      "rm -rf build/ && echo \"done <ok>\"",
+		Source:       "llm",
+		Query:        "clean the build",
+		Latency:      1500 * time.Millisecond,
+		SafetyReport: AnalyzeCommand("rm -rf build/"),
+	}
+	var buf bytes.Buffer
+	if err := writeWizardJSON(&buf, resp); err != nil {
+		t.Fatalf("writeWizardJSON() error = %v", err)
+	}
+	line := buf.String()
+	if strings.Count(line, "\n") != 1 || !strings.Contains(line, `<ok>`) {
+		t.Errorf("writeWizardJSON() = %q, want one line with nothing escaped needlessly", line)
+	}
+	// The widget finds the warning by this exact text
+	if !strings.Contains(line, `"requires_confirmation":true,"danger_reasons":["deletes files and directories recursively"]`) {
+		t.Errorf("writeWizardJSON() = %s, missing the confirmation fields the widget matches", line)
+	}
+
+	var got struct {
+		WizardResponse
+		LatencyMs int64 `json:"latency_ms"`
+	}
+	if err := json.Unmarshal(buf.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Command != resp.Command || got.LatencyMs != 1500 || got.EstimatedEffect != EffectDestructive {
+		t.Errorf("writeWizardJSON() decoded to %+v", got)
+	}
+}
+
+func TestWizardEnvHintsInPrompt(t *testing.T) {
+	db, err := InitDB(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("InitDB() error = %v", err)
+	}
+	defer db.Close()
+
+	recorder := &llm.Mock{Reply: "aws s3 ls"}
+	_, err = NewWizard(db, recorder).Generate(context.Background(), WizardRequest{
+		Query:    "list buckets",
+		EnvHints: []string{"AWS_PROFILE=prod", "KUBECONFIG is set"},
+	})
+	if err != nil {
+		t.Fatalf("Generate() error = %v", err)
+	}
+	for _, want := range []string{"- AWS_PROFILE=prod\n", "- KUBECONFIG is set\n"} {
+		if !strings.Contains(recorder.Prompts()[0], want) {
+			t.Errorf("prompt is missing %q:\n%s", want, recorder.Prompts()[0])
+		}
+	}
+}
+
+func TestWizardExamples(t *testing.T) {
+	db, err := InitDB(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("InitDB() error = %v", err)
+	}
+	defer db.Close()
+
+	// Each SetWizardCache is one accepted run
+	cache := []struct {
+		project, query, command string
+		runs                    int
+	}{
+		{"", "list containers", "docker ps --format '{{.Names}}'", 3},
+		{"", "show disk usage", "duf", 2},
+		{"", "tail the logs", "journalctl -fu app", 1},
+		{"", "loop over files", "for f in *; do\n  echo $f\ndone", 4},
+		{"/srv/other", "run the tests", "make test", 5},
+		{"", "grep for todos", "rg TODO", 6},
+	}
+	for _, c := range cache {
+		for i := 0; i < c.runs; i++ {
+			if err := SetWizardCache(context.Background(), db, c.project, c.query, c.command); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	examples, err := GetWizardExamples(context.Background(), db, "", minExampleRuns, 10)
+	if err != nil {
+		t.Fatalf("GetWizardExamples() error = %v", err)
+	}
+	var got []string
+	for _, e := range examples {
+		got = append(got, e.QueryOriginal)
+	}
+	// Most run first; single runs, multi-line commands and other projects are left out
+	if want := []string{"grep for todos", "list containers", "show disk usage"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("GetWizardExamples() = %q, want %q", got, want)
+	}
+
+	tests := []struct {
+		name     string
+		query    string
+		examples int
+		want     []string
+		dontWant []string
+	}{
+		{"limited", "find big files", 2, []string{`User: "grep for todos"` + "\nOutput: rg TODO", `"list containers"`}, []string{"duf"}},
+		{"skips the query itself", "grep for TODOs", 1, []string{`"list containers"`}, []string{"rg TODO"}},
+		{"disabled", "find big files", 0, nil, []string{"EXAMPLES THIS USER ACCEPTED"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := NewWizard(db, nil)
+			system := w.buildSystemPrompt(w.gatherExamples(context.Background(), "", tt.query, tt.examples))
+			for _, want := range tt.want {
+				if !strings.Contains(system, want) {
+					t.Errorf("system prompt is missing %q:\n%s", want, system)
+				}
+			}
+			for _, dontWant := range tt.dontWant {
+				if strings.Contains(system, dontWant) {
+					t.Errorf("system prompt has %q:\n%s", dontWant, system)
+				}
+			}
+		})
+	}
+
+	recorder := &llm.Mock{Reply: "du -ah . | sort -h"}
+	if _, err := NewWizard(db, recorder).Generate(context.Background(), WizardRequest{Query: "find big files", Examples: 5}); err != nil {
+		t.Fatalf("Generate() error = %v", err)
+	}
+	if !strings.Contains(recorder.Systems()[0], "Output: duf") {
+		t.Errorf("Generate() system prompt has no examples:\n%s", recorder.Systems()[0])
+	}
+}
+
+// update rewrites the golden files in testdata/ with what the tests got:
+// go test -run Golden -update
+var update = flag.Bool("update", false, "update golden files")
+
+// checkGolden compares got with testdata/name.golden
+func checkGolden(t *testing.T, name, got string) {
+	t.Helper()
+	path := filepath.Join("testdata", name+".golden")
+	if *update {
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(got), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("failed to read golden file (run with -update to create it): %v", err)
+	}
+	if got != string(want) {
+		t.Errorf("%s differs from %s (run with -update if the change is intended):\n--- got\n%s\n--- want\n%s", name, path, got, want)
+	}
+}
+
+func TestGoldenPrompts(t *testing.T) {
+	w := NewWizard(nil, nil)
+	examples := []WizardCacheEntry{
+		{QueryOriginal: "list containers", Command: "docker ps --format '{{.Names}}'"},
+		{QueryOriginal: "show disk usage", Command: "duf"},
+	}
+	full := WizardRequest{
+		Query:    "compress the logs folder",
+		PWD:      "/srv/app",
+		EnvHints: []string{"AWS_PROFILE=prod", "VIRTUAL_ENV=.venv"},
+	}
+	history := []string{
+		"tar czf backup.tar.gz data/",
+		"tail -f logs/app.log",
+		"rsync -av --exclude=.git --exclude=node_modules ./ deploy@web1:/srv/app/ && ssh deploy@web1 systemctl restart app",
+	}
+
+	tests := []struct {
+		name string
+		got  string
+	}{
+		{"system", w.buildSystemPrompt(nil)},
+		{"system_examples", w.buildSystemPrompt(examples)},
+		{"plan_system", w.buildPlanSystemPrompt()},
+		{"user", w.buildUserPrompt(WizardRequest{Query: "show disk usage"}, nil, nil)},
+		{"user_context", w.buildUserPrompt(full, history, []string{"logs/", "main.go", "... (3 more)"})},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			checkGolden(t, filepath.Join("prompts", tt.name), tt.got)
+		})
+	}
+}
+
+func TestParseResponse(t *testing.T) {
+	tests := []struct {
+		name     string
+		response string
+		want     string
+	}{
+		{"plain", "ls -la", "ls -la"},
+		{"whitespace", "\n  df -h  \n", "df -h"},
+		{"bash fence", "```bash\ndu -sh .\n```", "du -sh ."},
+		{"sh fence", "```sh\ndu -sh .\n```", "du -sh ."},
+		{"bare fence", "```\ndu -sh .\n```", "du -sh ."},
+		{"prompt sign", "$ git status", "git status"},
+		{"root prompt", "# apt update", "apt update"},
+		{"explanation after", "ps aux | grep nginx\nThis lists nginx processes.", "ps aux | grep nginx"},
+		{"continued line", "docker run \\\n  -it alpine", "docker run \\\n  -it alpine"},
+		{"chained", "make &&\nmake install", "make &&\nmake install"},
+		{"piped", "cat log |\ngrep ERROR", "cat log |\ngrep ERROR"},
+		{"empty", "```\n```", ""},
+	}
+
+	// Through Generate too, so the cleaning is what users get back
+	db, err := InitDB(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("InitDB() error = %v", err)
+	}
+	defer db.Close()
+	w := NewWizard(db, &llm.Mock{Respond: func(messages []llm.Message) (string, error) {
+		for _, tt := range tests {
+			if strings.Contains(messages[len(messages)-1].Content, "\n"+tt.name+"\n") {
+				return tt.response, nil
+			}
+		}
+		return "", fmt.Errorf("no response for %q", messages)
+	}})
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := w.parseResponse(tt.response); got != tt.want {
+				t.Errorf("parseResponse(%q) = %q, want %q", tt.response, got, tt.want)
+			}
+			resp, err := w.Generate(context.Background(), WizardRequest{Query: tt.name})
+			if tt.want == "" {
+				if err == nil {
+					t.Errorf("Generate() = %q, want an error", resp.Command)
+				}
+				return
+			}
+			if err != nil || resp.Command != tt.want || resp.Source != "llm" {
+				t.Errorf("Generate() = %+v, %v, want %q from the LLM", resp, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestGoldenGenerate(t *testing.T) {
+	db, err := InitDB(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("InitDB() error = %v", err)
+	}
+	defer db.Close()
+
+	commands := []Command{
+		{Source: "/h", Timestamp: 1000, Command: "tar czf logs.tar.gz logs/"},
+		{Source: "/h", Timestamp: 1001, Command: "journalctl -u nginx"},
+		{Source: "/h", Timestamp: 1002, Command: "ls logs"},
+	}
+	if _, _, err := InsertCommands(context.Background(), db, commands); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < minExampleRuns; i++ {
+		if err := SetWizardCache(context.Background(), db, "", "show disk usage", "duf"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	mock := &llm.Mock{Reply: "```bash\ntar czf logs.tar.gz logs/\n```"}
+	resp, err := NewWizard(db, mock).Generate(context.Background(), WizardRequest{
+		Query:    "compress the logs",
+		PWD:      "/srv/app",
+		Examples: DefaultWizardExamples,
+	})
+	if err != nil {
+		t.Fatalf("Generate() error = %v", err)
+	}
+	if resp.Command != "tar czf logs.tar.gz logs/" {
+		t.Errorf("Generate() = %q", resp.Command)
+	}
+
+	calls := mock.Calls()
+	if len(calls) != 1 {
+		t.Fatalf("LLM called %d times, want 1", len(calls))
+	}
+	var sb strings.Builder
+	for _, msg := range calls[0] {
+		fmt.Fprintf(&sb, "=== %s\n%s\n", msg.Role, msg.Content)
+	}
+	checkGolden(t, filepath.Join("prompts", "generate"), sb.String())
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/tchaudhry91/zist/llm"
+)
+
+// warmPrompt is about the smallest request a model will answer
+const warmPrompt = "Reply with OK."
+
+// WarmLLM sends a tiny request so a local server loads the model into memory
+// before the first real one, and returns how long it took
+func WarmLLM(ctx context.Context, client llm.Client) (time.Duration, error) {
+	start := time.Now()
+	if _, err := client.Complete(ctx, warmPrompt, ""); err != nil {
+		return time.Since(start), fmt.Errorf("failed to warm up LLM: %w", err)
+	}
+	return time.Since(start), nil
+}
+
+// KeepWarm warms client now and then every interval until ctx is done, so
+// servers that unload idle models, like Ollama after five minutes, keep it
+// loaded. Only the first success and failures are logged.
+func KeepWarm(ctx context.Context, client llm.Client, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	logged := false
+	for {
+		took, err := WarmLLM(ctx, client)
+		switch {
+		case err != nil && ctx.Err() == nil:
+			log.Printf("%v", err)
+			logged = false
+		case err == nil && !logged:
+			log.Printf("Wizard model loaded in %s", took.Round(time.Millisecond))
+			logged = true
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/tchaudhry91/zist/llm"
+)
+
+func TestWarmLLM(t *testing.T) {
+	recorder := &llm.Mock{Reply: "OK"}
+	if _, err := WarmLLM(context.Background(), recorder); err != nil {
+		t.Fatalf("WarmLLM() error = %v", err)
+	}
+	if len(recorder.Prompts()) != 1 || recorder.Prompts()[0] != warmPrompt {
+		t.Errorf("prompts = %q, want one tiny prompt", recorder.Prompts())
+	}
+
+	if _, err := WarmLLM(context.Background(), &scriptedLLM{errs: []error{statusErr(503)}}); err == nil {
+		t.Error("WarmLLM() error = nil for a failing server")
+	}
+}
+
+func TestKeepWarm(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	client := &scriptedLLM{errs: []error{errors.New("connection refused")}}
+	done := make(chan struct{})
+	go func() {
+		KeepWarm(ctx, client, 10*time.Millisecond)
+		close(done)
+	}()
+
+	time.Sleep(55 * time.Millisecond)
+	cancel()
+	<-done
+	// Keeps trying after a failure
+	if client.calls < 2 {
+		t.Errorf("KeepWarm() made %d requests, want at least 2", client.calls)
+	}
+}
@@ -0,0 +1,321 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"database/sql"
+	"fmt"
+	"io"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/tchaudhry91/zist/llm"
+)
+
+// Defaults of zist report workflows
+const (
+	DefaultWorkflowMinCount  = 3  // Runs before something is worth a name
+	DefaultWorkflowMinLength = 40 // Bytes a single command needs to be worth an alias
+	DefaultWorkflowLimit     = 10
+	workflowMaxSteps         = 4   // Longest run of commands suggested as a function
+	workflowMinShare         = 0.3 // Share of its first command's runs a run must be, so merely common commands don't pair up
+	maxWorkflowName          = 8
+	maxWorkflowCommand       = 300 // Bytes of a command shown to the LLM
+)
+
+// Kinds of WorkflowCandidate
+const (
+	WorkflowAlias    = "alias"    // One long command
+	WorkflowFunction = "function" // Commands run one after another
+)
+
+// WorkflowOptions controls what MineWorkflows looks at and suggests
+type WorkflowOptions struct {
+	Since     float64 // Unix timestamp, 0 means no filter
+	Until     float64 // Unix timestamp, 0 means no filter
+	MinCount  int
+	MinLength int
+	Limit     int
+	Taken     func(name string) bool // Names not to suggest, e.g. commands on PATH
+}
+
+// WorkflowCandidate is a command or run of commands typed often enough to
+// deserve a name
+type WorkflowCandidate struct {
+	Kind        string   `json:"kind"`
+	Name        string   `json:"name"`
+	Commands    []string `json:"commands"`
+	Count       int      `json:"count"`
+	Saved       int      `json:"keystrokes_saved"` // Typing it all Count times, less typing the name
+	Description string   `json:"description,omitempty"`
+}
+
+// Definition is the alias or function to paste into .zshrc
+func (c WorkflowCandidate) Definition() string {
+	if c.Kind == WorkflowAlias {
+		return "alias " + c.Name + "=" + shellQuote(c.Commands[0])
+	}
+	return c.Name + "() {\n  " + strings.Join(c.Commands, " &&\n  ") + "\n}"
+}
+
+// estimate sets Saved for the current name
+func (c *WorkflowCandidate) estimate() {
+	typed := 0
+	for _, command := range c.Commands {
+		typed += len(command) + 1
+	}
+	c.Saved = c.Count * (typed - len(c.Name) - 1)
+}
+
+// WorkflowReport is what MineWorkflows found
+type WorkflowReport struct {
+	Commands   int                 `json:"commands"` // History rows looked at
+	Candidates []WorkflowCandidate `json:"candidates"`
+}
+
+// MineWorkflows looks for long commands typed again and again, and for runs
+// of up to workflowMaxSteps commands repeated within sessions that usually
+// follow their first command, and suggests
+// an alias or function for the ones that would save the most typing. A run
+// already inside a better function isn't suggested again.
+func MineWorkflows(ctx context.Context, db *sql.DB, opts WorkflowOptions) (*WorkflowReport, error) {
+	if opts.MinCount <= 0 {
+		opts.MinCount = DefaultWorkflowMinCount
+	}
+	if opts.MinLength <= 0 {
+		opts.MinLength = DefaultWorkflowMinLength
+	}
+	if opts.Limit <= 0 {
+		opts.Limit = DefaultWorkflowLimit
+	}
+
+	query := `SELECT source, timestamp, command FROM commands WHERE 1=1`
+	var args []interface{}
+	if opts.Since > 0 {
+		query += " AND timestamp >= ?"
+		args = append(args, opts.Since)
+	}
+	if opts.Until > 0 {
+		query += " AND timestamp <= ?"
+		args = append(args, opts.Until)
+	}
+	query += " ORDER BY source, timestamp, seq"
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read history: %w", err)
+	}
+	defer rows.Close()
+
+	report := &WorkflowReport{}
+	singles := make(map[string]int)
+	runs := make(map[string]int)   // Commands joined by NUL
+	starts := make(map[string]int) // Runs of each command, repeats in a row counted once
+	var window []string            // The session's latest commands, oldest first
+	var source string
+	var last float64
+	for rows.Next() {
+		var rowSource, command string
+		var timestamp float64
+		if err := rows.Scan(&rowSource, &timestamp, &command); err != nil {
+			return nil, fmt.Errorf("failed to scan command: %w", err)
+		}
+		report.Commands++
+		if rowSource != source || timestamp-last > timelineSessionGap.Seconds() {
+			window = window[:0]
+		}
+		source, last = rowSource, timestamp
+
+		// Multi-line commands make poor aliases and break up runs
+		if strings.Contains(command, "\n") {
+			window = window[:0]
+			continue
+		}
+		if len(command) >= opts.MinLength {
+			singles[command]++
+		}
+		if len(window) > 0 && window[len(window)-1] == command {
+			continue
+		}
+		window = append(window, command)
+		starts[command]++
+		if len(window) > workflowMaxSteps {
+			window = window[1:]
+		}
+		for n := 2; n <= len(window); n++ {
+			runs[strings.Join(window[len(window)-n:], "\x00")]++
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating history: %w", err)
+	}
+
+	var candidates []WorkflowCandidate
+	for command, count := range singles {
+		if count >= opts.MinCount {
+			candidates = append(candidates, WorkflowCandidate{Kind: WorkflowAlias, Commands: []string{command}, Count: count})
+		}
+	}
+	for run, count := range runs {
+		commands := strings.Split(run, "\x00")
+		if count >= opts.MinCount && float64(count) >= workflowMinShare*float64(starts[commands[0]]) {
+			candidates = append(candidates, WorkflowCandidate{Kind: WorkflowFunction, Commands: commands, Count: count})
+		}
+	}
+	for i := range candidates {
+		candidates[i].Name = workflowName(candidates[i].Commands)
+		candidates[i].estimate()
+	}
+	slices.SortFunc(candidates, func(a, b WorkflowCandidate) int {
+		return cmp.Or(cmp.Compare(b.Saved, a.Saved), slices.Compare(a.Commands, b.Commands))
+	})
+
+	taken := make(map[string]bool)
+	for _, c := range candidates {
+		if len(report.Candidates) == opts.Limit {
+			break
+		}
+		if c.Saved <= 0 || c.Kind == WorkflowFunction && slices.ContainsFunc(report.Candidates, func(chosen WorkflowCandidate) bool {
+			return chosen.Kind == WorkflowFunction && containsRun(chosen.Commands, c.Commands)
+		}) {
+			continue
+		}
+		c.Name = freeWorkflowName(c.Name, taken, opts.Taken)
+		taken[c.Name] = true
+		c.estimate()
+		report.Candidates = append(report.Candidates, c)
+	}
+	return report, nil
+}
+
+// containsRun reports whether run appears in commands, one after another
+func containsRun(commands, run []string) bool {
+	for i := 0; i+len(run) <= len(commands); i++ {
+		if slices.Equal(commands[i:i+len(run)], run) {
+			return true
+		}
+	}
+	return false
+}
+
+// workflowName makes a short name from the initials of commands: of every
+// word for one command ("kubectl get pods -n staging" is kgpns), of the
+// first two words of each for several ("git add -A", "git push" is gagp)
+func workflowName(commands []string) string {
+	var name []byte
+	for _, command := range commands {
+		words := strings.Fields(command)
+		if len(commands) > 1 && len(words) > 2 {
+			words = words[:2]
+		}
+		for _, w := range words {
+			w = strings.TrimLeft(strings.ToLower(w), "-")
+			if w != "" && (w[0] >= 'a' && w[0] <= 'z' || w[0] >= '0' && w[0] <= '9') {
+				name = append(name, w[0])
+			}
+		}
+	}
+	if len(name) == 0 || name[0] < 'a' {
+		name = append([]byte("w"), name...)
+	}
+	return string(name[:min(len(name), maxWorkflowName)])
+}
+
+// freeWorkflowName returns name, or name with the lowest number from 2
+// after it that is neither taken nor reported taken by isTaken
+func freeWorkflowName(name string, taken map[string]bool, isTaken func(string) bool) string {
+	free := func(n string) bool { return !taken[n] && (isTaken == nil || !isTaken(n)) }
+	if free(name) {
+		return name
+	}
+	for i := 2; ; i++ {
+		if n := name + strconv.Itoa(i); free(n) {
+			return n
+		}
+	}
+}
+
+// workflowSystemPrompt sets the LLM up to name suggested aliases
+const workflowSystemPrompt = `You name shell aliases and functions for commands a user types often.
+
+RULES:
+- Reply with one line per numbered suggestion: the number, a name, a colon, and what it does in a few words
+- Names are short (2-8 characters), lowercase, easy to remember and type, and use only a-z, 0-9, - and _
+- Don't reuse the name of a common command
+- No markdown, no code blocks, no other text
+
+EXAMPLE:
+1. kpods: list the pods in staging
+2. ship: stage, commit and push`
+
+// workflowNameLine matches a reply line like "2. ship: stage, commit and push"
+var workflowNameLine = regexp.MustCompile(`^\s*(\d+)[.)]?\s+([a-z][a-z0-9_-]{0,15})\s*[:—–-]\s*(.+)$`)
+
+// NameWorkflows asks the LLM for a memorable name and a description of
+// each candidate. Names that are taken, or clash with another candidate's,
+// keep the one made up from initials.
+func NameWorkflows(ctx context.Context, client llm.Client, candidates []WorkflowCandidate, isTaken func(string) bool) error {
+	if len(candidates) == 0 {
+		return nil
+	}
+	var sb strings.Builder
+	sb.WriteString("Name these suggestions:\n")
+	for i, c := range candidates {
+		fmt.Fprintf(&sb, "\n%d. %s run %d time(s):\n", i+1, c.Kind, c.Count)
+		for _, command := range c.Commands {
+			shown, _ := truncateCommand(command, maxWorkflowCommand)
+			fmt.Fprintf(&sb, "   %s\n", shown)
+		}
+	}
+
+	response, err := client.Complete(ctx, sb.String(), workflowSystemPrompt)
+	if err != nil {
+		return fmt.Errorf("LLM request failed: %w", err)
+	}
+
+	taken := make(map[string]bool)
+	for _, c := range candidates {
+		taken[c.Name] = true
+	}
+	for _, line := range strings.Split(response, "\n") {
+		m := workflowNameLine.FindStringSubmatch(line)
+		if m == nil {
+			continue
+		}
+		i, _ := strconv.Atoi(m[1])
+		if i < 1 || i > len(candidates) {
+			continue
+		}
+		c := &candidates[i-1]
+		c.Description = strings.TrimSpace(m[3])
+		if name := m[2]; name != c.Name && !taken[name] && (isTaken == nil || !isTaken(name)) {
+			delete(taken, c.Name)
+			taken[name] = true
+			c.Name = name
+			c.estimate()
+		}
+	}
+	return nil
+}
+
+// writeWorkflowsText prints the suggestions, each with the definition to
+// paste into .zshrc
+func writeWorkflowsText(w io.Writer, report *WorkflowReport) {
+	if len(report.Candidates) == 0 {
+		fmt.Fprintf(w, "Nothing in %d command(s) is typed often enough to suggest an alias for\n", report.Commands)
+		return
+	}
+	fmt.Fprintf(w, "Suggestions from %d command(s), most typing saved first:\n", report.Commands)
+	for i, c := range report.Candidates {
+		what := fmt.Sprintf("typed %d times", c.Count)
+		if c.Kind == WorkflowFunction {
+			what = fmt.Sprintf("%d commands run together %d times", len(c.Commands), c.Count)
+		}
+		fmt.Fprintf(w, "\n%d. %s %s (%s, ~%d keystrokes saved)\n", i+1, c.Kind, c.Name, what, c.Saved)
+		if c.Description != "" {
+			fmt.Fprintf(w, "   # %s\n", c.Description)
+		}
+		fmt.Fprintf(w, "   %s\n", strings.ReplaceAll(c.Definition(), "\n", "\n   "))
+	}
+}
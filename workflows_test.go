@@ -0,0 +1,118 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/tchaudhry91/zist/llm"
+)
+
+func TestWorkflowName(t *testing.T) {
+	tests := []struct {
+		commands []string
+		want     string
+	}{
+		{[]string{"kubectl get pods -n staging"}, "kgpns"},
+		{[]string{"git add -A", "git commit -v", "git push"}, "gagcgp"},
+		{[]string{"./build.sh --release --target linux/amd64 --verbose"}, "rtlv"},
+		{[]string{"2to3 -w src some more words here"}, "w2wssmwh"},
+		{[]string{"a b c d e f g h i j"}, "abcdefgh"},
+	}
+	for _, tt := range tests {
+		if got := workflowName(tt.commands); got != tt.want {
+			t.Errorf("workflowName(%q) = %q, want %q", tt.commands, got, tt.want)
+		}
+	}
+}
+
+func TestMineWorkflows(t *testing.T) {
+	ctx := context.Background()
+	db, err := InitDB(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("InitDB() error = %v", err)
+	}
+	defer db.Close()
+
+	long := "kubectl --context prod -n payments get pods -o wide"
+	var commands []Command
+	ts := 1000.0
+	add := func(source string, cmds ...string) {
+		for _, c := range cmds {
+			ts += 10
+			commands = append(commands, Command{Source: source, Timestamp: ts, Command: c})
+		}
+	}
+	for range 4 {
+		add("/laptop", "git add -A", "git commit -v", "git push origin main", "ls")
+		add("/laptop", long)
+		ts += 2 * timelineSessionGap.Seconds()
+	}
+	// Split by a long break, so no run
+	add("/server", "make build")
+	ts += 2 * timelineSessionGap.Seconds()
+	add("/server", "make deploy")
+	add("/server", "make build")
+	ts += 2 * timelineSessionGap.Seconds()
+	add("/server", "make deploy")
+	add("/server", "make build")
+	ts += 2 * timelineSessionGap.Seconds()
+	add("/server", "make deploy", "cat <<EOF\nx\nEOF", long)
+	if _, _, err := InsertCommands(ctx, db, commands); err != nil {
+		t.Fatal(err)
+	}
+
+	report, err := MineWorkflows(ctx, db, WorkflowOptions{Limit: 3, Taken: func(name string) bool { return name == "kcpnpgpo" }})
+	if err != nil {
+		t.Fatalf("MineWorkflows() error = %v", err)
+	}
+	if report.Commands != len(commands) {
+		t.Errorf("MineWorkflows() looked at %d command(s), want %d", report.Commands, len(commands))
+	}
+	want := []WorkflowCandidate{
+		{Kind: WorkflowFunction, Name: "gcgplkc", Commands: []string{"git commit -v", "git push origin main", "ls", long}, Count: 4},
+		{Kind: WorkflowAlias, Name: "kcpnpgpo2", Commands: []string{long}, Count: 5},
+		{Kind: WorkflowFunction, Name: "gagcgpl", Commands: []string{"git add -A", "git commit -v", "git push origin main", "ls"}, Count: 4},
+	}
+	if len(report.Candidates) != len(want) {
+		t.Fatalf("MineWorkflows() = %+v, want %+v", report.Candidates, want)
+	}
+	for i, w := range want {
+		got := report.Candidates[i]
+		if got.Kind != w.Kind || got.Name != w.Name || strings.Join(got.Commands, "|") != strings.Join(w.Commands, "|") || got.Count != w.Count {
+			t.Errorf("candidate %d = %+v, want %+v", i, got, w)
+		}
+	}
+	if c := report.Candidates[1]; c.Saved != 5*(len(long)+1-len("kcpnpgpo2")-1) {
+		t.Errorf("alias saves %d keystrokes", c.Saved)
+	}
+
+	mock := &llm.Mock{Reply: "Here you go:\n1. ship: commit, push and check pods\n2. ls: clashes\n3. ship: taken above\n9. nope: no such suggestion"}
+	if err := NameWorkflows(ctx, mock, report.Candidates, func(name string) bool { return name == "ls" }); err != nil {
+		t.Fatalf("NameWorkflows() error = %v", err)
+	}
+	names := []string{"ship", "kcpnpgpo2", "gagcgpl"}
+	descriptions := []string{"commit, push and check pods", "clashes", "taken above"}
+	for i, c := range report.Candidates {
+		if c.Name != names[i] || c.Description != descriptions[i] {
+			t.Errorf("named candidate %d = %s (%q), want %s (%q)", i, c.Name, c.Description, names[i], descriptions[i])
+		}
+	}
+	if c := report.Candidates[0]; c.Saved != 4*(len("git commit -v git push origin main ls "+long)+1-len("ship")-1) {
+		t.Errorf("renamed function saves %d keystrokes", c.Saved)
+	}
+
+	var buf bytes.Buffer
+	writeWorkflowsText(&buf, report)
+	for _, want := range []string{
+		"1. function ship (4 commands run together 4 times, ~",
+		"   # commit, push and check pods\n   ship() {\n     git commit -v &&\n     git push origin main &&\n     ls &&\n     " + long + "\n   }\n",
+		"   alias kcpnpgpo2='" + long + "'\n",
+	} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("writeWorkflowsText() missing %q in\n%s", want, buf.String())
+		}
+	}
+}
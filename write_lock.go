@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// ErrLocked is returned when another zist holds the write lock and the
+// caller didn't want to wait
+var ErrLocked = errors.New("another zist is writing to the database")
+
+// lockRetryInterval is how often a waiting AcquireWriteLock tries again
+const lockRetryInterval = 100 * time.Millisecond
+
+// A probe such as WriteLocked holds the lock for a moment, so even an
+// AcquireWriteLock not waiting tries this many times, this far apart,
+// before it gives up
+const (
+	lockProbeRetries       = 5
+	lockProbeRetryInterval = 2 * time.Millisecond
+)
+
+// WriteLock keeps zist commands that write to the same database in bulk
+// (collect, archive, fts rebuild) from interleaving. SQLite would serialise
+// their transactions anyway, but not their batches.
+type WriteLock struct {
+	f *os.File
+}
+
+// writeLockPath is the lock file next to the database
+func writeLockPath(dbPath string) string {
+	return expandTilde(dbPath) + ".lock"
+}
+
+// AcquireWriteLock locks dbPath for writing. If another zist holds the lock
+// it returns ErrLocked, or with wait calls waiting once and tries again
+// until it gets the lock or ctx is done.
+func AcquireWriteLock(ctx context.Context, dbPath string, wait bool, waiting func()) (*WriteLock, error) {
+	path := writeLockPath(dbPath)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create %s: %w", filepath.Dir(path), err)
+	}
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open write lock: %w", err)
+	}
+
+	locked := tryLock(f)
+	for i := 0; !locked && i < lockProbeRetries; i++ {
+		time.Sleep(lockProbeRetryInterval)
+		locked = tryLock(f)
+	}
+	if !locked {
+		if !wait {
+			f.Close()
+			return nil, ErrLocked
+		}
+		if waiting != nil {
+			waiting()
+		}
+		ticker := time.NewTicker(lockRetryInterval)
+		defer ticker.Stop()
+		for !tryLock(f) {
+			select {
+			case <-ctx.Done():
+				f.Close()
+				return nil, ctx.Err()
+			case <-ticker.C:
+			}
+		}
+	}
+	return &WriteLock{f: f}, nil
+}
+
+// WriteLocked reports whether another zist holds the write lock on dbPath,
+// creating nothing if no zist ever took it
+func WriteLocked(dbPath string) bool {
+	f, err := os.Open(writeLockPath(dbPath))
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	return lockHeld(f)
+}
+
+// lockHeld reports whether another process holds the lock on f. Finding it
+// free takes it, so it's released at once rather than when f is closed.
+func lockHeld(f *os.File) bool {
+	if !tryLock(f) {
+		return true
+	}
+	unlock(f)
+	return false
+}
+
+// Touch records that a collect started, for RecentlyCollected. Taking the
+// lock alone leaves the file's mtime alone.
+func (l *WriteLock) Touch() error {
+	now := time.Now()
+	if err := os.Chtimes(l.f.Name(), now, now); err != nil {
+		return fmt.Errorf("failed to update write lock: %w", err)
+	}
+	return nil
+}
+
+// Release lets the next writer in
+func (l *WriteLock) Release() error {
+	return l.f.Close()
+}
+
+// RecentlyCollected reports whether a collect into dbPath started less than
+// d ago
+func RecentlyCollected(dbPath string, d time.Duration) bool {
+	info, err := os.Stat(writeLockPath(dbPath))
+	return err == nil && time.Since(info.ModTime()) < d
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func TestAcquireWriteLock(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("no flock")
+	}
+	dbPath := filepath.Join(t.TempDir(), "test.db")
+	ctx := context.Background()
+
+	lock, err := AcquireWriteLock(ctx, dbPath, false, nil)
+	if err != nil {
+		t.Fatalf("AcquireWriteLock() error = %v", err)
+	}
+	if _, err := AcquireWriteLock(ctx, dbPath, false, nil); !errors.Is(err, ErrLocked) {
+		t.Errorf("AcquireWriteLock(no wait) while held error = %v, want ErrLocked", err)
+	}
+
+	timeout, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancel()
+	waited := false
+	if _, err := AcquireWriteLock(timeout, dbPath, true, func() { waited = true }); !errors.Is(err, context.DeadlineExceeded) || !waited {
+		t.Errorf("AcquireWriteLock(wait) while held = %v, waited %v, want a timeout after waiting", err, waited)
+	}
+
+	time.AfterFunc(50*time.Millisecond, func() { lock.Release() })
+	next, err := AcquireWriteLock(ctx, dbPath, true, nil)
+	if err != nil {
+		t.Fatalf("AcquireWriteLock(wait) after release error = %v", err)
+	}
+	next.Release()
+}
+
+func TestWriteLockedProbe(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("no flock")
+	}
+	dbPath := filepath.Join(t.TempDir(), "test.db")
+	ctx := context.Background()
+
+	lock, err := AcquireWriteLock(ctx, dbPath, false, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !WriteLocked(dbPath) {
+		t.Error("WriteLocked() = false while held")
+	}
+	lock.Release()
+
+	// A status checking over and over doesn't keep a collect from it
+	stop, probed := make(chan struct{}), make(chan struct{})
+	go func() {
+		defer close(probed)
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+				WriteLocked(dbPath)
+			}
+		}
+	}()
+	defer func() {
+		close(stop)
+		<-probed
+	}()
+	for deadline := time.Now().Add(300 * time.Millisecond); time.Now().Before(deadline); {
+		lock, err := AcquireWriteLock(ctx, dbPath, false, nil)
+		if err != nil {
+			t.Fatalf("AcquireWriteLock(no wait) during probes error = %v", err)
+		}
+		lock.Release()
+	}
+}
+
+func TestRecentlyCollected(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "test.db")
+	if RecentlyCollected(dbPath, time.Hour) {
+		t.Error("RecentlyCollected() = true before any collect")
+	}
+
+	lock, err := AcquireWriteLock(context.Background(), dbPath, false, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := lock.Touch(); err != nil {
+		t.Fatal(err)
+	}
+	lock.Release()
+	if !RecentlyCollected(dbPath, time.Hour) {
+		t.Error("RecentlyCollected() = false right after a collect")
+	}
+
+	old := time.Now().Add(-time.Minute)
+	if err := os.Chtimes(writeLockPath(dbPath), old, old); err != nil {
+		t.Fatal(err)
+	}
+	if RecentlyCollected(dbPath, time.Second) {
+		t.Error("RecentlyCollected() = true a minute after a collect")
+	}
+}